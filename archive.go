@@ -0,0 +1,130 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// ErrMsgNotFound is returned when we fail to find a previously sent message
+var ErrMsgNotFound = errors.New("message not found")
+
+// ErrResendURNInvalid is returned when a message can't be resent to the requested URN
+var ErrResendURNInvalid = errors.New("URN can't be used for resend")
+
+// ArchivedMsg is the full content of a previously sent message, as needed to send it again
+type ArchivedMsg struct {
+	UUID         MsgUUID         `json:"uuid"`
+	ChannelUUID  ChannelUUID     `json:"channel_uuid"`
+	URN          urns.URN        `json:"urn"`
+	Text         string          `json:"text"`
+	Attachments  []string        `json:"attachments"`
+	QuickReplies []string        `json:"quick_replies"`
+	Locale       i18n.Locale     `json:"locale,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	Status       MsgStatus       `json:"status"`
+	CreatedOn    time.Time       `json:"created_on"`
+	SentOn       *time.Time      `json:"sent_on"`
+}
+
+type archivedMsgResponse struct {
+	Msg *ArchivedMsg `json:"msg"`
+}
+
+// resendArchivedMsgRequest is the optional body of a resend request, used to send to a different URN, e.g.
+//
+//	{"urn": "tel:+250788123123"}
+type resendArchivedMsgRequest struct {
+	URN urns.URN `json:"urn"`
+}
+
+// gets the message UUID from the path of an admin request
+func adminMsgUUID(r *http.Request) (MsgUUID, error) {
+	uuid := chi.URLParam(r, "uuid")
+	if !uuids.Is(uuid) {
+		return NilMsgUUID, errors.New("invalid message UUID")
+	}
+	return MsgUUID(uuid), nil
+}
+
+func (s *server) handleGetArchivedMsg(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	msgUUID, err := adminMsgUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	msg, err := s.archive.GetArchivedMsg(ctx, msgUUID)
+	if err == ErrMsgNotFound {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		slog.Error("error reading archived msg", "error", err, "msg_uuid", msgUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error reading message"))
+		return
+	}
+
+	writeAdminResponse(w, &archivedMsgResponse{Msg: msg})
+}
+
+func (s *server) handleResendArchivedMsg(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	msgUUID, err := adminMsgUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	// an empty body means resend to the original URN
+	request := &resendArchivedMsgRequest{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, request); err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Errorf("error unmarshalling request: %w", err))
+			return
+		}
+		if request.URN != urns.NilURN {
+			if err := ValidateURN(request.URN); err != nil {
+				WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid URN: %w", err))
+				return
+			}
+		}
+	}
+
+	msg, err := s.archive.ResendArchivedMsg(ctx, msgUUID, request.URN)
+	if err == ErrMsgNotFound {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	} else if errors.Is(err, ErrResendURNInvalid) {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		slog.Error("error resending archived msg", "error", err, "msg_uuid", msgUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error resending message"))
+		return
+	}
+
+	slog.Info("resent archived msg", "msg_uuid", msgUUID, "channel_uuid", msg.ChannelUUID)
+
+	writeAdminResponse(w, &archivedMsgResponse{Msg: msg})
+}
@@ -3,6 +3,7 @@ package courier
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,6 +13,8 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/h2non/filetype"
 	"github.com/nyaruka/courier/utils"
@@ -19,9 +22,36 @@ import (
 	"github.com/nyaruka/gocommon/httpx"
 )
 
-const (
-	maxAttBodyReadBytes = 100 * 1024 * 1024
-)
+// AttachmentLimits are the limits applied to attachments that we fetch from channels
+type AttachmentLimits struct {
+	MaxSize      int
+	AllowedTypes []string // content types like image/jpeg or prefixes like image/*, empty means all are allowed
+	Timeout      time.Duration
+}
+
+// NewAttachmentLimits creates attachment limits from the given config
+func NewAttachmentLimits(cfg *Config) *AttachmentLimits {
+	return &AttachmentLimits{
+		MaxSize:      cfg.AttachmentMaxSize,
+		AllowedTypes: cfg.ParseAttachmentAllowedTypes(),
+		Timeout:      time.Duration(cfg.AttachmentFetchTimeout) * time.Second,
+	}
+}
+
+// Allows returns whether the given content type is allowed by these limits
+func (l *AttachmentLimits) Allows(contentType string) bool {
+	if len(l.AllowedTypes) == 0 {
+		return true
+	}
+
+	contentType = strings.ToLower(contentType)
+	for _, t := range l.AllowedTypes {
+		if t == contentType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
 
 type Attachment struct {
 	ContentType string `json:"content_type"`
@@ -29,19 +59,43 @@ type Attachment struct {
 	Size        int    `json:"size"`
 }
 
+// AttachmentInfectedError is returned by backends when they won't store an attachment because anti-virus scanning
+// found it to be infected
+type AttachmentInfectedError struct {
+	Signature string
+}
+
+func (e *AttachmentInfectedError) Error() string {
+	return fmt.Sprintf("attachment is infected with %s", e.Signature)
+}
+
+// an unavailable attachment tells the caller to continue without the attachment rather than retry
+func unavailableAttachment(attURL string) *Attachment {
+	return &Attachment{ContentType: "unavailable", URL: attURL}
+}
+
 type fetchAttachmentRequest struct {
 	ChannelType ChannelType `json:"channel_type" validate:"required"`
 	ChannelUUID ChannelUUID `json:"channel_uuid" validate:"required,uuid"`
-	URL         string      `json:"url"          validate:"required"`
+	URL         string      `json:"url"          validate:"required_without=URLs"`
+	URLs        []string    `json:"urls"         validate:"required_without=URL"`
 	MsgID       MsgID       `json:"msg_id"`
 }
 
-type fetchAttachmentResponse struct {
+type fetchedAttachment struct {
 	Attachment *Attachment   `json:"attachment"`
 	LogUUID    clogs.LogUUID `json:"log_uuid"`
 }
 
-func fetchAttachment(ctx context.Context, b Backend, r *http.Request) (*fetchAttachmentResponse, error) {
+type fetchAttachmentResponse struct {
+	Attachment  *Attachment          `json:"attachment,omitempty"`
+	LogUUID     clogs.LogUUID        `json:"log_uuid,omitempty"`
+	Attachments []*fetchedAttachment `json:"attachments,omitempty"`
+}
+
+// fetchAttachment handles a request to fetch a single attachment by its url, or several attachments of the same message
+// by their urls, which are fetched in parallel using up to the given number of workers
+func fetchAttachment(ctx context.Context, b Backend, limits *AttachmentLimits, workers int, r *http.Request) (*fetchAttachmentResponse, error) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading request body: %w", err)
@@ -60,9 +114,50 @@ func fetchAttachment(ctx context.Context, b Backend, r *http.Request) (*fetchAtt
 		return nil, fmt.Errorf("error getting channel: %w", err)
 	}
 
+	if len(fa.URLs) > 0 {
+		return &fetchAttachmentResponse{Attachments: fetchAttachments(ctx, b, ch, fa.URLs, limits, workers)}, nil
+	}
+
+	attachment, logUUID, err := fetchAndLogAttachment(ctx, b, ch, fa.URL, limits)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching attachment for msg #%d: %w", fa.MsgID, err)
+	}
+
+	return &fetchAttachmentResponse{Attachment: attachment, LogUUID: logUUID}, nil
+}
+
+// fetchAttachments fetches the given attachments in parallel. Each fetch gets its own channel log and an attachment
+// which can't be fetched is returned as unavailable so that the message keeps the attachments which could be.
+func fetchAttachments(ctx context.Context, b Backend, ch Channel, attURLs []string, limits *AttachmentLimits, workers int) []*fetchedAttachment {
+	fetched := make([]*fetchedAttachment, len(attURLs))
+	sem := make(chan struct{}, max(workers, 1))
+	wg := &sync.WaitGroup{}
+
+	for i, attURL := range attURLs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, attURL string) {
+			defer func() { <-sem; wg.Done() }()
+
+			attachment, logUUID, err := fetchAndLogAttachment(ctx, b, ch, attURL, limits)
+			if err != nil {
+				slog.Error("error fetching attachment", "error", err, "channel", ch.UUID(), "url", attURL)
+				attachment = unavailableAttachment(attURL)
+			}
+			fetched[i] = &fetchedAttachment{Attachment: attachment, LogUUID: logUUID}
+		}(i, attURL)
+	}
+	wg.Wait()
+
+	return fetched
+}
+
+// fetches and stores a single attachment, writing a channel log of the fetch
+func fetchAndLogAttachment(ctx context.Context, b Backend, ch Channel, attURL string, limits *AttachmentLimits) (*Attachment, clogs.LogUUID, error) {
 	clog := NewChannelLogForAttachmentFetch(ch, GetHandler(ch.ChannelType()).RedactValues(ch))
 
-	attachment, err := FetchAndStoreAttachment(ctx, b, ch, fa.URL, clog)
+	attachment, err := FetchAndStoreAttachment(ctx, b, ch, attURL, limits, clog)
 
 	// try to write channel log even if we have an error
 	clog.End()
@@ -70,14 +165,12 @@ func fetchAttachment(ctx context.Context, b Backend, r *http.Request) (*fetchAtt
 		slog.Error("error writing log", "error", err)
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error fetching attachment for msg #%d: %w", fa.MsgID, err)
-	}
-
-	return &fetchAttachmentResponse{Attachment: attachment, LogUUID: clog.UUID}, nil
+	return attachment, clog.UUID, err
 }
 
-func FetchAndStoreAttachment(ctx context.Context, b Backend, channel Channel, attURL string, clog *ChannelLog) (*Attachment, error) {
+// FetchAndStoreAttachment fetches the given attachment URL and saves it to storage. Attachments which can't be fetched
+// or which exceed the given limits are returned as unavailable, and an error is only returned for failures on our side.
+func FetchAndStoreAttachment(ctx context.Context, b Backend, channel Channel, attURL string, limits *AttachmentLimits, clog *ChannelLog) (*Attachment, error) {
 	parsedURL, err := url.Parse(attURL)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse attachment url '%s': %w", attURL, err)
@@ -96,14 +189,21 @@ func FetchAndStoreAttachment(ctx context.Context, b Backend, channel Channel, at
 		return nil, fmt.Errorf("unable to create attachment request: %w", err)
 	}
 
-	trace, err := httpx.DoTrace(b.HttpClient(true), attRequest, nil, b.HttpAccess(), maxAttBodyReadBytes)
+	fetchCtx, cancel := context.WithTimeout(attRequest.Context(), limits.Timeout)
+	defer cancel()
+
+	trace, err := httpx.DoTrace(b.HttpClient(true), attRequest.WithContext(fetchCtx), nil, b.HttpAccess(), limits.MaxSize)
 	if trace != nil {
 		clog.HTTP(trace)
 
+		if err == httpx.ErrResponseSize {
+			clog.Error(ErrorAttachmentTooLarge(limits.MaxSize))
+		}
+
 		// if we got a non-200 response, return the attachment with a pseudo content type which tells the caller
 		// to continue without the attachment
 		if trace.Response == nil || trace.Response.StatusCode/100 != 2 || err == httpx.ErrResponseSize || err == httpx.ErrAccessConfig {
-			return &Attachment{ContentType: "unavailable", URL: attURL}, nil
+			return unavailableAttachment(attURL), nil
 		}
 	}
 	if err != nil {
@@ -112,8 +212,18 @@ func FetchAndStoreAttachment(ctx context.Context, b Backend, channel Channel, at
 
 	mimeType, extension := getAttachmentType(trace)
 
+	if !limits.Allows(mimeType) {
+		clog.Error(ErrorAttachmentTypeNotAllowed(mimeType))
+		return unavailableAttachment(attURL), nil
+	}
+
 	storageURL, err := b.SaveAttachment(ctx, channel, mimeType, trace.ResponseBody, extension)
 	if err != nil {
+		var infected *AttachmentInfectedError
+		if errors.As(err, &infected) {
+			clog.Error(ErrorAttachmentInfected(infected.Signature))
+			return unavailableAttachment(attURL), nil
+		}
 		return nil, err
 	}
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
@@ -21,12 +22,16 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
 		"http://mock.com/media/hello.jpg": {
 			httpx.NewMockResponse(200, nil, testJPG),
+			httpx.NewMockResponse(200, nil, testJPG),
+			httpx.NewMockResponse(200, nil, testJPG),
 		},
 		"http://mock.com/media/hello2": {
 			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/jpeg"}, testJPG),
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/jpeg"}, testJPG),
 		},
 		"http://mock.com/media/hello3": {
 			httpx.NewMockResponse(200, map[string]string{"Content-Type": "application/octet-stream"}, testJPG),
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "application/octet-stream"}, testJPG),
 		},
 		"http://mock.com/media/hello.mp3": {
 			httpx.NewMockResponse(502, nil, []byte(`My gateways!`)),
@@ -51,9 +56,10 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
 	mb.AddChannel(mockChannel)
 
+	limits := courier.NewAttachmentLimits(courier.NewDefaultConfig())
 	clog := courier.NewChannelLogForAttachmentFetch(mockChannel, []string{"sesame"})
 
-	att, err := courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.jpg", clog)
+	att, err := courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.jpg", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, "image/jpeg", att.ContentType)
 	assert.Equal(t, "https://backend.com/attachments/cdf7ed27-5ad5-4028-b664-880fc7581c77.jpg", att.URL)
@@ -64,7 +70,7 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	assert.Len(t, clog.HttpLogs, 1)
 	assert.Equal(t, "http://mock.com/media/hello.jpg", clog.HttpLogs[0].URL)
 
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello2", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello2", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, "image/jpeg", att.ContentType)
 	assert.Equal(t, "https://backend.com/attachments/547deaf7-7620-4434-95b3-58675999c4b7.jpg", att.URL)
@@ -76,7 +82,7 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	assert.Equal(t, "http://mock.com/media/hello2", clog.HttpLogs[1].URL)
 
 	// a non-200 response should return an unavailable attachment
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.mp3", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.mp3", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, &courier.Attachment{ContentType: "unavailable", URL: "http://mock.com/media/hello.mp3"}, att)
 
@@ -86,17 +92,17 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	assert.Len(t, mb.SavedAttachments(), 2)
 
 	// same for a connection error
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.pdf", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.pdf", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, &courier.Attachment{ContentType: "unavailable", URL: "http://mock.com/media/hello.pdf"}, att)
 
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello3", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello3", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, "image/jpeg", att.ContentType)
 	assert.Equal(t, "https://backend.com/attachments/338ff339-5663-49ed-8ef6-384876655d1b.jpg", att.URL)
 	assert.Equal(t, 17301, att.Size)
 
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello7", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello7", limits, clog)
 	assert.NoError(t, err)
 	assert.Equal(t, "application/octet-stream", att.ContentType)
 	assert.Equal(t, "https://backend.com/attachments/9b955e36-ac16-4c6b-8ab6-9b9af5cd042a.", att.URL)
@@ -105,7 +111,59 @@ func TestFetchAndStoreAttachment(t *testing.T) {
 	// an actual error on our part should be returned as an error
 	mb.SetStorageError(errors.New("boom"))
 
-	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.txt", clog)
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.txt", limits, clog)
 	assert.EqualError(t, err, "boom")
 	assert.Nil(t, att)
+
+	// but an attachment the backend won't store because it's infected is unavailable
+	mb.SetStorageError(&courier.AttachmentInfectedError{Signature: "Eicar-Signature"})
+	clog = courier.NewChannelLogForAttachmentFetch(mockChannel, []string{"sesame"})
+
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.jpg", limits, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, &courier.Attachment{ContentType: "unavailable", URL: "http://mock.com/media/hello.jpg"}, att)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorAttachmentInfected("Eicar-Signature")}, clog.Errors)
+
+	mb.SetStorageError(nil)
+
+	// attachments larger than the max size are unavailable
+	clog = courier.NewChannelLogForAttachmentFetch(mockChannel, []string{"sesame"})
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello.jpg", &courier.AttachmentLimits{MaxSize: 1000, Timeout: time.Second}, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, &courier.Attachment{ContentType: "unavailable", URL: "http://mock.com/media/hello.jpg"}, att)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorAttachmentTooLarge(1000)}, clog.Errors)
+	assert.Len(t, mb.SavedAttachments(), 4)
+
+	// as are attachments whose type isn't allowed
+	clog = courier.NewChannelLogForAttachmentFetch(mockChannel, []string{"sesame"})
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello2", &courier.AttachmentLimits{MaxSize: 1000000, AllowedTypes: []string{"audio/*", "image/png"}, Timeout: time.Second}, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, &courier.Attachment{ContentType: "unavailable", URL: "http://mock.com/media/hello2"}, att)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorAttachmentTypeNotAllowed("image/jpeg")}, clog.Errors)
+	assert.Len(t, mb.SavedAttachments(), 4)
+
+	clog = courier.NewChannelLogForAttachmentFetch(mockChannel, []string{"sesame"})
+	att, err = courier.FetchAndStoreAttachment(ctx, mb, mockChannel, "http://mock.com/media/hello3", &courier.AttachmentLimits{MaxSize: 1000000, AllowedTypes: []string{"image/*"}, Timeout: time.Second}, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", att.ContentType)
+	assert.Len(t, clog.Errors, 0)
+	assert.Len(t, mb.SavedAttachments(), 5)
+}
+
+func TestAttachmentLimits(t *testing.T) {
+	cfg := courier.NewDefaultConfig()
+	cfg.AttachmentAllowedTypes = " Image/*, audio/mpeg,,"
+	cfg.AttachmentFetchTimeout = 10
+
+	limits := courier.NewAttachmentLimits(cfg)
+	assert.Equal(t, &courier.AttachmentLimits{MaxSize: 100 * 1024 * 1024, AllowedTypes: []string{"image/*", "audio/mpeg"}, Timeout: 10 * time.Second}, limits)
+
+	assert.True(t, limits.Allows("image/jpeg"))
+	assert.True(t, limits.Allows("IMAGE/PNG"))
+	assert.True(t, limits.Allows("audio/mpeg"))
+	assert.False(t, limits.Allows("audio/ogg"))
+	assert.False(t, limits.Allows("video/mp4"))
+	assert.False(t, limits.Allows("imagex/png"))
+
+	assert.True(t, (&courier.AttachmentLimits{}).Allows("video/mp4"))
 }
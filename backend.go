@@ -2,11 +2,15 @@ package courier
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/nyaruka/courier/utils/publish"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -22,6 +26,10 @@ type Backend interface {
 	// Stop stops any backend processes
 	Stop() error
 
+	// Flush writes anything the backend is buffering, such as status updates, blocking until it has been written or the
+	// context is done. An error is returned if anything couldn't be written.
+	Flush(context.Context) error
+
 	// Cleanup closes any active connections to databases
 	Cleanup() error
 
@@ -79,7 +87,8 @@ type Backend interface {
 	// a message is being forced in being resent by a user
 	ClearMsgSent(context.Context, MsgID) error
 
-	// OnSendComplete is called when the sender has finished trying to send a message
+	// OnSendComplete is called when the sender has finished trying to send a message, and is where backends which keep
+	// dead letters should set aside any messages which won't be retried again
 	OnSendComplete(context.Context, MsgOut, StatusUpdate, *ChannelLog)
 
 	// OnReceiveComplete is called when the server has finished handling an incoming request
@@ -102,7 +111,142 @@ type Backend interface {
 	Status() string
 
 	// RedisPool returns the redisPool for this backend
-	RedisPool() *redis.Pool
+	RedisPool() valkey.Pool
+}
+
+// ChannelLister is the interface backends which can list all of their active channels of a type implement, which is
+// needed to poll for the quality of channels
+type ChannelLister interface {
+	GetChannelsByType(context.Context, ChannelType) ([]Channel, error)
+}
+
+// ChannelConfigUpdater is the interface backends which can persist changes to the config of a channel implement, e.g.
+// refreshed access tokens. ErrChannelConfigConflict is returned if the config has changed since the channel was loaded.
+type ChannelConfigUpdater interface {
+	UpdateChannelConfig(context.Context, Channel, map[string]any) error
+}
+
+// QualityWriter is the interface backends which can record the quality state of channels implement
+type QualityWriter interface {
+	WriteChannelQuality(context.Context, Channel, *ChannelQuality) error
+}
+
+// QueuePauser is the interface backends which can pause the sending of messages by a channel implement, e.g. when its
+// account has run out of credit
+type QueuePauser interface {
+	PauseQueue(context.Context, ChannelUUID, bool) error
+}
+
+// QueueManager is the interface backends whose outgoing queues can be inspected and managed through the admin API
+// implement
+type QueueManager interface {
+	QueuePauser
+
+	// Queues returns the state of the outgoing queues of all channels which have pending messages or have been paused
+	Queues(context.Context) ([]*QueueInfo, error)
+
+	// PeekQueue returns up to the given number of pending messages for the given channel without removing them
+	PeekQueue(context.Context, ChannelUUID, int) ([]json.RawMessage, error)
+
+	// PurgeQueue removes all pending messages for the given channel, returning how many queued items were removed
+	PurgeQueue(context.Context, ChannelUUID) (int, error)
+
+	// DrainQueue moves all pending messages for the first channel onto the queue of the second, returning how many
+	// queued items were moved. ErrChannelNotFound is returned if the second channel can't be sent to from the first.
+	DrainQueue(context.Context, ChannelUUID, ChannelUUID) (int, error)
+}
+
+// MsgQueuer is the interface backends which can queue new outgoing messages themselves, in the same way as mailroom
+// does, implement. QueueMsg returns the ID the message was given.
+type MsgQueuer interface {
+	QueueMsg(context.Context, Channel, *OutgoingMsg) (MsgID, error)
+}
+
+// BulkMsgPopper is the interface backends which can pop more messages for the same channel as a popped message
+// implement, so that they can be sent together by handlers which implement BulkSender. Callers should call
+// OnSendComplete for each message.
+type BulkMsgPopper interface {
+	PopMoreOutgoingMsgs(context.Context, MsgOut, int) ([]MsgOut, error)
+}
+
+// MsgRequeuer is the interface backends which can put outgoing messages back on a queue implement. The sender calls
+// these before OnSendComplete, to retry a message after a delay asked for by its handler, or to move a message which
+// keeps erroring onto the queue of the fallback channel of its channel. FailoverMsg returns an error if the fallback
+// channel can't send to the contact of the message.
+type MsgRequeuer interface {
+	RequeueMsg(context.Context, MsgOut, time.Duration) error
+	FailoverMsg(context.Context, MsgOut, Channel) error
+}
+
+// SentMsgRequeuer is the interface backends which can put a message that was already sent back on the outgoing queue of
+// its channel implement, e.g. when the provider later reports that it was rejected for lack of credit
+type SentMsgRequeuer interface {
+	RequeueSentMsg(context.Context, Channel, MsgID) error
+}
+
+// DeadLetterStore is the interface backends which set aside permanently failed messages implement
+type DeadLetterStore interface {
+	// DeadLetters returns the permanently failed messages which have been set aside for the given channel
+	DeadLetters(context.Context, ChannelUUID) ([]*DeadLetter, error)
+
+	// RequeueDeadLetters puts the permanently failed messages for the given channel back on its outgoing queue,
+	// returning how many were requeued
+	RequeueDeadLetters(context.Context, ChannelUUID) (int, error)
+}
+
+// ChannelLogSearcher is the interface backends which can search the recent logs of a channel implement
+type ChannelLogSearcher interface {
+	RecentChannelLogs(context.Context, ChannelUUID, *ChannelLogQuery) ([]*RecentChannelLog, error)
+}
+
+// MsgArchive is the interface backends which keep the content of sent messages implement
+type MsgArchive interface {
+	// GetArchivedMsg returns the full content of the previously sent message with the given UUID, or ErrMsgNotFound
+	GetArchivedMsg(context.Context, MsgUUID) (*ArchivedMsg, error)
+
+	// ResendArchivedMsg puts the previously sent message with the given UUID back on the outgoing queue of its channel,
+	// optionally to a different URN of the same workspace, returning the message as it was requeued
+	ResendArchivedMsg(context.Context, MsgUUID, urns.URN) (*ArchivedMsg, error)
+
+	// MsgTimeline returns the delivery timeline of the outgoing message with the given UUID, or ErrMsgNotFound
+	MsgTimeline(context.Context, MsgUUID) (*MsgTimeline, error)
+}
+
+// MediaProcessor is the interface backends which can convert media for channels which don't support it as is implement
+type MediaProcessor interface {
+	// TranscodeMedia converts the given audio media to the given content type, returning nil if that's not possible
+	TranscodeMedia(context.Context, Channel, Media, string) (Media, error)
+
+	// ResizeImage scales down and compresses the given image media to fit within the given max width, height and bytes
+	ResizeImage(context.Context, Channel, Media, int, int, int) (Media, error)
+}
+
+// HealthChecker is the interface backends which can check each of their dependencies, such as their database and
+// storage, implement
+type HealthChecker interface {
+	CheckHealth(context.Context) []*HealthCheck
+}
+
+// BackendWrapper is the interface backends which wrap another backend implement, so that the optional interfaces of
+// the wrapped backend can still be found
+type BackendWrapper interface {
+	Unwrap() Backend
+}
+
+// BackendAs returns the given backend, or the first backend it wraps, which implements T
+func BackendAs[T any](b Backend) (T, bool) {
+	for {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+
+		w, ok := b.(BackendWrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		b = w.Unwrap()
+	}
 }
 
 // Media is a resolved media object that can be used as a message attachment
@@ -117,13 +261,37 @@ type Media interface {
 	Alternates() []Media
 }
 
-// NewBackend creates the type of backend passed in
+// NewBackend creates the type of backend passed in, wrapped to also write to the secondary backend, the status feeds
+// of the gRPC API and the event stream if those are configured
 func NewBackend(config *Config) (Backend, error) {
 	backendFunc, found := registeredBackends[strings.ToLower(config.Backend)]
 	if !found {
 		return nil, fmt.Errorf("no such backend type: '%s'", config.Backend)
 	}
-	return backendFunc(config), nil
+	backend := backendFunc(config)
+
+	if config.SecondaryBackend != "" {
+		secondaryFunc, found := registeredBackends[strings.ToLower(config.SecondaryBackend)]
+		if !found {
+			return nil, fmt.Errorf("no such backend type: '%s'", config.SecondaryBackend)
+		}
+		backend = NewFanoutBackend(backend, secondaryFunc(config))
+	}
+
+	// statuses are only followed by clients of the gRPC API
+	if config.GRPCAddress != "" {
+		backend = NewStatusFeedBackend(backend)
+	}
+
+	if config.StreamURL != "" {
+		publisher, err := publish.New(config.StreamURL)
+		if err != nil {
+			return nil, fmt.Errorf("error creating event stream publisher: %w", err)
+		}
+		backend = NewStreamBackend(backend, publisher, config, clock.Real)
+	}
+
+	return backend, nil
 }
 
 // RegisterBackend adds a new backend, called by individual backends in their init() func
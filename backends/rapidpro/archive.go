@@ -0,0 +1,213 @@
+package rapidpro
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/null/v3"
+)
+
+// TPS used for resent and API queued messages when their channel doesn't currently have a queue to tell us its TPS
+const defaultQueueTPS = 10
+
+// archivedMsg is a previously sent message read from the database along with the channel and URN it was sent with
+type archivedMsg struct {
+	Msg
+
+	ChannelUUID   courier.ChannelUUID `db:"channel_uuid"`
+	URNIdentity   null.String         `db:"urn_identity"`
+	URNAuthTokens null.Map[string]    `db:"urn_auth_tokens"`
+}
+
+func (m *archivedMsg) toArchived() *courier.ArchivedMsg {
+	var metadata json.RawMessage
+	if !m.Metadata_.IsNull() {
+		metadata = json.RawMessage(m.Metadata_)
+	}
+
+	return &courier.ArchivedMsg{
+		UUID:         m.UUID_,
+		ChannelUUID:  m.ChannelUUID,
+		URN:          m.URN_,
+		Text:         m.Text_,
+		Attachments:  []string(m.Attachments_),
+		QuickReplies: []string(m.QuickReplies_),
+		Locale:       m.Locale(),
+		Metadata:     metadata,
+		Status:       m.Status_,
+		CreatedOn:    m.CreatedOn_,
+		SentOn:       m.SentOn_,
+	}
+}
+
+const sqlSelectArchivedMsgs = `
+SELECT
+	m.id,
+	m.uuid,
+	m.org_id,
+	m.direction,
+	m.status,
+	m.visibility,
+	m.high_priority,
+	m.text,
+	m.attachments,
+	m.quick_replies,
+	m.locale,
+	m.metadata,
+	m.external_id,
+	m.channel_id,
+	m.contact_id,
+	m.contact_urn_id,
+	m.msg_count,
+	m.error_count,
+	m.failed_reason,
+	m.next_attempt,
+	m.created_on,
+	m.modified_on,
+	m.sent_on,
+	m.log_uuids,
+	c.uuid AS channel_uuid,
+	u.identity AS urn_identity,
+	u.auth_tokens AS urn_auth_tokens
+  FROM msgs_msg m
+  JOIN channels_channel c ON c.id = m.channel_id
+  LEFT JOIN contacts_contacturn u ON u.id = m.contact_urn_id`
+
+const sqlSelectArchivedMsgByUUID = sqlSelectArchivedMsgs + `
+ WHERE m.uuid = $1 AND m.direction = 'O'`
+
+const sqlSelectArchivedMsgByID = sqlSelectArchivedMsgs + `
+ WHERE m.id = $1 AND c.uuid = $2 AND m.direction = 'O'`
+
+// reads the outgoing message with the given UUID from the database
+func (b *backend) readArchivedMsg(ctx context.Context, uuid courier.MsgUUID) (*archivedMsg, error) {
+	return b.selectArchivedMsg(ctx, sqlSelectArchivedMsgByUUID, uuid)
+}
+
+func (b *backend) selectArchivedMsg(ctx context.Context, query string, args ...any) (*archivedMsg, error) {
+	m := &archivedMsg{}
+	err := b.db.GetContext(ctx, m, query, args...)
+	if err == sql.ErrNoRows {
+		return nil, courier.ErrMsgNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading archived msg: %w", err)
+	}
+
+	m.ChannelUUID_ = m.ChannelUUID
+	m.URN_ = urns.URN(m.URNIdentity)
+	m.URNAuthTokens_ = m.URNAuthTokens
+	return m, nil
+}
+
+// GetArchivedMsg returns the full content of the previously sent message with the given UUID
+func (b *backend) GetArchivedMsg(ctx context.Context, uuid courier.MsgUUID) (*courier.ArchivedMsg, error) {
+	m, err := b.readArchivedMsg(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return m.toArchived(), nil
+}
+
+// ResendArchivedMsg puts the previously sent message with the given UUID back on the outgoing queue of its channel. If
+// a URN is given, it must already exist in the workspace and the message will be sent to that instead.
+func (b *backend) ResendArchivedMsg(ctx context.Context, uuid courier.MsgUUID, urn urns.URN) (*courier.ArchivedMsg, error) {
+	m, err := b.readArchivedMsg(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := b.GetChannel(ctx, courier.AnyChannelType, m.ChannelUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading channel for archived msg: %w", err)
+	}
+
+	if urn != urns.NilURN && urn.Identity() != m.URN_.Identity() {
+		if !slices.Contains(ch.Schemes(), urn.Scheme()) {
+			return nil, fmt.Errorf("%w: not supported by channel", courier.ErrResendURNInvalid)
+		}
+
+		contactURN := &ContactURN{}
+		err := b.db.GetContext(ctx, contactURN, sqlSelectURNByIdentity, m.OrgID_, urn.Identity())
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: not found in workspace", courier.ErrResendURNInvalid)
+		} else if err != nil {
+			return nil, fmt.Errorf("error looking up URN for resend: %w", err)
+		}
+
+		m.URN_ = urns.URN(contactURN.Identity)
+		m.URNAuthTokens_ = contactURN.AuthTokens
+		m.ContactID_ = contactURN.ContactID
+		m.ContactURNID_ = contactURN.ID
+	}
+
+	if err := b.queueArchivedMsg(m); err != nil {
+		return nil, err
+	}
+
+	return m.toArchived(), nil
+}
+
+// RequeueSentMsg puts the message with the given ID which was already sent by the given channel back on its queue, e.g.
+// when the provider later rejects it for lack of credit
+func (b *backend) RequeueSentMsg(ctx context.Context, ch courier.Channel, id courier.MsgID) error {
+	m, err := b.selectArchivedMsg(ctx, sqlSelectArchivedMsgByID, id, ch.UUID())
+	if err != nil {
+		return err
+	}
+
+	return b.queueArchivedMsg(m)
+}
+
+// pushes the given archived message onto the outgoing queue of its channel to be sent again
+func (b *backend) queueArchivedMsg(m *archivedMsg) error {
+	// flag as a resend so that the sender clears our record of it having been sent already
+	m.IsResend_ = true
+	m.ErrorCount_ = 0
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	tps, err := b.channelQueueTPS(rc, m.ChannelUUID)
+	if err != nil {
+		return err
+	}
+
+	priority := queue.LowPriority
+	if m.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]*Msg{&m.Msg})
+
+	if err := queue.PushOntoQueue(rc, msgQueueName, string(m.ChannelUUID), tps, string(value), queue.Priority(priority)); err != nil {
+		return fmt.Errorf("error queuing archived msg: %w", err)
+	}
+	return nil
+}
+
+// returns the TPS of the current queue of the given channel, or the default if it doesn't have one
+func (b *backend) channelQueueTPS(rc redis.Conn, uuid courier.ChannelUUID) (int, error) {
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(names) > 0 {
+		_, t, _ := strings.Cut(names[len(names)-1], "|")
+		if v, err := strconv.Atoi(t); err == nil {
+			return v, nil
+		}
+	}
+	return defaultQueueTPS, nil
+}
@@ -0,0 +1,107 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	filetype "github.com/h2non/filetype"
+	"github.com/nyaruka/courier"
+)
+
+// max number of embedded attachments of a single message that we save to storage at the same time
+var maxAttachmentWorkers = 4
+
+var errAttachmentNotDecodable = errors.New("unable to decode attachment data")
+
+// resolveAttachments saves any embedded data: attachments of the given message to storage, concurrently if there are
+// several. Fetching of other URLs can be deferred until message handling and is performed by calling the
+// /c/_fetch-attachment endpoint. Attachments which can't be resolved are logged and dropped so that the rest of the
+// message is still written, and an error is only returned if that leaves the message with no attachments at all.
+func resolveAttachments(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) error {
+	embedded := make([]int, 0, len(m.Attachments_))
+	for i, attURL := range m.Attachments_ {
+		if strings.HasPrefix(attURL, "data:") {
+			embedded = append(embedded, i)
+		}
+	}
+	if len(embedded) == 0 {
+		return nil
+	}
+
+	resolved := make([]string, len(m.Attachments_))
+	errs := make([]error, len(m.Attachments_))
+	sem := make(chan struct{}, maxAttachmentWorkers)
+	wg := &sync.WaitGroup{}
+
+	for _, i := range embedded {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+
+			resolved[i], errs[i] = resolveEmbeddedAttachment(ctx, b, m.Channel(), m.Attachments_[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// channel logs aren't safe for concurrent use so errors are logged once everything is done, in attachment order
+	attachments := make([]string, 0, len(m.Attachments_))
+	failures := make([]error, 0, len(embedded))
+
+	for i, attURL := range m.Attachments_ {
+		if err := errs[i]; err != nil {
+			var infected *courier.AttachmentInfectedError
+			if errors.Is(err, errAttachmentNotDecodable) {
+				clog.Error(courier.ErrorAttachmentNotDecodable())
+			} else if errors.As(err, &infected) {
+				clog.Error(courier.ErrorAttachmentInfected(infected.Signature))
+			} else {
+				slog.Error("error saving embedded attachment", "error", err, "msg", m.UUID(), "index", i)
+			}
+			failures = append(failures, err)
+			continue
+		}
+
+		if resolved[i] != "" {
+			attURL = resolved[i]
+		}
+		attachments = append(attachments, attURL)
+	}
+
+	if len(attachments) == 0 {
+		return errors.Join(failures...)
+	}
+
+	m.Attachments_ = attachments
+	return nil
+}
+
+// resolveEmbeddedAttachment decodes the given data: attachment and saves it to storage, returning its new URL
+func resolveEmbeddedAttachment(ctx context.Context, b *backend, channel courier.Channel, attURL string) (string, error) {
+	attData, err := base64.StdEncoding.DecodeString(attURL[5:])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errAttachmentNotDecodable, err)
+	}
+
+	var contentType, extension string
+	fileType, _ := filetype.Match(attData[:min(len(attData), 300)])
+	if fileType != filetype.Unknown {
+		contentType = fileType.MIME.Value
+		extension = fileType.Extension
+	} else {
+		contentType = "application/octet-stream"
+		extension = "bin"
+	}
+
+	newURL, err := b.SaveAttachment(ctx, channel, contentType, attData, extension)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", contentType, newURL), nil
+}
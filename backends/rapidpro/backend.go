@@ -2,29 +2,38 @@ package rapidpro
 
 import (
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/tls"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/url"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/buger/jsonparser"
 	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils/clamd"
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/storage"
+	"github.com/nyaruka/courier/utils/transcode"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/gocommon/aws/cwatch"
 	"github.com/nyaruka/gocommon/aws/dynamo"
 	"github.com/nyaruka/gocommon/aws/s3x"
@@ -58,12 +67,23 @@ type backend struct {
 	dyLogWriter  *DynamoLogWriter // all logs being written to dynamo
 	writerWG     *sync.WaitGroup
 
+	// clock used by our batched writers, which tests can replace with a fake
+	clock clock.Clock
+
 	db     *sqlx.DB
-	rp     *redis.Pool
+	rp     valkey.Pool
 	dynamo *dynamo.Service
-	s3     *s3x.Service
+	s3     *s3x.Service // nil unless storage type is s3
 	cw     *cwatch.Service
 
+	attachmentStorage   storage.Storage
+	deactivationStorage storage.Storage // nil if deactivations aren't imported
+
+	scanner    *clamd.Client         // nil if attachments aren't scanned
+	transcoder *transcode.Transcoder // nil if audio isn't transcoded
+
+	channelDefaults map[courier.ChannelType]map[string]any
+
 	channelsByUUID *cache.Local[courier.ChannelUUID, *Channel]
 	channelsByAddr *cache.Local[courier.ChannelAddress, *Channel]
 
@@ -72,11 +92,22 @@ type backend struct {
 
 	httpClient         *http.Client
 	httpClientInsecure *http.Client
+	httpUsage          *usageTracker
 	httpAccess         *httpx.AccessConfig
 
 	mediaCache   *redisx.IntervalHash
 	mediaMutexes syncx.HashMutex
 
+	// tracking of media we've transcoded so that each is only transcoded once per content type
+	transcodedMedia *redisx.IntervalHash
+
+	// tracking of images we've resized so that each is only resized once per set of limits
+	resizedMedia *redisx.IntervalHash
+
+	// tracking of attachments we've stored by content hash so that identical attachments are only stored once per org
+	storedAttachments *redisx.IntervalHash
+	attachmentMutexes syncx.HashMutex
+
 	// tracking of recent messages received to avoid creating duplicates
 	receivedExternalIDs *redisx.IntervalHash // using external id
 	receivedMsgs        *redisx.IntervalHash // using content hash
@@ -87,6 +118,9 @@ type backend struct {
 	// tracking of external ids of messages we've sent in case we need one before its status update has been written
 	sentExternalIDs *redisx.IntervalHash
 
+	// tracking of sent messages which are waiting for a reply so we can measure response rates
+	awaitingReplies *redisx.IntervalHash
+
 	stats *StatsCollector
 
 	// both sqlx and redis provide wait stats which are cummulative that we need to convert into increments by
@@ -97,38 +131,58 @@ type backend struct {
 
 // NewBackend creates a new RapidPro backend
 func newBackend(cfg *courier.Config) courier.Backend {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.MaxIdleConns = 64
-	transport.MaxIdleConnsPerHost = 8
-	transport.IdleConnTimeout = 15 * time.Second
+	// key names depend on whether we're using a cluster so this needs to be set before we create any
+	valkey.SetCluster(cfg.RedisCluster)
 
-	insecureTransport := http.DefaultTransport.(*http.Transport).Clone()
-	insecureTransport.MaxIdleConns = 64
-	insecureTransport.MaxIdleConnsPerHost = 8
-	insecureTransport.IdleConnTimeout = 15 * time.Second
-	insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport, insecureTransport := newHTTPTransports(cfg)
+	tracker := &usageTracker{RoundTripper: transport}
 
 	disallowedIPs, disallowedNets, _ := cfg.ParseDisallowedNetworks()
+	channelDefaults, _ := cfg.ParseChannelDefaults()
+
+	var scanner *clamd.Client
+	if cfg.AttachmentScanAddress != "" {
+		scanner = clamd.NewClient(cfg.AttachmentScanAddress, time.Duration(cfg.AttachmentScanTimeout)*time.Second)
+	}
+
+	var transcoder *transcode.Transcoder
+	if cfg.AudioTranscoder != "" {
+		transcoder = transcode.NewTranscoder(cfg.AudioTranscoder, time.Duration(cfg.AudioTranscodeTimeout)*time.Second)
+	}
 
 	return &backend{
 		config: cfg,
 
-		httpClient:         &http.Client{Transport: transport, Timeout: 30 * time.Second},
-		httpClientInsecure: &http.Client{Transport: insecureTransport, Timeout: 30 * time.Second},
+		httpClient:         &http.Client{Transport: tracker, Timeout: maxRequestTimeout},
+		httpClientInsecure: &http.Client{Transport: insecureTransport, Timeout: maxRequestTimeout},
+		httpUsage:          tracker,
 		httpAccess:         httpx.NewAccessConfig(10*time.Second, disallowedIPs, disallowedNets),
 
+		channelDefaults: channelDefaults,
+
+		scanner:    scanner,
+		transcoder: transcoder,
+
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
 
 		writerWG: &sync.WaitGroup{},
+		clock:    clock.Real,
 
-		mediaCache:   redisx.NewIntervalHash("media-lookups", time.Hour*24, 2),
+		mediaCache:   redisx.NewIntervalHash(valkey.Tag("media-lookups"), time.Hour*24, 2),
 		mediaMutexes: *syncx.NewHashMutex(8),
 
-		receivedMsgs:        redisx.NewIntervalHash("seen-msgs", time.Second*2, 2),        // 2 - 4 seconds
-		receivedExternalIDs: redisx.NewIntervalHash("seen-external-ids", time.Hour*24, 2), // 24 - 48 hours
-		sentIDs:             redisx.NewIntervalSet("sent-ids", time.Hour, 2),              // 1 - 2 hours
-		sentExternalIDs:     redisx.NewIntervalHash("sent-external-ids", time.Hour, 2),    // 1 - 2 hours
+		transcodedMedia: redisx.NewIntervalHash(valkey.Tag("transcoded-media"), time.Hour*24, 7), // 6 - 7 days
+		resizedMedia:    redisx.NewIntervalHash(valkey.Tag("resized-media"), time.Hour*24, 7),    // 6 - 7 days
+
+		storedAttachments: redisx.NewIntervalHash(valkey.Tag("stored-attachments"), time.Hour*24, 30), // 29 - 30 days
+		attachmentMutexes: *syncx.NewHashMutex(8),
+
+		receivedMsgs:        redisx.NewIntervalHash(valkey.Tag("seen-msgs"), time.Second*2, 2),        // 2 - 4 seconds
+		receivedExternalIDs: redisx.NewIntervalHash(valkey.Tag("seen-external-ids"), time.Hour*24, 2), // 24 - 48 hours
+		sentIDs:             redisx.NewIntervalSet(valkey.Tag("sent-ids"), time.Hour, 2),              // 1 - 2 hours
+		sentExternalIDs:     redisx.NewIntervalHash(valkey.Tag("sent-external-ids"), time.Hour, 2),    // 1 - 2 hours
+		awaitingReplies:     redisx.NewIntervalHash(valkey.Tag("awaiting-replies"), time.Hour*24, 2),  // 24 - 48 hours
 
 		stats: NewStatsCollector(),
 	}
@@ -161,7 +215,7 @@ func (b *backend) Start() error {
 		log.Info("db ok")
 	}
 
-	b.rp, err = redisx.NewPool(b.config.Redis, redisx.WithMaxActive(b.config.MaxWorkers*2))
+	b.rp, err = valkey.NewPool(b.config.Redis, b.config.RedisCluster, b.config.MaxWorkers*2)
 	if err != nil {
 		log.Error("redis not reachable", "error", err)
 	} else {
@@ -184,22 +238,26 @@ func (b *backend) Start() error {
 		log.Info("dynamodb ok")
 	}
 
-	// setup S3 storage
-	b.s3, err = s3x.NewService(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, b.config.AWSRegion, b.config.S3Endpoint, b.config.S3Minio)
-	if err != nil {
+	// setup attachment and deactivation storage
+	if b.attachmentStorage, err = b.newStorage(ctx, b.config.S3AttachmentsBucket); err != nil {
 		return err
 	}
+	if b.config.S3DeactivationsBucket != "" {
+		if b.deactivationStorage, err = b.newStorage(ctx, b.config.S3DeactivationsBucket); err != nil {
+			return err
+		}
+	}
 
 	b.cw, err = cwatch.NewService(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, b.config.AWSRegion, b.config.CloudwatchNamespace, b.config.DeploymentID)
 	if err != nil {
 		return err
 	}
 
-	// check attachment bucket access
-	if err := b.s3.Test(ctx, b.config.S3AttachmentsBucket); err != nil {
-		log.Error("attachments bucket not accessible", "error", err)
+	// check attachment storage access
+	if err := b.attachmentStorage.Test(ctx); err != nil {
+		log.Error("attachments storage not accessible", "error", err, "storage", b.attachmentStorage.Name())
 	} else {
-		log.Info("attachments bucket ok")
+		log.Info("attachments storage ok", "storage", b.attachmentStorage.Name())
 	}
 
 	// create and start channel caches...
@@ -226,10 +284,10 @@ func (b *backend) Start() error {
 	b.statusWriter = NewStatusWriter(b, b.config.SpoolDir, b.writerWG)
 	b.statusWriter.Start()
 
-	b.dbLogWriter = NewDBLogWriter(b.db, b.writerWG)
+	b.dbLogWriter = NewDBLogWriter(b.db, b.clock, b.writerWG)
 	b.dbLogWriter.Start()
 
-	b.dyLogWriter = NewDynamoLogWriter(b.dynamo, b.writerWG)
+	b.dyLogWriter = NewDynamoLogWriter(b.dynamo, b.clock, b.writerWG)
 	b.dyLogWriter.Start()
 
 	// register and start our spool flushers
@@ -239,10 +297,42 @@ func (b *backend) Start() error {
 
 	b.startMetricsReporter(time.Minute)
 
+	if b.deactivationStorage != nil {
+		b.startDeactivationsImporter(time.Hour)
+	}
+
+	if hosts := b.config.ParseWarmupHosts(); len(hosts) > 0 {
+		newConnWarmer(b.httpUsage, hosts).Start(b.stopChan, b.waitGroup)
+		log.Info("provider connections warmed", "hosts", hosts)
+	}
+
 	slog.Info("backend started", "comp", "backend", "state", "started")
 	return nil
 }
 
+// creates the storage for the given bucket or container according to the configured storage type
+func (b *backend) newStorage(ctx context.Context, bucket string) (storage.Storage, error) {
+	switch b.config.StorageType {
+	case "azure":
+		return storage.NewAzure(&http.Client{Timeout: time.Minute}, b.config.AzureStorageAccount, b.config.AzureStorageKey, b.config.AzureStorageEndpoint, bucket)
+	case "gcs":
+		client, err := storage.NewGCSClient(ctx, b.config.GCSCredentials)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewGCS(client, b.config.GCSEndpoint, bucket), nil
+	default:
+		if b.s3 == nil {
+			var err error
+			b.s3, err = s3x.NewService(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, b.config.AWSRegion, b.config.S3Endpoint, b.config.S3Minio)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return storage.NewS3(b.s3, bucket), nil
+	}
+}
+
 func (b *backend) startMetricsReporter(interval time.Duration) {
 	b.waitGroup.Add(1)
 
@@ -289,6 +379,60 @@ func (b *backend) Stop() error {
 	return nil
 }
 
+// a writer which writes items in batches
+type batchWriter interface {
+	Flush(context.Context) error
+	Pending() int
+	Failed() int
+}
+
+// returns our batched writers by the names we use for them in logs
+func (b *backend) batchWriters() map[string]batchWriter {
+	if b.statusWriter == nil {
+		return nil // not started
+	}
+	return map[string]batchWriter{"statuses": b.statusWriter, "db_logs": b.dbLogWriter, "dynamo_logs": b.dyLogWriter}
+}
+
+// Flush writes any status updates and channel logs which are waiting to be written in batches, blocking until they
+// have been or the context is done. The number of items which couldn't be written, either because they were still
+// waiting or because writing them failed, is logged for each writer and an error returned if there were any.
+func (b *backend) Flush(ctx context.Context) error {
+	writers := b.batchWriters()
+	failedBefore := make(map[string]int, len(writers))
+	for name, w := range writers {
+		failedBefore[name] = w.Failed()
+	}
+
+	// flush writers concurrently so that a slow one doesn't use up the time of the others
+	wg := &sync.WaitGroup{}
+	for _, w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Flush(ctx)
+		}()
+	}
+	wg.Wait()
+
+	lost := 0
+	attrs := make([]any, 0, len(writers)*4)
+	for _, name := range slices.Sorted(maps.Keys(writers)) {
+		unflushed, failed := writers[name].Pending(), writers[name].Failed()-failedBefore[name]
+		lost += unflushed + failed
+		attrs = append(attrs, name+"_unflushed", unflushed, name+"_failed", failed)
+	}
+
+	log := slog.With("comp", "backend").With(attrs...)
+	if lost > 0 {
+		log.Error("buffered writes lost while flushing", "lost", lost)
+		return fmt.Errorf("%d buffered writes couldn't be flushed", lost)
+	}
+
+	log.Info("buffered writes flushed")
+	return nil
+}
+
 func (b *backend) Cleanup() error {
 	// stop our batched writers
 	if b.statusWriter != nil {
@@ -301,8 +445,23 @@ func (b *backend) Cleanup() error {
 		b.dyLogWriter.Stop()
 	}
 
-	// wait for them to flush fully
-	b.writerWG.Wait()
+	// wait for them to flush fully, but only as long as we're configured to wait
+	writersDone := make(chan bool)
+	go func() {
+		b.writerWG.Wait()
+		close(writersDone)
+	}()
+
+	select {
+	case <-writersDone:
+	case <-time.After(time.Second * time.Duration(b.config.ShutdownTimeout)):
+		writers := b.batchWriters()
+		attrs := make([]any, 0, len(writers)*2)
+		for _, name := range slices.Sorted(maps.Keys(writers)) {
+			attrs = append(attrs, name+"_abandoned", writers[name].Pending())
+		}
+		slog.With("comp", "backend").Error("timed out waiting for buffered writes, abandoning them", attrs...)
+	}
 
 	// close our db and redis pool
 	if b.db != nil {
@@ -345,6 +504,41 @@ func (b *backend) GetChannelByAddress(ctx context.Context, typ courier.ChannelTy
 	return ch, nil
 }
 
+// GetChannelsByType returns all active channels of the passed in type
+func (b *backend) GetChannelsByType(ctx context.Context, typ courier.ChannelType) ([]courier.Channel, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	dbChannels, err := b.loadChannelsByType(timeout, typ)
+	if err != nil {
+		return nil, fmt.Errorf("error loading channels of type %s: %w", typ, err)
+	}
+
+	channels := make([]courier.Channel, len(dbChannels))
+	for i, ch := range dbChannels {
+		channels[i] = ch
+	}
+	return channels, nil
+}
+
+// UpdateChannelConfig persists the given config values for the passed in channel
+func (b *backend) UpdateChannelConfig(ctx context.Context, ch courier.Channel, updates map[string]any) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	updated, err := b.updateChannelConfig(timeout, ch.(*Channel), updates)
+	if err != nil {
+		return err
+	}
+
+	// replace our cached channel so that the new values are used immediately
+	b.channelsByUUID.Set(updated.UUID(), updated)
+	if updated.ChannelAddress() != courier.NilChannelAddress {
+		b.channelsByAddr.Set(updated.ChannelAddress(), updated)
+	}
+	return nil
+}
+
 // GetContact returns the contact for the passed in channel and URN
 func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, authTokens map[string]string, name string, clog *courier.ChannelLog) (courier.Contact, error) {
 	dbChannel := c.(*Channel)
@@ -423,6 +617,94 @@ func (b *backend) NewIncomingMsg(channel courier.Channel, urn urns.URN, text str
 	return msg
 }
 
+const sqlInsertOutgoingMsg = `
+INSERT INTO
+	msgs_msg(org_id, uuid, direction, text, attachments, quick_replies, msg_type, msg_count, error_count, high_priority, status, is_android,
+             visibility, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt)
+    VALUES(:org_id, :uuid, 'O', :text, :attachments, :quick_replies, 'T', 1, 0, :high_priority, 'Q', FALSE,
+           'V', :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt)
+RETURNING id`
+
+// QueueMsg creates the given outgoing message in the database for the contact with its URN, creating them if
+// necessary, and queues it to be sent, as mailroom does
+func (b *backend) QueueMsg(ctx context.Context, ch courier.Channel, out *courier.OutgoingMsg) (courier.MsgID, error) {
+	dbChannel := ch.(*Channel)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, ch, nil)
+
+	contact, err := contactForURN(ctx, b, dbChannel.OrgID_, dbChannel, out.URN, nil, "", clog)
+
+	// creating a new contact can involve looking up their details from the channel, which we want a log of
+	if len(clog.HttpLogs) > 0 || len(clog.Errors) > 0 {
+		clog.End()
+		if err := b.WriteChannelLog(ctx, clog); err != nil {
+			slog.Error("error writing contact lookup log", "error", err, "channel_uuid", ch.UUID())
+		}
+	}
+
+	if err != nil {
+		return courier.NilMsgID, fmt.Errorf("error getting contact for URN: %w", err)
+	}
+
+	now := time.Now()
+	m := &Msg{
+		OrgID_:        dbChannel.OrgID_,
+		UUID_:         out.UUID,
+		Direction_:    MsgOutgoing,
+		Status_:       courier.MsgStatusQueued,
+		Visibility_:   MsgVisible,
+		HighPriority_: out.HighPriority,
+		Text_:         out.Text,
+		Attachments_:  out.Attachments,
+		QuickReplies_: out.QuickReplies,
+		ChannelID_:    dbChannel.ID_,
+		ChannelUUID_:  dbChannel.UUID_,
+		ContactID_:    contact.ID_,
+		ContactURNID_: contact.URNID_,
+		URN_:          out.URN,
+		MessageCount_: 1,
+		CreatedOn_:    now,
+		ModifiedOn_:   now,
+		NextAttempt_:  now,
+	}
+
+	rows, err := b.db.NamedQueryContext(ctx, sqlInsertOutgoingMsg, m)
+	if err != nil {
+		return courier.NilMsgID, fmt.Errorf("error inserting message: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return courier.NilMsgID, fmt.Errorf("error inserting message: %w", err)
+		}
+		return courier.NilMsgID, errors.New("error inserting message, no id returned")
+	}
+	if err := rows.Scan(&m.ID_); err != nil {
+		return courier.NilMsgID, fmt.Errorf("error scanning for inserted message id: %w", err)
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	tps, err := b.channelQueueTPS(rc, dbChannel.UUID_)
+	if err != nil {
+		return courier.NilMsgID, err
+	}
+
+	priority := queue.LowPriority
+	if m.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]*Msg{m})
+
+	if err := queue.PushOntoQueue(rc, msgQueueName, string(dbChannel.UUID_), tps, string(value), queue.Priority(priority)); err != nil {
+		return courier.NilMsgID, fmt.Errorf("error queuing message: %w", err)
+	}
+	return m.ID_, nil
+}
+
 // PopNextOutgoingMsg pops the next message that needs to be sent
 func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error) {
 	tryToPop := func() (queue.WorkerToken, string, error) {
@@ -439,45 +721,142 @@ func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error
 		}
 	}
 
-	// pop the next message off our queue
-	token, msgJSON, err := tryToPop()
-	if err != nil {
-		return nil, err
-	}
+	for {
+		// pop the next message off our queue
+		token, msgJSON, err := tryToPop()
+		if err != nil {
+			return nil, err
+		}
+
+		for token == queue.Retry {
+			token, msgJSON, err = tryToPop()
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	for token == queue.Retry {
-		token, msgJSON, err = tryToPop()
+		if msgJSON == "" {
+			return nil, nil
+		}
+
+		dbMsg := &Msg{}
+		err = json.Unmarshal([]byte(msgJSON), dbMsg)
+		if err != nil {
+			markComplete(token)
+			b.failUnreadableMsg(ctx, nil, msgJSON)
+			return nil, fmt.Errorf("unable to unmarshal message: %s: %w", string(msgJSON), err)
+		}
+
+		// populate the channel on our db msg
+		channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
 		if err != nil {
+			markComplete(token)
 			return nil, err
 		}
-	}
 
-	if msgJSON == "" {
-		return nil, nil
+		dbMsg.Direction_ = MsgOutgoing
+		dbMsg.channel = channel.(*Channel)
+		dbMsg.workerToken = token
+		dbMsg.tps = tpsFromWorkerToken(token)
+
+		// if this message is being paced and has been pushed back for later, try the next message
+		if b.deferIfPaced(dbMsg) {
+			markComplete(token)
+			continue
+		}
+
+		// if this message is already being sent (e.g. it was queued twice), drop it and try the next message
+		if b.isDuplicateSend(dbMsg) {
+			markComplete(token)
+			continue
+		}
+
+		// clear out our seen incoming messages
+		b.clearMsgSeen(dbMsg)
+		b.tracePopped(dbMsg)
+
+		return dbMsg, nil
 	}
+}
+
+// defers the given message if it's being paced, returning whether it was deferred. Errors are logged and the
+// message sent without pacing rather than risk losing it.
+func (b *backend) deferIfPaced(msg *Msg) bool {
+	rc := b.rp.Get()
+	defer rc.Close()
 
-	dbMsg := &Msg{}
-	err = json.Unmarshal([]byte(msgJSON), dbMsg)
+	deferred, err := deferPacedMsg(rc, msg)
 	if err != nil {
-		markComplete(token)
-		return nil, fmt.Errorf("unable to unmarshal message: %s: %w", string(msgJSON), err)
+		slog.Error("error deferring paced message", "error", err, "msg_id", msg.ID_)
 	}
+	return deferred
+}
 
-	// populate the channel on our db msg
-	channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
+// PopMoreOutgoingMsgs pops up to max more messages from the same queue as the passed in message
+func (b *backend) PopMoreOutgoingMsgs(ctx context.Context, msg courier.MsgOut, max int) ([]courier.MsgOut, error) {
+	first := msg.(*Msg)
+
+	rc := b.rp.Get()
+	values, err := queue.PopMoreFromQueue(rc, msgQueueName, first.workerToken, max)
+	rc.Close()
 	if err != nil {
-		markComplete(token)
 		return nil, err
 	}
 
-	dbMsg.Direction_ = MsgOutgoing
-	dbMsg.channel = channel.(*Channel)
-	dbMsg.workerToken = token
+	msgs := make([]courier.MsgOut, 0, len(values))
+	for _, msgJSON := range values {
+		dbMsg := &Msg{}
+		if err := json.Unmarshal([]byte(msgJSON), dbMsg); err != nil {
+			slog.Error("unable to unmarshal message", "error", err, "msg", msgJSON)
+			b.failUnreadableMsg(ctx, first.channel, msgJSON)
+			continue
+		}
+
+		// these messages share the worker token of the first message so don't get one of their own
+		dbMsg.Direction_ = MsgOutgoing
+		dbMsg.channel = first.channel
+		dbMsg.tps = first.tps
+
+		if b.deferIfPaced(dbMsg) || b.isDuplicateSend(dbMsg) {
+			continue
+		}
+
+		b.clearMsgSeen(dbMsg)
+		b.tracePopped(dbMsg)
+
+		msgs = append(msgs, dbMsg)
+	}
+
+	return msgs, nil
+}
+
+// fails a message popped from our queue which couldn't be unmarshaled so that it doesn't just disappear, provided we
+// can at least read its id, and its channel if that isn't given
+func (b *backend) failUnreadableMsg(ctx context.Context, ch courier.Channel, msgJSON string) {
+	id, err := jsonparser.GetInt([]byte(msgJSON), "id")
+	if err != nil || id <= 0 {
+		slog.Error("unable to read id of unreadable message, dropping", "msg", msgJSON)
+		return
+	}
+
+	if ch == nil {
+		uuid, _ := jsonparser.GetString([]byte(msgJSON), "channel_uuid")
+		if ch, err = b.GetChannel(ctx, courier.AnyChannelType, courier.ChannelUUID(uuid)); err != nil {
+			slog.Error("unable to load channel of unreadable message, dropping", "error", err, "msg_id", id)
+			return
+		}
+	}
 
-	// clear out our seen incoming messages
-	b.clearMsgSeen(dbMsg)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, ch, nil)
+	clog.Error(clogs.NewLogError("invalid_message", "", "Message could not be read from the queue."))
+	clog.End()
 
-	return dbMsg, nil
+	if err := b.WriteStatusUpdate(ctx, b.NewStatusUpdate(ch, courier.MsgID(id), courier.MsgStatusFailed, clog)); err != nil {
+		slog.Error("error failing unreadable message", "error", err, "msg_id", id)
+	}
+	if err := b.WriteChannelLog(ctx, clog); err != nil {
+		slog.Error("error writing channel log", "error", err, "msg_id", id)
+	}
 }
 
 // WasMsgSent returns whether the passed in message has already been sent
@@ -495,6 +874,27 @@ func (b *backend) ClearMsgSent(ctx context.Context, id courier.MsgID) error {
 	return b.sentIDs.Rem(rc, id.String())
 }
 
+// RequeueMsg puts the passed in message back on its queue to be popped again after the given delay
+func (b *backend) RequeueMsg(ctx context.Context, msg courier.MsgOut, delay time.Duration) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	dbMsg := msg.(*Msg)
+
+	priority := queue.LowPriority
+	if dbMsg.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{dbMsg})
+
+	if err := queue.PushOntoQueueAt(rc, msgQueueName, string(dbMsg.ChannelUUID_), dbMsg.tps, string(value), queue.Priority(priority), time.Now().Add(delay)); err != nil {
+		return fmt.Errorf("error requeuing message: %w", err)
+	}
+	return nil
+}
+
 // OnSendComplete is called when the sender has finished trying to send a message
 func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status courier.StatusUpdate, clog *courier.ChannelLog) {
 	rc := b.rp.Get()
@@ -502,17 +902,44 @@ func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status
 
 	dbMsg := msg.(*Msg)
 
-	if err := queue.MarkComplete(rc, msgQueueName, dbMsg.workerToken); err != nil {
-		slog.Error("unable to mark queue task complete", "error", err)
+	// messages popped as part of a batch don't have their own worker token
+	if dbMsg.workerToken != "" {
+		if err := queue.MarkComplete(rc, msgQueueName, dbMsg.workerToken); err != nil {
+			slog.Error("unable to mark queue task complete", "error", err)
+		}
 	}
 
 	// if message won't be retried, mark as sent to avoid dupe sends
-	if status.Status() != courier.MsgStatusErrored {
+	if status.Status() != courier.MsgStatusErrored && status.Status() != courier.MsgStatusQueued {
 		if err := b.sentIDs.Add(rc, msg.ID().String()); err != nil {
 			slog.Error("unable to mark message sent", "error", err)
 		}
 	}
 
+	// and now that we're done sending it, another sender can have it if it's retried or resent
+	if err := releaseMsgSend(rc, msg.UUID()); err != nil {
+		slog.Error("unable to release message send", "error", err, "msg_id", msg.ID())
+	}
+
+	attempt := &courier.MsgTimelineEvent{
+		Type:      courier.MsgTimelineSendAttempt,
+		CreatedOn: clog.CreatedOn.In(time.UTC),
+		Status:    status.Status(),
+		LogUUID:   clog.UUID,
+		ElapsedMS: int(clog.Elapsed / time.Millisecond),
+		Errors:    clog.Errors,
+	}
+	if err := traceMsgEvent(rc, msg.ID(), attempt); err != nil {
+		slog.Error("unable to trace send attempt", "error", err, "msg_id", msg.ID())
+	}
+
+	// if message won't be retried again, set it aside so that it can be requeued later
+	if isRetriesExhausted(dbMsg, status.Status()) {
+		if err := pushDeadLetter(rc, dbMsg, clog); err != nil {
+			slog.Error("unable to push dead letter", "error", err, "msg_id", msg.ID())
+		}
+	}
+
 	// if message was successfully sent, and we have a session timeout, update it
 	wasSuccess := status.Status() == courier.MsgStatusWired || status.Status() == courier.MsgStatusSent || status.Status() == courier.MsgStatusDelivered || status.Status() == courier.MsgStatusRead
 	if wasSuccess && dbMsg.Session_ != nil && dbMsg.Session_.Timeout > 0 {
@@ -521,12 +948,268 @@ func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status
 		}
 	}
 
-	b.stats.RecordOutgoing(msg.Channel().ChannelType(), wasSuccess, clog.Elapsed)
+	if wasSuccess && dbMsg.expectsReply() {
+		if err := b.trackReplyExpected(rc, dbMsg); err != nil {
+			slog.Error("unable to track expected reply", "error", err, "msg_id", msg.ID())
+		}
+	}
+
+	hasError := func(code string) bool {
+		return slices.ContainsFunc(clog.Errors, func(e *clogs.LogError) bool { return e.Code == code })
+	}
+
+	// sends deferred because the channel's circuit is open weren't attempted so don't count as errors
+	if hasError("circuit_open") {
+		b.stats.RecordOutgoingDeferred(msg.Channel().ChannelType())
+	} else {
+		b.stats.RecordOutgoing(msg.Channel().ChannelType(), wasSuccess, clog.Elapsed)
+	}
+
+	if status.Segments() > 0 {
+		b.stats.RecordOutgoingSegments(msg.Channel().ChannelType(), status.Segments())
+	}
+
+	if hasError("request_timeout") {
+		b.stats.RecordOutgoingTimeout(msg.Channel().ChannelType())
+	}
+	if hasError("circuit_opened") {
+		b.stats.RecordCircuitOpened(msg.Channel().ChannelType())
+	}
+}
+
+// Queues returns the state of the outgoing queues of all channels which have pending messages or have been paused
+func (b *backend) Queues(ctx context.Context) ([]*courier.QueueInfo, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	queues, err := queue.Queues(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queues: %w", err)
+	}
+	paused, err := queue.Paused(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading paused queues: %w", err)
+	}
+
+	infos := make(map[courier.ChannelUUID]*courier.QueueInfo, len(queues))
+	getInfo := func(uuid courier.ChannelUUID) *courier.QueueInfo {
+		if infos[uuid] == nil {
+			infos[uuid] = &courier.QueueInfo{ChannelUUID: uuid}
+		}
+		return infos[uuid]
+	}
+
+	for name, workers := range queues {
+		// our queue name is in the format uuid|tps, break it apart
+		uuid, tps, found := strings.Cut(name, "|")
+		if !found {
+			continue
+		}
+
+		size, bulkSize, err := queue.Size(rc, msgQueueName, name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading queue size: %w", err)
+		}
+
+		// channels can have more than one queue if their TPS has changed
+		info := getInfo(courier.ChannelUUID(uuid))
+		info.TPS, _ = strconv.Atoi(tps)
+		info.Size += size
+		info.BulkSize += bulkSize
+		info.Workers += workers
+	}
+	for _, uuid := range paused {
+		getInfo(courier.ChannelUUID(uuid)).Paused = true
+	}
+
+	result := make([]*courier.QueueInfo, 0, len(infos))
+	for _, uuid := range slices.Sorted(maps.Keys(infos)) {
+		result = append(result, infos[uuid])
+	}
+	return result, nil
+}
+
+// returns the names of the queues for the given channel, of which there can be more than one if its TPS has changed
+func (b *backend) channelQueues(rc redis.Conn, uuid courier.ChannelUUID) ([]string, error) {
+	queues, err := queue.Queues(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queues: %w", err)
+	}
+
+	names := make([]string, 0, 1)
+	for name := range queues {
+		if strings.HasPrefix(name, string(uuid)+"|") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// PeekQueue returns up to limit pending messages for the given channel without removing them
+func (b *backend) PeekQueue(ctx context.Context, uuid courier.ChannelUUID, limit int) ([]json.RawMessage, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]json.RawMessage, 0, limit)
+
+	for _, name := range names {
+		values, err := queue.Peek(rc, msgQueueName, name, limit-len(msgs))
+		if err != nil {
+			return nil, fmt.Errorf("error peeking queue: %w", err)
+		}
+
+		// each queued value is a list of messages
+		for _, value := range values {
+			var batch []json.RawMessage
+			if err := json.Unmarshal([]byte(value), &batch); err != nil {
+				slog.Error("unable to unmarshal queued messages", "error", err, "value", value)
+				continue
+			}
+			for _, m := range batch {
+				if len(msgs) < limit {
+					msgs = append(msgs, m)
+				}
+			}
+		}
+	}
+
+	return msgs, nil
+}
+
+// PurgeQueue removes all pending messages for the given channel
+func (b *backend) PurgeQueue(ctx context.Context, uuid courier.ChannelUUID) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, name := range names {
+		n, err := queue.Purge(rc, msgQueueName, name)
+		if err != nil {
+			return purged, fmt.Errorf("error purging queue: %w", err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// PauseQueue pauses or resumes sending of messages for the given channel
+func (b *backend) PauseQueue(ctx context.Context, uuid courier.ChannelUUID, pause bool) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if pause {
+		return queue.Pause(rc, msgQueueName, string(uuid))
+	}
+	return queue.Resume(rc, msgQueueName, string(uuid))
+}
+
+// DrainQueue moves all pending messages for the given channel onto the queue of another channel of the same workspace
+func (b *backend) DrainQueue(ctx context.Context, from, to courier.ChannelUUID) (int, error) {
+	source, err := b.GetChannel(ctx, courier.AnyChannelType, from)
+	if err != nil && !errors.Is(err, courier.ErrChannelNotFound) {
+		return 0, fmt.Errorf("error loading source channel: %w", err)
+	}
+	ch, err := b.GetChannel(ctx, courier.AnyChannelType, to)
+	if err != nil {
+		return 0, fmt.Errorf("error loading target channel: %w", err)
+	}
+	target := ch.(*Channel)
+
+	// a source channel which has since been deleted can still be drained, but never into another workspace
+	if source != nil && source.(*Channel).OrgID() != target.OrgID() {
+		return 0, fmt.Errorf("channel %s belongs to a different workspace: %w", to, courier.ErrChannelNotFound)
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, from)
+	if err != nil {
+		return 0, err
+	}
+	targetNames, err := b.channelQueues(rc, to)
+	if err != nil {
+		return 0, err
+	}
+
+	// use the TPS of the target's current queue if it has one
+	targetTPS := -1
+	if len(targetNames) > 0 {
+		_, t, _ := strings.Cut(targetNames[len(targetNames)-1], "|")
+		targetTPS, _ = strconv.Atoi(t)
+	}
+
+	drained := 0
+	for _, name := range names {
+		n, err := b.drainChannelQueue(ctx, rc, name, target, targetTPS)
+		drained += n
+		if err != nil {
+			return drained, err
+		}
+	}
+	return drained, nil
+}
+
+// DeadLetters returns the dead letters for the given channel
+func (b *backend) DeadLetters(ctx context.Context, uuid courier.ChannelUUID) ([]*courier.DeadLetter, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	letters, err := readDeadLetters(rc, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*courier.DeadLetter, len(letters))
+	for i, l := range letters {
+		result[i] = &l.DeadLetter
+	}
+	return result, nil
+}
+
+// RequeueDeadLetters puts the dead letters for the given channel back onto the outgoing queue
+func (b *backend) RequeueDeadLetters(ctx context.Context, uuid courier.ChannelUUID) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	requeued, err := requeueDeadLetters(rc, uuid)
+
+	// failed messages are marked as sent so need unmarking to not be skipped as dupes
+	for _, l := range requeued {
+		if err := b.sentIDs.Rem(rc, l.MsgID.String()); err != nil {
+			slog.Error("unable to clear sent msg", "error", err, "msg_id", l.MsgID)
+		}
+	}
+
+	return len(requeued), err
 }
 
 // OnReceiveComplete is called when the server has finished handling an incoming request
 func (b *backend) OnReceiveComplete(ctx context.Context, ch courier.Channel, events []courier.Event, clog *courier.ChannelLog) {
 	b.stats.RecordIncoming(ch.ChannelType(), events, clog.Elapsed)
+
+	for _, e := range events {
+		if msg, isMsg := e.(courier.MsgIn); isMsg {
+			rc := b.rp.Get()
+			err := b.trackReplyReceived(rc, ch, msg)
+			rc.Close()
+
+			if err != nil {
+				slog.Error("unable to track received reply", "error", err, "channel_uuid", ch.UUID())
+			}
+		}
+	}
 }
 
 // WriteMsg writes the passed in message to our store
@@ -670,26 +1353,86 @@ func (b *backend) WriteChannelLog(ctx context.Context, clog *courier.ChannelLog)
 	return nil
 }
 
-// SaveAttachment saves an attachment to backend storage
+// WriteChannelQuality records the latest quality of the passed in channel, logging any changes in rating
+func (b *backend) WriteChannelQuality(ctx context.Context, ch courier.Channel, quality *courier.ChannelQuality) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	prev, err := writeChannelQuality(rc, ch, quality)
+	if err != nil {
+		return err
+	}
+
+	if prev != nil && (prev.Rating != quality.Rating || prev.Tier != quality.Tier) {
+		slog.Warn("channel quality changed", "channel_uuid", ch.UUID(), "channel_type", ch.ChannelType(), "rating", quality.Rating, "prev_rating", prev.Rating, "tier", quality.Tier, "prev_tier", prev.Tier)
+	}
+	return nil
+}
+
+// SaveAttachment saves an attachment to backend storage, reusing the URL of an identical attachment if the channel's
+// workspace has recently stored one
 func (b *backend) SaveAttachment(ctx context.Context, ch courier.Channel, contentType string, data []byte, extension string) (string, error) {
+	orgID := ch.(*Channel).OrgID()
+
+	// the same content, e.g. a forwarded image, can be received many times at once
+	hash := fmt.Sprintf("%d:%s:%x", orgID, contentType, sha256.Sum256(data))
+	unlock := b.attachmentMutexes.Lock(hash)
+	defer unlock()
+
+	if storageURL := b.lookupStoredAttachment(hash); storageURL != "" {
+		b.stats.RecordAttachmentDeduplicated(ch.ChannelType())
+		return storageURL, nil
+	}
+
+	// infected attachments are never stored
+	if b.scanner != nil {
+		signature, err := b.scanner.Scan(ctx, data)
+		if err != nil {
+			return "", fmt.Errorf("error scanning attachment: %w", err)
+		}
+		if signature != "" {
+			b.stats.RecordAttachmentInfected(ch.ChannelType())
+			return "", &courier.AttachmentInfectedError{Signature: signature}
+		}
+	}
+
 	// create our filename
 	filename := string(uuids.NewV4())
 	if extension != "" {
 		filename = fmt.Sprintf("%s.%s", filename, extension)
 	}
 
-	orgID := ch.(*Channel).OrgID()
-
 	path := filepath.Join("attachments", strconv.FormatInt(int64(orgID), 10), filename[:4], filename[4:8], filename)
 
-	storageURL, err := b.s3.PutObject(ctx, b.config.S3AttachmentsBucket, path, contentType, data, s3types.ObjectCannedACLPublicRead)
+	storageURL, err := b.attachmentStorage.Put(ctx, path, contentType, data)
 	if err != nil {
 		return "", fmt.Errorf("error saving attachment to storage (bytes=%d): %w", len(data), err)
 	}
 
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if err := b.storedAttachments.Set(rc, hash, storageURL); err != nil {
+		slog.Error("error recording stored attachment", "error", err, "url", storageURL)
+	}
+
 	return storageURL, nil
 }
 
+// looks up the URL of a stored attachment by its hash. Errors are logged and the attachment stored again rather than
+// fail to store it at all.
+func (b *backend) lookupStoredAttachment(hash string) string {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	storageURL, err := b.storedAttachments.Get(rc, hash)
+	if err != nil {
+		slog.Error("error looking up stored attachment", "error", err)
+		return ""
+	}
+	return storageURL
+}
+
 // ResolveMedia resolves the passed in attachment URL to a media object
 func (b *backend) ResolveMedia(ctx context.Context, mediaUrl string) (courier.Media, error) {
 	u, err := url.Parse(mediaUrl)
@@ -736,6 +1479,78 @@ func (b *backend) ResolveMedia(ctx context.Context, mediaUrl string) (courier.Me
 	return media, nil
 }
 
+// TranscodeMedia converts the given audio media to the given content type, returning nil if audio transcoding isn't
+// enabled or we can't transcode to that type. Transcoded media is stored like any other attachment and cached by the
+// URL of its source so that it's reused by other messages with the same attachment.
+func (b *backend) TranscodeMedia(ctx context.Context, ch courier.Channel, media courier.Media, contentType string) (courier.Media, error) {
+	if b.transcoder == nil || !strings.HasPrefix(media.ContentType(), "audio/") || !transcode.Supports(contentType) {
+		return nil, nil
+	}
+
+	key := contentType + ":" + media.URL()
+
+	unlock := b.mediaMutexes.Lock(key)
+	defer unlock()
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	mediaJSON, err := b.transcodedMedia.Get(rc, key)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up transcoded media: %w", err)
+	}
+	if mediaJSON != "" {
+		transcoded := &Media{}
+		jsonx.MustUnmarshal([]byte(mediaJSON), transcoded)
+		return transcoded, nil
+	}
+
+	transcoded, err := b.transcodeMedia(ctx, ch, media, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("error transcoding media: %w", err)
+	}
+
+	b.transcodedMedia.Set(rc, key, string(jsonx.MustMarshal(transcoded)))
+
+	return transcoded, nil
+}
+
+// ResizeImage scales down and compresses the given image media to fit within the given limits, returning nil if it isn't
+// an image. Resized images are stored as JPEGs like any other attachment and cached by the URL of their source and the
+// limits, so that they're reused when the message is resent or other messages have the same attachment.
+func (b *backend) ResizeImage(ctx context.Context, ch courier.Channel, media courier.Media, maxWidth, maxHeight, maxBytes int) (courier.Media, error) {
+	if !strings.HasPrefix(media.ContentType(), "image/") {
+		return nil, nil
+	}
+
+	key := fmt.Sprintf("%dx%d:%d:%s", maxWidth, maxHeight, maxBytes, media.URL())
+
+	unlock := b.mediaMutexes.Lock(key)
+	defer unlock()
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	mediaJSON, err := b.resizedMedia.Get(rc, key)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up resized media: %w", err)
+	}
+	if mediaJSON != "" {
+		resized := &Media{}
+		jsonx.MustUnmarshal([]byte(mediaJSON), resized)
+		return resized, nil
+	}
+
+	resized, err := b.resizeImage(ctx, ch, media, maxWidth, maxHeight, maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error resizing image: %w", err)
+	}
+
+	b.resizedMedia.Set(rc, key, string(jsonx.MustMarshal(resized)))
+
+	return resized, nil
+}
+
 func (b *backend) HttpClient(secure bool) *http.Client {
 	if secure {
 		return b.httpClient
@@ -775,38 +1590,40 @@ func (b *backend) Health() string {
 	return health.String()
 }
 
+// CheckHealth checks our database, valkey, dynamodb and storage
+func (b *backend) CheckHealth(ctx context.Context) []*courier.HealthCheck {
+	checks := []*courier.HealthCheck{
+		courier.CheckDependency("db", func() error { return b.db.PingContext(ctx) }),
+		courier.CheckDependency("valkey", func() error { return valkey.Ping(ctx, b.rp) }),
+		courier.CheckDependency("dynamodb", func() error { return b.dynamo.Test(ctx) }),
+		courier.CheckDependency(b.attachmentStorage.Name(), func() error { return b.attachmentStorage.Test(ctx) }),
+	}
+	if b.deactivationStorage != nil {
+		checks = append(checks, courier.CheckDependency(b.deactivationStorage.Name(), func() error { return b.deactivationStorage.Test(ctx) }))
+	}
+	return checks
+}
+
 func (b *backend) reportMetrics(ctx context.Context) (int, error) {
 	metrics := b.stats.Extract().ToMetrics()
 
 	// get queue sizes
 	rc := b.rp.Get()
 	defer rc.Close()
-	active, err := redis.Strings(rc.Do("ZRANGE", fmt.Sprintf("%s:active", msgQueueName), "0", "-1"))
+	queues, err := queue.Queues(rc, msgQueueName)
 	if err != nil {
-		return 0, fmt.Errorf("error getting active queues: %w", err)
+		return 0, fmt.Errorf("error getting queues: %w", err)
 	}
-	throttled, err := redis.Strings(rc.Do("ZRANGE", fmt.Sprintf("%s:throttled", msgQueueName), "0", "-1"))
-	if err != nil {
-		return 0, fmt.Errorf("error getting throttled queues: %w", err)
-	}
-	queues := append(active, throttled...)
 
 	prioritySize := 0
 	bulkSize := 0
-	for _, queue := range queues {
-		q := fmt.Sprintf("%s/1", queue)
-		count, err := redis.Int(rc.Do("ZCARD", q))
+	for q := range queues {
+		size, bulk, err := queue.Size(rc, msgQueueName, q)
 		if err != nil {
-			return 0, fmt.Errorf("error getting size of priority queue: %s: %w", q, err)
+			return 0, fmt.Errorf("error getting size of queue: %s: %w", q, err)
 		}
-		prioritySize += count
-
-		q = fmt.Sprintf("%s/0", queue)
-		count, err = redis.Int(rc.Do("ZCARD", q))
-		if err != nil {
-			return 0, fmt.Errorf("error getting size of bulk queue: %s: %w", q, err)
-		}
-		bulkSize += count
+		prioritySize += size
+		bulkSize += bulk
 	}
 
 	// calculate DB and redis pool metrics
@@ -827,6 +1644,19 @@ func (b *backend) reportMetrics(ctx context.Context) (int, error) {
 		cwatch.Datum("QueuedMsgs", float64(prioritySize), cwtypes.StandardUnitCount, cwatch.Dimension("QueueName", "priority")),
 	)
 
+	// count channels by their last reported quality rating
+	qualities, err := readChannelQualities(rc)
+	if err != nil {
+		return 0, err
+	}
+	byRating := make(map[string]int, 3)
+	for _, q := range qualities {
+		byRating[q.Rating]++
+	}
+	for rating, count := range byRating {
+		metrics = append(metrics, cwatch.Datum("ChannelsByQuality", float64(count), cwtypes.StandardUnitCount, cwatch.Dimension("Rating", rating)))
+	}
+
 	if err := b.cw.Send(ctx, metrics...); err != nil {
 		return 0, fmt.Errorf("error sending metrics: %w", err)
 	}
@@ -844,38 +1674,16 @@ func (b *backend) Status() string {
 	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
 	status.WriteString("------------------------------------------------------------------------------------\n")
 
-	var queue string
-	var workers float64
-
-	// get all our queues
-	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:active", msgQueueName), "+inf", "-inf", "withscores")
-	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:throttled", msgQueueName), "+inf", "-inf", "withscores")
-	rc.Flush()
-
-	active, err := redis.Values(rc.Receive())
+	// get all our queues, busiest first
+	queues, err := queue.Queues(rc, msgQueueName)
 	if err != nil {
-		return fmt.Sprintf("unable to read active queues: %v", err)
+		return fmt.Sprintf("unable to read queues: %v", err)
 	}
-	throttled, err := redis.Values(rc.Receive())
-	if err != nil {
-		return fmt.Sprintf("unable to read throttled queues: %v", err)
-	}
-	values := append(active, throttled...)
-
-	for len(values) > 0 {
-		values, err = redis.Scan(values, &queue, &workers)
-		if err != nil {
-			return fmt.Sprintf("error reading active queues: %v", err)
-		}
+	names := slices.SortedFunc(maps.Keys(queues), func(a, b string) int { return cmp.Or(queues[b]-queues[a], strings.Compare(a, b)) })
 
-		// our queue name is in the format msgs:uuid|tps, break it apart
-		queue = strings.TrimPrefix(queue, "msgs:")
-		parts := strings.Split(queue, "|")
-		if len(parts) != 2 {
-			return fmt.Sprintf("error parsing queue name '%s'", queue)
-		}
-		uuid := parts[0]
-		tps := parts[1]
+	for _, name := range names {
+		// our queue name is in the format uuid|tps, break it apart
+		uuid, tps, _ := strings.Cut(name, "|")
 
 		// try to look up our channel
 		channelUUID := courier.ChannelUUID(uuid)
@@ -885,25 +1693,50 @@ func (b *backend) Status() string {
 			channelType = string(channel.ChannelType())
 		}
 
-		// get # of items in our normal queue
-		size, err := redis.Int64(rc.Do("ZCARD", fmt.Sprintf("%s:%s/1", msgQueueName, queue)))
+		// get # of items in our normal and bulk queues
+		size, bulkSize, err := queue.Size(rc, msgQueueName, name)
 		if err != nil {
 			return fmt.Sprintf("error reading queue size: %v", err)
 		}
 
-		// get # of items in the bulk queue
-		bulkSize, err := redis.Int64(rc.Do("ZCARD", fmt.Sprintf("%s:%s/0", msgQueueName, queue)))
-		if err != nil {
-			return fmt.Sprintf("error reading bulk queue size: %v", err)
-		}
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, queues[name], tps, channelType, uuid))
+	}
 
-		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, int(workers), tps, channelType, uuid))
+	qualities, err := readChannelQualities(rc)
+	if err != nil {
+		return err.Error()
+	}
+
+	if len(qualities) > 0 {
+		status.WriteString("\n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		status.WriteString("   Rating |      Tier |           Checked On | Channel              \n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+
+		for _, uuid := range slices.Sorted(maps.Keys(qualities)) {
+			q := qualities[uuid]
+			status.WriteString(fmt.Sprintf("% 9s   % 9s   % 20s   %s\n", q.Rating, q.Tier, q.CheckedOn.Format(time.DateTime), uuid))
+		}
 	}
 
 	return status.String()
 }
 
 // RedisPool returns the redisPool for this backend
-func (b *backend) RedisPool() *redis.Pool {
+func (b *backend) RedisPool() valkey.Pool {
 	return b.rp
 }
+
+var _ courier.ChannelConfigUpdater = (*backend)(nil)
+var _ courier.QualityWriter = (*backend)(nil)
+var _ courier.QueueManager = (*backend)(nil)
+var _ courier.MsgQueuer = (*backend)(nil)
+var _ courier.BulkMsgPopper = (*backend)(nil)
+var _ courier.MsgRequeuer = (*backend)(nil)
+var _ courier.SentMsgRequeuer = (*backend)(nil)
+var _ courier.DeadLetterStore = (*backend)(nil)
+var _ courier.ChannelLogSearcher = (*backend)(nil)
+var _ courier.MsgArchive = (*backend)(nil)
+var _ courier.MediaProcessor = (*backend)(nil)
+var _ courier.HealthChecker = (*backend)(nil)
+var _ courier.ChannelLister = (*backend)(nil)
@@ -1,12 +1,16 @@
 package rapidpro
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -25,7 +29,9 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clamd"
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/utils/transcode"
 	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/gocommon/httpx"
@@ -112,6 +118,11 @@ func (ts *BackendTestSuite) SetupSuite() {
 	ts.clearRedis()
 }
 
+func (ts *BackendTestSuite) SetupTest() {
+	// tests pop the same messages so mustn't see each other's send claims
+	ts.clearRedis()
+}
+
 func (ts *BackendTestSuite) TearDownSuite() {
 	ts.b.Stop()
 	ts.b.Cleanup()
@@ -157,7 +168,7 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 		"high_priority": true,
 		"response_to_external_id": "external-id",
 		"is_resend": true,
-		"metadata": {"topic": "event"}
+		"metadata": {"topic": "event", "url_preview": false}
 	}`
 
 	msg := Msg{}
@@ -169,6 +180,7 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 	ts.Equal("", msg.ExternalID())
 	ts.Equal([]string{"Yes", "No"}, msg.QuickReplies())
 	ts.Equal("event", msg.Topic())
+	ts.Equal(false, *msg.URLPreview())
 	ts.Equal("external-id", msg.ResponseToExternalID())
 	ts.True(msg.HighPriority())
 	ts.True(msg.IsResend())
@@ -197,6 +209,7 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 	ts.Nil(msg.Attachments())
 	ts.Nil(msg.QuickReplies())
 	ts.Equal("", msg.Topic())
+	ts.Nil(msg.URLPreview())
 	ts.Equal("", msg.ResponseToExternalID())
 	ts.False(msg.IsResend())
 	ts.Nil(msg.Flow())
@@ -273,6 +286,59 @@ func (ts *BackendTestSuite) TestContact() {
 
 }
 
+func (ts *BackendTestSuite) TestContactStitching() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeUnknown, knChannel, nil)
+	ctx := context.Background()
+
+	// channel is cached so restore its org config after
+	orgConfig := knChannel.OrgConfig_
+	defer func() { knChannel.OrgConfig_ = orgConfig }()
+
+	// without stitching enabled, a whatsapp URN with the same number as a tel URN gets a new contact
+	unstitched, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("tel:+12065551900"), nil, "", clog)
+	ts.NoError(err)
+	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:12065551900"), nil, "", clog)
+	ts.NoError(err)
+	ts.NotEqual(unstitched.ID_, contact.ID_)
+	ts.True(contact.IsNew_)
+
+	knChannel.OrgConfig_ = null.Map[any]{courier.ConfigStitchPhoneURNs: true}
+
+	// with it enabled, it's added to the existing contact
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:12067799192"), map[string]string{"default": "sesame"}, "", clog)
+	ts.NoError(err)
+	ts.Equal(ContactID(100), contact.ID_)
+	ts.False(contact.IsNew_)
+
+	tx, err := ts.b.db.Beginx()
+	ts.NoError(err)
+	contactURNs, err := getURNsForContact(tx, contact.ID_)
+	ts.NoError(err)
+	ts.Len(contactURNs, 2)
+	tx.Rollback()
+
+	// and found by that URN from now on
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:12067799192"), nil, "", clog)
+	ts.NoError(err)
+	ts.Equal(ContactID(100), contact.ID_)
+
+	// contact already has a whatsapp URN so another one with a matching tel number isn't stitched
+	_, err = ts.b.AddURNtoContact(ctx, knChannel, contact, urns.URN("tel:+12065551700"), nil)
+	ts.NoError(err)
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:12065551700"), nil, "", clog)
+	ts.NoError(err)
+	ts.NotEqual(ContactID(100), contact.ID_)
+	ts.True(contact.IsNew_)
+
+	// short codes are never stitched
+	shortCode, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("tel:+1234"), nil, "", clog)
+	ts.NoError(err)
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:1234"), nil, "", clog)
+	ts.NoError(err)
+	ts.NotEqual(shortCode.ID_, contact.ID_)
+}
+
 func (ts *BackendTestSuite) TestContactRace() {
 	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
 	clog := courier.NewChannelLog(courier.ChannelLogTypeUnknown, knChannel, nil)
@@ -502,7 +568,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 		}
 		err := ts.b.WriteStatusUpdate(ctx, statusObj)
 		ts.NoError(err)
-		time.Sleep(600 * time.Millisecond) // give committer time to write this
+		ts.b.Flush(ctx)
 		return clog
 	}
 
@@ -511,7 +577,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 		statusObj := ts.b.NewStatusUpdateByExternalID(channel, extID, status, clog)
 		err := ts.b.WriteStatusUpdate(ctx, statusObj)
 		ts.NoError(err)
-		time.Sleep(600 * time.Millisecond) // give committer time to write this
+		ts.b.Flush(ctx)
 		return clog
 	}
 
@@ -615,7 +681,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	status := ts.b.NewStatusUpdateByExternalID(channel, "ext1", courier.MsgStatusSent, clog6)
 	err := ts.b.WriteStatusUpdate(ctx, status)
 	ts.NoError(err)
-	time.Sleep(time.Second)
+	ts.b.Flush(ctx)
 
 	// error our msg
 	now = time.Now().In(time.UTC)
@@ -624,7 +690,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	err = ts.b.WriteStatusUpdate(ctx, status)
 	ts.NoError(err)
 
-	time.Sleep(time.Second) // give committer time to write this
+	ts.b.Flush(ctx)
 
 	m = readMsgFromDB(ts.b, 10000)
 	ts.Equal(m.Status_, courier.MsgStatusErrored)
@@ -638,7 +704,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	err = ts.b.WriteStatusUpdate(ctx, status)
 	ts.NoError(err)
 
-	time.Sleep(time.Second) // give committer time to write this
+	ts.b.Flush(ctx)
 
 	m = readMsgFromDB(ts.b, 10000)
 	ts.Equal(m.Status_, courier.MsgStatusErrored)
@@ -649,7 +715,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	status = ts.b.NewStatusUpdateByExternalID(channel, "ext1", courier.MsgStatusErrored, clog6)
 	err = ts.b.WriteStatusUpdate(ctx, status)
 
-	time.Sleep(time.Second) // give committer time to write this
+	ts.b.Flush(ctx)
 
 	ts.NoError(err)
 	m = readMsgFromDB(ts.b, 10000)
@@ -657,6 +723,20 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ErrorCount_, 3)
 	ts.Equal(null.String("E"), m.FailedReason_)
 
+	// number of segments sent is written to msg_count, and left unchanged by statuses without it
+	status = ts.b.NewStatusUpdate(channel, courier.MsgID(10001), courier.MsgStatusWired, clog6)
+	status.SetSegments(3)
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, status))
+	ts.b.Flush(ctx)
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(3, m.MessageCount_)
+
+	updateStatusByID(10001, courier.MsgStatusDelivered, "")
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(3, m.MessageCount_)
+
 	// update URN when the new doesn't exist
 	tx, _ := ts.b.db.BeginTxx(ctx, nil)
 	oldURN := urns.URN("whatsapp:55988776655")
@@ -738,8 +818,7 @@ func (ts *BackendTestSuite) TestSentExternalIDCaching() {
 	err := ts.b.WriteStatusUpdate(ctx, status1)
 	ts.NoError(err)
 
-	// give batcher time to write it
-	time.Sleep(time.Millisecond * 600)
+	ts.b.Flush(ctx)
 
 	keys, err := redis.Strings(rc.Do("KEYS", "sent-external-ids:*"))
 	ts.NoError(err)
@@ -755,8 +834,7 @@ func (ts *BackendTestSuite) TestSentExternalIDCaching() {
 	err = ts.b.WriteStatusUpdate(ctx, status2)
 	ts.NoError(err)
 
-	// give batcher time to write it
-	time.Sleep(time.Millisecond * 700)
+	ts.b.Flush(ctx)
 
 	// msg status successfully updated in the database
 	assertdb.Query(ts.T(), ts.b.db, `SELECT status FROM msgs_msg WHERE id = 10000`).Returns("D")
@@ -925,6 +1003,460 @@ func (ts *BackendTestSuite) TestOutgoingQueue() {
 	ts.False(sent)
 }
 
+func (ts *BackendTestSuite) TestUnreadableOutgoingMsgs() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q' WHERE id IN (10000, 10001)`)
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// queue a message followed by one which can't be unmarshaled
+	err := queue.PushOntoQueueAt(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 0, string(jsonx.MustMarshal([]any{dbMsg})), queue.HighPriority, time.Now().Add(-time.Second))
+	ts.NoError(err)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 0, `[{"id": 10001, "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "text": 123}]`, queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(courier.MsgID(10000), msg.ID())
+
+	more, err := ts.b.PopMoreOutgoingMsgs(ctx, msg, 5)
+	ts.NoError(err)
+	ts.Len(more, 0)
+
+	// rather than disappearing, the unreadable message should have been failed
+	ts.b.Flush(ctx)
+
+	m := readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusFailed, m.Status_)
+}
+
+func (ts *BackendTestSuite) TestPacedOutgoingQueue() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	newPacedMsg := func(id courier.MsgID) *Msg {
+		m := readMsgFromDB(ts.b, id)
+		m.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+		m.Pacing_ = &Pacing{Key: "bcast:1", Count: 100, Duration: 200}
+		m.tps = 10
+		return m
+	}
+
+	// messages without pacing are never deferred
+	deferred, err := deferPacedMsg(r, readMsgFromDB(ts.b, 10000))
+	ts.NoError(err)
+	ts.False(deferred)
+
+	// first message of a paced broadcast can be sent immediately
+	deferred, err = deferPacedMsg(r, newPacedMsg(10000))
+	ts.NoError(err)
+	ts.False(deferred)
+
+	// but the next isn't due for another 2 seconds so is pushed back onto the queue
+	msg := newPacedMsg(10001)
+	deferred, err = deferPacedMsg(r, msg)
+	ts.NoError(err)
+	ts.True(deferred)
+	ts.WithinDuration(time.Now().Add(2*time.Second), *msg.Pacing_.SendAfter, 100*time.Millisecond)
+
+	values, err := queue.Peek(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d|10", 5)
+	ts.NoError(err)
+	ts.Len(values, 1)
+	ts.Contains(values[0], `"send_after":`)
+
+	// once it has been given a slot it won't be deferred again
+	deferred, err = deferPacedMsg(r, msg)
+	ts.NoError(err)
+	ts.False(deferred)
+
+	// and popping isn't possible until it's due
+	popped, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(popped)
+
+	// deferring a paced message from the queue means popping returns nothing
+	msgJSON, err := json.Marshal([]any{newPacedMsg(10000)})
+	ts.NoError(err)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.LowPriority)
+	ts.NoError(err)
+
+	popped, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(popped)
+
+	_, bulkSize, err := queue.Size(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d|10")
+	ts.NoError(err)
+	ts.Equal(2, bulkSize)
+}
+
+func (ts *BackendTestSuite) TestRequeueMsg() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(dbMsg.ID(), msg.ID())
+
+	// channel asks us to try again later
+	err = ts.b.RequeueMsg(ctx, msg, time.Second)
+	ts.NoError(err)
+
+	clog := courier.NewChannelLogForSend(msg, nil)
+	ts.b.OnSendComplete(ctx, msg, ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusQueued, clog), clog)
+
+	// message isn't considered sent or dead
+	sent, err := ts.b.WasMsgSent(ctx, msg.ID())
+	ts.NoError(err)
+	ts.False(sent)
+
+	letters, err := ts.b.DeadLetters(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Len(letters, 0)
+
+	// and can't be popped until its delay has passed
+	popped, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(popped)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	popped, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	if ts.NotNil(popped) {
+		ts.Equal(msg.ID(), popped.ID())
+		ts.Equal("test message", popped.Text())
+	}
+}
+
+func (ts *BackendTestSuite) TestDuplicateSend() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	// queue the same message twice
+	for range 2 {
+		err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+		ts.NoError(err)
+	}
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(dbMsg.ID(), msg.ID())
+
+	// while it's being sent, the second copy is dropped
+	popped, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(popped)
+
+	size, _, err := queue.Size(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d|10")
+	ts.NoError(err)
+	ts.Equal(0, size)
+
+	// once the send completes with an error, the message can be claimed again for its retry
+	clog := courier.NewChannelLogForSend(msg, nil)
+	ts.b.OnSendComplete(ctx, msg, ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusErrored, clog), clog)
+
+	claimed, err := claimMsgSend(r, msg.UUID())
+	ts.NoError(err)
+	ts.True(claimed)
+
+	claimed, err = claimMsgSend(r, msg.UUID())
+	ts.NoError(err)
+	ts.False(claimed)
+
+	ts.NoError(releaseMsgSend(r, msg.UUID()))
+
+	claimed, err = claimMsgSend(r, msg.UUID())
+	ts.NoError(err)
+	ts.True(claimed)
+}
+
+func (ts *BackendTestSuite) TestReplyTracking() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// a message from a flow which is now waiting for a reply
+	msg := readMsgFromDB(ts.b, 10000)
+	msg.URN_ = "tel:+12067799192"
+	msg.Session_ = &courier.Session{UUID: "79c1dbc6-4200-4333-b17a-1f996273a4cb", Status: "W"}
+	ts.True(msg.expectsReply())
+
+	clog := courier.NewChannelLogForSend(msg, nil)
+	ts.b.OnSendComplete(ctx, msg, ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusWired, clog), clog)
+
+	// a message which isn't waiting for a reply isn't tracked
+	msg2 := readMsgFromDB(ts.b, 10001)
+	ts.False(msg2.expectsReply())
+
+	stats := ts.b.stats.Extract()
+	ts.Equal(CountByType{"KN": 1}, stats.RepliesExpected)
+
+	// receiving a message from another URN isn't a reply
+	clog = courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, knChannel, nil)
+	other := ts.b.NewIncomingMsg(knChannel, "tel:+250788383383", "hi", "", clog)
+	ts.b.OnReceiveComplete(ctx, knChannel, []courier.Event{other}, clog)
+
+	// but one from the same URN is
+	reply := ts.b.NewIncomingMsg(knChannel, "tel:+12067799192", "yes", "", clog)
+	ts.b.OnReceiveComplete(ctx, knChannel, []courier.Event{reply}, clog)
+
+	// and only the first reply counts
+	ts.b.OnReceiveComplete(ctx, knChannel, []courier.Event{reply}, clog)
+
+	stats = ts.b.stats.Extract()
+	ts.Equal(CountByType{"KN": 1}, stats.RepliesReceived)
+	ts.Len(stats.ReplyLatency, 1)
+}
+
+func (ts *BackendTestSuite) TestDeadLetters() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(dbMsg.ID(), msg.ID())
+
+	// fail the message permanently
+	clog := courier.NewChannelLogForSend(msg, nil)
+	clog.Error(courier.ErrorResponseStatusCode())
+	ts.b.OnSendComplete(ctx, msg, ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusFailed, clog), clog)
+
+	letters, err := ts.b.DeadLetters(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Len(letters, 1)
+	ts.Equal(msg.ID(), letters[0].MsgID)
+	ts.Equal(clog.UUID, letters[0].LogUUID)
+	ts.Equal("Unexpected response status code.", letters[0].Error)
+
+	// other channels have no dead letters
+	letters, err = ts.b.DeadLetters(ctx, "53e5aafa-8155-449d-9009-fcb30d54bd26")
+	ts.NoError(err)
+	ts.Len(letters, 0)
+
+	// requeue our dead letter, which should no longer be considered sent
+	requeued, err := ts.b.RequeueDeadLetters(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Equal(1, requeued)
+
+	sent, err := ts.b.WasMsgSent(ctx, msg.ID())
+	ts.NoError(err)
+	ts.False(sent)
+
+	letters, err = ts.b.DeadLetters(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Len(letters, 0)
+
+	// and we can pop it off the queue again
+	msg2, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.NotNil(msg2)
+	ts.Equal(msg.ID(), msg2.ID())
+	ts.Equal("test message", msg2.Text())
+}
+
+func (ts *BackendTestSuite) TestArchivedMsgs() {
+	ctx := context.Background()
+
+	// incoming messages and unknown UUIDs can't be read
+	_, err := ts.b.GetArchivedMsg(ctx, "0ee51bf5-b285-4c39-95d6-c85d18b23f1e")
+	ts.Equal(courier.ErrMsgNotFound, err)
+	_, err = ts.b.GetArchivedMsg(ctx, "7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3")
+	ts.Equal(courier.ErrMsgNotFound, err)
+
+	msg, err := ts.b.GetArchivedMsg(ctx, "b10fff91-4ff6-46d4-b237-caed786e09d3")
+	ts.NoError(err)
+	ts.Equal(courier.MsgUUID("b10fff91-4ff6-46d4-b237-caed786e09d3"), msg.UUID)
+	ts.Equal(courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), msg.ChannelUUID)
+	ts.Equal(urns.URN("tel:+12067799192"), msg.URN)
+	ts.Equal("test message", msg.Text)
+	ts.Equal(courier.MsgStatusWired, msg.Status)
+
+	// URNs which don't exist in the workspace can't be resent to
+	_, err = ts.b.ResendArchivedMsg(ctx, "b10fff91-4ff6-46d4-b237-caed786e09d3", "tel:+12065551212")
+	ts.ErrorIs(err, courier.ErrResendURNInvalid)
+
+	// resend to the original URN
+	msg, err = ts.b.ResendArchivedMsg(ctx, "b10fff91-4ff6-46d4-b237-caed786e09d3", urns.NilURN)
+	ts.NoError(err)
+	ts.Equal(urns.URN("tel:+12067799192"), msg.URN)
+
+	out, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.NotNil(out)
+	ts.Equal(courier.MsgID(10000), out.ID())
+	ts.Equal("test message", out.Text())
+	ts.Equal(urns.URN("tel:+12067799192"), out.URN())
+	ts.True(out.IsResend())
+
+	// messages can also be requeued by their ID, but only by their own channel
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.Equal(courier.ErrMsgNotFound, ts.b.RequeueSentMsg(ctx, ts.getChannel("TG", "dbc126ed-66bc-4e28-b67b-81dc3327c98a"), 10000))
+	ts.Equal(courier.ErrMsgNotFound, ts.b.RequeueSentMsg(ctx, knChannel, 123456))
+	ts.NoError(ts.b.RequeueSentMsg(ctx, knChannel, 10000))
+
+	out, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.NotNil(out)
+	ts.Equal(courier.MsgID(10000), out.ID())
+	ts.True(out.IsResend())
+}
+
+func (ts *BackendTestSuite) TestMsgTimeline() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	// incoming messages don't have timelines
+	_, err := ts.b.MsgTimeline(ctx, "0ee51bf5-b285-4c39-95d6-c85d18b23f1e")
+	ts.Equal(courier.ErrMsgNotFound, err)
+
+	dbMsg := readMsgFromDB(ts.b, 10001)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(dbMsg.ID(), msg.ID())
+
+	clog := courier.NewChannelLogForSend(msg, nil)
+	clog.Error(courier.ErrorResponseStatusCode())
+	clog.End()
+	status := ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusErrored, clog)
+	ts.b.OnSendComplete(ctx, msg, status, clog)
+
+	// write the status directly rather than waiting for the batch writer
+	_, err = ts.b.writeStatusUpdatesToDB(ctx, []*StatusUpdate{status.(*StatusUpdate)})
+	ts.NoError(err)
+
+	timeline, err := ts.b.MsgTimeline(ctx, "452adaa9-1e4d-4ff3-a3c6-d3867ff2adfb")
+	ts.NoError(err)
+	ts.Equal(courier.MsgUUID("452adaa9-1e4d-4ff3-a3c6-d3867ff2adfb"), timeline.MsgUUID)
+	ts.Equal(courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), timeline.ChannelUUID)
+	ts.Equal(courier.MsgStatusErrored, timeline.Status)
+
+	// the sender's status update is part of the send attempt
+	if ts.Len(timeline.Events, 3) {
+		ts.Equal(courier.MsgTimelineQueued, timeline.Events[0].Type)
+		ts.Equal(courier.MsgTimelinePopped, timeline.Events[1].Type)
+		ts.Equal(courier.MsgTimelineSendAttempt, timeline.Events[2].Type)
+		ts.Equal(courier.MsgStatusErrored, timeline.Events[2].Status)
+		ts.Equal(clog.UUID, timeline.Events[2].LogUUID)
+		ts.Equal([]*clogs.LogError{courier.ErrorResponseStatusCode()}, timeline.Events[2].Errors)
+	}
+}
+
+func (ts *BackendTestSuite) TestQueueAdmin() {
+	ctx := context.Background()
+	r := ts.b.rp.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg, dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.LowPriority)
+	ts.NoError(err)
+
+	// pause the channel
+	ts.NoError(ts.b.PauseQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", true))
+
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal([]*courier.QueueInfo{{ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", TPS: 10, Size: 0, BulkSize: 1, Paused: true}}, queues)
+
+	pending, err := ts.b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10)
+	ts.NoError(err)
+	ts.Len(pending, 2)
+
+	pending, err = ts.b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 1)
+	ts.NoError(err)
+	ts.Len(pending, 1)
+
+	// nothing can be popped while paused
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	ts.NoError(ts.b.PauseQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", false))
+
+	// can't drain to a channel which doesn't exist
+	_, err = ts.b.DrainQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "a5c7b3d1-6f5e-4c8a-9b2d-1e0f3a4b5c6d")
+	ts.ErrorIs(err, courier.ErrChannelNotFound)
+
+	// pause the channel we're migrating to so nothing gets popped, and drain our queue onto it
+	ts.NoError(ts.b.PauseQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c99a", true))
+
+	drained, err := ts.b.DrainQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "dbc126ed-66bc-4e28-b67b-81dc3327c99a")
+	ts.NoError(err)
+	ts.Equal(1, drained)
+
+	pending, err = ts.b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10)
+	ts.NoError(err)
+	ts.Len(pending, 0)
+
+	pending, err = ts.b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c99a", 10)
+	ts.NoError(err)
+	ts.Len(pending, 2)
+	ts.Contains(string(pending[0]), `"channel_uuid":"dbc126ed-66bc-4e28-b67b-81dc3327c99a"`)
+
+	// drained messages keep the TPS of the queue they came from since the target didn't have one
+	queues, err = ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal([]*courier.QueueInfo{{ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c99a", TPS: 10, Size: 0, BulkSize: 1, Paused: true}}, queues)
+
+	// and are updated in the database to belong to the new channel
+	var channelID courier.ChannelID
+	ts.NoError(ts.b.db.Get(&channelID, `SELECT channel_id FROM msgs_msg WHERE id = 10000`))
+	ts.Equal(courier.ChannelID(14), channelID)
+
+	ts.NoError(ts.b.PauseQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c99a", false))
+
+	purged, err := ts.b.PurgeQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c99a")
+	ts.NoError(err)
+	ts.Equal(1, purged)
+
+	pending, err = ts.b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c99a", 10)
+	ts.NoError(err)
+	ts.Len(pending, 0)
+}
+
 func (ts *BackendTestSuite) TestChannel() {
 	noAddress := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c99a")
 	ts.Equal(i18n.Country("US"), noAddress.Country())
@@ -991,6 +1523,21 @@ func (ts *BackendTestSuite) TestChannel() {
 	ts.False(exChannel2.HasRole(courier.ChannelRoleAnswer))
 }
 
+func (ts *BackendTestSuite) TestGetChannelsByType() {
+	ctx := context.Background()
+
+	channels, err := ts.b.GetChannelsByType(ctx, courier.ChannelType("KN"))
+	ts.NoError(err)
+	if ts.Len(channels, 2) {
+		ts.Equal(courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), channels[0].UUID())
+		ts.Equal(courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c99a"), channels[1].UUID())
+	}
+
+	channels, err = ts.b.GetChannelsByType(ctx, courier.ChannelType("XX"))
+	ts.NoError(err)
+	ts.Len(channels, 0)
+}
+
 func (ts *BackendTestSuite) TestGetChannel() {
 	ctx := context.Background()
 
@@ -1018,6 +1565,38 @@ func (ts *BackendTestSuite) TestGetChannel() {
 	ts.Assert().True(ch == nil) // https://github.com/stretchr/testify/issues/503
 }
 
+func (ts *BackendTestSuite) TestUpdateChannelConfig() {
+	ctx := context.Background()
+
+	uuid := courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c99a")
+
+	ch, err := ts.b.GetChannel(ctx, courier.AnyChannelType, uuid)
+	ts.NoError(err)
+
+	err = ts.b.UpdateChannelConfig(ctx, ch, map[string]any{"auth_token": "sesame"})
+	ts.NoError(err)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT config->>'auth_token' FROM channels_channel WHERE uuid = $1`, uuid).Returns("sesame")
+
+	// cached channel should have been replaced
+	updated, err := ts.b.GetChannel(ctx, courier.AnyChannelType, uuid)
+	ts.NoError(err)
+	ts.Equal("sesame", updated.StringConfigForKey(courier.ConfigAuthToken, ""))
+
+	// trying to update using the stale channel is a conflict
+	err = ts.b.UpdateChannelConfig(ctx, ch, map[string]any{"auth_token": "open"})
+	ts.Equal(courier.ErrChannelConfigConflict, err)
+
+	// but updated channel can be updated again
+	err = ts.b.UpdateChannelConfig(ctx, updated, map[string]any{"secret": "xyz"})
+	ts.NoError(err)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT config->>'secret' AS secret, config->>'auth_token' AS auth_token FROM channels_channel WHERE uuid = $1`, uuid).Columns(map[string]any{"secret": "xyz", "auth_token": "sesame"})
+
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = '{}' WHERE uuid = $1`, uuid)
+	ts.b.channelsByUUID.Clear()
+}
+
 func (ts *BackendTestSuite) TestWriteChanneLog() {
 	ctx := context.Background()
 	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
@@ -1046,7 +1625,7 @@ func (ts *BackendTestSuite) TestWriteChanneLog() {
 	err = ts.b.WriteChannelLog(ctx, clog1)
 	ts.NoError(err)
 
-	time.Sleep(time.Second) // give writer time to write this
+	ts.b.Flush(ctx)
 
 	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM channels_channellog`).Returns(1)
 	assertdb.Query(ts.T(), ts.b.db, `SELECT channel_id, http_logs->0->>'url' AS url, errors->0->>'message' AS err FROM channels_channellog`).
@@ -1068,7 +1647,7 @@ func (ts *BackendTestSuite) TestWriteChanneLog() {
 	err = ts.b.WriteChannelLog(ctx, clog2)
 	ts.NoError(err)
 
-	time.Sleep(time.Second) // give writer time to write this
+	ts.b.Flush(ctx)
 
 	// check that we can read the log back from DynamoDB
 	actualLog = &clogs.Log{}
@@ -1086,7 +1665,7 @@ func (ts *BackendTestSuite) TestWriteChanneLog() {
 	clog3.HTTP(trace)
 	ts.NoError(ts.b.WriteChannelLog(ctx, clog3))
 
-	time.Sleep(time.Second) // give writer time to.. not write this
+	ts.b.Flush(ctx)
 
 	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM channels_channellog`).Returns(0)
 
@@ -1095,7 +1674,7 @@ func (ts *BackendTestSuite) TestWriteChanneLog() {
 	clog4.Error(courier.ErrorResponseStatusCode())
 	ts.NoError(ts.b.WriteChannelLog(ctx, clog4))
 
-	time.Sleep(time.Second) // give writer time to write this because it's an error
+	ts.b.Flush(ctx)
 
 	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM channels_channellog`).Returns(1)
 
@@ -1107,9 +1686,45 @@ func (ts *BackendTestSuite) TestWriteChanneLog() {
 	clog5.Error(courier.ErrorResponseStatusCode())
 	ts.NoError(ts.b.WriteChannelLog(ctx, clog5))
 
-	time.Sleep(time.Second) // give writer time to.. not write this
+	ts.b.Flush(ctx)
 
 	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM channels_channellog`).Returns(1)
+
+	// logs which weren't discarded are also in the recent logs of their channel, newest first
+	recentUUIDs := func(query *courier.ChannelLogQuery) []clogs.LogUUID {
+		logs, err := ts.b.RecentChannelLogs(ctx, channel.UUID(), query)
+		ts.NoError(err)
+		uuids := make([]clogs.LogUUID, len(logs))
+		for i, l := range logs {
+			uuids[i] = l.UUID
+		}
+		return uuids
+	}
+
+	ts.Equal([]clogs.LogUUID{clog4.UUID, clog2.UUID, clog1.UUID}, recentUUIDs(&courier.ChannelLogQuery{Limit: 10}))
+	ts.Equal([]clogs.LogUUID{clog4.UUID, clog2.UUID}, recentUUIDs(&courier.ChannelLogQuery{Limit: 2}))
+	ts.Equal([]clogs.LogUUID{clog4.UUID, clog2.UUID}, recentUUIDs(&courier.ChannelLogQuery{Type: courier.ChannelLogTypeMsgSend, Limit: 10}))
+	ts.Equal([]clogs.LogUUID{clog4.UUID, clog1.UUID}, recentUUIDs(&courier.ChannelLogQuery{ErrorsOnly: true, Limit: 10}))
+	ts.Equal([]clogs.LogUUID{}, recentUUIDs(&courier.ChannelLogQuery{After: time.Now(), Limit: 10}))
+
+	// channels can be configured to only write a sample of their logs
+	channel.LogPolicy = LogPolicyAll
+	channel.Config_["log_sampling"] = map[string]any{"success_rate": 0.0, "error_rate": 1.0}
+
+	clog6 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+	clog6.HTTP(trace)
+	ts.NoError(ts.b.WriteChannelLog(ctx, clog6))
+
+	clog7 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+	clog7.HTTP(trace)
+	clog7.Error(courier.ErrorResponseStatusCode())
+	ts.NoError(ts.b.WriteChannelLog(ctx, clog7))
+
+	ts.b.Flush(ctx)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM channels_channellog`).Returns(2)
+	ts.Equal([]clogs.LogUUID{clog7.UUID, clog4.UUID}, recentUUIDs(&courier.ChannelLogQuery{ErrorsOnly: true, Limit: 2}))
+	ts.Equal(clog7.UUID, recentUUIDs(&courier.ChannelLogQuery{Limit: 1})[0])
 }
 
 func (ts *BackendTestSuite) TestSaveAttachment() {
@@ -1121,9 +1736,152 @@ func (ts *BackendTestSuite) TestSaveAttachment() {
 	defer uuids.SetGenerator(uuids.DefaultGenerator)
 	uuids.SetGenerator(uuids.NewSeededGenerator(1234, time.Now))
 
+	ts.b.stats.Extract()
+
 	newURL, err := ts.b.SaveAttachment(ctx, knChannel, "image/jpeg", testJPG, "jpg")
 	ts.NoError(err)
 	ts.Equal("http://localhost:9000/test-attachments/attachments/1/c00e/5d67/c00e5d67-c275-4389-aded-7d8b151cbd5b.jpg", newURL)
+
+	// saving the same content again, e.g. a forwarded image, reuses the stored attachment
+	dupeURL, err := ts.b.SaveAttachment(ctx, knChannel, "image/jpeg", testJPG, "jpg")
+	ts.NoError(err)
+	ts.Equal(newURL, dupeURL)
+	ts.Equal(CountByType{"KN": 1}, ts.b.stats.Extract().AttachmentsDeduplicated)
+
+	// but not if it has a different content type
+	otherURL, err := ts.b.SaveAttachment(ctx, knChannel, "application/octet-stream", testJPG, "bin")
+	ts.NoError(err)
+	ts.NotEqual(newURL, otherURL)
+	ts.True(strings.HasSuffix(otherURL, ".bin"))
+}
+
+func (ts *BackendTestSuite) TestSaveAttachmentScanned() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// a fake clamd which reads the streamed attachment and then reports it as infected
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	ts.Require().NoError(err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.ReadFull(conn, make([]byte, 10+4+5+4)) // zINSTREAM, then a chunk of 5 bytes and the terminating chunk
+			conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			conn.Close()
+		}
+	}()
+
+	ts.b.scanner = clamd.NewClient(ln.Addr().String(), time.Second)
+	defer func() { ts.b.scanner = nil }()
+
+	ts.b.stats.Extract()
+
+	_, err = ts.b.SaveAttachment(ctx, knChannel, "text/plain", []byte("EICAR"), "txt")
+	ts.Equal(&courier.AttachmentInfectedError{Signature: "Eicar-Signature"}, err)
+	ts.Equal(CountByType{"KN": 1}, ts.b.stats.Extract().AttachmentsInfected)
+
+	// if clamd can't be reached, we error rather than store an unscanned attachment
+	ln.Close()
+
+	_, err = ts.b.SaveAttachment(ctx, knChannel, "text/plain", []byte("EICAR"), "txt")
+	ts.ErrorContains(err, "error scanning attachment")
+}
+
+func (ts *BackendTestSuite) TestTranscodeMedia() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	voiceWAV := &Media{UUID_: "4a4d4b6c-1f42-4d5e-9c4e-2a8e0e4d3b21", ContentType_: "audio/wav", URL_: "http://nyaruka.s3.com/orgs/1/media/4a4d/4a4d4b6c-1f42-4d5e-9c4e-2a8e0e4d3b21/voice.wav", Size_: 11, Duration_: 5}
+
+	// transcoding isn't enabled
+	transcoded, err := ts.b.TranscodeMedia(ctx, knChannel, voiceWAV, "audio/ogg")
+	ts.NoError(err)
+	ts.Nil(transcoded)
+
+	// a fake ffmpeg which just echoes back its input
+	ffmpeg := ts.T().TempDir() + "/ffmpeg"
+	ts.Require().NoError(os.WriteFile(ffmpeg, []byte("#!/bin/sh\nprintf 'OGG:'; cat"), 0755))
+
+	ts.b.transcoder = transcode.NewTranscoder(ffmpeg, time.Second)
+	defer func() { ts.b.transcoder = nil }()
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		voiceWAV.URL(): {
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "audio/wav"}, []byte(`WAV content`)),
+		},
+	}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	// can't transcode to types we don't know how to write
+	transcoded, err = ts.b.TranscodeMedia(ctx, knChannel, voiceWAV, "audio/wav")
+	ts.NoError(err)
+	ts.Nil(transcoded)
+
+	transcoded, err = ts.b.TranscodeMedia(ctx, knChannel, voiceWAV, "audio/ogg")
+	ts.NoError(err)
+	if ts.NotNil(transcoded) {
+		ts.Equal("audio/ogg", transcoded.ContentType())
+		ts.Equal(15, transcoded.Size())
+		ts.Equal(5, transcoded.Duration())
+		ts.True(strings.HasSuffix(transcoded.URL(), ".ogg"))
+	}
+
+	// second request for the same transcoding is read from the cache, without fetching the source again
+	cached, err := ts.b.TranscodeMedia(ctx, knChannel, voiceWAV, "audio/ogg")
+	ts.NoError(err)
+	ts.Equal(transcoded, cached)
+
+	// if the source can't be fetched, we error
+	otherWAV := &Media{UUID_: "5b5e5c7d-2a53-4e6f-8d5f-3b9f1f5e4c32", ContentType_: "audio/wav", URL_: "http://nyaruka.s3.com/orgs/1/media/5b5e/5b5e5c7d-2a53-4e6f-8d5f-3b9f1f5e4c32/other.wav"}
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		otherWAV.URL(): {httpx.NewMockResponse(404, nil, []byte(`not found`))},
+	}))
+
+	_, err = ts.b.TranscodeMedia(ctx, knChannel, otherWAV, "audio/ogg")
+	ts.EqualError(err, "error transcoding media: error fetching media to transcode: received non-200 response: 404")
+}
+
+func (ts *BackendTestSuite) TestResizeImage() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	srcPNG := &bytes.Buffer{}
+	ts.Require().NoError(png.Encode(srcPNG, src))
+
+	bigPNG := &Media{UUID_: "6c6f6d8e-3b64-4f70-9e60-4c0a2a6f5d43", ContentType_: "image/png", URL_: "http://nyaruka.s3.com/orgs/1/media/6c6f/6c6f6d8e-3b64-4f70-9e60-4c0a2a6f5d43/big.png", Size_: srcPNG.Len(), Width_: 400, Height_: 300}
+
+	// only images can be resized
+	resized, err := ts.b.ResizeImage(ctx, knChannel, &Media{ContentType_: "audio/mp3", URL_: "http://nyaruka.s3.com/test.mp3"}, 100, 100, 0)
+	ts.NoError(err)
+	ts.Nil(resized)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		bigPNG.URL(): {
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/png"}, srcPNG.Bytes()),
+		},
+	}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	resized, err = ts.b.ResizeImage(ctx, knChannel, bigPNG, 200, 200, 0)
+	ts.NoError(err)
+	if ts.NotNil(resized) {
+		ts.Equal("image/jpeg", resized.ContentType())
+		ts.Equal(200, resized.Width())
+		ts.Equal(150, resized.Height())
+		ts.True(strings.HasSuffix(resized.URL(), ".jpg"))
+	}
+
+	// resizing again with the same limits, e.g. when the message is resent, reuses the stored image
+	cached, err := ts.b.ResizeImage(ctx, knChannel, bigPNG, 200, 200, 0)
+	ts.NoError(err)
+	ts.Equal(resized, cached)
 }
 
 func (ts *BackendTestSuite) TestWriteMsg() {
@@ -1206,6 +1964,13 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	err = writeMsgToDB(ctx, ts.b, msg, clog)
 	ts.NoError(err)
 
+	// msg with structured metadata
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "", "ext789", clog).WithAttachment("geo:1.000000,2.000000").WithMetadata(json.RawMessage(`{"location": {"name": "Home"}}`)).(*Msg)
+	err = writeMsgToDB(ctx, ts.b, msg, clog)
+	ts.NoError(err)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT metadata FROM msgs_msg WHERE id = $1`, msg.ID()).Returns(`{"location": {"name": "Home"}}`)
+
 	ts.clearRedis()
 
 	// check that our mailroom queue has an item
@@ -1261,6 +2026,25 @@ func (ts *BackendTestSuite) TestWriteMsgWithAttachments() {
 	err = ts.b.WriteMsg(ctx, msg, clog)
 	ts.EqualError(err, "unable to decode attachment data: illegal base64 data at input byte 16")
 
+	// try several embedded attachments, one of which is invalid
+	clog = courier.NewChannelLog(courier.ChannelLogTypeUnknown, knChannel, nil)
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "several embedded attachments", "", clog).(*Msg)
+	msg.WithAttachment(fmt.Sprintf("data:%s", base64.StdEncoding.EncodeToString(test.ReadFile("../../test/testdata/test.jpg"))))
+	msg.WithAttachment("data:34564363576573573")
+	msg.WithAttachment("http://example.com/test.m4a")
+	msg.WithAttachment(fmt.Sprintf("data:%s", base64.StdEncoding.EncodeToString(test.ReadFile("../../test/testdata/test.jpg"))))
+
+	// valid attachments should be saved in their original order and the invalid one dropped, and since the image
+	// has already been stored, it's reused rather than stored again
+	err = ts.b.WriteMsg(ctx, msg, clog)
+	ts.NoError(err)
+	ts.Equal([]string{
+		"image/jpeg:http://localhost:9000/test-attachments/attachments/1/9b95/5e36/9b955e36-ac16-4c6b-8ab6-9b9af5cd042a.jpg",
+		"http://example.com/test.m4a",
+		"image/jpeg:http://localhost:9000/test-attachments/attachments/1/9b95/5e36/9b955e36-ac16-4c6b-8ab6-9b9af5cd042a.jpg",
+	}, msg.Attachments())
+	ts.Equal([]*clogs.LogError{courier.ErrorAttachmentNotDecodable()}, clog.Errors)
+
 	// try a geo attachment
 	msg = ts.b.NewIncomingMsg(knChannel, urn, "geo attachment", "", clog).(*Msg)
 	msg.WithAttachment("geo:123.234,-45.676")
@@ -1333,6 +2117,18 @@ func (ts *BackendTestSuite) TestChannelEvent() {
 	ts.Equal(dbE.EventType_, courier.EventTypeOptIn)
 	ts.Equal(map[string]string{"title": "Polls", "payload": "1"}, dbE.Extra())
 	ts.Equal(null.Int(1), dbE.OptInID_)
+
+	ts.clearRedis()
+
+	// events which mailroom can't handle are recorded but not queued to it
+	event = ts.b.NewChannelEvent(channel, courier.EventTypeURNDeactivated, urn, clog)
+	err = ts.b.WriteChannelEvent(ctx, event, clog)
+	ts.NoError(err)
+
+	dbE = event.(*ChannelEvent)
+	dbE = readChannelEventFromDB(ts.b, dbE.ID_)
+	ts.Equal(dbE.EventType_, courier.EventTypeURNDeactivated)
+	ts.assertNoQueuedContactTask(contact.ID_)
 }
 
 func (ts *BackendTestSuite) TestSessionTimeout() {
@@ -3,12 +3,15 @@ package rapidpro
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"maps"
 	"strconv"
 	"strings"
 
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null/v3"
 )
@@ -37,6 +40,9 @@ type Channel struct {
 
 	OrgConfig_ null.Map[any] `db:"org_config"`
 	OrgIsAnon_ bool          `db:"org_is_anon"`
+
+	// config values inherited from deployment and org level defaults for this channel's type
+	defaults map[string]any
 }
 
 func (c *Channel) ID() courier.ChannelID            { return c.ID_ }
@@ -84,15 +90,32 @@ func (c *Channel) HasRole(role courier.ChannelRole) bool {
 	return false
 }
 
-// ConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+// ConfigForKey returns the config value for the passed in key, falling back to any default for this channel's type, or
+// defaultValue if it isn't found
 func (c *Channel) ConfigForKey(key string, defaultValue any) any {
 	value, found := c.Config_[key]
 	if !found {
-		return defaultValue
+		value, found = c.defaults[key]
+		if !found {
+			return defaultValue
+		}
 	}
 	return value
 }
 
+// inheritDefaults sets the default config values for this channel from the given deployment level defaults for its
+// type, overridden by any org level defaults for its type
+func (c *Channel) inheritDefaults(deploymentDefaults map[courier.ChannelType]map[string]any) {
+	c.defaults = make(map[string]any)
+
+	maps.Copy(c.defaults, deploymentDefaults[c.ChannelType_])
+
+	orgDefaults, _ := c.OrgConfigForKey(courier.ConfigChannelDefaults, nil).(map[string]any)
+	if typeDefaults, isMap := orgDefaults[string(c.ChannelType_)].(map[string]any); isMap {
+		maps.Copy(c.defaults, typeDefaults)
+	}
+}
+
 // OrgConfigForKey returns the org config value for the passed in key, or defaultValue if it isn't found
 func (c *Channel) OrgConfigForKey(key string, defaultValue any) any {
 	value, found := c.OrgConfig_[key]
@@ -172,8 +195,12 @@ func (b *backend) loadChannelByUUID(ctx context.Context, uuid courier.ChannelUUI
 
 	if err == sql.ErrNoRows {
 		return nil, courier.ErrChannelNotFound
+	} else if err != nil {
+		return nil, err
 	}
-	return channel, err
+
+	channel.inheritDefaults(b.channelDefaults)
+	return channel, nil
 }
 
 const sqlLookupChannelFromAddress = `
@@ -201,6 +228,67 @@ func (b *backend) loadChannelByAddress(ctx context.Context, address courier.Chan
 
 	if err == sql.ErrNoRows {
 		return nil, courier.ErrChannelNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	channel.inheritDefaults(b.channelDefaults)
+	return channel, nil
+}
+
+const sqlSelectChannelsByType = `
+SELECT
+	c.uuid,
+	c.org_id,
+	c.id,
+	c.channel_type,
+	c.name,
+	c.schemes,
+	c.address,
+	c.country,
+	c.config,
+	c.role,
+	c.log_policy,
+	o.config AS org_config,
+	o.is_anon AS org_is_anon
+  FROM channels_channel c
+  JOIN orgs_org o ON c.org_id = o.id
+ WHERE c.channel_type = $1 AND c.is_active = TRUE AND c.org_id IS NOT NULL
+ ORDER BY c.id`
+
+func (b *backend) loadChannelsByType(ctx context.Context, typ courier.ChannelType) ([]*Channel, error) {
+	var channels []*Channel
+	if err := b.db.SelectContext(ctx, &channels, sqlSelectChannelsByType, typ); err != nil {
+		return nil, err
 	}
-	return channel, err
+
+	for _, ch := range channels {
+		ch.inheritDefaults(b.channelDefaults)
+	}
+	return channels, nil
+}
+
+// config is only updated if it still matches the config the channel was loaded with
+const sqlUpdateChannelConfig = `
+UPDATE channels_channel
+   SET config = config || $3::jsonb
+ WHERE uuid = $1 AND is_active = TRUE AND config = COALESCE($2::jsonb, '{}'::jsonb)`
+
+// updates the config of the given channel, returning a copy of the channel with its new config
+func (b *backend) updateChannelConfig(ctx context.Context, ch *Channel, updates map[string]any) (*Channel, error) {
+	res, err := b.db.ExecContext(ctx, sqlUpdateChannelConfig, ch.UUID_, ch.Config_, jsonx.MustMarshal(updates))
+	if err != nil {
+		return nil, fmt.Errorf("error updating channel config: %w", err)
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, courier.ErrChannelConfigConflict
+	}
+
+	updated := *ch
+	updated.Config_ = make(null.Map[any], len(ch.Config_)+len(updates))
+	maps.Copy(updated.Config_, ch.Config_)
+	maps.Copy(updated.Config_, updates)
+
+	return &updated, nil
 }
@@ -138,6 +138,16 @@ INSERT INTO
 				   VALUES(:org_id, :channel_id, :contact_id, :contact_urn_id, :event_type, :optin_id, :extra, :occurred_on,      NOW(), 'P',    :log_uuids)
 RETURNING id, created_on`
 
+// the types of channel event which mailroom has handlers for, other types are only recorded in the db
+var mailroomEventTypes = map[courier.ChannelEventType]bool{
+	courier.EventTypeNewConversation: true,
+	courier.EventTypeReferral:        true,
+	courier.EventTypeStopContact:     true,
+	courier.EventTypeWelcomeMessage:  true,
+	courier.EventTypeOptIn:           true,
+	courier.EventTypeOptOut:          true,
+}
+
 // writeChannelEventToDB writes the passed in channel event to our db
 func writeChannelEventToDB(ctx context.Context, b *backend, e *ChannelEvent, clog *courier.ChannelLog) error {
 	// grab the contact for this event
@@ -162,6 +172,11 @@ func writeChannelEventToDB(ctx context.Context, b *backend, e *ChannelEvent, clo
 		return err
 	}
 
+	// mailroom can't handle other types of events so there's no point queueing them
+	if !mailroomEventTypes[e.EventType_] {
+		return nil
+	}
+
 	// queue it up for handling by RapidPro
 	rc := b.rp.Get()
 	defer rc.Close()
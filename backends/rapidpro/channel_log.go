@@ -12,11 +12,13 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/batch"
+	"github.com/nyaruka/courier/utils/clock"
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/aws/dynamo"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/jsonx"
-	"github.com/nyaruka/gocommon/syncx"
+	"github.com/nyaruka/gocommon/random"
 )
 
 const sqlInsertChannelLog = `
@@ -46,10 +48,26 @@ func queueChannelLog(b *backend, clog *courier.ChannelLog) {
 		return
 	}
 
+	// channels can also be configured to only write a sample of their logs
+	if !logSamplingForChannel(dbChan).sample(isError) {
+		log.Debug("channel log discarded by sampling")
+		return
+	}
+
+	if retention := logRetentionForChannel(dbChan); retention > 0 {
+		clog.SetRetention(retention)
+	}
+
 	if b.dyLogWriter.Queue(clog.Log) <= 0 {
 		log.With("storage", "dynamo").Error("channel log writer buffer full")
 	}
 
+	rc := b.rp.Get()
+	if err := pushRecentLog(rc, clog); err != nil {
+		log.Error("error buffering recent channel log", "error", err)
+	}
+	rc.Close()
+
 	// if log is not attached to a call or message, need to write it to the database so that it is retrievable
 	if !clog.Attached() {
 		v := &dbChannelLog{
@@ -70,23 +88,73 @@ func queueChannelLog(b *backend, clog *courier.ChannelLog) {
 	log.Debug("channel log queued")
 }
 
+// logSampling controls the fraction of a channel's logs which are written, separately for those with and without errors
+type logSampling struct {
+	SuccessRate float64 `json:"success_rate"`
+	ErrorRate   float64 `json:"error_rate"`
+}
+
+// the default is to write every log
+var defaultLogSampling = logSampling{SuccessRate: 1, ErrorRate: 1}
+
+// returns the log sampling for the given channel, which can be overridden by its log_sampling config value
+func logSamplingForChannel(channel *Channel) logSampling {
+	sampling := defaultLogSampling
+
+	config, isMap := channel.ConfigForKey(courier.ConfigLogSampling, nil).(map[string]any)
+	if !isMap {
+		return sampling
+	}
+
+	if v, isNum := config["success_rate"].(float64); isNum && v >= 0 && v <= 1 {
+		sampling.SuccessRate = v
+	}
+	if v, isNum := config["error_rate"].(float64); isNum && v >= 0 && v <= 1 {
+		sampling.ErrorRate = v
+	}
+	return sampling
+}
+
+// returns whether a log with or without errors should be written
+func (s logSampling) sample(isError bool) bool {
+	rate := s.SuccessRate
+	if isError {
+		rate = s.ErrorRate
+	}
+	return rate >= 1 || random.Float64() < rate
+}
+
+// longest a channel can be configured to keep its logs for
+const maxLogRetention = 30 * 24 * time.Hour
+
+// returns how long the given channel's logs should be kept for, or zero for the default
+func logRetentionForChannel(channel *Channel) time.Duration {
+	days := channel.IntConfigForKey(courier.ConfigLogRetention, 0)
+	if days <= 0 {
+		return 0
+	}
+	return min(time.Duration(days)*24*time.Hour, maxLogRetention)
+}
+
 type DBLogWriter struct {
-	*syncx.Batcher[*dbChannelLog]
+	*batch.Batcher[*dbChannelLog]
 }
 
-func NewDBLogWriter(db *sqlx.DB, wg *sync.WaitGroup) *DBLogWriter {
+func NewDBLogWriter(db *sqlx.DB, clk clock.Clock, wg *sync.WaitGroup) *DBLogWriter {
 	return &DBLogWriter{
-		Batcher: syncx.NewBatcher(func(batch []*dbChannelLog) {
+		Batcher: batch.NewBatcher(func(batch []*dbChannelLog) int {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 			defer cancel()
 
-			writeDBChannelLogs(ctx, db, batch)
-		}, 1000, time.Millisecond*500, 1000, wg),
+			return writeDBChannelLogs(ctx, db, batch)
+		}, 1000, time.Millisecond*500, 1000, clk, wg),
 	}
 }
 
-func writeDBChannelLogs(ctx context.Context, db *sqlx.DB, batch []*dbChannelLog) {
+// writes the given batch of logs to the database, returning how many couldn't be written
+func writeDBChannelLogs(ctx context.Context, db *sqlx.DB, batch []*dbChannelLog) int {
 	err := dbutil.BulkQuery(ctx, db, sqlInsertChannelLog, batch)
+	failed := 0
 
 	// if we received an error, try again one at a time (in case it is one value hanging us up)
 	if err != nil {
@@ -101,35 +169,41 @@ func writeDBChannelLogs(ctx context.Context, db *sqlx.DB, batch []*dbChannelLog)
 				}
 
 				log.Error("error writing channel log", "error", err)
+				failed++
 			}
 		}
 	}
+	return failed
 }
 
 type DynamoLogWriter struct {
-	*syncx.Batcher[*clogs.Log]
+	*batch.Batcher[*clogs.Log]
 }
 
-func NewDynamoLogWriter(dy *dynamo.Service, wg *sync.WaitGroup) *DynamoLogWriter {
+func NewDynamoLogWriter(dy *dynamo.Service, clk clock.Clock, wg *sync.WaitGroup) *DynamoLogWriter {
 	return &DynamoLogWriter{
-		Batcher: syncx.NewBatcher(func(batch []*clogs.Log) {
+		Batcher: batch.NewBatcher(func(batch []*clogs.Log) int {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 			defer cancel()
 
-			if err := writeDynamoChannelLogs(ctx, dy, batch); err != nil {
+			unprocessed, err := writeDynamoChannelLogs(ctx, dy, batch)
+			if err != nil {
 				slog.Error("error writing logs to dynamo", "error", err)
+				return len(batch)
 			}
-		}, 25, time.Millisecond*500, 1000, wg),
+			return unprocessed
+		}, 25, time.Millisecond*500, 1000, clk, wg),
 	}
 }
 
-func writeDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, batch []*clogs.Log) error {
+// writes the given batch of logs to dynamo, returning how many dynamo didn't process
+func writeDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, batch []*clogs.Log) (int, error) {
 	writeReqs := make([]types.WriteRequest, len(batch))
 
 	for i, l := range batch {
 		d, err := l.MarshalDynamo()
 		if err != nil {
-			return fmt.Errorf("error marshalling log for dynamo: %w", err)
+			return 0, fmt.Errorf("error marshalling log for dynamo: %w", err)
 		}
 		writeReqs[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: d}}
 	}
@@ -138,11 +212,16 @@ func writeDynamoChannelLogs(ctx context.Context, ds *dynamo.Service, batch []*cl
 		RequestItems: map[string][]types.WriteRequest{ds.TableName("ChannelLogs"): writeReqs},
 	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	unprocessed := 0
+	for _, reqs := range resp.UnprocessedItems {
+		unprocessed += len(reqs)
 	}
-	if len(resp.UnprocessedItems) > 0 {
+	if unprocessed > 0 {
 		// TODO shouldn't happend.. but need to figure out how we would retry these
-		slog.Error("unprocessed items writing logs to dynamo", "count", len(resp.UnprocessedItems))
+		slog.Error("unprocessed items writing logs to dynamo", "count", unprocessed)
 	}
-	return nil
+	return unprocessed, nil
 }
@@ -0,0 +1,67 @@
+package rapidpro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/random"
+	"github.com/nyaruka/null/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSamplingForChannel(t *testing.T) {
+	tcs := []struct {
+		config   map[string]any
+		expected logSampling
+	}{
+		{nil, logSampling{SuccessRate: 1, ErrorRate: 1}},
+		{map[string]any{"log_sampling": "foo"}, logSampling{SuccessRate: 1, ErrorRate: 1}},
+		{map[string]any{"log_sampling": map[string]any{"success_rate": 0.01}}, logSampling{SuccessRate: 0.01, ErrorRate: 1}},
+		{map[string]any{"log_sampling": map[string]any{"success_rate": 0.0, "error_rate": 0.5}}, logSampling{SuccessRate: 0, ErrorRate: 0.5}},
+		{map[string]any{"log_sampling": map[string]any{"success_rate": 2.0, "error_rate": "x"}}, logSampling{SuccessRate: 1, ErrorRate: 1}},
+	}
+
+	for _, tc := range tcs {
+		channel := &Channel{Config_: null.Map[any](tc.config)}
+		assert.Equal(t, tc.expected, logSamplingForChannel(channel), "log sampling mismatch for config %v", tc.config)
+	}
+}
+
+func TestLogSampling(t *testing.T) {
+	defer random.SetGenerator(random.DefaultGenerator)
+	random.SetGenerator(random.NewSeededGenerator(123))
+
+	assert.True(t, defaultLogSampling.sample(false))
+	assert.True(t, defaultLogSampling.sample(true))
+
+	sampling := logSampling{SuccessRate: 0.1, ErrorRate: 1}
+
+	sampled := 0
+	for range 1000 {
+		if sampling.sample(false) {
+			sampled++
+		}
+		assert.True(t, sampling.sample(true))
+	}
+	assert.InDelta(t, 100, sampled, 30)
+
+	assert.False(t, logSampling{SuccessRate: 0, ErrorRate: 0}.sample(true))
+}
+
+func TestLogRetentionForChannel(t *testing.T) {
+	tcs := []struct {
+		config   map[string]any
+		expected time.Duration
+	}{
+		{nil, 0},
+		{map[string]any{"log_retention": 3.0}, 3 * 24 * time.Hour},
+		{map[string]any{"log_retention": "14"}, 14 * 24 * time.Hour},
+		{map[string]any{"log_retention": 365.0}, 30 * 24 * time.Hour},
+		{map[string]any{"log_retention": -1.0}, 0},
+	}
+
+	for _, tc := range tcs {
+		channel := &Channel{Config_: null.Map[any](tc.config)}
+		assert.Equal(t, tc.expected, logRetentionForChannel(channel), "log retention mismatch for config %v", tc.config)
+	}
+}
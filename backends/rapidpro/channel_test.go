@@ -0,0 +1,51 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/null/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelInheritDefaults(t *testing.T) {
+	deploymentDefaults := map[courier.ChannelType]map[string]any{
+		"EX": {"callback_domain": "courier.example.com", "max_length": 160.0},
+		"T":  {"callback_domain": "twilio.example.com"},
+	}
+
+	channel := &Channel{
+		ChannelType_: "EX",
+		Config_:      null.Map[any]{"max_length": 320.0},
+		OrgConfig_: null.Map[any]{
+			"channel_defaults": map[string]any{
+				"EX": map[string]any{"retry_policy": map[string]any{"max_retries": 5.0}, "callback_domain": "org.example.com"},
+				"T":  map[string]any{"username": "bob"},
+			},
+		},
+	}
+	channel.inheritDefaults(deploymentDefaults)
+
+	// channel config overrides all defaults
+	assert.Equal(t, 320, channel.IntConfigForKey(courier.ConfigMaxLength, 0))
+
+	// org defaults override deployment defaults
+	assert.Equal(t, "org.example.com", channel.CallbackDomain("localhost"))
+	assert.Equal(t, 5, retryPolicyForChannel(channel).MaxRetries)
+
+	// defaults for other channel types aren't inherited
+	assert.Equal(t, "", channel.StringConfigForKey(courier.ConfigUsername, ""))
+
+	// channel without org defaults just inherits deployment defaults
+	channel = &Channel{ChannelType_: "T", Config_: null.Map[any]{}}
+	channel.inheritDefaults(deploymentDefaults)
+
+	assert.Equal(t, "twilio.example.com", channel.CallbackDomain("localhost"))
+	assert.Equal(t, 0, channel.IntConfigForKey(courier.ConfigMaxLength, 0))
+
+	// and channel with no defaults at all
+	channel = &Channel{ChannelType_: "KN"}
+	channel.inheritDefaults(nil)
+
+	assert.Equal(t, "localhost", channel.CallbackDomain("localhost"))
+}
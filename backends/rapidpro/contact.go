@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/urns"
@@ -130,6 +132,19 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 		return contact, tx.Commit()
 	}
 
+	// org may want us to link this URN to an existing contact with the same phone number
+	if stitch, _ := channel.OrgConfigForKey(courier.ConfigStitchPhoneURNs, false).(bool); stitch {
+		stitched, err := stitchContactForURN(ctx, b, org, channel, urn, authTokens)
+		if err != nil {
+			log.Error("error stitching URN to existing contact", "error", err)
+			return nil, fmt.Errorf("error stitching URN to existing contact: %w", err)
+		}
+		if stitched != nil {
+			log.Info("stitched URN to existing contact", "contact_uuid", stitched.UUID_)
+			return stitched, nil
+		}
+	}
+
 	// didn't find it, we need to create it instead
 	contact.OrgID_ = org
 	contact.UUID_ = courier.ContactUUID(uuids.NewV4())
@@ -220,3 +235,132 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 
 	return contact, nil
 }
+
+// schemes whose paths are phone numbers and so can be stitched together
+var stitchableSchemes = []*urns.Scheme{urns.Phone, urns.WhatsApp}
+
+// numbers shorter than this may be short codes which aren't unique to a person so are never stitched
+const minStitchableDigits = 8
+
+const lookupContactsFromIdentitiesSQL = `
+SELECT DISTINCT
+	c.org_id, 
+	c.id, 
+	c.uuid, 
+	c.modified_on, 
+	c.created_on, 
+	c.name
+FROM 
+	contacts_contact AS c, 
+	contacts_contacturn AS u 
+WHERE 
+	u.identity = ANY($1) AND 
+	u.contact_id = c.id AND 
+	u.org_id = $2 AND 
+	c.is_active = TRUE
+LIMIT 2
+`
+
+const contactHasSchemeSQL = `SELECT EXISTS(SELECT 1 FROM contacts_contacturn WHERE contact_id = $1 AND scheme = $2)`
+
+// stitchContactForURN tries to find a single existing contact with a URN of another phone based scheme with the same
+// number as the passed in URN, and if found adds the URN to that contact. Returns nil if no contact could be safely
+// picked, in which case a new contact should be created.
+func stitchContactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel, urn urns.URN, authTokens map[string]string) (*Contact, error) {
+	number := stitchableNumber(urn)
+	if number == "" {
+		return nil, nil
+	}
+
+	identities := make(pq.StringArray, 0, len(stitchableSchemes))
+	for _, scheme := range stitchableSchemes {
+		if scheme.Prefix != urn.Scheme() {
+			identities = append(identities, string(stitchableIdentity(scheme, number)))
+		}
+	}
+
+	candidates := make([]*Contact, 0, 2)
+	if err := b.db.SelectContext(ctx, &candidates, lookupContactsFromIdentitiesSQL, identities, org); err != nil {
+		return nil, fmt.Errorf("error looking up contacts by identity: %w", err)
+	}
+
+	// if the number is shared by more than one contact we can't know which is the right one
+	if len(candidates) != 1 {
+		return nil, nil
+	}
+
+	contact := candidates[0]
+
+	// a contact which already has a different URN of this scheme is likely someone else sharing the number
+	var hasScheme bool
+	if err := b.db.GetContext(ctx, &hasScheme, contactHasSchemeSQL, contact.ID_, urn.Scheme()); err != nil {
+		return nil, fmt.Errorf("error checking contact URN schemes: %w", err)
+	}
+	if hasScheme {
+		return nil, nil
+	}
+
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %w", err)
+	}
+
+	contactURN, err := getOrCreateContactURN(tx, channel, contact.ID_, urn, authTokens)
+	if err != nil {
+		tx.Rollback()
+
+		// URN was created by someone else in the meantime, let the caller deal with that
+		if dbutil.IsUniqueViolation(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error adding URN to contact: %w", err)
+	}
+
+	// likewise if it turns out the URN already existed and belonged to another contact
+	if contactURN.PrevContactID != NilContactID {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error commiting transaction: %w", err)
+	}
+
+	contact.URNID_ = contactURN.ID
+
+	return contact, nil
+}
+
+// stitchableNumber returns the number of the given URN without any + prefix if it can be stitched, otherwise empty string
+func stitchableNumber(urn urns.URN) string {
+	stitchable := false
+	for _, scheme := range stitchableSchemes {
+		if scheme.Prefix == urn.Scheme() {
+			stitchable = true
+		}
+	}
+
+	// tel URNs without a + might be in a local format so can't be compared
+	if urn.Scheme() == urns.Phone.Prefix && !strings.HasPrefix(urn.Path(), "+") {
+		return ""
+	}
+
+	number := strings.TrimPrefix(urn.Path(), "+")
+	if !stitchable || len(number) < minStitchableDigits {
+		return ""
+	}
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return number
+}
+
+// stitchableIdentity returns the identity of a URN with the given scheme and number
+func stitchableIdentity(scheme *urns.Scheme, number string) urns.URN {
+	if scheme == urns.Phone {
+		return urns.URN(fmt.Sprintf("%s:+%s", scheme.Prefix, number))
+	}
+	return urns.URN(fmt.Sprintf("%s:%s", scheme.Prefix, number))
+}
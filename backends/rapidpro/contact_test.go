@@ -0,0 +1,30 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStitchableNumber(t *testing.T) {
+	tcs := []struct {
+		urn      urns.URN
+		expected string
+	}{
+		{"tel:+12067799192", "12067799192"},
+		{"whatsapp:12067799192", "12067799192"},
+		{"tel:0788383383", ""},       // local format
+		{"tel:+1234", ""},            // short code
+		{"whatsapp:1234", ""},        // short code
+		{"telegram:12067799192", ""}, // not a phone based scheme
+		{"ext:12067799192", ""},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, stitchableNumber(tc.urn), "stitchable number mismatch for %s", tc.urn)
+	}
+
+	assert.Equal(t, urns.URN("tel:+12067799192"), stitchableIdentity(urns.Phone, "12067799192"))
+	assert.Equal(t, urns.URN("whatsapp:12067799192"), stitchableIdentity(urns.WhatsApp, "12067799192"))
+}
@@ -0,0 +1,107 @@
+package rapidpro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/storage"
+)
+
+// carriers drop a file of numbers deactivated each day into the deactivations bucket, under the UUID of the channel
+// whose carrier account it came from, e.g. deactivations/<channel-uuid>/2024-01-01.txt
+const deactivationsImportedKey = "deactivations-imported:%s:%s"
+
+const sqlSelectPhoneChannelUUIDs = `
+SELECT uuid
+  FROM channels_channel
+ WHERE is_active = TRUE AND 'tel' = ANY(schemes)
+ ORDER BY id`
+
+func (b *backend) startDeactivationsImporter(interval time.Duration) {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer func() {
+			slog.Info("deactivations importer exiting")
+			b.waitGroup.Done()
+		}()
+
+		for {
+			select {
+			case <-b.stopChan:
+				return
+			case <-time.After(interval):
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				count, err := b.importDeactivations(ctx, time.Now().UTC().AddDate(0, 0, -1))
+				cancel()
+				if err != nil {
+					slog.Error("error importing deactivated numbers", "error", err)
+				} else if count > 0 {
+					slog.Info("imported deactivated numbers", "count", count)
+				}
+			}
+		}
+	}()
+}
+
+// imports the deactivated numbers files for the given day of all phone channels which haven't already been imported
+func (b *backend) importDeactivations(ctx context.Context, day time.Time) (int, error) {
+	var channelUUIDs []courier.ChannelUUID
+	if err := b.db.SelectContext(ctx, &channelUUIDs, sqlSelectPhoneChannelUUIDs); err != nil {
+		return 0, fmt.Errorf("error loading phone channels: %w", err)
+	}
+
+	total := 0
+	for _, uuid := range channelUUIDs {
+		count, err := b.importChannelDeactivations(ctx, uuid, day)
+		if err != nil {
+			slog.Error("error importing deactivated numbers", "error", err, "channel_uuid", uuid)
+			continue
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// imports the deactivated numbers file for the given channel and day if it exists and hasn't already been imported
+func (b *backend) importChannelDeactivations(ctx context.Context, uuid courier.ChannelUUID, day time.Time) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	dayStr := day.Format(time.DateOnly)
+	importedKey := fmt.Sprintf(deactivationsImportedKey, uuid, dayStr)
+
+	imported, err := redis.Bool(rc.Do("EXISTS", importedKey))
+	if err != nil {
+		return 0, fmt.Errorf("error checking deactivations import: %w", err)
+	}
+	if imported {
+		return 0, nil
+	}
+
+	_, contents, err := b.deactivationStorage.Get(ctx, fmt.Sprintf("deactivations/%s/%s.txt", uuid, dayStr))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil // file hasn't been dropped yet
+		}
+		return 0, err
+	}
+
+	count, err := courier.RecordDeactivatedNumbers(rc, uuid, strings.Split(string(contents), "\n"))
+	if err != nil {
+		return 0, err
+	}
+
+	// remember that we've imported this day for long enough that we'll never consider it again
+	if _, err := rc.Do("SET", importedKey, "1", "EX", 60*60*48); err != nil {
+		return 0, fmt.Errorf("error recording deactivations import: %w", err)
+	}
+
+	return count, nil
+}
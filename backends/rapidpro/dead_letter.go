@@ -0,0 +1,138 @@
+package rapidpro
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// each channel has a redis list of its dead letters, newest first
+const deadLetterKeyPrefix = "dlq:"
+
+// maximum number of dead letters we hold onto per channel, beyond which the oldest are discarded
+const maxDeadLetters = 10000
+
+// deadLetter is what we store in redis, which includes everything needed to requeue the message
+type deadLetter struct {
+	courier.DeadLetter
+
+	TPS          int             `json:"tps"`
+	HighPriority bool            `json:"high_priority"`
+	Msg          json.RawMessage `json:"msg"`
+}
+
+func deadLetterKey(uuid courier.ChannelUUID) string {
+	return deadLetterKeyPrefix + string(uuid)
+}
+
+// returns whether the given message won't be retried after getting the given status
+func isRetriesExhausted(msg *Msg, status courier.MsgStatus) bool {
+	if status == courier.MsgStatusFailed {
+		return true
+	}
+	return status == courier.MsgStatusErrored && msg.ErrorCount_ >= retryPolicyForChannel(msg.channel).MaxRetries
+}
+
+// pushes the given message onto the dead letter queue of its channel
+func pushDeadLetter(rc redis.Conn, msg *Msg, clog *courier.ChannelLog) error {
+	errMsgs := make([]string, len(clog.Errors))
+	for i, e := range clog.Errors {
+		errMsgs[i] = e.Message
+	}
+
+	letter := &deadLetter{
+		DeadLetter: courier.DeadLetter{
+			MsgID:       msg.ID(),
+			ChannelUUID: msg.ChannelUUID_,
+			LogUUID:     clog.UUID,
+			Error:       strings.Join(errMsgs, ", "),
+			FailedOn:    time.Now().In(time.UTC),
+		},
+		TPS:          msg.tps,
+		HighPriority: msg.HighPriority_,
+		Msg:          jsonx.MustMarshal(msg),
+	}
+
+	key := deadLetterKey(msg.ChannelUUID_)
+
+	rc.Send("MULTI")
+	rc.Send("LPUSH", key, jsonx.MustMarshal(letter))
+	rc.Send("LTRIM", key, 0, maxDeadLetters-1)
+	if _, err := rc.Do("EXEC"); err != nil {
+		return fmt.Errorf("error pushing dead letter: %w", err)
+	}
+	return nil
+}
+
+// reads all the dead letters for the given channel, newest first
+func readDeadLetters(rc redis.Conn, uuid courier.ChannelUUID) ([]*deadLetter, error) {
+	values, err := redis.ByteSlices(rc.Do("LRANGE", deadLetterKey(uuid), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading dead letters: %w", err)
+	}
+
+	letters := make([]*deadLetter, 0, len(values))
+	for _, value := range values {
+		letter := &deadLetter{}
+		if err := json.Unmarshal(value, letter); err != nil {
+			slog.Error("error unmarshalling dead letter", "channel_uuid", uuid, "error", err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// pops the dead letters for the given channel, oldest first, and pushes them back onto the outgoing queue
+func requeueDeadLetters(rc redis.Conn, uuid courier.ChannelUUID) ([]*deadLetter, error) {
+	requeued := make([]*deadLetter, 0, 10)
+
+	for {
+		raw, err := redis.Bytes(rc.Do("RPOP", deadLetterKey(uuid)))
+		if err == redis.ErrNil {
+			return requeued, nil
+		} else if err != nil {
+			return requeued, fmt.Errorf("error popping dead letter: %w", err)
+		}
+
+		letter := &deadLetter{}
+		if err := json.Unmarshal(raw, letter); err != nil {
+			slog.Error("error unmarshalling dead letter", "channel_uuid", uuid, "error", err)
+			continue
+		}
+
+		priority := queue.LowPriority
+		if letter.HighPriority {
+			priority = queue.HighPriority
+		}
+
+		// queued values are lists of messages
+		value := jsonx.MustMarshal([]json.RawMessage{letter.Msg})
+
+		if err := queue.PushOntoQueue(rc, msgQueueName, string(uuid), letter.TPS, string(value), queue.Priority(priority)); err != nil {
+			// put it back so that it isn't lost
+			rc.Do("RPUSH", deadLetterKey(uuid), raw)
+			return requeued, fmt.Errorf("error requeuing dead letter: %w", err)
+		}
+
+		requeued = append(requeued, letter)
+	}
+}
+
+// parses the TPS from a worker token, which is the name of the queue, e.g. msgs:<uuid>|<tps>
+func tpsFromWorkerToken(token queue.WorkerToken) int {
+	_, tps, found := strings.Cut(string(token), "|")
+	if !found {
+		return 0
+	}
+	v, _ := strconv.Atoi(tps)
+	return v
+}
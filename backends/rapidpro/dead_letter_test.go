@@ -0,0 +1,40 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/null/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetriesExhausted(t *testing.T) {
+	channel := &Channel{}
+	strictChannel := &Channel{Config_: null.Map[any]{"retry_policy": map[string]any{"max_retries": 0.0}}}
+
+	tcs := []struct {
+		channel    *Channel
+		errorCount int
+		status     courier.MsgStatus
+		expected   bool
+	}{
+		{channel, 0, courier.MsgStatusWired, false},
+		{channel, 0, courier.MsgStatusFailed, true},
+		{channel, 0, courier.MsgStatusErrored, false},
+		{channel, 1, courier.MsgStatusErrored, false},
+		{channel, 2, courier.MsgStatusErrored, true},
+		{strictChannel, 0, courier.MsgStatusErrored, true},
+	}
+
+	for _, tc := range tcs {
+		msg := &Msg{ErrorCount_: tc.errorCount, channel: tc.channel}
+		assert.Equal(t, tc.expected, isRetriesExhausted(msg, tc.status), "exhausted mismatch for error_count=%d status=%s", tc.errorCount, tc.status)
+	}
+}
+
+func TestTPSFromWorkerToken(t *testing.T) {
+	assert.Equal(t, 10, tpsFromWorkerToken(queue.WorkerToken("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10")))
+	assert.Equal(t, 0, tpsFromWorkerToken(queue.WorkerToken("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|0")))
+	assert.Equal(t, 0, tpsFromWorkerToken(queue.WorkerToken("")))
+}
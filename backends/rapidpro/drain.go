@@ -0,0 +1,100 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+const sqlUpdateMsgsChannel = `
+UPDATE msgs_msg SET channel_id = $1, modified_on = NOW() WHERE id = ANY($2) AND direction = 'O'`
+
+// moves the messages in the given queue of a channel onto the queue of the target channel, returning how many queued
+// items were moved. Messages keep the TPS of the queue they came from unless a TPS for the target is given.
+func (b *backend) drainChannelQueue(ctx context.Context, rc redis.Conn, name string, target *Channel, targetTPS int) (int, error) {
+	// our queue name is in the format uuid|tps, break it apart
+	source, t, _ := strings.Cut(name, "|")
+	sourceTPS, _ := strconv.Atoi(t)
+
+	tps := sourceTPS
+	if targetTPS >= 0 {
+		tps = targetTPS
+	}
+
+	moved := 0
+
+	for _, priority := range []queue.Priority{queue.HighPriority, queue.LowPriority} {
+		items, err := queue.Take(rc, msgQueueName, name, priority)
+		if err != nil {
+			return moved, fmt.Errorf("error taking queued messages: %w", err)
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		// put everything back where it came from if we can't move it
+		restore := func() {
+			for _, item := range items {
+				queue.PushOntoQueueAt(rc, msgQueueName, source, sourceTPS, item.Value, priority, item.At)
+			}
+		}
+
+		values := make([]string, len(items))
+		msgIDs := make([]courier.MsgID, 0, len(items))
+
+		for i, item := range items {
+			value, ids, err := rechannelQueuedValue(item.Value, target.UUID())
+			if err != nil {
+				restore()
+				return moved, err
+			}
+			values[i] = value
+			msgIDs = append(msgIDs, ids...)
+		}
+
+		// messages are updated in the database too so that status updates from the target channel match them
+		if _, err := b.db.ExecContext(ctx, sqlUpdateMsgsChannel, target.ID(), pq.Array(msgIDs)); err != nil {
+			restore()
+			return moved, fmt.Errorf("error updating channel of drained messages: %w", err)
+		}
+
+		for i, item := range items {
+			if err := queue.PushOntoQueueAt(rc, msgQueueName, string(target.UUID()), tps, values[i], priority, item.At); err != nil {
+				return moved, fmt.Errorf("error queuing drained messages: %w", err)
+			}
+			moved++
+		}
+	}
+
+	return moved, nil
+}
+
+// rewrites a queued value, which is a list of messages, so that its messages are sent by the given channel, returning
+// the new value and the IDs of its messages
+func rechannelQueuedValue(value string, uuid courier.ChannelUUID) (string, []courier.MsgID, error) {
+	var msgs []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &msgs); err != nil {
+		return "", nil, fmt.Errorf("error unmarshalling queued messages: %w", err)
+	}
+
+	ids := make([]courier.MsgID, 0, len(msgs))
+	for _, m := range msgs {
+		var id courier.MsgID
+		if err := json.Unmarshal(m["id"], &id); err != nil {
+			return "", nil, fmt.Errorf("error reading queued message ID: %w", err)
+		}
+		ids = append(ids, id)
+
+		m["channel_uuid"] = jsonx.MustMarshal(uuid)
+	}
+
+	return string(jsonx.MustMarshal(msgs)), ids, nil
+}
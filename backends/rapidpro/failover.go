@@ -0,0 +1,113 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// messages moved to a fallback channel aren't sent until after the status update recording the move has been written
+const failoverDelay = 5 * time.Second
+
+const sqlFailoverMsg = `
+UPDATE msgs_msg SET channel_id = $2, contact_urn_id = $3, error_count = 0, modified_on = NOW() WHERE id = $1 AND direction = 'O'`
+
+// FailoverMsg moves the given message onto the queue of the given fallback channel, switching it to a URN of the same
+// contact which that channel can send to if necessary
+func (b *backend) FailoverMsg(ctx context.Context, msg courier.MsgOut, ch courier.Channel) error {
+	dbMsg := msg.(*Msg)
+	fallback := ch.(*Channel)
+
+	if fallback.OrgID() != dbMsg.OrgID_ {
+		return fmt.Errorf("fallback channel %s belongs to a different workspace: %w", fallback.UUID(), courier.ErrChannelNotFound)
+	}
+
+	urn, err := fallbackURN(dbMsg.URN_, fallback)
+	if err != nil {
+		return err
+	}
+
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	contactURN, err := getOrCreateContactURN(tx, fallback, dbMsg.ContactID_, urn, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlFailoverMsg, dbMsg.ID_, fallback.ID(), contactURN.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error updating channel of message: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	moved := *dbMsg
+	moved.ChannelID_ = fallback.ID()
+	moved.ChannelUUID_ = fallback.UUID()
+	moved.ContactURNID_ = contactURN.ID
+	moved.ErrorCount_ = 0
+	moved.channel = fallback
+	moved.workerToken = ""
+	if urn != dbMsg.URN_ {
+		moved.URN_ = urn
+		moved.URNAuth_ = ""
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	// use the TPS of the fallback's current queue if it has one
+	names, err := b.channelQueues(rc, fallback.UUID())
+	if err != nil {
+		return err
+	}
+	tps := dbMsg.tps
+	if len(names) > 0 {
+		_, t, _ := strings.Cut(names[len(names)-1], "|")
+		tps, _ = strconv.Atoi(t)
+	}
+
+	priority := queue.LowPriority
+	if moved.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{&moved})
+
+	if err := queue.PushOntoQueueAt(rc, msgQueueName, string(fallback.UUID()), tps, string(value), queue.Priority(priority), time.Now().Add(failoverDelay)); err != nil {
+		return fmt.Errorf("error queuing message on fallback channel: %w", err)
+	}
+	return nil
+}
+
+// returns the URN a message to the given URN should be sent to by the given fallback channel, which is the same URN if
+// the channel supports its scheme, or the same number with a scheme the channel supports, e.g. whatsapp:250788383383
+// becomes tel:+250788383383 for an SMS channel
+func fallbackURN(urn urns.URN, fallback *Channel) (urns.URN, error) {
+	if slices.Contains(fallback.Schemes(), urn.Scheme()) {
+		return urn, nil
+	}
+
+	if number := stitchableNumber(urn); number != "" {
+		for _, scheme := range stitchableSchemes {
+			if slices.Contains(fallback.Schemes(), scheme.Prefix) {
+				return stitchableIdentity(scheme, number), nil
+			}
+		}
+	}
+
+	return urns.NilURN, fmt.Errorf("fallback channel %s can't send to URNs with scheme %s", fallback.UUID(), urn.Scheme())
+}
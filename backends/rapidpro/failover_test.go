@@ -0,0 +1,37 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackURN(t *testing.T) {
+	sms := &Channel{UUID_: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Schemes_: pq.StringArray{"tel"}}
+	whatsapp := &Channel{UUID_: "8eb23e93-5ecb-45ba-b726-3b064e0c56cd", Schemes_: pq.StringArray{"whatsapp"}}
+
+	tcs := []struct {
+		urn         urns.URN
+		fallback    *Channel
+		expected    urns.URN
+		expectedErr string
+	}{
+		{"tel:+12067799192", sms, "tel:+12067799192", ""},
+		{"whatsapp:12067799192", sms, "tel:+12067799192", ""},
+		{"tel:+12067799192", whatsapp, "whatsapp:12067799192", ""},
+		{"tel:0788383383", whatsapp, urns.NilURN, "fallback channel 8eb23e93-5ecb-45ba-b726-3b064e0c56cd can't send to URNs with scheme tel"},
+		{"telegram:12067799192", sms, urns.NilURN, "fallback channel dbc126ed-66bc-4e28-b67b-81dc3327c95d can't send to URNs with scheme telegram"},
+	}
+
+	for _, tc := range tcs {
+		urn, err := fallbackURN(tc.urn, tc.fallback)
+		if tc.expectedErr != "" {
+			assert.EqualError(t, err, tc.expectedErr, "error mismatch for %s", tc.urn)
+		} else {
+			assert.NoError(t, err, "unexpected error for %s", tc.urn)
+		}
+		assert.Equal(t, tc.expected, urn, "URN mismatch for %s", tc.urn)
+	}
+}
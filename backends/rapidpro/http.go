@@ -0,0 +1,167 @@
+package rapidpro
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/dnscache"
+)
+
+// how long idle connections to provider hosts are kept open
+const idleConnTimeout = 15 * time.Second
+
+// handlers apply their own timeouts to each request so this is just a ceiling for channels configured with long ones
+const maxRequestTimeout = 2 * time.Minute
+
+// builds the transports for our secure and insecure HTTP clients, which share a DNS cache if that's enabled
+func newHTTPTransports(cfg *courier.Config) (*http.Transport, *http.Transport) {
+	var resolver *dnscache.Resolver
+	if cfg.DNSCacheMaxTTL > 0 {
+		resolver = dnscache.NewResolver(time.Duration(cfg.DNSCacheMaxTTL) * time.Second)
+	}
+
+	newTransport := func() *http.Transport {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = 64
+		t.MaxIdleConnsPerHost = 8
+		t.IdleConnTimeout = idleConnTimeout
+
+		if resolver != nil {
+			t.DialContext = resolver.DialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+		}
+		return t
+	}
+
+	transport := newTransport()
+	insecureTransport := newTransport()
+	insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return transport, insecureTransport
+}
+
+// usageTracker is a round tripper which records when requests were last made to each host
+type usageTracker struct {
+	http.RoundTripper
+
+	lastUsed sync.Map
+}
+
+func (t *usageTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lastUsed.Store(req.URL.Host, time.Now())
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// LastUsed returns when a request was last made to the given host
+func (t *usageTracker) LastUsed(host string) time.Time {
+	if v, ok := t.lastUsed.Load(host); ok {
+		return v.(time.Time)
+	}
+	return time.Time{}
+}
+
+// hosts which haven't had real requests for this long are no longer kept warm
+const warmWindow = 15 * time.Minute
+
+// connWarmer opens connections to the configured provider hosts at startup, and again for hosts in use whenever
+// they've been idle for long enough that their connections would be closed, so that sends don't have to wait for DNS
+// lookups, TCP connections and TLS handshakes, e.g. the first sends after a deploy
+type connWarmer struct {
+	client  *http.Client
+	tracker *usageTracker
+	hosts   []string
+	warmed  map[string]time.Time
+}
+
+// creates a new warmer which shares the tracker's transport and so its connection pool, but whose own requests aren't
+// tracked as usage
+func newConnWarmer(tracker *usageTracker, hosts []string) *connWarmer {
+	return &connWarmer{
+		client:  &http.Client{Transport: tracker.RoundTripper},
+		tracker: tracker,
+		hosts:   hosts,
+		warmed:  make(map[string]time.Time, len(hosts)),
+	}
+}
+
+// Start warms all hosts and then starts a goroutine which re-warms idle hosts until the given stop channel is closed
+func (w *connWarmer) Start(stopChan chan bool, wg *sync.WaitGroup) {
+	w.warm(time.Now(), true)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(idleConnTimeout / 3):
+				w.warm(time.Now(), false)
+			}
+		}
+	}()
+}
+
+// warms hosts which have had real requests recently but whose connections haven't been used or warmed for over two
+// thirds of the idle timeout, or all hosts if force is set
+func (w *connWarmer) warm(now time.Time, force bool) int {
+	wg := &sync.WaitGroup{}
+	warmed := 0
+
+	for _, host := range w.hosts {
+		if !force {
+			lastUsed := w.tracker.LastUsed(host)
+			if now.Sub(lastUsed) > warmWindow {
+				continue
+			}
+
+			lastActive := lastUsed
+			if w.warmed[host].After(lastActive) {
+				lastActive = w.warmed[host]
+			}
+			if now.Sub(lastActive) < idleConnTimeout*2/3 {
+				continue
+			}
+		}
+
+		w.warmed[host] = now
+		warmed++
+		wg.Add(1)
+
+		go func(host string) {
+			defer wg.Done()
+
+			if err := w.warmHost(host); err != nil {
+				slog.Warn("error warming connection", "comp", "conn warmer", "host", host, "error", err)
+			}
+		}(host)
+	}
+
+	wg.Wait()
+	return warmed
+}
+
+// makes a HEAD request to the given host which leaves an open connection in the transport's pool, the response itself
+// doesn't matter
+func (w *connWarmer) warmHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+host+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
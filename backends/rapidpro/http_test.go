@@ -0,0 +1,73 @@
+package rapidpro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPTransports(t *testing.T) {
+	cfg := courier.NewDefaultConfig()
+
+	secure, insecure := newHTTPTransports(cfg)
+	assert.NotNil(t, secure.DialContext)
+	assert.Equal(t, idleConnTimeout, secure.IdleConnTimeout)
+	assert.False(t, secure.TLSClientConfig != nil && secure.TLSClientConfig.InsecureSkipVerify)
+	assert.True(t, insecure.TLSClientConfig.InsecureSkipVerify)
+
+	cfg.DNSCacheMaxTTL = 0
+
+	secure, _ = newHTTPTransports(cfg)
+	assert.Equal(t, 64, secure.MaxIdleConns)
+}
+
+func TestConnWarmer(t *testing.T) {
+	requests := &atomic.Int32{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	tracker := &usageTracker{RoundTripper: server.Client().Transport}
+	client := &http.Client{Transport: tracker}
+
+	warmer := newConnWarmer(tracker, []string{host, "127.0.0.1:1"})
+
+	// all hosts are warmed at startup, errors are just logged
+	assert.Equal(t, 2, warmer.warm(time.Now(), true))
+	assert.Equal(t, int32(1), requests.Load())
+
+	// warming doesn't count as usage
+	assert.True(t, tracker.LastUsed(host).IsZero())
+
+	// so hosts without real requests aren't re-warmed
+	assert.Equal(t, 0, warmer.warm(time.Now().Add(idleConnTimeout), false))
+	assert.Equal(t, int32(1), requests.Load())
+
+	req, _ := http.NewRequest(http.MethodHead, server.URL, nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.WithinDuration(t, time.Now(), tracker.LastUsed(host), time.Second)
+
+	// hosts used recently don't need warming
+	assert.Equal(t, 0, warmer.warm(time.Now(), false))
+
+	// but once they've been idle for a while they do, and only once per idle period
+	assert.Equal(t, 1, warmer.warm(time.Now().Add(idleConnTimeout), false))
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, 0, warmer.warm(time.Now().Add(idleConnTimeout), false))
+
+	// until the host hasn't had real requests for long enough to no longer be kept warm
+	assert.Equal(t, 0, warmer.warm(time.Now().Add(warmWindow+time.Minute), false))
+
+	assert.True(t, tracker.LastUsed("foo.com").IsZero())
+}
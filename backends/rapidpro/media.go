@@ -1,12 +1,20 @@
 package rapidpro
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
 	"path/filepath"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/images"
+	"github.com/nyaruka/courier/utils/transcode"
+	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/uuids"
 )
 
@@ -61,3 +69,90 @@ func lookupMediaFromUUID(ctx context.Context, db *sqlx.DB, uuid uuids.UUID) (*Me
 	media.Alternates_ = alternates
 	return media, nil
 }
+
+// fetches the data of the given media, which may be stored anywhere our HTTP client can reach
+func (b *backend) fetchMediaData(ctx context.Context, media courier.Media) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, media.URL(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	trace, err := httpx.DoTrace(b.httpClient, req, nil, b.httpAccess, b.config.AttachmentMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	if trace.Response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("received non-200 response: %d", trace.Response.StatusCode)
+	}
+	return trace.ResponseBody, nil
+}
+
+// transcodes the given audio media to the given content type and stores the result as an attachment of the channel
+func (b *backend) transcodeMedia(ctx context.Context, ch courier.Channel, media courier.Media, contentType string) (*Media, error) {
+	data, err := b.fetchMediaData(ctx, media)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching media to transcode: %w", err)
+	}
+
+	data, err = b.transcoder.Transcode(ctx, data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	storageURL, err := b.SaveAttachment(ctx, ch, contentType, data, transcode.Extension(contentType))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Media{
+		UUID_:        uuids.NewV4(),
+		Path_:        storagePath(storageURL),
+		ContentType_: contentType,
+		URL_:         storageURL,
+		Size_:        len(data),
+		Duration_:    media.Duration(),
+		Alternates_:  []*Media{},
+	}, nil
+}
+
+// resizes the given image media to fit within the given limits and stores the result as an attachment of the channel
+func (b *backend) resizeImage(ctx context.Context, ch courier.Channel, media courier.Media, maxWidth, maxHeight, maxBytes int) (*Media, error) {
+	data, err := b.fetchMediaData(ctx, media)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching media to resize: %w", err)
+	}
+
+	data, err = images.Resize(data, maxWidth, maxHeight, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reading resized image: %w", err)
+	}
+
+	storageURL, err := b.SaveAttachment(ctx, ch, "image/jpeg", data, "jpg")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Media{
+		UUID_:        uuids.NewV4(),
+		Path_:        storagePath(storageURL),
+		ContentType_: "image/jpeg",
+		URL_:         storageURL,
+		Size_:        len(data),
+		Width_:       img.Width,
+		Height_:      img.Height,
+		Alternates_:  []*Media{},
+	}, nil
+}
+
+// returns the path of the given storage URL, which is used to name media
+func storagePath(storageURL string) string {
+	if u, err := url.Parse(storageURL); err == nil {
+		return u.Path
+	}
+	return ""
+}
@@ -3,7 +3,6 @@ package rapidpro
 import (
 	"context"
 	"crypto/sha1"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -14,7 +13,6 @@ import (
 	"time"
 
 	"github.com/buger/jsonparser"
-	filetype "github.com/h2non/filetype"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
@@ -58,14 +56,14 @@ type Msg struct {
 	Locale_       null.String         `json:"locale"          db:"locale"`
 	Templating_   *courier.Templating `json:"templating"      db:"templating"`
 	ExternalID_   null.String         `                       db:"external_id"`
-	Metadata_     json.RawMessage     `json:"metadata"        db:"metadata"`
+	Metadata_     null.JSON           `json:"metadata"        db:"metadata"`
 
 	ChannelID_    courier.ChannelID `                       db:"channel_id"`
 	ContactID_    ContactID         `json:"contact_id"      db:"contact_id"`
 	ContactURNID_ ContactURNID      `json:"contact_urn_id"  db:"contact_urn_id"`
 
 	MessageCount_ int         `                     db:"msg_count"`
-	ErrorCount_   int         `                     db:"error_count"`
+	ErrorCount_   int         `json:"error_count"   db:"error_count"`
 	FailedReason_ null.String `                     db:"failed_reason"`
 
 	NextAttempt_ time.Time      `                     db:"next_attempt"`
@@ -86,11 +84,15 @@ type Msg struct {
 	Origin_               courier.MsgOrigin       `json:"origin"`
 	ContactLastSeenOn_    *time.Time              `json:"contact_last_seen_on"`
 	Session_              *courier.Session        `json:"session"`
+	Pacing_               *Pacing                 `json:"pacing,omitempty"`
 
 	ContactName_   string            `json:"contact_name"`
 	URNAuthTokens_ map[string]string `json:"auth_tokens"`
+	IsEcho_        bool              `json:"is_echo,omitempty"`
+	payload        *queue.Payload
 	channel        *Channel
 	workerToken    queue.WorkerToken
+	tps            int
 	alreadyWritten bool
 }
 
@@ -142,14 +144,27 @@ func (m *Msg) URNAuth() string                 { return m.URNAuth_ }
 func (m *Msg) Origin() courier.MsgOrigin       { return m.Origin_ }
 func (m *Msg) ContactLastSeenOn() *time.Time   { return m.ContactLastSeenOn_ }
 func (m *Msg) Topic() string {
-	if m.Metadata_ == nil {
+	if m.Metadata_.IsNull() {
 		return ""
 	}
 	topic, _, _, _ := jsonparser.Get(m.Metadata_, "topic")
 	return string(topic)
 }
+func (m *Msg) URLPreview() *bool {
+	if m.Metadata_.IsNull() {
+		return nil
+	}
+	preview, err := jsonparser.GetBoolean(m.Metadata_, "url_preview")
+	if err != nil {
+		return nil
+	}
+	return &preview
+}
 func (m *Msg) Metadata() json.RawMessage {
-	return m.Metadata_
+	if m.Metadata_.IsNull() {
+		return nil
+	}
+	return json.RawMessage(m.Metadata_)
 }
 func (m *Msg) ResponseToExternalID() string   { return m.ResponseToExternalID_ }
 func (m *Msg) SentOn() *time.Time             { return m.SentOn_ }
@@ -172,6 +187,38 @@ func (m *Msg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	return m
 }
 func (m *Msg) WithReceivedOn(date time.Time) courier.MsgIn { m.SentOn_ = &date; return m }
+func (m *Msg) WithMetadata(metadata json.RawMessage) courier.MsgIn {
+	m.Metadata_ = null.JSON(metadata)
+	return m
+}
+func (m *Msg) WithEcho() courier.MsgIn {
+	m.Direction_ = MsgOutgoing
+	m.Status_ = courier.MsgStatusSent
+	m.IsEcho_ = true
+	return m
+}
+
+// queued msgs are required to have these fields even if they're from a newer schema version
+var requiredMsgFields = []string{"id", "uuid", "channel_uuid", "urn"}
+
+// msgEnvelope is Msg without its JSON methods
+type msgEnvelope Msg
+
+// MarshalJSON marshals this message as a queued payload, including its schema version and any fields we didn't
+// understand when it was unmarshalled
+func (m *Msg) MarshalJSON() ([]byte, error) {
+	return queue.Encode((*msgEnvelope)(m), m.payload)
+}
+
+// UnmarshalJSON unmarshals this message from a queued payload, which may be from a different schema version
+func (m *Msg) UnmarshalJSON(data []byte) error {
+	p, err := queue.Decode(data, (*msgEnvelope)(m), requiredMsgFields...)
+	if err != nil {
+		return err
+	}
+	m.payload = p
+	return nil
+}
 
 func (m *Msg) hash() string {
 	hash := sha1.Sum([]byte(m.Text_ + "|" + strings.Join(m.Attachments_, "|")))
@@ -187,34 +234,8 @@ func writeMsg(ctx context.Context, b *backend, msg courier.MsgIn, clog *courier.
 		return nil
 	}
 
-	channel := m.Channel()
-
-	// check for data: attachment URLs which need to be fetched now - fetching of other URLs can be deferred until
-	// message handling and performed by calling the /c/_fetch-attachment endpoint
-	for i, attURL := range m.Attachments_ {
-		if strings.HasPrefix(attURL, "data:") {
-			attData, err := base64.StdEncoding.DecodeString(attURL[5:])
-			if err != nil {
-				clog.Error(courier.ErrorAttachmentNotDecodable())
-				return fmt.Errorf("unable to decode attachment data: %w", err)
-			}
-
-			var contentType, extension string
-			fileType, _ := filetype.Match(attData[:300])
-			if fileType != filetype.Unknown {
-				contentType = fileType.MIME.Value
-				extension = fileType.Extension
-			} else {
-				contentType = "application/octet-stream"
-				extension = "bin"
-			}
-
-			newURL, err := b.SaveAttachment(ctx, channel, contentType, attData, extension)
-			if err != nil {
-				return err
-			}
-			m.Attachments_[i] = fmt.Sprintf("%s:%s", contentType, newURL)
-		}
+	if err := resolveAttachments(ctx, b, m, clog); err != nil {
+		return err
 	}
 
 	// try to write it our db
@@ -239,9 +260,9 @@ func writeMsg(ctx context.Context, b *backend, msg courier.MsgIn, clog *courier.
 const sqlInsertMsg = `
 INSERT INTO
 	msgs_msg(org_id, uuid, direction, text, attachments, msg_type, msg_count, error_count, high_priority, status, is_android,
-             visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, sent_on, log_uuids)
+             visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, sent_on, log_uuids, metadata)
     VALUES(:org_id, :uuid, :direction, :text, :attachments, 'T', :msg_count, :error_count, :high_priority, :status, FALSE,
-           :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :sent_on, :log_uuids)
+           :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :sent_on, :log_uuids, :metadata)
 RETURNING id`
 
 func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) error {
@@ -268,6 +289,11 @@ func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.Channel
 		return fmt.Errorf("error scanning for inserted message id: %w", err)
 	}
 
+	// echoes have already been sent so there's nothing for RapidPro to handle
+	if m.IsEcho_ {
+		return nil
+	}
+
 	// queue this up to be handled by RapidPro
 	rc := b.rp.Get()
 	defer rc.Close()
@@ -305,6 +331,11 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 	}
 	msg.channel = channel.(*Channel)
 
+	// direction and status aren't part of our JSON so restore them for echoes
+	if msg.IsEcho_ {
+		msg.WithEcho()
+	}
+
 	// create log tho it won't be written
 	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, channel, nil)
 
@@ -0,0 +1,71 @@
+package rapidpro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// how long we hold onto the pacing state of a group after its duration has passed
+const pacingExpiry = time.Hour
+
+// Pacing is an optional hint in a queued message payload that it's one of a larger group of messages, e.g. a
+// broadcast, which should be spread evenly over the given duration rather than sent as fast as the channel allows,
+// e.g. {"key": "bcast:123", "count": 100000, "duration": 7200}
+type Pacing struct {
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Duration int    `json:"duration"`
+
+	// set when we push a message back onto the queue to be sent later, so that it isn't paced again
+	SendAfter *time.Time `json:"send_after,omitempty"`
+}
+
+func (p *Pacing) interval() time.Duration {
+	return time.Duration(p.Duration) * time.Second / time.Duration(p.Count)
+}
+
+// defers sending of the given message if it's part of a paced group which is ahead of its pace, by pushing it back
+// onto the queue to be popped again when it's due, which the pop script won't do before then. Returns whether the
+// message was deferred.
+func deferPacedMsg(rc redis.Conn, msg *Msg) (bool, error) {
+	p := msg.Pacing_
+	if p == nil || p.Key == "" || p.Count <= 0 || p.Duration <= 0 || p.SendAfter != nil {
+		return false, nil
+	}
+
+	slot, err := queue.Pace(rc, "pacing:"+p.Key, p.interval(), time.Duration(p.Duration)*time.Second+pacingExpiry)
+	if err != nil {
+		return false, fmt.Errorf("error pacing message: %w", err)
+	}
+
+	if !slot.After(time.Now()) {
+		return false, nil
+	}
+
+	p.SendAfter = &slot
+
+	priority := queue.LowPriority
+	if msg.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{msg})
+
+	if err := queue.PushOntoQueueAt(rc, msgQueueName, string(msg.ChannelUUID_), msg.tps, string(value), queue.Priority(priority), slot); err != nil {
+		return false, fmt.Errorf("error pushing paced message: %w", err)
+	}
+
+	// popping it shouldn't count against the channel's rate limit since it wasn't sent
+	if msg.workerToken != "" {
+		if err := queue.Refund(rc, msgQueueName, msg.workerToken); err != nil {
+			return true, fmt.Errorf("error refunding paced message: %w", err)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package rapidpro
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// the redis hash of channel UUIDs to their last reported quality
+const channelQualityKey = "channel-quality"
+
+// writes the given channel quality to redis, returning the previous quality if there was one
+func writeChannelQuality(rc redis.Conn, ch courier.Channel, quality *courier.ChannelQuality) (*courier.ChannelQuality, error) {
+	prev, err := redis.Bytes(rc.Do("HGET", channelQualityKey, string(ch.UUID())))
+	if err != nil && err != redis.ErrNil {
+		return nil, fmt.Errorf("error reading channel quality: %w", err)
+	}
+
+	if _, err := rc.Do("HSET", channelQualityKey, string(ch.UUID()), jsonx.MustMarshal(quality)); err != nil {
+		return nil, fmt.Errorf("error writing channel quality: %w", err)
+	}
+
+	if prev == nil {
+		return nil, nil
+	}
+
+	prevQuality := &courier.ChannelQuality{}
+	if err := json.Unmarshal(prev, prevQuality); err != nil {
+		return nil, nil // ignore previous values we can't parse
+	}
+	return prevQuality, nil
+}
+
+// reads the last reported quality of all channels
+func readChannelQualities(rc redis.Conn) (map[courier.ChannelUUID]*courier.ChannelQuality, error) {
+	values, err := redis.StringMap(rc.Do("HGETALL", channelQualityKey))
+	if err != nil {
+		return nil, fmt.Errorf("error reading channel qualities: %w", err)
+	}
+
+	qualities := make(map[courier.ChannelUUID]*courier.ChannelQuality, len(values))
+	for uuid, value := range values {
+		quality := &courier.ChannelQuality{}
+		if err := json.Unmarshal([]byte(value), quality); err != nil {
+			slog.Error("error unmarshalling channel quality", "channel_uuid", uuid, "error", err)
+			continue
+		}
+		qualities[courier.ChannelUUID(uuid)] = quality
+	}
+	return qualities, nil
+}
@@ -0,0 +1,73 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// each channel has a redis list of its most recent logs, newest first, so that they can be inspected without having
+// to know their UUIDs
+const recentLogsKeyPrefix = "recent_logs:"
+
+// maximum number of logs we hold onto per channel, beyond which the oldest are discarded
+const maxRecentLogs = 500
+
+// recent logs are only for looking at what a channel is doing now so they expire long before channel logs do
+const recentLogsTTL = 24 * time.Hour
+
+func recentLogsKey(uuid courier.ChannelUUID) string {
+	return recentLogsKeyPrefix + string(uuid)
+}
+
+// pushes the given log onto the recent logs of its channel
+func pushRecentLog(rc redis.Conn, clog *courier.ChannelLog) error {
+	key := recentLogsKey(clog.Channel().UUID())
+
+	rc.Send("MULTI")
+	rc.Send("LPUSH", key, jsonx.MustMarshal(courier.NewRecentChannelLog(clog)))
+	rc.Send("LTRIM", key, 0, maxRecentLogs-1)
+	rc.Send("EXPIRE", key, int(recentLogsTTL/time.Second))
+	if _, err := rc.Do("EXEC"); err != nil {
+		return fmt.Errorf("error pushing recent log: %w", err)
+	}
+	return nil
+}
+
+// reads the recent logs of the given channel which match the given query, newest first
+func readRecentLogs(rc redis.Conn, uuid courier.ChannelUUID, query *courier.ChannelLogQuery) ([]*courier.RecentChannelLog, error) {
+	values, err := redis.ByteSlices(rc.Do("LRANGE", recentLogsKey(uuid), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading recent logs: %w", err)
+	}
+
+	logs := make([]*courier.RecentChannelLog, 0, min(len(values), query.Limit))
+	for _, value := range values {
+		l := &courier.RecentChannelLog{}
+		if err := json.Unmarshal(value, l); err != nil {
+			slog.Error("error unmarshalling recent log", "channel_uuid", uuid, "error", err)
+			continue
+		}
+		if query.Matches(l) {
+			logs = append(logs, l)
+			if len(logs) >= query.Limit {
+				break
+			}
+		}
+	}
+	return logs, nil
+}
+
+// RecentChannelLogs returns the recent logs of the given channel which match the given query, newest first
+func (b *backend) RecentChannelLogs(ctx context.Context, uuid courier.ChannelUUID, query *courier.ChannelLogQuery) ([]*courier.RecentChannelLog, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	return readRecentLogs(rc, uuid, query)
+}
@@ -0,0 +1,54 @@
+package rapidpro
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// returns whether this outgoing message expects a reply, which is the case if it was sent by a flow which is now
+// waiting for the contact to respond
+func (m *Msg) expectsReply() bool {
+	return m.Session_ != nil && m.Session_.Status == "W"
+}
+
+func awaitingReplyField(ch courier.Channel, urn urns.URN) string {
+	return fmt.Sprintf("%s:%s", ch.UUID(), urn.Identity())
+}
+
+// records that the given sent message is waiting for a reply from its URN on its channel
+func (b *backend) trackReplyExpected(rc redis.Conn, msg *Msg) error {
+	sentOn := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	if err := b.awaitingReplies.Set(rc, awaitingReplyField(msg.Channel(), msg.URN()), sentOn); err != nil {
+		return fmt.Errorf("error tracking expected reply: %w", err)
+	}
+
+	b.stats.RecordReplyExpected(msg.Channel().ChannelType())
+	return nil
+}
+
+// checks whether the given received message is a reply to a sent message which was waiting for one
+func (b *backend) trackReplyReceived(rc redis.Conn, ch courier.Channel, msg courier.MsgIn) error {
+	field := awaitingReplyField(ch, msg.URN())
+
+	sentOn, err := b.awaitingReplies.Get(rc, field)
+	if err != nil {
+		return fmt.Errorf("error checking expected reply: %w", err)
+	}
+	if sentOn == "" {
+		return nil
+	}
+
+	if err := b.awaitingReplies.Del(rc, field); err != nil {
+		return fmt.Errorf("error clearing expected reply: %w", err)
+	}
+
+	sentMillis, _ := strconv.ParseInt(sentOn, 10, 64)
+	b.stats.RecordReplyReceived(ch.ChannelType(), time.Since(time.UnixMilli(sentMillis)))
+	return nil
+}
@@ -0,0 +1,58 @@
+package rapidpro
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/valkey"
+)
+
+// while a message is being sent, we hold a claim on its UUID so that no other sender can send it at the same time
+const sendingKeyPrefix = "sending:"
+
+// claims outlive any send so that if a worker dies mid-send, a redelivery of its message shortly after is still caught
+const sendingClaimTTL = 5 * time.Minute
+
+// claim keys are tagged individually so that claims are spread across the slots of a cluster
+func sendingKey(uuid courier.MsgUUID) string {
+	return valkey.Tag(sendingKeyPrefix + string(uuid))
+}
+
+// claims the sending of the given message, returning false if it's already claimed by another send
+func claimMsgSend(rc redis.Conn, uuid courier.MsgUUID) (bool, error) {
+	_, err := redis.String(rc.Do("SET", sendingKey(uuid), time.Now().UTC().Format(time.RFC3339Nano), "NX", "EX", int(sendingClaimTTL/time.Second)))
+	if err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error claiming msg send: %w", err)
+	}
+	return true, nil
+}
+
+// releases the claim on sending the given message, which is then protected by our sent IDs if it won't be retried
+func releaseMsgSend(rc redis.Conn, uuid courier.MsgUUID) error {
+	if _, err := rc.Do("DEL", sendingKey(uuid)); err != nil {
+		return fmt.Errorf("error releasing msg send: %w", err)
+	}
+	return nil
+}
+
+// returns whether the given message is already being sent and so shouldn't be sent again. Errors are logged and the
+// message sent anyway rather than risk losing it.
+func (b *backend) isDuplicateSend(msg *Msg) bool {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	claimed, err := claimMsgSend(rc, msg.UUID_)
+	if err != nil {
+		slog.Error("error checking for duplicate send", "error", err, "msg_id", msg.ID_)
+		return false
+	}
+	if !claimed {
+		slog.Warn("msg is already being sent, suppressing duplicate send", "msg_id", msg.ID_, "msg_uuid", msg.UUID_, "channel_uuid", msg.ChannelUUID_)
+	}
+	return !claimed
+}
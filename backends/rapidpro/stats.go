@@ -31,6 +31,17 @@ func (c DurationByType) metrics(name string, avgDenom func(courier.ChannelType)
 	return m
 }
 
+// converts per type numerator and denominator counts into percentage metrics for types with a non-zero denominator
+func ratioMetrics(name string, num, denom CountByType) []types.MetricDatum {
+	m := make([]types.MetricDatum, 0, len(denom))
+	for typ, d := range denom {
+		if d > 0 {
+			m = append(m, cwatch.Datum(name, 100*float64(num[typ])/float64(d), types.StandardUnitPercent, cwatch.Dimension("ChannelType", string(typ))))
+		}
+	}
+	return m
+}
+
 type Stats struct {
 	IncomingRequests CountByType    // number of handler requests
 	IncomingMessages CountByType    // number of messages received
@@ -41,7 +52,18 @@ type Stats struct {
 
 	OutgoingSends    CountByType    // number of sends that succeeded
 	OutgoingErrors   CountByType    // number of sends that errored
+	OutgoingTimeouts CountByType    // number of sends with requests that timed out
 	OutgoingDuration DurationByType // total time spent sending messages
+	OutgoingSegments CountByType    // number of SMS segments sent
+	OutgoingDeferred CountByType    // number of sends deferred because the channel's circuit was open
+	CircuitsOpened   CountByType    // number of times a channel's circuit was opened
+
+	RepliesExpected CountByType    // number of sent messages which expect a reply
+	RepliesReceived CountByType    // number of replies received to messages which expected one
+	ReplyLatency    DurationByType // total time between sending messages and receiving their replies
+
+	AttachmentsInfected     CountByType // number of attachments rejected by anti-virus scanning
+	AttachmentsDeduplicated CountByType // number of attachments not stored because an identical one already was
 
 	ContactsCreated int
 }
@@ -57,7 +79,18 @@ func newStats() *Stats {
 
 		OutgoingSends:    make(CountByType),
 		OutgoingErrors:   make(CountByType),
+		OutgoingTimeouts: make(CountByType),
 		OutgoingDuration: make(DurationByType),
+		OutgoingSegments: make(CountByType),
+		OutgoingDeferred: make(CountByType),
+		CircuitsOpened:   make(CountByType),
+
+		RepliesExpected: make(CountByType),
+		RepliesReceived: make(CountByType),
+		ReplyLatency:    make(DurationByType),
+
+		AttachmentsInfected:     make(CountByType),
+		AttachmentsDeduplicated: make(CountByType),
 
 		ContactsCreated: 0,
 	}
@@ -74,7 +107,19 @@ func (s *Stats) ToMetrics() []types.MetricDatum {
 
 	metrics = append(metrics, s.OutgoingSends.metrics("OutgoingSends")...)
 	metrics = append(metrics, s.OutgoingErrors.metrics("OutgoingErrors")...)
+	metrics = append(metrics, s.OutgoingTimeouts.metrics("OutgoingTimeouts")...)
 	metrics = append(metrics, s.OutgoingDuration.metrics("OutgoingDuration", func(typ courier.ChannelType) int { return s.OutgoingSends[typ] + s.OutgoingErrors[typ] })...)
+	metrics = append(metrics, s.OutgoingSegments.metrics("OutgoingSegments")...)
+	metrics = append(metrics, s.OutgoingDeferred.metrics("OutgoingDeferred")...)
+	metrics = append(metrics, s.CircuitsOpened.metrics("CircuitsOpened")...)
+
+	metrics = append(metrics, s.RepliesExpected.metrics("RepliesExpected")...)
+	metrics = append(metrics, s.RepliesReceived.metrics("RepliesReceived")...)
+	metrics = append(metrics, s.ReplyLatency.metrics("ReplyLatency", func(typ courier.ChannelType) int { return s.RepliesReceived[typ] })...)
+	metrics = append(metrics, ratioMetrics("ReplyRate", s.RepliesReceived, s.RepliesExpected)...)
+
+	metrics = append(metrics, s.AttachmentsInfected.metrics("AttachmentsInfected")...)
+	metrics = append(metrics, s.AttachmentsDeduplicated.metrics("AttachmentsDeduplicated")...)
 
 	metrics = append(metrics, cwatch.Datum("ContactsCreated", float64(s.ContactsCreated), types.StandardUnitCount))
 	return metrics
@@ -124,6 +169,55 @@ func (c *StatsCollector) RecordOutgoing(typ courier.ChannelType, success bool, d
 	c.mutex.Unlock()
 }
 
+func (c *StatsCollector) RecordOutgoingSegments(typ courier.ChannelType, segments int) {
+	c.mutex.Lock()
+	c.stats.OutgoingSegments[typ] += segments
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordOutgoingTimeout(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.OutgoingTimeouts[typ]++
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordOutgoingDeferred(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.OutgoingDeferred[typ]++
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordCircuitOpened(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.CircuitsOpened[typ]++
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordReplyExpected(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.RepliesExpected[typ]++
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordReplyReceived(typ courier.ChannelType, latency time.Duration) {
+	c.mutex.Lock()
+	c.stats.RepliesReceived[typ]++
+	c.stats.ReplyLatency[typ] += latency
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordAttachmentInfected(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.AttachmentsInfected[typ]++
+	c.mutex.Unlock()
+}
+
+func (c *StatsCollector) RecordAttachmentDeduplicated(typ courier.ChannelType) {
+	c.mutex.Lock()
+	c.stats.AttachmentsDeduplicated[typ]++
+	c.mutex.Unlock()
+}
+
 func (c *StatsCollector) RecordContactCreated() {
 	c.mutex.Lock()
 	c.stats.ContactsCreated++
@@ -21,6 +21,16 @@ func TestStats(t *testing.T) {
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
+	sc.RecordOutgoing("FBA", false, time.Second)
+	sc.RecordOutgoingSegments("T", 2)
+	sc.RecordOutgoingSegments("T", 1)
+	sc.RecordOutgoingTimeout("FBA")
+	sc.RecordOutgoingDeferred("FBA")
+	sc.RecordOutgoingDeferred("FBA")
+	sc.RecordCircuitOpened("FBA")
+	sc.RecordAttachmentInfected("T")
+	sc.RecordAttachmentDeduplicated("FBA")
+	sc.RecordAttachmentDeduplicated("FBA")
 
 	stats := sc.Extract()
 
@@ -31,11 +41,17 @@ func TestStats(t *testing.T) {
 	assert.Equal(t, rapidpro.CountByType{}, stats.IncomingEvents)
 	assert.Equal(t, rapidpro.DurationByType{"T": time.Second}, stats.IncomingDuration)
 	assert.Equal(t, rapidpro.CountByType{"T": 2, "FBA": 3}, stats.OutgoingSends)
-	assert.Equal(t, rapidpro.CountByType{}, stats.OutgoingErrors)
-	assert.Equal(t, rapidpro.DurationByType{"T": time.Second * 2, "FBA": time.Second * 3}, stats.OutgoingDuration)
+	assert.Equal(t, rapidpro.CountByType{"FBA": 1}, stats.OutgoingErrors)
+	assert.Equal(t, rapidpro.CountByType{"FBA": 1}, stats.OutgoingTimeouts)
+	assert.Equal(t, rapidpro.CountByType{"T": 3}, stats.OutgoingSegments)
+	assert.Equal(t, rapidpro.DurationByType{"T": time.Second * 2, "FBA": time.Second * 4}, stats.OutgoingDuration)
+	assert.Equal(t, rapidpro.CountByType{"FBA": 2}, stats.OutgoingDeferred)
+	assert.Equal(t, rapidpro.CountByType{"FBA": 1}, stats.CircuitsOpened)
+	assert.Equal(t, rapidpro.CountByType{"T": 1}, stats.AttachmentsInfected)
+	assert.Equal(t, rapidpro.CountByType{"FBA": 2}, stats.AttachmentsDeduplicated)
 
 	metrics := stats.ToMetrics()
-	assert.Len(t, metrics, 8)
+	assert.Len(t, metrics, 15)
 
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
@@ -50,6 +66,7 @@ func TestStats(t *testing.T) {
 	assert.Equal(t, rapidpro.DurationByType{}, stats.IncomingDuration)
 	assert.Equal(t, rapidpro.CountByType{"FBA": 2}, stats.OutgoingSends)
 	assert.Equal(t, rapidpro.CountByType{}, stats.OutgoingErrors)
+	assert.Equal(t, rapidpro.CountByType{}, stats.OutgoingTimeouts)
 	assert.Equal(t, rapidpro.DurationByType{"FBA": time.Second * 2}, stats.OutgoingDuration)
 
 	metrics = stats.ToMetrics()
@@ -59,4 +76,23 @@ func TestStats(t *testing.T) {
 		cwatch.Datum("OutgoingDuration", 1, "Seconds", cwatch.Dimension("ChannelType", "FBA")),
 		cwatch.Datum("ContactsCreated", 0, "Count"),
 	}, metrics)
+
+	sc.RecordReplyExpected("WAC")
+	sc.RecordReplyExpected("WAC")
+	sc.RecordReplyExpected("WAC")
+	sc.RecordReplyExpected("WAC")
+	sc.RecordReplyReceived("WAC", time.Second*2)
+	sc.RecordReplyReceived("WAC", time.Second*4)
+	sc.RecordReplyReceived("T", time.Second*3) // reply to message sent in previous period
+
+	stats = sc.Extract()
+
+	assert.Equal(t, rapidpro.CountByType{"WAC": 4}, stats.RepliesExpected)
+	assert.Equal(t, rapidpro.CountByType{"WAC": 2, "T": 1}, stats.RepliesReceived)
+	assert.Equal(t, rapidpro.DurationByType{"WAC": time.Second * 6, "T": time.Second * 3}, stats.ReplyLatency)
+
+	metrics = stats.ToMetrics()
+	assert.Len(t, metrics, 7)
+	assert.Contains(t, metrics, cwatch.Datum("ReplyLatency", 3, "Seconds", cwatch.Dimension("ChannelType", "WAC")))
+	assert.Contains(t, metrics, cwatch.Datum("ReplyRate", 50, "Percent", cwatch.Dimension("ChannelType", "WAC")))
 }
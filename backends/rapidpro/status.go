@@ -12,42 +12,85 @@ import (
 	"time"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/batch"
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/dbutil"
-	"github.com/nyaruka/gocommon/syncx"
 	"github.com/nyaruka/gocommon/urns"
 )
 
 // StatusUpdate represents a status update on a message
 type StatusUpdate struct {
-	ChannelUUID_ courier.ChannelUUID `json:"channel_uuid"             db:"channel_uuid"`
-	ChannelID_   courier.ChannelID   `json:"channel_id"               db:"channel_id"`
-	MsgID_       courier.MsgID       `json:"msg_id,omitempty"         db:"msg_id"`
-	OldURN_      urns.URN            `json:"old_urn"                  db:"old_urn"`
-	NewURN_      urns.URN            `json:"new_urn"                  db:"new_urn"`
-	ExternalID_  string              `json:"external_id,omitempty"    db:"external_id"`
-	Status_      courier.MsgStatus   `json:"status"                   db:"status"`
-	ModifiedOn_  time.Time           `json:"modified_on"              db:"modified_on"`
-	LogUUID      clogs.LogUUID       `json:"log_uuid"                 db:"log_uuid"`
+	ChannelUUID_  courier.ChannelUUID     `json:"channel_uuid"             db:"channel_uuid"`
+	ChannelID_    courier.ChannelID       `json:"channel_id"               db:"channel_id"`
+	MsgID_        courier.MsgID           `json:"msg_id,omitempty"         db:"msg_id"`
+	OldURN_       urns.URN                `json:"old_urn"                  db:"old_urn"`
+	NewURN_       urns.URN                `json:"new_urn"                  db:"new_urn"`
+	ExternalID_   string                  `json:"external_id,omitempty"    db:"external_id"`
+	Status_       courier.MsgStatus       `json:"status"                   db:"status"`
+	FailedReason_ courier.MsgFailedReason `json:"failed_reason,omitempty"  db:"failed_reason"`
+	Fallback_     courier.ChannelUUID     `json:"fallback,omitempty"       db:"fallback"`
+	Segments_     int                     `json:"segments,omitempty"       db:"segments"`
+	ModifiedOn_   time.Time               `json:"modified_on"              db:"modified_on"`
+	LogUUID       clogs.LogUUID           `json:"log_uuid"                 db:"log_uuid"`
+
+	// retry policy of the channel, used to schedule the next attempt if this is an error
+	MaxRetries_   int `json:"max_retries"   db:"max_retries"`
+	RetryBackoff_ int `json:"retry_backoff" db:"retry_backoff"`
+	RetryJitter_  int `json:"retry_jitter"  db:"retry_jitter"`
 }
 
 // creates a new message status update
 func newStatusUpdate(channel courier.Channel, id courier.MsgID, externalID string, status courier.MsgStatus, clog *courier.ChannelLog) *StatusUpdate {
 	dbChannel := channel.(*Channel)
+	retries := retryPolicyForChannel(dbChannel)
 
 	return &StatusUpdate{
-		ChannelUUID_: channel.UUID(),
-		ChannelID_:   dbChannel.ID(),
-		MsgID_:       id,
-		OldURN_:      urns.NilURN,
-		NewURN_:      urns.NilURN,
-		ExternalID_:  externalID,
-		Status_:      status,
-		ModifiedOn_:  time.Now().In(time.UTC),
-		LogUUID:      clog.UUID,
+		ChannelUUID_:  channel.UUID(),
+		ChannelID_:    dbChannel.ID(),
+		MsgID_:        id,
+		OldURN_:       urns.NilURN,
+		NewURN_:       urns.NilURN,
+		ExternalID_:   externalID,
+		Status_:       status,
+		ModifiedOn_:   time.Now().In(time.UTC),
+		LogUUID:       clog.UUID,
+		MaxRetries_:   retries.MaxRetries,
+		RetryBackoff_: retries.Backoff,
+		RetryJitter_:  retries.Jitter,
 	}
 }
 
+// retryPolicy controls how errored messages are retried, with backoff and jitter in seconds
+type retryPolicy struct {
+	MaxRetries int `json:"max_retries"`
+	Backoff    int `json:"backoff"`
+	Jitter     int `json:"jitter"`
+}
+
+// the default is to retry twice, 5 and then 10 minutes after each error
+var defaultRetryPolicy = retryPolicy{MaxRetries: 2, Backoff: 300, Jitter: 0}
+
+// returns the retry policy for the given channel, which can be overridden by its retry_policy config value
+func retryPolicyForChannel(channel *Channel) retryPolicy {
+	policy := defaultRetryPolicy
+
+	config, isMap := channel.ConfigForKey(courier.ConfigRetryPolicy, nil).(map[string]any)
+	if !isMap {
+		return policy
+	}
+
+	if v, isNum := config["max_retries"].(float64); isNum && v >= 0 {
+		policy.MaxRetries = int(v)
+	}
+	if v, isNum := config["backoff"].(float64); isNum && v > 0 {
+		policy.Backoff = int(v)
+	}
+	if v, isNum := config["jitter"].(float64); isNum && v >= 0 {
+		policy.Jitter = int(v)
+	}
+	return policy
+}
+
 // the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
 const sqlUpdateMsgByID = `
 UPDATE msgs_msg SET 
@@ -56,7 +99,7 @@ UPDATE msgs_msg SET
 			s.status = 'E' 
 		THEN CASE 
 			WHEN 
-				error_count >= 2 OR msgs_msg.status = 'F' 
+				error_count >= s.max_retries::int OR msgs_msg.status = 'F' 
 			THEN 
 				'F' 
 			ELSE 
@@ -77,13 +120,17 @@ UPDATE msgs_msg SET
 		WHEN 
 			s.status = 'E' 
 		THEN 
-			NOW() + (5 * (error_count+1) * interval '1 minutes') 
+			NOW() + (s.retry_backoff::int * (error_count+1) * interval '1 second') + (floor(random() * (s.retry_jitter::int + 1)) * interval '1 second') 
 		ELSE 
 			next_attempt 
 		END,
 	failed_reason = CASE
 		WHEN
-			error_count >= 2
+			s.failed_reason != ''
+		THEN
+			s.failed_reason
+		WHEN
+			error_count >= s.max_retries::int
 		THEN
 			'E'
 		ELSE
@@ -105,12 +152,20 @@ UPDATE msgs_msg SET
 		ELSE
 			msgs_msg.external_id
 		END,
+	msg_count = CASE
+		WHEN
+			s.segments::int > 0
+		THEN
+			s.segments::int
+		ELSE
+			msg_count
+		END,
 	modified_on = NOW(),
 	log_uuids = array_append(log_uuids, s.log_uuid::uuid)
 FROM
-	(VALUES(:msg_id, :channel_id, :status, :external_id, :log_uuid)) 
+	(VALUES(:msg_id, :channel_id, :status, :failed_reason, :external_id, :log_uuid, :max_retries, :retry_backoff, :retry_jitter, :segments)) 
 AS 
-	s(msg_id, channel_id, status, external_id, log_uuid) 
+	s(msg_id, channel_id, status, failed_reason, external_id, log_uuid, max_retries, retry_backoff, retry_jitter, segments) 
 WHERE 
 	msgs_msg.id = s.msg_id::bigint AND
 	msgs_msg.channel_id = s.channel_id::int AND 
@@ -127,6 +182,13 @@ func (b *backend) flushStatusFile(filename string, contents []byte) error {
 		return nil
 	}
 
+	// statuses spooled before retry policies existed won't have one
+	if status.RetryBackoff_ == 0 {
+		status.MaxRetries_ = defaultRetryPolicy.MaxRetries
+		status.RetryBackoff_ = defaultRetryPolicy.Backoff
+		status.RetryJitter_ = defaultRetryPolicy.Jitter
+	}
+
 	// try to flush to our db
 	_, err = b.writeStatusUpdatesToDB(ctx, []*StatusUpdate{status})
 	return err
@@ -163,27 +225,44 @@ func (s *StatusUpdate) SetExternalID(id string) { s.ExternalID_ = id }
 func (s *StatusUpdate) Status() courier.MsgStatus          { return s.Status_ }
 func (s *StatusUpdate) SetStatus(status courier.MsgStatus) { s.Status_ = status }
 
+func (s *StatusUpdate) FailedReason() courier.MsgFailedReason          { return s.FailedReason_ }
+func (s *StatusUpdate) SetFailedReason(reason courier.MsgFailedReason) { s.FailedReason_ = reason }
+
+func (s *StatusUpdate) FallbackChannel() courier.ChannelUUID { return s.Fallback_ }
+
+// SetFallbackChannel records that the message has been moved to the given channel, which is then the channel we match
+// the message on when writing this update
+func (s *StatusUpdate) SetFallbackChannel(channel courier.Channel) {
+	s.Fallback_ = channel.UUID()
+	s.ChannelID_ = channel.(*Channel).ID()
+}
+
+// Segments returns the number of SMS segments sent for the message, which is written to its msg_count
+func (s *StatusUpdate) Segments() int     { return s.Segments_ }
+func (s *StatusUpdate) SetSegments(n int) { s.Segments_ = n }
+
 // StatusWriter handles batched writes of status updates to the database
 type StatusWriter struct {
-	*syncx.Batcher[*StatusUpdate]
+	*batch.Batcher[*StatusUpdate]
 }
 
 // NewStatusWriter creates a new status update writer
 func NewStatusWriter(b *backend, spoolDir string, wg *sync.WaitGroup) *StatusWriter {
 	return &StatusWriter{
-		Batcher: syncx.NewBatcher[*StatusUpdate](func(batch []*StatusUpdate) {
+		Batcher: batch.NewBatcher(func(batch []*StatusUpdate) int {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 			defer cancel()
 
-			b.writeStatuseUpdates(ctx, spoolDir, batch)
-
-		}, 1000, time.Millisecond*500, 1000, wg),
+			return b.writeStatuseUpdates(ctx, spoolDir, batch)
+		}, 1000, time.Millisecond*500, 1000, b.clock, wg),
 	}
 }
 
-// tries to write a batch of message statuses to the database and spools those that fail
-func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batch []*StatusUpdate) {
+// tries to write a batch of message statuses to the database and spools those that fail, returning how many couldn't
+// be written to either
+func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batch []*StatusUpdate) int {
 	log := slog.With("comp", "status writer")
+	failed := 0
 
 	unresolved, err := b.writeStatusUpdatesToDB(ctx, batch)
 
@@ -204,6 +283,7 @@ func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batc
 				err := courier.WriteToSpool(spoolDir, "statuses", s)
 				if err != nil {
 					log.Error("error writing status to spool", "error", err) // just have to log and move on
+					failed++
 				}
 			}
 		}
@@ -212,6 +292,7 @@ func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batc
 			log.Warn(fmt.Sprintf("unable to find message with channel_id=%d and external_id=%s", s.ChannelID_, s.ExternalID_))
 		}
 	}
+	return failed
 }
 
 // writes a batch of msg status updates to the database - messages that can't be resolved are returned and aren't
@@ -248,6 +329,8 @@ func (b *backend) writeStatusUpdatesToDB(ctx context.Context, statuses []*Status
 		return nil, fmt.Errorf("error updating status: %w", err)
 	}
 
+	b.traceStatusUpdates(resolved)
+
 	return unresolved, nil
 }
 
@@ -324,3 +407,17 @@ func (b *backend) resolveStatusUpdateMsgIDs(ctx context.Context, statuses []*Sta
 
 	return rows.Err()
 }
+
+// records the given written status updates in the traces of their messages
+func (b *backend) traceStatusUpdates(statuses []*StatusUpdate) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	for _, s := range statuses {
+		event := &courier.MsgTimelineEvent{Type: courier.MsgTimelineStatus, CreatedOn: s.ModifiedOn_, Status: s.Status_, LogUUID: s.LogUUID}
+
+		if err := traceMsgEvent(rc, s.MsgID_, event); err != nil {
+			slog.Error("error tracing status update", "error", err, "msg_id", s.MsgID_)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package rapidpro
+
+import (
+	"testing"
+
+	"github.com/nyaruka/null/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyForChannel(t *testing.T) {
+	tcs := []struct {
+		config   map[string]any
+		expected retryPolicy
+	}{
+		{nil, retryPolicy{MaxRetries: 2, Backoff: 300, Jitter: 0}},
+		{map[string]any{"retry_policy": "foo"}, retryPolicy{MaxRetries: 2, Backoff: 300, Jitter: 0}},
+		{map[string]any{"retry_policy": map[string]any{"max_retries": 5.0}}, retryPolicy{MaxRetries: 5, Backoff: 300, Jitter: 0}},
+		{map[string]any{"retry_policy": map[string]any{"max_retries": 0.0, "backoff": 30.0, "jitter": 10.0}}, retryPolicy{MaxRetries: 0, Backoff: 30, Jitter: 10}},
+		{map[string]any{"retry_policy": map[string]any{"max_retries": -1.0, "backoff": 0.0, "jitter": "x"}}, retryPolicy{MaxRetries: 2, Backoff: 300, Jitter: 0}},
+	}
+
+	for _, tc := range tcs {
+		channel := &Channel{Config_: null.Map[any](tc.config)}
+		assert.Equal(t, tc.expected, retryPolicyForChannel(channel), "retry policy mismatch for config %v", tc.config)
+	}
+}
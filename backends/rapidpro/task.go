@@ -6,6 +6,7 @@ import (
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/gocommon/jsonx"
 )
 
@@ -70,11 +71,12 @@ func queueMailroomTask(rc redis.Conn, taskType string, orgID OrgID, contactID Co
 	epochFloat := float64(now.UnixNano()) / float64(time.Second)
 
 	// we do all our queueing in a transaction
-	contactQueue := fmt.Sprintf("c:%d:%d", orgID, contactID)
+	// in cluster mode these keys share a hash tag so that the transaction is within a single slot
+	contactQueue := valkey.WithTag("tasks", fmt.Sprintf("c:%d:%d", orgID, contactID))
 	rc.Send("MULTI")
 	rc.Send("RPUSH", contactQueue, eventJSON)
-	rc.Send("ZADD", fmt.Sprintf("tasks:handler:%d", orgID), fmt.Sprintf("%.5f", epochFloat-10000000), contactJSON)
-	rc.Send("ZINCRBY", "tasks:handler:active", 0, orgID)
+	rc.Send("ZADD", valkey.WithTag("tasks", fmt.Sprintf("tasks:handler:%d", orgID)), fmt.Sprintf("%.5f", epochFloat-10000000), contactJSON)
+	rc.Send("ZINCRBY", valkey.WithTag("tasks", "tasks:handler:active"), 0, orgID)
 	_, err = rc.Do("EXEC")
 
 	return err
@@ -0,0 +1,201 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// each outgoing message has a redis list of the things that happened to it which aren't recorded anywhere else
+const msgTraceKeyPrefix = "msg_trace:"
+
+// traces are kept as long as channel logs are
+const msgTraceTTL = 7 * 24 * time.Hour
+
+// maximum number of channel logs we'll read for a single timeline, which is the most DynamoDB will batch get
+const maxTimelineLogs = 100
+
+func msgTraceKey(id courier.MsgID) string {
+	return msgTraceKeyPrefix + id.String()
+}
+
+// appends the given event to the trace of the given message
+func traceMsgEvent(rc redis.Conn, id courier.MsgID, event *courier.MsgTimelineEvent) error {
+	key := msgTraceKey(id)
+
+	rc.Send("RPUSH", key, jsonx.MustMarshal(event))
+	rc.Send("EXPIRE", key, int(msgTraceTTL/time.Second))
+	if _, err := rc.Do(""); err != nil {
+		return fmt.Errorf("error tracing msg event: %w", err)
+	}
+	return nil
+}
+
+// reads the trace of the given message, oldest event first
+func readMsgTrace(rc redis.Conn, id courier.MsgID) ([]*courier.MsgTimelineEvent, error) {
+	values, err := redis.ByteSlices(rc.Do("LRANGE", msgTraceKey(id), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading msg trace: %w", err)
+	}
+
+	events := make([]*courier.MsgTimelineEvent, 0, len(values))
+	for _, v := range values {
+		e := &courier.MsgTimelineEvent{}
+		if err := json.Unmarshal(v, e); err != nil {
+			slog.Error("error unmarshalling msg trace event", "error", err, "msg_id", id)
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// records that the given message was popped from its queue, logging rather than failing on errors
+func (b *backend) tracePopped(msg *Msg) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if err := traceMsgEvent(rc, msg.ID_, &courier.MsgTimelineEvent{Type: courier.MsgTimelinePopped, CreatedOn: time.Now().In(time.UTC)}); err != nil {
+		slog.Error("error tracing popped msg", "error", err, "msg_id", msg.ID_)
+	}
+}
+
+// MsgTimeline returns the delivery timeline of the outgoing message with the given UUID
+func (b *backend) MsgTimeline(ctx context.Context, uuid courier.MsgUUID) (*courier.MsgTimeline, error) {
+	m, err := b.readArchivedMsg(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := b.rp.Get()
+	traced, err := readMsgTrace(rc, m.ID_)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := b.readTimelineLogs(ctx, m.LogUUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildMsgTimeline(m, traced, logs), nil
+}
+
+// reads the channel logs with the given UUIDs from DynamoDB, ignoring any which have expired
+func (b *backend) readTimelineLogs(ctx context.Context, uuids []string) (map[clogs.LogUUID]*clogs.Log, error) {
+	logs := make(map[clogs.LogUUID]*clogs.Log, len(uuids))
+
+	// a message can reference the same log more than once but DynamoDB doesn't allow duplicate keys
+	uuids = slices.Compact(slices.Sorted(slices.Values(uuids)))
+	if len(uuids) > maxTimelineLogs {
+		uuids = uuids[len(uuids)-maxTimelineLogs:] // v7 UUIDs so these are the newest
+	}
+	if len(uuids) == 0 {
+		return logs, nil
+	}
+
+	keys := make([]map[string]types.AttributeValue, len(uuids))
+	for i, u := range uuids {
+		keys[i] = map[string]types.AttributeValue{"UUID": &types.AttributeValueMemberS{Value: u}}
+	}
+
+	table := b.dynamo.TableName("ChannelLogs")
+	resp, err := b.dynamo.Client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{table: {Keys: keys}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading channel logs from dynamo: %w", err)
+	}
+
+	for _, item := range resp.Responses[table] {
+		l := &clogs.Log{}
+		if err := l.UnmarshalDynamo(item); err != nil {
+			return nil, fmt.Errorf("error unmarshalling channel log: %w", err)
+		}
+		logs[l.UUID] = l
+	}
+
+	return logs, nil
+}
+
+// builds a timeline for the given message from its trace and channel logs
+func buildMsgTimeline(m *archivedMsg, traced []*courier.MsgTimelineEvent, logs map[clogs.LogUUID]*clogs.Log) *courier.MsgTimeline {
+	// mailroom creates messages and queues them at the same time
+	events := []*courier.MsgTimelineEvent{{Type: courier.MsgTimelineQueued, CreatedOn: m.CreatedOn_}}
+
+	// the sender writes a status update for each send attempt which we don't need to repeat
+	attempts := make(map[clogs.LogUUID]bool)
+	for _, e := range traced {
+		if e.Type == courier.MsgTimelineSendAttempt {
+			attempts[e.LogUUID] = true
+		}
+	}
+
+	seenLogs := make(map[clogs.LogUUID]bool)
+	for _, e := range traced {
+		if e.Type == courier.MsgTimelineStatus && attempts[e.LogUUID] {
+			continue
+		}
+
+		if l := logs[e.LogUUID]; l != nil {
+			addLogToTimelineEvent(e, l)
+			seenLogs[e.LogUUID] = true
+		}
+		events = append(events, e)
+	}
+
+	// logs which aren't in our trace are either older than it or from before we started tracing
+	for _, u := range slices.Sorted(maps.Keys(logs)) {
+		l := logs[u]
+		if seenLogs[u] {
+			continue
+		}
+
+		var e *courier.MsgTimelineEvent
+		switch l.Type {
+		case courier.ChannelLogTypeMsgSend:
+			e = &courier.MsgTimelineEvent{Type: courier.MsgTimelineSendAttempt, CreatedOn: l.CreatedOn, LogUUID: l.UUID, ElapsedMS: int(l.Elapsed / time.Millisecond)}
+		case courier.ChannelLogTypeMsgStatus:
+			e = &courier.MsgTimelineEvent{Type: courier.MsgTimelineStatus, CreatedOn: l.CreatedOn, LogUUID: l.UUID}
+		default:
+			continue
+		}
+
+		addLogToTimelineEvent(e, l)
+		events = append(events, e)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedOn.Before(events[j].CreatedOn) })
+
+	return &courier.MsgTimeline{
+		MsgUUID:     m.UUID_,
+		ChannelUUID: m.ChannelUUID,
+		Status:      m.Status_,
+		Events:      events,
+	}
+}
+
+// adds the details only the channel log has to the given timeline event
+func addLogToTimelineEvent(e *courier.MsgTimelineEvent, l *clogs.Log) {
+	if len(e.Errors) == 0 && len(l.Errors) > 0 {
+		e.Errors = l.Errors
+	}
+
+	// for incoming requests the first HTTP log is always the request made to us
+	if e.Type == courier.MsgTimelineStatus && len(l.HttpLogs) > 0 {
+		e.Request = l.HttpLogs[0].Request
+	}
+}
@@ -0,0 +1,71 @@
+package rapidpro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildMsgTimeline(t *testing.T) {
+	t0 := time.Date(2025, 10, 1, 12, 30, 0, 0, time.UTC)
+
+	msg := &archivedMsg{
+		Msg:         Msg{UUID_: "0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b", Status_: courier.MsgStatusDelivered, CreatedOn_: t0},
+		ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+	}
+
+	traced := []*courier.MsgTimelineEvent{
+		{Type: courier.MsgTimelinePopped, CreatedOn: t0.Add(time.Second)},
+		{Type: courier.MsgTimelineSendAttempt, CreatedOn: t0.Add(2 * time.Second), Status: courier.MsgStatusWired, LogUUID: "0199a7b6-0000-7000-8000-000000000002", ElapsedMS: 345},
+		{Type: courier.MsgTimelineStatus, CreatedOn: t0.Add(3 * time.Second), Status: courier.MsgStatusWired, LogUUID: "0199a7b6-0000-7000-8000-000000000002"},
+		{Type: courier.MsgTimelineStatus, CreatedOn: t0.Add(6 * time.Second), Status: courier.MsgStatusDelivered, LogUUID: "0199a7b6-0000-7000-8000-000000000003"},
+	}
+
+	logs := map[clogs.LogUUID]*clogs.Log{
+		// a send attempt from before the trace starts
+		"0199a7b6-0000-7000-8000-000000000001": {
+			UUID:      "0199a7b6-0000-7000-8000-000000000001",
+			Type:      courier.ChannelLogTypeMsgSend,
+			Errors:    []*clogs.LogError{courier.ErrorResponseStatusCode()},
+			CreatedOn: t0.Add(500 * time.Millisecond),
+			Elapsed:   time.Second,
+		},
+		"0199a7b6-0000-7000-8000-000000000002": {
+			UUID:      "0199a7b6-0000-7000-8000-000000000002",
+			Type:      courier.ChannelLogTypeMsgSend,
+			CreatedOn: t0.Add(2 * time.Second),
+		},
+		"0199a7b6-0000-7000-8000-000000000003": {
+			UUID:      "0199a7b6-0000-7000-8000-000000000003",
+			Type:      courier.ChannelLogTypeMsgStatus,
+			HttpLogs:  []*httpx.Log{{LogWithoutTime: &httpx.LogWithoutTime{Request: "POST /c/ex/dbc126ed/delivered?ts=1759321805 HTTP/1.1\r\n\r\n"}}},
+			CreatedOn: t0.Add(6 * time.Second),
+		},
+		// not related to delivery
+		"0199a7b6-0000-7000-8000-000000000004": {
+			UUID:      "0199a7b6-0000-7000-8000-000000000004",
+			Type:      courier.ChannelLogTypeAttachmentFetch,
+			CreatedOn: t0.Add(7 * time.Second),
+		},
+	}
+
+	timeline := buildMsgTimeline(msg, traced, logs)
+	assert.Equal(t, courier.MsgUUID("0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b"), timeline.MsgUUID)
+	assert.Equal(t, courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), timeline.ChannelUUID)
+	assert.Equal(t, courier.MsgStatusDelivered, timeline.Status)
+	assert.Equal(t, []*courier.MsgTimelineEvent{
+		{Type: courier.MsgTimelineQueued, CreatedOn: t0},
+		{Type: courier.MsgTimelineSendAttempt, CreatedOn: t0.Add(500 * time.Millisecond), LogUUID: "0199a7b6-0000-7000-8000-000000000001", ElapsedMS: 1000, Errors: []*clogs.LogError{courier.ErrorResponseStatusCode()}},
+		{Type: courier.MsgTimelinePopped, CreatedOn: t0.Add(time.Second)},
+		{Type: courier.MsgTimelineSendAttempt, CreatedOn: t0.Add(2 * time.Second), Status: courier.MsgStatusWired, LogUUID: "0199a7b6-0000-7000-8000-000000000002", ElapsedMS: 345},
+		{Type: courier.MsgTimelineStatus, CreatedOn: t0.Add(6 * time.Second), Status: courier.MsgStatusDelivered, LogUUID: "0199a7b6-0000-7000-8000-000000000003", Request: "POST /c/ex/dbc126ed/delivered?ts=1759321805 HTTP/1.1\r\n\r\n"},
+	}, timeline.Events)
+
+	// a message that's never been popped only has its queued event
+	timeline = buildMsgTimeline(msg, nil, nil)
+	assert.Equal(t, []*courier.MsgTimelineEvent{{Type: courier.MsgTimelineQueued, CreatedOn: t0}}, timeline.Events)
+}
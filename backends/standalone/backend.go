@@ -0,0 +1,458 @@
+package standalone
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/dbutil"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/redisx"
+)
+
+// the name of our msg queue, which is the same as that of the rapidpro backend so that anything which can queue
+// messages like mailroom does can queue messages for us
+const msgQueueName = "msgs"
+
+// the key of the counter we use to give IDs to messages queued via QueueMsg
+func lastMsgIDKey() string { return valkey.WithTag("msgs", "last-msg-id") }
+
+// our timeout for backend operations
+const backendTimeout = time.Second * 20
+
+// timeout for requests to channel providers and our webhook
+const maxRequestTimeout = 2 * time.Minute
+
+func init() {
+	courier.RegisterBackend("standalone", newBackend)
+}
+
+// backend is a backend for using courier as a channel gateway without RapidPro. Channels are read from a JSON file,
+// outgoing messages are queued in Valkey and incoming messages, statuses and channel events are POSTed to a webhook.
+type backend struct {
+	config *courier.Config
+
+	rp valkey.Pool
+
+	channels          map[courier.ChannelUUID]*Channel
+	channelsByAddress map[courier.ChannelAddress]*Channel
+	channelsMutex     sync.RWMutex
+
+	httpClient         *http.Client
+	httpClientInsecure *http.Client
+	httpAccess         *httpx.AccessConfig
+
+	stopChan  chan bool
+	waitGroup *sync.WaitGroup
+
+	receivedExternalIDs *redisx.IntervalHash // using external id
+	sentIDs             *redisx.IntervalSet  // using id
+}
+
+func newBackend(cfg *courier.Config) courier.Backend {
+	// key names depend on whether we're using a cluster so this needs to be set before we create any
+	valkey.SetCluster(cfg.RedisCluster)
+
+	disallowedIPs, disallowedNets, _ := cfg.ParseDisallowedNetworks()
+
+	insecureTransport := http.DefaultTransport.(*http.Transport).Clone()
+	insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return &backend{
+		config: cfg,
+
+		httpClient:         &http.Client{Timeout: maxRequestTimeout},
+		httpClientInsecure: &http.Client{Transport: insecureTransport, Timeout: maxRequestTimeout},
+		httpAccess:         httpx.NewAccessConfig(10*time.Second, disallowedIPs, disallowedNets),
+
+		stopChan:  make(chan bool),
+		waitGroup: &sync.WaitGroup{},
+
+		receivedExternalIDs: redisx.NewIntervalHash(valkey.Tag("seen-external-ids"), time.Hour*24, 2), // 24 - 48 hours
+		sentIDs:             redisx.NewIntervalSet(valkey.Tag("sent-ids"), time.Hour, 2),              // 1 - 2 hours
+	}
+}
+
+// Start starts our standalone backend, reading our channels and connecting to Valkey
+func (b *backend) Start() error {
+	log := slog.With("comp", "backend", "state", "starting")
+	log.Info("starting backend")
+
+	if b.config.StandaloneChannels == "" || b.config.StandaloneWebhook == "" {
+		return errors.New("standalone backend requires 'StandaloneChannels' and 'StandaloneWebhook' to be set")
+	}
+
+	channelDefaults, _ := b.config.ParseChannelDefaults()
+	channels, err := readChannels(b.config.StandaloneChannels, channelDefaults)
+	if err != nil {
+		return err
+	}
+	b.setChannels(channels)
+	log.Info("channels ok", "count", len(channels))
+
+	b.rp, err = valkey.NewPool(b.config.Redis, b.config.RedisCluster, b.config.MaxWorkers*2)
+	if err != nil {
+		log.Error("redis not reachable", "error", err)
+	} else {
+		log.Info("redis ok")
+	}
+
+	// start our dethrottler if we are going to be doing some sending
+	if b.config.MaxWorkers > 0 {
+		queue.StartDethrottler(b.rp, b.stopChan, b.waitGroup, msgQueueName)
+	}
+
+	slog.Info("backend started", "comp", "backend", "state", "started")
+	return nil
+}
+
+// Stop stops our standalone backend
+func (b *backend) Stop() error {
+	close(b.stopChan)
+
+	b.waitGroup.Wait()
+	return nil
+}
+
+// Flush is a no-op as everything is written as soon as we get it
+func (b *backend) Flush(ctx context.Context) error { return nil }
+
+// Cleanup closes our Valkey pool
+func (b *backend) Cleanup() error {
+	if b.rp != nil {
+		return b.rp.Close()
+	}
+	return nil
+}
+
+func (b *backend) setChannels(channels []*Channel) {
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+
+	b.channels = make(map[courier.ChannelUUID]*Channel, len(channels))
+	b.channelsByAddress = make(map[courier.ChannelAddress]*Channel, len(channels))
+
+	for _, ch := range channels {
+		b.channels[ch.UUID_] = ch
+		if ch.Address_ != "" {
+			b.channelsByAddress[ch.ChannelAddress()] = ch
+		}
+	}
+}
+
+// GetChannel returns the channel for the passed in type and UUID
+func (b *backend) GetChannel(ctx context.Context, typ courier.ChannelType, uuid courier.ChannelUUID) (courier.Channel, error) {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	ch := b.channels[uuid]
+	if ch == nil || (typ != courier.AnyChannelType && ch.ChannelType_ != typ) {
+		return nil, courier.ErrChannelNotFound
+	}
+	return ch, nil
+}
+
+// GetChannelByAddress returns the channel with the passed in type and address
+func (b *backend) GetChannelByAddress(ctx context.Context, typ courier.ChannelType, address courier.ChannelAddress) (courier.Channel, error) {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	ch := b.channelsByAddress[address]
+	if ch == nil || (typ != courier.AnyChannelType && ch.ChannelType_ != typ) {
+		return nil, courier.ErrChannelNotFound
+	}
+	return ch, nil
+}
+
+// UpdateChannelConfig updates the config of the passed in channel and writes it back to our channels file
+func (b *backend) UpdateChannelConfig(ctx context.Context, ch courier.Channel, updates map[string]any) error {
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+
+	current := b.channels[ch.UUID()]
+	if current == nil {
+		return courier.ErrChannelNotFound
+	}
+
+	// don't overwrite updates made since the caller loaded the channel
+	if current != ch {
+		return courier.ErrChannelConfigConflict
+	}
+
+	updated := current.withConfig(updates)
+
+	channels := make([]*Channel, 0, len(b.channels))
+	for _, c := range b.channels {
+		if c.UUID_ == updated.UUID_ {
+			c = updated
+		}
+		channels = append(channels, c)
+	}
+	slices.SortFunc(channels, func(c1, c2 *Channel) int { return cmp.Compare(c1.UUID_, c2.UUID_) })
+
+	if err := writeChannels(b.config.StandaloneChannels, channels); err != nil {
+		return err
+	}
+
+	b.channels[updated.UUID_] = updated
+	if updated.Address_ != "" {
+		b.channelsByAddress[updated.ChannelAddress()] = updated
+	}
+	return nil
+}
+
+// GetContact returns the contact for the passed in URN, as we don't store contacts, this never fails
+func (b *backend) GetContact(ctx context.Context, ch courier.Channel, urn urns.URN, authTokens map[string]string, name string, clog *courier.ChannelLog) (courier.Contact, error) {
+	return &Contact{uuid: contactUUIDForURN(urn)}, nil
+}
+
+// AddURNtoContact returns the passed in URN, as contacts only have the URN they were created for
+func (b *backend) AddURNtoContact(ctx context.Context, ch courier.Channel, contact courier.Contact, urn urns.URN, authTokens map[string]string) (urns.URN, error) {
+	return urn, nil
+}
+
+// RemoveURNfromContact returns the passed in URN, as contacts only have the URN they were created for
+func (b *backend) RemoveURNfromContact(ctx context.Context, ch courier.Channel, contact courier.Contact, urn urns.URN) (urns.URN, error) {
+	return urn, nil
+}
+
+// DeleteMsgByExternalID is a no-op as we don't store messages
+func (b *backend) DeleteMsgByExternalID(ctx context.Context, ch courier.Channel, externalID string) error {
+	return nil
+}
+
+// NewIncomingMsg creates a new message from the given params
+func (b *backend) NewIncomingMsg(ch courier.Channel, urn urns.URN, text string, extID string, clog *courier.ChannelLog) courier.MsgIn {
+	// strip out invalid UTF8 and NULL chars
+	urn = urns.URN(dbutil.ToValidUTF8(string(urn)))
+	text = dbutil.ToValidUTF8(text)
+	extID = dbutil.ToValidUTF8(extID)
+
+	msg := newIncomingMsg(ch.(*Channel), urn, text, extID)
+	msg.WithReceivedOn(time.Now().UTC())
+
+	// if we've received this external ID before, return the UUID we gave it then
+	if extID != "" {
+		rc := b.rp.Get()
+		defer rc.Close()
+
+		uuid, err := b.receivedExternalIDs.Get(rc, fmt.Sprintf("%s|%s", ch.UUID(), extID))
+		if err != nil {
+			slog.Error("error looking up received external id", "error", err)
+		} else if uuid != "" {
+			msg.UUID_ = courier.MsgUUID(uuid)
+			msg.alreadyWritten = true
+		}
+	}
+
+	return msg
+}
+
+// WriteMsg POSTs the passed in message to our webhook
+func (b *backend) WriteMsg(ctx context.Context, m courier.MsgIn, clog *courier.ChannelLog) error {
+	msg := m.(*Msg)
+
+	// this msg has already been written (we received it twice), we are a no op
+	if msg.alreadyWritten {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	err := b.postToWebhook(ctx, &webhookEvent{
+		Type: webhookTypeMsgIn,
+		Msg: &webhookMsg{
+			UUID:        msg.UUID_,
+			ChannelUUID: msg.ChannelUUID_,
+			ContactUUID: contactUUIDForURN(msg.URN_),
+			URN:         msg.URN_,
+			ContactName: msg.contactName,
+			Text:        msg.Text_,
+			Attachments: msg.Attachments_,
+			ExternalID:  msg.externalID,
+			Metadata:    msg.Metadata_,
+			IsEcho:      msg.isEcho,
+			ReceivedOn:  msg.receivedOn,
+			CreatedOn:   msg.CreatedOn_,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if msg.externalID != "" {
+		rc := b.rp.Get()
+		defer rc.Close()
+
+		if err := b.receivedExternalIDs.Set(rc, fmt.Sprintf("%s|%s", msg.ChannelUUID_, msg.externalID), string(msg.UUID_)); err != nil {
+			slog.Error("error recording received external id", "error", err, "msg", msg.UUID_)
+		}
+	}
+	return nil
+}
+
+// NewStatusUpdate creates a new status update for the given message id
+func (b *backend) NewStatusUpdate(ch courier.Channel, id courier.MsgID, status courier.MsgStatus, clog *courier.ChannelLog) courier.StatusUpdate {
+	return newStatusUpdate(ch, id, "", status)
+}
+
+// NewStatusUpdateByExternalID creates a new status update for the given external id
+func (b *backend) NewStatusUpdateByExternalID(ch courier.Channel, externalID string, status courier.MsgStatus, clog *courier.ChannelLog) courier.StatusUpdate {
+	return newStatusUpdate(ch, courier.NilMsgID, externalID, status)
+}
+
+// WriteStatusUpdate POSTs the passed in status update to our webhook
+func (b *backend) WriteStatusUpdate(ctx context.Context, s courier.StatusUpdate) error {
+	status := s.(*StatusUpdate)
+
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return b.postToWebhook(ctx, &webhookEvent{
+		Type: webhookTypeStatus,
+		Status: &webhookStatus{
+			ChannelUUID:  status.channelUUID,
+			MsgID:        status.msgID,
+			ExternalID:   status.externalID,
+			Status:       status.status,
+			FailedReason: status.failedReason,
+			Fallback:     status.fallback,
+			Segments:     status.segments,
+			OldURN:       status.oldURN,
+			NewURN:       status.newURN,
+			CreatedOn:    status.createdOn,
+		},
+	})
+}
+
+// NewChannelEvent creates a new channel event with the passed in parameters
+func (b *backend) NewChannelEvent(ch courier.Channel, eventType courier.ChannelEventType, urn urns.URN, clog *courier.ChannelLog) courier.ChannelEvent {
+	return newChannelEvent(ch, eventType, urn)
+}
+
+// WriteChannelEvent POSTs the passed in channel event to our webhook
+func (b *backend) WriteChannelEvent(ctx context.Context, e courier.ChannelEvent, clog *courier.ChannelLog) error {
+	event := e.(*ChannelEvent)
+
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return b.postToWebhook(ctx, &webhookEvent{
+		Type: webhookTypeChannelEvent,
+		Event: &webhookChannelEvent{
+			ChannelUUID: event.channelUUID,
+			ContactUUID: contactUUIDForURN(event.urn),
+			EventType:   event.eventType,
+			URN:         event.urn,
+			ContactName: event.contactName,
+			Extra:       event.extra,
+			OccurredOn:  event.occurredOn,
+			CreatedOn:   event.createdOn,
+		},
+	})
+}
+
+// WriteChannelLog logs any errors of the passed in channel log, as we don't store channel logs
+func (b *backend) WriteChannelLog(ctx context.Context, clog *courier.ChannelLog) error {
+	log := slog.With("log_uuid", clog.UUID, "log_type", clog.Type)
+	if clog.Channel() != nil {
+		log = log.With("channel_uuid", clog.Channel().UUID())
+	}
+
+	for _, e := range clog.Errors {
+		log.Warn("channel log error", "code", e.Code, "message", e.Message)
+	}
+	return nil
+}
+
+// OnReceiveComplete is called when the server has finished handling an incoming request
+func (b *backend) OnReceiveComplete(ctx context.Context, ch courier.Channel, events []courier.Event, clog *courier.ChannelLog) {
+}
+
+// SaveAttachment returns an error as we have nowhere to store attachments, so incoming attachments are passed to the
+// webhook as the URLs the channel gave us
+func (b *backend) SaveAttachment(ctx context.Context, ch courier.Channel, contentType string, data []byte, extension string) (string, error) {
+	return "", errors.New("standalone backend doesn't store attachments")
+}
+
+// ResolveMedia returns nil as we don't have any media of our own
+func (b *backend) ResolveMedia(ctx context.Context, mediaUrl string) (courier.Media, error) {
+	return nil, nil
+}
+
+// HttpClient returns the HTTP client to use for requests to channel providers
+func (b *backend) HttpClient(secure bool) *http.Client {
+	if secure {
+		return b.httpClient
+	}
+	return b.httpClientInsecure
+}
+
+// HttpAccess returns the access config to use for fetching attachments
+func (b *backend) HttpAccess() *httpx.AccessConfig {
+	return b.httpAccess
+}
+
+// Health returns the health of this backend as a string, returning "" if all is well
+func (b *backend) Health() string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	rc, err := b.rp.GetContext(ctx)
+	cancel()
+
+	if err == nil {
+		defer rc.Close()
+		_, err = rc.Do("PING")
+	}
+	if err != nil {
+		return fmt.Sprintf("\n% 16s: %v", "redis err", err)
+	}
+	return ""
+}
+
+// CheckHealth checks our valkey
+func (b *backend) CheckHealth(ctx context.Context) []*courier.HealthCheck {
+	return []*courier.HealthCheck{courier.CheckDependency("valkey", func() error { return valkey.Ping(ctx, b.rp) })}
+}
+
+// Status returns information on our queues
+func (b *backend) Status() string {
+	infos, err := b.Queues(context.Background())
+	if err != nil {
+		return err.Error()
+	}
+
+	status := bytes.Buffer{}
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	status.WriteString("     Size | Bulk Size | Workers | TPS | Paused | Channel              \n")
+	status.WriteString("------------------------------------------------------------------------------------\n")
+
+	for _, info := range infos {
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3d   %6t   %s\n", info.Size, info.BulkSize, info.Workers, info.TPS, info.Paused, info.ChannelUUID))
+	}
+	return status.String()
+}
+
+// RedisPool returns the redisPool for this backend
+func (b *backend) RedisPool() valkey.Pool {
+	return b.rp
+}
+
+var _ courier.ChannelConfigUpdater = (*backend)(nil)
+var _ courier.QueueManager = (*backend)(nil)
+var _ courier.MsgQueuer = (*backend)(nil)
+var _ courier.BulkMsgPopper = (*backend)(nil)
+var _ courier.MsgRequeuer = (*backend)(nil)
+var _ courier.HealthChecker = (*backend)(nil)
@@ -0,0 +1,382 @@
+package standalone
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChannels = `[
+	{"uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "type": "KN", "address": "2020", "country": "RW", "schemes": ["tel"], "config": {"username": "bob"}},
+	{"uuid": "4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c", "type": "TG", "name": "Bot", "schemes": ["telegram"], "role": "R"}
+]`
+
+type testWebhook struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	events []map[string]any
+	status int
+}
+
+func newTestWebhook() *testWebhook {
+	w := &testWebhook{status: http.StatusOK}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		if w.status == http.StatusOK {
+			event := map[string]any{}
+			json.Unmarshal(body, &event)
+			w.events = append(w.events, event)
+		}
+		rw.WriteHeader(w.status)
+	}))
+	return w
+}
+
+func (w *testWebhook) popEvents() []map[string]any {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	events := w.events
+	w.events = nil
+	return events
+}
+
+func newTestBackend(t *testing.T, webhookURL string) (*backend, string) {
+	channelsPath := filepath.Join(t.TempDir(), "channels.json")
+	require.NoError(t, os.WriteFile(channelsPath, []byte(testChannels), 0644))
+
+	config := courier.NewDefaultConfig()
+	config.Redis = "redis://localhost:6379/0"
+	config.Backend = "standalone"
+	config.StandaloneChannels = channelsPath
+	config.StandaloneWebhook = webhookURL
+	config.ChannelDefaults = `{"KN": {"verify_ssl": false}}`
+
+	b, err := courier.NewBackend(config)
+	require.NoError(t, err)
+	require.NoError(t, b.Start())
+	t.Cleanup(func() { b.Stop(); b.Cleanup() })
+
+	rc := b.RedisPool().Get()
+	defer rc.Close()
+	_, err = rc.Do("FLUSHDB")
+	require.NoError(t, err)
+
+	return b.(*backend), channelsPath
+}
+
+func TestChannels(t *testing.T) {
+	ctx := context.Background()
+	b, channelsPath := newTestBackend(t, "http://localhost/webhook")
+
+	ch, err := b.GetChannel(ctx, "KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	require.NoError(t, err)
+	assert.Equal(t, courier.ChannelAddress("2020"), ch.ChannelAddress())
+	assert.Equal(t, []courier.ChannelRole{courier.ChannelRoleSend, courier.ChannelRoleReceive}, ch.Roles())
+	assert.True(t, ch.IsScheme(urns.Phone))
+	assert.Equal(t, "bob", ch.StringConfigForKey("username", ""))
+	assert.False(t, ch.BoolConfigForKey("verify_ssl", true)) // inherited from channel defaults
+	assert.Nil(t, ch.OrgConfigForKey("anon", nil))
+
+	_, err = b.GetChannel(ctx, "TG", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+	_, err = b.GetChannel(ctx, "KN", "d5d4a1f6-3e3e-4f3c-9c6e-2e5f6b0a1c1d")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+
+	tg, err := b.GetChannel(ctx, courier.AnyChannelType, "4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c")
+	require.NoError(t, err)
+	assert.Equal(t, []courier.ChannelRole{courier.ChannelRoleReceive}, tg.Roles())
+
+	byAddress, err := b.GetChannelByAddress(ctx, "KN", "2020")
+	assert.NoError(t, err)
+	assert.Equal(t, ch, byAddress)
+
+	// config updates are written back to our channels file
+	assert.NoError(t, b.UpdateChannelConfig(ctx, ch, map[string]any{"auth_token": "sesame"}))
+
+	updated, _ := b.GetChannel(ctx, "KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	assert.Equal(t, "sesame", updated.StringConfigForKey("auth_token", ""))
+	assert.Equal(t, "", ch.StringConfigForKey("auth_token", "")) // channel we already had is unchanged
+
+	channels, err := readChannels(channelsPath, nil)
+	require.NoError(t, err)
+	assert.Len(t, channels, 2)
+	assert.Equal(t, courier.ChannelUUID("4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c"), channels[0].UUID_)
+	assert.Equal(t, map[string]any{"username": "bob", "auth_token": "sesame"}, channels[1].Config_)
+
+	// updating a channel which has been updated since it was loaded is a conflict
+	assert.Equal(t, courier.ErrChannelConfigConflict, b.UpdateChannelConfig(ctx, ch, map[string]any{"auth_token": "other"}))
+
+	// invalid channel files
+	for content, expectedErr := range map[string]string{
+		`{`: "error parsing channels file: unexpected end of JSON input",
+		`[{"uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d"}]`: "invalid channel #0 in channels file: Key: 'Channel.ChannelType_' Error:Field validation for 'ChannelType_' failed on the 'required' tag\nKey: 'Channel.Schemes_' Error:Field validation for 'Schemes_' failed on the 'required' tag",
+		`[{"uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "type": "KN", "schemes": ["tel"]}, {"uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "type": "KN", "schemes": ["tel"]}]`: "duplicate channel dbc126ed-66bc-4e28-b67b-81dc3327c95d in channels file",
+	} {
+		require.NoError(t, os.WriteFile(channelsPath, []byte(content), 0644))
+		_, err := readChannels(channelsPath, nil)
+		assert.EqualError(t, err, expectedErr)
+	}
+}
+
+func TestIncoming(t *testing.T) {
+	ctx := context.Background()
+	webhook := newTestWebhook()
+	defer webhook.server.Close()
+
+	b, _ := newTestBackend(t, webhook.server.URL)
+	ch, _ := b.GetChannel(ctx, "KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+
+	msg := b.NewIncomingMsg(ch, "tel:+250788383383", "hello", "ext1", clog).WithContactName("Bob").WithAttachment("https://example.com/1.jpg")
+	assert.NoError(t, b.WriteMsg(ctx, msg, clog))
+
+	events := webhook.popEvents()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "msg_in", events[0]["type"])
+		m := events[0]["msg"].(map[string]any)
+		assert.Equal(t, string(msg.UUID()), m["uuid"])
+		assert.Equal(t, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", m["channel_uuid"])
+		assert.Equal(t, string(contactUUIDForURN("tel:+250788383383")), m["contact_uuid"])
+		assert.Equal(t, "tel:+250788383383", m["urn"])
+		assert.Equal(t, "Bob", m["contact_name"])
+		assert.Equal(t, "hello", m["text"])
+		assert.Equal(t, []any{"https://example.com/1.jpg"}, m["attachments"])
+		assert.Equal(t, "ext1", m["external_id"])
+	}
+
+	// receiving the same external ID again gives us the same message which isn't forwarded again
+	dupe := b.NewIncomingMsg(ch, "tel:+250788383383", "hello", "ext1", clog)
+	assert.Equal(t, msg.UUID(), dupe.UUID())
+	assert.NoError(t, b.WriteMsg(ctx, dupe, clog))
+	assert.Len(t, webhook.popEvents(), 0)
+
+	contact, err := b.GetContact(ctx, ch, "tel:+250788383383", nil, "", clog)
+	assert.NoError(t, err)
+	assert.Equal(t, contactUUIDForURN("tel:+250788383383"), contact.UUID())
+	assert.NotEqual(t, contactUUIDForURN("tel:+250788383384"), contact.UUID())
+
+	status := b.NewStatusUpdateByExternalID(ch, "ext2", courier.MsgStatusDelivered, clog)
+	assert.NoError(t, b.WriteStatusUpdate(ctx, status))
+
+	event := b.NewChannelEvent(ch, courier.EventTypeStopContact, "tel:+250788383383", clog)
+	assert.NoError(t, b.WriteChannelEvent(ctx, event, clog))
+
+	events = webhook.popEvents()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "status", events[0]["type"])
+		assert.Equal(t, map[string]any{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "external_id": "ext2", "status": "D", "created_on": events[0]["status"].(map[string]any)["created_on"]}, events[0]["status"])
+		assert.Equal(t, "channel_event", events[1]["type"])
+		assert.Equal(t, "stop_contact", events[1]["event"].(map[string]any)["event_type"])
+	}
+
+	// if our webhook fails, so does writing
+	webhook.status = http.StatusServiceUnavailable
+	msg = b.NewIncomingMsg(ch, "tel:+250788383383", "hello again", "ext3", clog)
+	assert.EqualError(t, b.WriteMsg(ctx, msg, clog), "webhook returned non-2XX response: 503")
+
+	// and the message isn't considered written
+	assert.NotEqual(t, msg.UUID(), b.NewIncomingMsg(ch, "tel:+250788383383", "hello again", "ext3", clog).UUID())
+}
+
+func TestOutgoing(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBackend(t, "http://localhost/webhook")
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	// queue messages like mailroom does
+	msgsJSON := `[{"id": 10, "uuid": "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0c9d", "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hi", "quick_replies": ["Yes", "No"], "high_priority": true, "metadata": {"topic": "account"}, "org_id": 1, "contact_id": 100}]`
+	require.NoError(t, queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, msgsJSON, queue.HighPriority))
+
+	infos, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.QueueInfo{{ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Size: 1, TPS: 10}}, infos)
+
+	peeked, err := b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10)
+	assert.NoError(t, err)
+	assert.Len(t, peeked, 1)
+
+	msg, err := b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.MsgID(10), msg.ID())
+	assert.Equal(t, "hi", msg.Text())
+	assert.Equal(t, []string{"Yes", "No"}, msg.QuickReplies())
+	assert.Equal(t, "account", msg.Topic())
+	assert.True(t, msg.HighPriority())
+	assert.Equal(t, courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), msg.Channel().UUID())
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg.Channel(), nil)
+	b.OnSendComplete(ctx, msg, b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusWired, clog), clog)
+
+	sent, err := b.WasMsgSent(ctx, msg.ID())
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	assert.NoError(t, b.ClearMsgSent(ctx, msg.ID()))
+	sent, _ = b.WasMsgSent(ctx, msg.ID())
+	assert.False(t, sent)
+
+	// nothing left to pop
+	msg, err = b.PopNextOutgoingMsg(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	// drain a queue into another channel
+	require.NoError(t, queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, msgsJSON, queue.LowPriority))
+
+	_, err = b.DrainQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "d5d4a1f6-3e3e-4f3c-9c6e-2e5f6b0a1c1d")
+	assert.Equal(t, courier.ErrChannelNotFound, err)
+
+	drained, err := b.DrainQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, drained)
+
+	msg, err = b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.ChannelUUID("4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c"), msg.Channel().UUID())
+
+	// messages from a newer schema version can be sent and requeued without losing what we don't understand
+	msgsJSON = `[{"schema_version": 2, "id": 11, "uuid": "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0c9e", "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hi", "quick_replies": [{"text": "Yes"}], "buttons": ["No"]}]`
+	require.NoError(t, queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, msgsJSON, queue.HighPriority))
+
+	msg, err = b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.MsgID(11), msg.ID())
+	assert.Equal(t, "hi", msg.Text())
+	assert.Nil(t, msg.QuickReplies())
+
+	assert.NoError(t, b.RequeueMsg(ctx, msg, 0))
+
+	peeked, err = b.PeekQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, peeked, 1) {
+		requeued := map[string]any{}
+		require.NoError(t, json.Unmarshal(peeked[0], &requeued))
+		assert.Equal(t, float64(2), requeued["schema_version"])
+		assert.Equal(t, []any{map[string]any{"text": "Yes"}}, requeued["quick_replies"])
+		assert.Equal(t, []any{"No"}, requeued["buttons"])
+	}
+
+	msg, err = b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.MsgID(11), msg.ID())
+
+	// but not if a required field has changed
+	msgsJSON = `[{"schema_version": 2, "id": "12", "uuid": "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0c9f", "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hi"}]`
+	require.NoError(t, queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, msgsJSON, queue.HighPriority))
+
+	_, err = b.PopNextOutgoingMsg(ctx)
+	assert.ErrorContains(t, err, "unable to decode required field 'id' of schema version 2")
+}
+
+func TestFailoverMsg(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBackend(t, "http://localhost/webhook")
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	msgsJSON := `[{"id": 10, "uuid": "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0c9d", "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hi", "buttons": ["No"]}]`
+	require.NoError(t, queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, msgsJSON, queue.HighPriority))
+
+	msg, err := b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+
+	// fallback channel has to support the URN scheme of the message
+	tg, err := b.GetChannel(ctx, courier.AnyChannelType, "4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c")
+	require.NoError(t, err)
+	assert.EqualError(t, b.FailoverMsg(ctx, msg, tg), "fallback channel 4f9c1a1e-0d6e-4b9a-8c43-4b1b8f1e9a2c can't send to URNs with scheme tel")
+
+	sms := &Channel{UUID_: "d5d4a1f6-3e3e-4f3c-9c6e-2e5f6b0a1c1d", ChannelType_: "EX", Schemes_: []string{"tel"}}
+	assert.NoError(t, b.FailoverMsg(ctx, msg, sms))
+
+	peeked, err := b.PeekQueue(ctx, "d5d4a1f6-3e3e-4f3c-9c6e-2e5f6b0a1c1d", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, peeked, 1) {
+		moved := map[string]any{}
+		require.NoError(t, json.Unmarshal(peeked[0], &moved))
+		assert.Equal(t, float64(10), moved["id"])
+		assert.Equal(t, "d5d4a1f6-3e3e-4f3c-9c6e-2e5f6b0a1c1d", moved["channel_uuid"])
+		assert.Equal(t, []any{"No"}, moved["buttons"])
+	}
+
+	// message we failed over is unchanged
+	assert.Equal(t, courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), msg.Channel().UUID())
+}
+
+func TestQueueMsg(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBackend(t, "http://localhost/webhook")
+
+	ch, err := b.GetChannel(ctx, courier.AnyChannelType, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	require.NoError(t, err)
+
+	id1, err := b.QueueMsg(ctx, ch, &courier.OutgoingMsg{UUID: "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0ca0", URN: "tel:+250788383383", Text: "hi", QuickReplies: []string{"Yes", "No"}})
+	assert.NoError(t, err)
+	id2, err := b.QueueMsg(ctx, ch, &courier.OutgoingMsg{UUID: "0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0ca1", URN: "tel:+250788383383", Text: "urgent", HighPriority: true})
+	assert.NoError(t, err)
+	assert.Equal(t, id1+1, id2)
+
+	infos, err := b.Queues(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.QueueInfo{{ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", Size: 1, BulkSize: 1, TPS: 10}}, infos)
+
+	// high priority message is popped first
+	msg, err := b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, id2, msg.ID())
+	assert.Equal(t, "urgent", msg.Text())
+	assert.True(t, msg.HighPriority())
+
+	// mark it as complete so the queue's worker is released
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg.Channel(), nil)
+	b.OnSendComplete(ctx, msg, b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusWired, clog), clog)
+
+	msg, err = b.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, id1, msg.ID())
+	assert.Equal(t, courier.MsgUUID("0199f0a6-5d1c-7d2e-8c6d-4f3e2a1b0ca0"), msg.UUID())
+	assert.Equal(t, urns.URN("tel:+250788383383"), msg.URN())
+	assert.Equal(t, []string{"Yes", "No"}, msg.QuickReplies())
+	assert.False(t, msg.HighPriority())
+}
+
+func TestUnsupportedFeatures(t *testing.T) {
+	b, _ := newTestBackend(t, "http://localhost/webhook")
+
+	// features we can't provide aren't implemented so that the server doesn't expose them
+	_, ok := courier.BackendAs[courier.DeadLetterStore](b)
+	assert.False(t, ok)
+	_, ok = courier.BackendAs[courier.ChannelLogSearcher](b)
+	assert.False(t, ok)
+	_, ok = courier.BackendAs[courier.MsgArchive](b)
+	assert.False(t, ok)
+	_, ok = courier.BackendAs[courier.MediaProcessor](b)
+	assert.False(t, ok)
+	_, ok = courier.BackendAs[courier.QualityWriter](b)
+	assert.False(t, ok)
+}
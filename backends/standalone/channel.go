@@ -0,0 +1,183 @@
+package standalone
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// Channel is a channel read from the channels file of the standalone backend
+type Channel struct {
+	UUID_        courier.ChannelUUID `json:"uuid"                validate:"required,uuid"`
+	ChannelType_ courier.ChannelType `json:"type"                validate:"required"`
+	Name_        string              `json:"name,omitempty"`
+	Address_     string              `json:"address,omitempty"`
+	Country_     i18n.Country        `json:"country,omitempty"`
+	Schemes_     []string            `json:"schemes"             validate:"required,min=1"`
+	Role_        string              `json:"role,omitempty"` // e.g. SR for send and receive which is the default
+	Config_      map[string]any      `json:"config,omitempty"`
+
+	// config values inherited from deployment level defaults for this channel's type
+	defaults map[string]any
+}
+
+func (c *Channel) UUID() courier.ChannelUUID        { return c.UUID_ }
+func (c *Channel) ChannelType() courier.ChannelType { return c.ChannelType_ }
+func (c *Channel) Name() string                     { return c.Name_ }
+func (c *Channel) Schemes() []string                { return c.Schemes_ }
+func (c *Channel) Address() string                  { return c.Address_ }
+func (c *Channel) Country() i18n.Country            { return c.Country_ }
+
+// ChannelAddress returns the address of this channel
+func (c *Channel) ChannelAddress() courier.ChannelAddress {
+	return courier.ChannelAddress(c.Address_)
+}
+
+// IsScheme returns whether this channel serves only the passed in scheme
+func (c *Channel) IsScheme(scheme *urns.Scheme) bool {
+	return len(c.Schemes_) == 1 && c.Schemes_[0] == scheme.Prefix
+}
+
+// Roles returns the roles of this channel
+func (c *Channel) Roles() []courier.ChannelRole {
+	role := c.Role_
+	if role == "" {
+		role = string(courier.ChannelRoleSend) + string(courier.ChannelRoleReceive)
+	}
+
+	roles := []courier.ChannelRole{}
+	for _, char := range strings.Split(role, "") {
+		roles = append(roles, courier.ChannelRole(char))
+	}
+	return roles
+}
+
+// ConfigForKey returns the config value for the passed in key, falling back to any default for this channel's type, or
+// defaultValue if it isn't found
+func (c *Channel) ConfigForKey(key string, defaultValue any) any {
+	value, found := c.Config_[key]
+	if !found {
+		value, found = c.defaults[key]
+		if !found {
+			return defaultValue
+		}
+	}
+	return value
+}
+
+// OrgConfigForKey returns defaultValue as channels of the standalone backend don't belong to workspaces
+func (c *Channel) OrgConfigForKey(key string, defaultValue any) any {
+	return defaultValue
+}
+
+// StringConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) StringConfigForKey(key string, defaultValue string) string {
+	str, isStr := c.ConfigForKey(key, defaultValue).(string)
+	if !isStr {
+		return defaultValue
+	}
+	return str
+}
+
+// BoolConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) BoolConfigForKey(key string, defaultValue bool) bool {
+	b, isBool := c.ConfigForKey(key, defaultValue).(bool)
+	if !isBool {
+		return defaultValue
+	}
+	return b
+}
+
+// IntConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) IntConfigForKey(key string, defaultValue int) int {
+	val := c.ConfigForKey(key, defaultValue)
+
+	// golang unmarshals number literals in JSON into float64s by default
+	switch v := val.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// CallbackDomain is convenience utility to get the callback domain configured for this channel
+func (c *Channel) CallbackDomain(fallbackDomain string) string {
+	return c.StringConfigForKey(courier.ConfigCallbackDomain, fallbackDomain)
+}
+
+// returns a copy of this channel with the given config updates applied, as channels are shared by concurrent requests
+func (c *Channel) withConfig(updates map[string]any) *Channel {
+	updated := *c
+	updated.Config_ = make(map[string]any, len(c.Config_)+len(updates))
+	maps.Copy(updated.Config_, c.Config_)
+	maps.Copy(updated.Config_, updates)
+	return &updated
+}
+
+// reads the channels in the JSON file at the given path
+func readChannels(path string, defaults map[courier.ChannelType]map[string]any) ([]*Channel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading channels file: %w", err)
+	}
+
+	var channels []*Channel
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return nil, fmt.Errorf("error parsing channels file: %w", err)
+	}
+
+	seen := make(map[courier.ChannelUUID]bool, len(channels))
+	for i, ch := range channels {
+		if err := utils.Validate(ch); err != nil {
+			return nil, fmt.Errorf("invalid channel #%d in channels file: %w", i, err)
+		}
+		if seen[ch.UUID_] {
+			return nil, fmt.Errorf("duplicate channel %s in channels file", ch.UUID_)
+		}
+		seen[ch.UUID_] = true
+
+		ch.defaults = defaults[ch.ChannelType_]
+	}
+	return channels, nil
+}
+
+// writes the given channels to the JSON file at the given path, replacing it so that it's never partially written
+func writeChannels(path string, channels []*Channel) error {
+	data, err := json.MarshalIndent(channels, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".channels-*.json")
+	if err != nil {
+		return fmt.Errorf("error writing channels file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing channels file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing channels file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing channels file: %w", err)
+	}
+	return nil
+}
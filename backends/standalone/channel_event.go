@@ -0,0 +1,57 @@
+package standalone
+
+import (
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// ChannelEvent is an event like a new conversation or a stop from a contact
+type ChannelEvent struct {
+	channelUUID   courier.ChannelUUID
+	eventType     courier.ChannelEventType
+	urn           urns.URN
+	extra         map[string]string
+	createdOn     time.Time
+	occurredOn    time.Time
+	contactName   string
+	urnAuthTokens map[string]string
+}
+
+func newChannelEvent(channel courier.Channel, eventType courier.ChannelEventType, urn urns.URN) *ChannelEvent {
+	now := time.Now().In(time.UTC)
+
+	return &ChannelEvent{
+		channelUUID: channel.UUID(),
+		eventType:   eventType,
+		urn:         urn,
+		createdOn:   now,
+		occurredOn:  now,
+	}
+}
+
+func (e *ChannelEvent) EventID() int64                      { return 0 }
+func (e *ChannelEvent) ChannelUUID() courier.ChannelUUID    { return e.channelUUID }
+func (e *ChannelEvent) EventType() courier.ChannelEventType { return e.eventType }
+func (e *ChannelEvent) URN() urns.URN                       { return e.urn }
+func (e *ChannelEvent) Extra() map[string]string            { return e.extra }
+func (e *ChannelEvent) CreatedOn() time.Time                { return e.createdOn }
+func (e *ChannelEvent) OccurredOn() time.Time               { return e.occurredOn }
+
+func (e *ChannelEvent) WithContactName(name string) courier.ChannelEvent {
+	e.contactName = name
+	return e
+}
+func (e *ChannelEvent) WithURNAuthTokens(tokens map[string]string) courier.ChannelEvent {
+	e.urnAuthTokens = tokens
+	return e
+}
+func (e *ChannelEvent) WithExtra(extra map[string]string) courier.ChannelEvent {
+	e.extra = extra
+	return e
+}
+func (e *ChannelEvent) WithOccurredOn(t time.Time) courier.ChannelEvent {
+	e.occurredOn = t
+	return e
+}
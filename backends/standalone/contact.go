@@ -0,0 +1,26 @@
+package standalone
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// Contact is a contact of the standalone backend, which doesn't store contacts, so each URN is its own contact
+type Contact struct {
+	uuid courier.ContactUUID
+}
+
+func (c *Contact) UUID() courier.ContactUUID { return c.uuid }
+
+// returns the UUID of the contact with the given URN, which is a version 5 style UUID derived from the URN so that the
+// webhook can correlate messages and events from the same contact
+func contactUUIDForURN(urn urns.URN) courier.ContactUUID {
+	h := sha1.Sum([]byte(urn.Identity().String()))
+	h[6] = (h[6] & 0x0f) | 0x50 // version 5
+	h[8] = (h[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return courier.ContactUUID(fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16]))
+}
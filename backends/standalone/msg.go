@@ -0,0 +1,141 @@
+package standalone
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// Msg is an incoming or outgoing message. Outgoing messages are decoded from the same JSON that mailroom queues, of
+// which we only use the fields which don't depend on the RapidPro schema.
+type Msg struct {
+	ID_                   courier.MsgID           `json:"id"`
+	UUID_                 courier.MsgUUID         `json:"uuid"`
+	ChannelUUID_          courier.ChannelUUID     `json:"channel_uuid"`
+	URN_                  urns.URN                `json:"urn"`
+	URNAuth_              string                  `json:"urn_auth,omitempty"`
+	Text_                 string                  `json:"text"`
+	Attachments_          []string                `json:"attachments,omitempty"`
+	QuickReplies_         []string                `json:"quick_replies,omitempty"`
+	Locale_               i18n.Locale             `json:"locale,omitempty"`
+	Templating_           *courier.Templating     `json:"templating,omitempty"`
+	HighPriority_         bool                    `json:"high_priority"`
+	Metadata_             json.RawMessage         `json:"metadata,omitempty"`
+	ResponseToExternalID_ string                  `json:"response_to_external_id,omitempty"`
+	IsResend_             bool                    `json:"is_resend,omitempty"`
+	Flow_                 *courier.FlowReference  `json:"flow,omitempty"`
+	OptIn_                *courier.OptInReference `json:"optin,omitempty"`
+	UserID_               courier.UserID          `json:"user_id,omitempty"`
+	Origin_               courier.MsgOrigin       `json:"origin,omitempty"`
+	ContactLastSeenOn_    *time.Time              `json:"contact_last_seen_on,omitempty"`
+	Session_              *courier.Session        `json:"session,omitempty"`
+	CreatedOn_            time.Time               `json:"created_on"`
+
+	// incoming specific
+	externalID     string
+	receivedOn     *time.Time
+	contactName    string
+	urnAuthTokens  map[string]string
+	isEcho         bool
+	alreadyWritten bool
+
+	channel     *Channel
+	workerToken queue.WorkerToken
+	tps         int
+	payload     *queue.Payload
+}
+
+func newIncomingMsg(channel *Channel, urn urns.URN, text string, extID string) *Msg {
+	return &Msg{
+		UUID_:        courier.MsgUUID(uuids.NewV4()),
+		ChannelUUID_: channel.UUID(),
+		URN_:         urn,
+		Text_:        text,
+		CreatedOn_:   time.Now().In(time.UTC),
+		externalID:   extID,
+		channel:      channel,
+	}
+}
+
+func (m *Msg) EventID() int64           { return int64(m.ID_) }
+func (m *Msg) ID() courier.MsgID        { return m.ID_ }
+func (m *Msg) UUID() courier.MsgUUID    { return m.UUID_ }
+func (m *Msg) ExternalID() string       { return m.externalID }
+func (m *Msg) Text() string             { return m.Text_ }
+func (m *Msg) Attachments() []string    { return m.Attachments_ }
+func (m *Msg) URN() urns.URN            { return m.URN_ }
+func (m *Msg) Channel() courier.Channel { return m.channel }
+
+// outgoing specific
+func (m *Msg) QuickReplies() []string          { return m.QuickReplies_ }
+func (m *Msg) Locale() i18n.Locale             { return m.Locale_ }
+func (m *Msg) Templating() *courier.Templating { return m.Templating_ }
+func (m *Msg) URNAuth() string                 { return m.URNAuth_ }
+func (m *Msg) Origin() courier.MsgOrigin       { return m.Origin_ }
+func (m *Msg) ContactLastSeenOn() *time.Time   { return m.ContactLastSeenOn_ }
+func (m *Msg) Topic() string {
+	topic, _ := jsonparser.GetString(m.Metadata_, "topic")
+	return topic
+}
+func (m *Msg) URLPreview() *bool {
+	preview, err := jsonparser.GetBoolean(m.Metadata_, "url_preview")
+	if err != nil {
+		return nil
+	}
+	return &preview
+}
+func (m *Msg) Metadata() json.RawMessage      { return m.Metadata_ }
+func (m *Msg) ResponseToExternalID() string   { return m.ResponseToExternalID_ }
+func (m *Msg) SentOn() *time.Time             { return nil }
+func (m *Msg) IsResend() bool                 { return m.IsResend_ }
+func (m *Msg) Flow() *courier.FlowReference   { return m.Flow_ }
+func (m *Msg) OptIn() *courier.OptInReference { return m.OptIn_ }
+func (m *Msg) UserID() courier.UserID         { return m.UserID_ }
+func (m *Msg) Session() *courier.Session      { return m.Session_ }
+func (m *Msg) HighPriority() bool             { return m.HighPriority_ }
+
+// incoming specific
+func (m *Msg) ReceivedOn() *time.Time { return m.receivedOn }
+func (m *Msg) WithAttachment(url string) courier.MsgIn {
+	m.Attachments_ = append(m.Attachments_, url)
+	return m
+}
+func (m *Msg) WithContactName(name string) courier.MsgIn { m.contactName = name; return m }
+func (m *Msg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
+	m.urnAuthTokens = tokens
+	return m
+}
+func (m *Msg) WithReceivedOn(date time.Time) courier.MsgIn { m.receivedOn = &date; return m }
+func (m *Msg) WithMetadata(metadata json.RawMessage) courier.MsgIn {
+	m.Metadata_ = metadata
+	return m
+}
+func (m *Msg) WithEcho() courier.MsgIn { m.isEcho = true; return m }
+
+// queued msgs are required to have these fields even if they're from a newer schema version
+var requiredMsgFields = []string{"id", "uuid", "channel_uuid", "urn"}
+
+// msgEnvelope is Msg without its JSON methods
+type msgEnvelope Msg
+
+// MarshalJSON marshals this message as a queued payload, including its schema version and any fields we didn't
+// understand when it was unmarshalled
+func (m *Msg) MarshalJSON() ([]byte, error) {
+	return queue.Encode((*msgEnvelope)(m), m.payload)
+}
+
+// UnmarshalJSON unmarshals this message from a queued payload, which may be from a different schema version
+func (m *Msg) UnmarshalJSON(data []byte) error {
+	p, err := queue.Decode(data, (*msgEnvelope)(m), requiredMsgFields...)
+	if err != nil {
+		return err
+	}
+	m.payload = p
+	return nil
+}
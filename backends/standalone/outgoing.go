@@ -0,0 +1,445 @@
+package standalone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// TPS used for messages queued via QueueMsg when their channel doesn't currently have a queue to tell us its TPS
+const defaultQueueTPS = 10
+
+// QueueMsg queues the given outgoing message to be sent, giving it an ID from our counter in Valkey
+func (b *backend) QueueMsg(ctx context.Context, ch courier.Channel, out *courier.OutgoingMsg) (courier.MsgID, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	id, err := redis.Int64(rc.Do("INCR", lastMsgIDKey()))
+	if err != nil {
+		return courier.NilMsgID, fmt.Errorf("error getting message ID: %w", err)
+	}
+
+	m := &Msg{
+		ID_:           courier.MsgID(id),
+		UUID_:         out.UUID,
+		ChannelUUID_:  ch.UUID(),
+		URN_:          out.URN,
+		Text_:         out.Text,
+		Attachments_:  out.Attachments,
+		QuickReplies_: out.QuickReplies,
+		HighPriority_: out.HighPriority,
+		CreatedOn_:    time.Now().In(time.UTC),
+	}
+
+	tps, err := b.queueTPS(rc, ch.UUID(), defaultQueueTPS)
+	if err != nil {
+		return courier.NilMsgID, err
+	}
+
+	priority := queue.LowPriority
+	if m.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{m})
+
+	if err := queue.PushOntoQueue(rc, msgQueueName, string(m.ChannelUUID_), tps, string(value), queue.Priority(priority)); err != nil {
+		return courier.NilMsgID, fmt.Errorf("error queuing message: %w", err)
+	}
+	return m.ID_, nil
+}
+
+// PopNextOutgoingMsg pops the next message that needs to be sent
+func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error) {
+	tryToPop := func() (queue.WorkerToken, string, error) {
+		rc := b.rp.Get()
+		defer rc.Close()
+		return queue.PopFromQueue(rc, msgQueueName)
+	}
+
+	markComplete := func(token queue.WorkerToken) {
+		rc := b.rp.Get()
+		defer rc.Close()
+		if err := queue.MarkComplete(rc, msgQueueName, token); err != nil {
+			slog.Error("error marking queue task complete", "error", err)
+		}
+	}
+
+	token, msgJSON, err := tryToPop()
+	for err == nil && token == queue.Retry {
+		token, msgJSON, err = tryToPop()
+	}
+	if err != nil || msgJSON == "" {
+		return nil, err
+	}
+
+	msg := &Msg{}
+	if err := json.Unmarshal([]byte(msgJSON), msg); err != nil {
+		markComplete(token)
+		return nil, fmt.Errorf("unable to unmarshal message: %s: %w", msgJSON, err)
+	}
+
+	ch, err := b.GetChannel(ctx, courier.AnyChannelType, msg.ChannelUUID_)
+	if err != nil {
+		markComplete(token)
+		return nil, err
+	}
+
+	msg.channel = ch.(*Channel)
+	msg.workerToken = token
+	msg.tps = tpsFromWorkerToken(token)
+	return msg, nil
+}
+
+// PopMoreOutgoingMsgs pops up to max more messages from the same queue as the passed in message
+func (b *backend) PopMoreOutgoingMsgs(ctx context.Context, msg courier.MsgOut, max int) ([]courier.MsgOut, error) {
+	first := msg.(*Msg)
+
+	rc := b.rp.Get()
+	values, err := queue.PopMoreFromQueue(rc, msgQueueName, first.workerToken, max)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.MsgOut, 0, len(values))
+	for _, msgJSON := range values {
+		m := &Msg{}
+		if err := json.Unmarshal([]byte(msgJSON), m); err != nil {
+			slog.Error("unable to unmarshal message", "error", err, "msg", msgJSON)
+			continue
+		}
+
+		// these messages share the worker token of the first message so don't get one of their own
+		m.channel = first.channel
+		m.tps = first.tps
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// WasMsgSent returns whether the passed in message has already been sent
+func (b *backend) WasMsgSent(ctx context.Context, id courier.MsgID) (bool, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	return b.sentIDs.IsMember(rc, id.String())
+}
+
+// ClearMsgSent clears our record of the passed in message having been sent
+func (b *backend) ClearMsgSent(ctx context.Context, id courier.MsgID) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	return b.sentIDs.Rem(rc, id.String())
+}
+
+// RequeueMsg puts the passed in message back on its queue to be popped again after the given delay
+func (b *backend) RequeueMsg(ctx context.Context, msg courier.MsgOut, delay time.Duration) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	m := msg.(*Msg)
+
+	priority := queue.LowPriority
+	if m.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{m})
+
+	if err := queue.PushOntoQueueAt(rc, msgQueueName, string(m.ChannelUUID_), m.tps, string(value), queue.Priority(priority), time.Now().Add(delay)); err != nil {
+		return fmt.Errorf("error requeuing message: %w", err)
+	}
+	return nil
+}
+
+// FailoverMsg moves the given message onto the queue of the given fallback channel
+func (b *backend) FailoverMsg(ctx context.Context, msg courier.MsgOut, ch courier.Channel) error {
+	m := msg.(*Msg)
+
+	if !slices.Contains(ch.Schemes(), m.URN_.Scheme()) {
+		return fmt.Errorf("fallback channel %s can't send to URNs with scheme %s", ch.UUID(), m.URN_.Scheme())
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	tps, err := b.queueTPS(rc, ch.UUID(), m.tps)
+	if err != nil {
+		return err
+	}
+
+	moved := *m
+	moved.ChannelUUID_ = ch.UUID()
+	moved.channel = ch.(*Channel)
+	moved.workerToken = ""
+
+	priority := queue.LowPriority
+	if moved.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	// queued values are lists of messages
+	value := jsonx.MustMarshal([]any{&moved})
+
+	if err := queue.PushOntoQueue(rc, msgQueueName, string(ch.UUID()), tps, string(value), queue.Priority(priority)); err != nil {
+		return fmt.Errorf("error queuing message on fallback channel: %w", err)
+	}
+	return nil
+}
+
+// OnSendComplete is called when the sender has finished trying to send a message
+func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status courier.StatusUpdate, clog *courier.ChannelLog) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	m := msg.(*Msg)
+
+	// messages popped as part of a batch don't have their own worker token
+	if m.workerToken != "" {
+		if err := queue.MarkComplete(rc, msgQueueName, m.workerToken); err != nil {
+			slog.Error("unable to mark queue task complete", "error", err)
+		}
+	}
+
+	// if message won't be retried, mark as sent to avoid dupe sends
+	if status.Status() != courier.MsgStatusErrored && status.Status() != courier.MsgStatusQueued {
+		if err := b.sentIDs.Add(rc, msg.ID().String()); err != nil {
+			slog.Error("unable to mark message sent", "error", err)
+		}
+	}
+}
+
+// Queues returns the state of the outgoing queues of all channels which have pending messages or have been paused
+func (b *backend) Queues(ctx context.Context) ([]*courier.QueueInfo, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	queues, err := queue.Queues(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queues: %w", err)
+	}
+	paused, err := queue.Paused(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading paused queues: %w", err)
+	}
+
+	infos := make(map[courier.ChannelUUID]*courier.QueueInfo, len(queues))
+	getInfo := func(uuid courier.ChannelUUID) *courier.QueueInfo {
+		if infos[uuid] == nil {
+			infos[uuid] = &courier.QueueInfo{ChannelUUID: uuid}
+		}
+		return infos[uuid]
+	}
+
+	for name, workers := range queues {
+		// our queue name is in the format uuid|tps, break it apart
+		uuid, tps, found := strings.Cut(name, "|")
+		if !found {
+			continue
+		}
+
+		size, bulkSize, err := queue.Size(rc, msgQueueName, name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading queue size: %w", err)
+		}
+
+		// channels can have more than one queue if their TPS has changed
+		info := getInfo(courier.ChannelUUID(uuid))
+		info.TPS, _ = strconv.Atoi(tps)
+		info.Size += size
+		info.BulkSize += bulkSize
+		info.Workers += workers
+	}
+	for _, uuid := range paused {
+		getInfo(courier.ChannelUUID(uuid)).Paused = true
+	}
+
+	result := make([]*courier.QueueInfo, 0, len(infos))
+	for _, uuid := range slices.Sorted(maps.Keys(infos)) {
+		result = append(result, infos[uuid])
+	}
+	return result, nil
+}
+
+// returns the names of the queues for the given channel, of which there can be more than one if its TPS has changed
+func (b *backend) channelQueues(rc redis.Conn, uuid courier.ChannelUUID) ([]string, error) {
+	queues, err := queue.Queues(rc, msgQueueName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queues: %w", err)
+	}
+
+	names := make([]string, 0, 1)
+	for name := range queues {
+		if strings.HasPrefix(name, string(uuid)+"|") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// returns the TPS of the current queue of the given channel, or the given default if it doesn't have one
+func (b *backend) queueTPS(rc redis.Conn, uuid courier.ChannelUUID, defaultTPS int) (int, error) {
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return 0, err
+	}
+	if len(names) > 0 {
+		_, t, _ := strings.Cut(names[len(names)-1], "|")
+		if v, err := strconv.Atoi(t); err == nil {
+			return v, nil
+		}
+	}
+	return defaultTPS, nil
+}
+
+// PeekQueue returns up to limit pending messages for the given channel without removing them
+func (b *backend) PeekQueue(ctx context.Context, uuid courier.ChannelUUID, limit int) ([]json.RawMessage, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]json.RawMessage, 0, limit)
+
+	for _, name := range names {
+		values, err := queue.Peek(rc, msgQueueName, name, limit-len(msgs))
+		if err != nil {
+			return nil, fmt.Errorf("error peeking queue: %w", err)
+		}
+
+		// each queued value is a list of messages
+		for _, value := range values {
+			var batch []json.RawMessage
+			if err := json.Unmarshal([]byte(value), &batch); err != nil {
+				slog.Error("unable to unmarshal queued messages", "error", err, "value", value)
+				continue
+			}
+			for _, m := range batch {
+				if len(msgs) < limit {
+					msgs = append(msgs, m)
+				}
+			}
+		}
+	}
+
+	return msgs, nil
+}
+
+// PurgeQueue removes all pending messages for the given channel
+func (b *backend) PurgeQueue(ctx context.Context, uuid courier.ChannelUUID) (int, error) {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, name := range names {
+		n, err := queue.Purge(rc, msgQueueName, name)
+		if err != nil {
+			return purged, fmt.Errorf("error purging queue: %w", err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// PauseQueue pauses or resumes sending of messages for the given channel
+func (b *backend) PauseQueue(ctx context.Context, uuid courier.ChannelUUID, pause bool) error {
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	if pause {
+		return queue.Pause(rc, msgQueueName, string(uuid))
+	}
+	return queue.Resume(rc, msgQueueName, string(uuid))
+}
+
+// DrainQueue moves all pending messages for the given channel onto the queue of another channel
+func (b *backend) DrainQueue(ctx context.Context, from, to courier.ChannelUUID) (int, error) {
+	if _, err := b.GetChannel(ctx, courier.AnyChannelType, to); err != nil {
+		return 0, err
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	names, err := b.channelQueues(rc, from)
+	if err != nil {
+		return 0, err
+	}
+
+	drained := 0
+	for _, name := range names {
+		// our queue name is in the format uuid|tps, messages keep the TPS of the queue they came from
+		_, t, _ := strings.Cut(name, "|")
+		tps, _ := strconv.Atoi(t)
+
+		for _, priority := range []queue.Priority{queue.HighPriority, queue.LowPriority} {
+			items, err := queue.Take(rc, msgQueueName, name, priority)
+			if err != nil {
+				return drained, fmt.Errorf("error taking queued messages: %w", err)
+			}
+
+			for i, item := range items {
+				value, err := rechannelQueuedValue(item.Value, to)
+				if err == nil {
+					err = queue.PushOntoQueueAt(rc, msgQueueName, string(to), tps, value, priority, item.At)
+				}
+				if err != nil {
+					// put back anything we haven't moved so that it isn't lost
+					for _, rest := range items[i:] {
+						queue.PushOntoQueueAt(rc, msgQueueName, string(from), tps, rest.Value, priority, rest.At)
+					}
+					return drained, err
+				}
+				drained++
+			}
+		}
+	}
+	return drained, nil
+}
+
+// rewrites a queued value, which is a list of messages, so that its messages are sent by the given channel
+func rechannelQueuedValue(value string, uuid courier.ChannelUUID) (string, error) {
+	var msgs []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &msgs); err != nil {
+		return "", fmt.Errorf("error unmarshalling queued messages: %w", err)
+	}
+
+	for _, m := range msgs {
+		m["channel_uuid"] = jsonx.MustMarshal(uuid)
+	}
+	return string(jsonx.MustMarshal(msgs)), nil
+}
+
+// parses the TPS from a worker token, which is the name of the queue, e.g. msgs:<uuid>|<tps>
+func tpsFromWorkerToken(token queue.WorkerToken) int {
+	_, tps, found := strings.Cut(string(token), "|")
+	if !found {
+		return 0
+	}
+	v, _ := strconv.Atoi(tps)
+	return v
+}
@@ -0,0 +1,58 @@
+package standalone
+
+import (
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// StatusUpdate is a status update of an outgoing message, identified by its ID or its external ID
+type StatusUpdate struct {
+	channelUUID  courier.ChannelUUID
+	msgID        courier.MsgID
+	externalID   string
+	status       courier.MsgStatus
+	failedReason courier.MsgFailedReason
+	fallback     courier.ChannelUUID
+	segments     int
+	oldURN       urns.URN
+	newURN       urns.URN
+	createdOn    time.Time
+}
+
+func newStatusUpdate(channel courier.Channel, id courier.MsgID, externalID string, status courier.MsgStatus) *StatusUpdate {
+	return &StatusUpdate{
+		channelUUID: channel.UUID(),
+		msgID:       id,
+		externalID:  externalID,
+		status:      status,
+		createdOn:   time.Now().In(time.UTC),
+	}
+}
+
+func (s *StatusUpdate) EventID() int64                   { return int64(s.msgID) }
+func (s *StatusUpdate) ChannelUUID() courier.ChannelUUID { return s.channelUUID }
+func (s *StatusUpdate) MsgID() courier.MsgID             { return s.msgID }
+
+func (s *StatusUpdate) SetURNUpdate(old, new urns.URN) error {
+	s.oldURN = old
+	s.newURN = new
+	return nil
+}
+func (s *StatusUpdate) URNUpdate() (urns.URN, urns.URN) { return s.oldURN, s.newURN }
+
+func (s *StatusUpdate) ExternalID() string      { return s.externalID }
+func (s *StatusUpdate) SetExternalID(id string) { s.externalID = id }
+
+func (s *StatusUpdate) Status() courier.MsgStatus          { return s.status }
+func (s *StatusUpdate) SetStatus(status courier.MsgStatus) { s.status = status }
+
+func (s *StatusUpdate) FailedReason() courier.MsgFailedReason          { return s.failedReason }
+func (s *StatusUpdate) SetFailedReason(reason courier.MsgFailedReason) { s.failedReason = reason }
+
+func (s *StatusUpdate) FallbackChannel() courier.ChannelUUID       { return s.fallback }
+func (s *StatusUpdate) SetFallbackChannel(channel courier.Channel) { s.fallback = channel.UUID() }
+
+func (s *StatusUpdate) Segments() int     { return s.segments }
+func (s *StatusUpdate) SetSegments(n int) { s.segments = n }
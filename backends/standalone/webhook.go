@@ -0,0 +1,88 @@
+package standalone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// the types of events we POST to the webhook
+const (
+	webhookTypeMsgIn        = "msg_in"
+	webhookTypeStatus       = "status"
+	webhookTypeChannelEvent = "channel_event"
+)
+
+// webhookEvent is what we POST to the webhook, which has one of msg, status or event depending on its type
+type webhookEvent struct {
+	Type   string               `json:"type"`
+	Msg    *webhookMsg          `json:"msg,omitempty"`
+	Status *webhookStatus       `json:"status,omitempty"`
+	Event  *webhookChannelEvent `json:"event,omitempty"`
+}
+
+type webhookMsg struct {
+	UUID        courier.MsgUUID     `json:"uuid"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	ContactUUID courier.ContactUUID `json:"contact_uuid"`
+	URN         urns.URN            `json:"urn"`
+	ContactName string              `json:"contact_name,omitempty"`
+	Text        string              `json:"text"`
+	Attachments []string            `json:"attachments,omitempty"`
+	ExternalID  string              `json:"external_id,omitempty"`
+	Metadata    json.RawMessage     `json:"metadata,omitempty"`
+	IsEcho      bool                `json:"is_echo,omitempty"`
+	ReceivedOn  *time.Time          `json:"received_on,omitempty"`
+	CreatedOn   time.Time           `json:"created_on"`
+}
+
+type webhookStatus struct {
+	ChannelUUID  courier.ChannelUUID     `json:"channel_uuid"`
+	MsgID        courier.MsgID           `json:"msg_id,omitempty"`
+	ExternalID   string                  `json:"external_id,omitempty"`
+	Status       courier.MsgStatus       `json:"status"`
+	FailedReason courier.MsgFailedReason `json:"failed_reason,omitempty"`
+	Fallback     courier.ChannelUUID     `json:"fallback_channel_uuid,omitempty"`
+	Segments     int                     `json:"segments,omitempty"`
+	OldURN       urns.URN                `json:"old_urn,omitempty"`
+	NewURN       urns.URN                `json:"new_urn,omitempty"`
+	CreatedOn    time.Time               `json:"created_on"`
+}
+
+type webhookChannelEvent struct {
+	ChannelUUID courier.ChannelUUID      `json:"channel_uuid"`
+	ContactUUID courier.ContactUUID      `json:"contact_uuid"`
+	EventType   courier.ChannelEventType `json:"event_type"`
+	URN         urns.URN                 `json:"urn"`
+	ContactName string                   `json:"contact_name,omitempty"`
+	Extra       map[string]string        `json:"extra,omitempty"`
+	OccurredOn  time.Time                `json:"occurred_on"`
+	CreatedOn   time.Time                `json:"created_on"`
+}
+
+// POSTs the given event to the webhook, returning an error if it doesn't respond with a 2XX
+func (b *backend) postToWebhook(ctx context.Context, event *webhookEvent) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.StandaloneWebhook, bytes.NewReader(jsonx.MustMarshal(event)))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Courier/"+b.config.Version)
+
+	trace, err := httpx.DoTrace(b.httpClient, req, nil, nil, -1)
+	if err != nil {
+		return fmt.Errorf("error calling webhook: %w", err)
+	}
+	if trace.Response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned non-2XX response: %d", trace.Response.StatusCode)
+	}
+	return nil
+}
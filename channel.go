@@ -23,15 +23,60 @@ const (
 	// ConfigCallbackDomain is the domain that should be used for this channel when registering callbacks
 	ConfigCallbackDomain = "callback_domain"
 
+	// ConfigCampaignID is the ID of the 10DLC campaign that the channel's number is registered to, for handlers whose
+	// provider takes it on each send. There's no brand ID equivalent because a campaign belongs to a single brand and
+	// providers only take the campaign.
+	ConfigCampaignID = "campaign_id"
+
+	// ConfigChannelDefaults is an org config object of default config values by channel type which channels inherit
+	ConfigChannelDefaults = "channel_defaults"
+
 	// ConfigContentType is a constant key for channel configs
 	ConfigContentType = "content_type"
 
+	// ConfigFallbackChannel is the UUID of a channel in the same workspace which messages are moved to when they keep
+	// erroring on this channel, e.g. an SMS channel for a WhatsApp channel
+	ConfigFallbackChannel = "fallback_channel"
+
+	// ConfigFallbackAttempts is the number of consecutive errored attempts to send a message before it's moved to the
+	// fallback channel, defaults to 2
+	ConfigFallbackAttempts = "fallback_attempts"
+
+	// ConfigLogRetention is the number of days a channel's logs are kept for, in place of the default of 7 days
+	ConfigLogRetention = "log_retention"
+
+	// ConfigLogSampling is an object with success_rate and error_rate (between 0 and 1) controlling the fraction of a
+	// channel's logs without and with errors which are written
+	ConfigLogSampling = "log_sampling"
+
+	// ConfigMarkRead is whether incoming messages should be marked as read when they're replied to, for handlers that support it
+	ConfigMarkRead = "mark_read"
+
 	// ConfigMaxLength is the maximum size of a message in characters
 	ConfigMaxLength = "max_length"
 
+	// ConfigMaxBulkSize is the maximum number of messages to send in a single request for handlers that support it
+	ConfigMaxBulkSize = "max_bulk_size"
+
+	// ConfigMaxSegments is the maximum number of SMS segments in each part of a message, for handlers which split
+	// messages into parts, in place of splitting by the maximum length in characters
+	ConfigMaxSegments = "max_segments"
+
+	// ConfigNumberLookup is an org config object with the provider and credentials to use for number lookups before sending
+	ConfigNumberLookup = "number_lookup"
+
 	// ConfigPassword is a constant key for channel configs
 	ConfigPassword = "password"
 
+	// ConfigRelayURL is a constant key for channel configs, incoming messages and statuses are also POSTed to this URL
+	ConfigRelayURL = "relay_url"
+
+	// ConfigRelaySecret is a constant key for channel configs, the secret used to sign requests to the relay URL
+	ConfigRelaySecret = "relay_secret"
+
+	// ConfigRetryPolicy is an object with max_retries, backoff and jitter (in seconds) controlling how errored messages are retried
+	ConfigRetryPolicy = "retry_policy"
+
 	// ConfigSecret is the secret used for signing commands by the channel
 	ConfigSecret = "secret"
 
@@ -47,6 +92,37 @@ const (
 	// ConfigSendURL is a constant key for channel configs
 	ConfigSendURL = "send_url"
 
+	// ConfigSendTimeout is the number of seconds to wait for each request made to the channel, overriding the default
+	// of its handler
+	ConfigSendTimeout = "send_timeout"
+
+	// ConfigSenderPool is a list of numbers which messages are sent from in place of the channel's own address, to spread
+	// volume over numbers with carrier throughput caps
+	ConfigSenderPool = "sender_pool"
+
+	// ConfigSenderPoolMode is how numbers are picked from the sender pool, either sticky per contact or round_robin
+	ConfigSenderPoolMode = "sender_pool_mode"
+
+	// ConfigShortLinks is whether SMS handlers send short links in place of attachment URLs, overriding the deployment
+	// level setting
+	ConfigShortLinks = "short_links"
+
+	// ConfigSmartEncoding is whether SMS handlers replace characters like curly quotes with lookalikes so that messages
+	// can be sent as GSM-7 rather than UCS-2
+	ConfigSmartEncoding = "smart_encoding"
+
+	// ConfigStitchPhoneURNs is an org config flag which links new phone based URNs to existing contacts with the same
+	// number under a different scheme, e.g. a whatsapp URN to a contact with a matching tel URN
+	ConfigStitchPhoneURNs = "stitch_phone_urns"
+
+	// ConfigTenDLC is whether the channel's number is a US 10DLC number, which carriers require to send A2P messages as
+	// part of a registered campaign
+	ConfigTenDLC = "ten_dlc"
+
+	// ConfigTrackClicks is whether SMS handlers replace links in messages with short links which record clicks on them
+	// as channel events
+	ConfigTrackClicks = "track_clicks"
+
 	// ConfigUsername is a constant key for channel configs
 	ConfigUsername = "username"
 
@@ -55,6 +131,9 @@ const (
 
 	// ConfigSendHeaders is a constant key for channel configs
 	ConfigSendHeaders = "headers"
+
+	// ConfigWebhookMirrorURL is a constant key for channel configs, requests to the channel are also sent to this URL
+	ConfigWebhookMirrorURL = "webhook_mirror_url"
 )
 
 // ChannelType is the 1-3 letter code used for channel types in the database
@@ -115,6 +194,9 @@ var ErrChannelNotFound = errors.New("channel not found")
 // ErrChannelWrongType is returned when we find a channel with the set UUID but with a different type
 var ErrChannelWrongType = errors.New("channel type wrong")
 
+// ErrChannelConfigConflict is returned when updating the config of a channel which has been modified since it was loaded
+var ErrChannelConfigConflict = errors.New("channel config modified")
+
 //-----------------------------------------------------------------------------
 // Channel Interface
 //-----------------------------------------------------------------------------
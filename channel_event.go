@@ -17,6 +17,11 @@ const (
 	EventTypeWelcomeMessage  ChannelEventType = "welcome_message"
 	EventTypeOptIn           ChannelEventType = "optin"
 	EventTypeOptOut          ChannelEventType = "optout"
+	EventTypeReaction        ChannelEventType = "reaction"
+	EventTypeComment         ChannelEventType = "comment"
+	EventTypeURNDeactivated  ChannelEventType = "urn_deactivated"
+	EventTypeLinkClicked     ChannelEventType = "link_clicked"
+	EventTypeCallStatus      ChannelEventType = "call_status"
 )
 
 //-----------------------------------------------------------------------------
@@ -2,6 +2,7 @@ package courier
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
@@ -18,8 +19,17 @@ const (
 	ChannelLogTypeTokenRefresh    clogs.LogType = "token_refresh"
 	ChannelLogTypePageSubscribe   clogs.LogType = "page_subscribe"
 	ChannelLogTypeWebhookVerify   clogs.LogType = "webhook_verify"
+	ChannelLogTypeQualityCheck    clogs.LogType = "quality_check"
+	ChannelLogTypeNumberLookup    clogs.LogType = "number_lookup"
+	ChannelLogTypeDeactivations   clogs.LogType = "deactivations_fetch"
+	ChannelLogTypeMsgPreview      clogs.LogType = "msg_preview"
+	ChannelLogTypeProfileUpdate   clogs.LogType = "profile_update"
 )
 
+func ErrorRequestTimeout(timeout time.Duration) *clogs.LogError {
+	return clogs.NewLogError("request_timeout", "", "Request timed out after %s.", timeout)
+}
+
 func ErrorResponseStatusCode() *clogs.LogError {
 	return clogs.NewLogError("response_status_code", "", "Unexpected response status code.")
 }
@@ -49,6 +59,34 @@ func ErrorAttachmentNotDecodable() *clogs.LogError {
 	return clogs.NewLogError("attachment_not_decodable", "", "Unable to decode embedded attachment data.")
 }
 
+func ErrorAttachmentTooLarge(maxSize int) *clogs.LogError {
+	return clogs.NewLogError("attachment_too_large", "", "Attachment exceeds maximum size of %d bytes.", maxSize)
+}
+
+func ErrorAttachmentTypeNotAllowed(contentType string) *clogs.LogError {
+	return clogs.NewLogError("attachment_type_not_allowed", "", "Attachment type %s is not allowed.", contentType)
+}
+
+func ErrorAttachmentInfected(signature string) *clogs.LogError {
+	return clogs.NewLogError("attachment_infected", "", "Attachment rejected by anti-virus scanning: %s.", signature)
+}
+
+func ErrorHandlerPanic() *clogs.LogError {
+	return clogs.NewLogError("handler_panic", "", "An internal error occurred whilst sending the message.")
+}
+
+func ErrorPoisonMsg(crashes int) *clogs.LogError {
+	return clogs.NewLogError("poison_message", "", "Message failed after %d attempts to send it caused internal errors.", crashes)
+}
+
+func ErrorCircuitOpened(cooldown time.Duration) *clogs.LogError {
+	return clogs.NewLogError("circuit_opened", "", "Too many sends failed so sending has been paused for %s.", cooldown)
+}
+
+func ErrorFailover(fallback ChannelUUID, attempts int) *clogs.LogError {
+	return clogs.NewLogError("failover", "", "Message moved to fallback channel %s after %d failed attempts.", fallback, attempts)
+}
+
 func ErrorExternal(code, message string) *clogs.LogError {
 	if message == "" {
 		message = fmt.Sprintf("Service specific error: %s.", code)
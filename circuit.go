@@ -0,0 +1,208 @@
+package courier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
+)
+
+// how many buckets the window over which we calculate the error rate of a channel's sends is split into
+const circuitBuckets = 6
+
+// how long an open circuit is remembered for if the channel doesn't try to send again
+const circuitTTL = time.Hour
+
+type circuitChange int
+
+const (
+	circuitUnchanged circuitChange = iota
+	circuitOpened
+	circuitClosed
+)
+
+// circuitBreaker pauses sending for channels whose providers are failing. The outcomes of sends are tracked in valkey so
+// that all instances share the state of each circuit. A circuit opens when the rate of sends failing with connection
+// errors or 5xx responses within the window reaches the threshold. After the cooldown a single send is let through as
+// a probe, and the circuit closes if that succeeds or stays open for another cooldown if it doesn't.
+type circuitBreaker struct {
+	errorRate int
+	minSends  int
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+func newCircuitBreaker(cfg *Config) *circuitBreaker {
+	return &circuitBreaker{
+		errorRate: cfg.CircuitErrorRate,
+		minSends:  cfg.CircuitMinSends,
+		window:    time.Duration(cfg.CircuitWindow) * time.Second,
+		cooldown:  time.Duration(cfg.CircuitCooldown) * time.Second,
+	}
+}
+
+func (b *circuitBreaker) enabled() bool {
+	return b.errorRate > 0 && b.window > 0
+}
+
+// returns how long sends by the given channel should be deferred for because its circuit is open, which is zero if the
+// circuit is closed or this send has been chosen to probe whether the provider has recovered
+func (b *circuitBreaker) check(rc redis.Conn, uuid ChannelUUID, now time.Time) (time.Duration, error) {
+	openedOn, err := b.openedOn(rc, uuid)
+	if err != nil || openedOn.IsZero() {
+		return 0, err
+	}
+
+	if remaining := openedOn.Add(b.cooldown).Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+
+	// only one send gets to probe the provider per cooldown
+	probing, err := redis.String(rc.Do("SET", circuitKey(uuid, "probe"), "1", "NX", "PX", b.cooldown.Milliseconds()))
+	if err != nil && err != redis.ErrNil {
+		return 0, fmt.Errorf("error acquiring circuit probe: %w", err)
+	}
+	if probing == "OK" {
+		return 0, nil
+	}
+	return b.cooldown, nil
+}
+
+// records the outcome of a send by the given channel, returning whether that opened or closed its circuit
+func (b *circuitBreaker) record(rc redis.Conn, uuid ChannelUUID, failed bool, now time.Time) (circuitChange, error) {
+	openedOn, err := b.openedOn(rc, uuid)
+	if err != nil {
+		return circuitUnchanged, err
+	}
+
+	if !openedOn.IsZero() {
+		// ignore sends which were already underway when the circuit opened
+		if now.Before(openedOn.Add(b.cooldown)) {
+			return circuitUnchanged, nil
+		}
+
+		// otherwise this was the probe so it decides whether the circuit closes or stays open
+		if failed {
+			rc.Send("MULTI")
+			rc.Send("SET", circuitKey(uuid, "opened"), now.UnixMilli(), "EX", int(circuitTTL/time.Second))
+			rc.Send("DEL", circuitKey(uuid, "probe"))
+			if _, err := rc.Do("EXEC"); err != nil {
+				return circuitUnchanged, fmt.Errorf("error reopening circuit: %w", err)
+			}
+			return circuitOpened, nil
+		}
+
+		keys := append([]any{circuitKey(uuid, "opened"), circuitKey(uuid, "probe")}, b.bucketKeys(uuid, now)...)
+		if _, err := rc.Do("DEL", keys...); err != nil {
+			return circuitUnchanged, fmt.Errorf("error closing circuit: %w", err)
+		}
+		return circuitClosed, nil
+	}
+
+	bucketSize := b.window / circuitBuckets
+	bucketKey := circuitKey(uuid, fmt.Sprintf("sends:%d", now.UnixMilli()/bucketSize.Milliseconds()))
+
+	rc.Send("MULTI")
+	rc.Send("HINCRBY", bucketKey, "total", 1)
+	if failed {
+		rc.Send("HINCRBY", bucketKey, "failed", 1)
+	}
+	rc.Send("PEXPIRE", bucketKey, (b.window + bucketSize).Milliseconds())
+	if _, err := rc.Do("EXEC"); err != nil {
+		return circuitUnchanged, fmt.Errorf("error recording circuit send: %w", err)
+	}
+
+	if !failed {
+		return circuitUnchanged, nil
+	}
+
+	total, failures, err := b.counts(rc, uuid, now)
+	if err != nil {
+		return circuitUnchanged, err
+	}
+	if total < b.minSends || failures*100 < b.errorRate*total {
+		return circuitUnchanged, nil
+	}
+
+	opened, err := redis.String(rc.Do("SET", circuitKey(uuid, "opened"), now.UnixMilli(), "NX", "EX", int(circuitTTL/time.Second)))
+	if err != nil && err != redis.ErrNil {
+		return circuitUnchanged, fmt.Errorf("error opening circuit: %w", err)
+	}
+	if opened == "OK" {
+		return circuitOpened, nil
+	}
+	return circuitUnchanged, nil // another instance beat us to it
+}
+
+// returns when the circuit of the given channel was opened, or zero time if it's closed
+func (b *circuitBreaker) openedOn(rc redis.Conn, uuid ChannelUUID) (time.Time, error) {
+	millis, err := redis.Int64(rc.Do("GET", circuitKey(uuid, "opened")))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("error reading circuit state: %w", err)
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// returns the total and failed sends of the given channel within the window
+func (b *circuitBreaker) counts(rc redis.Conn, uuid ChannelUUID, now time.Time) (int, int, error) {
+	keys := b.bucketKeys(uuid, now)
+	for _, key := range keys {
+		rc.Send("HMGET", key, "total", "failed")
+	}
+	if err := rc.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("error reading circuit sends: %w", err)
+	}
+
+	total, failed := 0, 0
+	for range keys {
+		counts, err := redis.Ints(rc.Receive())
+		if err != nil {
+			return 0, 0, fmt.Errorf("error reading circuit sends: %w", err)
+		}
+		total += counts[0]
+		failed += counts[1]
+	}
+	return total, failed, nil
+}
+
+func (b *circuitBreaker) bucketKeys(uuid ChannelUUID, now time.Time) []any {
+	bucketSize := b.window / circuitBuckets
+	current := now.UnixMilli() / bucketSize.Milliseconds()
+
+	keys := make([]any, circuitBuckets)
+	for i := range keys {
+		keys[i] = circuitKey(uuid, fmt.Sprintf("sends:%d", current-int64(i)))
+	}
+	return keys
+}
+
+// keys of a channel's circuit are tagged with its UUID so that they're in the same slot in a cluster but circuits of
+// different channels are spread across slots
+func circuitKey(uuid ChannelUUID, part string) string {
+	return valkey.WithTag(string(uuid), fmt.Sprintf("circuit:%s:%s", uuid, part))
+}
+
+// whether the provider failed the given send, i.e. its last request couldn't connect or got a 5xx response, and whether
+// the send made any requests at all
+func isProviderFailure(clog *ChannelLog) (bool, bool) {
+	if len(clog.HttpLogs) == 0 {
+		return false, false
+	}
+	last := clog.HttpLogs[len(clog.HttpLogs)-1]
+	return last.StatusCode == 0 || last.StatusCode >= 500, true
+}
+
+// errCircuitOpen is used in place of the result of a send which is deferred because its channel's circuit is open
+func errCircuitOpen(delay time.Duration) *SendError {
+	return &SendError{
+		msg:        "channel circuit open",
+		retryable:  true,
+		loggable:   false,
+		retryAfter: delay,
+		clogCode:   "circuit_open",
+		clogMsg:    fmt.Sprintf("Sending paused because the provider is failing, will retry after %s.", delay.Round(time.Second)),
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+const channelUsage = `usage: courier channel status <channel-uuid> [-url URL]
+       courier channel pause <channel-uuid> [-url URL]
+       courier channel resume <channel-uuid> [-url URL]
+       courier channel drain <channel-uuid> -to <channel-uuid> [-url URL]
+
+Manages the outgoing queue of a channel via the admin API of a running courier, authenticating with its auth token.
+Paused channels keep receiving but don't send until resumed. Draining moves all pending messages of a channel onto the
+queue of another channel, e.g. when migrating to a new provider.
+`
+
+// runs the channel command with the given args, returning the exit code
+func runChannel(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 2 {
+		fmt.Fprint(stderr, channelUsage)
+		return 2
+	}
+
+	command, uuid := args[0], args[1]
+
+	config, err := courier.ReadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading config: %s\n", err)
+		return 1
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	baseURL := flags.String("url", fmt.Sprintf("http://localhost:%d", config.Port), "the base URL of the running courier")
+	to := flags.String("to", "", "the channel to drain pending messages to")
+	if err := flags.Parse(args[2:]); err != nil {
+		return 2
+	}
+
+	path := "/admin/queues/" + url.PathEscape(uuid)
+	client := &adminClient{baseURL: *baseURL, authToken: config.AuthToken}
+
+	switch command {
+	case "status":
+		resp := &struct {
+			Queue *courier.QueueInfo `json:"queue"`
+		}{}
+		if err := client.do(http.MethodGet, path, resp); err != nil {
+			fmt.Fprintf(stderr, "error reading queue: %s\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "channel %s: paused=%t size=%d bulk_size=%d workers=%d tps=%d\n", uuid, resp.Queue.Paused, resp.Queue.Size, resp.Queue.BulkSize, resp.Queue.Workers, resp.Queue.TPS)
+	case "pause", "resume":
+		if err := client.do(http.MethodPost, path+"/"+command, nil); err != nil {
+			fmt.Fprintf(stderr, "error updating queue: %s\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "channel %s %sd\n", uuid, command)
+	case "drain":
+		if *to == "" {
+			fmt.Fprint(stderr, channelUsage)
+			return 2
+		}
+
+		resp := &struct {
+			Drained int `json:"drained"`
+		}{}
+		if err := client.do(http.MethodPost, path+"/drain?to="+url.QueryEscape(*to), resp); err != nil {
+			fmt.Fprintf(stderr, "error draining queue: %s\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "moved %d queued items from channel %s to %s\n", resp.Drained, uuid, *to)
+	default:
+		fmt.Fprint(stderr, channelUsage)
+		return 2
+	}
+	return 0
+}
+
+// makes requests to the admin API of a running courier
+type adminClient struct {
+	baseURL   string
+	authToken string
+}
+
+func (c *adminClient) do(method, path string, resp any) error {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		e := &struct {
+			Data []courier.ErrorData `json:"data"`
+		}{}
+		if json.Unmarshal(body, e) == nil && len(e.Data) > 0 {
+			return fmt.Errorf("%s (%d)", e.Data[0].Error, r.StatusCode)
+		}
+		return fmt.Errorf("received non-200 response: %d", r.StatusCode)
+	}
+
+	if resp != nil {
+		return json.Unmarshal(body, resp)
+	}
+	return nil
+}
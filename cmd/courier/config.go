@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nyaruka/courier"
+)
+
+const configUsage = `usage: courier config validate
+       courier config dump [-redacted]
+
+Checks or prints the config that courier would run with, as read from config.toml and COURIER_ environment variables.
+`
+
+// runs the config command with the given args, returning the exit code
+func runConfig(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "validate":
+		return validateConfig(stdout, stderr)
+	case "dump":
+		flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+		flags.SetOutput(stderr)
+		redacted := flags.Bool("redacted", false, "mask secrets and URL passwords")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		return dumpConfig(stdout, stderr, *redacted)
+	default:
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+}
+
+func validateConfig(stdout, stderr io.Writer) int {
+	config, err := courier.ReadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading config: %s\n", err)
+		return 1
+	}
+
+	valid := true
+
+	for _, key := range courier.UnknownEnvVars(os.Environ()) {
+		fmt.Fprintf(stderr, "unknown environment variable: %s\n", key)
+		valid = false
+	}
+
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(stderr, "invalid config: %s\n", err)
+		valid = false
+	}
+
+	if err := config.ValidateCombinations(); err != nil {
+		fmt.Fprintf(stderr, "invalid config: %s\n", err)
+		valid = false
+	}
+
+	if !valid {
+		return 1
+	}
+
+	fmt.Fprintln(stdout, "config is valid")
+	return 0
+}
+
+func dumpConfig(stdout, stderr io.Writer, redacted bool) int {
+	config, err := courier.ReadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading config: %s\n", err)
+		return 1
+	}
+
+	if err := config.Dump(stdout, redacted); err != nil {
+		fmt.Fprintf(stderr, "error writing config: %s\n", err)
+		return 1
+	}
+	return 0
+}
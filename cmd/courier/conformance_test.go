@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+)
+
+// handlers which are known to not pass a check, and why. New handlers should pass every check.
+var conformanceExemptions = map[courier.ChannelType][]handlers.ConformanceCheck{
+	"RR":  {handlers.CheckRoutes},                             // can only send messages
+	"TST": {handlers.CheckRoutes, handlers.CheckErrorMapping}, // simulates sending for load testing without making requests
+}
+
+// TestHandlerConformance runs the conformance checklist against every handler imported by main
+func TestHandlerConformance(t *testing.T) {
+	for _, h := range courier.RegisteredHandlers() {
+		t.Run(string(h.ChannelType()), func(t *testing.T) {
+			handlers.RunConformanceChecks(t, h, conformanceExemptions[h.ChannelType()]...)
+		})
+	}
+}
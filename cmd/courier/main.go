@@ -10,11 +10,14 @@ import (
 	"github.com/getsentry/sentry-go"
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/grpcapi"
 	slogmulti "github.com/samber/slog-multi"
 	slogsentry "github.com/samber/slog-sentry/v2"
 
 	// load channel handler packages
 	_ "github.com/nyaruka/courier/handlers/africastalking"
+	_ "github.com/nyaruka/courier/handlers/apns"
+	_ "github.com/nyaruka/courier/handlers/app"
 	_ "github.com/nyaruka/courier/handlers/arabiacell"
 	_ "github.com/nyaruka/courier/handlers/bandwidth"
 	_ "github.com/nyaruka/courier/handlers/bongolive"
@@ -27,11 +30,13 @@ import (
 	_ "github.com/nyaruka/courier/handlers/dialog360"
 	_ "github.com/nyaruka/courier/handlers/discord"
 	_ "github.com/nyaruka/courier/handlers/dmark"
+	_ "github.com/nyaruka/courier/handlers/expo"
 	_ "github.com/nyaruka/courier/handlers/external"
 	_ "github.com/nyaruka/courier/handlers/facebook_legacy"
 	_ "github.com/nyaruka/courier/handlers/firebase"
 	_ "github.com/nyaruka/courier/handlers/freshchat"
 	_ "github.com/nyaruka/courier/handlers/globe"
+	_ "github.com/nyaruka/courier/handlers/googlebm"
 	_ "github.com/nyaruka/courier/handlers/highconnection"
 	_ "github.com/nyaruka/courier/handlers/hormuud"
 	_ "github.com/nyaruka/courier/handlers/hub9"
@@ -40,6 +45,7 @@ import (
 	_ "github.com/nyaruka/courier/handlers/jasmin"
 	_ "github.com/nyaruka/courier/handlers/jiochat"
 	_ "github.com/nyaruka/courier/handlers/justcall"
+	_ "github.com/nyaruka/courier/handlers/kakao"
 	_ "github.com/nyaruka/courier/handlers/kaleyra"
 	_ "github.com/nyaruka/courier/handlers/kannel"
 	_ "github.com/nyaruka/courier/handlers/line"
@@ -58,9 +64,11 @@ import (
 	_ "github.com/nyaruka/courier/handlers/redrabbit"
 	_ "github.com/nyaruka/courier/handlers/rocketchat"
 	_ "github.com/nyaruka/courier/handlers/shaqodoon"
+	_ "github.com/nyaruka/courier/handlers/sinch"
 	_ "github.com/nyaruka/courier/handlers/slack"
 	_ "github.com/nyaruka/courier/handlers/smscentral"
 	_ "github.com/nyaruka/courier/handlers/start"
+	_ "github.com/nyaruka/courier/handlers/teams"
 	_ "github.com/nyaruka/courier/handlers/telegram"
 	_ "github.com/nyaruka/courier/handlers/telesom"
 	_ "github.com/nyaruka/courier/handlers/test"
@@ -70,12 +78,15 @@ import (
 	_ "github.com/nyaruka/courier/handlers/vk"
 	_ "github.com/nyaruka/courier/handlers/wavy"
 	_ "github.com/nyaruka/courier/handlers/wechat"
+	_ "github.com/nyaruka/courier/handlers/wecom"
 	_ "github.com/nyaruka/courier/handlers/whatsapp_legacy"
 	_ "github.com/nyaruka/courier/handlers/yo"
+	_ "github.com/nyaruka/courier/handlers/zalo"
 	_ "github.com/nyaruka/courier/handlers/zenvia"
 
 	// load available backends
 	_ "github.com/nyaruka/courier/backends/rapidpro"
+	_ "github.com/nyaruka/courier/backends/standalone"
 )
 
 var (
@@ -85,6 +96,16 @@ var (
 )
 
 func main() {
+	// the config command checks or prints our config without starting courier
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// the channel command manages the queues of channels via the admin API of a running courier
+	if len(os.Args) > 1 && os.Args[1] == "channel" {
+		os.Exit(runChannel(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	config := courier.LoadConfig()
 	config.Version = version
 
@@ -127,9 +148,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	// start our gRPC API if enabled
+	var grpcServer *grpcapi.Server
+	if config.GRPCAddress != "" {
+		grpcServer = grpcapi.NewServer(config, backend)
+		if err := grpcServer.Start(); err != nil {
+			log.Error("unable to start gRPC API", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	log.Info("stopping", "comp", "main", "signal", <-ch)
 
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
 	server.Stop()
 }
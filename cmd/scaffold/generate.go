@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+var templates = template.Must(template.New("").ParseFS(templatesFS, "templates/*.tmpl"))
+
+// values used by the generated tests
+const (
+	testAddress    = "2020"
+	testURN        = "tel:+250788383383"
+	testText       = "Simple Message ☺"
+	testMsgID      = "10"
+	testExternalID = "12345"
+)
+
+type param struct {
+	Name      string
+	Expr      string // Go expression for the value
+	TestValue string // the value expected when sending the test message
+}
+
+type status struct {
+	Value string
+	Const string
+}
+
+// the data passed to the templates, with everything the templates need already worked out
+type model struct {
+	*Spec
+
+	Imports     []string
+	TestImports []string
+
+	Route         string
+	ReceiveMethod string
+	ReceiveQuery  string
+	MissingQuery  string
+	StatusMethod  string
+	Statuses      []status
+	StatusQuery   string
+
+	Params         []param
+	ResponseIDPath string // quoted path elements for jsonparser
+	TestResponse   string
+	TestConfig     map[string]string // keyed by Go expressions of the config keys
+	TestRequest    string            // expected request as a Go literal of the fields of an ExpectedRequest
+	TestRedact     string
+}
+
+// Generate returns the generated files of the handler package, keyed by file name
+func Generate(s *Spec) (map[string][]byte, error) {
+	m, err := newModel(s)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, 2)
+	for _, name := range []string{"handler.go", "handler_test.go"} {
+		buf := &bytes.Buffer{}
+		if err := templates.ExecuteTemplate(buf, name+".tmpl", m); err != nil {
+			return nil, fmt.Errorf("error executing template %s: %w", name, err)
+		}
+
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("error formatting %s: %w", name, err)
+		}
+		files[name] = src
+	}
+	return files, nil
+}
+
+func newModel(s *Spec) (*model, error) {
+	m := &model{Spec: s, TestConfig: make(map[string]string)}
+
+	m.Route = strings.ToLower(s.ChannelType)
+	m.ReceiveMethod = methodConst(s.Receive.Method)
+	receiveQuery := url.Values{s.Receive.From: []string{"+250788383383"}, s.Receive.Text: []string{"Hello World"}}
+	if s.Receive.ID != "" {
+		receiveQuery.Set(s.Receive.ID, "ext123")
+	}
+	m.ReceiveQuery = receiveQuery.Encode()
+	m.MissingQuery = url.Values{s.Receive.Text: []string{"Hello World"}}.Encode()
+
+	if s.Status != nil {
+		m.StatusMethod = methodConst(s.Status.Method)
+		for _, v := range sortedKeys(s.Status.Statuses) {
+			m.Statuses = append(m.Statuses, status{Value: v, Const: statusNames[s.Status.Statuses[v]]})
+		}
+		m.StatusQuery = url.Values{s.Status.ID: []string{testExternalID}, s.Status.Status: []string{m.Statuses[0].Value}}.Encode()
+	}
+
+	imports := []string{"context", "net/http"}
+	usesStrings := false
+
+	testValues := make(map[string]string)
+	for _, name := range sortedKeys(s.Send.Params) {
+		p := param{Name: name}
+		value := s.Send.Params[name]
+
+		switch value {
+		case "{{to}}":
+			p.Expr, p.TestValue = `strings.TrimPrefix(msg.URN().Path(), "+")`, "250788383383"
+			usesStrings = true
+		case "{{to_e164}}":
+			p.Expr, p.TestValue = `msg.URN().Path()`, "+250788383383"
+		case "{{from}}":
+			p.Expr, p.TestValue = `msg.Channel().Address()`, testAddress
+		case "{{text}}":
+			p.Expr, p.TestValue = `part`, testText
+		case "{{id}}":
+			p.Expr, p.TestValue = `msg.ID().String()`, testMsgID
+		default:
+			if strings.HasPrefix(value, "{{config:") {
+				key := strings.TrimSuffix(strings.TrimPrefix(value, "{{config:"), "}}")
+				p.Expr, p.TestValue = fmt.Sprintf(`msg.Channel().StringConfigForKey(%q, "")`, key), key+"-value"
+				m.TestConfig[strconv.Quote(key)] = p.TestValue
+			} else {
+				p.Expr, p.TestValue = strconv.Quote(value), value
+			}
+		}
+
+		m.Params = append(m.Params, p)
+		testValues[name] = p.TestValue
+	}
+
+	if s.Send.Format == formatJSON {
+		imports = append(imports, "bytes", "github.com/nyaruka/gocommon/jsonx")
+		body, _ := json.Marshal(testValues)
+		m.TestRequest = fmt.Sprintf("Body: %s", strconv.Quote(string(body)))
+	} else {
+		imports = append(imports, "net/url")
+		usesStrings = true
+
+		lines := make([]string, 0, len(m.Params))
+		for _, p := range m.Params {
+			lines = append(lines, fmt.Sprintf("%q: {%q},", p.Name, p.TestValue))
+		}
+		m.TestRequest = fmt.Sprintf("Form: url.Values{\n%s\n}", strings.Join(lines, "\n"))
+		m.TestImports = append(m.TestImports, "net/url")
+	}
+
+	if s.Send.ResponseID != "" {
+		path := strings.Split(s.Send.ResponseID, ".")
+		quoted := make([]string, len(path))
+		for i, p := range path {
+			quoted[i] = strconv.Quote(p)
+		}
+		m.ResponseIDPath = strings.Join(quoted, ", ")
+
+		resp, err := json.Marshal(testResponse(path))
+		if err != nil {
+			return nil, err
+		}
+		m.TestResponse = string(resp)
+		imports = append(imports, "github.com/buger/jsonparser")
+	} else {
+		m.TestResponse = `{}`
+	}
+
+	switch s.Auth {
+	case authBasic:
+		m.TestConfig["courier.ConfigUsername"] = "user1"
+		m.TestConfig["courier.ConfigPassword"] = "pass1"
+		m.TestRedact = `httpx.BasicAuth("user1", "pass1")`
+	case authBearer:
+		m.TestConfig["courier.ConfigAPIKey"] = "secret123"
+		m.TestRedact = `"secret123"`
+	}
+
+	if usesStrings {
+		imports = append(imports, "strings")
+	}
+	imports = append(imports, "github.com/nyaruka/courier", "github.com/nyaruka/courier/handlers", "github.com/nyaruka/gocommon/urns")
+	if s.Auth == authBasic {
+		imports = append(imports, "github.com/nyaruka/gocommon/httpx")
+	}
+	if s.Status != nil {
+		imports = append(imports, "fmt")
+	}
+
+	m.Imports = sortImports(imports)
+	m.TestImports = sortImports(append(m.TestImports, "testing", "github.com/nyaruka/courier", ". github.com/nyaruka/courier/handlers", "github.com/nyaruka/courier/test", "github.com/nyaruka/gocommon/httpx", "github.com/nyaruka/gocommon/urns"))
+	return m, nil
+}
+
+var arrayIndexRegex = regexp.MustCompile(`^\[(\d+)\]$`)
+
+// builds a response to the test send with the external ID at the given path
+func testResponse(path []string) any {
+	if len(path) == 0 {
+		return testExternalID
+	}
+
+	if match := arrayIndexRegex.FindStringSubmatch(path[0]); match != nil {
+		idx, _ := strconv.Atoi(match[1])
+		arr := make([]any, idx+1)
+		for i := range arr {
+			arr[i] = map[string]any{}
+		}
+		arr[idx] = testResponse(path[1:])
+		return arr
+	}
+
+	return map[string]any{path[0]: testResponse(path[1:])}
+}
+
+func methodConst(m string) string {
+	if m == "GET" {
+		return "http.MethodGet"
+	}
+	return "http.MethodPost"
+}
+
+// sorts and dedupes imports, which may be aliased like ". path", with standard library packages first
+func sortImports(imports []string) []string {
+	slices.Sort(imports)
+	imports = slices.Compact(imports)
+
+	std := make([]string, 0, len(imports))
+	other := make([]string, 0, len(imports))
+	for _, i := range imports {
+		name, path, aliased := strings.Cut(i, " ")
+		if !aliased {
+			name, path = "", i
+		}
+		spec := strings.TrimSpace(name + " " + strconv.Quote(path))
+
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	if len(other) > 0 {
+		std = append(std, "")
+	}
+	return append(std, other...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	spec, err := ReadSpec("testdata/basic_form.json")
+	require.NoError(t, err)
+
+	files, err := Generate(spec)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	handler := string(files["handler.go"])
+	assert.Contains(t, handler, "package zzacme")
+	assert.Contains(t, handler, `handlers.NewBaseHandler(courier.ChannelType("ZA"), "Acme SMS")`)
+	assert.Contains(t, handler, `s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)`)
+	assert.Contains(t, handler, `s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, h.receiveStatus)`)
+	assert.Contains(t, handler, `"DELIVRD": courier.MsgStatusDelivered,`)
+	assert.Contains(t, handler, `"service": []string{msg.Channel().StringConfigForKey("service_id", "")},`)
+	assert.Contains(t, handler, `jsonparser.Get(respBody, "messages", "[0]", "id")`)
+	assert.Contains(t, handler, "req.SetBasicAuth(username, password)")
+
+	tests := string(files["handler_test.go"])
+	assert.Contains(t, tests, `receiveURL  = "/c/za/" + channelUUID + "/receive/"`)
+	assert.Contains(t, tests, `httpx.NewMockResponse(200, nil, []byte("{\"messages\":[{\"id\":\"12345\"}]}"))`)
+	assert.Contains(t, tests, `"to":      {"250788383383"},`)
+	assert.Contains(t, tests, `[]string{httpx.BasicAuth("user1", "pass1")}`)
+
+	spec, err = ReadSpec("testdata/bearer_json.json")
+	require.NoError(t, err)
+
+	files, err = Generate(spec)
+	require.NoError(t, err)
+
+	handler = string(files["handler.go"])
+	assert.Contains(t, handler, "handlers.WithRedactConfigKeys(courier.ConfigAPIKey)")
+	assert.Contains(t, handler, `req.Header.Set("Authorization", "Bearer "+apiKey)`)
+	assert.Contains(t, handler, "bytes.NewReader(jsonx.MustMarshal(payload))")
+	assert.NotContains(t, handler, "receiveStatus")
+	assert.NotContains(t, handler, "jsonparser")
+
+	tests = string(files["handler_test.go"])
+	assert.Contains(t, tests, `Body: "{\"text\":\"Simple Message ☺\",\"to\":\"+250788383383\"}"`)
+	assert.Contains(t, tests, `Data:                 "body=Hello+World&from=%2B250788383383"`)
+}
+
+func TestSpecValidation(t *testing.T) {
+	valid := func() *Spec {
+		return &Spec{
+			Package:     "acme",
+			ChannelType: "AC",
+			Name:        "Acme",
+			Auth:        "none",
+			Receive:     &ReceiveSpec{Method: "POST", From: "from", Text: "text"},
+			Send:        &SendSpec{URL: "https://acme.com/send", Format: "form", Params: map[string]string{"text": "{{text}}"}},
+		}
+	}
+
+	assert.NoError(t, valid().Validate())
+
+	tcs := []struct {
+		mutate func(*Spec)
+		err    string
+	}{
+		{func(s *Spec) { s.Package = "Acme" }, "package must be a lowercase Go package name"},
+		{func(s *Spec) { s.ChannelType = "acme" }, "channel_type must be 1-3 uppercase characters"},
+		{func(s *Spec) { s.Auth = "oauth" }, "unsupported auth type 'oauth'"},
+		{func(s *Spec) { s.Receive = nil }, "receive is required"},
+		{func(s *Spec) { s.Receive.Method = "PUT" }, "receive: unsupported method 'PUT'"},
+		{func(s *Spec) { s.Send.URL = "acme.com" }, "send: url must be an absolute URL"},
+		{func(s *Spec) { s.Send.Format = "xml" }, "send: unsupported format 'xml'"},
+		{func(s *Spec) { s.Send.Params["to"] = "{{phone}}" }, "send: param 'to' has invalid placeholder '{{phone}}'"},
+		{func(s *Spec) { s.Send.Params = map[string]string{"to": "{{to}}"} }, "send: one param must be {{text}}"},
+		{func(s *Spec) {
+			s.Status = &StatusSpec{Method: "POST", ID: "id", Status: "status", Statuses: map[string]string{"OK": "done"}}
+		}, "status: 'OK' maps to unknown status 'done'"},
+	}
+
+	for _, tc := range tcs {
+		s := valid()
+		tc.mutate(s)
+		assert.EqualError(t, s.Validate(), tc.err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	outDir := t.TempDir()
+
+	require.NoError(t, run("testdata/bearer_json.json", outDir, false))
+	assert.FileExists(t, filepath.Join(outDir, "zzacmejson", "handler.go"))
+	assert.FileExists(t, filepath.Join(outDir, "zzacmejson", "handler_test.go"))
+
+	// won't overwrite existing files unless forced
+	assert.ErrorContains(t, run("testdata/bearer_json.json", outDir, false), "already exists")
+	assert.NoError(t, run("testdata/bearer_json.json", outDir, true))
+
+	_, err := os.Stat(filepath.Join(outDir, "zzacme"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,67 @@
+package main
+
+/*
+Scaffolds a new handler package for a webhook based channel type from a declarative spec (see Spec), e.g.
+
+	go run ./cmd/scaffold -spec acme.json
+
+This writes handlers/acme/handler.go and handlers/acme/handler_test.go which should pass as generated and are then
+edited as needed like any other handler. The package still needs to be imported in cmd/courier/main.go.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	specPath := flag.String("spec", "", "path of the JSON spec file")
+	outDir := flag.String("out", "handlers", "directory in which to create the handler package")
+	force := flag.Bool("force", false, "overwrite existing files")
+	flag.Parse()
+
+	if *specPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(*specPath, *outDir, *force); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(specPath, outDir string, force bool) error {
+	spec, err := ReadSpec(specPath)
+	if err != nil {
+		return err
+	}
+
+	files, err := Generate(spec)
+	if err != nil {
+		return err
+	}
+
+	pkgDir := filepath.Join(outDir, spec.Package)
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		return fmt.Errorf("error creating package directory: %w", err)
+	}
+
+	for name, src := range files {
+		path := filepath.Join(pkgDir, name)
+
+		if _, err := os.Stat(path); err == nil && !force {
+			return fmt.Errorf("%s already exists, use -force to overwrite", path)
+		}
+		if err := os.WriteFile(path, src, 0644); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+
+		fmt.Println("wrote", path)
+	}
+
+	fmt.Printf("add _ \"github.com/nyaruka/courier/handlers/%s\" to the handler imports in cmd/courier/main.go\n", spec.Package)
+	return nil
+}
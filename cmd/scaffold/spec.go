@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Spec is the declarative description of a webhook based channel type from which a handler package is generated, e.g.
+//
+//	{
+//	  "package": "acme",
+//	  "channel_type": "AC",
+//	  "name": "Acme SMS",
+//	  "auth": "basic",
+//	  "max_msg_length": 160,
+//	  "receive": {"method": "POST", "from": "msisdn", "text": "message", "id": "message_id"},
+//	  "send": {
+//	    "url": "https://api.acme.com/v1/send",
+//	    "format": "json",
+//	    "params": {"to": "{{to}}", "from": "{{from}}", "text": "{{text}}", "ref": "{{id}}"},
+//	    "response_id": "data.id"
+//	  },
+//	  "status": {"method": "POST", "id": "message_id", "status": "state", "statuses": {"DELIVRD": "delivered", "UNDELIV": "failed"}}
+//	}
+type Spec struct {
+	Package      string       `json:"package"`
+	ChannelType  string       `json:"channel_type"`
+	Name         string       `json:"name"`
+	Auth         string       `json:"auth"`
+	MaxMsgLength int          `json:"max_msg_length"`
+	Receive      *ReceiveSpec `json:"receive"`
+	Send         *SendSpec    `json:"send"`
+	Status       *StatusSpec  `json:"status"`
+}
+
+// ReceiveSpec maps the form fields of incoming message requests
+type ReceiveSpec struct {
+	Method string `json:"method"`
+	From   string `json:"from"`
+	Text   string `json:"text"`
+	ID     string `json:"id"`
+}
+
+// SendSpec describes the request made to send a message. Param values are either literals or one of the placeholders
+// {{to}}, {{to_e164}}, {{from}}, {{text}}, {{id}} or {{config:<key>}}.
+type SendSpec struct {
+	URL        string            `json:"url"`
+	Format     string            `json:"format"`
+	Params     map[string]string `json:"params"`
+	ResponseID string            `json:"response_id"`
+}
+
+// StatusSpec maps the form fields of status callbacks and the provider's status values to courier statuses
+type StatusSpec struct {
+	Method   string            `json:"method"`
+	ID       string            `json:"id"`
+	Status   string            `json:"status"`
+	Statuses map[string]string `json:"statuses"`
+}
+
+const (
+	authNone   = "none"
+	authBasic  = "basic"
+	authBearer = "bearer"
+
+	formatForm = "form"
+	formatJSON = "json"
+)
+
+var (
+	packageRegex     = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	channelTypeRegex = regexp.MustCompile(`^[A-Z0-9]{1,3}$`)
+	placeholderRegex = regexp.MustCompile(`^\{\{(to|to_e164|from|text|id|config:[a-z0-9_]+)\}\}$`)
+
+	statusNames = map[string]string{
+		"wired":     "courier.MsgStatusWired",
+		"sent":      "courier.MsgStatusSent",
+		"delivered": "courier.MsgStatusDelivered",
+		"read":      "courier.MsgStatusRead",
+		"errored":   "courier.MsgStatusErrored",
+		"failed":    "courier.MsgStatusFailed",
+	}
+)
+
+// ReadSpec reads and validates the spec in the given file
+func ReadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec: %w", err)
+	}
+
+	s := &Spec{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error parsing spec: %w", err)
+	}
+
+	s.setDefaults()
+
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Spec) setDefaults() {
+	if s.Auth == "" {
+		s.Auth = authNone
+	}
+	if s.MaxMsgLength == 0 {
+		s.MaxMsgLength = 160
+	}
+	if s.Receive != nil && s.Receive.Method == "" {
+		s.Receive.Method = "POST"
+	}
+	if s.Send != nil && s.Send.Format == "" {
+		s.Send.Format = formatForm
+	}
+	if s.Status != nil && s.Status.Method == "" {
+		s.Status.Method = "POST"
+	}
+}
+
+// Validate checks that the spec describes a handler we can generate
+func (s *Spec) Validate() error {
+	if !packageRegex.MatchString(s.Package) {
+		return errors.New("package must be a lowercase Go package name")
+	}
+	if !channelTypeRegex.MatchString(s.ChannelType) {
+		return errors.New("channel_type must be 1-3 uppercase characters")
+	}
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if !slices.Contains([]string{authNone, authBasic, authBearer}, s.Auth) {
+		return fmt.Errorf("unsupported auth type '%s'", s.Auth)
+	}
+	if s.MaxMsgLength < 0 {
+		return errors.New("max_msg_length must be positive")
+	}
+
+	if s.Receive == nil {
+		return errors.New("receive is required")
+	}
+	if err := validateMethod(s.Receive.Method); err != nil {
+		return fmt.Errorf("receive: %w", err)
+	}
+	if s.Receive.From == "" || s.Receive.Text == "" {
+		return errors.New("receive: from and text fields are required")
+	}
+
+	if s.Send == nil {
+		return errors.New("send is required")
+	}
+	if !strings.HasPrefix(s.Send.URL, "https://") && !strings.HasPrefix(s.Send.URL, "http://") {
+		return errors.New("send: url must be an absolute URL")
+	}
+	if !slices.Contains([]string{formatForm, formatJSON}, s.Send.Format) {
+		return fmt.Errorf("send: unsupported format '%s'", s.Send.Format)
+	}
+	hasText := false
+	for name, value := range s.Send.Params {
+		if strings.Contains(value, "{{") && !placeholderRegex.MatchString(value) {
+			return fmt.Errorf("send: param '%s' has invalid placeholder '%s'", name, value)
+		}
+		hasText = hasText || value == "{{text}}"
+	}
+	if !hasText {
+		return errors.New("send: one param must be {{text}}")
+	}
+
+	if s.Status != nil {
+		if err := validateMethod(s.Status.Method); err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		if s.Status.ID == "" || s.Status.Status == "" {
+			return errors.New("status: id and status fields are required")
+		}
+		if len(s.Status.Statuses) == 0 {
+			return errors.New("status: statuses must not be empty")
+		}
+		for value, status := range s.Status.Statuses {
+			if _, ok := statusNames[status]; !ok {
+				return fmt.Errorf("status: '%s' maps to unknown status '%s'", value, status)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateMethod(m string) error {
+	if m != "GET" && m != "POST" {
+		return fmt.Errorf("unsupported method '%s'", m)
+	}
+	return nil
+}
@@ -2,12 +2,18 @@ package courier
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/nyaruka/courier/utils"
@@ -17,7 +23,7 @@ import (
 
 // Config is our top level configuration object
 type Config struct {
-	Backend   string `help:"the backend that will be used by courier (currently only rapidpro is supported)"`
+	Backend   string `help:"the backend that will be used by courier: rapidpro or standalone"`
 	SentryDSN string `help:"the DSN used for logging errors to Sentry"`
 	Domain    string `help:"the domain courier is exposed on"`
 	Address   string `help:"the network interface address courier will bind to"`
@@ -26,6 +32,8 @@ type Config struct {
 	Redis     string `validate:"url,startswith=redis:"      help:"URL for your Redis instance"`
 	SpoolDir  string `help:"the local directory where courier will write statuses or msgs that need to be retried (needs to be writable)"`
 
+	RedisCluster bool `help:"whether Redis is a cluster, in which case its URL can be that of any node and mailroom must use the same hash tagged keys"`
+
 	AWSAccessKeyID     string `help:"access key ID to use for AWS services"`
 	AWSSecretAccessKey string `help:"secret access key to use for AWS services"`
 	AWSRegion          string `help:"region to use for AWS services, e.g. us-east-1"`
@@ -37,24 +45,76 @@ type Config struct {
 	DynamoEndpoint    string `help:"DynamoDB service endpoint, e.g. https://dynamodb.us-east-1.amazonaws.com"`
 	DynamoTablePrefix string `help:"prefix to use for DynamoDB tables"`
 
-	S3Endpoint          string `help:"S3 service endpoint, e.g. https://s3.amazonaws.com"`
-	S3AttachmentsBucket string `help:"S3 bucket to write attachments to"`
-	S3Minio             bool   `help:"S3 is actually Minio or other compatible service"`
+	S3Endpoint            string `help:"S3 service endpoint, e.g. https://s3.amazonaws.com"`
+	S3AttachmentsBucket   string `help:"S3 bucket, Azure container or GCS bucket to write attachments to"`
+	S3DeactivationsBucket string `help:"S3 bucket, Azure container or GCS bucket to read daily carrier deactivated number files from (leave empty to disable)"`
+	S3Minio               bool   `help:"S3 is actually Minio or other compatible service"`
+
+	StorageType          string `validate:"omitempty,oneof=s3 azure gcs" help:"the storage service attachments and deactivation files are kept in: s3, azure or gcs (buckets must allow public reads)"`
+	AzureStorageAccount  string `help:"the name of the Azure storage account when using azure storage"`
+	AzureStorageKey      string `help:"the base64 encoded access key of the Azure storage account"`
+	AzureStorageEndpoint string `help:"Azure Blob Storage service endpoint (leave empty to use that of the account)"`
+	GCSCredentials       string `help:"JSON of the GCS service account credentials when using gcs storage (leave empty to use the default credentials of the environment)"`
+	GCSEndpoint          string `help:"GCS service endpoint (leave empty to use https://storage.googleapis.com)"`
+
+	SecondaryBackend string `help:"a backend which is also sent copies of incoming messages and statuses on a best-effort basis (leave empty to disable)"`
+
+	StandaloneChannels string `help:"the path of the JSON file of channels used by the standalone backend"`
+	StandaloneWebhook  string `help:"the URL the standalone backend POSTs incoming messages, statuses and channel events to"`
+
+	RelayURL    string `validate:"omitempty,url" help:"the URL incoming messages and statuses of all channels are also POSTed to (leave empty to disable)"`
+	RelaySecret string `help:"the secret used to sign requests to the relay URL (leave empty to not sign requests)"`
+
+	StreamURL                string `validate:"omitempty,url" help:"the URL of a NATS server (nats://) or Kafka brokers (kafka://host1:9092,host2:9092) incoming messages, statuses and channel events are published to (leave empty to disable)"`
+	StreamMsgsTopic          string `help:"the topic incoming messages are published to"`
+	StreamStatusesTopic      string `help:"the topic status updates are published to"`
+	StreamChannelEventsTopic string `help:"the topic channel events are published to"`
+
+	GRPCAddress    string `help:"the address the gRPC API for queueing messages listens on, e.g. :8090 (leave empty to disable)"`
+	GRPCAuthTokens string `help:"comma separated tokens which clients of the gRPC API can authenticate with"`
+	GRPCCertFile   string `help:"the path of the TLS certificate of the gRPC API (leave empty to not use TLS)"`
+	GRPCKeyFile    string `help:"the path of the key of the TLS certificate of the gRPC API"`
+	GRPCClientCA   string `help:"the path of the CA certificate used to verify the client certificates of gRPC API clients (leave empty to not use mTLS)"`
+
+	AttachmentFetchWorkers int    `help:"the maximum number of attachments of a single request that will be fetched at the same time"`
+	AttachmentFetchTimeout int    `help:"the timeout in seconds for fetching a single attachment"`
+	AttachmentMaxSize      int    `help:"the maximum size in bytes of attachments we'll fetch, larger attachments are treated as unavailable"`
+	AttachmentAllowedTypes string `help:"comma separated list of content types of attachments we'll fetch, e.g. image/*,audio/mpeg (leave empty to allow all)"`
+	AttachmentScanAddress  string `help:"the address of a clamd instance which attachments are scanned with before being stored, e.g. localhost:3310 (leave empty to disable)"`
+	AttachmentScanTimeout  int    `help:"the timeout in seconds for scanning a single attachment"`
+	AudioTranscoder        string `help:"the path of an ffmpeg binary used to transcode outgoing audio to codecs channels support (leave empty to disable)"`
+	AudioTranscodeTimeout  int    `help:"the timeout in seconds for transcoding a single audio attachment"`
 
+	FacebookApplicationID        string `help:"the Facebook app ID, used to refresh expiring page access tokens"`
 	FacebookApplicationSecret    string `help:"the Facebook app secret"`
 	FacebookWebhookSecret        string `help:"the secret for Facebook webhook URL verification"`
-	WhatsappAdminSystemUserToken string `help:"the token of the admin system user for WhatsApp"`
-
-	DisallowedNetworks string     `help:"comma separated list of IP addresses and networks which we disallow fetching attachments from"`
-	MediaDomain        string     `help:"the domain on which we'll try to resolve outgoing media URLs"`
-	MaxWorkers         int        `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
-	LibratoUsername    string     `help:"the username that will be used to authenticate to Librato"`
-	LibratoToken       string     `help:"the token that will be used to authenticate to Librato"`
-	StatusUsername     string     `help:"the username that is needed to authenticate against the /status endpoint"`
-	StatusPassword     string     `help:"the password that is needed to authenticate against the /status endpoint"`
-	AuthToken          string     `help:"the authentication token need to access non-channel endpoints"`
-	LogLevel           slog.Level `help:"the logging level courier should use"`
-	Version            string     `help:"the version that will be used in request and response headers"`
+	WhatsappAdminSystemUserToken string `help:"the token of the admin system user for WhatsApp, used by channels without their own auth_token"`
+	MetaGraphVersion             string `help:"the version of the Meta Graph API to use, e.g. v23.0, which channels can override with their graph_version config"`
+
+	ChannelDefaults       string     `help:"JSON object of default config values by channel type, which channels inherit unless they override them"`
+	DisallowedNetworks    string     `help:"comma separated list of IP addresses and networks which we disallow fetching attachments from"`
+	DNSCacheMaxTTL        int        `help:"the maximum number of seconds to cache DNS lookups for outgoing requests, records with shorter TTLs are cached for less (set to 0 to disable)"`
+	WarmupHosts           string     `help:"comma separated list of provider hosts to open connections to at startup and keep warm while in use, e.g. graph.facebook.com,api.twilio.com"`
+	SchemaDriftTypes      string     `help:"comma separated list of channel types whose webhook payloads are checked for fields we don't parse, e.g. WAC,TG (leave empty to disable)"`
+	SchemaDriftSampleRate float64    `help:"the fraction of webhook requests of those channel types which are checked, from 0 to 1"`
+	MediaDomain           string     `help:"the domain on which we'll try to resolve outgoing media URLs"`
+	MaxWorkers            int        `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
+	QualityInterval       int        `help:"the interval in seconds at which channels are checked for provider quality changes, e.g. WhatsApp (set to 0 to disable)"`
+	DeactivationsInterval int        `help:"the interval in seconds at which active channels are checked for new carrier deactivated numbers (set to 0 to disable)"`
+	CircuitErrorRate      int        `help:"the percentage of a channel's sends failing with connection errors or 5xx responses which pauses its sends (set to 0 to disable)"`
+	CircuitMinSends       int        `help:"the minimum number of sends by a channel within the window before its sends can be paused"`
+	CircuitWindow         int        `help:"the window in seconds over which the error rate of a channel's sends is calculated"`
+	CircuitCooldown       int        `help:"the number of seconds a channel's sends are paused for before one is tried to see if its provider has recovered"`
+	ShortLinks            bool       `help:"whether SMS channels send short links on our domain in place of the URLs of attachments"`
+	ShortLinkTTL          int        `help:"the number of days short links to attachments keep working for"`
+	ShutdownTimeout       int        `help:"the maximum number of seconds to wait for buffered status updates and logs to be written when stopping"`
+	LibratoUsername       string     `help:"the username that will be used to authenticate to Librato"`
+	LibratoToken          string     `help:"the token that will be used to authenticate to Librato"`
+	StatusUsername        string     `help:"the username that is needed to authenticate against the /status endpoint"`
+	StatusPassword        string     `help:"the password that is needed to authenticate against the /status endpoint"`
+	AuthToken             string     `help:"the authentication token need to access non-channel endpoints"`
+	LogLevel              slog.Level `help:"the logging level courier should use"`
+	Version               string     `help:"the version that will be used in request and response headers"`
 
 	// IncludeChannels is the list of channels to enable, empty means include all
 	IncludeChannels []string
@@ -90,17 +150,49 @@ func NewDefaultConfig() *Config {
 		S3AttachmentsBucket: "temba-attachments",
 		S3Minio:             false,
 
+		StorageType: "s3",
+
+		AttachmentFetchWorkers: 4,
+		AttachmentFetchTimeout: 30,
+		AttachmentMaxSize:      100 * 1024 * 1024,
+		AttachmentScanTimeout:  30,
+		AudioTranscodeTimeout:  60,
+
+		StreamMsgsTopic:          "courier.msgs",
+		StreamStatusesTopic:      "courier.statuses",
+		StreamChannelEventsTopic: "courier.channel_events",
+
 		FacebookApplicationSecret:    "missing_facebook_app_secret",
 		FacebookWebhookSecret:        "missing_facebook_webhook_secret",
 		WhatsappAdminSystemUserToken: "missing_whatsapp_admin_system_user_token",
+		MetaGraphVersion:             "v23.0",
 
-		DisallowedNetworks: `127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,fe80::/10`,
-		MaxWorkers:         32,
-		LogLevel:           slog.LevelWarn,
-		Version:            "Dev",
+		ChannelDefaults:       `{}`,
+		DisallowedNetworks:    `127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,fe80::/10`,
+		DNSCacheMaxTTL:        300,
+		SchemaDriftSampleRate: 0.1,
+		MaxWorkers:            32,
+		QualityInterval:       0,
+		DeactivationsInterval: 0,
+		CircuitErrorRate:      0,
+		CircuitMinSends:       20,
+		CircuitWindow:         60,
+		CircuitCooldown:       30,
+		ShortLinkTTL:          30,
+		ShutdownTimeout:       30,
+		LogLevel:              slog.LevelWarn,
+		Version:               "Dev",
 	}
 }
 
+// ReadConfig reads our config from the config file, environment variables and command line parameters like LoadConfig,
+// but returns any error rather than exiting, and doesn't validate the config.
+func ReadConfig() (*Config, error) {
+	config := NewDefaultConfig()
+	loader := ezconf.NewLoader(config, "courier", "Courier - A fast message broker for SMS and IP messages", []string{"config.toml"})
+	return config, loader.Load()
+}
+
 func LoadConfig() *Config {
 	config := NewDefaultConfig()
 	loader := ezconf.NewLoader(config, "courier", "Courier - A fast message broker for SMS and IP messages", []string{"config.toml"})
@@ -111,6 +203,11 @@ func LoadConfig() *Config {
 		log.Fatalf("invalid config: %s", err)
 	}
 
+	// but only warn about invalid combinations of settings so that existing deployments can still start
+	if err := config.ValidateCombinations(); err != nil {
+		slog.Warn("invalid combination of config settings", "error", err)
+	}
+
 	return config
 }
 
@@ -123,9 +220,128 @@ func (c *Config) Validate() error {
 	if _, _, err := c.ParseDisallowedNetworks(); err != nil {
 		return fmt.Errorf("unable to parse 'DisallowedNetworks': %w", err)
 	}
+	if _, err := c.ParseChannelDefaults(); err != nil {
+		return fmt.Errorf("unable to parse 'ChannelDefaults': %w", err)
+	}
+
+	if c.StreamURL != "" && !strings.HasPrefix(c.StreamURL, "nats://") && !strings.HasPrefix(c.StreamURL, "kafka://") {
+		return errors.New("'StreamURL' must be a nats:// or kafka:// URL")
+	}
+	if c.ShutdownTimeout < 1 {
+		return errors.New("'ShutdownTimeout' must be at least 1")
+	}
+	if c.AttachmentFetchWorkers < 1 {
+		return errors.New("'AttachmentFetchWorkers' must be at least 1")
+	}
 	return nil
 }
 
+// ValidateCombinations validates settings which are valid on their own but not in combination with others, returning
+// all the problems found. Existing deployments may have such combinations so these are only warned about at startup.
+func (c *Config) ValidateCombinations() error {
+	var errs []error
+	check := func(invalid bool, msg string) {
+		if invalid {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+
+	check(c.S3Minio && (c.S3Endpoint == "" || strings.Contains(c.S3Endpoint, "amazonaws.com")), "'S3Minio' requires 'S3Endpoint' to be set to the Minio endpoint")
+	check((c.AWSAccessKeyID == "") != (c.AWSSecretAccessKey == ""), "'AWSAccessKeyID' and 'AWSSecretAccessKey' must be set together")
+	check((c.Backend == "standalone" || c.SecondaryBackend == "standalone") && (c.StandaloneChannels == "" || c.StandaloneWebhook == ""), "'StandaloneChannels' and 'StandaloneWebhook' must be set when using the standalone backend")
+	check(c.SecondaryBackend != "" && strings.EqualFold(c.SecondaryBackend, c.Backend), "'SecondaryBackend' must be a different backend to 'Backend'")
+	check(c.StreamURL != "" && (c.StreamMsgsTopic == "" || c.StreamStatusesTopic == "" || c.StreamChannelEventsTopic == ""), "'StreamMsgsTopic', 'StreamStatusesTopic' and 'StreamChannelEventsTopic' must be set when 'StreamURL' is set")
+	check(c.GRPCAddress != "" && c.GRPCAuthTokens == "" && c.GRPCClientCA == "", "'GRPCAuthTokens' or 'GRPCClientCA' must be set when 'GRPCAddress' is set")
+	check((c.GRPCCertFile == "") != (c.GRPCKeyFile == ""), "'GRPCCertFile' and 'GRPCKeyFile' must be set together")
+	check(c.GRPCClientCA != "" && c.GRPCCertFile == "", "'GRPCClientCA' requires 'GRPCCertFile' and 'GRPCKeyFile' to be set")
+	check(c.StorageType == "azure" && (c.AzureStorageAccount == "" || c.AzureStorageKey == ""), "'AzureStorageAccount' and 'AzureStorageKey' must be set when 'StorageType' is azure")
+	check((c.StatusUsername == "") != (c.StatusPassword == ""), "'StatusUsername' and 'StatusPassword' must be set together")
+	check((c.LibratoUsername == "") != (c.LibratoToken == ""), "'LibratoUsername' and 'LibratoToken' must be set together")
+	check(c.AttachmentScanAddress != "" && c.AttachmentScanTimeout <= 0, "'AttachmentScanTimeout' must be positive when 'AttachmentScanAddress' is set")
+	check(c.AudioTranscoder != "" && c.AudioTranscodeTimeout <= 0, "'AudioTranscodeTimeout' must be positive when 'AudioTranscoder' is set")
+	check(c.SchemaDriftTypes != "" && (c.SchemaDriftSampleRate <= 0 || c.SchemaDriftSampleRate > 1), "'SchemaDriftSampleRate' must be greater than 0 and at most 1 when 'SchemaDriftTypes' is set")
+
+	return errors.Join(errs...)
+}
+
+// settings which are replaced when dumping a redacted config, other than URLs which only have their passwords redacted
+var secretSettings = map[string]bool{
+	"SentryDSN":                    true,
+	"AWSSecretAccessKey":           true,
+	"AzureStorageKey":              true,
+	"GCSCredentials":               true,
+	"FacebookApplicationSecret":    true,
+	"FacebookWebhookSecret":        true,
+	"WhatsappAdminSystemUserToken": true,
+	"LibratoToken":                 true,
+	"StatusPassword":               true,
+	"AuthToken":                    true,
+	"RelaySecret":                  true,
+	"GRPCAuthTokens":               true,
+}
+
+// UnknownEnvVars returns any of the given environment variables (as KEY=value) which start with COURIER_ but don't
+// match any setting. These are ignored when loading the config so are usually typos.
+func UnknownEnvVars(environ []string) []string {
+	known := make(map[string]bool)
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(Config{})) {
+		known["COURIER_"+strings.ToUpper(ezconf.CamelToSnake(f.Name))] = true
+	}
+
+	unknown := make([]string, 0)
+	for _, kv := range environ {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "COURIER_") && !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// Dump writes the config as TOML, which is the effective config after merging the config file, environment variables
+// and command line parameters. If redact is true, secrets and URL passwords are masked.
+func (c *Config) Dump(w io.Writer, redact bool) error {
+	v := reflect.ValueOf(c).Elem()
+
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		value := v.FieldByIndex(f.Index).Interface()
+
+		if redact {
+			if s, ok := value.(string); ok && s != "" {
+				if secretSettings[f.Name] {
+					value = "**********"
+				} else if u, err := url.Parse(s); err == nil && u.User != nil {
+					value = u.Redacted()
+				}
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s = %s\n", ezconf.CamelToSnake(f.Name), tomlValue(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formats a config value as a TOML value
+func tomlValue(v any) string {
+	switch typed := v.(type) {
+	case string:
+		return strconv.Quote(typed)
+	case slog.Level:
+		return strconv.Quote(typed.String())
+	case []string:
+		quoted := make([]string, len(typed))
+		for i, s := range typed {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // ParseDisallowedNetworks parses the list of IPs and IP networks (written in CIDR notation)
 func (c *Config) ParseDisallowedNetworks() ([]net.IP, []*net.IPNet, error) {
 	addrs, err := csv.NewReader(strings.NewReader(c.DisallowedNetworks)).Read()
@@ -135,3 +351,54 @@ func (c *Config) ParseDisallowedNetworks() ([]net.IP, []*net.IPNet, error) {
 
 	return httpx.ParseNetworks(addrs...)
 }
+
+// DeactivationsEnabled returns whether deactivated numbers are being fetched or imported and so need checking on send
+func (c *Config) DeactivationsEnabled() bool {
+	return c.DeactivationsInterval > 0 || c.S3DeactivationsBucket != ""
+}
+
+// ParseWarmupHosts parses the list of hosts to keep warm connections to
+func (c *Config) ParseWarmupHosts() []string {
+	hosts := make([]string, 0, 4)
+	for _, h := range strings.Split(c.WarmupHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// ParseSchemaDriftTypes parses the list of channel types whose webhook payloads are checked for unknown fields
+func (c *Config) ParseSchemaDriftTypes() []ChannelType {
+	types := make([]ChannelType, 0, 4)
+	for _, t := range strings.Split(c.SchemaDriftTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, ChannelType(t))
+		}
+	}
+	return types
+}
+
+// ParseAttachmentAllowedTypes parses the list of content types of attachments we'll fetch
+func (c *Config) ParseAttachmentAllowedTypes() []string {
+	types := make([]string, 0, 4)
+	for _, t := range strings.Split(c.AttachmentAllowedTypes, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// ParseChannelDefaults parses the default config values by channel type, e.g. {"T": {"callback_domain": "example.com"}}
+func (c *Config) ParseChannelDefaults() (map[ChannelType]map[string]any, error) {
+	defaults := make(map[ChannelType]map[string]any)
+	if c.ChannelDefaults == "" {
+		return defaults, nil
+	}
+
+	if err := json.Unmarshal([]byte(c.ChannelDefaults), &defaults); err != nil {
+		return nil, err
+	}
+	return defaults, nil
+}
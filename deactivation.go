@@ -0,0 +1,221 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/redisx"
+)
+
+// deactivated numbers are remembered for 90 days after which carriers may have reassigned them to someone else. They're
+// scoped to the channel they were reported for because a number which one carrier has reassigned may still be valid
+// for another carrier's or org's channel.
+func deactivatedNumbers(ch ChannelUUID) *redisx.IntervalSet {
+	return redisx.NewIntervalSet(valkey.Tag(fmt.Sprintf("deactivated-numbers:%s", ch)), time.Hour*24, 90)
+}
+
+// ErrURNDeactivated is returned when the destination number has been reported as deactivated by its carrier
+var ErrURNDeactivated error = &SendError{
+	msg:       "urn deactivated",
+	retryable: false,
+	loggable:  false,
+	clogCode:  "urn_deactivated",
+	clogMsg:   "Destination number has been deactivated or ported out by its carrier.",
+}
+
+// RecordDeactivatedNumbers records the given phone numbers as deactivated for the given channel so that sends to them
+// from it are prevented. Numbers without a + prefix are assumed to be North American as that's what carrier
+// deactivation files contain.
+func RecordDeactivatedNumbers(rc redis.Conn, ch ChannelUUID, numbers []string) (int, error) {
+	recorded := 0
+
+	for _, n := range numbers {
+		number := normalizeDeactivatedNumber(n)
+		if number == "" {
+			continue
+		}
+		if err := deactivatedNumbers(ch).Add(rc, number); err != nil {
+			return recorded, fmt.Errorf("error recording deactivated number: %w", err)
+		}
+		recorded++
+	}
+
+	return recorded, nil
+}
+
+func normalizeDeactivatedNumber(n string) string {
+	n = strings.TrimSpace(n)
+	if n == "" {
+		return ""
+	}
+	for _, c := range strings.TrimPrefix(n, "+") {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	if strings.HasPrefix(n, "+") {
+		return n
+	}
+	if len(n) == 10 {
+		return "+1" + n
+	}
+	return "+" + n
+}
+
+// checks whether the destination of the given message has been deactivated, and if so writes a channel event so that
+// the contact can be updated and returns an error so that the message isn't sent
+func checkDeactivated(ctx context.Context, b Backend, msg MsgOut, clog *ChannelLog) error {
+	if msg.URN().Scheme() != urns.Phone.Prefix {
+		return nil
+	}
+
+	rc := b.RedisPool().Get()
+	defer rc.Close()
+
+	deactivated, err := deactivatedNumbers(msg.Channel().UUID()).IsMember(rc, msg.URN().Path())
+	if err != nil || !deactivated {
+		return nil // cache errors aren't fatal, just means we might send to a dead number
+	}
+
+	event := b.NewChannelEvent(msg.Channel(), EventTypeURNDeactivated, msg.URN(), clog)
+	if err := b.WriteChannelEvent(ctx, event, clog); err != nil {
+		slog.Error("error writing urn deactivated event", "error", err, "channel_uuid", msg.Channel().UUID())
+	}
+
+	return ErrURNDeactivated
+}
+
+// deactivationPoller periodically fetches yesterday's deactivated numbers for channels whose handlers implement
+// DeactivationFetcher. If the backend can list channels, all active channels of those types are fetched, otherwise
+// only those which have been active since its last check. Each channel is only fetched once per day.
+type deactivationPoller struct {
+	server   Server
+	lister   ChannelLister // nil if backend can't list channels
+	types    []ChannelType
+	interval time.Duration
+
+	mutex    sync.Mutex
+	channels map[ChannelUUID]Channel
+}
+
+func newDeactivationPoller(s Server, lister ChannelLister, types []ChannelType, interval time.Duration) *deactivationPoller {
+	return &deactivationPoller{server: s, lister: lister, types: types, interval: interval, channels: make(map[ChannelUUID]Channel)}
+}
+
+// Track records the given channel as active so that it will be included in the next check
+func (p *deactivationPoller) Track(ch Channel) {
+	if _, isFetcher := p.server.GetHandler(ch).(DeactivationFetcher); !isFetcher {
+		return
+	}
+
+	p.mutex.Lock()
+	p.channels[ch.UUID()] = ch
+	p.mutex.Unlock()
+}
+
+// Start starts a goroutine which checks tracked channels every interval until the server is stopped
+func (p *deactivationPoller) Start() {
+	p.server.WaitGroup().Add(1)
+
+	go func() {
+		defer p.server.WaitGroup().Done()
+
+		for {
+			select {
+			case <-p.server.StopChan():
+				return
+			case <-time.After(p.interval):
+				p.check(context.Background(), time.Now().UTC())
+			}
+		}
+	}()
+}
+
+func (p *deactivationPoller) check(ctx context.Context, now time.Time) {
+	p.mutex.Lock()
+	channels := p.channels
+	p.channels = make(map[ChannelUUID]Channel)
+	p.mutex.Unlock()
+
+	// include channels which only send and so are never tracked
+	if p.lister != nil {
+		for _, typ := range p.types {
+			listed, err := p.lister.GetChannelsByType(ctx, typ)
+			if err != nil {
+				slog.Error("error loading channels to fetch deactivations for", "comp", "deactivation poller", "channel_type", typ, "error", err)
+				continue
+			}
+			for _, ch := range listed {
+				channels[ch.UUID()] = ch
+			}
+		}
+	}
+
+	// deactivation lists are published for complete days
+	day := now.AddDate(0, 0, -1).Truncate(time.Hour * 24)
+
+	for _, ch := range channels {
+		handler := p.server.GetHandler(ch)
+		fetcher := handler.(DeactivationFetcher)
+		log := slog.With("comp", "deactivation poller", "channel_uuid", ch.UUID(), "day", day.Format(time.DateOnly))
+
+		if !p.claimDay(ch, day) {
+			continue
+		}
+
+		clog := NewChannelLog(ChannelLogTypeDeactivations, ch, handler.RedactValues(ch))
+
+		numbers, err := fetcher.FetchDeactivations(ctx, ch, day, clog)
+		if err != nil {
+			log.Error("error fetching deactivated numbers", "error", err)
+			p.releaseDay(ch, day)
+		} else {
+			rc := p.server.Backend().RedisPool().Get()
+			recorded, err := RecordDeactivatedNumbers(rc, ch.UUID(), numbers)
+			rc.Close()
+
+			if err != nil {
+				log.Error("error recording deactivated numbers", "error", err)
+			} else {
+				log.Info("recorded deactivated numbers", "count", recorded)
+			}
+		}
+
+		clog.End()
+
+		// only bother writing logs for fetches which failed
+		if clog.IsError() {
+			if err := p.server.Backend().WriteChannelLog(ctx, clog); err != nil {
+				log.Error("error writing channel log", "error", err)
+			}
+		}
+	}
+}
+
+func deactivationsFetchedKey(ch Channel, day time.Time) string {
+	return fmt.Sprintf("deactivations-fetched:%s:%s", ch.UUID(), day.Format(time.DateOnly))
+}
+
+// claims the fetching of the given day for the given channel, returning false if it's already been fetched
+func (p *deactivationPoller) claimDay(ch Channel, day time.Time) bool {
+	rc := p.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	reply, err := redis.String(rc.Do("SET", deactivationsFetchedKey(ch, day), "1", "NX", "EX", 60*60*48))
+	return err == nil && reply == "OK"
+}
+
+// releases the claim on the given day so that fetching it will be retried
+func (p *deactivationPoller) releaseDay(ch Channel, day time.Time) {
+	rc := p.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	rc.Do("DEL", deactivationsFetchedKey(ch, day))
+}
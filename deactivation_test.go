@@ -0,0 +1,124 @@
+package courier_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeactivations(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	config := testConfig()
+	config.DeactivationsInterval = 1
+
+	mb := test.NewMockBackend()
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	channel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{
+		"deactivated_numbers": "2065550001,+12065550002,foo",
+	})
+	mb.AddChannel(channel)
+
+	// channel is fetched by the poller even though it hasn't received anything
+	time.Sleep(1500 * time.Millisecond)
+	mb.Reset()
+
+	// message to a deactivated number fails without being sent
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, channel, "tel:+12065550001", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelEvents(), 1)
+	assert.Equal(t, courier.EventTypeURNDeactivated, mb.WrittenChannelEvents()[0].EventType())
+	assert.Equal(t, urns.URN("tel:+12065550001"), mb.WrittenChannelEvents()[0].URN())
+	require.Len(t, mb.WrittenChannelLogs(), 1)
+	assert.Equal(t, "urn_deactivated", mb.WrittenChannelLogs()[0].Errors[0].Code)
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 0)
+	mb.Reset()
+
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, channel, "tel:+12065550002", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	mb.Reset()
+
+	// other numbers are sent to as normal
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(103), courier.NilMsgUUID, channel, "tel:+12065550003", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	assert.Len(t, mb.WrittenChannelEvents(), 0)
+	mb.Reset()
+
+	// numbers can also be recorded directly, e.g. from carrier files
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	count, err := courier.RecordDeactivatedNumbers(rc, channel.UUID(), []string{" 2065550004 ", "", "+447700900123", "12-34"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// but numbers deactivated for other channels don't affect this one
+	_, err = courier.RecordDeactivatedNumbers(rc, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", []string{"2065550005"})
+	assert.NoError(t, err)
+
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(105), courier.NilMsgUUID, channel, "tel:+12065550005", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	mb.Reset()
+
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(104), courier.NilMsgUUID, channel, "tel:+12065550004", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+
+	assert.False(t, mocks.HasUnused())
+}
+
+func TestDeactivationsDisabled(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	mb := test.NewMockBackend()
+	stop := startTestServer(t, testConfig(), mb)
+	defer stop()
+
+	channel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, nil)
+	mb.AddChannel(channel)
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	_, err := courier.RecordDeactivatedNumbers(rc, channel.UUID(), []string{"2065550001"})
+	require.NoError(t, err)
+
+	// with deactivations disabled, numbers aren't checked
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, channel, "tel:+12065550001", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	assert.Len(t, mb.WrittenChannelEvents(), 0)
+
+	assert.False(t, mocks.HasUnused())
+}
@@ -0,0 +1,70 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+)
+
+// DeadLetter is an outgoing message which failed permanently and has been set aside so that it can be requeued
+type DeadLetter struct {
+	MsgID       MsgID         `json:"msg_id"`
+	ChannelUUID ChannelUUID   `json:"channel_uuid"`
+	LogUUID     clogs.LogUUID `json:"log_uuid"`
+	Error       string        `json:"error"`
+	FailedOn    time.Time     `json:"failed_on"`
+}
+
+type deadLettersResponse struct {
+	DeadLetters []*DeadLetter `json:"dead_letters"`
+}
+
+type requeueDeadLettersResponse struct {
+	Requeued int `json:"requeued"`
+}
+
+func (s *server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	letters, err := s.deadLetters.DeadLetters(ctx, channelUUID)
+	if err != nil {
+		slog.Error("error listing dead letters", "error", err, "channel_uuid", channelUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error listing dead letters"))
+		return
+	}
+
+	writeAdminResponse(w, &deadLettersResponse{DeadLetters: letters})
+}
+
+func (s *server) handleRequeueDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	requeued, err := s.deadLetters.RequeueDeadLetters(ctx, channelUUID)
+	if err != nil {
+		slog.Error("error requeuing dead letters", "error", err, "channel_uuid", channelUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error requeuing dead letters"))
+		return
+	}
+
+	slog.Info("requeued dead letters", "channel_uuid", channelUUID, "count", requeued)
+
+	writeAdminResponse(w, &requeueDeadLettersResponse{Requeued: requeued})
+}
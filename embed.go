@@ -0,0 +1,45 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// Embed creates a server for use by other Go services which want to use courier's handlers as a library rather than
+// run the full courier service. Handlers are initialized but the server is never started, so there's no HTTP listener,
+// no queue processing and no background tasks. Messages are sent synchronously with Send, and incoming requests can
+// be handled by mounting the server's Router in the embedding service's own HTTP server.
+//
+// The backend can be any in-process implementation of Backend, and is used by handlers for things like looking up
+// channels and writing incoming messages. It's the responsibility of the caller to start and stop it.
+//
+//	s, err := courier.Embed(config, myBackend, courier.WithHandlers(courier.GetHandler("T")))
+//	res, clog, err := courier.Send(ctx, s, msg)
+func Embed(config *Config, backend Backend, opts ...ServerOption) (Server, error) {
+	s := NewServer(config, backend, opts...).(*server)
+
+	if err := s.initializeChannelHandlers(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Send sends the given message using the handler for its channel type. Unlike messages sent from the queue by a
+// running server, there are no duplicate checks, no number lookups and no statuses or logs written to the backend, so
+// it's up to the caller to act on the returned result, channel log and error.
+func Send(ctx context.Context, s Server, msg MsgOut) (*SendResult, *ChannelLog, error) {
+	handler := s.GetHandler(msg.Channel())
+	if handler == nil {
+		return nil, nil, fmt.Errorf("no handler for channel type: %s", msg.Channel().ChannelType())
+	}
+
+	clog := NewChannelLogForSend(msg, handler.RedactValues(msg.Channel()))
+	res := &SendResult{newURN: urns.NilURN}
+
+	err := handler.Send(ctx, msg, res, clog)
+	clog.End()
+
+	return res, clog, err
+}
@@ -0,0 +1,63 @@
+package courier_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbed(t *testing.T) {
+	ctx := context.Background()
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(429, nil, []byte(`too much!`)),
+		},
+	}))
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	otherChannel := test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	s, err := courier.Embed(testConfig(), mb, courier.WithHandlers(courier.GetHandler("MCK")))
+	require.NoError(t, err)
+
+	msg := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil)
+	res, clog, err := courier.Send(ctx, s, msg)
+	assert.NoError(t, err)
+	assert.NotNil(t, res)
+	assert.Equal(t, courier.ChannelLogTypeMsgSend, clog.Type)
+	assert.Len(t, clog.HttpLogs, 1)
+	assert.Equal(t, "http://mock.com/send", clog.HttpLogs[0].URL)
+
+	// errors from the handler are returned to the caller
+	_, _, err = courier.Send(ctx, s, msg)
+	assert.Equal(t, courier.ErrConnectionThrottled, err)
+
+	// nothing is written to the backend
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+	assert.Len(t, mb.WrittenChannelLogs(), 0)
+
+	// can't send for channel types without a handler
+	msg = test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, otherChannel, "tel:+250788383383", "test message", nil)
+	_, _, err = courier.Send(ctx, s, msg)
+	assert.EqualError(t, err, "no handler for channel type: XX")
+
+	// incoming requests can be handled by mounting the router
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Len(t, mb.WrittenMsgs(), 1)
+}
@@ -0,0 +1,39 @@
+package courier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// the default number of consecutive errored attempts to send a message before it's moved to its channel's fallback
+const defaultFallbackAttempts = 2
+
+// how long we count the errored attempts of a message for, which comfortably covers all of its retries
+const sendErrorsTTL = 24 * time.Hour
+
+func sendErrorsKey(id MsgID) string {
+	return fmt.Sprintf("send-errors:%d", id)
+}
+
+// records an errored attempt to send the given message, returning how many consecutive errored attempts it has now had
+func recordSendError(rc redis.Conn, id MsgID) (int, error) {
+	key := sendErrorsKey(id)
+
+	rc.Send("MULTI")
+	rc.Send("INCR", key)
+	rc.Send("EXPIRE", key, int(sendErrorsTTL/time.Second))
+	values, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return 0, fmt.Errorf("error recording send error: %w", err)
+	}
+
+	return redis.Int(values[0], nil)
+}
+
+// clears the errored attempts of the given message, so that a new channel gets its own attempts
+func clearSendErrors(rc redis.Conn, id MsgID) error {
+	_, err := rc.Do("DEL", sendErrorsKey(id))
+	return err
+}
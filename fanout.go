@@ -0,0 +1,221 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// how many copies can be waiting to be written to the secondary backend before we start dropping them
+const fanoutBufferSize = 1000
+
+// a copy of an incoming message or status update which is waiting to be written to the secondary backend
+type fanoutCopy struct {
+	channelUUID ChannelUUID
+	write       func(context.Context, Backend, Channel) error
+}
+
+// fanoutBackend is a backend which wraps a primary backend, also writing copies of incoming messages and status
+// updates to a secondary backend. Copies are written asynchronously on a best-effort basis so a slow or failing
+// secondary backend never affects the primary.
+type fanoutBackend struct {
+	Backend
+
+	secondary Backend
+	copies    chan *fanoutCopy
+	stopChan  chan bool
+	waitGroup sync.WaitGroup
+	started   bool
+}
+
+// NewFanoutBackend creates a new backend which uses the given primary backend for everything, and also writes copies
+// of incoming messages and status updates to the given secondary backend
+func NewFanoutBackend(primary, secondary Backend) Backend {
+	return &fanoutBackend{
+		Backend:   primary,
+		secondary: secondary,
+		copies:    make(chan *fanoutCopy, fanoutBufferSize),
+		stopChan:  make(chan bool),
+	}
+}
+
+// Unwrap returns the primary backend
+func (b *fanoutBackend) Unwrap() Backend { return b.Backend }
+
+// Start starts the primary backend and then the secondary, which isn't used if it fails to start
+func (b *fanoutBackend) Start() error {
+	if err := b.Backend.Start(); err != nil {
+		return err
+	}
+
+	if err := b.secondary.Start(); err != nil {
+		slog.Error("error starting secondary backend, copies won't be written", "comp", "fanout", "error", err)
+		return nil
+	}
+
+	b.started = true
+	b.waitGroup.Add(1)
+	go b.writeCopies()
+
+	return nil
+}
+
+// Stop stops the primary backend, then writes any pending copies before stopping the secondary
+func (b *fanoutBackend) Stop() error {
+	err := b.Backend.Stop()
+
+	if b.started {
+		close(b.stopChan)
+		b.waitGroup.Wait()
+
+		if err := b.secondary.Stop(); err != nil {
+			slog.Error("error stopping secondary backend", "comp", "fanout", "error", err)
+		}
+	}
+
+	return err
+}
+
+// Flush flushes both backends, though copies which are still waiting to be written to the secondary aren't
+func (b *fanoutBackend) Flush(ctx context.Context) error {
+	err := b.Backend.Flush(ctx)
+
+	if b.started {
+		if err := b.secondary.Flush(ctx); err != nil {
+			slog.Error("error flushing secondary backend", "comp", "fanout", "error", err)
+		}
+	}
+
+	return err
+}
+
+// Cleanup cleans up both backends
+func (b *fanoutBackend) Cleanup() error {
+	if b.started {
+		if err := b.secondary.Cleanup(); err != nil {
+			slog.Error("error cleaning up secondary backend", "comp", "fanout", "error", err)
+		}
+	}
+	return b.Backend.Cleanup()
+}
+
+// WriteMsg writes the given message to the primary backend and if that succeeds, queues a copy for the secondary
+func (b *fanoutBackend) WriteMsg(ctx context.Context, msg MsgIn, clog *ChannelLog) error {
+	if err := b.Backend.WriteMsg(ctx, msg, clog); err != nil {
+		return err
+	}
+
+	urn, text, externalID, attachments, receivedOn := msg.URN(), msg.Text(), msg.ExternalID(), msg.Attachments(), msg.ReceivedOn()
+
+	b.queueCopy(msg.Channel().UUID(), func(ctx context.Context, secondary Backend, ch Channel) error {
+		sclog := NewChannelLog(ChannelLogTypeMsgReceive, ch, nil)
+		msgCopy := secondary.NewIncomingMsg(ch, urn, text, externalID, sclog)
+		for _, a := range attachments {
+			msgCopy.WithAttachment(a)
+		}
+		if receivedOn != nil {
+			msgCopy.WithReceivedOn(*receivedOn)
+		}
+		return secondary.WriteMsg(ctx, msgCopy, sclog)
+	})
+
+	return nil
+}
+
+// WriteStatusUpdate writes the given status update to the primary backend and if that succeeds, queues a copy for
+// the secondary
+func (b *fanoutBackend) WriteStatusUpdate(ctx context.Context, status StatusUpdate) error {
+	if err := b.Backend.WriteStatusUpdate(ctx, status); err != nil {
+		return err
+	}
+
+	msgID, externalID, msgStatus, failedReason := status.MsgID(), status.ExternalID(), status.Status(), status.FailedReason()
+	oldURN, newURN := status.URNUpdate()
+
+	b.queueCopy(status.ChannelUUID(), func(ctx context.Context, secondary Backend, ch Channel) error {
+		sclog := NewChannelLog(ChannelLogTypeMsgStatus, ch, nil)
+
+		var statusCopy StatusUpdate
+		if msgID != NilMsgID {
+			statusCopy = secondary.NewStatusUpdate(ch, msgID, msgStatus, sclog)
+			statusCopy.SetExternalID(externalID)
+		} else {
+			statusCopy = secondary.NewStatusUpdateByExternalID(ch, externalID, msgStatus, sclog)
+		}
+		statusCopy.SetFailedReason(failedReason)
+
+		if oldURN != urns.NilURN && newURN != urns.NilURN {
+			if err := statusCopy.SetURNUpdate(oldURN, newURN); err != nil {
+				return err
+			}
+		}
+		return secondary.WriteStatusUpdate(ctx, statusCopy)
+	})
+
+	return nil
+}
+
+// queues a copy to be written to the secondary backend, dropping it if the buffer is full
+func (b *fanoutBackend) queueCopy(channelUUID ChannelUUID, write func(context.Context, Backend, Channel) error) {
+	if !b.started {
+		return
+	}
+
+	select {
+	case b.copies <- &fanoutCopy{channelUUID: channelUUID, write: write}:
+	default:
+		slog.Warn("secondary backend buffer full, dropping copy", "comp", "fanout", "channel_uuid", channelUUID)
+	}
+}
+
+// writes queued copies to the secondary backend until we're stopped, at which point any remaining copies are written
+func (b *fanoutBackend) writeCopies() {
+	defer b.waitGroup.Done()
+
+	for {
+		select {
+		case c := <-b.copies:
+			b.writeCopy(c)
+		case <-b.stopChan:
+			for {
+				select {
+				case c := <-b.copies:
+					b.writeCopy(c)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writes a single copy to the secondary backend, logging rather than returning any error or panic
+func (b *fanoutBackend) writeCopy(c *fanoutCopy) {
+	log := slog.With("comp", "fanout", "channel_uuid", c.channelUUID)
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic writing copy to secondary backend", "error", fmt.Sprint(r))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// copies are written to the secondary's own version of the channel, which it may not have
+	ch, err := b.secondary.GetChannel(ctx, AnyChannelType, c.channelUUID)
+	if err != nil {
+		if err != ErrChannelNotFound {
+			log.Error("error getting channel from secondary backend", "error", err)
+		}
+		return
+	}
+
+	if err := c.write(ctx, b.secondary, ch); err != nil {
+		log.Error("error writing copy to secondary backend", "error", err)
+	}
+}
@@ -0,0 +1,114 @@
+package courier_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanoutBackend(t *testing.T) {
+	ctx := context.Background()
+
+	primary := test.NewMockBackend()
+	primary.AddChannel(test.NewMockChannel("95710b36-855d-4832-a723-5f71f73688a0", "MCK", "12345", "RW", []string{urns.Phone.Prefix}, nil))
+	primary.AddChannel(test.NewMockChannel("f1a2b3c4-855d-4832-a723-5f71f73688a0", "MCK", "23456", "RW", []string{urns.Phone.Prefix}, nil))
+
+	// secondary only knows about one of the channels
+	secondary := test.NewMockBackend()
+	secondary.AddChannel(test.NewMockChannel("95710b36-855d-4832-a723-5f71f73688a0", "MCK", "12345", "RW", []string{urns.Phone.Prefix}, nil))
+
+	backend := courier.NewFanoutBackend(primary, secondary)
+	require.NoError(t, backend.Start())
+
+	ch1, _ := backend.GetChannel(ctx, "MCK", "95710b36-855d-4832-a723-5f71f73688a0")
+	ch2, _ := backend.GetChannel(ctx, "MCK", "f1a2b3c4-855d-4832-a723-5f71f73688a0")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch1, nil)
+
+	msg := backend.NewIncomingMsg(ch1, "tel:+250788383383", "hello", "ext1", clog).WithAttachment("image/jpeg:https://example.com/1.jpg").WithReceivedOn(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC))
+	assert.NoError(t, backend.WriteMsg(ctx, msg, clog))
+	assert.NoError(t, backend.WriteMsg(ctx, backend.NewIncomingMsg(ch2, "tel:+250788383384", "hola", "ext2", clog), clog))
+
+	status := backend.NewStatusUpdateByExternalID(ch1, "ext3", courier.MsgStatusDelivered, clog)
+	status.SetURNUpdate("tel:+250788383383", "tel:+250788383385")
+	assert.NoError(t, backend.WriteStatusUpdate(ctx, status))
+	assert.NoError(t, backend.WriteStatusUpdate(ctx, backend.NewStatusUpdate(ch1, 123, courier.MsgStatusFailed, clog)))
+
+	// if primary fails, nothing is copied to secondary
+	primary.SetErrorOnQueue(true)
+	assert.EqualError(t, backend.WriteMsg(ctx, backend.NewIncomingMsg(ch1, "tel:+250788383383", "fail", "ext5", clog), clog), "unable to queue message")
+
+	// stopping writes any copies still pending
+	assert.NoError(t, backend.Stop())
+	assert.NoError(t, backend.Cleanup())
+
+	assert.Len(t, primary.WrittenMsgs(), 2)
+	assert.Len(t, primary.WrittenMsgStatuses(), 2)
+
+	if assert.Len(t, secondary.WrittenMsgs(), 1) {
+		copied := secondary.WrittenMsgs()[0]
+		assert.NotSame(t, msg, copied)
+		assert.Equal(t, courier.ChannelUUID("95710b36-855d-4832-a723-5f71f73688a0"), copied.Channel().UUID())
+		assert.Equal(t, urns.URN("tel:+250788383383"), copied.URN())
+		assert.Equal(t, "hello", copied.Text())
+		assert.Equal(t, "ext1", copied.ExternalID())
+		assert.Equal(t, []string{"image/jpeg:https://example.com/1.jpg"}, copied.Attachments())
+		assert.Equal(t, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), *copied.ReceivedOn())
+	}
+
+	if assert.Len(t, secondary.WrittenMsgStatuses(), 2) {
+		s1 := secondary.WrittenMsgStatuses()[0]
+		assert.Equal(t, "ext3", s1.ExternalID())
+		assert.Equal(t, courier.MsgStatusDelivered, s1.Status())
+		oldURN, newURN := s1.URNUpdate()
+		assert.Equal(t, urns.URN("tel:+250788383383"), oldURN)
+		assert.Equal(t, urns.URN("tel:+250788383385"), newURN)
+
+		s2 := secondary.WrittenMsgStatuses()[1]
+		assert.Equal(t, courier.MsgID(123), s2.MsgID())
+		assert.Equal(t, courier.MsgStatusFailed, s2.Status())
+	}
+
+	// if secondary fails, primary is unaffected
+	primary.Reset()
+	primary.SetErrorOnQueue(false)
+	secondary.Reset()
+	secondary.SetErrorOnQueue(true)
+
+	backend = courier.NewFanoutBackend(primary, secondary)
+	require.NoError(t, backend.Start())
+
+	assert.NoError(t, backend.WriteMsg(ctx, backend.NewIncomingMsg(ch1, "tel:+250788383383", "again", "ext4", clog), clog))
+	assert.NoError(t, backend.Stop())
+
+	assert.Len(t, primary.WrittenMsgs(), 1)
+	assert.Len(t, secondary.WrittenMsgs(), 0)
+
+	// optional interfaces of the primary backend can still be found
+	lister, ok := courier.BackendAs[courier.ChannelLister](courier.NewStatusFeedBackend(backend))
+	if assert.True(t, ok) {
+		channels, err := lister.GetChannelsByType(ctx, "MCK")
+		assert.NoError(t, err)
+		assert.Len(t, channels, 2)
+	}
+}
+
+func TestNewBackendWithSecondary(t *testing.T) {
+	config := courier.NewDefaultConfig()
+	config.Backend = "mock"
+	config.SecondaryBackend = "mock"
+
+	backend, err := courier.NewBackend(config)
+	assert.NoError(t, err)
+	assert.NotNil(t, backend)
+
+	config.SecondaryBackend = "foo"
+
+	_, err = courier.NewBackend(config)
+	assert.EqualError(t, err, "no such backend type: 'foo'")
+}
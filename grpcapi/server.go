@@ -0,0 +1,307 @@
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative courier.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the largest request message we accept
+	maxMsgSize = 1024 * 1024
+
+	// how often the status feed of a watched channel is polled, and how many status updates are read each time
+	watchPollInterval = 500 * time.Millisecond
+	watchBatchSize    = 100
+)
+
+// Server is the gRPC API which lets external systems queue outgoing messages and watch their status updates
+type Server struct {
+	UnimplementedCourierServer
+
+	config  *courier.Config
+	backend courier.Backend
+	tokens  [][]byte
+
+	grpcServer *grpc.Server
+	listener   net.Listener
+	stopChan   chan bool
+	waitGroup  sync.WaitGroup
+}
+
+// NewServer creates a new gRPC API server for the given config and backend
+func NewServer(config *courier.Config, backend courier.Backend) *Server {
+	s := &Server{config: config, backend: backend, stopChan: make(chan bool)}
+
+	for _, t := range strings.Split(config.GRPCAuthTokens, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			s.tokens = append(s.tokens, []byte(t))
+		}
+	}
+	return s
+}
+
+// Start starts listening on the configured address
+func (s *Server) Start() error {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxMsgSize),
+		grpc.UnaryInterceptor(s.authenticateUnary),
+		grpc.StreamInterceptor(s.authenticateStream),
+	}
+
+	useTLS := s.config.GRPCCertFile != ""
+	if useTLS {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s.grpcServer = grpc.NewServer(opts...)
+	RegisterCourierServer(s.grpcServer, s)
+
+	listener, err := net.Listen("tcp", s.config.GRPCAddress)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", s.config.GRPCAddress, err)
+	}
+	s.listener = listener
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+
+		if err := s.grpcServer.Serve(listener); err != nil {
+			slog.Error("error serving gRPC API", "comp", "grpc", "error", err)
+		}
+	}()
+
+	slog.Info("gRPC API started", "comp", "grpc", "address", listener.Addr().String(), "tls", useTLS)
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop ends any status watches and stops the server
+func (s *Server) Stop() {
+	close(s.stopChan)
+
+	stopped := make(chan bool)
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		slog.Error("timed out waiting for gRPC API calls to finish", "comp", "grpc")
+		s.grpcServer.Stop()
+	}
+	s.waitGroup.Wait()
+
+	slog.Info("gRPC API stopped", "comp", "grpc")
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.config.GRPCCertFile, s.config.GRPCKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading gRPC TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if s.config.GRPCClientCA != "" {
+		caPEM, err := os.ReadFile(s.config.GRPCClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gRPC client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in gRPC client CA")
+		}
+
+		// clients without certificates can still authenticate with a token
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+func (s *Server) authenticateUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !s.authenticate(ctx) {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authenticateStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !s.authenticate(ss.Context()) {
+		return status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	}
+	return handler(srv, ss)
+}
+
+// clients authenticate with a verified client certificate or a bearer token
+func (s *Server) authenticate(ctx context.Context) bool {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS && len(tlsInfo.State.VerifiedChains) > 0 {
+			return true
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			continue
+		}
+		for _, t := range s.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), t) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QueueMsg queues an outgoing message to be sent by a channel
+func (s *Server) QueueMsg(ctx context.Context, req *QueueMsgRequest) (*QueueMsgResponse, error) {
+	queuer, ok := courier.BackendAs[courier.MsgQueuer](s.backend)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "backend can't queue messages")
+	}
+
+	ch, err := s.getChannel(ctx, req.ChannelUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Uuid != "" && !uuids.Is(req.Uuid) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid message UUID: %s", req.Uuid)
+	}
+
+	msg := &courier.OutgoingMsg{
+		UUID:         courier.MsgUUID(req.Uuid),
+		URN:          urns.URN(req.Urn),
+		Text:         req.Text,
+		Attachments:  req.Attachments,
+		QuickReplies: req.QuickReplies,
+		HighPriority: req.HighPriority,
+	}
+	if msg.UUID == courier.NilMsgUUID {
+		msg.UUID = courier.MsgUUID(uuids.NewV4())
+	}
+
+	if err := msg.Validate(ch); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	id, err := queuer.QueueMsg(ctx, ch, msg)
+	if err != nil {
+		slog.Error("error queueing message from gRPC API", "comp", "grpc", "channel_uuid", ch.UUID(), "error", err)
+		return nil, status.Error(codes.Internal, "unable to queue message")
+	}
+
+	return &QueueMsgResponse{Id: int64(id), Uuid: string(msg.UUID)}, nil
+}
+
+// WatchStatuses streams the status updates of the outgoing messages of a channel as they're written
+func (s *Server) WatchStatuses(req *WatchStatusesRequest, stream grpc.ServerStreamingServer[StatusUpdate]) error {
+	ctx := stream.Context()
+
+	ch, err := s.getChannel(ctx, req.ChannelUuid)
+	if err != nil {
+		return err
+	}
+	log := slog.With("comp", "grpc", "channel_uuid", ch.UUID())
+
+	// start from the end of the feed so clients only get status updates written after they started watching
+	_, pos, err := s.readStatusFeed(ctx, ch.UUID(), "")
+	if err != nil {
+		log.Error("error reading status feed", "error", err)
+		return status.Error(codes.Unavailable, "unable to read status updates")
+	}
+
+	// send headers now so the client knows the watch has started
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.stopChan:
+			return status.Error(codes.Unavailable, "server is stopping")
+		case <-ticker.C:
+			var items []*courier.StatusFeedItem
+			items, pos, err = s.readStatusFeed(ctx, ch.UUID(), pos)
+			if err != nil {
+				log.Error("error reading status feed", "error", err)
+				continue
+			}
+
+			for _, item := range items {
+				update := &StatusUpdate{
+					ChannelUuid:  string(item.ChannelUUID),
+					MsgId:        int64(item.MsgID),
+					ExternalId:   item.ExternalID,
+					Status:       string(item.Status),
+					FailedReason: string(item.FailedReason),
+					CreatedOn:    item.CreatedOn.Format(time.RFC3339Nano),
+				}
+				if err := stream.Send(update); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) readStatusFeed(ctx context.Context, uuid courier.ChannelUUID, after string) ([]*courier.StatusFeedItem, string, error) {
+	rc, err := s.backend.RedisPool().GetContext(ctx)
+	if err != nil {
+		return nil, after, err
+	}
+	defer rc.Close()
+
+	return courier.ReadStatusFeed(rc, uuid, after, watchBatchSize)
+}
+
+// looks up the channel with the given UUID, returning a status error if that fails
+func (s *Server) getChannel(ctx context.Context, uuid string) (courier.Channel, error) {
+	ch, err := s.backend.GetChannel(ctx, courier.AnyChannelType, courier.ChannelUUID(uuid))
+	if err == courier.ErrChannelNotFound {
+		return nil, status.Errorf(codes.NotFound, "channel not found: %s", uuid)
+	} else if err != nil {
+		slog.Error("error looking up channel for gRPC API", "comp", "grpc", "channel_uuid", uuid, "error", err)
+		return nil, status.Error(codes.Internal, "unable to look up channel")
+	}
+	return ch, nil
+}
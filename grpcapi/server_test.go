@@ -0,0 +1,230 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	backend := courier.NewStatusFeedBackend(mb)
+
+	channel := test.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "TG", "2020", "RW", []string{urns.Phone.Prefix}, nil)
+	mb.AddChannel(channel)
+
+	config := courier.NewDefaultConfig()
+	config.GRPCAddress = "localhost:0"
+	config.GRPCAuthTokens = "sesame, open"
+
+	server := NewServer(config, backend)
+	require.NoError(t, server.Start())
+
+	conn, err := grpc.NewClient(server.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := NewCourierClient(conn)
+
+	// no token or an invalid token
+	_, err = client.QueueMsg(ctx, &QueueMsgRequest{})
+	assertStatus(t, err, codes.Unauthenticated, "missing or invalid credentials")
+
+	_, err = client.QueueMsg(withToken(ctx, "sesame!"), &QueueMsgRequest{})
+	assertStatus(t, err, codes.Unauthenticated, "missing or invalid credentials")
+
+	_, err = client.QueueMsg(withToken(ctx, "open"), &QueueMsgRequest{ChannelUuid: "9bcdf1ae-6c7a-4b9e-8b2f-3c4d5e6f7a8b", Urn: "tel:+250788383383", Text: "hi"})
+	assertStatus(t, err, codes.NotFound, "channel not found: 9bcdf1ae-6c7a-4b9e-8b2f-3c4d5e6f7a8b")
+
+	_, err = client.QueueMsg(withToken(ctx, "open"), &QueueMsgRequest{ChannelUuid: string(channel.UUID()), Urn: "telegram:12345", Text: "hi"})
+	assertStatus(t, err, codes.InvalidArgument, "URN scheme telegram not supported by channel")
+
+	_, err = client.QueueMsg(withToken(ctx, "open"), &QueueMsgRequest{ChannelUuid: string(channel.UUID()), Urn: "tel:+250788383383", Text: "hi", Uuid: "xyz"})
+	assertStatus(t, err, codes.InvalidArgument, "invalid message UUID: xyz")
+
+	// start watching statuses before we queue anything, waiting for headers so we know the watch has started
+	watch, err := client.WatchStatuses(withToken(ctx, "sesame"), &WatchStatusesRequest{ChannelUuid: string(channel.UUID())})
+	require.NoError(t, err)
+	_, err = watch.Header()
+	require.NoError(t, err)
+
+	// queue a message with a UUID
+	resp, err := client.QueueMsg(withToken(ctx, "sesame"), &QueueMsgRequest{
+		ChannelUuid:  string(channel.UUID()),
+		Urn:          "tel:+250788383383",
+		Text:         "hi",
+		Attachments:  []string{"image/jpeg:https://example.com/test.jpg"},
+		QuickReplies: []string{"yes", "no"},
+		HighPriority: true,
+		Uuid:         "0191e180-7d60-7000-aded-7d8b151cbd5b",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Id)
+	assert.Equal(t, "0191e180-7d60-7000-aded-7d8b151cbd5b", resp.Uuid)
+
+	// and one without
+	resp, err = client.QueueMsg(withToken(ctx, "sesame"), &QueueMsgRequest{ChannelUuid: string(channel.UUID()), Urn: "tel:+250788383383", Text: "bye"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.Id)
+	assert.NotEqual(t, "", resp.Uuid)
+
+	m, err := mb.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgID(1), m.ID())
+	assert.Equal(t, courier.MsgUUID("0191e180-7d60-7000-aded-7d8b151cbd5b"), m.UUID())
+	assert.Equal(t, "hi", m.Text())
+	assert.Equal(t, []string{"image/jpeg:https://example.com/test.jpg"}, m.Attachments())
+	assert.Equal(t, []string{"yes", "no"}, m.QuickReplies())
+	assert.True(t, m.HighPriority())
+
+	// which we can't queue if the backend fails
+	mb.SetErrorOnQueue(true)
+	_, err = client.QueueMsg(withToken(ctx, "sesame"), &QueueMsgRequest{ChannelUuid: string(channel.UUID()), Urn: "tel:+250788383383", Text: "hi"})
+	assertStatus(t, err, codes.Internal, "unable to queue message")
+	mb.SetErrorOnQueue(false)
+
+	// write some status updates which should be sent to our watcher
+	clog := courier.NewChannelLogForSend(m, nil)
+	require.NoError(t, backend.WriteStatusUpdate(ctx, mb.NewStatusUpdate(channel, 1, courier.MsgStatusWired, clog)))
+	require.NoError(t, backend.WriteStatusUpdate(ctx, mb.NewStatusUpdateByExternalID(channel, "ext1", courier.MsgStatusDelivered, clog)))
+
+	update, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, string(channel.UUID()), update.ChannelUuid)
+	assert.Equal(t, int64(1), update.MsgId)
+	assert.Equal(t, "W", update.Status)
+	assert.NotEqual(t, "", update.CreatedOn)
+
+	update, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), update.MsgId)
+	assert.Equal(t, "ext1", update.ExternalId)
+	assert.Equal(t, "D", update.Status)
+
+	// stopping the server ends the watch
+	server.Stop()
+
+	_, err = watch.Recv()
+	assertStatus(t, err, codes.Unavailable, "server is stopping")
+}
+
+func TestServerMTLS(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	channel := test.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "TG", "2020", "RW", []string{urns.Phone.Prefix}, nil)
+	mb.AddChannel(channel)
+
+	dir := t.TempDir()
+	caCert, caKey := newTestCert(t, "Test CA", nil, nil)
+	serverCert, serverKey := newTestCert(t, "localhost", caCert, caKey)
+	clientCert, clientKey := newTestCert(t, "client", caCert, caKey)
+
+	config := courier.NewDefaultConfig()
+	config.GRPCAddress = "localhost:0"
+	config.GRPCCertFile = writePEM(t, dir, "server.crt", "CERTIFICATE", serverCert.Raw)
+	config.GRPCKeyFile = writeKey(t, dir, "server.key", serverKey)
+	config.GRPCClientCA = writePEM(t, dir, "ca.crt", "CERTIFICATE", caCert.Raw)
+
+	server := NewServer(config, mb)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	newClient := func(certs []tls.Certificate) CourierClient {
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: certs, ServerName: "localhost"})
+		conn, err := grpc.NewClient(server.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return NewCourierClient(conn)
+	}
+
+	// a client without a certificate and without a token can't authenticate
+	_, err := newClient(nil).QueueMsg(ctx, &QueueMsgRequest{})
+	assertStatus(t, err, codes.Unauthenticated, "missing or invalid credentials")
+
+	// but one with a certificate signed by our CA can
+	cert := tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}
+	resp, err := newClient([]tls.Certificate{cert}).QueueMsg(ctx, &QueueMsgRequest{ChannelUuid: string(channel.UUID()), Urn: "tel:+250788383383", Text: "hi"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Id)
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func assertStatus(t *testing.T, err error, code codes.Code, msg string) {
+	t.Helper()
+
+	s, ok := status.FromError(err)
+	if assert.True(t, ok, "expected status error, got %v", err) {
+		assert.Equal(t, code, s.Code())
+		assert.Equal(t, msg, s.Message())
+	}
+}
+
+// creates a certificate signed by the given parent, or self-signed CA certificate if parent is nil
+func newTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writeKey(t *testing.T, dir, name string, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, dir, name, "EC PRIVATE KEY", der)
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return path
+}
@@ -2,7 +2,11 @@ package courier
 
 import (
 	"context"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -45,6 +49,37 @@ type AttachmentRequestBuilder interface {
 	BuildAttachmentRequest(context.Context, Backend, Channel, string, *ChannelLog) (*http.Request, error)
 }
 
+// BulkSender is the interface handlers which can send multiple messages for the same channel in a single request
+// should satisfy. The sender will try to coalesce queued messages into batches of up to MaxBulkSize messages.
+type BulkSender interface {
+	MaxBulkSize(Channel) int
+	SendBulk(context.Context, []MsgOut, []*SendResult, *ChannelLog) error
+}
+
+// QualityChecker is the interface handlers which can fetch a channel's quality rating and messaging limits from their
+// provider should satisfy. Active channels will be periodically checked and the results written to the backend.
+type QualityChecker interface {
+	CheckQuality(context.Context, Channel, *ChannelLog) (*ChannelQuality, error)
+}
+
+// DeactivationFetcher is the interface handlers which can fetch the phone numbers deactivated by carriers on a given
+// day should satisfy. Active channels will be checked daily and sends to the returned numbers prevented.
+type DeactivationFetcher interface {
+	FetchDeactivations(ctx context.Context, ch Channel, day time.Time, clog *ChannelLog) ([]string, error)
+}
+
+// ReadMarker is the interface handlers which can mark incoming messages as read with their provider should satisfy. On
+// channels with mark_read enabled, messages are marked as read when they are replied to.
+type ReadMarker interface {
+	MarkRead(ctx context.Context, ch Channel, externalID string, clog *ChannelLog) error
+}
+
+// ProfileConfigurer is the interface handlers which can push a channel's profile, e.g. its greeting and menus, to their
+// provider should satisfy. The profile is read from the channel's config and is configured via the admin API.
+type ProfileConfigurer interface {
+	ConfigureProfile(ctx context.Context, ch Channel, clog *ChannelLog) error
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
@@ -55,5 +90,13 @@ func GetHandler(ct ChannelType) ChannelHandler {
 	return registeredHandlers[ct]
 }
 
+// RegisteredHandlers returns all registered handlers, sorted by channel type
+func RegisteredHandlers() []ChannelHandler {
+	handlers := slices.Collect(maps.Values(registeredHandlers))
+	slices.SortFunc(handlers, func(a, b ChannelHandler) int {
+		return strings.Compare(string(a.ChannelType()), string(b.ChannelType()))
+	})
+	return handlers
+}
+
 var registeredHandlers = make(map[ChannelType]ChannelHandler)
-var activeHandlers = make(map[ChannelType]ChannelHandler)
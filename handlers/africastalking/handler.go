@@ -2,13 +2,13 @@ package africastalking
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
-	"time"
 
-	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/gocommon/urns"
@@ -16,8 +16,14 @@ import (
 
 const configIsShared = "is_shared"
 
+// maximum number of recipients we send to in a single request
+const maxBulkSize = 100
+
 var sendURL = "https://api.africastalking.com/version1/messaging"
 
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45Z or 2017-05-03 06:04:45
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z", "2006-01-02 15:04:05"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -58,14 +64,9 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our date from the timestamp
-	// 2017-05-03T06:04:45Z
-	date, err := time.Parse("2006-01-02T15:04:05Z", form.Date)
+	date, err := timestampFormat.Parse(form.Date)
 	if err != nil {
-		date, err = time.Parse("2006-01-02 15:04:05", form.Date)
-		if err != nil {
-			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", form.Date))
-		}
-		date = date.UTC()
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", form.Date))
 	}
 
 	// create our URN
@@ -116,26 +117,73 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 
 // Send sends the given message, logging any HTTP calls or errors
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
-	isSharedStr := msg.Channel().ConfigForKey(configIsShared, false)
-	isShared, _ := isSharedStr.(bool)
+	if err := h.sendToRecipients([]courier.MsgOut{msg}, []*courier.SendResult{res}, clog); err != nil {
+		return err
+	}
+	return res.GetError()
+}
 
-	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
-	apiKey := msg.Channel().StringConfigForKey(courier.ConfigAPIKey, "")
+// MaxBulkSize returns the maximum number of messages to send in a single bulk send
+func (h *handler) MaxBulkSize(ch courier.Channel) int {
+	return min(ch.IntConfigForKey(courier.ConfigMaxBulkSize, 1), maxBulkSize)
+}
+
+// SendBulk sends the passed in messages, which are all for the same channel, with a request for each group of them which
+// have the same sender and content
+func (h *handler) SendBulk(ctx context.Context, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	// messages can only be sent in the same request if they're from the same number with the same content
+	bulkKey := func(m courier.MsgOut) string { return m.Channel().Address() + "\x00" + h.TextAndAttachments(m) }
+
+	for _, group := range handlers.GroupMsgs(msgs, bulkKey) {
+		groupMsgs := make([]courier.MsgOut, len(group))
+		groupResults := make([]*courier.SendResult, len(group))
+		for i, idx := range group {
+			groupMsgs[i], groupResults[i] = msgs[idx], results[idx]
+		}
+
+		if err := h.sendToRecipients(groupMsgs, groupResults, clog); err != nil {
+			for _, res := range groupResults {
+				res.SetError(err)
+			}
+		}
+	}
+	return nil
+}
+
+type sendRecipient struct {
+	Number    string `json:"number"`
+	Status    string `json:"status"`
+	MessageID string `json:"messageId"`
+}
+
+// sends the given messages, which have the same sender and content, in a single request to their recipients. The
+// result of each message is set from its recipient in the response.
+func (h *handler) sendToRecipients(msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	channel := msgs[0].Channel()
+	isShared := channel.BoolConfigForKey(configIsShared, false)
+
+	username := channel.StringConfigForKey(courier.ConfigUsername, "")
+	apiKey := channel.StringConfigForKey(courier.ConfigAPIKey, "")
 
 	if username == "" || apiKey == "" {
 		return courier.ErrChannelConfig
 	}
 
+	to := make([]string, len(msgs))
+	for i, m := range msgs {
+		to[i] = m.URN().Path()
+	}
+
 	// build our request
 	form := url.Values{
 		"username": []string{username},
-		"to":       []string{msg.URN().Path()},
-		"message":  []string{handlers.GetTextAndAttachments(msg)},
+		"to":       []string{strings.Join(to, ",")},
+		"message":  []string{h.TextAndAttachments(msgs[0])},
 	}
 
 	// if this isn't shared, include our from
 	if !isShared {
-		form["from"] = []string{msg.Channel().Address()}
+		form["from"] = []string{channel.Address()}
 	}
 
 	req, err := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
@@ -153,16 +201,34 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
-	// was this request successful?
-	msgStatus, _ := jsonparser.GetString(respBody, "SMSMessageData", "Recipients", "[0]", "status")
-	if msgStatus != "Success" {
-		return courier.ErrResponseContent
-	}
+	response := &struct {
+		SMSMessageData struct {
+			Recipients []sendRecipient `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}{}
+	json.Unmarshal(respBody, response) // an unparseable response is treated like one without recipients
+	recipients := response.SMSMessageData.Recipients
+
+	for i, m := range msgs {
+		// a single recipient is the first in the response, otherwise we find each by its number
+		var recipient sendRecipient
+		if len(msgs) == 1 && len(recipients) > 0 {
+			recipient = recipients[0]
+		} else if j := slices.IndexFunc(recipients, func(r sendRecipient) bool { return r.Number == m.URN().Path() }); j >= 0 {
+			recipient = recipients[j]
+		}
 
-	// grab the external id if we can
-	externalID, _ := jsonparser.GetString(respBody, "SMSMessageData", "Recipients", "[0]", "messageId")
-	if externalID != "" {
-		res.AddExternalID(externalID)
+		// was this message sent successfully?
+		if recipient.Status == "InsufficientBalance" {
+			results[i].SetError(courier.ErrInsufficientBalance)
+		} else if recipient.Status != "Success" {
+			results[i].SetError(courier.ErrResponseContent)
+		} else {
+			if recipient.MessageID != "" {
+				results[i].AddExternalID(recipient.MessageID)
+			}
+			handlers.AddSMSSegments(results[i], form.Get("message"))
+		}
 	}
 
 	return nil
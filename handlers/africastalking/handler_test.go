@@ -1,6 +1,8 @@
 package africastalking
 
 import (
+	"context"
+	"io"
 	"net/url"
 	"testing"
 	"time"
@@ -10,6 +12,8 @@ import (
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -155,6 +159,20 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrResponseContent,
 	},
+	{
+		Label:   "Insufficient balance",
+		MsgText: "Hi",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.africastalking.com/version1/messaging": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "SMSMessageData": {"Recipients": [{"status": "InsufficientBalance", "statusCode": 405 }] } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"message": {`Hi`}, "username": {"Username"}, "to": {"+250788383383"}, "from": {"2020"}}},
+		},
+		ExpectedError: courier.ErrInsufficientBalance,
+	},
 	{
 		Label:   "Missing status value",
 		MsgText: "Error Message",
@@ -208,3 +226,55 @@ func TestOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, defaultChannel, newHandler(), outgoingCases, []string{"KEY"}, nil)
 	RunOutgoingTestCases(t, sharedChannel, newHandler(), sharedOutgoingCases, []string{"KEY"}, nil)
 }
+
+func TestSendBulk(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AT", "2020", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{
+			courier.ConfigUsername:    "Username",
+			courier.ConfigAPIKey:      "KEY",
+			courier.ConfigMaxBulkSize: 50,
+		})
+
+	mb := test.NewMockBackend()
+	h := newHandler().(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	assert.Equal(t, 50, h.MaxBulkSize(ch))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.africastalking.com/version1/messaging": {
+			httpx.NewMockResponse(201, nil, []byte(`{"SMSMessageData": {"Recipients": [{"number": "+250788383385", "status": "InsufficientBalance"}, {"number": "+250788383383", "status": "Success", "messageId": "1002"}]}}`)),
+			httpx.NewMockResponse(201, nil, []byte(`{"SMSMessageData": {"Recipients": [{"number": "+250788383384", "status": "Success", "messageId": "1003"}]}}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	msgs := []courier.MsgOut{
+		test.NewMockMsg(10, "", ch, "tel:+250788383383", "Hi all", nil),
+		test.NewMockMsg(11, "", ch, "tel:+250788383384", "Hi Bob", nil),
+		test.NewMockMsg(12, "", ch, "tel:+250788383385", "Hi all", nil),
+	}
+	results := []*courier.SendResult{{}, {}, {}}
+	clog := courier.NewChannelLogForSend(msgs[0], h.RedactValues(ch))
+
+	err := h.SendBulk(context.Background(), msgs, results, clog)
+	assert.NoError(t, err)
+
+	// messages with the same content are sent to all their recipients in one request, and matched up by number
+	assert.Equal(t, []string{"1002"}, results[0].ExternalIDs())
+	assert.NoError(t, results[0].GetError())
+	assert.Equal(t, 1, results[0].Segments())
+	assert.Nil(t, results[2].ExternalIDs())
+	assert.Equal(t, courier.ErrInsufficientBalance, results[2].GetError())
+
+	assert.Equal(t, []string{"1003"}, results[1].ExternalIDs())
+	assert.NoError(t, results[1].GetError())
+
+	require.Len(t, mocks.Requests(), 2)
+	body, _ := io.ReadAll(mocks.Requests()[0].Body)
+	assert.Equal(t, url.Values{"from": {"2020"}, "message": {"Hi all"}, "to": {"+250788383383,+250788383385"}, "username": {"Username"}}.Encode(), string(body))
+	body, _ = io.ReadAll(mocks.Requests()[1].Body)
+	assert.Equal(t, url.Values{"from": {"2020"}, "message": {"Hi Bob"}, "to": {"+250788383384"}, "username": {"Username"}}.Encode(), string(body))
+}
@@ -0,0 +1,288 @@
+package apns
+
+/*
+Sends to iOS apps using the Apple Push Notification service with token-based authentication. Contacts are identified by
+an external id given by the app and their device token is stored as a URN auth token when they register or send.
+
+Requests are made with the backend's shared HTTP client whose transport negotiates HTTP/2 and keeps connections alive
+between sends, as recommended by Apple. Sending is exported so that handlers of other channel types can push to iOS apps.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	configKeyID   = "apns_key_id"
+	configTeamID  = "apns_team_id"
+	configKey     = "apns_key" // contents of the .p8 file
+	configTopic   = "apns_topic"
+	configTitle   = "apns_title"
+	configSandbox = "apns_sandbox"
+)
+
+var (
+	sendURL        = "https://api.push.apple.com/3/device/%s"
+	sandboxSendURL = "https://api.sandbox.push.apple.com/3/device/%s"
+	maxMsgLength   = 2048
+
+	// descriptions of rejection reasons which aren't retryable
+	reasonDescriptions = map[string]string{
+		"BadDeviceToken":         "The device token is invalid.",
+		"DeviceTokenNotForTopic": "The device token doesn't match the topic.",
+		"TopicDisallowed":        "Pushing to this topic is not allowed.",
+		"PayloadTooLarge":        "The message payload is too large.",
+		"InvalidProviderToken":   "The key ID, team ID or key is invalid.",
+	}
+
+	// provider tokens must be refreshed at least once an hour but no more than once every 20 minutes
+	tokenLifetime = time.Minute * 50
+)
+
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45.123
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000"}}
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	tokens *handlers.TokenManager
+}
+
+func newHandler() courier.ChannelHandler {
+	h := &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("APN"), "Apple Push Notifications", handlers.WithRedactConfigKeys(configKey))}
+	h.tokens = handlers.NewTokenManager(h)
+	return h
+}
+
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
+	s.AddHandlerRoute(h, http.MethodPost, "register", courier.ChannelLogTypeEventReceive, h.registerContact)
+	return nil
+}
+
+type receiveForm struct {
+	From        string `name:"from"         validate:"required"`
+	Msg         string `name:"msg"`
+	DeviceToken string `name:"device_token"`
+	Date        string `name:"date"`
+	Name        string `name:"name"`
+}
+
+// receiveMessage is our HTTP handler function for incoming messages
+func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &receiveForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	date := time.Now().UTC()
+	if form.Date != "" {
+		date, err = timestampFormat.Parse(form.Date)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse date: %s", form.Date))
+		}
+	}
+
+	urn, err := urns.New(urns.External, form.From)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// if a new device token was provided, record that
+	var authTokens map[string]string
+	if form.DeviceToken != "" {
+		authTokens = map[string]string{"default": form.DeviceToken}
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, form.Msg, "", clog).WithReceivedOn(date).WithContactName(form.Name).WithURNAuthTokens(authTokens)
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+type registerForm struct {
+	URN         string `name:"urn"          validate:"required"`
+	DeviceToken string `name:"device_token" validate:"required"`
+	Name        string `name:"name"`
+}
+
+// registerContact is our HTTP handler function for when a contact is registered or their device token changes
+func (h *handler) registerContact(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &registerForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	urn, err := urns.New(urns.External, form.URN)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	contact, err := h.Backend().GetContact(ctx, channel, urn, map[string]string{"default": form.DeviceToken}, form.Name, clog)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]string{"contact_uuid": string(contact.UUID())})
+	return nil, err
+}
+
+// see https://developer.apple.com/documentation/usernotifications/generating-a-remote-notification
+type mtPayload struct {
+	APS struct {
+		Alert struct {
+			Title string `json:"title,omitempty"`
+			Body  string `json:"body"`
+		} `json:"alert"`
+		Sound string `json:"sound,omitempty"`
+	} `json:"aps"`
+	MessageID     string   `json:"message_id"`
+	SessionStatus string   `json:"session_status"`
+	QuickReplies  []string `json:"quick_replies,omitempty"`
+}
+
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	return Send(ctx, &h.BaseHandler, h.tokens, msg, res, clog)
+}
+
+// FetchAccessToken creates a new provider token for the given channel
+func (h *handler) FetchAccessToken(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+	return FetchProviderToken(channel)
+}
+
+// FetchProviderToken creates a new provider token for the given channel, returning it with how long it can be used for
+func FetchProviderToken(channel courier.Channel) (string, time.Duration, error) {
+	token, err := NewProviderToken(channel, time.Now())
+	return token, tokenLifetime, err
+}
+
+// Send pushes the given message to the contact's device using the APNs config of its channel, authenticating with
+// provider tokens from the given token manager
+func Send(ctx context.Context, h *handlers.BaseHandler, tokens *handlers.TokenManager, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	topic := msg.Channel().StringConfigForKey(configTopic, "")
+	if topic == "" {
+		return courier.ErrChannelConfig
+	}
+
+	// without a device token there's no way to reach the contact
+	if msg.URNAuth() == "" {
+		return courier.ErrFailedWithReason("", "Contact has no registered device token.")
+	}
+
+	token, err := tokens.Token(ctx, msg.Channel(), clog)
+	if err != nil {
+		return err
+	}
+
+	deviceURL := fmt.Sprintf(sendURL, msg.URNAuth())
+	if msg.Channel().BoolConfigForKey(configSandbox, false) {
+		deviceURL = fmt.Sprintf(sandboxSendURL, msg.URNAuth())
+	}
+
+	// notifications with the same collapse id replace each other on the device
+	collapseID, _ := jsonparser.GetString(msg.Metadata(), "collapse_id")
+
+	title := msg.Channel().StringConfigForKey(configTitle, "")
+	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+
+	for i, part := range parts {
+		payload := &mtPayload{}
+		payload.APS.Alert.Title = title
+		payload.APS.Alert.Body = part
+		payload.APS.Sound = "default"
+		payload.MessageID = msg.ID().String()
+		if msg.Session() != nil {
+			payload.SessionStatus = msg.Session().Status
+		}
+
+		// include any quick replies on the last piece we send
+		if i == len(parts)-1 {
+			payload.QuickReplies = msg.QuickReplies()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, deviceURL, bytes.NewReader(jsonx.MustMarshal(payload)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+		req.Header.Set("apns-topic", topic)
+		req.Header.Set("apns-push-type", "alert")
+		req.Header.Set("apns-priority", "10")
+		if collapseID != "" {
+			req.Header.Set("apns-collapse-id", collapseID)
+		}
+
+		resp, respBody, err := h.RequestHTTP(req, clog)
+		if err != nil || resp.StatusCode/100 == 5 {
+			return courier.ErrConnectionFailed
+		}
+
+		// see https://developer.apple.com/documentation/usernotifications/handling-notification-responses-from-apns
+		if resp.StatusCode/100 != 2 {
+			reason, _ := jsonparser.GetString(respBody, "reason")
+
+			switch {
+			case resp.StatusCode == http.StatusGone || reason == "Unregistered":
+				return courier.ErrContactStopped
+			case resp.StatusCode == http.StatusTooManyRequests:
+				return courier.ErrConnectionThrottled
+			case reason == "ExpiredProviderToken":
+				tokens.Clear(msg.Channel())
+				return courier.ErrConnectionFailed
+			case reason != "":
+				return courier.ErrFailedWithReason(reason, reasonDescriptions[reason])
+			default:
+				return courier.ErrResponseStatus
+			}
+		}
+
+		externalID := resp.Header.Get("apns-id")
+		if externalID == "" {
+			return courier.ErrResponseUnexpected
+		}
+		res.AddExternalID(externalID)
+	}
+
+	return nil
+}
+
+// NewProviderToken creates a new JWT signed with the channel's .p8 key
+// see https://developer.apple.com/documentation/usernotifications/establishing-a-token-based-connection-to-apns
+func NewProviderToken(channel courier.Channel, now time.Time) (string, error) {
+	keyID := channel.StringConfigForKey(configKeyID, "")
+	teamID := channel.StringConfigForKey(configTeamID, "")
+	keyPEM := channel.StringConfigForKey(configKey, "")
+	if keyID == "" || teamID == "" || keyPEM == "" {
+		return "", courier.ErrChannelConfig
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(keyPEM))
+	if err != nil {
+		return "", courier.ErrChannelConfig
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": teamID, "iat": now.Unix()})
+	token.Header["kid"] = keyID
+
+	return token.SignedString(key)
+}
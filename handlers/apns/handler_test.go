@@ -0,0 +1,283 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	receiveURL  = "/c/apn/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive"
+	registerURL = "/c/apn/8eb23e93-5ecb-45ba-b726-3b064e0c568c/register"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APN", "", "",
+		[]string{urns.External.Prefix},
+		map[string]any{
+			configKeyID:  "ABC123DEFG",
+			configTeamID: "DEF123GHIJ",
+			configKey:    "KEY",
+			configTopic:  "com.example.app",
+			configTitle:  "Example",
+		}),
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APN", "", "",
+		[]string{urns.External.Prefix},
+		map[string]any{
+			configKeyID:   "ABC123DEFG",
+			configTeamID:  "DEF123GHIJ",
+			configKey:     "KEY",
+			configTopic:   "com.example.app",
+			configSandbox: true,
+		}),
+}
+
+var incomingCases = []IncomingTestCase{
+	{
+		Label:                 "Receive Valid Message",
+		URL:                   receiveURL,
+		Data:                  "from=12345&date=2017-01-01T08:50:00.000&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:    200,
+		ExpectedBodyContains:  "Accepted",
+		ExpectedMsgText:       Sp("hello world"),
+		ExpectedURN:           "ext:12345",
+		ExpectedDate:          time.Date(2017, 1, 1, 8, 50, 0, 0, time.UTC),
+		ExpectedURNAuthTokens: map[urns.URN]map[string]string{"ext:12345": {"default": "token"}},
+		ExpectedContactName:   Sp("fred"),
+	},
+	{
+		Label:                "Receive Invalid Date",
+		URL:                  receiveURL,
+		Data:                 "from=12345&date=yo&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unable to parse date",
+	},
+	{
+		Label:                "Receive Missing From",
+		URL:                  receiveURL,
+		Data:                 "date=2017-01-01T08:50:00.000&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'from' required",
+	},
+	{
+		Label:                "Receive Valid Register",
+		URL:                  registerURL,
+		Data:                 "urn=12345&device_token=token&name=fred",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "contact_uuid",
+	},
+	{
+		Label:                "Receive Missing Device Token",
+		URL:                  registerURL,
+		Data:                 "urn=12345&name=fred",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'devicetoken' required",
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), incomingCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), incomingCases)
+}
+
+var outgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(200, map[string]string{"apns-id": "EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"}, nil),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "bearer APNSToken", "apns-topic": "com.example.app", "apns-push-type": "alert", "apns-priority": "10"},
+			Body:    `{"aps":{"alert":{"title":"Example","body":"Simple Message"},"sound":"default"},"message_id":"10","session_status":""}`,
+		}},
+		ExpectedExtIDs: []string{"EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"},
+	},
+	{
+		Label:           "Quick Replies And Collapse ID",
+		MsgText:         "Simple Message",
+		MsgURN:          "ext:12345",
+		MsgURNAuth:      "device1",
+		MsgQuickReplies: []string{"yes", "no"},
+		MsgAttachments:  []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MsgMetadata:     `{"collapse_id": "order-123"}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(200, map[string]string{"apns-id": "EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"}, nil),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "bearer APNSToken", "apns-collapse-id": "order-123"},
+			Body:    `{"aps":{"alert":{"title":"Example","body":"Simple Message\nhttps://foo.bar/image.jpg"},"sound":"default"},"message_id":"10","session_status":"","quick_replies":["yes","no"]}`,
+		}},
+		ExpectedExtIDs: []string{"EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"},
+	},
+	{
+		Label:         "No Device Token",
+		MsgText:       "Simple Message",
+		MsgURN:        "ext:12345",
+		ExpectedError: courier.ErrFailedWithReason("", "Contact has no registered device token."),
+	},
+	{
+		Label:      "Unregistered",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(410, nil, []byte(`{"reason": "Unregistered", "timestamp": 1725000000000}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+	{
+		Label:      "Bad Device Token",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(400, nil, []byte(`{"reason": "BadDeviceToken"}`)),
+			},
+		},
+		ExpectedError: courier.ErrFailedWithReason("BadDeviceToken", "The device token is invalid."),
+	},
+	{
+		Label:      "Throttled",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(429, nil, []byte(`{"reason": "TooManyRequests"}`)),
+			},
+		},
+		ExpectedError: courier.ErrConnectionThrottled,
+	},
+	{
+		Label:      "Server Error",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(503, nil, []byte(`{"reason": "ServiceUnavailable"}`)),
+			},
+		},
+		ExpectedError: courier.ErrConnectionFailed,
+	},
+	{
+		Label:      "Missing APNs ID",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(200, nil, nil),
+			},
+		},
+		ExpectedError: courier.ErrResponseUnexpected,
+	},
+	{
+		Label:      "Expired Provider Token",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(403, nil, []byte(`{"reason": "ExpiredProviderToken"}`)),
+			},
+		},
+		ExpectedError: courier.ErrConnectionFailed, // and cached provider token is cleared
+	},
+}
+
+var sandboxOutgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.sandbox.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(200, map[string]string{"apns-id": "EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"}, nil),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "bearer APNSToken", "apns-topic": "com.example.app"},
+			Body:    `{"aps":{"alert":{"body":"Simple Message"},"sound":"default"},"message_id":"10","session_status":""}`,
+		}},
+		ExpectedExtIDs: []string{"EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"},
+	},
+}
+
+func setupBackend(mb *test.MockBackend) {
+	// ensure there's a cached provider token
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("SET", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APNSToken")
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), outgoingCases, []string{"KEY"}, setupBackend)
+	RunOutgoingTestCases(t, testChannels[1], newHandler(), sandboxOutgoingCases, []string{"KEY"}, setupBackend)
+}
+
+func TestNewProviderToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APN", "", "", []string{urns.External.Prefix}, map[string]any{
+		configKeyID:  "ABC123DEFG",
+		configTeamID: "DEF123GHIJ",
+		configKey:    string(keyPEM),
+	})
+
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	signed, err := NewProviderToken(channel, now)
+	assert.NoError(t, err)
+
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (any, error) { return &key.PublicKey, nil }, jwt.WithValidMethods([]string{"ES256"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "ABC123DEFG", token.Header["kid"])
+
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "DEF123GHIJ", claims["iss"])
+	assert.Equal(t, float64(now.Unix()), claims["iat"])
+
+	// invalid or missing keys are a config error
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APN", "", "", []string{urns.External.Prefix}, map[string]any{
+		configKeyID:  "ABC123DEFG",
+		configTeamID: "DEF123GHIJ",
+		configKey:    "not a key",
+	})
+	_, err = NewProviderToken(channel, now)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APN", "", "", []string{urns.External.Prefix}, map[string]any{})
+	_, err = NewProviderToken(channel, now)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"golang.org/x/oauth2/google"
+)
+
+const configFCMTitle = "fcm_title"
+
+var (
+	fcmSendURL      = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	fcmMaxMsgLength = 2048
+)
+
+// see https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages
+type fcmPayload struct {
+	Message struct {
+		Token        string `json:"token"`
+		Notification struct {
+			Title string `json:"title,omitempty"`
+			Body  string `json:"body"`
+		} `json:"notification"`
+		Data struct {
+			MessageID     string `json:"message_id"`
+			SessionStatus string `json:"session_status"`
+			QuickReplies  string `json:"quick_replies,omitempty"` // data values must be strings so these are JSON encoded
+		} `json:"data"`
+		Android struct {
+			Priority string `json:"priority"`
+		} `json:"android"`
+	} `json:"message"`
+}
+
+// sendFCM pushes the message using the Firebase Cloud Messaging HTTP v1 API
+func (h *handler) sendFCM(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	projectID, _ := jsonparser.GetString([]byte(msg.Channel().StringConfigForKey(configFCMCredentials, "")), "project_id")
+	if projectID == "" {
+		return courier.ErrChannelConfig
+	}
+
+	// without a device token there's no way to reach the contact
+	if msg.URNAuth() == "" {
+		return courier.ErrFailedWithReason("", "Contact has no registered device token.")
+	}
+
+	token, err := h.tokens.Token(ctx, msg.Channel(), clog)
+	if err != nil {
+		return err
+	}
+
+	title := msg.Channel().StringConfigForKey(configFCMTitle, "")
+	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), fcmMaxMsgLength)
+
+	for i, part := range parts {
+		payload := &fcmPayload{}
+		payload.Message.Token = msg.URNAuth()
+		payload.Message.Notification.Title = title
+		payload.Message.Notification.Body = part
+		payload.Message.Data.MessageID = msg.ID().String()
+		if msg.Session() != nil {
+			payload.Message.Data.SessionStatus = msg.Session().Status
+		}
+		payload.Message.Android.Priority = "high"
+
+		// include any quick replies on the last piece we send
+		if i == len(parts)-1 && len(msg.QuickReplies()) > 0 {
+			payload.Message.Data.QuickReplies = string(jsonx.MustMarshal(msg.QuickReplies()))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(fcmSendURL, projectID), bytes.NewReader(jsonx.MustMarshal(payload)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		resp, respBody, err := h.RequestHTTP(req, clog)
+		if err != nil || resp.StatusCode/100 == 5 {
+			return courier.ErrConnectionFailed
+		}
+
+		// see https://firebase.google.com/docs/reference/fcm/rest/v1/ErrorCode
+		if resp.StatusCode/100 != 2 {
+			errorCode, _ := jsonparser.GetString(respBody, "error", "details", "[0]", "errorCode")
+			message, _ := jsonparser.GetString(respBody, "error", "message")
+
+			switch {
+			case errorCode == "UNREGISTERED":
+				return courier.ErrContactStopped
+			case resp.StatusCode == http.StatusTooManyRequests:
+				return courier.ErrConnectionThrottled
+			case resp.StatusCode == http.StatusUnauthorized:
+				h.tokens.Clear(msg.Channel())
+				return courier.ErrConnectionFailed
+			case errorCode != "":
+				return courier.ErrFailedWithReason(errorCode, message)
+			default:
+				return courier.ErrResponseStatus
+			}
+		}
+
+		// name is like projects/myproject/messages/0:1500415314455276%31bd1c9631bd1c96
+		name, _ := jsonparser.GetString(respBody, "name")
+		_, externalID, _ := strings.Cut(name, "/messages/")
+		if externalID == "" {
+			return courier.ErrResponseUnexpected
+		}
+		res.AddExternalID(externalID)
+	}
+
+	return nil
+}
+
+// fetchFCMToken creates a new access token signed with the channel's service account key
+func fetchFCMToken(ch courier.Channel) (string, time.Duration, error) {
+	credentials := ch.StringConfigForKey(configFCMCredentials, "")
+
+	ts, err := google.JWTAccessTokenSourceWithScope([]byte(credentials), "https://www.googleapis.com/auth/firebase.messaging")
+	if err != nil {
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating FCM access token: %w", err)
+	}
+
+	return token.AccessToken, time.Until(token.Expiry), nil
+}
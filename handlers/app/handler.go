@@ -0,0 +1,158 @@
+package app
+
+/*
+Sends to customer-owned mobile apps using the push provider configured for the channel, which can be Firebase Cloud
+Messaging, the Apple Push Notification service or Expo. Contacts have app URNs with the user id given by the app, and
+the app registers the device token of each user with the register endpoint. Tokens are stored as URN auth tokens so
+messages are always pushed to the user's most recently registered device.
+
+APNs and Expo channels are configured with the same keys as APN and EXP channels, and are sent to by those handlers'
+exported send functions.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/handlers/apns"
+	"github.com/nyaruka/courier/handlers/expo"
+)
+
+const (
+	configProvider = "push_provider" // one of fcm, apns or expo
+
+	// secrets of the providers, which are redacted from logs
+	configFCMCredentials  = "fcm_credentials" // service account JSON
+	configAPNsKey         = "apns_key"
+	configExpoAccessToken = "expo_access_token"
+)
+
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45.123
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000"}}
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	tokens *handlers.TokenManager
+}
+
+func newHandler() courier.ChannelHandler {
+	h := &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("APP"), "App Push", handlers.WithRedactConfigKeys(configFCMCredentials, configAPNsKey, configExpoAccessToken))}
+	h.tokens = handlers.NewTokenManager(h)
+	return h
+}
+
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
+	s.AddHandlerRoute(h, http.MethodPost, "register", courier.ChannelLogTypeEventReceive, h.registerDevice)
+
+	expo.StartReceiptsPoller(&h.BaseHandler, s)
+	return nil
+}
+
+type receiveForm struct {
+	From        string `name:"from"         validate:"required"`
+	Msg         string `name:"msg"`
+	DeviceToken string `name:"device_token"`
+	Date        string `name:"date"`
+	Name        string `name:"name"`
+}
+
+// receiveMessage is our HTTP handler function for incoming messages
+func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &receiveForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	date := time.Now().UTC()
+	if form.Date != "" {
+		date, err = timestampFormat.Parse(form.Date)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse date: %s", form.Date))
+		}
+	}
+
+	urn, err := courier.NewAppURN(form.From)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// if a new device token was provided, record that
+	var authTokens map[string]string
+	if form.DeviceToken != "" {
+		authTokens = map[string]string{"default": form.DeviceToken}
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, form.Msg, "", clog).WithReceivedOn(date).WithContactName(form.Name).WithURNAuthTokens(authTokens)
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+type registerForm struct {
+	UserID      string `name:"user_id"      validate:"required"`
+	DeviceToken string `name:"device_token" validate:"required"`
+	Name        string `name:"name"`
+}
+
+// registerDevice is our HTTP handler function for when an app user is registered or their device token changes
+func (h *handler) registerDevice(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &registerForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	urn, err := courier.NewAppURN(form.UserID)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	contact, err := h.Backend().GetContact(ctx, channel, urn, map[string]string{"default": form.DeviceToken}, form.Name, clog)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]string{"contact_uuid": string(contact.UUID()), "urn": string(urn)})
+	return nil, err
+}
+
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	switch msg.Channel().StringConfigForKey(configProvider, "") {
+	case "fcm":
+		return h.sendFCM(ctx, msg, res, clog)
+	case "apns":
+		return apns.Send(ctx, &h.BaseHandler, h.tokens, msg, res, clog)
+	case "expo":
+		if err := expo.SendBulk(ctx, &h.BaseHandler, []courier.MsgOut{msg}, []*courier.SendResult{res}, clog); err != nil {
+			return err
+		}
+		return res.GetError()
+	default:
+		return courier.ErrChannelConfig
+	}
+}
+
+// FetchAccessToken fetches a new token to authenticate with the channel's push provider
+func (h *handler) FetchAccessToken(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+	switch channel.StringConfigForKey(configProvider, "") {
+	case "fcm":
+		return fetchFCMToken(channel)
+	case "apns":
+		return apns.FetchProviderToken(channel)
+	default:
+		return "", 0, courier.ErrChannelConfig
+	}
+}
@@ -0,0 +1,398 @@
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	receiveURL  = "/c/app/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive"
+	registerURL = "/c/app/8eb23e93-5ecb-45ba-b726-3b064e0c568c/register"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "",
+		[]string{courier.AppScheme.Prefix},
+		map[string]any{
+			configProvider: "fcm",
+		}),
+}
+
+var incomingCases = []IncomingTestCase{
+	{
+		Label:                 "Receive Valid Message",
+		URL:                   receiveURL,
+		Data:                  "from=user-123&date=2017-01-01T08:50:00.000&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:    200,
+		ExpectedBodyContains:  "Accepted",
+		ExpectedMsgText:       Sp("hello world"),
+		ExpectedURN:           "app:user-123",
+		ExpectedDate:          time.Date(2017, 1, 1, 8, 50, 0, 0, time.UTC),
+		ExpectedURNAuthTokens: map[urns.URN]map[string]string{"app:user-123": {"default": "token"}},
+		ExpectedContactName:   Sp("fred"),
+	},
+	{
+		Label:                "Receive Invalid Date",
+		URL:                  receiveURL,
+		Data:                 "from=user-123&date=yo&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unable to parse date",
+	},
+	{
+		Label:                "Receive Invalid User ID",
+		URL:                  receiveURL,
+		Data:                 "from=user+123&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid path component",
+	},
+	{
+		Label:                "Receive Missing From",
+		URL:                  receiveURL,
+		Data:                 "date=2017-01-01T08:50:00.000&device_token=token&name=fred&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'from' required",
+	},
+	{
+		Label:                "Receive Valid Register",
+		URL:                  registerURL,
+		Data:                 "user_id=user-123&device_token=token&name=fred",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"urn":"app:user-123"`,
+	},
+	{
+		Label:                "Receive Missing Device Token",
+		URL:                  registerURL,
+		Data:                 "user_id=user-123&name=fred",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'devicetoken' required",
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), incomingCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), incomingCases)
+}
+
+var fcmOutgoingCases = []OutgoingTestCase{
+	{
+		Label:           "Plain Send",
+		MsgText:         "Simple Message",
+		MsgURN:          "app:user-123",
+		MsgURNAuth:      "device1",
+		MsgQuickReplies: []string{"yes", "no"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"name": "projects/example-app/messages/0:1500415314455276"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `{"message":{"token":"device1","notification":{"title":"Example","body":"Simple Message"},"data":{"message_id":"10","session_status":"","quick_replies":"[\"yes\",\"no\"]"},"android":{"priority":"high"}}}`,
+		}},
+		ExpectedExtIDs: []string{"0:1500415314455276"},
+	},
+	{
+		Label:         "No Device Token",
+		MsgText:       "Simple Message",
+		MsgURN:        "app:user-123",
+		ExpectedError: courier.ErrFailedWithReason("", "Contact has no registered device token."),
+	},
+	{
+		Label:      "Unregistered",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(404, nil, []byte(`{"error": {"code": 404, "message": "Requested entity was not found.", "details": [{"errorCode": "UNREGISTERED"}]}}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+	{
+		Label:      "Invalid Argument",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(400, nil, []byte(`{"error": {"code": 400, "message": "Invalid registration token", "details": [{"errorCode": "INVALID_ARGUMENT"}]}}`)),
+			},
+		},
+		ExpectedError: courier.ErrFailedWithReason("INVALID_ARGUMENT", "Invalid registration token"),
+	},
+	{
+		Label:      "Throttled",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(429, nil, []byte(`{"error": {"code": 429}}`)),
+			},
+		},
+		ExpectedError: courier.ErrConnectionThrottled,
+	},
+	{
+		Label:      "Server Error",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(503, nil, []byte(`{"error": {"code": 503}}`)),
+			},
+		},
+		ExpectedError: courier.ErrConnectionFailed,
+	},
+	{
+		Label:      "Missing Name",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/example-app/messages:send": {
+				httpx.NewMockResponse(200, nil, []byte(`{}`)),
+			},
+		},
+		ExpectedError: courier.ErrResponseUnexpected,
+	},
+}
+
+var apnsOutgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.sandbox.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(200, map[string]string{"apns-id": "EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"}, nil),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"apns-topic": "com.example.app", "apns-push-type": "alert"},
+			Body:    `{"aps":{"alert":{"body":"Simple Message"},"sound":"default"},"message_id":"10","session_status":""}`,
+		}},
+		ExpectedExtIDs: []string{"EC1BF194-B3B2-424A-89A9-5A918A6E7C8E"},
+	},
+	{
+		Label:      "Unregistered",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.sandbox.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(410, nil, []byte(`{"reason": "Unregistered"}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+	{
+		Label:      "Bad Device Token",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "device1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.sandbox.push.apple.com/3/device/device1": {
+				httpx.NewMockResponse(400, nil, []byte(`{"reason": "BadDeviceToken"}`)),
+			},
+		},
+		ExpectedError: courier.ErrFailedWithReason("BadDeviceToken", "The device token is invalid."),
+	},
+}
+
+var expoOutgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "ExponentPushToken[xxx]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data": [{"status": "ok", "id": "XXXX-XXXX"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "Bearer sesame"},
+			Body:    `[{"to":"ExponentPushToken[xxx]","body":"Simple Message","sound":"default","data":{"message_id":"10","session_status":""}}]`,
+		}},
+		ExpectedExtIDs: []string{"XXXX-XXXX"},
+	},
+	{
+		Label:      "Device Not Registered",
+		MsgText:    "Simple Message",
+		MsgURN:     "app:user-123",
+		MsgURNAuth: "ExponentPushToken[xxx]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data": [{"status": "error", "message": "not registered", "details": {"error": "DeviceNotRegistered"}}]}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+}
+
+var noProviderOutgoingCases = []OutgoingTestCase{
+	{
+		Label:         "No Provider",
+		MsgText:       "Simple Message",
+		MsgURN:        "app:user-123",
+		MsgURNAuth:    "device1",
+		ExpectedError: courier.ErrChannelConfig,
+	},
+}
+
+// generates an EC key in PEM format like the .p8 files Apple provides
+func newAPNsKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// generates the JSON of a service account with a new RSA key like those Google provides
+func newFCMCredentials(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return key, string(jsonx.MustMarshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "example-app",
+		"private_key_id": "123",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "courier@example-app.iam.gserviceaccount.com",
+	}))
+}
+
+// ensures that provider tokens are fetched rather than left over from previous runs
+func clearTokens(mb *test.MockBackend) {
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c568c", "channel-token:0cbf4a2c-9e5f-4a87-9a04-a1b2e1c6b3f2")
+}
+
+func TestOutgoing(t *testing.T) {
+	_, fcmCredentials := newFCMCredentials(t)
+	_, apnsKey := newAPNsKey(t)
+
+	fcmChannel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{
+		configProvider:       "fcm",
+		configFCMTitle:       "Example",
+		configFCMCredentials: fcmCredentials,
+	})
+	apnsChannel := test.NewMockChannel("0cbf4a2c-9e5f-4a87-9a04-a1b2e1c6b3f2", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{
+		configProvider: "apns",
+		"apns_key_id":  "ABC123DEFG",
+		"apns_team_id": "DEF123GHIJ",
+		configAPNsKey:  apnsKey,
+		"apns_topic":   "com.example.app",
+		"apns_sandbox": true,
+	})
+	expoChannel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{
+		configProvider:        "expo",
+		configExpoAccessToken: "sesame",
+	})
+	noProviderChannel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{})
+
+	RunOutgoingTestCases(t, fcmChannel, newHandler(), fcmOutgoingCases, []string{fcmCredentials}, clearTokens)
+	RunOutgoingTestCases(t, apnsChannel, newHandler(), apnsOutgoingCases, []string{apnsKey}, clearTokens)
+	RunOutgoingTestCases(t, expoChannel, newHandler(), expoOutgoingCases, []string{"sesame"}, nil)
+	RunOutgoingTestCases(t, noProviderChannel, newHandler(), noProviderOutgoingCases, nil, nil)
+}
+
+func TestFetchAccessToken(t *testing.T) {
+	ctx := context.Background()
+	h := newHandler().(*handler)
+
+	apnsKey, apnsKeyPEM := newAPNsKey(t)
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{
+		configProvider: "apns",
+		"apns_key_id":  "ABC123DEFG",
+		"apns_team_id": "DEF123GHIJ",
+		configAPNsKey:  apnsKeyPEM,
+	})
+
+	signed, _, err := h.FetchAccessToken(ctx, channel, nil)
+	assert.NoError(t, err)
+
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (any, error) { return &apnsKey.PublicKey, nil }, jwt.WithValidMethods([]string{"ES256"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "DEF123GHIJ", token.Claims.(jwt.MapClaims)["iss"])
+
+	fcmKey, fcmCredentials := newFCMCredentials(t)
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{
+		configProvider:       "fcm",
+		configFCMCredentials: fcmCredentials,
+	})
+
+	signed, expires, err := h.FetchAccessToken(ctx, channel, nil)
+	assert.NoError(t, err)
+	assert.Greater(t, expires, 50*time.Minute)
+
+	token, err = jwt.Parse(signed, func(token *jwt.Token) (any, error) { return &fcmKey.PublicKey, nil }, jwt.WithValidMethods([]string{"RS256"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "courier@example-app.iam.gserviceaccount.com", token.Claims.(jwt.MapClaims)["iss"])
+
+	// invalid credentials or no provider are config errors
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{configProvider: "fcm", configFCMCredentials: "{}"})
+	_, _, err = h.FetchAccessToken(ctx, channel, nil)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{})
+	_, _, err = h.FetchAccessToken(ctx, channel, nil)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+}
+
+func TestExpoReceipts(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "APP", "", "", []string{courier.AppScheme.Prefix}, map[string]any{configProvider: "expo"})
+
+	mb := test.NewMockBackend()
+	h := newHandler().(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "expo-tickets:APP")
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://exp.host/--/api/v2/push/send": {
+			httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"ok","id":"ticket1"}]}`)),
+		},
+	}))
+
+	msg := test.NewMockMsg(10, "", ch, "app:bob", "Hi Bob", nil).WithURNAuth("ExponentPushToken[bob]")
+	res := &courier.SendResult{}
+	err := h.Send(context.Background(), msg, res, courier.NewChannelLogForSend(msg, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ticket1"}, res.ExternalIDs())
+
+	// ticket is recorded for this channel type's receipts poller
+	count, err := redis.Int(rc.Do("ZCARD", "expo-tickets:APP"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
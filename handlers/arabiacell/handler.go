@@ -64,7 +64,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		form := url.Values{
 			"userName":      []string{username},
 			"password":      []string{password},
@@ -102,6 +102,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			return courier.ErrResponseContent
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
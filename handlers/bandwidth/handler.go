@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
@@ -25,18 +25,28 @@ var (
 const (
 	configAccountID     = "account_id"
 	configApplicationID = "application_id"
+
+	// set from verification events so that the verification progress of a channel's number can be tracked
+	configVerificationStatus = "verification_status"
+	configVerificationReason = "verification_reason"
+	configCampaignStatus     = "campaign_status"
 )
 
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45Z
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
 
 type handler struct {
 	handlers.BaseHandler
+
+	configUpdater courier.ChannelConfigUpdater
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("BW"), "Bandwidth")}
+	return &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("BW"), "Bandwidth")}
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -44,6 +54,12 @@ func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
 	s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, h.statusMessage)
+
+	// verification statuses are saved on the channel so can only be received if our backend can do that
+	var ok bool
+	if h.configUpdater, ok = courier.BackendAs[courier.ChannelConfigUpdater](s.Backend()); ok {
+		s.AddHandlerRoute(h, http.MethodPost, "verification", courier.ChannelLogTypeEventReceive, h.receiveVerification)
+	}
 	return nil
 }
 
@@ -84,8 +100,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	messagePayload := payload[0]
 
 	// create our date from the timestamp
-	// 2017-05-03T06:04:45Z
-	date, err := time.Parse("2006-01-02T15:04:05Z", messagePayload.Message.Time)
+	date, err := timestampFormat.Parse(messagePayload.Message.Time)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", messagePayload.Message.Time))
 	}
@@ -159,8 +174,73 @@ func (h *handler) statusMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
+// toll-free verification and 10DLC campaign status events, e.g.
+//
+//	{"phoneNumber": "+18005551234", "status": "UNVERIFIED", "declineReasonDescription": "Invalid URL", "resubmitAllowed": true}
+//	{"campaignId": "CJEUMDK", "status": "ACTIVE", "description": "Campaign approved"}
+type verificationPayload struct {
+	PhoneNumber              string `json:"phoneNumber"`
+	CampaignID               string `json:"campaignId"`
+	Status                   string `json:"status" validate:"required"`
+	DeclineReasonDescription string `json:"declineReasonDescription"`
+	Description              string `json:"description"`
+}
+
+const (
+	verificationStatusVerified   = "VERIFIED"
+	verificationStatusUnverified = "UNVERIFIED"
+)
+
+// receiveVerification is our HTTP handler function for toll-free verification and 10DLC campaign status events
+func (h *handler) receiveVerification(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	payload := &verificationPayload{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	updates := make(map[string]any, 2)
+
+	if payload.CampaignID != "" {
+		updates[configCampaignStatus] = payload.Status
+	} else if payload.PhoneNumber != "" {
+		updates[configVerificationStatus] = payload.Status
+		updates[configVerificationReason] = payload.DeclineReasonDescription
+
+		// sending from an unverified toll-free number will fail so hold messages until it's verified
+		if payload.Status == verificationStatusUnverified {
+			clog.Error(courier.ErrorExternal(payload.Status, fmt.Sprintf("Toll-free number is not verified: %s", payload.DeclineReasonDescription)))
+
+			if err := h.pauseQueue(ctx, channel, true); err != nil {
+				return nil, err
+			}
+		} else if payload.Status == verificationStatusVerified && channel.StringConfigForKey(configVerificationStatus, "") == verificationStatusUnverified {
+			if err := h.pauseQueue(ctx, channel, false); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("missing phoneNumber or campaignId"))
+	}
+
+	if err := h.configUpdater.UpdateChannelConfig(ctx, channel, updates); err != nil {
+		return nil, err
+	}
+
+	return nil, courier.WriteDataResponse(w, http.StatusOK, "Verification status updated", []any{courier.NewInfoData(fmt.Sprintf("status is %s", payload.Status))})
+}
+
+// pauses or resumes the queue of the given channel, if our backend can do that
+func (h *handler) pauseQueue(ctx context.Context, channel courier.Channel, pause bool) error {
+	if pauser, ok := courier.BackendAs[courier.QueuePauser](h.Backend()); ok {
+		return pauser.PauseQueue(ctx, channel.UUID(), pause)
+	}
+	return nil
+}
+
 type mtPayload struct {
 	ApplicationID string   `json:"applicationId"`
+	CampaignID    string   `json:"campaignId,omitempty"`
 	To            []string `json:"to"`
 	From          string   `json:"from"`
 	Text          string   `json:"text"`
@@ -183,6 +263,17 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
+	// messages from toll-free numbers which have failed verification will be rejected
+	if msg.Channel().StringConfigForKey(configVerificationStatus, "") == verificationStatusUnverified {
+		return courier.ErrChannelUnverified
+	}
+
+	// carriers filter messages from 10DLC numbers which aren't sent as part of a registered campaign
+	campaignID := msg.Channel().StringConfigForKey(courier.ConfigCampaignID, "")
+	if msg.Channel().BoolConfigForKey(courier.ConfigTenDLC, false) && campaignID == "" {
+		return courier.ErrCampaignMissing
+	}
+
 	msgParts := make([]string, 0)
 	if msg.Text() != "" {
 		msgParts = handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLength)
@@ -195,6 +286,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	for i, part := range msgParts {
 		payload := &mtPayload{}
 		payload.ApplicationID = applicationID
+		payload.CampaignID = campaignID
 		payload.To = []string{msg.URN().Path()}
 		payload.From = msg.Channel().Address()
 		payload.Text = part
@@ -2,6 +2,9 @@ package bandwidth
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/nyaruka/courier"
@@ -16,6 +19,7 @@ import (
 const (
 	receiveURL = "/c/bw/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
 	statusURL  = "/c/bw/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+	verifyURL  = "/c/bw/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/verification/"
 )
 
 var helloMsg = `[{
@@ -232,6 +236,42 @@ var incomingCases = []IncomingTestCase{
 		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "14762070468292kw2fuqty55yp2b2", Status: courier.MsgStatusFailed}},
 		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("4432", "forbidden to country")},
 	},
+	{
+		Label:                "Toll-free verified",
+		URL:                  verifyURL,
+		Data:                 `{"phoneNumber": "+18005551234", "status": "VERIFIED", "internalTicketNumber": "acde070d-8c4c-4f0d-9d8a-162843c10333"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `status is VERIFIED`,
+	},
+	{
+		Label:                "Toll-free unverified",
+		URL:                  verifyURL,
+		Data:                 `{"phoneNumber": "+18005551234", "status": "UNVERIFIED", "declineReasonDescription": "Invalid Information - Can't Validate URL", "resubmitAllowed": true}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `status is UNVERIFIED`,
+		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("UNVERIFIED", "Toll-free number is not verified: Invalid Information - Can't Validate URL")},
+	},
+	{
+		Label:                "Campaign status",
+		URL:                  verifyURL,
+		Data:                 `{"campaignId": "CJEUMDK", "status": "ACTIVE", "description": "Campaign approved"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `status is ACTIVE`,
+	},
+	{
+		Label:                "Verification missing status",
+		URL:                  verifyURL,
+		Data:                 `{"phoneNumber": "+18005551234"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: `Field validation for 'Status' failed on the 'required' tag`,
+	},
+	{
+		Label:                "Verification missing number and campaign",
+		URL:                  verifyURL,
+		Data:                 `{"status": "VERIFIED"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: `missing phoneNumber or campaignId`,
+	},
 }
 
 func TestIncoming(t *testing.T) {
@@ -363,6 +403,96 @@ func TestOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, ch, newHandler(), outgoingCases, []string{httpx.BasicAuth("user1", "pass1")}, nil)
 }
 
+func TestOutgoingTenDLC(t *testing.T) {
+	campaignCases := []OutgoingTestCase{
+		{
+			Label:   "Campaign ID",
+			MsgText: "Simple Message",
+			MsgURN:  "tel:+12067791234",
+			MockResponses: map[string][]*httpx.MockResponse{
+				"https://messaging.bandwidth.com/api/v2/users/accound-id/messages": {
+					httpx.NewMockResponse(200, nil, []byte(`{"id": "55555"}`)),
+				},
+			},
+			ExpectedRequests: []ExpectedRequest{
+				{Body: `{"applicationId":"application-id","campaignId":"CJEUMDK","to":["+12067791234"],"from":"+12065550100","text":"Simple Message"}`},
+			},
+			ExpectedExtIDs: []string{"55555"},
+		},
+	}
+
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "BW", "+12065550100", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{courier.ConfigUsername: "user1", courier.ConfigPassword: "pass1", configAccountID: "accound-id", configApplicationID: "application-id", courier.ConfigTenDLC: true, courier.ConfigCampaignID: "CJEUMDK"},
+	)
+	RunOutgoingTestCases(t, ch, newHandler(), campaignCases, []string{httpx.BasicAuth("user1", "pass1")}, nil)
+
+	missingCases := []OutgoingTestCase{
+		{
+			Label:         "Campaign ID missing",
+			MsgText:       "Simple Message",
+			MsgURN:        "tel:+12067791234",
+			ExpectedError: courier.ErrCampaignMissing,
+		},
+	}
+
+	ch = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "BW", "+12065550100", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{courier.ConfigUsername: "user1", courier.ConfigPassword: "pass1", configAccountID: "accound-id", configApplicationID: "application-id", courier.ConfigTenDLC: true},
+	)
+	RunOutgoingTestCases(t, ch, newHandler(), missingCases, []string{httpx.BasicAuth("user1", "pass1")}, nil)
+}
+
+func TestVerification(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "BW", "+18005551234", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{courier.ConfigUsername: "user1", courier.ConfigPassword: "pass1", configAccountID: "accound-id", configApplicationID: "application-id"},
+	)
+	mb.AddChannel(ch)
+
+	s := courier.NewServer(courier.NewDefaultConfig(), mb)
+	newHandler().Initialize(s)
+
+	postVerification := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, verifyURL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		s.Router().ServeHTTP(recorder, req)
+		assert.Equal(t, 200, recorder.Code)
+	}
+
+	isPaused := func() bool {
+		queues, err := mb.Queues(ctx)
+		assert.NoError(t, err)
+		return len(queues) > 0 && queues[0].Paused
+	}
+
+	// a failed verification is saved on the channel and pauses its queue
+	postVerification(`{"phoneNumber": "+18005551234", "status": "UNVERIFIED", "declineReasonDescription": "Invalid URL"}`)
+	assert.Equal(t, "UNVERIFIED", ch.StringConfigForKey(configVerificationStatus, ""))
+	assert.Equal(t, "Invalid URL", ch.StringConfigForKey(configVerificationReason, ""))
+	assert.True(t, isPaused())
+
+	// sending from the channel is held
+	msg := test.NewMockMsg(1, courier.NilMsgUUID, ch, "tel:+12067791234", "hello", nil)
+	err := newHandler().Send(ctx, msg, &courier.SendResult{}, courier.NewChannelLogForSend(msg, nil))
+	assert.Equal(t, courier.ErrChannelUnverified, err)
+
+	// campaign status updates don't affect the verification status
+	postVerification(`{"campaignId": "CJEUMDK", "status": "ACTIVE"}`)
+	assert.Equal(t, "ACTIVE", ch.StringConfigForKey(configCampaignStatus, ""))
+	assert.Equal(t, "UNVERIFIED", ch.StringConfigForKey(configVerificationStatus, ""))
+	assert.True(t, isPaused())
+
+	// once verified, the queue is resumed
+	postVerification(`{"phoneNumber": "+18005551234", "status": "VERIFIED"}`)
+	assert.Equal(t, "VERIFIED", ch.StringConfigForKey(configVerificationStatus, ""))
+	assert.Equal(t, "", ch.StringConfigForKey(configVerificationReason, ""))
+	assert.False(t, isPaused())
+}
+
 func TestBuildAttachmentRequest(t *testing.T) {
 	mb := test.NewMockBackend()
 	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "BW", "2020", "US",
@@ -370,7 +500,7 @@ func TestBuildAttachmentRequest(t *testing.T) {
 		map[string]any{courier.ConfigUsername: "user1", courier.ConfigPassword: "pass1", configAccountID: "accound-id", configApplicationID: "application-id"},
 	)
 
-	bwHandler := &handler{NewBaseHandler(courier.ChannelType("BW"), "Bandwidth")}
+	bwHandler := &handler{BaseHandler: NewBaseHandler(courier.ChannelType("BW"), "Bandwidth")}
 	req, _ := bwHandler.BuildAttachmentRequest(context.Background(), mb, ch, "https://example.org/v1/media/41", nil)
 	assert.Equal(t, "https://example.org/v1/media/41", req.URL.String())
 	assert.Equal(t, "Basic dXNlcjE6cGFzczE=", req.Header.Get("Authorization"))
@@ -1,17 +1,31 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
 )
 
+// DefaultRequestTimeout is how long requests made by handlers can take unless the handler or channel says otherwise
+const DefaultRequestTimeout = 30 * time.Second
+
 var defaultRedactConfigKeys = []string{courier.ConfigAuthToken, courier.ConfigAPIKey, courier.ConfigSecret, courier.ConfigPassword, courier.ConfigSendAuthorization}
 
+// ErrPreviewRequest is returned for all requests made when previewing a send since they aren't actually made
+var ErrPreviewRequest = errors.New("request not made in preview")
+
 // BaseHandler is the base class for most handlers, it just stored the server, name and channel type for the handler
 type BaseHandler struct {
 	channelType        courier.ChannelType
@@ -20,6 +34,7 @@ type BaseHandler struct {
 	backend            courier.Backend
 	uuidChannelRouting bool
 	redactConfigKeys   []string
+	requestTimeout     time.Duration
 }
 
 // NewBaseHandler returns a newly constructed BaseHandler with the passed in parameters
@@ -29,6 +44,7 @@ func NewBaseHandler(channelType courier.ChannelType, name string, options ...fun
 		name:               name,
 		uuidChannelRouting: true,
 		redactConfigKeys:   defaultRedactConfigKeys,
+		requestTimeout:     DefaultRequestTimeout,
 	}
 	for _, o := range options {
 		o(h)
@@ -48,6 +64,13 @@ func WithRedactConfigKeys(keys ...string) func(*BaseHandler) {
 	}
 }
 
+// WithRequestTimeout sets the default timeout of requests made by the handler, e.g. for APIs which take media uploads
+func WithRequestTimeout(timeout time.Duration) func(*BaseHandler) {
+	return func(s *BaseHandler) {
+		s.requestTimeout = timeout
+	}
+}
+
 // SetServer can be used to change the server on a BaseHandler
 func (h *BaseHandler) SetServer(server courier.Server) {
 	h.server = server
@@ -94,6 +117,16 @@ func (h *BaseHandler) RedactValues(ch courier.Channel) []string {
 	return vals
 }
 
+// RequestTimeout returns the timeout for requests made to the given channel, which can be overridden in its config
+func (h *BaseHandler) RequestTimeout(ch courier.Channel) time.Duration {
+	if ch != nil {
+		if secs := ch.IntConfigForKey(courier.ConfigSendTimeout, 0); secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return h.requestTimeout
+}
+
 // GetChannel returns the channel
 func (h *BaseHandler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
 	uuid := courier.ChannelUUID(r.PathValue("uuid"))
@@ -117,19 +150,51 @@ func (h *BaseHandler) RequestHTTPWithClient(client *http.Client, req *http.Reque
 
 	req.Header.Set("User-Agent", fmt.Sprintf("Courier/%s", h.server.Config().Version))
 
-	trace, err := httpx.DoTrace(client, req, nil, h.backend.HttpAccess(), 0)
+	// when previewing a send, requests are logged but never actually made
+	if clog.Type == courier.ChannelLogTypeMsgPreview {
+		return nil, nil, previewRequest(req, clog)
+	}
+
+	timeout := h.RequestTimeout(clog.Channel())
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	trace, err := httpx.DoTrace(client, req.WithContext(ctx), nil, h.backend.HttpAccess(), 0)
 	if trace != nil {
 		clog.HTTP(trace)
 		resp = trace.Response
 		body = trace.ResponseBody
 	}
 	if err != nil {
+		// timeouts are logged so they can be told apart from other connection errors
+		if isTimeout(err) {
+			clog.Error(courier.ErrorRequestTimeout(timeout))
+		}
 		return nil, nil, err
 	}
 
 	return resp, body, nil
 }
 
+// returns whether the given request error is because the request took too long
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// logs the given request without making it
+func previewRequest(req *http.Request, clog *courier.ChannelLog) error {
+	requestTrace, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return err
+	}
+
+	now := dates.Now()
+	clog.HTTP(&httpx.Trace{Request: req, RequestTrace: requestTrace, StartTime: now, EndTime: now})
+
+	return ErrPreviewRequest
+}
+
 // WriteStatusSuccessResponse writes a success response for the statuses
 func (h *BaseHandler) WriteStatusSuccessResponse(ctx context.Context, w http.ResponseWriter, statuses []courier.StatusUpdate) error {
 	return courier.WriteStatusSuccess(w, statuses)
@@ -150,6 +215,64 @@ func (h *BaseHandler) WriteRequestIgnored(ctx context.Context, w http.ResponseWr
 	return courier.WriteIgnored(w, details)
 }
 
+// TextAndAttachments returns the text of the message and its attachments newline delimited like GetTextAndAttachments,
+// but with short links to the attachments if they're enabled for its channel, with links which track clicks in place
+// of all URLs if click tracking is enabled for its channel, and transliterated if smart encoding is enabled for it
+func (h *BaseHandler) TextAndAttachments(msg courier.MsgOut) string {
+	text := h.textAndAttachments(msg)
+	if msg.Channel().BoolConfigForKey(courier.ConfigSmartEncoding, false) {
+		text = TransliterateSMS(text)
+	}
+	return text
+}
+
+func (h *BaseHandler) textAndAttachments(msg courier.MsgOut) string {
+	cfg := h.Server().Config()
+	ch := msg.Channel()
+	track := ch.BoolConfigForKey(courier.ConfigTrackClicks, false)
+	shorten := len(msg.Attachments()) > 0 && ch.BoolConfigForKey(courier.ConfigShortLinks, cfg.ShortLinks)
+	if !track && !shorten {
+		return GetTextAndAttachments(msg)
+	}
+
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	ttl := time.Duration(cfg.ShortLinkTTL) * 24 * time.Hour
+
+	// if we can't replace a URL we're better off sending it as is than not at all
+	link := func(url string) string {
+		var token string
+		var err error
+		if track {
+			token, err = courier.TrackURL(rc, url, msg, ttl)
+		} else {
+			token, err = courier.ShortenURL(rc, url, ttl)
+		}
+		if err != nil {
+			slog.Error("error creating short link", "error", err, "channel_uuid", ch.UUID())
+			return url
+		}
+		return courier.ShortLinkURL(cfg, ch, token)
+	}
+
+	text := msg.Text()
+	if track {
+		text = urlRegex.ReplaceAllStringFunc(text, func(url string) string {
+			trimmed := strings.TrimRight(url, ".,") // URLs at the end of sentences
+			return link(trimmed) + url[len(trimmed):]
+		})
+	}
+
+	buf := bytes.NewBufferString(text)
+	for _, a := range msg.Attachments() {
+		_, url := SplitAttachment(a)
+		buf.WriteString("\n")
+		buf.WriteString(link(url))
+	}
+	return buf.String()
+}
+
 // WithRedisConn is a utility to execute some code with a redis connection
 func (h *BaseHandler) WithRedisConn(fn func(rc redis.Conn)) {
 	rc := h.Backend().RedisPool().Get()
@@ -2,12 +2,17 @@ package handlers_test
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/random"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
@@ -53,3 +58,134 @@ func TestRequestHTTP(t *testing.T) {
 	assert.Equal(t, 400, hlog2.StatusCode)
 	assert.Equal(t, "https://api.messages.com/send.json", hlog2.URL)
 }
+
+func TestRequestHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	mc2 := test.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "NX", "1235", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigSendTimeout: 45})
+
+	h := handlers.NewBaseHandler("NX", "Test")
+	assert.Equal(t, handlers.DefaultRequestTimeout, h.RequestTimeout(mc))
+	assert.Equal(t, 45*time.Second, h.RequestTimeout(mc2))
+	assert.Equal(t, handlers.DefaultRequestTimeout, h.RequestTimeout(nil))
+
+	h = handlers.NewBaseHandler("NX", "Test", handlers.WithRequestTimeout(50*time.Millisecond))
+	h.SetServer(test.NewMockServer(courier.NewDefaultConfig(), mb))
+	assert.Equal(t, 50*time.Millisecond, h.RequestTimeout(mc))
+	assert.Equal(t, 45*time.Second, h.RequestTimeout(mc2))
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mc, nil)
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	_, _, err := h.RequestHTTP(req, clog)
+	assert.Error(t, err)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorRequestTimeout(50 * time.Millisecond)}, clog.Errors)
+	assert.Len(t, clog.HttpLogs, 1)
+
+	// channel allows longer so request succeeds
+	clog = courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mc2, nil)
+
+	req, _ = http.NewRequest("POST", server.URL, nil)
+	resp, _, err := h.RequestHTTP(req, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Len(t, clog.Errors, 0)
+}
+
+func TestRequestHTTPPreview(t *testing.T) {
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgPreview, mc, []string{"sesame"})
+
+	config := courier.NewDefaultConfig()
+	server := test.NewMockServer(config, mb)
+
+	h := handlers.NewBaseHandler("NX", "Test")
+	h.SetServer(server)
+
+	// no mocked responses so request would fail if made
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{}))
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	req, _ := http.NewRequest("POST", "https://api.messages.com/send.json", strings.NewReader(`{"text":"hi"}`))
+	req.Header.Set("Authorization", "Token sesame")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	assert.Equal(t, handlers.ErrPreviewRequest, err)
+	assert.Nil(t, resp)
+	assert.Nil(t, respBody)
+
+	// but request is still logged
+	if assert.Len(t, clog.HttpLogs, 1) {
+		assert.Equal(t, "https://api.messages.com/send.json", clog.HttpLogs[0].URL)
+		assert.Equal(t, 0, clog.HttpLogs[0].StatusCode)
+		assert.Contains(t, clog.HttpLogs[0].Request, `{"text":"hi"}`)
+		assert.Contains(t, clog.HttpLogs[0].Request, "Authorization: Token **********")
+	}
+}
+
+func TestTextAndAttachments(t *testing.T) {
+	random.SetGenerator(random.NewSeededGenerator(123))
+	defer random.SetGenerator(random.DefaultGenerator)
+
+	mb := test.NewMockBackend()
+	mc1 := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	mc2 := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "NX", "1235", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigShortLinks: false})
+
+	config := courier.NewDefaultConfig()
+	config.Domain = "courier.example.com"
+	config.ShortLinks = true
+	server := test.NewMockServer(config, mb)
+
+	h := handlers.NewBaseHandler("NX", "Test")
+	h.SetServer(server)
+
+	attachments := []string{"image/jpeg:https://s3.amazonaws.com/attachments/2/3a2c5a7b-f1f2-4a3f-8e0b-0f0b2f4d6e8c/photo.jpg", "audio/mp3:https://s3.amazonaws.com/attachments/2/1b6f8c0d-0a4e-4a4b-9b3f-6c5b7d8a9e0f/sound.mp3"}
+
+	msg1 := test.NewMockMsg(123, "", mc1, "tel:+1234", "Look", attachments)
+	assert.Equal(t, "Look\nhttps://courier.example.com/m/BLP7RVN3hb\nhttps://courier.example.com/m/l6MN05bxuc", h.TextAndAttachments(msg1))
+
+	// same URLs get the same short links
+	assert.Equal(t, "Look\nhttps://courier.example.com/m/BLP7RVN3hb\nhttps://courier.example.com/m/l6MN05bxuc", h.TextAndAttachments(msg1))
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	link, err := courier.ResolveShortLink(rc, "BLP7RVN3hb")
+	assert.NoError(t, err)
+	assert.Equal(t, &courier.ShortLink{URL: "https://s3.amazonaws.com/attachments/2/3a2c5a7b-f1f2-4a3f-8e0b-0f0b2f4d6e8c/photo.jpg"}, link)
+	assert.False(t, link.IsTracked())
+
+	link, err = courier.ResolveShortLink(rc, "xxxxxxxxxx")
+	assert.NoError(t, err)
+	assert.Nil(t, link)
+
+	// channels can opt out
+	msg2 := test.NewMockMsg(124, "", mc2, "tel:+1234", "Look", attachments)
+	assert.Equal(t, handlers.GetTextAndAttachments(msg2), h.TextAndAttachments(msg2))
+
+	// and messages without attachments are unchanged
+	msg3 := test.NewMockMsg(125, "", mc1, "tel:+1234", "Hi https://nyaruka.com", nil)
+	assert.Equal(t, "Hi https://nyaruka.com", h.TextAndAttachments(msg3))
+
+	// channels can track clicks on all links in messages
+	mc3 := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "NX", "1236", "EC", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigTrackClicks: true, courier.ConfigShortLinks: false})
+
+	msg4 := test.NewMockMsg(126, "", mc3, "tel:+1234", "Visit https://nyaruka.com/pricing?plan=pro or www.textit.com.", attachments[:1])
+	assert.Equal(t, "Visit https://courier.example.com/m/s8wHxSJqUM or www.textit.com.\nhttps://courier.example.com/m/2wIFP6UTHn", h.TextAndAttachments(msg4))
+
+	link, err = courier.ResolveShortLink(rc, "s8wHxSJqUM")
+	assert.NoError(t, err)
+	assert.Equal(t, &courier.ShortLink{URL: "https://nyaruka.com/pricing?plan=pro", ChannelUUID: mc3.UUID(), MsgID: 126, URN: "tel:+1234"}, link)
+	assert.True(t, link.IsTracked())
+
+	// tracked links are per message
+	msg5 := test.NewMockMsg(127, "", mc3, "tel:+1234", "Visit https://nyaruka.com/pricing?plan=pro", nil)
+	assert.Equal(t, "Visit https://courier.example.com/m/Sczb1ucoXV", h.TextAndAttachments(msg5))
+}
@@ -133,7 +133,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 	for _, part := range parts {
 		form := url.Values{
 			"USERNAME":   []string{username},
@@ -174,6 +174,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		if externalID != "" {
 			res.AddExternalID(externalID)
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -63,7 +63,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		form := url.Values{
 			"to":      []string{strings.TrimLeft(msg.URN().Path(), "+")},
 			"from":    []string{msg.Channel().Address()},
@@ -96,6 +96,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			return courier.ErrResponseContent
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 	"unicode/utf16"
 	"unicode/utf8"
 
@@ -23,6 +22,9 @@ var (
 	sendURL      = "https://platform.clickatell.com/messages/http/send"
 )
 
+// error code returned for messages which can't be sent because the account has no credit left
+const errorCodeNoCredit = 301
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -98,7 +100,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 			fmt.Errorf("missing one of 'messageId', 'fromNumber', 'text' or 'timestamp' in request body"))
 	}
 
-	date := time.Unix(0, payload.Timestamp*1000000)
+	date := handlers.ParseUnixTimestamp(payload.Timestamp, handlers.TimestampMillis)
 
 	text := payload.Text
 	if payload.Charset == "UTF-16BE" {
@@ -129,7 +131,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.MessageID, clog).WithReceivedOn(date.UTC())
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.MessageID, clog).WithReceivedOn(date)
 
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
@@ -160,7 +162,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 	for _, part := range parts {
 		form := url.Values{
 			"apiKey":  []string{apiKey},
@@ -179,6 +181,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		resp, respBody, err := h.RequestHTTP(req, clog)
 		if err != nil || resp.StatusCode/100 == 5 {
 			return courier.ErrConnectionFailed
+		} else if errorCode, _ := jsonparser.GetInt(respBody, "messages", "[0]", "errorCode"); errorCode == errorCodeNoCredit {
+			return courier.ErrInsufficientBalance
 		} else if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
@@ -190,6 +194,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			return courier.ErrResponseContent
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -167,7 +167,8 @@ var outgoingCases = []OutgoingTestCase{
 		ExpectedRequests: []ExpectedRequest{
 			{Params: url.Values{"content": {"Simple Message"}, "to": {"250788383383"}, "from": {"2020"}, "apiKey": {"API-KEY"}}},
 		},
-		ExpectedExtIDs: []string{"id1002"},
+		ExpectedExtIDs:   []string{"id1002"},
+		ExpectedSegments: 1,
 	},
 	{
 		Label:   "Unicode Send",
@@ -181,7 +182,8 @@ var outgoingCases = []OutgoingTestCase{
 		ExpectedRequests: []ExpectedRequest{
 			{Params: url.Values{"content": {"Unicode ☺"}, "to": {"250788383383"}, "from": {"2020"}, "apiKey": {"API-KEY"}}},
 		},
-		ExpectedExtIDs: []string{"id1002"},
+		ExpectedExtIDs:   []string{"id1002"},
+		ExpectedSegments: 1,
 	},
 	{
 		Label:          "Send Attachment",
@@ -212,6 +214,20 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrResponseStatus,
 	},
+	{
+		Label:   "No Credit",
+		MsgText: "No credit",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://platform.clickatell.com/messages/http/send*": {
+				httpx.NewMockResponse(200, nil, []byte(`{"messages":[{"apiMessageId":null,"accepted":false,"to":"250788383383","errorCode":301,"error":"No credit left","errorDescription":"Insufficient account balance."}],"error":null}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Params: url.Values{"content": {"No credit"}, "to": {"250788383383"}, "from": {"2020"}, "apiKey": {"API-KEY"}}},
+		},
+		ExpectedError: courier.ErrInsufficientBalance,
+	},
 	{
 		Label:   "Error Response",
 		MsgText: "Error Message",
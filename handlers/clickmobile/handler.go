@@ -109,7 +109,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 	cmSendURL := msg.Channel().StringConfigForKey(courier.ConfigSendURL, sendURL)
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 
 		timestamp := dates.Now().UTC().Format("20060102150405")
 
@@ -148,6 +148,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		if responseCode != "000" {
 			return courier.ErrResponseContent
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+// ConformanceCheck is a behavior which every handler is expected to have
+type ConformanceCheck string
+
+// the certification checklist for handlers. URN validation and dedupe are also checked by RunIncomingTestCases against
+// each handler's own test cases, as the standardized requests used here never contain valid messages.
+const (
+	CheckRoutes        ConformanceCheck = "routes"         // registers at least one route for incoming requests
+	CheckPanicSafety   ConformanceCheck = "panic_safety"   // doesn't panic on malformed requests or unexpected responses from the provider
+	CheckErrorMapping  ConformanceCheck = "error_mapping"  // responds to malformed requests with a non-5xx, and fails sends which get a 5xx or no response
+	CheckURNValidation ConformanceCheck = "urn_validation" // never writes messages or events without a valid URN
+	CheckDedupe        ConformanceCheck = "dedupe"         // doesn't write the same message twice when a request is retried
+	CheckRedaction     ConformanceCheck = "redaction"      // never writes the channel's secrets to channel logs, even when base64 encoded
+	CheckAttachments   ConformanceCheck = "attachments"    // can send messages with attachments of any type
+)
+
+// ConformanceChecklist is every check, in the order they're run
+var ConformanceChecklist = []ConformanceCheck{CheckRoutes, CheckPanicSafety, CheckErrorMapping, CheckURNValidation, CheckDedupe, CheckRedaction, CheckAttachments}
+
+const conformanceChannelUUID = "8eb23e93-5ecb-45ba-b726-3b064e0c56ab"
+
+// the config of the channel used for checks, where every secret has a value we can look for in channel logs
+// the standard channel config given to every handler, which includes the keys required by most handlers so that they
+// get as far as making requests. Values prefixed with conformance-secret- must never appear in channel logs.
+var conformanceConfig = map[string]any{
+	courier.ConfigUsername:          "conformance-username",
+	courier.ConfigPassword:          "conformance-secret-password",
+	courier.ConfigAuthToken:         "conformance-secret-auth-token",
+	courier.ConfigAPIKey:            "conformance-secret-api-key",
+	courier.ConfigSecret:            "conformance-secret-secret",
+	courier.ConfigSendAuthorization: "conformance-secret-send-authorization",
+	courier.ConfigSendURL:           "http://conformance.example.com/send",
+	courier.ConfigBaseURL:           "http://conformance.example.com",
+	courier.ConfigMaxLength:         160,
+
+	"account_id":            "conformance-account-id",
+	"account_sid":           "conformance-account-sid",
+	"api_token":             "conformance-secret-api-token",
+	"api_token_user":        "conformance-api-token-user",
+	"app_id":                "conformance-app-id",
+	"app_password":          "conformance-secret-app-password",
+	"app_secret":            "conformance-secret-app-secret",
+	"application_id":        "conformance-application-id",
+	"bot_token":             "conformance-secret-bot-token",
+	"bot_username":          "conformance-bot",
+	"carrier_id":            1,
+	"channel_hash":          "conformance-channel-hash",
+	"charging_level":        "0",
+	"corp_id":               "conformance-corp-id",
+	"corp_secret":           "conformance-secret-corp-secret",
+	"agent_id":              "1",
+	"instance_id":           1,
+	"macrokiosk_sender_id":  "conformance-sender",
+	"macrokiosk_service_id": "conformance-service",
+	"merchant_id":           "conformance-merchant",
+	"merchant_secret":       "conformance-secret-merchant-secret",
+	"nexmo_api_key":         "conformance-nexmo-key",
+	"nexmo_api_secret":      "conformance-secret-nexmo-api-secret",
+	"org_id":                "conformance-org-id",
+	"passphrase":            "conformance-secret-passphrase",
+	"private_key":           "conformance-secret-private-key",
+	"public_key":            "conformance-public-key",
+	"refresh_token":         "conformance-secret-refresh-token",
+	"sender_key":            "conformance-sender-key",
+	"service_id":            "conformance-service-id",
+	"service_plan_id":       "conformance-service-plan",
+	"FCM_KEY":               "conformance-secret-fcm-key",
+	"FCM_TITLE":             "conformance-title",
+	"PLIVO_APP_ID":          "conformance-plivo-app",
+	"PLIVO_AUTH_ID":         "conformance-plivo-auth-id",
+	"PLIVO_AUTH_TOKEN":      "conformance-secret-plivo-auth-token",
+}
+
+// conformanceSecrets are the values in the standard config which handlers must redact
+var conformanceSecrets = func() []string {
+	secrets := make([]string, 0, len(conformanceConfig))
+	for _, v := range conformanceConfig {
+		if s, ok := v.(string); ok && strings.HasPrefix(s, "conformance-secret-") {
+			secrets = append(secrets, s)
+		}
+	}
+	slices.Sort(secrets)
+	return secrets
+}()
+
+// conformanceRequest is a standardized malformed request sent to every route of a handler
+type conformanceRequest struct {
+	label       string
+	contentType string
+	query       string
+	body        string
+}
+
+var conformanceRequests = []conformanceRequest{
+	{label: "empty"},
+	{label: "malformed JSON", contentType: "application/json", body: `{"id": "123", "text": `},
+	{label: "JSON null", contentType: "application/json", body: `null`},
+	{label: "JSON with null fields", contentType: "application/json", body: `{"id": null, "from": null, "to": null, "text": null, "message": null, "messages": [null], "entry": [{"changes": [null], "messaging": [null]}], "data": null, "results": [null], "events": [null], "statuses": [null]}`},
+	{label: "JSON with wrong types", contentType: "application/json", body: `{"id": {}, "from": [], "to": 1, "text": 123, "message": "hi", "messages": "hi", "entry": "hi", "data": [1], "results": {}, "events": 1, "statuses": true}`},
+	{label: "malformed form", contentType: "application/x-www-form-urlencoded", query: "from=%zz&text[]=hi", body: "from=%zz&&=&text=%"},
+	{label: "empty form values", contentType: "application/x-www-form-urlencoded", query: "from=&to=&text=&id=&status=", body: "from=&to=&text=&id=&status="},
+	{label: "malformed XML", contentType: "text/xml", body: `<?xml version="1.0"?><message><from>`},
+	{label: "binary", contentType: "application/octet-stream", body: "\x00\xff\xfe\x80\x00{\"id\""},
+}
+
+// conformanceResponse is a standardized response from a provider to a send request
+type conformanceResponse struct {
+	label  string
+	status int // 0 for a connection error
+	body   string
+}
+
+var conformanceResponses = []conformanceResponse{
+	{label: "connection error"},
+	{label: "server error", status: 500, body: `{"error": "internal error"}`},
+	{label: "empty", status: 200},
+	{label: "empty JSON", status: 200, body: `{}`},
+	{label: "JSON null", status: 200, body: `null`},
+	{label: "JSON array", status: 200, body: `[]`},
+	{label: "JSON with wrong types", status: 200, body: `{"id": {}, "message_id": [], "messages": "x", "data": 1, "results": {}, "status": [], "error": 1}`},
+	{label: "text", status: 200, body: `OK`},
+}
+
+var conformanceAttachments = []string{
+	"image/jpeg:https://conformance.example.com/image.jpg",
+	"audio/mp4:https://conformance.example.com/audio.m4a",
+	"video/mp4:https://conformance.example.com/video.mp4",
+	"application/pdf:https://conformance.example.com/document.pdf",
+	"application/octet-stream:https://conformance.example.com/file",
+	"geo:-2.90875,-79.0117686",
+}
+
+// RunConformanceChecks runs the checklist against the given handler, other than the given exempted checks
+func RunConformanceChecks(t *testing.T, handler courier.ChannelHandler, exempt ...ConformanceCheck) {
+	mb := test.NewMockBackend()
+	ch := test.NewMockChannel(conformanceChannelUUID, string(handler.ChannelType()), "2020", "US", []string{urns.Phone.Prefix}, conformanceConfig)
+	mb.AddChannel(ch)
+
+	s := &conformanceServer{Server: newServer(mb)}
+	if err := handler.Initialize(s); err != nil {
+		t.Errorf("error initializing handler: %s", err)
+		return
+	}
+
+	isExempt := func(c ConformanceCheck) bool {
+		for _, e := range exempt {
+			if e == c {
+				return true
+			}
+		}
+		return false
+	}
+	check := func(c ConformanceCheck, f func(t *testing.T)) {
+		if isExempt(c) {
+			t.Run(string(c), func(t *testing.T) { t.Skip("handler is exempt") })
+			return
+		}
+		t.Run(string(c), f)
+	}
+
+	check(CheckRoutes, func(t *testing.T) {
+		assert.NotEmpty(t, s.routes, "no routes registered")
+	})
+
+	// send every malformed request to every route and record what happened
+	results := make([]*conformanceResult, 0, len(s.routes)*len(conformanceRequests))
+	for _, route := range s.routes {
+		for _, req := range conformanceRequests {
+			results = append(results, s.send(mb, route, req))
+		}
+	}
+
+	check(CheckPanicSafety, func(t *testing.T) {
+		for _, r := range results {
+			if r.panic != nil {
+				t.Errorf("panic handling %s: %s", r, r.panic)
+			}
+		}
+
+		for _, resp := range conformanceResponses {
+			msg := conformanceMsg(mb, ch, resp.label, conformanceAttachments[:1])
+			if _, p := conformanceSend(handler, msg, resp); p != nil {
+				t.Errorf("panic sending with %s response: %s", resp.label, p)
+			}
+		}
+	})
+
+	check(CheckErrorMapping, func(t *testing.T) {
+		for _, r := range results {
+			assert.Less(t, r.status, 500, "unexpected status handling %s", r)
+			assert.Zero(t, r.msgs, "message written handling %s", r)
+		}
+
+		for _, resp := range conformanceResponses {
+			if resp.status == 0 || resp.status >= 500 {
+				msg := conformanceMsg(mb, ch, resp.label, nil)
+				clog, p := conformanceSend(handler, msg, resp)
+				if p == nil {
+					assert.Error(t, clog.err, "send didn't fail with %s response", resp.label)
+				}
+			}
+		}
+	})
+
+	check(CheckURNValidation, func(t *testing.T) {
+		for _, r := range results {
+			for _, urn := range r.urns {
+				assert.NoError(t, courier.ValidateURN(urn), "invalid URN written handling %s", r)
+			}
+		}
+	})
+
+	check(CheckDedupe, func(t *testing.T) {
+		// a request which writes nothing the first time shouldn't write anything when retried either
+		for _, r := range results {
+			if r.msgs == 0 && r.panic == nil {
+				retry := s.send(mb, r.route, r.request)
+				assert.Zero(t, retry.msgs, "message written retrying %s", r)
+			}
+		}
+	})
+
+	check(CheckRedaction, func(t *testing.T) {
+		for _, resp := range conformanceResponses {
+			msg := conformanceMsg(mb, ch, resp.label, nil)
+			clog, _ := conformanceSend(handler, msg, resp)
+			assertConformanceRedaction(t, clog.ChannelLog)
+		}
+		for _, r := range results {
+			if r.clog != nil {
+				assertConformanceRedaction(t, r.clog)
+			}
+		}
+	})
+
+	check(CheckAttachments, func(t *testing.T) {
+		for _, a := range conformanceAttachments {
+			msg := conformanceMsg(mb, ch, "see attachment", []string{a})
+			if _, p := conformanceSend(handler, msg, conformanceResponses[3]); p != nil {
+				t.Errorf("panic sending attachment %s: %s", a, p)
+			}
+		}
+	})
+}
+
+type conformanceRoute struct {
+	method string
+	path   string
+}
+
+// conformanceServer is a server which records the routes added by handlers and catches panics in their handler funcs
+type conformanceServer struct {
+	courier.Server
+
+	routes []*conformanceRoute
+	panic  *conformancePanic
+	mutex  sync.Mutex
+}
+
+func (s *conformanceServer) AddHandlerRoute(handler courier.ChannelHandler, method string, action string, logType clogs.LogType, handlerFunc courier.ChannelHandleFunc) {
+	path := "/c/" + strings.ToLower(string(handler.ChannelType()))
+	if handler.UseChannelRouteUUID() {
+		path += "/" + conformanceChannelUUID
+	}
+	if action != "" {
+		path += "/" + action
+	}
+	s.routes = append(s.routes, &conformanceRoute{method: method, path: path})
+
+	s.Server.AddHandlerRoute(handler, method, action, logType, func(ctx context.Context, ch courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) (events []courier.Event, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				s.mutex.Lock()
+				s.panic = newConformancePanic(p)
+				s.mutex.Unlock()
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return handlerFunc(ctx, ch, w, r, clog)
+	})
+}
+
+type conformanceResult struct {
+	route   *conformanceRoute
+	request conformanceRequest
+	status  int
+	panic   *conformancePanic
+	msgs    int
+	urns    []urns.URN
+	clog    *courier.ChannelLog
+}
+
+func (r *conformanceResult) String() string {
+	return fmt.Sprintf("%s request to %s %s", r.request.label, r.route.method, r.route.path)
+}
+
+// sends the given request to the given route
+func (s *conformanceServer) send(mb *test.MockBackend, route *conformanceRoute, req conformanceRequest) *conformanceResult {
+	mb.Reset()
+	s.panic = nil
+
+	u := route.path
+	if req.query != "" {
+		u += "?" + req.query
+	}
+
+	r := httptest.NewRequest(route.method, u, strings.NewReader(req.body))
+	if req.contentType != "" {
+		r.Header.Set("Content-Type", req.contentType)
+	}
+	w := httptest.NewRecorder()
+
+	// handlers shouldn't make requests to anything but the provider, which always fails here
+	httpx.SetRequestor(&conformanceRequestor{})
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	s.Router().ServeHTTP(w, r)
+
+	result := &conformanceResult{route: route, request: req, status: w.Code, panic: s.panic, msgs: len(mb.WrittenMsgs())}
+	for _, m := range mb.WrittenMsgs() {
+		result.urns = append(result.urns, m.URN())
+	}
+	for _, e := range mb.WrittenChannelEvents() {
+		result.urns = append(result.urns, e.URN())
+	}
+	if logs := mb.WrittenChannelLogs(); len(logs) > 0 {
+		result.clog = logs[0]
+	}
+	return result
+}
+
+func conformanceMsg(mb *test.MockBackend, ch courier.Channel, text string, attachments []string) courier.MsgOut {
+	m := mb.NewOutgoingMsg(ch, 10, "tel:+12065551212", text, false, []string{"Yes", "No"}, "", "", courier.MsgOriginFlow, nil).(*test.MockMsg)
+	for _, a := range attachments {
+		m.WithAttachment(a)
+	}
+	return m
+}
+
+type conformanceSendLog struct {
+	*courier.ChannelLog
+
+	err error
+}
+
+// sends the given message with the provider giving the given response to every request
+func conformanceSend(handler courier.ChannelHandler, msg courier.MsgOut, resp conformanceResponse) (clog *conformanceSendLog, p *conformancePanic) {
+	clog = &conformanceSendLog{ChannelLog: courier.NewChannelLogForSend(msg, handler.RedactValues(msg.Channel()))}
+
+	httpx.SetRequestor(&conformanceRequestor{response: &resp})
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			p = newConformancePanic(r)
+		}
+	}()
+
+	clog.err = handler.Send(ctx, msg, &courier.SendResult{}, clog.ChannelLog)
+	return clog, nil
+}
+
+// conformanceRequestor gives the same response to every request
+type conformanceRequestor struct {
+	response *conformanceResponse
+}
+
+func (r *conformanceRequestor) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if r.response == nil || r.response.status == 0 {
+		return nil, errors.New("unable to connect to server")
+	}
+	return httpx.NewMockResponse(r.response.status, map[string]string{"Content-Type": "application/json"}, []byte(r.response.body)).Make(req), nil
+}
+
+// conformancePanic is a recovered panic and where in the handler it happened
+type conformancePanic struct {
+	value    any
+	location string
+}
+
+func newConformancePanic(v any) *conformancePanic {
+	// find the first frame of the stack which is in a handler package
+	location := "unknown"
+	for _, line := range strings.Split(string(debug.Stack()), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "/handlers/") && !strings.Contains(line, "/handlers/conformance.go") && strings.Contains(line, ".go:") {
+			location = line[strings.Index(line, "/handlers/")+1:]
+			break
+		}
+	}
+	return &conformancePanic{value: v, location: location}
+}
+
+func (p *conformancePanic) String() string { return fmt.Sprintf("%v at %s", p.value, p.location) }
+
+var basicAuthRegex = regexp.MustCompile(`(?i)Authorization: Basic ([A-Za-z0-9+/=]+)`)
+
+// asserts that the given channel log doesn't contain any of our secrets, either as is or in basic auth
+func assertConformanceRedaction(t *testing.T, clog *courier.ChannelLog) {
+	AssertChannelLogRedaction(t, clog, conformanceSecrets)
+
+	for _, h := range clog.HttpLogs {
+		for _, m := range basicAuthRegex.FindAllStringSubmatch(h.Request, -1) {
+			decoded, _ := base64.StdEncoding.DecodeString(m[1])
+			for _, s := range conformanceSecrets {
+				assert.NotContains(t, string(decoded), s, "basic auth in request to %s contains redacted value '%s'", h.URL, s)
+			}
+		}
+	}
+}
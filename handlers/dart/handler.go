@@ -152,7 +152,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), h.maxLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), h.maxLength)
 	for i, part := range parts {
 		form := url.Values{
 			"userid":   []string{username},
@@ -192,6 +192,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			return courier.ErrFailedWithReason(responseCode, errorCodes[responseCode])
 		}
 
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
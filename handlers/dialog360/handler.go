@@ -9,7 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -28,6 +28,9 @@ const (
 var (
 	// max for the body
 	maxMsgLength = 4096
+
+	// max for media captions, longer text is sent as a separate message
+	maxCaptionLength = 1024
 )
 
 func init() {
@@ -131,7 +134,7 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				if err != nil {
 					return nil, nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("invalid timestamp: %s", msg.Timestamp))
 				}
-				date := time.Unix(ts, 0).UTC()
+				date := handlers.ParseUnixTimestamp(ts, handlers.TimestampSeconds)
 
 				urn, err := urns.New(urns.WhatsApp, msg.From)
 				if err != nil {
@@ -179,6 +182,10 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				// create our message
 				event := h.Backend().NewIncomingMsg(channel, urn, text, msg.ID, clog).WithReceivedOn(date).WithContactName(contactNames[msg.From])
 
+				if forwarded := msg.Forwarded(); forwarded != nil {
+					event.WithMetadata(jsonx.MustMarshal(map[string]any{"forwarded": forwarded}))
+				}
+
 				// we had an error downloading media
 				if err != nil {
 					courier.LogRequestError(r, channel, err)
@@ -312,7 +319,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	if msg.Templating() != nil {
 		payload := whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path()}
 		payload.Type = "template"
-		payload.Template = whatsapp.GetTemplatePayload(msg.Templating())
+		payload.Template = whatsapp.GetTemplatePayload(msg.Templating(), msg.Locale())
 
 		err := h.requestD3C(payload, accessToken, res, sendURL, clog)
 		if err != nil {
@@ -328,11 +335,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 				if i < (len(msgParts) + len(msg.Attachments()) - 1) {
 					// this is still a msg part
-					text := &whatsapp.Text{PreviewURL: false}
+					text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 					payload.Type = "text"
-					if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-						text.PreviewURL = true
-					}
 					text.Body = msgParts[i-len(msg.Attachments())]
 					payload.Text = text
 				} else {
@@ -359,11 +363,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 								btns[i].Reply.ID = fmt.Sprint(i)
 								btns[i].Reply.Title = qr
 							}
-							interactive.Action = &struct {
-								Button   string             "json:\"button,omitempty\""
-								Sections []whatsapp.Section "json:\"sections,omitempty\""
-								Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-							}{Buttons: btns}
+							interactive.Action = &whatsapp.Action{Buttons: btns}
 							payload.Interactive = &interactive
 						} else {
 							interactive := whatsapp.Interactive{Type: "list", Body: struct {
@@ -380,11 +380,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 								}
 							}
 
-							interactive.Action = &struct {
-								Button   string             "json:\"button,omitempty\""
-								Sections []whatsapp.Section "json:\"sections,omitempty\""
-								Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-							}{Button: menuButton, Sections: []whatsapp.Section{
+							interactive.Action = &whatsapp.Action{Button: menuButton, Sections: []whatsapp.Section{
 								section,
 							}}
 
@@ -392,11 +388,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 						}
 					} else {
 						// this is still a msg part
-						text := &whatsapp.Text{PreviewURL: false}
+						text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 						payload.Type = "text"
-						if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-							text.PreviewURL = true
-						}
 						text.Body = msgParts[i-len(msg.Attachments())]
 						payload.Text = text
 					}
@@ -411,7 +404,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 				payload.Type = attType
 				media := whatsapp.Media{Link: attURL}
 
-				if len(msgParts) == 1 && attType != "audio" && len(msg.Attachments()) == 1 && len(msg.QuickReplies()) == 0 {
+				if len(msgParts) == 1 && utf8.RuneCountInString(msgParts[0]) <= maxCaptionLength && attType != "audio" && len(msg.Attachments()) == 1 && len(msg.QuickReplies()) == 0 {
 					media.Caption = msgParts[i]
 					hasCaption = true
 				}
@@ -512,11 +505,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 							btns[i].Reply.ID = fmt.Sprint(i)
 							btns[i].Reply.Title = qr
 						}
-						interactive.Action = &struct {
-							Button   string             "json:\"button,omitempty\""
-							Sections []whatsapp.Section "json:\"sections,omitempty\""
-							Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-						}{Buttons: btns}
+						interactive.Action = &whatsapp.Action{Buttons: btns}
 						payload.Interactive = &interactive
 
 					} else {
@@ -534,11 +523,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 							}
 						}
 
-						interactive.Action = &struct {
-							Button   string             "json:\"button,omitempty\""
-							Sections []whatsapp.Section "json:\"sections,omitempty\""
-							Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-						}{Button: menuButton, Sections: []whatsapp.Section{
+						interactive.Action = &whatsapp.Action{Button: menuButton, Sections: []whatsapp.Section{
 							section,
 						}}
 
@@ -546,11 +531,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 					}
 				} else {
 					// this is still a msg part
-					text := &whatsapp.Text{PreviewURL: false}
+					text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 					payload.Type = "text"
-					if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-						text.PreviewURL = true
-					}
 					text.Body = msgParts[i-len(msg.Attachments())]
 					payload.Text = text
 				}
@@ -596,6 +578,10 @@ func (h *handler) requestD3C(payload whatsapp.SendRequest, accessToken string, r
 		return courier.ErrFailedWithReason(strconv.Itoa(respPayload.Error.Code), respPayload.Error.Message)
 	}
 
+	if len(respPayload.Messages) == 0 {
+		return courier.ErrResponseUnexpected
+	}
+
 	externalID := respPayload.Messages[0].ID
 	if externalID != "" {
 		res.AddExternalID(externalID)
@@ -62,6 +62,20 @@ var testCasesD3C = []IncomingTestCase{
 		ExpectedExternalID:    "external_id",
 		ExpectedDate:          time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC),
 	},
+	{
+		Label:                 "Receive Forwarded Message",
+		URL:                   d3CReceiveURL,
+		Data:                  string(test.ReadFile("../meta/testdata/wac/forwarded.json")),
+		ExpectedRespStatus:    200,
+		ExpectedBodyContains:  "Handled",
+		NoQueueErrorCheck:     true,
+		NoInvalidChannelCheck: true,
+		ExpectedMsgText:       Sp("Hello World"),
+		ExpectedMsgMetadata:   `{"forwarded": {"frequently": true}}`,
+		ExpectedURN:           "whatsapp:5678",
+		ExpectedExternalID:    "forwarded_id",
+		ExpectedDate:          time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC),
+	},
 	{
 		Label:                 "Receive Valid Voice Message",
 		URL:                   d3CReceiveURL,
@@ -422,6 +436,47 @@ var SendTestCasesD3C = []OutgoingTestCase{
 		},
 		ExpectedExtIDs: []string{"157b5e14568e8"},
 	},
+	{
+		Label:          "Image Send With Max Length Caption",
+		MsgText:        strings.Repeat("é", 20),
+		MsgURN:         "whatsapp:250788123123",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://waba-v2.360dialog.io/messages": {
+				httpx.NewMockResponse(201, nil, []byte(`{ "messages": [{"id": "157b5e14568e8"}] }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Path: "/messages",
+				Body: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"image","image":{"link":"https://foo.bar/image.jpg","caption":"` + strings.Repeat("é", 20) + `"}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"157b5e14568e8"},
+	},
+	{
+		Label:          "Image Send With Caption Too Long",
+		MsgText:        strings.Repeat("a", 21),
+		MsgURN:         "whatsapp:250788123123",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://waba-v2.360dialog.io/messages": {
+				httpx.NewMockResponse(201, nil, []byte(`{ "messages": [{"id": "157b5e14568e8"}] }`)),
+				httpx.NewMockResponse(201, nil, []byte(`{ "messages": [{"id": "157b5e14568e9"}] }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Path: "/messages",
+				Body: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"image","image":{"link":"https://foo.bar/image.jpg"}}`,
+			},
+			{
+				Path: "/messages",
+				Body: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"` + strings.Repeat("a", 21) + `","preview_url":false}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"157b5e14568e8", "157b5e14568e9"},
+	},
 	{
 		Label:          "Video Send",
 		MsgText:        "video caption",
@@ -715,6 +770,7 @@ var SendTestCasesD3C = []OutgoingTestCase{
 func TestOutgoing(t *testing.T) {
 	// shorter max msg length for testing
 	maxMsgLength = 100
+	maxCaptionLength = 20
 
 	var ChannelWAC = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "D3C", "12345_ID", "", []string{urns.WhatsApp.Prefix}, map[string]any{
 		"auth_token": "the-auth-token",
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -19,6 +18,9 @@ var (
 	maxMsgLength = 453
 )
 
+// timestamps include an offset, e.g. 2017-10-26T15:51:32.906335+00:00
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.999999-07:00"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -55,8 +57,8 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
-	// create our date from the timestamp "2017-10-26T15:51:32.906335+00:00"
-	date, err := time.Parse("2006-01-02T15:04:05.999999-07:00", form.TStamp)
+	// create our date from the timestamp
+	date, err := timestampFormat.Parse(form.TStamp)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid tstamp: %s", form.TStamp))
 	}
@@ -0,0 +1,283 @@
+package expo
+
+/*
+Sends to mobile apps using Expo's push notification service. Contacts are identified by an external id given by the app
+and their Expo push token is stored as a URN auth token when they register or send.
+
+Sends are batched and Expo responds with a ticket for each notification. Tickets only tell us whether Expo accepted a
+notification so we record them and later poll for their receipts which tell us whether delivery to APNs or FCM failed.
+Sending and receipt polling are exported so that handlers of other channel types can push with Expo.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	configAccessToken = "expo_access_token" // only required if the project has enhanced push security enabled
+	configTitle       = "expo_title"
+)
+
+var (
+	sendURL     = "https://exp.host/--/api/v2/push/send"
+	receiptsURL = "https://exp.host/--/api/v2/push/getReceipts"
+
+	maxMsgLength   = 4096
+	maxBulkSize    = 100  // max notifications per send request
+	maxReceiptsIDs = 1000 // max ticket ids per receipts request
+
+	// receipts aren't available straight away and Expo only keeps them for a day
+	receiptsDelay   = time.Minute * 15
+	receiptsExpiry  = time.Hour * 24
+	receiptsTicker  = time.Minute
+	receiptsKey     = "expo-tickets:%s" // by channel type
+	ticketNotFound  = "DeviceNotRegistered"
+	ticketThrottled = "MessageRateExceeded"
+)
+
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45.123
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000"}}
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("EXP"), "Expo Push Notifications", handlers.WithRedactConfigKeys(configAccessToken))}
+}
+
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
+	s.AddHandlerRoute(h, http.MethodPost, "register", courier.ChannelLogTypeEventReceive, h.registerContact)
+
+	StartReceiptsPoller(&h.BaseHandler, s)
+	return nil
+}
+
+type receiveForm struct {
+	From      string `name:"from"       validate:"required"`
+	Msg       string `name:"msg"`
+	PushToken string `name:"push_token"`
+	Date      string `name:"date"`
+	Name      string `name:"name"`
+}
+
+// receiveMessage is our HTTP handler function for incoming messages
+func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &receiveForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	date := time.Now().UTC()
+	if form.Date != "" {
+		date, err = timestampFormat.Parse(form.Date)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse date: %s", form.Date))
+		}
+	}
+
+	urn, err := urns.New(urns.External, form.From)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// if a new push token was provided, record that
+	var authTokens map[string]string
+	if form.PushToken != "" {
+		authTokens = map[string]string{"default": form.PushToken}
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, form.Msg, "", clog).WithReceivedOn(date).WithContactName(form.Name).WithURNAuthTokens(authTokens)
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+type registerForm struct {
+	URN       string `name:"urn"        validate:"required"`
+	PushToken string `name:"push_token" validate:"required"`
+	Name      string `name:"name"`
+}
+
+// registerContact is our HTTP handler function for when a contact is registered or their push token changes
+func (h *handler) registerContact(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &registerForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	urn, err := urns.New(urns.External, form.URN)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	contact, err := h.Backend().GetContact(ctx, channel, urn, map[string]string{"default": form.PushToken}, form.Name, clog)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]string{"contact_uuid": string(contact.UUID())})
+	return nil, err
+}
+
+// see https://docs.expo.dev/push-notifications/sending-notifications/#message-request-format
+type mtNotification struct {
+	To    string `json:"to"`
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+	Sound string `json:"sound,omitempty"`
+	Data  struct {
+		MessageID     string   `json:"message_id"`
+		SessionStatus string   `json:"session_status"`
+		QuickReplies  []string `json:"quick_replies,omitempty"`
+	} `json:"data"`
+}
+
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	if err := h.SendBulk(ctx, []courier.MsgOut{msg}, []*courier.SendResult{res}, clog); err != nil {
+		return err
+	}
+	return res.GetError()
+}
+
+// MaxBulkSize returns the maximum number of messages we send in a single request
+func (h *handler) MaxBulkSize(ch courier.Channel) int {
+	return min(ch.IntConfigForKey(courier.ConfigMaxBulkSize, maxBulkSize), maxBulkSize)
+}
+
+// SendBulk sends the passed in messages, which are all for the same channel, in a single request
+func (h *handler) SendBulk(ctx context.Context, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	return SendBulk(ctx, &h.BaseHandler, msgs, results, clog)
+}
+
+// SendBulk pushes the given messages, which are all for the same channel, in a single request using the Expo config of
+// their channel. Tickets are recorded for the receipts poller of the given handler's channel type.
+func SendBulk(ctx context.Context, h *handlers.BaseHandler, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	channel := msgs[0].Channel()
+	title := channel.StringConfigForKey(configTitle, "")
+
+	// each message is sent as a single notification so that tickets map back to messages, long messages are truncated
+	notifications := make([]*mtNotification, 0, len(msgs))
+	sentIdx := make([]int, 0, len(msgs))
+
+	for i, msg := range msgs {
+		// without a push token there's no way to reach the contact
+		if msg.URNAuth() == "" {
+			results[i].SetError(courier.ErrFailedWithReason("", "Contact has no registered push token."))
+			continue
+		}
+
+		n := &mtNotification{
+			To:    msg.URNAuth(),
+			Title: title,
+			Body:  handlers.SplitMsgByChannel(channel, handlers.GetTextAndAttachments(msg), maxMsgLength)[0],
+			Sound: "default",
+		}
+		n.Data.MessageID = msg.ID().String()
+		n.Data.QuickReplies = msg.QuickReplies()
+		if msg.Session() != nil {
+			n.Data.SessionStatus = msg.Session().Status
+		}
+
+		notifications = append(notifications, n)
+		sentIdx = append(sentIdx, i)
+	}
+
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(jsonx.MustMarshal(notifications)))
+	if err != nil {
+		return err
+	}
+	setHeaders(req, channel)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return courier.ErrConnectionThrottled
+	} else if resp.StatusCode/100 != 2 {
+		return courier.ErrResponseStatus
+	}
+
+	// response contains a ticket for each notification in the order they were sent
+	// see https://docs.expo.dev/push-notifications/sending-notifications/#push-tickets
+	tickets := make([]*ticket, 0, len(notifications))
+	sentOn := time.Now().UTC()
+
+	for i, idx := range sentIdx {
+		ticketJSON, _, _, err := jsonparser.Get(respBody, "data", fmt.Sprintf("[%d]", i))
+		if err != nil {
+			results[idx].SetError(courier.ErrResponseUnexpected)
+			continue
+		}
+
+		status, _ := jsonparser.GetString(ticketJSON, "status")
+		if status == "ok" {
+			ticketID, err := jsonparser.GetString(ticketJSON, "id")
+			if err != nil {
+				clog.Error(courier.ErrorResponseValueMissing("id"))
+				continue
+			}
+
+			results[idx].AddExternalID(ticketID)
+			tickets = append(tickets, &ticket{ID: ticketID, ChannelUUID: channel.UUID(), URN: msgs[idx].URN(), SentOn: sentOn})
+		} else {
+			results[idx].SetError(ticketError(ticketJSON))
+		}
+	}
+
+	if len(tickets) > 0 {
+		if err := recordTickets(h, tickets); err != nil {
+			slog.Error("error recording expo tickets", "error", err, "channel_uuid", channel.UUID())
+		}
+	}
+
+	return nil
+}
+
+func setHeaders(req *http.Request, channel courier.Channel) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if accessToken := channel.StringConfigForKey(configAccessToken, ""); accessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	}
+}
+
+// ticketError maps an error ticket or receipt to a send error
+func ticketError(ticketJSON []byte) error {
+	message, _ := jsonparser.GetString(ticketJSON, "message")
+	code, _ := jsonparser.GetString(ticketJSON, "details", "error")
+
+	switch code {
+	case ticketNotFound:
+		return courier.ErrContactStopped
+	case ticketThrottled:
+		return courier.ErrConnectionThrottled
+	default:
+		return courier.ErrFailedWithReason(code, message)
+	}
+}
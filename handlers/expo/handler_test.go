@@ -0,0 +1,321 @@
+package expo
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	receiveURL  = "/c/exp/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive"
+	registerURL = "/c/exp/8eb23e93-5ecb-45ba-b726-3b064e0c568c/register"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "EXP", "", "",
+		[]string{urns.External.Prefix},
+		map[string]any{
+			configAccessToken: "ACCESS_TOKEN",
+			configTitle:       "Example",
+		}),
+}
+
+var incomingCases = []IncomingTestCase{
+	{
+		Label:                 "Receive Valid Message",
+		URL:                   receiveURL,
+		Data:                  "from=12345&date=2017-01-01T08:50:00.000&push_token=ExponentPushToken[abc]&name=fred&msg=hello+world",
+		ExpectedRespStatus:    200,
+		ExpectedBodyContains:  "Accepted",
+		ExpectedMsgText:       Sp("hello world"),
+		ExpectedURN:           "ext:12345",
+		ExpectedDate:          time.Date(2017, 1, 1, 8, 50, 0, 0, time.UTC),
+		ExpectedURNAuthTokens: map[urns.URN]map[string]string{"ext:12345": {"default": "ExponentPushToken[abc]"}},
+		ExpectedContactName:   Sp("fred"),
+	},
+	{
+		Label:                "Receive Invalid Date",
+		URL:                  receiveURL,
+		Data:                 "from=12345&date=yo&push_token=ExponentPushToken[abc]&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unable to parse date",
+	},
+	{
+		Label:                "Receive Missing From",
+		URL:                  receiveURL,
+		Data:                 "push_token=ExponentPushToken[abc]&msg=hello+world",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'from' required",
+	},
+	{
+		Label:                "Receive Valid Register",
+		URL:                  registerURL,
+		Data:                 "urn=12345&push_token=ExponentPushToken[abc]&name=fred",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "contact_uuid",
+	},
+	{
+		Label:                "Receive Missing Push Token",
+		URL:                  registerURL,
+		Data:                 "urn=12345&name=fred",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "field 'pushtoken' required",
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), incomingCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), incomingCases)
+}
+
+var outgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"ok","id":"XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "Bearer ACCESS_TOKEN", "Content-Type": "application/json"},
+			Body:    `[{"to":"ExponentPushToken[abc]","title":"Example","body":"Simple Message","sound":"default","data":{"message_id":"10","session_status":""}}]`,
+		}},
+		ExpectedExtIDs: []string{"XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"},
+	},
+	{
+		Label:           "Quick Replies And Attachment",
+		MsgText:         "Simple Message",
+		MsgURN:          "ext:12345",
+		MsgURNAuth:      "ExponentPushToken[abc]",
+		MsgQuickReplies: []string{"yes", "no"},
+		MsgAttachments:  []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"ok","id":"XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `[{"to":"ExponentPushToken[abc]","title":"Example","body":"Simple Message\nhttps://foo.bar/image.jpg","sound":"default","data":{"message_id":"10","session_status":"","quick_replies":["yes","no"]}}]`,
+		}},
+		ExpectedExtIDs: []string{"XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"},
+	},
+	{
+		Label:         "No Push Token",
+		MsgText:       "Simple Message",
+		MsgURN:        "ext:12345",
+		ExpectedError: courier.ErrFailedWithReason("", "Contact has no registered push token."),
+	},
+	{
+		Label:      "Device Not Registered",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"error","message":"\"ExponentPushToken[abc]\" is not a registered push notification recipient","details":{"error":"DeviceNotRegistered"}}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrContactStopped,
+	},
+	{
+		Label:      "Rate Exceeded",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"error","message":"Too many messages","details":{"error":"MessageRateExceeded"}}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionThrottled,
+	},
+	{
+		Label:      "Other Ticket Error",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"error","message":"The message is too big","details":{"error":"MessageTooBig"}}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrFailedWithReason("MessageTooBig", "The message is too big"),
+	},
+	{
+		Label:      "Too Many Requests",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(429, nil, []byte(`{"errors":[{"code":"TOO_MANY_REQUESTS","message":"Rate limit exceeded"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionThrottled,
+	},
+	{
+		Label:      "Error Status",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(400, nil, []byte(`{"errors":[{"code":"VALIDATION_ERROR","message":"Invalid request"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrResponseStatus,
+	},
+	{
+		Label:      "Connection Error",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:12345",
+		MsgURNAuth: "ExponentPushToken[abc]",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://exp.host/--/api/v2/push/send": {
+				httpx.NewMockResponse(503, nil, []byte(`Service Unavailable`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), outgoingCases, []string{"ACCESS_TOKEN"}, nil)
+}
+
+func TestSendBulk(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "EXP", "", "", []string{urns.External.Prefix}, map[string]any{courier.ConfigMaxBulkSize: 500})
+
+	mb := test.NewMockBackend()
+	h := newHandler().(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	assert.Equal(t, 100, h.MaxBulkSize(ch))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://exp.host/--/api/v2/push/send": {
+			httpx.NewMockResponse(200, nil, []byte(`{"data":[{"status":"ok","id":"ticket1"},{"status":"error","message":"Not registered","details":{"error":"DeviceNotRegistered"}}]}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	msgs := []courier.MsgOut{
+		test.NewMockMsg(10, "", ch, "ext:bob", "Hi Bob", nil).WithURNAuth("ExponentPushToken[bob]"),
+		test.NewMockMsg(11, "", ch, "ext:ann", "Hi Ann", nil),
+		test.NewMockMsg(12, "", ch, "ext:cat", "Hi Cat", nil).WithURNAuth("ExponentPushToken[cat]"),
+	}
+	results := []*courier.SendResult{{}, {}, {}}
+	clog := courier.NewChannelLogForSend(msgs[0], h.RedactValues(ch))
+
+	err := h.SendBulk(context.Background(), msgs, results, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ticket1"}, results[0].ExternalIDs())
+	assert.NoError(t, results[0].GetError())
+	assert.Equal(t, courier.ErrFailedWithReason("", "Contact has no registered push token."), results[1].GetError())
+	assert.Nil(t, results[2].ExternalIDs())
+	assert.Equal(t, courier.ErrContactStopped, results[2].GetError())
+
+	// message without a push token isn't included in the request
+	require.Len(t, mocks.Requests(), 1)
+	body, _ := io.ReadAll(mocks.Requests()[0].Body)
+	assert.JSONEq(t, `[{"to":"ExponentPushToken[bob]","body":"Hi Bob","sound":"default","data":{"message_id":"10","session_status":""}},{"to":"ExponentPushToken[cat]","body":"Hi Cat","sound":"default","data":{"message_id":"12","session_status":""}}]`, string(body))
+
+	// and only the successful ticket is recorded for receipt checking
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	count, err := redis.Int(rc.Do("ZCARD", "expo-tickets:EXP"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestCheckReceipts(t *testing.T) {
+	ctx := context.Background()
+	ch := testChannels[0]
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(ch)
+	h := newHandler().(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	tickets := []*ticket{
+		{ID: "ticket1", ChannelUUID: ch.UUID(), URN: "ext:bob", SentOn: now.Add(-time.Minute * 30)},                       // delivered
+		{ID: "ticket2", ChannelUUID: ch.UUID(), URN: "ext:ann", SentOn: now.Add(-time.Minute * 30)},                       // device not registered
+		{ID: "ticket3", ChannelUUID: ch.UUID(), URN: "ext:cat", SentOn: now.Add(-time.Minute * 30)},                       // other error
+		{ID: "ticket4", ChannelUUID: ch.UUID(), URN: "ext:dan", SentOn: now.Add(-time.Minute * 30)},                       // no receipt yet
+		{ID: "ticket5", ChannelUUID: ch.UUID(), URN: "ext:eve", SentOn: now.Add(-time.Hour * 25)},                         // no receipt and expired
+		{ID: "ticket6", ChannelUUID: ch.UUID(), URN: "ext:fay", SentOn: now.Add(-time.Minute * 5)},                        // too recent to check
+		{ID: "ticket7", ChannelUUID: "0d5a4c0f-e1c4-4a84-8b3f-3e0f55c1ba73", URN: "ext:gus", SentOn: now.Add(-time.Hour)}, // channel gone
+	}
+	require.NoError(t, recordTickets(&h.BaseHandler, tickets))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://exp.host/--/api/v2/push/getReceipts": {
+			httpx.NewMockResponse(200, nil, []byte(`{"data":{
+				"ticket1":{"status":"ok"},
+				"ticket2":{"status":"error","message":"Not registered","details":{"error":"DeviceNotRegistered"}},
+				"ticket3":{"status":"error","message":"Invalid credentials","details":{"error":"InvalidCredentials"}}
+			}}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	err := checkReceipts(ctx, &h.BaseHandler, now)
+	assert.NoError(t, err)
+
+	require.Len(t, mocks.Requests(), 1)
+	assert.Equal(t, "Bearer ACCESS_TOKEN", mocks.Requests()[0].Header.Get("Authorization"))
+	body, _ := io.ReadAll(mocks.Requests()[0].Body)
+	assert.JSONEq(t, `{"ids":["ticket5","ticket1","ticket2","ticket3","ticket4"]}`, string(body))
+
+	// undelivered messages are failed
+	require.Len(t, mb.WrittenMsgStatuses(), 2)
+	assert.Equal(t, "ticket2", mb.WrittenMsgStatuses()[0].ExternalID())
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, "ticket3", mb.WrittenMsgStatuses()[1].ExternalID())
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[1].Status())
+
+	// and contacts with unregistered devices stopped
+	require.Len(t, mb.WrittenChannelEvents(), 1)
+	assert.Equal(t, courier.EventTypeStopContact, mb.WrittenChannelEvents()[0].EventType())
+	assert.Equal(t, urns.URN("ext:ann"), mb.WrittenChannelEvents()[0].URN())
+
+	require.Len(t, mb.WrittenChannelLogs(), 1)
+	assert.Equal(t, courier.ChannelLogTypeMsgStatus, mb.WrittenChannelLogs()[0].Type)
+	assert.Len(t, mb.WrittenChannelLogs()[0].Errors, 2)
+
+	// tickets still waiting on receipts remain
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	remaining, err := redis.Strings(rc.Do("ZRANGE", "expo-tickets:EXP", 0, -1))
+	assert.NoError(t, err)
+	assert.Len(t, remaining, 2)
+	assert.Contains(t, remaining[0]+remaining[1], `"id":"ticket4"`)
+	assert.Contains(t, remaining[0]+remaining[1], `"id":"ticket6"`)
+}
@@ -0,0 +1,181 @@
+package expo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// ticket is a sent notification whose receipt we have yet to check
+type ticket struct {
+	ID          string              `json:"id"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	URN         urns.URN            `json:"urn"`
+	SentOn      time.Time           `json:"sent_on"`
+}
+
+// recordTickets adds the given tickets to the set of tickets of the handler's channel type waiting for receipts,
+// scored by when they were sent
+func recordTickets(h *handlers.BaseHandler, tickets []*ticket) error {
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		args := redis.Args{}.Add(fmt.Sprintf(receiptsKey, h.ChannelType()))
+		for _, t := range tickets {
+			args = args.Add(t.SentOn.Unix(), jsonx.MustMarshal(t))
+		}
+		_, err = rc.Do("ZADD", args...)
+	})
+	return err
+}
+
+// StartReceiptsPoller starts a goroutine which checks receipts for the tickets of the given handler's channel type until
+// the server is stopped
+func StartReceiptsPoller(h *handlers.BaseHandler, s courier.Server) {
+	s.WaitGroup().Add(1)
+
+	go func() {
+		defer s.WaitGroup().Done()
+
+		for {
+			select {
+			case <-s.StopChan():
+				return
+			case <-time.After(receiptsTicker):
+				if err := checkReceipts(context.Background(), h, time.Now()); err != nil {
+					slog.Error("error checking expo receipts", "comp", "expo", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// checkReceipts fetches the receipts of tickets which are old enough to have them, failing the messages of any which
+// weren't delivered and stopping contacts whose push tokens are no longer valid
+func checkReceipts(ctx context.Context, h *handlers.BaseHandler, now time.Time) error {
+	key := fmt.Sprintf(receiptsKey, h.ChannelType())
+
+	var members []string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		members, err = redis.Strings(rc.Do("ZRANGEBYSCORE", key, "-inf", now.Add(-receiptsDelay).Unix(), "LIMIT", 0, maxReceiptsIDs))
+	})
+	if err != nil {
+		return fmt.Errorf("error reading tickets: %w", err)
+	}
+
+	// receipts have to be requested with the access token of the project that sent them
+	byChannel := make(map[courier.ChannelUUID][]*ticket)
+	for _, m := range members {
+		t := &ticket{}
+		if err := json.Unmarshal([]byte(m), t); err != nil {
+			return fmt.Errorf("error unmarshaling ticket: %w", err)
+		}
+		byChannel[t.ChannelUUID] = append(byChannel[t.ChannelUUID], t)
+	}
+
+	for channelUUID, tickets := range byChannel {
+		channel, err := h.Backend().GetChannel(ctx, h.ChannelType(), channelUUID)
+		if err != nil && !errors.Is(err, courier.ErrChannelNotFound) {
+			return fmt.Errorf("error fetching channel: %w", err)
+		}
+
+		done := tickets
+		if channel != nil {
+			done = checkChannelReceipts(ctx, h, channel, tickets, now)
+		}
+
+		if len(done) > 0 {
+			h.WithRedisConn(func(rc redis.Conn) {
+				args := redis.Args{}.Add(key)
+				for _, t := range done {
+					args = args.Add(jsonx.MustMarshal(t))
+				}
+				_, err = rc.Do("ZREM", args...)
+			})
+			if err != nil {
+				return fmt.Errorf("error removing tickets: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkChannelReceipts fetches and handles receipts for the given tickets, returning those which no longer need checking
+func checkChannelReceipts(ctx context.Context, h *handlers.BaseHandler, channel courier.Channel, tickets []*ticket, now time.Time) []*ticket {
+	log := slog.With("comp", "expo", "channel_uuid", channel.UUID())
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, channel, h.RedactValues(channel))
+	defer func() {
+		clog.End()
+
+		// only bother writing logs for checks which failed or found undelivered notifications
+		if clog.IsError() {
+			if err := h.Backend().WriteChannelLog(ctx, clog); err != nil {
+				log.Error("error writing channel log", "error", err)
+			}
+		}
+	}()
+
+	ids := make([]string, len(tickets))
+	for i, t := range tickets {
+		ids[i] = t.ID
+	}
+
+	done := make([]*ticket, 0, len(tickets))
+
+	req, _ := http.NewRequest(http.MethodPost, receiptsURL, bytes.NewReader(jsonx.MustMarshal(map[string]any{"ids": ids})))
+	setHeaders(req, channel)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		clog.Error(courier.ErrorResponseStatusCode())
+		respBody = nil
+	}
+
+	for _, t := range tickets {
+		receiptJSON, _, _, err := jsonparser.Get(respBody, "data", t.ID)
+		if err != nil {
+			// no receipt yet, keep checking unless Expo will have discarded it by now
+			if now.Sub(t.SentOn) > receiptsExpiry {
+				done = append(done, t)
+			}
+			continue
+		}
+
+		done = append(done, t)
+
+		if status, _ := jsonparser.GetString(receiptJSON, "status"); status == "ok" {
+			continue
+		}
+
+		code, _ := jsonparser.GetString(receiptJSON, "details", "error")
+		message, _ := jsonparser.GetString(receiptJSON, "message")
+		clog.Error(courier.ErrorExternal(code, message))
+
+		status := h.Backend().NewStatusUpdateByExternalID(channel, t.ID, courier.MsgStatusFailed, clog)
+		if err := h.Backend().WriteStatusUpdate(ctx, status); err != nil {
+			log.Error("error writing status update", "error", err)
+		}
+
+		if code == ticketNotFound {
+			event := h.Backend().NewChannelEvent(channel, courier.EventTypeStopContact, t.URN, clog)
+			if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+				log.Error("error writing stop contact event", "error", err)
+			}
+		}
+	}
+
+	return done
+}
@@ -3,9 +3,17 @@ package external
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -13,6 +21,7 @@ import (
 	"time"
 
 	"github.com/antchfx/xmlquery"
+	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/gocommon/gsm7"
@@ -30,26 +39,49 @@ const (
 	configMOFromField = "mo_from_field"
 	configMOTextField = "mo_text_field"
 	configMODateField = "mo_date_field"
+	configMOBatchPath = "mo_batch_path"
 
 	configMOResponseContentType = "mo_response_content_type"
 	configMOResponse            = "mo_response"
 
-	configMTResponseCheck = "mt_response_check"
-	configEncoding        = "encoding"
-	encodingDefault       = "D"
-	encodingSmart         = "S"
+	configMOSignatureSecret    = "mo_signature_secret"
+	configMOSignatureHeader    = "mo_signature_header"
+	configMOSignatureAlgorithm = "mo_signature_algorithm"
+	configMOBasicAuthUsername  = "mo_basic_auth_username"
+	configMOBasicAuthPassword  = "mo_basic_auth_password"
+
+	configMTResponseCheck            = "mt_response_check"
+	configMTResponseIDPath           = "mt_response_id_path"
+	configMTResponseErrorPath        = "mt_response_error_path"
+	configMTResponseErrorMessagePath = "mt_response_error_message_path"
+	configEncoding                   = "encoding"
+	encodingDefault                  = "D"
+	encodingSmart                    = "S"
 )
 
 var defaultFromFields = []string{"from", "sender"}
 var defaultTextFields = []string{"text"}
 var defaultDateFields = []string{"date", "time"}
 
+// batches of incoming messages can be much bigger than single messages
+const maxRequestBodyBytes = 1000000
+
+const defaultSignatureHeader = "X-Signature"
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
 var contentTypeMappings = map[string]string{
 	contentURLEncoded: "application/x-www-form-urlencoded",
 	contentJSON:       "application/json",
 	contentXML:        "text/xml; charset=utf-8",
 }
 
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{time.RFC3339Nano}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -91,6 +123,10 @@ type stopContactForm struct {
 }
 
 func (h *handler) receiveStopContact(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	if err := h.validateRequest(channel, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
 	form := &stopContactForm{}
 	err := handlers.DecodeAndValidateForm(form, r)
 	if err != nil {
@@ -137,9 +173,49 @@ func getFormField(form url.Values, defaultNames []string, name string) string {
 	return ""
 }
 
+// like getFormField but returns all the values of the field, for requests with repeated fields
+func getFormFieldValues(form url.Values, defaultNames []string, name string) []string {
+	if name != "" {
+		if values, found := form[name]; found {
+			return values
+		}
+	}
+
+	for _, name := range defaultNames {
+		if values, found := form[name]; found {
+			return values
+		}
+	}
+
+	return nil
+}
+
+// like getFormField but for JSON objects, where the name can be a path, e.g. sender.number
+func getJSONField(data []byte, defaultNames []string, name string) string {
+	if name != "" {
+		if value := jsonPathValue(data, name); value != "" {
+			return value
+		}
+	}
+
+	for _, name := range defaultNames {
+		if value := jsonPathValue(data, name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
-	var err error
+	if err := h.validateRequest(channel, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	if batchPath := channel.StringConfigForKey(configMOBatchPath, ""); batchPath != "" {
+		return h.receiveBatch(ctx, channel, w, r, batchPath, clog)
+	}
 
 	var from, dateString, text string
 
@@ -184,36 +260,114 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		dateString = getFormField(r.Form, defaultDateFields, channel.StringConfigForKey(configMODateField, ""))
 	}
 
+	msg, err := h.newIncomingMsg(channel, from, text, dateString, clog)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// and finally write our message
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+// receiveBatch handles requests which contain multiple incoming messages, either as an array of objects at the
+// configured path in a JSON body, or as repeated form fields, e.g. from=123&text=hi&from=456&text=hello
+func (h *handler) receiveBatch(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, batchPath string, clog *courier.ChannelLog) ([]courier.Event, error) {
+	clog.Type = courier.ChannelLogTypeMultiReceive
+
+	fromField := channel.StringConfigForKey(configMOFromField, "")
+	textField := channel.StringConfigForKey(configMOTextField, "")
+	dateField := channel.StringConfigForKey(configMODateField, "")
+
+	type moFields struct {
+		from, text, date string
+	}
+	var items []moFields
+
+	if strings.Contains(r.Header.Get("Content-Type"), "json") {
+		body, err := handlers.ReadBody(r, maxRequestBodyBytes)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to read request body: %w", err))
+		}
+
+		_, err = jsonparser.ArrayEach(body, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+			items = append(items, moFields{
+				from: getJSONField(value, defaultFromFields, fromField),
+				text: getJSONField(value, defaultTextFields, textField),
+				date: getJSONField(value, defaultDateFields, dateField),
+			})
+		}, jsonPathKeys(batchPath)...)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to find array of messages at %s: %w", batchPath, err))
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid request: %w", err))
+		}
+
+		froms := getFormFieldValues(r.Form, defaultFromFields, fromField)
+		texts := getFormFieldValues(r.Form, defaultTextFields, textField)
+		dates := getFormFieldValues(r.Form, defaultDateFields, dateField)
+
+		for i, from := range froms {
+			item := moFields{from: from}
+			if i < len(texts) {
+				item.text = texts[i]
+			}
+			if i < len(dates) {
+				item.date = dates[i]
+			}
+			items = append(items, item)
+		}
+	}
+
+	// invalid messages are logged and skipped rather than failing the whole batch, which would likely be resent
+	msgs := make([]courier.MsgIn, 0, len(items))
+	for i, item := range items {
+		msg, err := h.newIncomingMsg(channel, item.from, item.text, item.date, clog)
+		if err != nil {
+			clog.RawError(fmt.Errorf("message %d: %w", i, err))
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("no valid messages in request"))
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, msgs, w, r, clog)
+}
+
+// creates a new incoming message from the given from, text and date values
+func (h *handler) newIncomingMsg(channel courier.Channel, from, text, dateString string, clog *courier.ChannelLog) (courier.MsgIn, error) {
 	// must have from field
 	if from == "" {
-		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("must have one of 'sender' or 'from' set"))
+		return nil, fmt.Errorf("must have one of 'sender' or 'from' set")
 	}
 
 	// if we have a date, parse it
 	date := time.Now()
 	if dateString != "" {
-		date, err = time.Parse(time.RFC3339Nano, dateString)
+		var err error
+		date, err = timestampFormat.Parse(dateString)
 		if err != nil {
-			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format, must be RFC 3339"))
+			return nil, fmt.Errorf("invalid date format, must be RFC 3339")
 		}
 	}
 
 	// create our URN
-	urn := urns.NilURN
+	var urn urns.URN
+	var err error
 	if channel.Schemes()[0] == urns.Phone.Prefix {
 		urn, err = urns.ParsePhone(from, channel.Country(), true, false)
 	} else {
 		urn, err = urns.NewFromParts(channel.Schemes()[0], from, nil, "")
 	}
 	if err != nil {
-		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		return nil, err
 	}
 
-	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, text, "", clog).WithReceivedOn(date)
-
-	// and finally write our message
-	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+	return h.Backend().NewIncomingMsg(channel, urn, text, "", clog).WithReceivedOn(date), nil
 }
 
 // WriteMsgSuccessResponse writes our response in TWIML format
@@ -231,6 +385,63 @@ func (h *handler) WriteMsgSuccessResponse(ctx context.Context, w http.ResponseWr
 	return err
 }
 
+// validateRequest checks the basic auth credentials and HMAC signature of an incoming request if the channel has
+// been configured to require them
+func (h *handler) validateRequest(channel courier.Channel, r *http.Request) error {
+	username := channel.StringConfigForKey(configMOBasicAuthUsername, "")
+	password := channel.StringConfigForKey(configMOBasicAuthPassword, "")
+	if username != "" || password != "" {
+		actualUsername, actualPassword, ok := r.BasicAuth()
+		if !ok {
+			return fmt.Errorf("missing basic auth credentials")
+		}
+
+		// compare credentials in way that isn't sensitive to a timing attack
+		usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(actualUsername)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(actualPassword)) == 1
+		if !usernameMatch || !passwordMatch {
+			return fmt.Errorf("invalid basic auth credentials")
+		}
+	}
+
+	secret := channel.StringConfigForKey(configMOSignatureSecret, "")
+	if secret != "" {
+		algorithm := strings.ToLower(channel.StringConfigForKey(configMOSignatureAlgorithm, "sha256"))
+		newHash, found := signatureAlgorithms[algorithm]
+		if !found {
+			return fmt.Errorf("unsupported signature algorithm '%s'", algorithm)
+		}
+
+		actual := r.Header.Get(channel.StringConfigForKey(configMOSignatureHeader, defaultSignatureHeader))
+		if actual == "" {
+			return fmt.Errorf("missing request signature")
+		}
+
+		// some aggregators prefix the signature with the algorithm, e.g. sha256=...
+		actual = strings.TrimPrefix(actual, algorithm+"=")
+
+		body, err := handlers.ReadBody(r, maxRequestBodyBytes)
+		if err != nil {
+			return fmt.Errorf("unable to read request body: %w", err)
+		}
+
+		mac := hmac.New(newHash, []byte(secret))
+		mac.Write(body)
+		expected := mac.Sum(nil)
+
+		// signatures can be hex or base64 encoded
+		decoded, err := hex.DecodeString(actual)
+		if err != nil {
+			decoded, err = base64.StdEncoding.DecodeString(actual)
+		}
+		if err != nil || !hmac.Equal(expected, decoded) {
+			return fmt.Errorf("invalid request signature")
+		}
+	}
+
+	return nil
+}
+
 // buildStatusHandler deals with building a handler that takes what status is received in the URL
 func (h *handler) buildStatusHandler(status string) courier.ChannelHandleFunc {
 	return func(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
@@ -250,6 +461,10 @@ var statusMappings = map[string]courier.MsgStatus{
 
 // receiveStatus is our HTTP handler function for status updates
 func (h *handler) receiveStatus(ctx context.Context, statusString string, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	if err := h.validateRequest(channel, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
 	form := &statusForm{}
 	err := handlers.DecodeAndValidateForm(form, r)
 	if err != nil {
@@ -278,6 +493,9 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	// figure out what encoding to tell kannel to send as
 	encoding := channel.StringConfigForKey(configEncoding, encodingDefault)
 	responseCheck := channel.StringConfigForKey(configMTResponseCheck, "")
+	responseIDPath := channel.StringConfigForKey(configMTResponseIDPath, "")
+	responseErrorPath := channel.StringConfigForKey(configMTResponseErrorPath, "")
+	responseErrorMessagePath := channel.StringConfigForKey(configMTResponseErrorMessagePath, "")
 	sendMethod := channel.StringConfigForKey(courier.ConfigSendMethod, http.MethodPost)
 	sendBody := channel.StringConfigForKey(courier.ConfigSendBody, "")
 	sendMaxLength := channel.IntConfigForKey(courier.ConfigMaxLength, 160)
@@ -287,7 +505,18 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		contentTypeHeader = contentType
 	}
 
-	parts := handlers.SplitMsgByChannel(channel, handlers.GetTextAndAttachments(msg), sendMaxLength)
+	// if the templates have a place for attachments, send them there rather than appending their URLs to the text
+	text := h.TextAndAttachments(msg)
+	attachmentURLs := []string{}
+	if strings.Contains(sendURL, "{{attachments}}") || strings.Contains(sendBody, "{{attachments}}") {
+		text = msg.Text()
+		for _, a := range msg.Attachments() {
+			_, attURL := handlers.SplitAttachment(a)
+			attachmentURLs = append(attachmentURLs, attURL)
+		}
+	}
+
+	parts := handlers.SplitMsgByChannel(channel, text, sendMaxLength)
 	for i, part := range parts {
 		// build our request
 		form := map[string]string{
@@ -295,6 +524,9 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			"text":           part,
 			"to":             msg.URN().Path(),
 			"to_no_plus":     strings.TrimPrefix(msg.URN().Path(), "+"),
+			"urn":            msg.URN().String(),
+			"urn_scheme":     msg.URN().Scheme(),
+			"urn_path":       msg.URN().Path(),
 			"from":           channel.Address(),
 			"from_no_plus":   strings.TrimPrefix(channel.Address(), "+"),
 			"channel":        string(channel.UUID()),
@@ -322,25 +554,25 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			}
 		}
 
-		formEncoded := encodeVariables(form, contentURLEncoded)
-
-		// put quick replies on last message part
+		// put attachments on first message part and quick replies on last message part
+		partAttachments, partQuickReplies := []string{}, []string{}
+		if i == 0 {
+			partAttachments = attachmentURLs
+		}
 		if i == len(parts)-1 {
-			formEncoded["quick_replies"] = buildQuickRepliesResponse(msg.QuickReplies(), sendMethod, contentURLEncoded)
-		} else {
-			formEncoded["quick_replies"] = buildQuickRepliesResponse([]string{}, sendMethod, contentURLEncoded)
+			partQuickReplies = msg.QuickReplies()
 		}
+
+		formEncoded := encodeVariables(form, contentURLEncoded)
+		formEncoded["quick_replies"] = buildListVariable(partQuickReplies, "quick_reply", sendMethod, contentURLEncoded)
+		formEncoded["attachments"] = buildListVariable(partAttachments, "attachment", sendMethod, contentURLEncoded)
 		url := replaceVariables(sendURL, formEncoded)
 
 		var body io.Reader
 		if sendMethod == http.MethodPost || sendMethod == http.MethodPut {
 			formEncoded = encodeVariables(form, contentType)
-
-			if i == len(parts)-1 {
-				formEncoded["quick_replies"] = buildQuickRepliesResponse(msg.QuickReplies(), sendMethod, contentType)
-			} else {
-				formEncoded["quick_replies"] = buildQuickRepliesResponse([]string{}, sendMethod, contentType)
-			}
+			formEncoded["quick_replies"] = buildListVariable(partQuickReplies, "quick_reply", sendMethod, contentType)
+			formEncoded["attachments"] = buildListVariable(partAttachments, "attachment", sendMethod, contentType)
 			body = strings.NewReader(replaceVariables(sendBody, formEncoded))
 		}
 
@@ -364,49 +596,117 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		resp, respBody, err := h.RequestHTTP(req, clog)
 		if err != nil || resp.StatusCode/100 == 5 {
 			return courier.ErrConnectionFailed
-		} else if resp.StatusCode/100 != 2 {
+		}
+
+		// error codes are often returned with non-success status codes so look for one first, with zero or false
+		// values taken to mean success
+		if responseErrorPath != "" {
+			code := jsonPathValue(respBody, responseErrorPath)
+			if code != "" && code != "0" && code != "false" {
+				return courier.ErrFailedWithReason(code, jsonPathValue(respBody, responseErrorMessagePath))
+			}
+		}
+
+		if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
 
 		if responseCheck != "" && !strings.Contains(string(respBody), responseCheck) {
 			return courier.ErrResponseContent
 		}
+
+		if responseIDPath != "" {
+			if !json.Valid(respBody) {
+				return courier.ErrResponseUnparseable
+			}
+
+			externalID := jsonPathValue(respBody, responseIDPath)
+			if externalID == "" {
+				return courier.ErrResponseUnexpected
+			}
+			res.AddExternalID(externalID)
+		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
 }
 
-type quickReplyXMLItem struct {
+type listXMLItem struct {
 	XMLName xml.Name `xml:"item"`
 	Value   string   `xml:",chardata"`
 }
 
-func buildQuickRepliesResponse(quickReplies []string, sendMethod string, contentType string) string {
-	if quickReplies == nil {
-		quickReplies = []string{}
+// builds the value of a list variable like quick_replies, which is repeated URL params unless we're sending a JSON
+// or XML body, e.g. &quick_reply=Yes&quick_reply=No
+func buildListVariable(values []string, param string, sendMethod string, contentType string) string {
+	if values == nil {
+		values = []string{}
 	}
 	if (sendMethod == http.MethodPost || sendMethod == http.MethodPut) && contentType == contentJSON {
-		marshalled, _ := json.Marshal(quickReplies)
+		marshalled, _ := json.Marshal(values)
 		return string(marshalled)
 	} else if (sendMethod == http.MethodPost || sendMethod == http.MethodPut) && contentType == contentXML {
-		items := make([]quickReplyXMLItem, len(quickReplies))
+		items := make([]listXMLItem, len(values))
 
-		for i, v := range quickReplies {
-			items[i] = quickReplyXMLItem{Value: v}
+		for i, v := range values {
+			items[i] = listXMLItem{Value: v}
 		}
 		marshalled, _ := xml.Marshal(items)
 		return string(marshalled)
 	} else {
 		response := bytes.Buffer{}
 
-		for _, reply := range quickReplies {
-			reply = url.QueryEscape(reply)
-			response.WriteString(fmt.Sprintf("&quick_reply=%s", reply))
+		for _, v := range values {
+			response.WriteString(fmt.Sprintf("&%s=%s", param, url.QueryEscape(v)))
 		}
 		return response.String()
 	}
 }
 
+// gets the value at the given JSONPath style path in the given JSON, e.g. $.messages[0].id, returning empty string
+// if there is no such value or it is null
+func jsonPathValue(data []byte, path string) string {
+	if path == "" {
+		return ""
+	}
+
+	value, dataType, _, err := jsonparser.Get(data, jsonPathKeys(path)...)
+	if err != nil {
+		return ""
+	}
+
+	switch dataType {
+	case jsonparser.Null:
+		return ""
+	case jsonparser.String:
+		str, _ := jsonparser.ParseString(value)
+		return str
+	}
+	return string(value)
+}
+
+// converts a JSONPath style path, e.g. $.messages[0].id, to the keys used by jsonparser, e.g. messages, [0], id
+func jsonPathKeys(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	keys := make([]string, 0, 4)
+	for _, key := range strings.Split(path, ".") {
+		// array indexes can follow a key, e.g. messages[0]
+		name, index, hasIndex := strings.Cut(key, "[")
+		if name != "" {
+			keys = append(keys, name)
+		}
+		for hasIndex {
+			var idx string
+			idx, index, _ = strings.Cut(index, "]")
+			keys = append(keys, "["+idx+"]")
+			_, index, hasIndex = strings.Cut(index, "[")
+		}
+	}
+	return keys
+}
+
 func encodeVariables(variables map[string]string, contentType string) map[string]string {
 	encoded := make(map[string]string)
 
@@ -1,6 +1,9 @@
 package external
 
 import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
 	"net/url"
 	"testing"
@@ -9,8 +12,10 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -295,6 +300,205 @@ var extReceiveTestCases = []IncomingTestCase{
 	},
 }
 
+var batchChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US", []string{urns.Phone.Prefix},
+		map[string]any{
+			configMOBatchPath: "$.data.messages",
+			configMOFromField: "sender.number",
+			configMODateField: "received",
+		},
+	),
+}
+
+var batchTestCases = []IncomingTestCase{
+	{
+		Label:                "Receive JSON batch",
+		URL:                  receiveURL,
+		Data:                 `{"data": {"messages": [{"sender": {"number": "+2349067554729"}, "text": "Join", "received": "2017-06-23T12:30:00Z"}]}}`,
+		Headers:              map[string]string{"Content-Type": "application/json"},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedDate:         time.Date(2017, 6, 23, 12, 30, 0, 0, time.UTC),
+	},
+	{
+		Label:                "Receive JSON batch with invalid message",
+		URL:                  receiveURL,
+		Data:                 `{"data": {"messages": [{"text": "No sender"}, {"sender": {"number": "+2349067554729"}, "text": "Join"}]}}`,
+		Headers:              map[string]string{"Content-Type": "application/json"},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedErrors:       []*clogs.LogError{clogs.NewLogError("", "", "message 0: must have one of 'sender' or 'from' set")},
+	},
+	{
+		Label:                "Receive JSON batch with no valid messages",
+		URL:                  receiveURL,
+		Data:                 `{"data": {"messages": [{"text": "No sender"}]}}`,
+		Headers:              map[string]string{"Content-Type": "application/json"},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "no valid messages in request",
+		ExpectedErrors:       []*clogs.LogError{clogs.NewLogError("", "", "message 0: must have one of 'sender' or 'from' set")},
+	},
+	{
+		Label:                "Receive JSON batch missing path",
+		URL:                  receiveURL,
+		Data:                 `{"messages": [{"sender": {"number": "+2349067554729"}, "text": "Join"}]}`,
+		Headers:              map[string]string{"Content-Type": "application/json"},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unable to find array of messages at $.data.messages",
+	},
+	{
+		Label:                "Receive form batch",
+		URL:                  receiveURL,
+		Data:                 "from=%2B2349067554729&text=Join&received=2017-06-23T12:30:00Z",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedDate:         time.Date(2017, 6, 23, 12, 30, 0, 0, time.UTC),
+	},
+}
+
+var authChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US", []string{urns.Phone.Prefix},
+		map[string]any{
+			configMOSignatureSecret:   "sesame",
+			configMOSignatureHeader:   "X-Hub-Signature",
+			configMOBasicAuthUsername: "bob",
+			configMOBasicAuthPassword: "pa55",
+		},
+	),
+}
+
+var authTestCases = []IncomingTestCase{
+	{
+		Label:                "Receive valid signature and credentials",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55"), "X-Hub-Signature": signBody("sha256", "sesame", "sender=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+	},
+	{
+		Label:                "Receive valid prefixed base64 signature",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55"), "X-Hub-Signature": "sha256=" + signBodyBase64("sha256", "sesame", "sender=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+	},
+	{
+		Label:                "Receive missing credentials",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"X-Hub-Signature": signBody("sha256", "sesame", "sender=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing basic auth credentials",
+	},
+	{
+		Label:                "Receive invalid credentials",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "guess"), "X-Hub-Signature": signBody("sha256", "sesame", "sender=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid basic auth credentials",
+	},
+	{
+		Label:                "Receive missing signature",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing request signature",
+	},
+	{
+		Label:                "Receive invalid signature",
+		URL:                  receiveURL,
+		Data:                 "sender=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55"), "X-Hub-Signature": signBody("sha256", "sesame", "sender=%2B2349067554729&text=Leave")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+	},
+	{
+		Label:                "Status with valid signature and credentials",
+		URL:                  "/c/ex/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered/",
+		Data:                 "id=12345",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55"), "X-Hub-Signature": signBody("sha256", "sesame", "id=12345")},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"D"`,
+		ExpectedStatuses:     []ExpectedStatus{{MsgID: 12345, Status: courier.MsgStatusDelivered}},
+	},
+	{
+		Label:                "Status with invalid signature",
+		URL:                  "/c/ex/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered/",
+		Data:                 "id=12345",
+		Headers:              map[string]string{"Authorization": basicAuth("bob", "pa55"), "X-Hub-Signature": signBody("sha256", "sesame", "id=12346")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+	},
+	{
+		Label:                "Stopped event with invalid credentials",
+		URL:                  "/c/ex/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/stopped/",
+		Data:                 "from=%2B2349067554729",
+		Headers:              map[string]string{"Authorization": basicAuth("jim", "pa55"), "X-Hub-Signature": signBody("sha256", "sesame", "from=%2B2349067554729")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid basic auth credentials",
+	},
+}
+
+var sha1SignatureChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US", []string{urns.Phone.Prefix},
+		map[string]any{
+			configMOSignatureSecret:    "sesame",
+			configMOSignatureAlgorithm: "SHA1",
+		},
+	),
+}
+
+var sha1SignatureTestCases = []IncomingTestCase{
+	{
+		Label:                "Receive valid SHA1 signature",
+		URL:                  receiveURL,
+		Data:                 "from=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"X-Signature": signBody("sha1", "sesame", "from=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Join"),
+		ExpectedURN:          "tel:+2349067554729",
+	},
+	{
+		Label:                "Receive SHA256 signature when SHA1 expected",
+		URL:                  receiveURL,
+		Data:                 "from=%2B2349067554729&text=Join",
+		Headers:              map[string]string{"X-Signature": signBody("sha256", "sesame", "from=%2B2349067554729&text=Join")},
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "invalid request signature",
+	},
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func signBody(algorithm, secret, body string) string {
+	mac := hmac.New(signatureAlgorithms[algorithm], []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signBodyBase64(algorithm, secret, body string) string {
+	mac := hmac.New(signatureAlgorithms[algorithm], []byte(secret))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
 func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newHandler(), handleTestCases)
 	RunIncomingTestCases(t, testSOAPReceiveChannels, newHandler(), handleSOAPReceiveTestCases)
@@ -302,6 +506,9 @@ func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, customChannels, newHandler(), customTestCases)
 
 	RunIncomingTestCases(t, extChannels, newHandler(), extReceiveTestCases)
+	RunIncomingTestCases(t, batchChannels, newHandler(), batchTestCases)
+	RunIncomingTestCases(t, authChannels, newHandler(), authTestCases)
+	RunIncomingTestCases(t, sha1SignatureChannels, newHandler(), sha1SignatureTestCases)
 }
 
 func BenchmarkHandler(b *testing.B) {
@@ -670,6 +877,118 @@ var jsonLongSendTestCases = []OutgoingTestCase{
 	},
 }
 
+var jsonTemplateSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "Plain Send",
+		MsgText: "Simple Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data": {"messages": [{"id": "abc123", "status": "queued"}]}, "error": {"code": 0}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "Simple Message", "media": []}, "buttons": []}`,
+		}},
+		ExpectedExtIDs: []string{"abc123"},
+	},
+	{
+		Label:           "Send Attachments And Quick Replies",
+		MsgText:         "My pics!",
+		MsgURN:          "tel:+250788383383",
+		MsgAttachments:  []string{"image/jpeg:https://foo.bar/image1.jpg", "image/jpeg:https://foo.bar/image2.jpg"},
+		MsgQuickReplies: []string{"Like", "Dislike"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data": {"messages": [{"id": 34567}]}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "My pics!", "media": ["https://foo.bar/image1.jpg","https://foo.bar/image2.jpg"]}, "buttons": ["Like","Dislike"]}`,
+		}},
+		ExpectedExtIDs: []string{"34567"},
+	},
+	{
+		Label:   "Error Code In Response",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(400, nil, []byte(`{"error": {"code": "E21", "message": "Invalid recipient"}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "Error Message", "media": []}, "buttons": []}`,
+		}},
+		ExpectedError: courier.ErrFailedWithReason("E21", "Invalid recipient"),
+	},
+	{
+		Label:   "Error Status Without Code",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(401, nil, []byte(`{"error": null}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "Error Message", "media": []}, "buttons": []}`,
+		}},
+		ExpectedError: courier.ErrResponseStatus,
+	},
+	{
+		Label:   "Missing External ID",
+		MsgText: "Simple Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"data": {"messages": []}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "Simple Message", "media": []}, "buttons": []}`,
+		}},
+		ExpectedError: courier.ErrResponseUnexpected,
+	},
+	{
+		Label:   "Response Not JSON",
+		MsgText: "Simple Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send": {
+				httpx.NewMockResponse(200, nil, []byte(`Accepted`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    `{"to": {"scheme": "tel", "id": "+250788383383"}, "message": {"text": "Simple Message", "media": []}, "buttons": []}`,
+		}},
+		ExpectedError: courier.ErrResponseUnparseable,
+	},
+}
+
+var getAttachmentsSendTestCases = []OutgoingTestCase{
+	{
+		Label:          "Send Attachment",
+		MsgText:        "My pic!",
+		MsgURN:         "tel:+250788383383",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send*": {
+				httpx.NewMockResponse(200, nil, []byte(`0: Accepted for delivery`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Params: url.Values{"urn": {"tel:+250788383383"}, "text": {"My pic!"}, "attachment": {"https://foo.bar/image.jpg"}},
+		}},
+	},
+}
+
 var xmlSendTestCases = []OutgoingTestCase{
 	{
 		Label:   "Plain Send",
@@ -964,6 +1283,27 @@ func TestOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, postSmartChannel, newHandler(), postSendSmartEncodingTestCases, nil, nil)
 	RunOutgoingTestCases(t, jsonChannel, newHandler(), jsonSendTestCases, nil, nil)
 	RunOutgoingTestCases(t, xmlChannel, newHandler(), xmlSendTestCases, nil, nil)
+
+	var jsonTemplateChannel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{
+			courier.ConfigSendURL:            "http://example.com/send",
+			courier.ConfigSendBody:           `{"to": {"scheme": {{urn_scheme}}, "id": {{urn_path}}}, "message": {"text": {{text}}, "media": {{attachments}}}, "buttons": {{quick_replies}}}`,
+			courier.ConfigContentType:        contentJSON,
+			courier.ConfigSendMethod:         http.MethodPost,
+			configMTResponseIDPath:           "$.data.messages[0].id",
+			configMTResponseErrorPath:        "$.error.code",
+			configMTResponseErrorMessagePath: "$.error.message",
+		})
+
+	var getAttachmentsChannel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{
+			courier.ConfigSendURL:    "http://example.com/send?urn={{urn}}&text={{text}}{{attachments}}",
+			courier.ConfigSendMethod: http.MethodGet})
+
+	RunOutgoingTestCases(t, jsonTemplateChannel, newHandler(), jsonTemplateSendTestCases, nil, nil)
+	RunOutgoingTestCases(t, getAttachmentsChannel, newHandler(), getAttachmentsSendTestCases, nil, nil)
 	RunOutgoingTestCases(t, xmlChannelWithResponseContent, newHandler(), xmlSendWithResponseContentTestCases, nil, nil)
 
 	var getChannel30IntLength = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US",
@@ -1027,3 +1367,21 @@ func TestOutgoing(t *testing.T) {
 		})
 	RunOutgoingTestCases(t, jsonChannelWithSendAuthorization, newHandler(), jsonSendTestCases, []string{"Token ABCDEF"}, nil)
 }
+
+func TestJSONPathValue(t *testing.T) {
+	data := []byte(`{"id": "abc", "count": 0, "ok": false, "nothing": null, "msgs": [{"id": 123}, {"id": 456, "tags": ["a", "b"]}], "esc": "a\"b"}`)
+
+	assert.Equal(t, "abc", jsonPathValue(data, "$.id"))
+	assert.Equal(t, "abc", jsonPathValue(data, "id"))
+	assert.Equal(t, "0", jsonPathValue(data, "$.count"))
+	assert.Equal(t, "false", jsonPathValue(data, "$.ok"))
+	assert.Equal(t, "", jsonPathValue(data, "$.nothing"))
+	assert.Equal(t, "", jsonPathValue(data, "$.missing"))
+	assert.Equal(t, "123", jsonPathValue(data, "$.msgs[0].id"))
+	assert.Equal(t, "456", jsonPathValue(data, "$.msgs[1].id"))
+	assert.Equal(t, "b", jsonPathValue(data, "$.msgs[1].tags[1]"))
+	assert.Equal(t, "", jsonPathValue(data, "$.msgs[2].id"))
+	assert.Equal(t, `a"b`, jsonPathValue(data, "$.esc"))
+	assert.Equal(t, "", jsonPathValue(data, ""))
+	assert.Equal(t, "", jsonPathValue([]byte(`not json`), "$.id"))
+}
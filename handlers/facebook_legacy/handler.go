@@ -248,8 +248,8 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 			continue
 		}
 
-		// create our date from the timestamp (they give us millis, arg is nanos)
-		date := time.Unix(0, msg.Timestamp*1000000).UTC()
+		// create our date from the timestamp
+		date := handlers.ParseUnixTimestamp(msg.Timestamp, handlers.TimestampMillis)
 
 		// create our URN
 		urn, err := urns.New(urns.Facebook, msg.Sender.ID)
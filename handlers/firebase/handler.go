@@ -31,6 +31,9 @@ var (
 	maxMsgLength = 1024
 )
 
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45.123
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -73,7 +76,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	date := time.Now().UTC()
 	if form.Date != "" {
-		date, err = time.Parse("2006-01-02T15:04:05.000", form.Date)
+		date, err = timestampFormat.Parse(form.Date)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse date: %s", form.Date))
 		}
@@ -0,0 +1,19 @@
+package handlers
+
+import "time"
+
+// Forwarded is the context of a forwarded incoming message, normalized across channel types and included in message
+// metadata as "forwarded" so that forwarding patterns can be analyzed
+type Forwarded struct {
+	Frequently bool           `json:"frequently"`
+	From       *ForwardedFrom `json:"from,omitempty"`
+	Date       *time.Time     `json:"date,omitempty"`
+}
+
+// ForwardedFrom is the original sender of a forwarded message, as far as the channel reveals them
+type ForwardedFrom struct {
+	Type     string `json:"type"` // user, chat or channel
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Username string `json:"username,omitempty"`
+}
@@ -65,7 +65,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our date from the timestamp
-	date := payload.Data.Message.CreatedTime
+	date := handlers.ClampTimestamp(payload.Data.Message.CreatedTime)
 
 	// create our URN
 	urn := urns.NilURN
@@ -105,7 +105,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
+	// URN paths are the channel ID and user ID
 	user := strings.Split(msg.URN().Path(), "/")
+	if len(user) != 2 {
+		return courier.ErrMessageInvalid
+	}
 
 	url := apiURL + "/conversations"
 
@@ -70,6 +70,8 @@ func JSONPayload[T any](h courier.ChannelHandler, handlerFunc JSONHandlerFunc[T]
 			return nil, WriteAndLogRequestError(ctx, h, c, w, r, err)
 		}
 
+		checkSchemaDrift(h, c, r, payload)
+
 		return handlerFunc(ctx, c, w, r, payload, clog)
 	}
 }
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
@@ -25,6 +24,9 @@ const (
 	configAppID      = "app_id"
 )
 
+// timestamps are in UTC, e.g. Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"Mon Jan 2 2006 15:04:05 GMT+0000 (UTC)"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -83,8 +85,7 @@ func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.
 
 	// parse each inbound message
 	for _, glMsg := range payload.InboundSMSMessageList.InboundSMSMessage {
-		// parse our date from format: "Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)"
-		date, err := time.Parse("Mon Jan 2 2006 15:04:05 GMT+0000 (UTC)", glMsg.DateTime)
+		date, err := timestampFormat.Parse(glMsg.DateTime)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, c, w, r, err)
 		}
@@ -129,7 +130,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 	for _, part := range parts {
 		payload := &mtPayload{}
 		payload.Address = strings.TrimPrefix(msg.URN().Path(), "+")
@@ -155,6 +156,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 	return nil
 }
@@ -0,0 +1,142 @@
+package googlebm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/stringsx"
+)
+
+// moPayload is the body of webhook requests
+// https://developers.google.com/business-communications/business-messages/reference/rest/v1/UserMessage
+type moPayload struct {
+	// only set on webhook verification requests
+	ClientToken string `json:"clientToken"`
+	Secret      string `json:"secret"`
+
+	Agent          string `json:"agent"`
+	ConversationID string `json:"conversationId"`
+	RequestID      string `json:"requestId"`
+	Context        struct {
+		UserInfo struct {
+			DisplayName string `json:"displayName"`
+		} `json:"userInfo"`
+	} `json:"context"`
+	Message *struct {
+		MessageID  string    `json:"messageId"`
+		Name       string    `json:"name"`
+		Text       string    `json:"text"`
+		CreateTime time.Time `json:"createTime"`
+		Image      *mtImage  `json:"image"`
+	} `json:"message"`
+	SuggestionResponse *struct {
+		Message      string    `json:"message"`
+		PostbackData string    `json:"postbackData"`
+		Text         string    `json:"text"`
+		Type         string    `json:"type"`
+		CreateTime   time.Time `json:"createTime"`
+	} `json:"suggestionResponse"`
+	Receipts *struct {
+		Receipts []struct {
+			Message     string `json:"message"`
+			ReceiptType string `json:"receiptType"`
+		} `json:"receipts"`
+	} `json:"receipts"`
+}
+
+// mtPayload is a message we send
+// https://developers.google.com/business-communications/business-messages/reference/rest/v1/conversations.messages
+type mtPayload struct {
+	MessageID      string `json:"messageId"`
+	Representative struct {
+		RepresentativeType string `json:"representativeType"`
+	} `json:"representative"`
+	Text        string          `json:"text,omitempty"`
+	Image       *mtImage        `json:"image,omitempty"`
+	Suggestions []*mtSuggestion `json:"suggestions,omitempty"`
+}
+
+type mtImage struct {
+	ContentInfo struct {
+		FileURL string `json:"fileUrl"`
+	} `json:"contentInfo"`
+}
+
+type mtSuggestion struct {
+	Reply  *mtSuggestedReply  `json:"reply,omitempty"`
+	Action *mtSuggestedAction `json:"action,omitempty"`
+}
+
+type mtSuggestedReply struct {
+	Text         string `json:"text"`
+	PostbackData string `json:"postbackData"`
+}
+
+type mtSuggestedAction struct {
+	Text          string `json:"text"`
+	PostbackData  string `json:"postbackData"`
+	OpenURLAction *struct {
+		URL string `json:"url"`
+	} `json:"openUrlAction,omitempty"`
+	DialAction *struct {
+		PhoneNumber string `json:"phoneNumber"`
+	} `json:"dialAction,omitempty"`
+}
+
+// suggested actions are requested by the message metadata, e.g.
+//
+//	"actions": [{"text": "Visit us", "url": "https://example.com"}, {"text": "Call us", "phone": "+12065551234"}]
+type actionMetadata struct {
+	Text  string `json:"text"`
+	URL   string `json:"url"`
+	Phone string `json:"phone"`
+}
+
+// suggestion text is limited to 25 characters
+const maxSuggestionText = 25
+
+// builds the suggestions for a message from its quick replies and any suggested actions in its metadata
+func getSuggestions(msg courier.MsgOut) ([]*mtSuggestion, error) {
+	suggestions := make([]*mtSuggestion, 0, len(msg.QuickReplies()))
+
+	for _, qr := range msg.QuickReplies() {
+		suggestions = append(suggestions, &mtSuggestion{Reply: &mtSuggestedReply{Text: stringsx.TruncateEllipsis(qr, maxSuggestionText), PostbackData: qr}})
+	}
+
+	if raw, _, _, err := jsonparser.Get(msg.Metadata(), "actions"); err == nil {
+		var actions []actionMetadata
+		if err := json.Unmarshal(raw, &actions); err != nil {
+			return nil, fmt.Errorf("unable to parse actions: %w", err)
+		}
+
+		for _, a := range actions {
+			if a.Text == "" {
+				return nil, errors.New("action text is required")
+			}
+
+			action := &mtSuggestedAction{Text: stringsx.TruncateEllipsis(a.Text, maxSuggestionText), PostbackData: a.Text}
+			if a.URL != "" {
+				action.OpenURLAction = &struct {
+					URL string `json:"url"`
+				}{URL: a.URL}
+			} else if a.Phone != "" {
+				action.DialAction = &struct {
+					PhoneNumber string `json:"phoneNumber"`
+				}{PhoneNumber: a.Phone}
+			} else {
+				return nil, fmt.Errorf("action '%s' must have a url or phone", a.Text)
+			}
+
+			suggestions = append(suggestions, &mtSuggestion{Action: action})
+		}
+	}
+
+	if len(suggestions) > maxSuggestions {
+		return nil, fmt.Errorf("too many suggestions, max is %d", maxSuggestions)
+	}
+	return suggestions, nil
+}
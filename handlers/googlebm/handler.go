@@ -0,0 +1,326 @@
+package googlebm
+
+/*
+Handles Google Business Messages agents. Webhook requests are signed with the agent's client token and messages are
+sent with an access token for the channel's service account. Contacts are identified by their conversation ID.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	configClientToken = "client_token"
+	configCredentials = "credentials_json"
+
+	signatureHeader = "X-Goog-Signature"
+)
+
+var (
+	sendURL        = "https://businessmessages.googleapis.com/v1/conversations"
+	scope          = "https://www.googleapis.com/auth/businessmessages"
+	maxMsgLength   = 3072
+	maxSuggestions = 13
+	maxRequestBody = int64(1024 * 1024)
+
+	receiptStatuses = map[string]courier.MsgStatus{
+		"DELIVERED": courier.MsgStatusDelivered,
+		"READ":      courier.MsgStatusRead,
+	}
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	fetchTokenMutex sync.Mutex
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("GBM"), "Google Business Messages", handlers.WithRedactConfigKeys(configClientToken))}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, h.receiveEvent)
+	return nil
+}
+
+// receiveEvent is our HTTP handler function for webhook verifications, messages, suggestion responses and receipts
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	body, err := handlers.ReadBody(r, maxRequestBody)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	payload := &moPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse request JSON: %w", err))
+	}
+
+	clientToken := channel.StringConfigForKey(configClientToken, "")
+
+	// webhook verification requests aren't signed but must include our client token
+	if payload.Secret != "" {
+		clog.Type = courier.ChannelLogTypeWebhookVerify
+
+		if payload.ClientToken == "" || payload.ClientToken != clientToken {
+			return nil, courier.WriteAndLogUnauthorized(w, r, channel, errors.New("invalid client token"))
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(payload.Secret))
+		return nil, err
+	}
+
+	if !validSignature(clientToken, body, r.Header.Get(signatureHeader)) {
+		return nil, courier.WriteAndLogUnauthorized(w, r, channel, errors.New("invalid request signature"))
+	}
+
+	if payload.Receipts != nil {
+		clog.Type = courier.ChannelLogTypeMsgStatus
+
+		return h.receiveReceipts(ctx, channel, w, r, payload, clog)
+	}
+
+	urn, err := urns.New(urns.External, payload.ConversationID)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	var text, externalID, attachment string
+	var date time.Time
+
+	if payload.Message != nil {
+		text, externalID, date = payload.Message.Text, payload.Message.MessageID, payload.Message.CreateTime
+		if payload.Message.Image != nil {
+			attachment = payload.Message.Image.ContentInfo.FileURL
+		}
+	} else if payload.SuggestionResponse != nil {
+		text, date = payload.SuggestionResponse.PostbackData, payload.SuggestionResponse.CreateTime
+		externalID = messageIDFromName(payload.SuggestionResponse.Message)
+		if text == "" {
+			text = payload.SuggestionResponse.Text
+		}
+	} else {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring request, no message")
+	}
+
+	clog.Type = courier.ChannelLogTypeMsgReceive
+
+	if date.IsZero() {
+		date = time.Now().UTC()
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, externalID, clog).WithReceivedOn(date).WithContactName(payload.Context.UserInfo.DisplayName)
+	if attachment != "" {
+		msg.WithAttachment(attachment)
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+func (h *handler) receiveReceipts(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	statuses := make([]courier.StatusUpdate, 0, len(payload.Receipts.Receipts))
+	events := make([]courier.Event, 0, len(payload.Receipts.Receipts))
+
+	for _, receipt := range payload.Receipts.Receipts {
+		msgStatus, found := receiptStatuses[receipt.ReceiptType]
+		if !found {
+			continue
+		}
+
+		status := h.Backend().NewStatusUpdateByExternalID(channel, messageIDFromName(receipt.Message), msgStatus, clog)
+		if err := h.Backend().WriteStatusUpdate(ctx, status); err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, status)
+		events = append(events, status)
+	}
+
+	if len(statuses) == 0 {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring request, no known receipt types")
+	}
+
+	return events, courier.WriteStatusSuccess(w, statuses)
+}
+
+// Send sends the given message, logging any HTTP calls or errors
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	suggestions, err := getSuggestions(msg)
+	if err != nil {
+		clog.RawError(err)
+		return courier.ErrMessageInvalid
+	}
+
+	token, err := h.getAccessToken(msg.Channel())
+	if err != nil {
+		return err
+	}
+
+	// each text part and image is a separate message whose ID we generate from the courier message UUID
+	payloads := make([]*mtPayload, 0, 2)
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLength) {
+		if part != "" {
+			payloads = append(payloads, &mtPayload{Text: part})
+		}
+	}
+	for _, a := range msg.Attachments() {
+		_, attURL := handlers.SplitAttachment(a)
+		p := &mtPayload{}
+		p.Image = &mtImage{}
+		p.Image.ContentInfo.FileURL = attURL
+		payloads = append(payloads, p)
+	}
+
+	if len(payloads) == 0 {
+		return courier.ErrMessageInvalid
+	}
+
+	// suggestions are shown below the last message
+	payloads[len(payloads)-1].Suggestions = suggestions
+
+	for i, p := range payloads {
+		p.MessageID = string(msg.UUID())
+		if i > 0 {
+			p.MessageID = fmt.Sprintf("%s-%d", msg.UUID(), i)
+		}
+		p.Representative.RepresentativeType = "BOT"
+
+		if err := h.sendPayload(msg.URN().Path(), p, token, clog); err != nil {
+			return err
+		}
+		res.AddExternalID(p.MessageID)
+	}
+
+	return nil
+}
+
+func (h *handler) sendPayload(conversationID string, payload *mtPayload, token string, clog *courier.ChannelLog) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s/messages", sendURL, conversationID), bytes.NewReader(jsonx.MustMarshal(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return courier.ErrConnectionThrottled
+	} else if resp.StatusCode/100 != 2 {
+		status, _ := jsonparser.GetString(respBody, "error", "status")
+		message, _ := jsonparser.GetString(respBody, "error", "message")
+		if status != "" {
+			clog.Error(courier.ErrorExternal(status, message))
+		}
+
+		// the conversation no longer exists, e.g. the user deleted it
+		if resp.StatusCode == http.StatusNotFound {
+			return courier.ErrContactStopped
+		}
+		return courier.ErrResponseStatus
+	}
+	return nil
+}
+
+// messages are referenced by names like conversations/<conversation>/messages/<message>
+func messageIDFromName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func validSignature(clientToken string, body []byte, signature string) bool {
+	if clientToken == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha512.New, []byte(clientToken))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (h *handler) getAccessToken(channel courier.Channel) (string, error) {
+	tokenKey := fmt.Sprintf("channel-token:%s", channel.UUID())
+
+	h.fetchTokenMutex.Lock()
+	defer h.fetchTokenMutex.Unlock()
+
+	var token string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		token, err = redis.String(rc.Do("GET", tokenKey))
+	})
+
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading cached access token: %w", err)
+	}
+
+	if token != "" {
+		return token, nil
+	}
+
+	token, expires, err := h.fetchAccessToken(channel)
+	if err != nil {
+		return "", err
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("SET", tokenKey, token, "EX", int(expires/time.Second))
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error updating cached access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// fetchAccessToken creates a new self-signed token for the channel's service account
+func (h *handler) fetchAccessToken(channel courier.Channel) (string, time.Duration, error) {
+	credentials, _ := channel.ConfigForKey(configCredentials, nil).(map[string]any)
+	if credentials == nil {
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	ts, err := google.JWTAccessTokenSourceWithScope(jsonx.MustMarshal(credentials), scope)
+	if err != nil {
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating access token: %w", err)
+	}
+
+	return token.AccessToken, time.Until(token.Expiry), nil
+}
@@ -0,0 +1,357 @@
+package googlebm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "GBM", "brands/1234/agents/5678", "", []string{urns.External.Prefix}, map[string]any{configClientToken: "sesame"}),
+}
+
+const (
+	receiveURL = "/c/gbm/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"
+)
+
+var verifyBody = `{"clientToken": "sesame", "secret": "1234567890"}`
+
+var textMsg = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"requestId": "req1",
+	"context": {"userInfo": {"displayName": "Bob", "userDeviceLocale": "en-US"}},
+	"message": {
+		"name": "conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages/3f2bd79e-9e5c-4b89-8b33-7a6a0ac3e2f4",
+		"messageId": "3f2bd79e-9e5c-4b89-8b33-7a6a0ac3e2f4",
+		"text": "Hello World",
+		"createTime": "2024-10-15T12:30:45.123Z"
+	},
+	"sendTime": "2024-10-15T12:30:45.456Z"
+}`
+
+var imageMsg = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"context": {"userInfo": {"displayName": "Bob"}},
+	"message": {
+		"messageId": "6a1c0f2e-6a45-4a0a-86c1-2d8c1ee1f0d3",
+		"image": {"contentInfo": {"fileUrl": "https://storage.googleapis.com/image.jpg"}},
+		"createTime": "2024-10-15T12:30:45.123Z"
+	}
+}`
+
+var suggestionResponse = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"context": {"userInfo": {"displayName": "Bob"}},
+	"suggestionResponse": {
+		"message": "conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages/9b4f3e22-0d1e-4bd8-a3a1-2f7bb1b0e0c7",
+		"postbackData": "Yes",
+		"text": "Yes please",
+		"createTime": "2024-10-15T12:31:00Z",
+		"type": "REPLY"
+	}
+}`
+
+var receipts = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"receipts": {
+		"receipts": [
+			{"message": "conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages/0191e180-7d60-7000-aded-7d8b151cbd5b", "receiptType": "DELIVERED"},
+			{"message": "conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages/0191e180-7d60-7000-aded-7d8b151cbd5b-1", "receiptType": "READ"}
+		],
+		"createTime": "2024-10-15T12:32:00Z"
+	}
+}`
+
+var unknownReceipts = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"receipts": {"receipts": [{"message": "conversations/x/messages/y", "receiptType": "RECEIPT_TYPE_UNSPECIFIED"}]}
+}`
+
+var userStatus = `{
+	"agent": "brands/1234/agents/5678",
+	"conversationId": "4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+	"userStatus": {"isTyping": true, "createTime": "2024-10-15T12:32:00Z"}
+}`
+
+var testCases = []IncomingTestCase{
+	{
+		Label:               "Receive Text Message",
+		URL:                 receiveURL,
+		Data:                textMsg,
+		PrepRequest:         addValidSignature,
+		ExpectedRespStatus:  200,
+		ExpectedContactName: Sp("Bob"),
+		ExpectedMsgText:     Sp("Hello World"),
+		ExpectedURN:         "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		ExpectedExternalID:  "3f2bd79e-9e5c-4b89-8b33-7a6a0ac3e2f4",
+		ExpectedDate:        time.Date(2024, 10, 15, 12, 30, 45, 123000000, time.UTC),
+	},
+	{
+		Label:                "Webhook Verification",
+		URL:                  receiveURL,
+		Data:                 verifyBody,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "1234567890",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Webhook Verification Wrong Token",
+		URL:                  receiveURL,
+		Data:                 `{"clientToken": "wrong", "secret": "1234567890"}`,
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid client token",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:               "Receive Image Message",
+		URL:                 receiveURL,
+		Data:                imageMsg,
+		PrepRequest:         addValidSignature,
+		ExpectedRespStatus:  200,
+		ExpectedContactName: Sp("Bob"),
+		ExpectedMsgText:     Sp(""),
+		ExpectedURN:         "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		ExpectedAttachments: []string{"https://storage.googleapis.com/image.jpg"},
+		ExpectedExternalID:  "6a1c0f2e-6a45-4a0a-86c1-2d8c1ee1f0d3",
+	},
+	{
+		Label:               "Receive Suggestion Response",
+		URL:                 receiveURL,
+		Data:                suggestionResponse,
+		PrepRequest:         addValidSignature,
+		ExpectedRespStatus:  200,
+		ExpectedContactName: Sp("Bob"),
+		ExpectedMsgText:     Sp("Yes"),
+		ExpectedURN:         "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		ExpectedExternalID:  "9b4f3e22-0d1e-4bd8-a3a1-2f7bb1b0e0c7",
+		ExpectedDate:        time.Date(2024, 10, 15, 12, 31, 0, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Receipts",
+		URL:                  receiveURL,
+		Data:                 receipts,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"type":"status"`,
+		ExpectedStatuses: []ExpectedStatus{
+			{ExternalID: "0191e180-7d60-7000-aded-7d8b151cbd5b", Status: courier.MsgStatusDelivered},
+			{ExternalID: "0191e180-7d60-7000-aded-7d8b151cbd5b-1", Status: courier.MsgStatusRead},
+		},
+	},
+	{
+		Label:                "Receive Unknown Receipts",
+		URL:                  receiveURL,
+		Data:                 unknownReceipts,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring request, no known receipt types",
+	},
+	{
+		Label:                "Receive User Status",
+		URL:                  receiveURL,
+		Data:                 userStatus,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring request, no message",
+	},
+	{
+		Label:                "Invalid Signature",
+		URL:                  receiveURL,
+		Data:                 textMsg,
+		Headers:              map[string]string{signatureHeader: "bad"},
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid request signature",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Missing Signature",
+		URL:                  receiveURL,
+		Data:                 textMsg,
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid request signature",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Invalid JSON",
+		URL:                  receiveURL,
+		Data:                 "not json",
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unable to parse request JSON",
+	},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	mac := hmac.New(sha512.New, []byte("sesame"))
+	mac.Write(body)
+	r.Header.Set(signatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"conversationId":"123"}`)
+
+	assert.False(t, validSignature("", body, "sig"))
+	assert.False(t, validSignature("sesame", body, ""))
+	assert.False(t, validSignature("sesame", body, "sig"))
+	assert.True(t, validSignature("sesame", body, "lhMGG1MRAW834PLg/TZhz0KuTJGHbdJNyn0vwLQJ87Czq9UhnuDeQkaFM17KRcy5IudItoWb00D6zAAkbo0zZQ=="))
+}
+
+func TestMessageIDFromName(t *testing.T) {
+	assert.Equal(t, "abc", messageIDFromName("conversations/123/messages/abc"))
+	assert.Equal(t, "abc", messageIDFromName("abc"))
+}
+
+var conversationURL = "https://businessmessages.googleapis.com/v1/conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages"
+
+var defaultSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "Plain Send",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {httpx.NewMockResponse(200, nil, []byte(`{"name":"conversations/4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1/messages/0191e180-7d60-7000-aded-7d8b151cbd5b"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Headers: map[string]string{"Authorization": "Bearer ACCESS_TOKEN"},
+				Body:    `{"messageId":"0191e180-7d60-7000-aded-7d8b151cbd5b","representative":{"representativeType":"BOT"},"text":"Simple Message"}`,
+			},
+		},
+		ExpectedExtIDs: []string{"0191e180-7d60-7000-aded-7d8b151cbd5b"},
+	},
+	{
+		Label:           "Send With Suggestions",
+		MsgUUID:         "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:         "Pick one",
+		MsgURN:          "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MsgQuickReplies: []string{"Yes", "No"},
+		MsgMetadata:     `{"actions": [{"text": "Visit us", "url": "https://example.com"}, {"text": "Call us", "phone": "+12065551234"}]}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {httpx.NewMockResponse(200, nil, []byte(`{}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"messageId":"0191e180-7d60-7000-aded-7d8b151cbd5b","representative":{"representativeType":"BOT"},"text":"Pick one","suggestions":[` +
+					`{"reply":{"text":"Yes","postbackData":"Yes"}},{"reply":{"text":"No","postbackData":"No"}},` +
+					`{"action":{"text":"Visit us","postbackData":"Visit us","openUrlAction":{"url":"https://example.com"}}},` +
+					`{"action":{"text":"Call us","postbackData":"Call us","dialAction":{"phoneNumber":"+12065551234"}}}]}`,
+			},
+		},
+		ExpectedExtIDs: []string{"0191e180-7d60-7000-aded-7d8b151cbd5b"},
+	},
+	{
+		Label:           "Send Text And Image",
+		MsgUUID:         "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:         "Look",
+		MsgURN:          "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MsgAttachments:  []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MsgQuickReplies: []string{"Nice"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {
+				httpx.NewMockResponse(200, nil, []byte(`{}`)),
+				httpx.NewMockResponse(200, nil, []byte(`{}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"messageId":"0191e180-7d60-7000-aded-7d8b151cbd5b","representative":{"representativeType":"BOT"},"text":"Look"}`},
+			{Body: `{"messageId":"0191e180-7d60-7000-aded-7d8b151cbd5b-1","representative":{"representativeType":"BOT"},"image":{"contentInfo":{"fileUrl":"https://foo.bar/image.jpg"}},"suggestions":[{"reply":{"text":"Nice","postbackData":"Nice"}}]}`},
+		},
+		ExpectedExtIDs: []string{"0191e180-7d60-7000-aded-7d8b151cbd5b", "0191e180-7d60-7000-aded-7d8b151cbd5b-1"},
+	},
+	{
+		Label:             "Invalid Actions",
+		MsgText:           "Pick one",
+		MsgURN:            "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MsgMetadata:       `{"actions": [{"text": "Visit us"}]}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "action 'Visit us' must have a url or phone")},
+	},
+	{
+		Label:   "Conversation Not Found",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {httpx.NewMockResponse(404, nil, []byte(`{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND"}}`))},
+		},
+		ExpectedRequests:  []ExpectedRequest{{}},
+		ExpectedError:     courier.ErrContactStopped,
+		ExpectedLogErrors: []*clogs.LogError{courier.ErrorExternal("NOT_FOUND", "Requested entity was not found.")},
+	},
+	{
+		Label:   "Error Sending",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {httpx.NewMockResponse(400, nil, []byte(`{"error":{"code":400,"message":"Invalid message.","status":"INVALID_ARGUMENT"}}`))},
+		},
+		ExpectedRequests:  []ExpectedRequest{{}},
+		ExpectedError:     courier.ErrResponseStatus,
+		ExpectedLogErrors: []*clogs.LogError{courier.ErrorExternal("INVALID_ARGUMENT", "Invalid message.")},
+	},
+	{
+		Label:   "Connection Error",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:4b2a8e3c-1fb0-4d3a-9a70-4c2cf0a2d6d1",
+		MockResponses: map[string][]*httpx.MockResponse{
+			conversationURL: {httpx.NewMockResponse(503, nil, []byte(`Service Unavailable`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+}
+
+func setupBackend(mb *test.MockBackend) {
+	// ensure there's a cached access token
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("SET", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "ACCESS_TOKEN")
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, []string{"sesame"}, setupBackend)
+}
+
+func TestFetchAccessToken(t *testing.T) {
+	h := newHandler().(*handler)
+
+	// no credentials configured
+	_, _, err := h.fetchAccessToken(testChannels[0])
+	assert.Equal(t, courier.ErrChannelConfig, err)
+
+	// invalid credentials
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "GBM", "brands/1234/agents/5678", "", []string{urns.External.Prefix}, map[string]any{configCredentials: map[string]any{"type": "service_account"}})
+	_, _, err = h.fetchAccessToken(ch)
+	assert.Error(t, err)
+}
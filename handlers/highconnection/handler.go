@@ -19,6 +19,9 @@ var (
 	maxMsgLength = 1500
 )
 
+// timestamps are in UTC, e.g. 2015-04-02T14:26:06
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -57,7 +60,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	date := time.Now()
 	if form.ReceiveDate != "" {
-		date, err = time.Parse("2006-01-02T15:04:05", form.ReceiveDate)
+		date, err = timestampFormat.Parse(form.ReceiveDate)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 		}
@@ -81,7 +84,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// build our Message
-	msg := h.Backend().NewIncomingMsg(channel, urn, text, msgID, clog).WithReceivedOn(date.UTC())
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, msgID, clog).WithReceivedOn(date)
 
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
@@ -133,7 +136,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	statusURL := fmt.Sprintf("https://%s/c/hx/%s/status", callbackDomain, msg.Channel().UUID())
 	receiveURL := fmt.Sprintf("https://%s/c/hx/%s/receive", callbackDomain, msg.Channel().UUID())
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 
 	var flowName string
 	if msg.Flow() != nil {
@@ -168,6 +171,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			return courier.ErrResponseStatus
 		}
 
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -93,7 +93,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		form := url.Values{
 			"action":  []string{"send_single"},
 			"mobile":  []string{strings.TrimLeft(msg.URN().Path(), "+")},
@@ -129,6 +129,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			return courier.ErrFailedWithReason(response.ErrorCode, response.ErrorDesc)
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -20,6 +20,12 @@ var sendURL = "https://api.infobip.com/sms/1/text/advanced"
 
 const configTransliteration = "transliteration"
 
+// maximum number of messages we send to Infobip in a single request
+const maxBulkSize = 100
+
+// timestamps include an offset, e.g. 2016-08-26T12:08:03.124+0000
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.999999999-0700"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -134,7 +140,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		date := time.Now()
 		var err error
 		if dateString != "" {
-			date, err = time.Parse("2006-01-02T15:04:05.999999999-0700", dateString)
+			date, err = timestampFormat.Parse(dateString)
 			if err != nil {
 				return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 			}
@@ -160,34 +166,48 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 }
 
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
-	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
-	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if err := h.SendBulk(ctx, []courier.MsgOut{msg}, []*courier.SendResult{res}, clog); err != nil {
+		return err
+	}
+	return res.GetError()
+}
+
+// MaxBulkSize returns the maximum number of messages we send in a single request, bulk sending is opt-in per channel
+func (h *handler) MaxBulkSize(ch courier.Channel) int {
+	return min(ch.IntConfigForKey(courier.ConfigMaxBulkSize, 1), maxBulkSize)
+}
+
+// SendBulk sends the passed in messages, which are all for the same channel, in a single request
+func (h *handler) SendBulk(ctx context.Context, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	channel := msgs[0].Channel()
+
+	username := channel.StringConfigForKey(courier.ConfigUsername, "")
+	password := channel.StringConfigForKey(courier.ConfigPassword, "")
 	if username == "" || password == "" {
 		return courier.ErrChannelConfig
 	}
 
-	transliteration := msg.Channel().StringConfigForKey(configTransliteration, "")
+	transliteration := channel.StringConfigForKey(configTransliteration, "")
 
-	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
-	statusURL := fmt.Sprintf("https://%s%s%s/delivered", callbackDomain, "/c/ib/", msg.Channel().UUID())
+	callbackDomain := channel.CallbackDomain(h.Server().Config().Domain)
+	statusURL := fmt.Sprintf("https://%s%s%s/delivered", callbackDomain, "/c/ib/", channel.UUID())
 
-	ibMsg := mtPayload{
-		Messages: []mtMessage{
-			{
-				From: msg.Channel().Address(),
-				Destinations: []mtDestination{
-					{
-						To:        strings.TrimLeft(msg.URN().Path(), "+"),
-						MessageID: msg.ID().String(),
-					},
+	ibMsg := mtPayload{Messages: make([]mtMessage, len(msgs))}
+	for i, msg := range msgs {
+		ibMsg.Messages[i] = mtMessage{
+			From: msg.Channel().Address(), // can differ between messages if the channel has a sender pool
+			Destinations: []mtDestination{
+				{
+					To:        strings.TrimLeft(msg.URN().Path(), "+"),
+					MessageID: msg.ID().String(),
 				},
-				Text:               handlers.GetTextAndAttachments(msg),
-				NotifyContentType:  "application/json",
-				IntermediateReport: true,
-				NotifyURL:          statusURL,
-				Transliteration:    transliteration,
 			},
-		},
+			Text:               h.TextAndAttachments(msg),
+			NotifyContentType:  "application/json",
+			IntermediateReport: true,
+			NotifyURL:          statusURL,
+			Transliteration:    transliteration,
+		}
 	}
 
 	requestBody := &bytes.Buffer{}
@@ -212,17 +232,22 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
-	groupID, err := jsonparser.GetInt(respBody, "messages", "[0]", "status", "groupId")
-	if err != nil || (groupID != 1 && groupID != 3) {
-		return courier.ErrResponseContent
-	}
+	// response contains a result for each message in the order they were sent
+	for i, res := range results {
+		groupID, err := jsonparser.GetInt(respBody, "messages", fmt.Sprintf("[%d]", i), "status", "groupId")
+		if err != nil || (groupID != 1 && groupID != 3) {
+			res.SetError(courier.ErrResponseContent)
+			continue
+		}
 
-	externalID, err := jsonparser.GetString(respBody, "messages", "[0]", "messageId")
-	if err != nil {
-		clog.Error(courier.ErrorResponseValueMissing("messageId"))
-	} else {
+		handlers.AddSMSSegments(res, ibMsg.Messages[i].Text)
 
-		res.AddExternalID(externalID)
+		externalID, err := jsonparser.GetString(respBody, "messages", fmt.Sprintf("[%d]", i), "messageId")
+		if err != nil {
+			clog.Error(courier.ErrorResponseValueMissing("messageId"))
+		} else {
+			res.AddExternalID(externalID)
+		}
 	}
 
 	return nil
@@ -1,6 +1,9 @@
 package infobip
 
 import (
+	"context"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +13,8 @@ import (
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testChannels = []courier.Channel{
@@ -439,3 +444,49 @@ func TestOutgoing(t *testing.T) {
 
 	RunOutgoingTestCases(t, transChannel, newHandler(), transSendTestCases, []string{httpx.BasicAuth("Username", "Password")}, nil)
 }
+
+func TestSendBulk(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{
+			courier.ConfigPassword:    "Password",
+			courier.ConfigUsername:    "Username",
+			courier.ConfigMaxBulkSize: 50,
+		})
+
+	mb := test.NewMockBackend()
+	h := newHandler().(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	assert.Equal(t, 50, h.MaxBulkSize(ch))
+
+	// configured sizes are capped at what Infobip will accept
+	bigCh := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IB", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxBulkSize: 5000})
+	assert.Equal(t, 100, h.MaxBulkSize(bigCh))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.infobip.com/sms/1/text/advanced": {
+			httpx.NewMockResponse(200, nil, []byte(`{"messages":[{"status":{"groupId": 1}, "messageId": "12345"},{"status":{"groupId": 5}, "messageId": "12346"}]}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	msgs := []courier.MsgOut{
+		test.NewMockMsg(10, "", ch, "tel:+250788383383", "Hi Bob", nil),
+		test.NewMockMsg(11, "", ch, "tel:+250788383384", "Hi Ann", nil),
+	}
+	results := []*courier.SendResult{{}, {}}
+	clog := courier.NewChannelLogForSend(msgs[0], h.RedactValues(ch))
+
+	err := h.SendBulk(context.Background(), msgs, results, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"12345"}, results[0].ExternalIDs())
+	assert.NoError(t, results[0].GetError())
+	assert.Nil(t, results[1].ExternalIDs())
+	assert.Equal(t, courier.ErrResponseContent, results[1].GetError())
+
+	require.Len(t, mocks.Requests(), 1)
+	body, _ := io.ReadAll(mocks.Requests()[0].Body)
+	assert.Equal(t, `{"messages":[{"from":"2020","destinations":[{"to":"250788383383","messageId":"10"}],"text":"Hi Bob","notifyContentType":"application/json","intermediateReport":true,"notifyUrl":"https://localhost/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered"},{"from":"2020","destinations":[{"to":"250788383384","messageId":"11"}],"text":"Hi Ann","notifyContentType":"application/json","intermediateReport":true,"notifyUrl":"https://localhost/c/ib/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered"}]}`, strings.TrimSpace(string(body)))
+}
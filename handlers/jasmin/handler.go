@@ -130,6 +130,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
 	dlrURL := fmt.Sprintf("https://%s/c/js/%s/status", callbackDomain, msg.Channel().UUID())
 
+	text := gsm7.ReplaceSubstitutions(h.TextAndAttachments(msg))
+
 	// build our request
 	form := url.Values{
 		"username":   []string{username},
@@ -141,7 +143,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		"dlr-level":  []string{"2"},
 		"dlr-method": []string{http.MethodPost},
 		"coding":     []string{"0"},
-		"content":    []string{string(gsm7.Encode(gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))))},
+		"content":    []string{string(gsm7.Encode(text))},
 	}
 
 	fullURL, _ := url.Parse(sendURL)
@@ -165,5 +167,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		res.AddExternalID(string(matches[1]))
 	}
 
+	handlers.AddSMSSegments(res, text)
+
 	return nil
 }
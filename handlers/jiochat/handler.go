@@ -12,11 +12,9 @@ import (
 	"net/url"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/buger/jsonparser"
-	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/gocommon/jsonx"
@@ -40,14 +38,13 @@ func init() {
 type handler struct {
 	handlers.BaseHandler
 
-	fetchTokenMutex sync.Mutex
+	tokens *handlers.TokenManager
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{
-		BaseHandler:     handlers.NewBaseHandler(courier.ChannelType("JC"), "Jiochat"),
-		fetchTokenMutex: sync.Mutex{},
-	}
+	h := &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("JC"), "Jiochat")}
+	h.tokens = handlers.NewTokenManager(h)
+	return h
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -114,7 +111,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("missing parameters, must have either 'MsgId' or 'Event'"))
 	}
 
-	date := time.Unix(payload.CreateTime/1000, payload.CreateTime%1000*1000000).UTC()
+	date := handlers.ParseUnixTimestamp(payload.CreateTime, handlers.TimestampMillis)
 	urn, err := urns.New(urns.JioChat, payload.FromUsername)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid jiochat id"))
@@ -163,7 +160,7 @@ type mtPayload struct {
 }
 
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
-	accessToken, err := h.getAccessToken(msg.Channel(), clog)
+	accessToken, err := h.tokens.Token(ctx, msg.Channel(), clog)
 	if err != nil {
 		return courier.ErrChannelConfig
 	}
@@ -198,7 +195,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 // DescribeURN handles Jiochat contact details
 func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn urns.URN, clog *courier.ChannelLog) (map[string]string, error) {
-	accessToken, err := h.getAccessToken(channel, clog)
+	accessToken, err := h.tokens.Token(ctx, channel, clog)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +234,7 @@ func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend,
 		return nil, err
 	}
 
-	accessToken, err := h.getAccessToken(channel, clog)
+	accessToken, err := h.tokens.Token(ctx, channel, clog)
 	if err != nil {
 		return nil, err
 	}
@@ -250,50 +247,14 @@ func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend,
 
 var _ courier.AttachmentRequestBuilder = (*handler)(nil)
 
-func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
-	tokenKey := fmt.Sprintf("channel-token:%s", channel.UUID())
-
-	h.fetchTokenMutex.Lock()
-	defer h.fetchTokenMutex.Unlock()
-
-	var token string
-	var err error
-	h.WithRedisConn(func(rc redis.Conn) {
-		token, err = redis.String(rc.Do("GET", tokenKey))
-	})
-
-	if err != nil && err != redis.ErrNil {
-		return "", fmt.Errorf("error reading cached access token: %w", err)
-	}
-
-	if token != "" {
-		return token, nil
-	}
-
-	token, expires, err := h.fetchAccessToken(channel, clog)
-	if err != nil {
-		return "", fmt.Errorf("error fetching new access token: %w", err)
-	}
-
-	h.WithRedisConn(func(rc redis.Conn) {
-		_, err = rc.Do("SET", tokenKey, token, "EX", int(expires/time.Second))
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("error updating cached access token: %w", err)
-	}
-
-	return token, nil
-}
-
 type fetchPayload struct {
 	GrantType    string `json:"grant_type"`
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
 }
 
-// fetchAccessToken tries to fetch a new token for our channel
-func (h *handler) fetchAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+// FetchAccessToken fetches a new access token for the given channel
+func (h *handler) FetchAccessToken(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
 	tokenURL, _ := url.Parse(fmt.Sprintf("%s/%s", sendURL, "auth/token.action"))
 	payload := &fetchPayload{
 		GrantType:    "client_credentials",
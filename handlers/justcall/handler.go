@@ -30,6 +30,9 @@ func newHandler() courier.ChannelHandler {
 	return &handler{handlers.NewBaseHandler(courier.ChannelType("JCL"), "JustCall")}
 }
 
+// timestamps are in UTC, e.g. 2024-04-02 14:39:54
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02 15:04:05"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -100,14 +103,13 @@ func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.
 	}
 
 	dateString := payload.Data.Datetime
-	date := time.Now()
+	date := time.Now().UTC()
 	var err error
 	if dateString != "" {
-		date, err = time.Parse("2006-01-02 15:04:05", dateString)
+		date, err = timestampFormat.Parse(dateString)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, c, w, r, errors.New("invalid date format, must be RFC 3339"))
 		}
-		date = date.UTC()
 	}
 
 	urn, err := urns.ParsePhone(payload.Data.From, c.Country(), true, false)
@@ -170,7 +172,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			mediaURLs = append(mediaURLs, url)
 		}
 	} else {
-		text = handlers.GetTextAndAttachments(msg)
+		text = h.TextAndAttachments(msg)
 	}
 
 	payload := mtPayload{From: msg.Channel().Address(), To: msg.URN().Path(), Body: text}
@@ -210,5 +212,10 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		res.AddExternalID(strconv.Itoa(int(externalID)))
 	}
 
+	// messages with attachments are sent as MMS
+	if len(mediaURLs) == 0 {
+		handlers.AddSMSSegments(res, text)
+	}
+
 	return nil
 }
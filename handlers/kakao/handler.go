@@ -0,0 +1,271 @@
+package kakao
+
+/*
+Sends KakaoTalk Bizmessages to Korean phone numbers. Messages with templating are sent as AlimTalk notifications using
+the template code given as the template's external ID, and other messages are sent as FriendTalk messages which can
+only reach users who have added the sender's Kakao channel as a friend. If SMS fallback is enabled, messages which
+can't be delivered over KakaoTalk are sent as SMS or LMS from the channel address.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/stringsx"
+)
+
+const (
+	configSenderKey   = "sender_key"
+	configFallbackSMS = "fallback_sms"
+
+	typeAlimTalk       = "AT"
+	typeFriendTalk     = "FT"
+	typeFriendTalkImg  = "FI"
+	responseCodeOK     = "API_200"
+	smsMaxBytes        = 90 // longer fallback messages are sent as LMS
+	lmsMaxLength       = 2000
+	maxButtons         = 5
+	maxButtonNameChars = 14
+)
+
+var (
+	sendURL      = "https://bizmsg-web.kakaoenterprise.com/v2/send/kakao"
+	maxMsgLength = 1000
+
+	// send result codes which tell us about the recipient rather than the message
+	contactErrorCodes = map[string]error{
+		"K102": courier.ErrContactUnknown, // invalid phone number
+		"K103": courier.ErrContactStopped, // user has blocked the sender's Kakao channel
+		"K105": courier.ErrContactUnknown, // not a KakaoTalk user
+	}
+
+	statusMapping = map[string]courier.MsgStatus{
+		"delivered":          courier.MsgStatusDelivered,
+		"failed":             courier.MsgStatusFailed,
+		"fallback_delivered": courier.MsgStatusDelivered,
+		"fallback_failed":    courier.MsgStatusFailed,
+	}
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("KKO"), "Kakao Talk", handlers.WithRedactConfigKeys(courier.ConfigAPIKey))}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, handlers.JSONPayload(h, h.receiveStatus))
+	return nil
+}
+
+//	{
+//	  "uid": "20241015123045000001",
+//	  "cid": "0191e180-7d60-7000-aded-7d8b151cbd5b",
+//	  "status": "fallback_delivered",
+//	  "code": "K105",
+//	  "message": "Not a KakaoTalk user"
+//	}
+type statusPayload struct {
+	UID     string `json:"uid"     validate:"required"`
+	CID     string `json:"cid"`
+	Status  string `json:"status"  validate:"required"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// receiveStatus is our HTTP handler function for delivery result callbacks
+func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *statusPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	msgStatus, found := statusMapping[payload.Status]
+	if !found {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unknown status '%s', must be one of delivered, failed, fallback_delivered or fallback_failed", payload.Status))
+	}
+
+	// a fallback result means KakaoTalk delivery failed, so the reason for that is worth logging even if the SMS succeeded
+	if payload.Code != "" {
+		clog.Error(courier.ErrorExternal(payload.Code, payload.Message))
+	}
+
+	status := h.Backend().NewStatusUpdateByExternalID(channel, payload.UID, msgStatus, clog)
+	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
+}
+
+type mtButton struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type mtFallback struct {
+	Type    string `json:"type"`
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+type mtPayload struct {
+	MessageType  string      `json:"message_type"`
+	SenderKey    string      `json:"sender_key"`
+	CID          string      `json:"cid"`
+	PhoneNumber  string      `json:"phone_number"`
+	TemplateCode string      `json:"template_code,omitempty"`
+	Message      string      `json:"message"`
+	ImageURL     string      `json:"image_url,omitempty"`
+	Buttons      []*mtButton `json:"button,omitempty"`
+	Fallback     *mtFallback `json:"fallback,omitempty"`
+}
+
+// Send sends the given message, logging any HTTP calls or errors
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	apiKey := msg.Channel().StringConfigForKey(courier.ConfigAPIKey, "")
+	senderKey := msg.Channel().StringConfigForKey(configSenderKey, "")
+	if apiKey == "" || senderKey == "" {
+		return courier.ErrChannelConfig
+	}
+
+	base := mtPayload{
+		SenderKey:   senderKey,
+		CID:         string(msg.UUID()),
+		PhoneNumber: strings.TrimPrefix(msg.URN().Path(), "+"),
+	}
+
+	// AlimTalk notifications must match their approved template so are always sent as a single message
+	if msg.Templating() != nil {
+		if msg.Templating().ExternalID == "" {
+			return courier.ErrMessageInvalid
+		}
+
+		payload := base
+		payload.MessageType = typeAlimTalk
+		payload.TemplateCode = msg.Templating().ExternalID
+		payload.Message = msg.Text()
+		payload.Buttons = buttonsForQuickReplies(msg.QuickReplies())
+		payload.Fallback = h.fallback(msg.Channel(), msg.Text())
+
+		return h.sendPayload(msg.Channel(), apiKey, &payload, res, clog)
+	}
+
+	// FriendTalk messages can include a single image, other attachments are sent as links
+	var imageURL string
+	text := msg.Text()
+	for _, a := range msg.Attachments() {
+		mediaType, mediaURL := handlers.SplitAttachment(a)
+		if imageURL == "" && strings.HasPrefix(mediaType, "image/") {
+			imageURL = mediaURL
+		} else {
+			text += "\n" + mediaURL
+		}
+	}
+	text = strings.TrimSpace(text)
+
+	parts := handlers.SplitMsgByChannel(msg.Channel(), text, maxMsgLength)
+	for i, part := range parts {
+		payload := base
+		payload.MessageType = typeFriendTalk
+		payload.Message = part
+		payload.Fallback = h.fallback(msg.Channel(), part)
+
+		if i == 0 && imageURL != "" {
+			payload.MessageType = typeFriendTalkImg
+			payload.ImageURL = imageURL
+		}
+		if i == len(parts)-1 {
+			payload.Buttons = buttonsForQuickReplies(msg.QuickReplies())
+		}
+
+		if err := h.sendPayload(msg.Channel(), apiKey, &payload, res, clog); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *handler) sendPayload(channel courier.Channel, apiKey string, payload *mtPayload, res *courier.SendResult, clog *courier.ChannelLog) error {
+	url := channel.StringConfigForKey(courier.ConfigBaseURL, sendURL)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonx.MustMarshal(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return courier.ErrConnectionThrottled
+	} else if resp.StatusCode/100 != 2 {
+		return courier.ErrResponseStatus
+	}
+
+	code, _ := jsonparser.GetString(respBody, "code")
+	if contactErr, found := contactErrorCodes[code]; found {
+		return contactErr
+	} else if code != responseCodeOK {
+		message, _ := jsonparser.GetString(respBody, "message")
+		return courier.ErrFailedWithReason(code, message)
+	}
+
+	uid, _ := jsonparser.GetString(respBody, "uid")
+	if uid == "" {
+		return courier.ErrResponseUnexpected
+	}
+
+	res.AddExternalID(uid)
+	return nil
+}
+
+// fallback returns the SMS fallback for the given text if the channel has it enabled
+func (h *handler) fallback(channel courier.Channel, text string) *mtFallback {
+	if !channel.BoolConfigForKey(configFallbackSMS, false) {
+		return nil
+	}
+
+	// SMS in Korea is limited to 90 bytes of EUC-KR where Hangul characters take 2 bytes, longer texts need to be LMS
+	fallbackType := "SMS"
+	if eucKRLength(text) > smsMaxBytes {
+		fallbackType = "LMS"
+	}
+
+	return &mtFallback{Type: fallbackType, From: channel.Address(), Message: handlers.SplitMsgByChannel(channel, text, lmsMaxLength)[0]}
+}
+
+// buttonsForQuickReplies converts quick replies to bot keyword buttons which send back the button name when tapped
+func buttonsForQuickReplies(qrs []string) []*mtButton {
+	buttons := make([]*mtButton, 0, min(len(qrs), maxButtons))
+	for _, qr := range qrs {
+		if len(buttons) == maxButtons {
+			break
+		}
+		buttons = append(buttons, &mtButton{Name: stringsx.Truncate(qr, maxButtonNameChars), Type: "BK"})
+	}
+	return buttons
+}
+
+// eucKRLength approximates the number of bytes of the given text when encoded as EUC-KR
+func eucKRLength(text string) int {
+	n := 0
+	for _, r := range text {
+		if r < 0x80 {
+			n++
+		} else {
+			n += 2
+		}
+	}
+	return n
+}
@@ -0,0 +1,245 @@
+package kakao
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KKO", "0212345678", "KR", []string{"tel"}, map[string]any{
+		courier.ConfigAPIKey: "sesame",
+		configSenderKey:      "3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c",
+	}),
+}
+
+const statusURL = "/c/kko/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"
+
+var incomingCases = []IncomingTestCase{
+	{
+		Label:                "Status Delivered",
+		NoQueueErrorCheck:    true,
+		URL:                  statusURL,
+		Data:                 `{"uid": "20241015123045000001", "cid": "0191e180-7d60-7000-aded-7d8b151cbd5b", "status": "delivered"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"D"`,
+		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "20241015123045000001", Status: courier.MsgStatusDelivered}},
+	},
+	{
+		Label:                "Status Delivered By Fallback",
+		URL:                  statusURL,
+		Data:                 `{"uid": "20241015123045000001", "status": "fallback_delivered", "code": "K105", "message": "Not a KakaoTalk user"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"D"`,
+		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "20241015123045000001", Status: courier.MsgStatusDelivered}},
+		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("K105", "Not a KakaoTalk user")},
+	},
+	{
+		Label:                "Status Failed",
+		URL:                  statusURL,
+		Data:                 `{"uid": "20241015123045000001", "status": "failed", "code": "K102", "message": "Invalid phone number"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"F"`,
+		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "20241015123045000001", Status: courier.MsgStatusFailed}},
+		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("K102", "Invalid phone number")},
+	},
+	{
+		Label:                "Status Unknown",
+		URL:                  statusURL,
+		Data:                 `{"uid": "20241015123045000001", "status": "lost"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "unknown status 'lost'",
+	},
+	{
+		Label:                "Status Missing UID",
+		URL:                  statusURL,
+		Data:                 `{"status": "delivered"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "Field validation for 'UID' failed on the 'required' tag",
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), incomingCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), incomingCases)
+}
+
+var defaultSendTestCases = []OutgoingTestCase{
+	{
+		Label:           "FriendTalk Send",
+		MsgUUID:         "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:         "안녕하세요",
+		MsgURN:          "tel:+821012345678",
+		MsgQuickReplies: []string{"Yes", "No"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_200","uid":"20241015123045000001"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Headers: map[string]string{"Authorization": "Bearer sesame", "Content-Type": "application/json"},
+				Body:    `{"message_type":"FT","sender_key":"3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c","cid":"0191e180-7d60-7000-aded-7d8b151cbd5b","phone_number":"821012345678","message":"안녕하세요","button":[{"name":"Yes","type":"BK"},{"name":"No","type":"BK"}]}`,
+			},
+		},
+		ExpectedExtIDs: []string{"20241015123045000001"},
+	},
+	{
+		Label:          "FriendTalk Send With Attachments",
+		MsgUUID:        "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:        "Look",
+		MsgURN:         "tel:+821012345678",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg", "application/pdf:https://foo.bar/doc.pdf"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_200","uid":"20241015123045000002"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"message_type":"FI","sender_key":"3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c","cid":"0191e180-7d60-7000-aded-7d8b151cbd5b","phone_number":"821012345678","message":"Look\nhttps://foo.bar/doc.pdf","image_url":"https://foo.bar/image.jpg"}`,
+			},
+		},
+		ExpectedExtIDs: []string{"20241015123045000002"},
+	},
+	{
+		Label:         "AlimTalk Send",
+		MsgUUID:       "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:       "Your order 1234 has shipped",
+		MsgURN:        "tel:+821012345678",
+		MsgTemplating: `{"template": {"uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3", "name": "order_shipped"}, "external_id": "ORDER_SHIPPED_01"}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_200","uid":"20241015123045000003"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"message_type":"AT","sender_key":"3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c","cid":"0191e180-7d60-7000-aded-7d8b151cbd5b","phone_number":"821012345678","template_code":"ORDER_SHIPPED_01","message":"Your order 1234 has shipped"}`,
+			},
+		},
+		ExpectedExtIDs: []string{"20241015123045000003"},
+	},
+	{
+		Label:         "AlimTalk Without Template Code",
+		MsgText:       "Your order 1234 has shipped",
+		MsgURN:        "tel:+821012345678",
+		MsgTemplating: `{"template": {"uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3", "name": "order_shipped"}}`,
+		ExpectedError: courier.ErrMessageInvalid,
+	},
+	{
+		Label:   "Error Response Code",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_305","message":"Invalid sender key"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrFailedWithReason("API_305", "Invalid sender key"),
+	},
+	{
+		Label:   "Not A KakaoTalk User",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"K105","message":"Not a KakaoTalk user"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrContactUnknown,
+	},
+	{
+		Label:   "Channel Blocked By User",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(200, nil, []byte(`{"code":"K103","message":"Blocked by user"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrContactStopped,
+	},
+	{
+		Label:   "Error Status",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(401, nil, []byte(`{"code":"API_401","message":"Unauthorized"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrResponseStatus,
+	},
+	{
+		Label:   "Connection Error",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://bizmsg-web.kakaoenterprise.com/v2/send/kakao": {httpx.NewMockResponse(500, nil, []byte(`Server Error`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+}
+
+var fallbackSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "FriendTalk Send With SMS Fallback",
+		MsgUUID: "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText: "Hello",
+		MsgURN:  "tel:+821012345678",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://kakao.example.com/send": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_200","uid":"20241015123045000004"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"message_type":"FT","sender_key":"3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c","cid":"0191e180-7d60-7000-aded-7d8b151cbd5b","phone_number":"821012345678","message":"Hello","fallback":{"type":"SMS","from":"0212345678","message":"Hello"}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"20241015123045000004"},
+	},
+	{
+		Label:         "AlimTalk Send With LMS Fallback",
+		MsgUUID:       "0191e180-7d60-7000-aded-7d8b151cbd5b",
+		MsgText:       "고객님의 주문이 발송되었습니다. 배송 조회는 아래 버튼을 눌러 확인해 주세요. 항상 저희 쇼핑몰을 이용해 주셔서 감사합니다.",
+		MsgURN:        "tel:+821012345678",
+		MsgTemplating: `{"template": {"uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3", "name": "order_shipped"}, "external_id": "ORDER_SHIPPED_02"}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://kakao.example.com/send": {httpx.NewMockResponse(200, nil, []byte(`{"code":"API_200","uid":"20241015123045000005"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"message_type":"AT","sender_key":"3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c","cid":"0191e180-7d60-7000-aded-7d8b151cbd5b","phone_number":"821012345678","template_code":"ORDER_SHIPPED_02","message":"고객님의 주문이 발송되었습니다. 배송 조회는 아래 버튼을 눌러 확인해 주세요. 항상 저희 쇼핑몰을 이용해 주셔서 감사합니다.","fallback":{"type":"LMS","from":"0212345678","message":"고객님의 주문이 발송되었습니다. 배송 조회는 아래 버튼을 눌러 확인해 주세요. 항상 저희 쇼핑몰을 이용해 주셔서 감사합니다."}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"20241015123045000005"},
+	},
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, []string{"sesame"}, nil)
+
+	fallbackChannel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KKO", "0212345678", "KR", []string{"tel"}, map[string]any{
+		courier.ConfigAPIKey:  "sesame",
+		courier.ConfigBaseURL: "https://kakao.example.com/send",
+		configSenderKey:       "3f9c1a2b4d5e6f708192a3b4c5d6e7f8091a2b3c",
+		configFallbackSMS:     true,
+	})
+	RunOutgoingTestCases(t, fallbackChannel, newHandler(), fallbackSendTestCases, []string{"sesame"}, nil)
+}
+
+func TestEUCKRLength(t *testing.T) {
+	assert.Equal(t, 5, eucKRLength("Hello"))
+	assert.Equal(t, 10, eucKRLength("안녕하세요"))
+	assert.Equal(t, 8, eucKRLength("Hi 안녕!"))
+}
+
+func TestButtonsForQuickReplies(t *testing.T) {
+	assert.Len(t, buttonsForQuickReplies(nil), 0)
+	assert.Equal(t, []*mtButton{{Name: "A very long qu", Type: "BK"}}, buttonsForQuickReplies([]string{"A very long quick reply"}))
+	assert.Len(t, buttonsForQuickReplies([]string{"1", "2", "3", "4", "5", "6"}), 5)
+}
@@ -11,7 +11,6 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -92,7 +91,7 @@ func (h *handler) receiveMsg(ctx context.Context, channel courier.Channel, w htt
 	}
 
 	// build msg
-	date := time.Unix(ts, 0).UTC()
+	date := handlers.ParseUnixTimestamp(ts, handlers.TimestampSeconds)
 	msg := h.Backend().NewIncomingMsg(channel, urn, form.Body, "", clog).WithReceivedOn(date).WithContactName(form.Name)
 
 	if form.MediaURL != "" {
@@ -206,8 +205,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		form := url.Values{}
 		baseForm := h.newSendForm(msg.Channel(), "text", msg.URN().Path())
 		baseForm["body"] = msg.Text()
-		// checks if the message has a valid url to activate the preview
-		if handlers.IsURL(msg.Text()) {
+		// checks if the message has a valid url to activate the preview, unless message says otherwise
+		if preview := msg.URLPreview(); (preview == nil && handlers.IsURL(msg.Text())) || (preview != nil && *preview) {
 			baseForm["preview_url"] = "true"
 		}
 		for k, v := range baseForm {
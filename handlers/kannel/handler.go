@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
@@ -20,6 +19,9 @@ const (
 	configDLRMask    = "dlr_mask"
 	configIgnoreSent = "ignore_sent"
 
+	// comma separated error codes which the channel's SMSC uses to reject messages for insufficient credit
+	configBalanceErrors = "balance_errors"
+
 	encodingDefault = "D"
 	encodingUnicode = "U"
 	encodingSmart   = "S"
@@ -66,7 +68,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our date from the timestamp
-	date := time.Unix(form.TS, 0).UTC()
+	date := handlers.ParseUnixTimestamp(form.TS, handlers.TimestampSeconds)
 
 	// create our URN
 	urn, err := urns.ParsePhone(form.Sender, channel.Country(), true, false)
@@ -92,8 +94,12 @@ var statusMapping = map[int]courier.MsgStatus{
 type statusForm struct {
 	ID     courier.MsgID `validate:"required" name:"id"`
 	Status int           `validate:"required" name:"status"`
+	Reply  string        `name:"reply"`
 }
 
+// status of a DLR for a message which the SMSC rejected
+const statusSMSCRejected = 16
+
 // receiveStatus is our HTTP handler function for status updates
 func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
 	// get our params
@@ -113,11 +119,49 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring sent report (message aready wired)")
 	}
 
+	// if the SMSC rejected the message for lack of credit, hold it with the rest of the channel's messages until credit
+	// is restored rather than counting it as a failed attempt
+	if form.Status == statusSMSCRejected {
+		if code := balanceErrorCode(channel, form.Reply); code != "" {
+			clog.Error(courier.ErrorExternal(code, "SMSC account has insufficient balance."))
+
+			if pauser, ok := courier.BackendAs[courier.QueuePauser](h.Backend()); ok {
+				if err := pauser.PauseQueue(ctx, channel.UUID(), true); err != nil {
+					return nil, err
+				}
+			}
+
+			if requeuer, ok := courier.BackendAs[courier.SentMsgRequeuer](h.Backend()); ok {
+				if err := requeuer.RequeueSentMsg(ctx, channel, form.ID); err != nil {
+					return nil, err
+				}
+				msgStatus = courier.MsgStatusQueued
+			}
+		}
+	}
+
 	// write our status
 	status := h.Backend().NewStatusUpdate(channel, form.ID, msgStatus, clog)
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
+// returns the code of the given SMSC reply, e.g. NACK/0x00000401/Vendor-specific error, if it's one of the channel's
+// balance errors, otherwise empty
+func balanceErrorCode(channel courier.Channel, reply string) string {
+	parts := strings.Split(reply, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	code := strings.TrimSpace(parts[1])
+	for _, c := range strings.Split(channel.StringConfigForKey(configBalanceErrors, ""), ",") {
+		if c = strings.TrimSpace(c); c != "" && strings.EqualFold(c, code) {
+			return code
+		}
+	}
+	return ""
+}
+
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 
 	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
@@ -131,12 +175,17 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
 	dlrURL := fmt.Sprintf("https://%s/c/kn/%s/status?id=%s&status=%%d", callbackDomain, msg.Channel().UUID(), msg.ID().String())
 
+	// SMSCs only tell us they're out of credit in the reply of a rejected message
+	if msg.Channel().StringConfigForKey(configBalanceErrors, "") != "" {
+		dlrURL += "&reply=%A"
+	}
+
 	// build our request
 	form := url.Values{
 		"username": []string{username},
 		"password": []string{password},
 		"from":     []string{msg.Channel().Address()},
-		"text":     []string{handlers.GetTextAndAttachments(msg)},
+		"text":     []string{h.TextAndAttachments(msg)},
 		"to":       []string{msg.URN().Path()},
 		"dlr-url":  []string{dlrURL},
 		"dlr-mask": []string{dlrMask},
@@ -159,7 +208,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 	// if we are smart, first try to convert to GSM7 chars
 	if encoding == encodingSmart {
-		replaced := gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))
+		replaced := gsm7.ReplaceSubstitutions(h.TextAndAttachments(msg))
 		if gsm7.IsValid(replaced) {
 			form["text"] = []string{replaced}
 		} else {
@@ -203,5 +252,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
+	handlers.AddSMSSegments(res, form.Get("text"))
+
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -16,6 +17,10 @@ var testChannels = []courier.Channel{
 	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US", []string{urns.Phone.Prefix}, nil),
 }
 
+var balanceChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"balance_errors": "0x00000401, 0x00000045"}),
+}
+
 var ignoreChannels = []courier.Channel{
 	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"ignore_sent": true}),
 }
@@ -88,6 +93,25 @@ var handleTestCases = []IncomingTestCase{
 	},
 }
 
+var balanceTestCases = []IncomingTestCase{
+	{
+		Label:                "Status Failed Insufficient Balance",
+		URL:                  "/c/kn/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/?id=12345&status=16&reply=NACK%2F0x00000401%2FVendor-specific%20error",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"Q"`,
+		ExpectedStatuses:     []ExpectedStatus{{MsgID: 12345, Status: courier.MsgStatusQueued}},
+		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("0x00000401", "SMSC account has insufficient balance.")},
+		NoQueueErrorCheck:    true,
+	},
+	{
+		Label:                "Status Failed Other Error",
+		URL:                  "/c/kn/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/?id=12345&status=16&reply=NACK%2F0x0000000B%2FInvalid%20destination",
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"E"`,
+		ExpectedStatuses:     []ExpectedStatus{{MsgID: 12345, Status: courier.MsgStatusErrored}},
+	},
+}
+
 var ignoreTestCases = []IncomingTestCase{
 	{
 		Label:                "Receive Valid Message",
@@ -124,6 +148,7 @@ var ignoreTestCases = []IncomingTestCase{
 func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newHandler(), handleTestCases)
 	RunIncomingTestCases(t, ignoreChannels, newHandler(), ignoreTestCases)
+	RunIncomingTestCases(t, balanceChannels, newHandler(), balanceTestCases)
 }
 
 func BenchmarkHandler(b *testing.B) {
@@ -198,6 +223,8 @@ var defaultSendTestCases = []OutgoingTestCase{
 				"password": {"Password"},
 			},
 		}},
+
+		ExpectedSegments: 1,
 	},
 	{
 		Label:           "Not Routable",
@@ -325,6 +352,30 @@ var nationalSendTestCases = []OutgoingTestCase{
 	},
 }
 
+var balanceSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "Plain Send",
+		MsgText: "Simple Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"http://example.com/send*": {
+				httpx.NewMockResponse(200, nil, []byte(`0: Accepted for delivery`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Params: url.Values{
+				"text":     {"Simple Message"},
+				"to":       {"+250788383383"},
+				"from":     {"2020"},
+				"dlr-mask": {"27"},
+				"dlr-url":  {"https://localhost/c/kn/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&status=%d&reply=%A"},
+				"username": {"Username"},
+				"password": {"Password"},
+			},
+		}},
+	},
+}
+
 func TestOutgoing(t *testing.T) {
 	var defaultChannel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US",
 		[]string{urns.Phone.Prefix},
@@ -355,5 +406,15 @@ func TestOutgoing(t *testing.T) {
 
 	RunOutgoingTestCases(t, defaultChannel, newHandler(), defaultSendTestCases, []string{"Password"}, nil)
 	RunOutgoingTestCases(t, customParamsChannel, newHandler(), customParamsTestCases, []string{"Password"}, nil)
+	var balanceChannel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US",
+		[]string{urns.Phone.Prefix},
+		map[string]any{
+			"password":            "Password",
+			"username":            "Username",
+			"balance_errors":      "0x00000401",
+			courier.ConfigSendURL: "http://example.com/send",
+		})
+
 	RunOutgoingTestCases(t, nationalChannel, newHandler(), nationalSendTestCases, []string{"Password"}, nil)
+	RunOutgoingTestCases(t, balanceChannel, newHandler(), balanceSendTestCases, []string{"Password"}, nil)
 }
@@ -0,0 +1,74 @@
+package line
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/gocommon/stringsx"
+)
+
+const (
+	// maxFlexAltTextLength is the maximum length of the alt text shown in notifications and chat lists
+	maxFlexAltTextLength = 1500
+	// maxFlexBubbles is the maximum number of bubbles in a carousel
+	maxFlexBubbles = 12
+	// maxFlexBubbleSize and maxFlexCarouselSize are the maximum sizes of flex contents in bytes
+	maxFlexBubbleSize   = 30 * 1024
+	maxFlexCarouselSize = 50 * 1024
+)
+
+// a flex message is requested by the message metadata, with contents being a bubble or carousel container as built
+// in the Flex Message Simulator, e.g.
+//
+//	"flex": {
+//	  "alt_text": "Our menu",
+//	  "contents": {"type": "bubble", "body": {"type": "box", "layout": "vertical", "contents": [{"type": "text", "text": "Pizza"}]}}
+//	}
+type flexMetadata struct {
+	AltText  string          `json:"alt_text"`
+	Contents json.RawMessage `json:"contents"`
+}
+
+// builds a flex message from the given message metadata, returning nil if it doesn't have one. Alt text is required by
+// LINE so if the metadata doesn't provide it, we fall back to the message text.
+func getFlexMsg(metadata json.RawMessage, text string) (*mtFlexMsg, error) {
+	raw, _, _, err := jsonparser.Get(metadata, "flex")
+	if err != nil {
+		return nil, nil
+	}
+
+	m := &flexMetadata{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("unable to parse flex message: %w", err)
+	}
+
+	altText := m.AltText
+	if altText == "" {
+		altText = text
+	}
+	if altText == "" {
+		return nil, fmt.Errorf("flex message must have alt text")
+	}
+
+	containerType, _ := jsonparser.GetString(m.Contents, "type")
+	switch containerType {
+	case "bubble":
+		if len(m.Contents) > maxFlexBubbleSize {
+			return nil, fmt.Errorf("flex bubble must be at most %d bytes", maxFlexBubbleSize)
+		}
+	case "carousel":
+		bubbles := 0
+		jsonparser.ArrayEach(m.Contents, func(value []byte, dataType jsonparser.ValueType, offset int, err error) { bubbles++ }, "contents")
+		if bubbles < 1 || bubbles > maxFlexBubbles {
+			return nil, fmt.Errorf("flex carousel must have between 1 and %d bubbles", maxFlexBubbles)
+		}
+		if len(m.Contents) > maxFlexCarouselSize {
+			return nil, fmt.Errorf("flex carousel must be at most %d bytes", maxFlexCarouselSize)
+		}
+	default:
+		return nil, fmt.Errorf("flex contents must be a bubble or carousel container")
+	}
+
+	return &mtFlexMsg{Type: "flex", AltText: stringsx.Truncate(altText, maxFlexAltTextLength), Contents: m.Contents}, nil
+}
@@ -12,12 +12,13 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
-	"time"
+	"strings"
 
 	"errors"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/stringsx"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -28,6 +29,10 @@ var (
 	maxMsgLength = 2000
 	maxMsgSend   = 5
 
+	// see https://developers.line.biz/en/reference/messaging-api/#items-object
+	maxQuickReplies   = 13
+	maxQuickReplySize = 20
+
 	signatureHeader = "X-Line-Signature"
 )
 
@@ -82,6 +87,18 @@ func (h *handler) Initialize(s courier.Server) error {
 //			  "type": "user",
 //			  "userId": "U4af4980629..."
 //			}
+//		  },
+//		  {
+//			"replyToken": "b60d432864f44d079f6d8efe86cf404b",
+//			"type": "postback",
+//			"timestamp": 1462629479859,
+//			"source": {
+//			  "type": "user",
+//			  "userId": "U4af4980629..."
+//			},
+//			"postback": {
+//			  "data": "Yes"
+//			}
 //		  }
 //		]
 //	}
@@ -107,6 +124,9 @@ type moPayload struct {
 				OriginalContentURL string `json:"originalContentUrl"`
 			} `json:"contentProvider"`
 		} `json:"message"`
+		Postback struct {
+			Data string `json:"data"`
+		} `json:"postback"`
 	} `json:"events"`
 }
 
@@ -120,7 +140,10 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	msgs := []courier.MsgIn{}
 
 	for _, lineEvent := range payload.Events {
-		if lineEvent.ReplyToken == "" || (lineEvent.Source.Type == "" && lineEvent.Source.UserID == "") || (lineEvent.Message.Type == "" && lineEvent.Message.ID == "") {
+		if lineEvent.ReplyToken == "" || (lineEvent.Source.Type == "" && lineEvent.Source.UserID == "") {
+			continue
+		}
+		if lineEvent.Type != "postback" && lineEvent.Message.Type == "" && lineEvent.Message.ID == "" {
 			continue
 		}
 
@@ -129,7 +152,11 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 		lineEventMsgType := lineEvent.Message.Type
 
-		if lineEventMsgType == "text" {
+		if lineEvent.Type == "postback" && lineEvent.Postback.Data != "" {
+			// postbacks come from our quick replies which use the reply text as their data
+			text = lineEvent.Postback.Data
+
+		} else if lineEventMsgType == "text" {
 			text = lineEvent.Message.Text
 
 		} else if lineEventMsgType == "audio" || lineEventMsgType == "video" || lineEventMsgType == "image" || lineEventMsgType == "file" {
@@ -146,8 +173,8 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 			continue
 		}
 
-		// create our date from the timestamp (they give us millis, arg is nanos)
-		date := time.Unix(0, lineEvent.Timestamp*1000000).UTC()
+		// create our date from the timestamp
+		date := handlers.ParseUnixTimestamp(lineEvent.Timestamp, handlers.TimestampMillis)
 
 		urn, err := urns.New(urns.Line, lineEvent.Source.UserID)
 		if err != nil {
@@ -249,12 +276,37 @@ type mtQuickReply struct {
 type QuickReplyItem struct {
 	Type   string `json:"type"`
 	Action struct {
-		Type  string `json:"type"`
-		Label string `json:"label"`
-		Text  string `json:"text"`
+		Type        string `json:"type"`
+		Label       string `json:"label"`
+		Data        string `json:"data"`
+		DisplayText string `json:"displayText"`
 	} `json:"action"`
 }
 
+// quick replies are sent as postback actions which display the reply in the chat and send it back to us as data
+func newQuickReply(qrs []string) *mtQuickReply {
+	if len(qrs) == 0 {
+		return nil
+	}
+
+	items := make([]QuickReplyItem, min(len(qrs), maxQuickReplies))
+	for i := range items {
+		items[i].Type = "action"
+		items[i].Action.Type = "postback"
+		items[i].Action.Label = stringsx.Truncate(qrs[i], maxQuickReplySize)
+		items[i].Action.Data = qrs[i]
+		items[i].Action.DisplayText = qrs[i]
+	}
+	return &mtQuickReply{Items: items}
+}
+
+type mtFlexMsg struct {
+	Type       string          `json:"type"`
+	AltText    string          `json:"altText"`
+	Contents   json.RawMessage `json:"contents"`
+	QuickReply *mtQuickReply   `json:"quickReply,omitempty"`
+}
+
 type mtImageMsg struct {
 	Type       string `json:"type"`
 	URL        string `json:"originalContentUrl"`
@@ -294,6 +346,12 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	parts := handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLength)
 	qrs := msg.QuickReplies()
 
+	flex, err := getFlexMsg(msg.Metadata(), msg.Text())
+	if err != nil {
+		clog.RawError(err)
+		return courier.ErrMessageInvalid
+	}
+
 	attachments, err := handlers.ResolveAttachments(ctx, h.Backend(), msg.Attachments(), mediaSupport, false, clog)
 	if err != nil {
 		return fmt.Errorf("error resolving attachments: %w", err)
@@ -323,27 +381,25 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		}
 	}
 
-	// fill all msg parts with text parts
+	// fill all msg parts with text parts, with quick replies on the last part unless there's a flex message to follow
 	for i, part := range parts {
-		if i < (len(parts) - 1) {
-			if jsonMsg, err := json.Marshal(mtTextMsg{Type: "text", Text: part}); err == nil {
-				jsonMsgs = append(jsonMsgs, string(jsonMsg))
-			}
-		} else {
-			mtTextMsg := mtTextMsg{Type: "text", Text: part}
-			items := make([]QuickReplyItem, len(qrs))
-			for j, qr := range qrs {
-				items[j] = QuickReplyItem{Type: "action"}
-				items[j].Action.Type = "message"
-				items[j].Action.Label = qr
-				items[j].Action.Text = qr
-			}
-			if len(items) > 0 {
-				mtTextMsg.QuickReply = &mtQuickReply{Items: items}
-			}
-			if jsonMsg, err := json.Marshal(mtTextMsg); err == nil {
-				jsonMsgs = append(jsonMsgs, string(jsonMsg))
-			}
+		if part == "" && flex != nil {
+			continue
+		}
+
+		mtTextMsg := mtTextMsg{Type: "text", Text: part}
+		if i == len(parts)-1 && flex == nil {
+			mtTextMsg.QuickReply = newQuickReply(qrs)
+		}
+		if jsonMsg, err := json.Marshal(mtTextMsg); err == nil {
+			jsonMsgs = append(jsonMsgs, string(jsonMsg))
+		}
+	}
+
+	if flex != nil {
+		flex.QuickReply = newQuickReply(qrs)
+		if jsonMsg, err := json.Marshal(flex); err == nil {
+			jsonMsgs = append(jsonMsgs, string(jsonMsg))
 		}
 	}
 
@@ -391,10 +447,10 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 				}
 
 				if resp.StatusCode/100 != 2 {
-					return courier.ErrFailedWithReason(strconv.Itoa(resp.StatusCode), respPayload.Message)
+					return sendError(resp.StatusCode, respPayload.Message)
 				}
 			} else {
-				return courier.ErrFailedWithReason(strconv.Itoa(resp.StatusCode), respPayload.Message)
+				return sendError(resp.StatusCode, respPayload.Message)
 			}
 		}
 	}
@@ -402,6 +458,14 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	return nil
 }
 
+// LINE doesn't tell us when a user has blocked us, but does reject sends to user IDs which don't exist
+func sendError(statusCode int, message string) error {
+	if statusCode == http.StatusBadRequest && strings.HasPrefix(message, "The property, 'to', in the request body is invalid") {
+		return courier.ErrContactUnknown
+	}
+	return courier.ErrFailedWithReason(strconv.Itoa(statusCode), message)
+}
+
 func buildSendMsgRequest(authToken, to string, replyToken string, jsonMsgs []string) (*http.Request, error) {
 	// convert from string slice to bytes JSON
 	rawJsonMsgs := bytes.Buffer{}
@@ -2,6 +2,7 @@ package line
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
@@ -245,6 +247,22 @@ var missingMessage = `{
 	}]
 }`
 
+var receivePostback = `
+{
+	"events": [{
+		"replyToken": "abcdefghij",
+		"type": "postback",
+		"timestamp": 1459991487970,
+		"source": {
+			"type": "user",
+			"userId": "uabcdefghij"
+		},
+		"postback": {
+			"data": "Yes"
+		}
+	}]
+}`
+
 var noEvent = `{
 	"events": []
 }`
@@ -341,6 +359,18 @@ var handleTestCases = []IncomingTestCase{
 		ExpectedDate:         time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC),
 		PrepRequest:          addValidSignature,
 	},
+	{
+		Label:                "Receive Quick Reply Postback",
+		URL:                  receiveURL,
+		Data:                 receivePostback,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("Yes"),
+		ExpectedURN:          "line:uabcdefghij",
+		ExpectedExternalID:   "abcdefghij",
+		ExpectedDate:         time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC),
+		PrepRequest:          addValidSignature,
+	},
 	{
 		Label:                "Missing message",
 		URL:                  receiveURL,
@@ -496,7 +526,7 @@ var defaultSendTestCases = []OutgoingTestCase{
 		},
 		ExpectedRequests: []ExpectedRequest{
 			{
-				Body: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Are you happy?","quickReply":{"items":[{"type":"action","action":{"type":"message","label":"Yes","text":"Yes"}},{"type":"action","action":{"type":"message","label":"No","text":"No"}}]}}]}`,
+				Body: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Are you happy?","quickReply":{"items":[{"type":"action","action":{"type":"postback","label":"Yes","data":"Yes","displayText":"Yes"}},{"type":"action","action":{"type":"postback","label":"No","data":"No","displayText":"No"}}]}}]}`,
 			},
 		},
 	},
@@ -511,7 +541,7 @@ var defaultSendTestCases = []OutgoingTestCase{
 		},
 		ExpectedRequests: []ExpectedRequest{
 			{
-				Body: `{"to":"uabcdefghij","messages":[{"type":"image","originalContentUrl":"http://mock.com/1234/test.jpg","previewImageUrl":"http://mock.com/1234/test.jpg"},{"type":"text","text":"Are you happy?","quickReply":{"items":[{"type":"action","action":{"type":"message","label":"Yes","text":"Yes"}},{"type":"action","action":{"type":"message","label":"No","text":"No"}}]}}]}`,
+				Body: `{"to":"uabcdefghij","messages":[{"type":"image","originalContentUrl":"http://mock.com/1234/test.jpg","previewImageUrl":"http://mock.com/1234/test.jpg"},{"type":"text","text":"Are you happy?","quickReply":{"items":[{"type":"action","action":{"type":"postback","label":"Yes","data":"Yes","displayText":"Yes"}},{"type":"action","action":{"type":"postback","label":"No","data":"No","displayText":"No"}}]}}]}`,
 			},
 		},
 	},
@@ -527,10 +557,77 @@ var defaultSendTestCases = []OutgoingTestCase{
 		},
 		ExpectedRequests: []ExpectedRequest{
 			{
-				Body: `{"replyToken":"nHuyWiB7yP5Zw52FIkcQobQuGDXCTA","messages":[{"type":"image","originalContentUrl":"http://mock.com/1234/test.jpg","previewImageUrl":"http://mock.com/1234/test.jpg"},{"type":"text","text":"This is a longer message than 160 characters and will cause us to split it into two separate parts, isn't that right but it is even longer than before I say,"},{"type":"text","text":"I need to keep adding more things to make it work","quickReply":{"items":[{"type":"action","action":{"type":"message","label":"Yes","text":"Yes"}},{"type":"action","action":{"type":"message","label":"No","text":"No"}}]}}]}`,
+				Body: `{"replyToken":"nHuyWiB7yP5Zw52FIkcQobQuGDXCTA","messages":[{"type":"image","originalContentUrl":"http://mock.com/1234/test.jpg","previewImageUrl":"http://mock.com/1234/test.jpg"},{"type":"text","text":"This is a longer message than 160 characters and will cause us to split it into two separate parts, isn't that right but it is even longer than before I say,"},{"type":"text","text":"I need to keep adding more things to make it work","quickReply":{"items":[{"type":"action","action":{"type":"postback","label":"Yes","data":"Yes","displayText":"Yes"}},{"type":"action","action":{"type":"postback","label":"No","data":"No","displayText":"No"}}]}}]}`,
+			},
+		},
+	},
+	{
+		Label:           "Quick Reply long label",
+		MsgText:         "Are you happy?",
+		MsgURN:          "line:uabcdefghij",
+		MsgQuickReplies: []string{"Yes, I am very very happy"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.line.me/v2/bot/message/push": {httpx.NewMockResponse(200, nil, []byte(`{}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Are you happy?","quickReply":{"items":[{"type":"action","action":{"type":"postback","label":"Yes, I am very very ","data":"Yes, I am very very happy","displayText":"Yes, I am very very happy"}}]}}]}`,
+			},
+		},
+	},
+	{
+		Label:       "Send Flex Bubble",
+		MsgText:     "Our menu",
+		MsgURN:      "line:uabcdefghij",
+		MsgMetadata: `{"flex": {"contents": {"type": "bubble", "body": {"type": "box", "layout": "vertical", "contents": [{"type": "text", "text": "Pizza"}]}}}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.line.me/v2/bot/message/push": {httpx.NewMockResponse(200, nil, []byte(`{}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Our menu"},{"type":"flex","altText":"Our menu","contents":{"type":"bubble","body":{"type":"box","layout":"vertical","contents":[{"type":"text","text":"Pizza"}]}}}]}`,
 			},
 		},
 	},
+	{
+		Label:           "Send Flex Carousel with Quick Replies",
+		MsgText:         "",
+		MsgURN:          "line:uabcdefghij",
+		MsgQuickReplies: []string{"Pizza", "Pasta"},
+		MsgMetadata:     `{"flex": {"alt_text": "Our menu", "contents": {"type": "carousel", "contents": [{"type": "bubble"}, {"type": "bubble"}]}}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.line.me/v2/bot/message/push": {httpx.NewMockResponse(200, nil, []byte(`{}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"to":"uabcdefghij","messages":[{"type":"flex","altText":"Our menu","contents":{"type":"carousel","contents":[{"type":"bubble"},{"type":"bubble"}]},"quickReply":{"items":[{"type":"action","action":{"type":"postback","label":"Pizza","data":"Pizza","displayText":"Pizza"}},{"type":"action","action":{"type":"postback","label":"Pasta","data":"Pasta","displayText":"Pasta"}}]}}]}`,
+			},
+		},
+	},
+	{
+		Label:             "Send Flex Without Alt Text",
+		MsgText:           "",
+		MsgURN:            "line:uabcdefghij",
+		MsgMetadata:       `{"flex": {"contents": {"type": "bubble"}}}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "flex message must have alt text")},
+	},
+	{
+		Label:             "Send Flex Invalid Container",
+		MsgText:           "Our menu",
+		MsgURN:            "line:uabcdefghij",
+		MsgMetadata:       `{"flex": {"contents": {"type": "box"}}}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "flex contents must be a bubble or carousel container")},
+	},
+	{
+		Label:             "Send Flex Empty Carousel",
+		MsgText:           "Our menu",
+		MsgURN:            "line:uabcdefghij",
+		MsgMetadata:       `{"flex": {"contents": {"type": "carousel", "contents": []}}}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "flex carousel must have between 1 and 12 bubbles")},
+	},
 	{
 		Label:                   "Send Push Message If Invalid Reply",
 		MsgText:                 "Simple Message",
@@ -573,6 +670,20 @@ var defaultSendTestCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrFailedWithReason("403", "Failed to send messages"),
 	},
+	{
+		Label:   "Invalid User ID",
+		MsgText: "Error Sending",
+		MsgURN:  "line:uabcdefghij",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://api.line.me/v2/bot/message/push": {httpx.NewMockResponse(400, nil, []byte(`{"message": "The property, 'to', in the request body is invalid (line: \"-\", column: \"-\")"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Error Sending"}]}`,
+			},
+		},
+		ExpectedError: courier.ErrContactUnknown,
+	},
 }
 
 // setupMedia takes care of having the media files needed to our test server host
@@ -611,3 +722,96 @@ func TestBuildAttachmentRequest(t *testing.T) {
 	assert.Equal(t, "https://example.org/v1/media/41", req.URL.String())
 	assert.Equal(t, "Bearer the-auth-token", req.Header.Get("Authorization"))
 }
+
+func TestConfigureProfile(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	ctx := context.Background()
+	handler := newHandler()
+	handler.Initialize(courier.NewServer(courier.NewDefaultConfig(), test.NewMockBackend()))
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://acme.com/menu.png": {
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/png"}, []byte(`PNG`)),
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/png"}, []byte(`PNG`)),
+			httpx.NewMockResponse(200, map[string]string{"Content-Type": "image/gif"}, []byte(`GIF`)),
+		},
+		"https://api.line.me/v2/bot/user/all/richmenu": {
+			httpx.NewMockResponse(200, nil, []byte(`{"richMenuId": "richmenu-old"}`)),
+			httpx.NewMockResponse(404, nil, []byte(`{"message": "no default richmenu"}`)),
+		},
+		"https://api.line.me/v2/bot/richmenu": {
+			httpx.NewMockResponse(200, nil, []byte(`{"richMenuId": "richmenu-new"}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"richMenuId": "richmenu-new2"}`)),
+		},
+		"https://api-data.line.me/v2/bot/richmenu/richmenu-new/content": {
+			httpx.NewMockResponse(200, nil, []byte(`{}`)),
+		},
+		"https://api-data.line.me/v2/bot/richmenu/richmenu-new2/content": {
+			httpx.NewMockResponse(400, nil, []byte(`{"message": "An image has already been uploaded"}`)),
+		},
+		"https://api.line.me/v2/bot/user/all/richmenu/richmenu-new": {
+			httpx.NewMockResponse(200, nil, []byte(`{}`)),
+		},
+		"https://api.line.me/v2/bot/richmenu/richmenu-old": {
+			httpx.NewMockResponse(200, nil, []byte(`{}`)),
+		},
+		"https://api.line.me/v2/bot/richmenu/richmenu-new2": {
+			httpx.NewMockResponse(200, nil, []byte(`{}`)),
+		},
+	})
+	httpx.SetRequestor(mockHTTP)
+
+	menu := map[string]any{
+		"size":        map[string]any{"width": 2500, "height": 843},
+		"selected":    true,
+		"name":        "Main",
+		"chatBarText": "Menu",
+		"areas": []any{
+			map[string]any{"bounds": map[string]any{"x": 0, "y": 0, "width": 1250, "height": 843}, "action": map[string]any{"type": "message", "text": "Help"}},
+		},
+	}
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "LN", "2020", "US", []string{urns.Line.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "the-auth-token",
+		configRichMenu:          menu,
+		configRichMenuImage:     "https://acme.com/menu.png",
+	})
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+	err := handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.NoError(t, err)
+	assert.Len(t, clog.HttpLogs, 6)
+	assert.Len(t, clog.Errors, 0)
+	AssertChannelLogRedaction(t, clog, []string{"the-auth-token"})
+
+	reqs := mockHTTP.Requests()
+	body, _ := io.ReadAll(reqs[2].Body)
+	assert.JSONEq(t, `{
+		"size": {"width": 2500, "height": 843}, "selected": true, "name": "Main", "chatBarText": "Menu",
+		"areas": [{"bounds": {"x": 0, "y": 0, "width": 1250, "height": 843}, "action": {"type": "message", "text": "Help"}}]
+	}`, string(body))
+	assert.Equal(t, "image/png", reqs[3].Header.Get("Content-Type"))
+	assert.Equal(t, "Bearer the-auth-token", reqs[3].Header.Get("Authorization"))
+	assert.Equal(t, http.MethodDelete, reqs[5].Method)
+
+	// image upload fails so new menu is deleted
+	clog = courier.NewChannelLog(courier.ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.EqualError(t, err, "unable to upload rich menu image")
+	assert.Equal(t, []*clogs.LogError{courier.ErrorExternal("400", "An image has already been uploaded")}, clog.Errors)
+
+	// image of wrong type
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.EqualError(t, err, "rich menu image must be JPEG or PNG, got image/gif")
+
+	// invalid or missing config doesn't make any requests
+	menu["chatBarText"] = "This text is too long"
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.ErrorContains(t, err, "invalid rich_menu config")
+
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "LN", "2020", "US", []string{urns.Line.Prefix}, map[string]any{courier.ConfigAuthToken: "the-auth-token"})
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.EqualError(t, err, "channel has no rich menu config")
+
+	assert.False(t, mockHTTP.HasUnused())
+}
@@ -0,0 +1,190 @@
+package line
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// channel config keys which define the rich menu of a LINE channel, with the menu itself in LINE's own format, e.g.
+//
+//	"rich_menu": {
+//	  "size": {"width": 2500, "height": 843}, "selected": true, "name": "Main", "chatBarText": "Menu",
+//	  "areas": [
+//	    {"bounds": {"x": 0, "y": 0, "width": 1250, "height": 843}, "action": {"type": "message", "text": "Help"}},
+//	    {"bounds": {"x": 1250, "y": 0, "width": 1250, "height": 843}, "action": {"type": "uri", "uri": "https://acme.com"}}
+//	  ]
+//	},
+//	"rich_menu_image": "https://acme.com/menu.png"
+const (
+	configRichMenu      = "rich_menu"
+	configRichMenuImage = "rich_menu_image"
+)
+
+var (
+	richMenuURL        = "https://api.line.me/v2/bot/richmenu"
+	richMenuContentURL = "https://api-data.line.me/v2/bot/richmenu"
+	defaultRichMenuURL = "https://api.line.me/v2/bot/user/all/richmenu"
+
+	// see https://developers.line.biz/en/reference/messaging-api/#upload-rich-menu-image-requirements
+	maxRichMenuImageBytes = 1024 * 1024
+	richMenuImageTypes    = map[string]bool{"image/jpeg": true, "image/png": true}
+)
+
+// see https://developers.line.biz/en/reference/messaging-api/#rich-menu-object
+type richMenu struct {
+	Size struct {
+		Width  int `json:"width"  validate:"min=800,max=2500"`
+		Height int `json:"height" validate:"min=250"`
+	} `json:"size"`
+	Selected    bool   `json:"selected"`
+	Name        string `json:"name"        validate:"required,max=300"`
+	ChatBarText string `json:"chatBarText" validate:"required,max=14"`
+	Areas       []struct {
+		Bounds struct {
+			X      int `json:"x"`
+			Y      int `json:"y"`
+			Width  int `json:"width"  validate:"min=1"`
+			Height int `json:"height" validate:"min=1"`
+		} `json:"bounds"`
+		Action json.RawMessage `json:"action" validate:"required"`
+	} `json:"areas" validate:"min=1,max=20,dive"`
+}
+
+// ConfigureProfile creates a rich menu from the channel's config, uploads its image and makes it the default menu for
+// all users. Any previous default menu is deleted so that menus don't accumulate each time they're changed.
+func (h *handler) ConfigureProfile(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) error {
+	authToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if authToken == "" {
+		return errors.New("missing auth token")
+	}
+
+	menu, imageURL, err := richMenuFromConfig(channel)
+	if err != nil {
+		return err
+	}
+
+	// fetch the image first so that we don't create a menu we can't complete
+	image, contentType, err := h.fetchRichMenuImage(imageURL, clog)
+	if err != nil {
+		return err
+	}
+
+	// not having a default menu is a 404 so we don't treat errors here as errors
+	_, respBody, _ := h.RequestHTTP(newRichMenuRequest(http.MethodGet, defaultRichMenuURL, authToken, "", nil), clog)
+	previousID, _ := jsonparser.GetString(respBody, "richMenuId")
+
+	_, respBody, err = h.richMenuRequest(http.MethodPost, richMenuURL, authToken, "application/json", jsonx.MustMarshal(menu), clog)
+	if err != nil {
+		return errors.New("unable to create rich menu")
+	}
+	menuID, err := jsonparser.GetString(respBody, "richMenuId")
+	if err != nil {
+		clog.Error(courier.ErrorResponseValueMissing("richMenuId"))
+		return errors.New("unable to create rich menu")
+	}
+
+	if _, _, err := h.richMenuRequest(http.MethodPost, fmt.Sprintf("%s/%s/content", richMenuContentURL, menuID), authToken, contentType, image, clog); err != nil {
+		h.richMenuRequest(http.MethodDelete, fmt.Sprintf("%s/%s", richMenuURL, menuID), authToken, "", nil, clog)
+		return errors.New("unable to upload rich menu image")
+	}
+
+	if _, _, err := h.richMenuRequest(http.MethodPost, fmt.Sprintf("%s/%s", defaultRichMenuURL, menuID), authToken, "", nil, clog); err != nil {
+		h.richMenuRequest(http.MethodDelete, fmt.Sprintf("%s/%s", richMenuURL, menuID), authToken, "", nil, clog)
+		return errors.New("unable to set default rich menu")
+	}
+
+	if previousID != "" && previousID != menuID {
+		if _, _, err := h.richMenuRequest(http.MethodDelete, fmt.Sprintf("%s/%s", richMenuURL, previousID), authToken, "", nil, clog); err != nil {
+			return errors.New("unable to delete previous rich menu")
+		}
+	}
+
+	return nil
+}
+
+// reads the rich menu and its image URL from the channel's config
+func richMenuFromConfig(channel courier.Channel) (*richMenu, string, error) {
+	value := channel.ConfigForKey(configRichMenu, nil)
+	if value == nil {
+		return nil, "", errors.New("channel has no rich menu config")
+	}
+
+	menu := &richMenu{}
+	if err := json.Unmarshal(jsonx.MustMarshal(value), menu); err != nil {
+		return nil, "", fmt.Errorf("invalid %s config: %w", configRichMenu, err)
+	}
+	if err := utils.Validate(menu); err != nil {
+		return nil, "", fmt.Errorf("invalid %s config: %w", configRichMenu, err)
+	}
+	if float64(menu.Size.Width)/float64(menu.Size.Height) < 1.45 {
+		return nil, "", fmt.Errorf("invalid %s config: aspect ratio must be at least 1.45", configRichMenu)
+	}
+
+	imageURL := channel.StringConfigForKey(configRichMenuImage, "")
+	if imageURL == "" {
+		return nil, "", fmt.Errorf("channel has no %s config", configRichMenuImage)
+	}
+
+	return menu, imageURL, nil
+}
+
+// downloads the rich menu image, checking that it's a type and size that LINE will accept
+func (h *handler) fetchRichMenuImage(imageURL string, clog *courier.ChannelLog) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid %s config: %w", configRichMenuImage, err)
+	}
+
+	resp, body, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		clog.Error(courier.ErrorResponseStatusCode())
+		return nil, "", errors.New("unable to fetch rich menu image")
+	}
+
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if !richMenuImageTypes[contentType] {
+		return nil, "", fmt.Errorf("rich menu image must be JPEG or PNG, got %s", contentType)
+	}
+	if len(body) > maxRichMenuImageBytes {
+		return nil, "", fmt.Errorf("rich menu image must be at most %d bytes", maxRichMenuImageBytes)
+	}
+
+	return body, contentType, nil
+}
+
+func newRichMenuRequest(method, url, authToken, contentType string, body []byte) *http.Request {
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+// makes a request to the rich menu API, logging any error returned
+func (h *handler) richMenuRequest(method, url, authToken, contentType string, body []byte, clog *courier.ChannelLog) (*http.Response, []byte, error) {
+	resp, respBody, err := h.RequestHTTP(newRichMenuRequest(method, url, authToken, contentType, body), clog)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		message, _ := jsonparser.GetString(respBody, "message")
+		clog.Error(courier.ErrorExternal(strconv.Itoa(resp.StatusCode), message))
+		return resp, respBody, errors.New("unexpected response status")
+	}
+
+	return resp, respBody, nil
+}
+
+var _ courier.ProfileConfigurer = (*handler)(nil)
@@ -77,7 +77,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	}
 
 	// figure out if we need to send as unicode (encoding 7)
-	text := gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))
+	text := gsm7.ReplaceSubstitutions(h.TextAndAttachments(msg))
 	encoding := "0"
 	if !gsm7.IsValid(text) {
 		encoding = "7"
@@ -113,6 +113,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
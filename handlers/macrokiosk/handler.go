@@ -117,7 +117,10 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, err
 	}
 
-	date, err := time.ParseInLocation("2006-01-0215:04:05", form.Time, loc)
+	// timestamps are local to Malaysia, e.g. 2016-03-3019:20:09
+	timestampFormat := &handlers.TimestampFormat{Layouts: []string{"2006-01-0215:04:05"}, Location: loc}
+
+	date, err := timestampFormat.Parse(form.Time)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -129,7 +132,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create and write the message
-	msg := h.Backend().NewIncomingMsg(channel, urn, form.Text, form.MsgID, clog).WithReceivedOn(date.UTC())
+	msg := h.Backend().NewIncomingMsg(channel, urn, form.Text, form.MsgID, clog).WithReceivedOn(date)
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
 
@@ -160,7 +163,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	}
 
 	// figure out if we need to send as unicode (encoding 5)
-	text := gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))
+	text := gsm7.ReplaceSubstitutions(h.TextAndAttachments(msg))
 	encoding := "0"
 	if !gsm7.IsValid(text) {
 		encoding = "5"
@@ -201,6 +204,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			res.AddExternalID(externalID)
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 	return nil
 }
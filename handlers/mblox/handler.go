@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 
@@ -21,6 +20,9 @@ var (
 	maxMsgLength = 459
 )
 
+// timestamps are in UTC, e.g. 2016-12-01T21:47:59.000Z
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000Z"}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -85,7 +87,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("missing one of 'id', 'from', 'to', 'body' or 'received_at' in request body"))
 		}
 
-		date, err := time.Parse("2006-01-02T15:04:05.000Z", payload.ReceivedAt)
+		date, err := timestampFormat.Parse(payload.ReceivedAt)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 		}
@@ -97,7 +99,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		}
 
 		// build our Message
-		msg := h.Backend().NewIncomingMsg(channel, urn, payload.Body, payload.ID, clog).WithReceivedOn(date.UTC())
+		msg := h.Backend().NewIncomingMsg(channel, urn, payload.Body, payload.ID, clog).WithReceivedOn(date)
 
 		// and finally write our message
 		return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
@@ -120,7 +122,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	if username == "" || password == "" {
 		return courier.ErrChannelConfig
 	}
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 	for _, part := range parts {
 		payload := &mtPayload{}
 		payload.From = strings.TrimPrefix(msg.Channel().Address(), "+")
@@ -153,6 +155,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else {
 			res.AddExternalID(externalID)
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
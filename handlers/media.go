@@ -3,8 +3,10 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/nyaruka/courier"
@@ -20,8 +22,10 @@ const (
 )
 
 type MediaTypeSupport struct {
-	Types    []string
-	MaxBytes int
+	Types     []string
+	MaxBytes  int
+	MaxWidth  int // images only
+	MaxHeight int // images only
 }
 
 // Attachment is a resolved attachment
@@ -46,7 +50,7 @@ func ResolveAttachments(ctx context.Context, b courier.Backend, attachments []st
 		}
 		contentType, mediaUrl := parts[0], parts[1]
 
-		att, err := resolveAttachment(ctx, b, contentType, mediaUrl, support, allowURLOnly)
+		att, err := resolveAttachment(ctx, b, clog.Channel(), contentType, mediaUrl, support, allowURLOnly)
 		if err != nil {
 			return nil, err
 		}
@@ -60,7 +64,24 @@ func ResolveAttachments(ctx context.Context, b courier.Backend, attachments []st
 	return resolved, nil
 }
 
-func resolveAttachment(ctx context.Context, b courier.Backend, contentType, mediaUrl string, support map[MediaType]MediaTypeSupport, allowURLOnly bool) (*Attachment, error) {
+// ResolveAttachmentURL resolves a single attachment for channel types which send attachments by URL and can send
+// anything, but which have limits on some media types, so need some uploaded media replaced by an alternate, transcoding
+// or resizing of it. It returns the content type and URL to send, which are unchanged if there's nothing better.
+func ResolveAttachmentURL(ctx context.Context, b courier.Backend, attachment string, support map[MediaType]MediaTypeSupport, clog *courier.ChannelLog) (string, string) {
+	contentType, attURL := SplitAttachment(attachment)
+	mediaType, _ := parseContentType(contentType)
+
+	if _, limited := support[mediaType]; limited {
+		resolved, err := ResolveAttachments(ctx, b, []string{attachment}, support, true, clog)
+		if err == nil && len(resolved) == 1 && resolved[0].Media != nil {
+			return resolved[0].ContentType, resolved[0].URL
+		}
+	}
+
+	return contentType, attURL
+}
+
+func resolveAttachment(ctx context.Context, b courier.Backend, ch courier.Channel, contentType, mediaUrl string, support map[MediaType]MediaTypeSupport, allowURLOnly bool) (*Attachment, error) {
 	media, err := b.ResolveMedia(ctx, mediaUrl)
 	if err != nil {
 		return nil, err
@@ -99,6 +120,25 @@ func resolveAttachment(ctx context.Context, b courier.Backend, contentType, medi
 		candidates = filterMediaBySize(candidates, mediaSupport.MaxBytes)
 	}
 
+	// narrow down the candidates to the ones that don't exceed our max dimensions
+	if mediaSupport.MaxWidth > 0 || mediaSupport.MaxHeight > 0 {
+		candidates = filterMediaByDimensions(candidates, mediaSupport.MaxWidth, mediaSupport.MaxHeight)
+	}
+
+	// if we have no candidates for audio, we can try transcoding it to a supported type
+	if len(candidates) == 0 && mediaType == MediaTypeAudio {
+		if transcoded := transcodeAudio(ctx, b, ch, media, mediaSupport); transcoded != nil {
+			candidates = []courier.Media{transcoded}
+		}
+	}
+
+	// and if we have no candidates for an image, we can try resizing it
+	if len(candidates) == 0 && mediaType == MediaTypeImage {
+		if resized := resizeImage(ctx, b, ch, media, mediaSupport); resized != nil {
+			candidates = []courier.Media{resized}
+		}
+	}
+
 	// if we have no candidates, we can't use this media
 	if len(candidates) == 0 {
 		return nil, nil
@@ -122,6 +162,47 @@ func resolveAttachment(ctx context.Context, b courier.Backend, contentType, medi
 	}, nil
 }
 
+// transcodes the given audio media to the first supported type that the backend can transcode it to, returning nil if
+// it can't be transcoded to any of them
+func transcodeAudio(ctx context.Context, b courier.Backend, ch courier.Channel, media courier.Media, support MediaTypeSupport) courier.Media {
+	processor, ok := courier.BackendAs[courier.MediaProcessor](b)
+	if !ok {
+		return nil
+	}
+
+	for _, contentType := range support.Types {
+		transcoded, err := processor.TranscodeMedia(ctx, ch, media, contentType)
+		if err != nil {
+			slog.Error("error transcoding audio", "error", err, "url", media.URL(), "content_type", contentType)
+			continue
+		}
+		if transcoded != nil && (support.MaxBytes == 0 || transcoded.Size() <= support.MaxBytes) {
+			return transcoded
+		}
+	}
+	return nil
+}
+
+// resizes the given image media to fit within the given support, returning nil if that's not possible
+func resizeImage(ctx context.Context, b courier.Backend, ch courier.Channel, media courier.Media, support MediaTypeSupport) courier.Media {
+	// resized images are always JPEGs
+	if len(support.Types) > 0 && !slices.Contains(support.Types, "image/jpeg") {
+		return nil
+	}
+
+	processor, ok := courier.BackendAs[courier.MediaProcessor](b)
+	if !ok {
+		return nil
+	}
+
+	resized, err := processor.ResizeImage(ctx, ch, media, support.MaxWidth, support.MaxHeight, support.MaxBytes)
+	if err != nil {
+		slog.Error("error resizing image", "error", err, "url", media.URL())
+		return nil
+	}
+	return resized
+}
+
 func filterMediaByType(in []courier.Media, mediaType MediaType) []courier.Media {
 	return filterMedia(in, func(m courier.Media) bool {
 		mt, _ := parseContentType(m.ContentType())
@@ -144,6 +225,12 @@ func filterMediaBySize(in []courier.Media, maxBytes int) []courier.Media {
 	return filterMedia(in, func(m courier.Media) bool { return m.Size() <= maxBytes })
 }
 
+func filterMediaByDimensions(in []courier.Media, maxWidth, maxHeight int) []courier.Media {
+	return filterMedia(in, func(m courier.Media) bool {
+		return (maxWidth <= 0 || m.Width() <= maxWidth) && (maxHeight <= 0 || m.Height() <= maxHeight)
+	})
+}
+
 func filterMedia(in []courier.Media, f func(courier.Media) bool) []courier.Media {
 	filtered := make([]courier.Media, 0, len(in))
 	for _, m := range in {
@@ -11,6 +11,35 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestResolveAttachmentURL(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+
+	imagePNG := test.NewMockMedia("big.png", "image/png", "http://mock.com/8901/big.png", 8*1024*1024, 4000, 3000, 0, nil)
+	imageResized := test.NewMockMedia("big.jpg", "image/jpeg", "http://mock.com/9012/big.jpg", 900*1024, 2000, 1500, 0, nil)
+	mb.MockMedia(imagePNG)
+	mb.MockResizedMedia(imagePNG.URL(), imageResized)
+
+	support := map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeImage: {MaxBytes: 1024 * 1024}}
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, nil, nil)
+
+	// uploaded media which needs resizing
+	contentType, url := handlers.ResolveAttachmentURL(ctx, mb, "image/png:http://mock.com/8901/big.png", support, clog)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, "http://mock.com/9012/big.jpg", url)
+
+	// media types without limits and unresolveable URLs are unchanged
+	contentType, url = handlers.ResolveAttachmentURL(ctx, mb, "video/mp4:http://mock.com/5678/test.mp4", support, clog)
+	assert.Equal(t, "video/mp4", contentType)
+	assert.Equal(t, "http://mock.com/5678/test.mp4", url)
+
+	contentType, url = handlers.ResolveAttachmentURL(ctx, mb, "image/jpeg:https://example.com/image 1.jpg", support, clog)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, "https://example.com/image 1.jpg", url)
+
+	assert.Len(t, clog.Errors, 0)
+}
+
 func TestResolveAttachments(t *testing.T) {
 	ctx := context.Background()
 	mb := test.NewMockBackend()
@@ -25,10 +54,18 @@ func TestResolveAttachments(t *testing.T) {
 
 	videoMOV := test.NewMockMedia("test.mov", "video/quicktime", "http://mock.com/6789/test.mov", 100*1024*1024, 0, 0, 2000, nil)
 
+	audioOGG := test.NewMockMedia("test.ogg", "audio/ogg", "http://mock.com/7890/test.ogg", 512*1024, 0, 0, 200, nil)
+
+	imagePNG := test.NewMockMedia("big.png", "image/png", "http://mock.com/8901/big.png", 8*1024*1024, 4000, 3000, 0, nil)
+	imageResized := test.NewMockMedia("big.jpg", "image/jpeg", "http://mock.com/9012/big.jpg", 900*1024, 2000, 1500, 0, nil)
+
 	mb.MockMedia(imageJPG)
 	mb.MockMedia(audioMP3)
 	mb.MockMedia(videoMP4)
 	mb.MockMedia(videoMOV)
+	mb.MockTranscodedMedia("http://mock.com/3456/test.mp3", audioOGG)
+	mb.MockMedia(imagePNG)
+	mb.MockResizedMedia("http://mock.com/8901/big.png", imageResized)
 
 	tcs := []struct {
 		attachments  []string
@@ -148,6 +185,61 @@ func TestResolveAttachments(t *testing.T) {
 			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{},
 			err:          "invalid attachment format: http://mock.com/1234/test.jpg",
 		},
+		{ // 14: resolveable uploaded audio URL, no alternate of a supported type but can be transcoded
+			attachments:  []string{"audio/mp3:http://mock.com/3456/test.mp3"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeAudio: {Types: []string{"audio/aac", "audio/ogg"}}},
+			allowURLOnly: true,
+			resolved: []*handlers.Attachment{
+				{Type: handlers.MediaTypeAudio, Name: "test.ogg", ContentType: "audio/ogg", URL: "http://mock.com/7890/test.ogg", Media: audioOGG, Thumbnail: nil},
+			},
+			errors: []*clogs.LogError{},
+		},
+		{ // 15: resolveable uploaded audio URL, can be transcoded but result is too big
+			attachments:  []string{"audio/mp3:http://mock.com/3456/test.mp3"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeAudio: {Types: []string{"audio/ogg"}, MaxBytes: 256 * 1024}},
+			allowURLOnly: true,
+			resolved:     []*handlers.Attachment{},
+			errors:       []*clogs.LogError{courier.ErrorMediaUnresolveable("audio/mp3")},
+		},
+		{ // 16: resolveable uploaded audio URL, no alternate of a supported type and can't be transcoded
+			attachments:  []string{"audio/mp3:http://mock.com/3456/test.mp3"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeAudio: {Types: []string{"audio/amr"}}},
+			allowURLOnly: true,
+			resolved:     []*handlers.Attachment{},
+			errors:       []*clogs.LogError{courier.ErrorMediaUnresolveable("audio/mp3")},
+		},
+		{ // 17: resolveable uploaded image URL, too big but can be resized
+			attachments:  []string{"image/png:http://mock.com/8901/big.png"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeImage: {MaxBytes: 5 * 1024 * 1024}},
+			allowURLOnly: true,
+			resolved: []*handlers.Attachment{
+				{Type: handlers.MediaTypeImage, Name: "big.jpg", ContentType: "image/jpeg", URL: "http://mock.com/9012/big.jpg", Media: imageResized, Thumbnail: nil},
+			},
+			errors: []*clogs.LogError{},
+		},
+		{ // 18: resolveable uploaded image URL, too wide but can be resized
+			attachments:  []string{"image/png:http://mock.com/8901/big.png"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeImage: {Types: []string{"image/png", "image/jpeg"}, MaxWidth: 2048, MaxHeight: 2048}},
+			allowURLOnly: true,
+			resolved: []*handlers.Attachment{
+				{Type: handlers.MediaTypeImage, Name: "big.jpg", ContentType: "image/jpeg", URL: "http://mock.com/9012/big.jpg", Media: imageResized, Thumbnail: nil},
+			},
+			errors: []*clogs.LogError{},
+		},
+		{ // 19: resolveable uploaded image URL, too wide and can't be resized small enough
+			attachments:  []string{"image/png:http://mock.com/8901/big.png"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeImage: {MaxWidth: 1024}},
+			allowURLOnly: true,
+			resolved:     []*handlers.Attachment{},
+			errors:       []*clogs.LogError{courier.ErrorMediaUnresolveable("image/png")},
+		},
+		{ // 20: resolveable uploaded image URL, too big but channel doesn't support JPEGs
+			attachments:  []string{"image/png:http://mock.com/8901/big.png"},
+			mediaSupport: map[handlers.MediaType]handlers.MediaTypeSupport{handlers.MediaTypeImage: {Types: []string{"image/png"}, MaxBytes: 5 * 1024 * 1024}},
+			allowURLOnly: true,
+			resolved:     []*handlers.Attachment{},
+			errors:       []*clogs.LogError{courier.ErrorMediaUnresolveable("image/png")},
+		},
 	}
 
 	for i, tc := range tcs {
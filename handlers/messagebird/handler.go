@@ -11,6 +11,7 @@ import (
 	"encoding/hex"
 	"log/slog"
 	"strconv"
+	"strings"
 
 	"fmt"
 
@@ -34,6 +35,9 @@ var (
 	errorStopped = 103
 )
 
+// maximum number of recipients Messagebird accepts in a single request
+const maxBulkSize = 50
+
 type Message struct {
 	Recipients []string `json:"recipients"`
 	Reference  string   `json:"reference,omitempty"`
@@ -72,6 +76,9 @@ type ReceivedMessage struct {
 	MMS             bool     `json:"mms"`
 }
 
+// timestamps are in UTC, e.g. 2016-05-03T15:41:45+00:00, or 20160503154145 for shortcodes
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05+00:00", "20060102150405"}}
+
 func init() {
 	courier.RegisterHandler(newHandler("MBD", "Messagebird", true))
 }
@@ -119,9 +126,14 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 		}
 	}
 
-	// if we have no status, then build it from the external (messagebird) id
+	// if we have no status, then build it from the external (messagebird) id, which for messages sent in bulk without a
+	// reference also includes the recipient
 	if status == nil {
-		status = h.Backend().NewStatusUpdateByExternalID(channel, receivedStatus.ID, msgStatus, clog)
+		externalID := receivedStatus.ID
+		if receivedStatus.Reference == "" {
+			externalID = bulkExternalID(receivedStatus.ID, receivedStatus.Recipient)
+		}
+		status = h.Backend().NewStatusUpdateByExternalID(channel, externalID, msgStatus, clog)
 	}
 
 	if receivedStatus.StatusErrorCode == errorStopped {
@@ -153,15 +165,9 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our date from the timestamp
-	standardDateLayout := "2006-01-02T15:04:05+00:00"
-	date, err := time.Parse(standardDateLayout, payload.CreatedDatetime)
+	date, err := timestampFormat.Parse(payload.CreatedDatetime)
 	if err != nil {
-		//try shortcode format
-		shortCodeDateLayout := "20060102150405"
-		date, err = time.Parse(shortCodeDateLayout, payload.CreatedDatetime)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse date '%s': %v", payload.CreatedDatetime, err)
-		}
+		return nil, fmt.Errorf("unable to parse date '%s': %v", payload.CreatedDatetime, err)
 	}
 
 	// create our URN
@@ -172,7 +178,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	text := payload.Body
 
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.ID, clog).WithReceivedOn(date.UTC())
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.ID, clog).WithReceivedOn(date)
 
 	// process any attached media
 	if payload.MMS {
@@ -184,21 +190,65 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
 
+// Send sends the given message, logging any HTTP calls or errors
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	return h.sendToRecipients([]courier.MsgOut{msg}, []*courier.SendResult{res}, clog)
+}
+
+// MaxBulkSize returns the maximum number of messages to send in a single bulk send
+func (h *handler) MaxBulkSize(ch courier.Channel) int {
+	return min(ch.IntConfigForKey(courier.ConfigMaxBulkSize, 1), maxBulkSize)
+}
+
+// SendBulk sends the passed in messages, which are all for the same channel, with a request for each group of them which
+// have the same sender and content
+func (h *handler) SendBulk(ctx context.Context, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	for _, group := range handlers.GroupMsgs(msgs, bulkKey) {
+		groupMsgs := make([]courier.MsgOut, len(group))
+		groupResults := make([]*courier.SendResult, len(group))
+		for i, idx := range group {
+			groupMsgs[i], groupResults[i] = msgs[idx], results[idx]
+		}
+
+		if err := h.sendToRecipients(groupMsgs, groupResults, clog); err != nil {
+			for _, res := range groupResults {
+				res.SetError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// messages can only be sent in the same request if they're from the same number with the same content
+func bulkKey(m courier.MsgOut) string {
+	return strings.Join(append([]string{m.Channel().Address(), m.Text()}, m.Attachments()...), "\x00")
+}
+
+// messages sent to several recipients in one request don't have a reference so are identified by the Messagebird ID
+// of the request and their recipient
+func bulkExternalID(id, recipient string) string {
+	return id + ":" + strings.TrimPrefix(recipient, "+")
+}
+
+// sends the given messages, which have the same sender and content, in a single request to their recipients
+func (h *handler) sendToRecipients(msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	msg := msgs[0]
+
 	authToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
 	if authToken == "" {
 		return courier.ErrChannelConfig
 	}
 
-	user := msg.URN().Path()
 	// create base payload
-	payload := &Message{
-		Recipients: []string{user},
-		Originator: msg.Channel().Address(),
-		Reference:  msg.ID().String(),
+	payload := &Message{Originator: msg.Channel().Address()}
+	for _, m := range msgs {
+		payload.Recipients = append(payload.Recipients, m.URN().Path())
+	}
+	if len(msgs) == 1 {
+		payload.Reference = msg.ID().String()
 	}
-	// build message payload
 
+	// build message payload
 	if len(msg.Text()) > 0 {
 		payload.Body = msg.Text()
 	}
@@ -234,8 +284,12 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	externalID, err := jsonparser.GetString(respBody, "id")
 	if err != nil {
 		clog.Error(courier.ErrorResponseValueMissing("id"))
+	} else if len(msgs) == 1 {
+		results[0].AddExternalID(externalID)
 	} else {
-		res.AddExternalID(externalID)
+		for i, m := range msgs {
+			results[i].AddExternalID(bulkExternalID(externalID, m.URN().Path()))
+		}
 	}
 
 	return nil
@@ -1,6 +1,8 @@
 package messagebird
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -12,6 +14,8 @@ import (
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testChannels = []courier.Channel{
@@ -173,6 +177,12 @@ var defaultReceiveTestCases = []IncomingTestCase{
 		},
 		ExpectedErrors: []*clogs.LogError{courier.ErrorExternal("103", "Contact has sent 'stop'")},
 	},
+	{
+		Label:              "Status Valid For Bulk Send",
+		URL:                "/c/mbd/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=b6aae1b5dfb2427a8f7ea6a717ba31a9&recipient=18885551515&status=delivered",
+		ExpectedRespStatus: 200,
+		ExpectedStatuses:   []ExpectedStatus{{ExternalID: "b6aae1b5dfb2427a8f7ea6a717ba31a9:18885551515", Status: courier.MsgStatusDelivered}},
+	},
 	{
 		Label:                "Receive Invalid Status",
 		URL:                  statusBaseURL + "&status=expiryttd",
@@ -320,3 +330,52 @@ func TestOutgoing(t *testing.T) {
 	})
 	RunOutgoingTestCases(t, defaultChannel, newHandler("MBD", "Messagebird", false), defaultSendTestCases, []string{"my_super_secret", "authtoken"}, nil)
 }
+
+func TestSendBulk(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MBD", "18005551212", "US", []string{urns.Phone.Prefix}, map[string]any{
+		"auth_token":              "authtoken",
+		courier.ConfigMaxBulkSize: 100,
+	})
+
+	mb := test.NewMockBackend()
+	h := newHandler("MBD", "Messagebird", false).(*handler)
+	h.Initialize(test.NewMockServer(courier.NewDefaultConfig(), mb))
+
+	assert.Equal(t, 50, h.MaxBulkSize(ch))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://rest.messagebird.com/messages": {
+			httpx.NewMockResponse(200, nil, []byte(`{"id":"efa6405d518d4c0c88cce11f7db775fb"}`)),
+			httpx.NewMockResponse(500, nil, []byte(`{"errors":[]}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	msgs := []courier.MsgOut{
+		test.NewMockMsg(10, "", ch, "tel:+250788383383", "Hi all", nil),
+		test.NewMockMsg(11, "", ch, "tel:+250788383384", "Hi Bob", nil),
+		test.NewMockMsg(12, "", ch, "tel:+250788383385", "Hi all", nil),
+	}
+	results := []*courier.SendResult{{}, {}, {}}
+	clog := courier.NewChannelLogForSend(msgs[0], h.RedactValues(ch))
+
+	err := h.SendBulk(context.Background(), msgs, results, clog)
+	assert.NoError(t, err)
+
+	// messages with the same content are sent to all their recipients in one request
+	assert.Equal(t, []string{"efa6405d518d4c0c88cce11f7db775fb:250788383383"}, results[0].ExternalIDs())
+	assert.NoError(t, results[0].GetError())
+	assert.Equal(t, []string{"efa6405d518d4c0c88cce11f7db775fb:250788383385"}, results[2].ExternalIDs())
+	assert.NoError(t, results[2].GetError())
+
+	// others get their own request
+	assert.Nil(t, results[1].ExternalIDs())
+	assert.Equal(t, courier.ErrConnectionFailed, results[1].GetError())
+
+	require.Len(t, mocks.Requests(), 2)
+	body, _ := io.ReadAll(mocks.Requests()[0].Body)
+	assert.Equal(t, `{"recipients":["+250788383383","+250788383385"],"originator":"18005551212","body":"Hi all"}`, string(body))
+	body, _ = io.ReadAll(mocks.Requests()[1].Body)
+	assert.Equal(t, `{"recipients":["+250788383384"],"reference":"11","originator":"18005551212","body":"Hi Bob"}`, string(body))
+}
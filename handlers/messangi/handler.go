@@ -68,7 +68,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength)
 	for _, part := range parts {
 		shortcode := strings.TrimPrefix(msg.Channel().Address(), "+")
 		to := strings.TrimPrefix(msg.URN().Path(), "+")
@@ -100,6 +100,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		if response.Status != "OK" {
 			return courier.ErrResponseStatus
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -3,6 +3,7 @@ package meta
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
@@ -185,6 +187,17 @@ var facebookIncomingTests = []IncomingTestCase{
 		},
 		PrepRequest: addValidSignature,
 	},
+	{
+		Label:                "Receive Referral From Ad",
+		URL:                  "/c/fba/receive",
+		Data:                 string(test.ReadFile("./testdata/fba/referral_ad.json")),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"referrer_id":"summer"`,
+		ExpectedEvents: []ExpectedEvent{
+			{Type: courier.EventTypeReferral, URN: "facebook:5678", Time: time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC), Extra: map[string]string{"referrer_id": "summer", "source": "ADS", "type": "OPEN_THREAD", "ad_id": "6045246247433", "headline": "Summer Sale", "media_type": "image", "media_url": "https://scontent.xx.fbcdn.net/ad.jpg"}},
+		},
+		PrepRequest: addValidSignature,
+	},
 	{
 		Label:                "Receive Referral timestamp seconds",
 		URL:                  "/c/fba/receive",
@@ -291,7 +304,7 @@ var facebookIncomingTests = []IncomingTestCase{
 }
 
 func TestFacebookIncoming(t *testing.T) {
-	graphURL = createMockGraphAPI().URL
+	mockGraphURL(t, createMockGraphAPI().URL)
 
 	RunIncomingTestCases(t, facebookTestChannels, newHandler("FBA", "Facebook"), facebookIncomingTests)
 }
@@ -299,6 +312,7 @@ func TestFacebookIncoming(t *testing.T) {
 func TestFacebookDescribeURN(t *testing.T) {
 	fbGraph := buildMockFBGraphFBA(facebookIncomingTests)
 	defer fbGraph.Close()
+	mockGraphURL(t, fbGraph.URL)
 
 	channel := facebookTestChannels[0]
 	handler := newHandler("FBA", "Facebook")
@@ -371,7 +385,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:    "facebook:12345",
 		MsgOrigin: courier.MsgOriginChat,
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -387,7 +401,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:    "facebook:12345",
 		MsgOrigin: courier.MsgOriginBroadcast,
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -404,7 +418,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURNAuth: "345678",
 		MsgOrigin:  courier.MsgOriginBroadcast,
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -421,7 +435,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:               courier.MsgOriginFlow,
 		MsgResponseToExternalID: "23526",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -438,7 +452,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:               courier.MsgOriginFlow,
 		MsgResponseToExternalID: "23526",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133", "recipient_id": "12345"}`)),
 			},
 		},
@@ -456,7 +470,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:       courier.MsgOriginBroadcast,
 		MsgQuickReplies: []string{"Yes", "No"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -473,7 +487,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:       courier.MsgOriginBroadcast,
 		MsgQuickReplies: []string{"Yes", "No"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -490,7 +504,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgQuickReplies: []string{"Yes", "No"},
 		MsgTopic:        "account",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
@@ -512,7 +526,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:         "facebook:12345",
 		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -530,7 +544,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgQuickReplies: []string{"Yes", "No"},
 		MsgTopic:        "event",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
@@ -552,7 +566,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:         "facebook:12345",
 		MsgAttachments: []string{"application/pdf:https://foo.bar/document.pdf"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -567,7 +581,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:         "facebook:12345",
 		MsgAttachments: []string{"document:https://foo.bar/document.pdf"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -582,7 +596,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgURN:   "facebook:12345",
 		MsgOptIn: &courier.OptInReference{ID: 3456, Name: "Joke Of The Day"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -597,7 +611,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgText: "ID Error",
 		MsgURN:  "facebook:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{ "is_error": true }`)),
 			},
 		},
@@ -608,18 +622,40 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgText: "Error",
 		MsgURN:  "facebook:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(403, nil, []byte(`{ "is_error": true }`)),
 			},
 		},
 		ExpectedError: courier.ErrResponseStatus,
 	},
+	{
+		Label:   "Contact has blocked page",
+		MsgText: "Error",
+		MsgURN:  "facebook:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://graph.facebook.com/v23.0/me/messages*": {
+				httpx.NewMockResponse(400, nil, []byte(`{"error": {"message": "(#551) This person isn't available right now.", "code": 551, "error_subcode": 1545041}}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+	{
+		Label:   "Contact not found",
+		MsgText: "Error",
+		MsgURN:  "facebook:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://graph.facebook.com/v23.0/me/messages*": {
+				httpx.NewMockResponse(400, nil, []byte(`{"error": {"message": "(#100) No matching user found", "code": 100, "error_subcode": 2018001}}`)),
+			},
+		},
+		ExpectedError: courier.ErrContactUnknown,
+	},
 	{
 		Label:   "Response is invalid JSON",
 		MsgText: "Error",
 		MsgURN:  "facebook:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`bad json`)),
 			},
 		},
@@ -630,7 +666,7 @@ var facebookOutgoingTests = []OutgoingTestCase{
 		MsgText: "Error",
 		MsgURN:  "facebook:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{ "error": {"message": "The image size is too large.","code": 36000 }}`)),
 			},
 		},
@@ -649,6 +685,130 @@ func TestFacebookOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, channel, newHandler("FBA", "Facebook"), facebookOutgoingTests, checkRedacted, nil)
 }
 
+func TestFacebookTokenRefresh(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	config := courier.NewDefaultConfig()
+	config.FacebookApplicationID = "fb_app_id"
+	handler := newHandler("FBA", "Facebook").(*handler)
+	handler.Initialize(courier.NewServer(config, mb))
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"*/oauth/access_token?*": {
+			httpx.NewMockResponse(200, nil, []byte(`{"access_token": "b234", "token_type": "bearer", "expires_in": 5184000}`)),
+			httpx.NewMockResponse(400, nil, []byte(`{"error": {"message": "Error validating access token", "code": 190}}`)),
+		},
+	})
+	httpx.SetRequestor(mockHTTP)
+
+	// tokens without an expiry or not expiring soon aren't refreshed
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FBA", "12345", "", []string{urns.Facebook.Prefix}, map[string]any{courier.ConfigAuthToken: "a123"})
+	assert.Equal(t, "a123", handler.pageAccessToken(ctx, channel))
+
+	channel.SetConfig(configTokenExpiresOn, time.Now().Add(time.Hour*24*30).Format(time.RFC3339))
+	assert.Equal(t, "a123", handler.pageAccessToken(ctx, channel))
+	assert.Len(t, mockHTTP.Requests(), 0)
+
+	// a token expiring soon is exchanged and the new token saved to the channel
+	channel.SetConfig(configTokenExpiresOn, time.Now().Add(time.Hour*24).Format(time.RFC3339))
+	assert.Equal(t, "b234", handler.pageAccessToken(ctx, channel))
+	assert.Len(t, mockHTTP.Requests(), 1)
+	assert.True(t, strings.HasSuffix(mockHTTP.Requests()[0].URL.Path, "/oauth/access_token"))
+	assert.Equal(t, url.Values{"grant_type": {"fb_exchange_token"}, "client_id": {"fb_app_id"}, "client_secret": {"missing_facebook_app_secret"}, "fb_exchange_token": {"a123"}}, mockHTTP.Requests()[0].URL.Query())
+	assert.Equal(t, "b234", channel.StringConfigForKey(courier.ConfigAuthToken, ""))
+
+	expiresOn, err := time.Parse(time.RFC3339, channel.StringConfigForKey(configTokenExpiresOn, ""))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour*24*60), expiresOn, time.Minute)
+
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+	assert.Equal(t, courier.ChannelLogTypeTokenRefresh, mb.WrittenChannelLogs()[0].Type)
+	AssertChannelLogRedaction(t, mb.WrittenChannelLogs()[0], []string{"a123", "missing_facebook_app_secret"})
+
+	// a stale copy of the channel uses the refreshed token from the cache
+	stale := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FBA", "12345", "", []string{urns.Facebook.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "a123",
+		configTokenExpiresOn:    time.Now().Add(time.Hour * 24).Format(time.RFC3339),
+	})
+	assert.Equal(t, "b234", handler.pageAccessToken(ctx, stale))
+	assert.Len(t, mockHTTP.Requests(), 1)
+
+	// if the exchange fails, we keep using the existing token
+	other := test.NewMockChannel("b5b0a9e4-7a4b-4d3b-9a2e-7d8b5c1f6e3a", "FBA", "23456", "", []string{urns.Facebook.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "c345",
+		configTokenExpiresOn:    time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	assert.Equal(t, "c345", handler.pageAccessToken(ctx, other))
+	assert.Len(t, mockHTTP.Requests(), 2)
+	assert.Equal(t, "c345", other.StringConfigForKey(courier.ConfigAuthToken, ""))
+	assert.Len(t, mb.WrittenChannelLogs(), 2)
+	assert.True(t, mb.WrittenChannelLogs()[1].IsError())
+}
+
+func TestFacebookConfigureProfile(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	ctx := context.Background()
+	handler := newHandler("FBA", "Facebook")
+	handler.Initialize(courier.NewServer(courier.NewDefaultConfig(), test.NewMockBackend()))
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"*/me/messenger_profile?access_token=a123": {
+			httpx.NewMockResponse(200, nil, []byte(`{"result": "success"}`)),
+			httpx.NewMockResponse(400, nil, []byte(`{"error": {"message": "Invalid parameter", "code": 100}}`)),
+		},
+	})
+	httpx.SetRequestor(mockHTTP)
+
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FBA", "12345", "", []string{urns.Facebook.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "a123",
+		configGreeting:          "Welcome to Acme!",
+		configPersistentMenu: []any{
+			map[string]any{"type": "postback", "title": "Talk to us", "payload": "talk"},
+			map[string]any{"type": "web_url", "title": "Shop", "url": "https://acme.com"},
+		},
+		configIceBreakers: []any{map[string]any{"question": "Where are you?", "payload": "location"}},
+	})
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+	err := handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.NoError(t, err)
+	assert.Len(t, clog.HttpLogs, 1)
+	AssertChannelLogRedaction(t, clog, []string{"a123"})
+
+	body, _ := io.ReadAll(mockHTTP.Requests()[0].Body)
+	assert.JSONEq(t, `{
+		"greeting": [{"locale": "default", "text": "Welcome to Acme!"}],
+		"persistent_menu": [{"locale": "default", "composer_input_disabled": false, "call_to_actions": [
+			{"type": "postback", "title": "Talk to us", "payload": "talk"},
+			{"type": "web_url", "title": "Shop", "url": "https://acme.com"}
+		]}],
+		"ice_breakers": [{"locale": "default", "call_to_actions": [{"question": "Where are you?", "payload": "location"}]}]
+	}`, string(body))
+
+	// error from API
+	clog = courier.NewChannelLog(courier.ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.EqualError(t, err, "unable to update messenger profile")
+	assert.Equal(t, []*clogs.LogError{courier.ErrorExternal("100", "Invalid parameter")}, clog.Errors)
+
+	// invalid or missing config doesn't make any requests
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FBA", "12345", "", []string{urns.Facebook.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "a123",
+		configPersistentMenu:    []any{map[string]any{"type": "call", "title": "Call us"}},
+	})
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.ErrorContains(t, err, "invalid profile config")
+
+	channel = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FBA", "12345", "", []string{urns.Facebook.Prefix}, map[string]any{courier.ConfigAuthToken: "a123"})
+	err = handler.(courier.ProfileConfigurer).ConfigureProfile(ctx, channel, clog)
+	assert.EqualError(t, err, "channel has no profile config")
+
+	assert.Len(t, mockHTTP.Requests(), 2)
+}
+
 func TestSigning(t *testing.T) {
 	tcs := []struct {
 		Body      string
@@ -675,7 +835,7 @@ func TestFacebookBuildAttachmentRequest(t *testing.T) {
 	mb := test.NewMockBackend()
 	s := courier.NewServer(courier.NewDefaultConfig(), mb)
 
-	handler := &handler{NewBaseHandler(courier.ChannelType("FBA"), "Facebook", DisableUUIDRouting())}
+	handler := &handler{BaseHandler: NewBaseHandler(courier.ChannelType("FBA"), "Facebook", DisableUUIDRouting())}
 	handler.Initialize(s)
 	req, _ := handler.BuildAttachmentRequest(context.Background(), mb, facebookTestChannels[0], "https://example.org/v1/media/41", nil)
 	assert.Equal(t, "https://example.org/v1/media/41", req.URL.String())
@@ -724,6 +884,13 @@ func createMockGraphAPI() *httptest.Server {
 	}))
 }
 
+// points graph API requests at the given mock server until the test completes
+func mockGraphURL(t *testing.T, u string) {
+	orig := graphURL
+	graphURL = u
+	t.Cleanup(func() { graphURL = orig })
+}
+
 func addValidSignature(r *http.Request) {
 	body, _ := ReadBody(r, maxRequestBodyBytes)
 	sig, _ := fbCalculateSignature("fb_app_secret", body)
@@ -753,7 +920,6 @@ func buildMockFBGraphFBA(testCases []IncomingTestCase) *httptest.Server {
 		// no name
 		w.Write([]byte(`{ "first_name": "", "last_name": ""}`))
 	}))
-	graphURL = server.URL
 
 	return server
 }
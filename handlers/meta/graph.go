@@ -0,0 +1,140 @@
+package meta
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// channels can use a different version of the Graph API to the one configured globally
+const configGraphVersion = "graph_version"
+
+var (
+	graphURL = "https://graph.facebook.com/"
+
+	// the oldest version whose payloads we know how to handle
+	minGraphVersion = graphVersion{18, 0}
+
+	// when versions stop being supported by Meta, see https://developers.facebook.com/docs/graph-api/changelog/versions
+	graphVersionExpirations = map[graphVersion]time.Time{
+		{18, 0}: time.Date(2026, 1, 26, 0, 0, 0, 0, time.UTC),
+		{19, 0}: time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC),
+		{20, 0}: time.Date(2026, 9, 24, 0, 0, 0, 0, time.UTC),
+		{21, 0}: time.Date(2027, 1, 21, 0, 0, 0, 0, time.UTC),
+		{22, 0}: time.Date(2027, 5, 29, 0, 0, 0, 0, time.UTC),
+		{23, 0}: time.Date(2027, 10, 8, 0, 0, 0, 0, time.UTC),
+	}
+
+	// how long before a version expires that we start warning about it
+	graphVersionWarningPeriod = time.Hour * 24 * 90
+
+	graphVersionRegex = regexp.MustCompile(`^v(\d+)\.(\d+)$`)
+)
+
+type graphVersion struct {
+	major, minor int
+}
+
+func parseGraphVersion(s string) (graphVersion, error) {
+	m := graphVersionRegex.FindStringSubmatch(s)
+	if m == nil {
+		return graphVersion{}, fmt.Errorf("invalid graph API version '%s', should be like v23.0", s)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return graphVersion{major, minor}, nil
+}
+
+func (v graphVersion) String() string { return fmt.Sprintf("v%d.%d", v.major, v.minor) }
+
+func (v graphVersion) olderThan(o graphVersion) bool {
+	return v.major < o.major || (v.major == o.major && v.minor < o.minor)
+}
+
+// validate checks that the version is one we support, returning a warning if Meta has stopped or will soon stop
+// supporting it
+func (v graphVersion) validate(now time.Time) (string, error) {
+	if v.olderThan(minGraphVersion) {
+		return "", fmt.Errorf("graph API version %s is not supported, minimum is %s", v, minGraphVersion)
+	}
+
+	if expires, ok := graphVersionExpirations[v]; ok {
+		if now.After(expires) {
+			return fmt.Sprintf("graph API version %s is no longer supported by Meta since %s", v, expires.Format(time.DateOnly)), nil
+		} else if expires.Sub(now) < graphVersionWarningPeriod {
+			return fmt.Sprintf("graph API version %s will no longer be supported by Meta after %s", v, expires.Format(time.DateOnly)), nil
+		}
+	}
+	return "", nil
+}
+
+// graphVersions resolves the graph API version to use for each channel
+type graphVersions struct {
+	defaultVersion graphVersion
+
+	warned sync.Map // channel versions we've already warned about
+}
+
+func newGraphVersions(cfg *courier.Config, now time.Time) (*graphVersions, error) {
+	v, err := parseGraphVersion(cfg.MetaGraphVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	warning, err := v.validate(now)
+	if err != nil {
+		return nil, err
+	}
+	if warning != "" {
+		slog.Warn(warning, "comp", "meta")
+	}
+
+	return &graphVersions{defaultVersion: v}, nil
+}
+
+// forChannel returns the version to use for the given channel, which may be nil for requests not made for a channel
+func (g *graphVersions) forChannel(ch courier.Channel) graphVersion {
+	if ch == nil {
+		return g.defaultVersion
+	}
+
+	override := ch.StringConfigForKey(configGraphVersion, "")
+	if override == "" {
+		return g.defaultVersion
+	}
+
+	log := slog.With("comp", "meta", "channel_uuid", ch.UUID(), "graph_version", override)
+
+	v, err := parseGraphVersion(override)
+	if err == nil {
+		var warning string
+		warning, err = v.validate(time.Now())
+		if err == nil {
+			if warning != "" {
+				if _, warned := g.warned.LoadOrStore(fmt.Sprintf("%s:%s", ch.UUID(), v), true); !warned {
+					log.Warn(warning)
+				}
+			}
+			return v
+		}
+	}
+
+	// an invalid override shouldn't stop us sending, so use the default version instead
+	if _, warned := g.warned.LoadOrStore(fmt.Sprintf("%s:%s", ch.UUID(), override), true); !warned {
+		log.Error("ignoring invalid channel graph API version", "error", err)
+	}
+	return g.defaultVersion
+}
+
+// graphURL returns the URL of the given path in the graph API version used by the given channel
+func (h *handler) graphURL(ch courier.Channel, path string) *url.URL {
+	u, _ := url.Parse(fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(graphURL, "/"), h.graphVersions.forChannel(ch), strings.TrimPrefix(path, "/")))
+	return u
+}
@@ -0,0 +1,106 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphVersionValidate(t *testing.T) {
+	now := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	tcs := []struct {
+		version string
+		warning string
+		err     string
+	}{
+		{"v18.0", "graph API version v18.0 is no longer supported by Meta since 2026-01-26", ""},
+		{"v20.0", "graph API version v20.0 will no longer be supported by Meta after 2026-09-24", ""},
+		{"v21.0", "", ""},
+		{"v24.0", "", ""},
+		{"v17.0", "", "graph API version v17.0 is not supported, minimum is v18.0"},
+	}
+
+	for _, tc := range tcs {
+		v, err := parseGraphVersion(tc.version)
+		require.NoError(t, err)
+
+		warning, err := v.validate(now)
+		assert.Equal(t, tc.warning, warning, "warning mismatch for %s", tc.version)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s", tc.version)
+		} else {
+			assert.NoError(t, err, "unexpected error for %s", tc.version)
+		}
+	}
+
+	_, err := parseGraphVersion("18")
+	assert.EqualError(t, err, "invalid graph API version '18', should be like v23.0")
+}
+
+func TestDefaultGraphVersion(t *testing.T) {
+	v, err := parseGraphVersion(courier.NewDefaultConfig().MetaGraphVersion)
+	require.NoError(t, err)
+
+	// the default should be a version we know the expiration of and which is still supported
+	expires, ok := graphVersionExpirations[v]
+	assert.True(t, ok, "no expiration for default version %s", v)
+	assert.True(t, expires.After(time.Now()), "default version %s expired on %s", v, expires.Format(time.DateOnly))
+}
+
+func TestGraphURL(t *testing.T) {
+	cfg := courier.NewDefaultConfig()
+	cfg.MetaGraphVersion = "v21.0"
+
+	h := newHandler("WAC", "WhatsApp Cloud").(*handler)
+	require.NoError(t, h.Initialize(test.NewMockServer(cfg, test.NewMockBackend())))
+
+	defaultCh := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", []string{urns.WhatsApp.Prefix}, nil)
+	overrideCh := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", []string{urns.WhatsApp.Prefix}, map[string]any{configGraphVersion: "v22.0"})
+	invalidCh := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", []string{urns.WhatsApp.Prefix}, map[string]any{configGraphVersion: "v12.0"})
+
+	assert.Equal(t, "https://graph.facebook.com/v21.0/12345/messages", h.graphURL(defaultCh, "12345/messages").String())
+	assert.Equal(t, "https://graph.facebook.com/v22.0/12345/messages", h.graphURL(overrideCh, "12345/messages").String())
+	assert.Equal(t, "https://graph.facebook.com/v21.0/12345/messages", h.graphURL(invalidCh, "/12345/messages").String())
+	assert.Equal(t, "https://graph.facebook.com/v21.0/oauth/access_token", h.graphURL(nil, "oauth/access_token").String())
+
+	// invalid global versions prevent the handler from starting
+	cfg.MetaGraphVersion = "latest"
+	err := newHandler("WAC", "WhatsApp Cloud").Initialize(test.NewMockServer(cfg, test.NewMockBackend()))
+	assert.EqualError(t, err, "invalid graph API version 'latest', should be like v23.0")
+
+	cfg.MetaGraphVersion = "v16.0"
+	err = newHandler("WAC", "WhatsApp Cloud").Initialize(test.NewMockServer(cfg, test.NewMockBackend()))
+	assert.EqualError(t, err, "graph API version v16.0 is not supported, minimum is v18.0")
+}
+
+func TestGraphVersionOverrideSend(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345_ID", "", []string{urns.WhatsApp.Prefix}, map[string]any{courier.ConfigAuthToken: "a123", configGraphVersion: "v21.0"})
+
+	RunOutgoingTestCases(t, ch, newHandler("WAC", "Cloud API WhatsApp"), []OutgoingTestCase{
+		{
+			Label:   "Plain Send",
+			MsgText: "Simple Message",
+			MsgURN:  "whatsapp:250788123123",
+			MockResponses: map[string][]*httpx.MockResponse{
+				"https://graph.facebook.com/v21.0/12345_ID/messages": {
+					httpx.NewMockResponse(201, nil, []byte(`{ "messages": [{"id": "157b5e14568e8"}] }`)),
+				},
+			},
+			ExpectedRequests: []ExpectedRequest{
+				{
+					Path: "/v21.0/12345_ID/messages",
+					Body: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"Simple Message","preview_url":false}}`,
+				},
+			},
+			ExpectedExtIDs: []string{"157b5e14568e8"},
+		},
+	}, []string{"a123"}, nil)
+}
@@ -14,7 +14,9 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -29,9 +31,6 @@ import (
 
 // Endpoints we hit
 var (
-	sendURL  = "https://graph.facebook.com/v18.0/me/messages"
-	graphURL = "https://graph.facebook.com/v18.0/"
-
 	signatureHeader = "X-Hub-Signature-256"
 
 	maxRequestBodyBytes int64 = 1024 * 1024
@@ -39,6 +38,9 @@ var (
 	// max for the body
 	maxMsgLength = 1000
 
+	// max for WhatsApp media captions, longer text is sent as a separate message
+	maxCaptionLength = 1024
+
 	// Sticker ID substitutions
 	stickerIDToEmoji = map[int64]string{
 		369239263222822: "👍", // small
@@ -54,20 +56,28 @@ var (
 	}
 
 	wacThrottlingErrorCodes = []int{4, 80007, 130429, 131048, 131056, 133016}
+
+	// Messenger send errors which tell us the recipient can't be messaged, by error code or subcode
+	messengerContactErrorCodes    = map[int]error{551: courier.ErrContactStopped}     // person isn't available, i.e. blocked us
+	messengerContactErrorSubcodes = map[int]error{2018001: courier.ErrContactUnknown} // no matching user found
 )
 
 // keys for extra in channel events
 const (
 	referrerIDKey = "referrer_id"
-	sourceKey     = "source"
-	adIDKey       = "ad_id"
-	typeKey       = "type"
 	titleKey      = "title"
 	payloadKey    = "payload"
+	msgIDKey      = "msg_external_id"
+	emojiKey      = "emoji"
+	commentIDKey  = "comment_id"
+	parentIDKey   = "parent_id"
+	mediaIDKey    = "media_id"
+	textKey       = "text"
+	usernameKey   = "username"
 )
 
 func newHandler(channelType courier.ChannelType, name string) courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(channelType, name, handlers.DisableUUIDRouting(), handlers.WithRedactConfigKeys(courier.ConfigAuthToken))}
+	return &handler{BaseHandler: handlers.NewBaseHandler(channelType, name, handlers.DisableUUIDRouting(), handlers.WithRedactConfigKeys(courier.ConfigAuthToken))}
 }
 
 func init() {
@@ -79,11 +89,20 @@ func init() {
 
 type handler struct {
 	handlers.BaseHandler
+
+	refreshTokenMutex sync.Mutex
+	graphVersions     *graphVersions
 }
 
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
+
+	var err error
+	if h.graphVersions, err = newGraphVersions(s.Config(), time.Now()); err != nil {
+		return err
+	}
+
 	s.AddHandlerRoute(h, http.MethodGet, "receive", courier.ChannelLogTypeWebhookVerify, h.receiveVerify)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMultiReceive, handlers.JSONPayload(h, h.receiveEvents))
 	return nil
@@ -113,8 +132,9 @@ type Notifications struct {
 	Entry  []struct {
 		ID        string                `json:"id"`
 		Time      int64                 `json:"time"`
-		Changes   []whatsapp.Change     `json:"changes"`   // used by WhatsApp
+		Changes   []whatsapp.Change     `json:"changes"`   // used by WhatsApp and for Instagram comments
 		Messaging []messenger.Messaging `json:"messaging"` // used by Facebook and Instgram
+		Standby   []messenger.Messaging `json:"standby"`   // used for Instagram message requests
 	} `json:"entry"`
 }
 
@@ -124,6 +144,15 @@ func (h *handler) RedactValues(ch courier.Channel) []string {
 	return vals
 }
 
+// returns the access token to use for the given WhatsApp channel, which can have its own token, e.g. for a separate
+// system user per workspace, but otherwise uses the global admin system user token
+func (h *handler) wacAccessToken(ch courier.Channel) string {
+	if token := ch.StringConfigForKey(courier.ConfigAuthToken, ""); token != "" {
+		return token
+	}
+	return h.Server().Config().WhatsappAdminSystemUserToken
+}
+
 // WriteRequestError writes the passed in error to our response writer
 func (h *handler) WriteRequestError(ctx context.Context, w http.ResponseWriter, err error) error {
 	return courier.WriteError(w, http.StatusOK, err)
@@ -165,6 +194,11 @@ func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Chan
 			return nil, fmt.Errorf("no changes found")
 		}
 
+		// some changes like quality updates are for the whole business account rather than a phone number
+		if payload.Entry[0].Changes[0].Value.Metadata == nil {
+			return nil, fmt.Errorf("no channel address found")
+		}
+
 		channelAddress = payload.Entry[0].Changes[0].Value.Metadata.PhoneNumberID
 		if channelAddress == "" {
 			return nil, fmt.Errorf("no channel address found")
@@ -192,14 +226,12 @@ func (h *handler) receiveVerify(ctx context.Context, channel courier.Channel, w
 	return nil, err
 }
 
-func (h *handler) resolveMediaURL(mediaID string, token string, clog *courier.ChannelLog) (string, error) {
+func (h *handler) resolveMediaURL(channel courier.Channel, mediaID string, token string, clog *courier.ChannelLog) (string, error) {
 	if token == "" {
 		return "", fmt.Errorf("missing token for WA channel")
 	}
 
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s", mediaID))
-	retrieveURL := base.ResolveReference(path)
+	retrieveURL := h.graphURL(channel, mediaID)
 
 	// set the access token as the authorization header
 	req, _ := http.NewRequest(http.MethodGet, retrieveURL.String(), nil)
@@ -256,7 +288,7 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 	// the list of data we will return in our response
 	data := make([]any, 0, 2)
 
-	token := h.Server().Config().WhatsappAdminSystemUserToken
+	token := h.wacAccessToken(channel)
 
 	seenMsgIDs := make(map[string]bool, 2)
 	contactNames := make(map[string]string)
@@ -273,19 +305,36 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				contactNames[contact.WaID] = contact.Profile.Name
 			}
 
-			for _, msg := range change.Value.Messages {
+			// in coexistence mode, messages sent from the WhatsApp Business app are echoed to us and recorded as outgoing
+			isEcho := change.Field == "smb_message_echoes"
+			msgs := change.Value.Messages
+			if isEcho {
+				msgs = change.Value.MessageEchoes
+			}
+
+			for _, msg := range msgs {
 				if seenMsgIDs[msg.ID] {
 					continue
 				}
 
+				// the contact is the recipient of an echo rather than its sender
+				contactID := msg.From
+				if isEcho {
+					contactID = msg.To
+				} else if change.Value.Metadata != nil && isOwnNumber(msg.From, change.Value.Metadata.DisplayPhoneNumber) {
+					// businesses in coexistence mode can message their own number from the app, ignore these
+					data = append(data, courier.NewInfoData("ignoring message from own number"))
+					continue
+				}
+
 				// create our date from the timestamp
 				ts, err := strconv.ParseInt(msg.Timestamp, 10, 64)
 				if err != nil {
 					return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid timestamp: %s", msg.Timestamp))
 				}
-				date := parseTimestamp(ts)
+				date := handlers.ParseUnixTimestamp(ts, handlers.TimestampSecondsOrMillis)
 
-				urn, err := urns.New(urns.WhatsApp, msg.From)
+				urn, err := urns.New(urns.WhatsApp, contactID)
 				if err != nil {
 					return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid whatsapp id"))
 				}
@@ -294,42 +343,102 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 					clog.Error(courier.ErrorExternal(strconv.Itoa(msgError.Code), msgError.Title))
 				}
 
+				// reactions from the business aren't something we can record
+				if isEcho && msg.Type == "reaction" {
+					data = append(data, courier.NewInfoData("ignoring echoed reaction"))
+					seenMsgIDs[msg.ID] = true
+					continue
+				}
+
+				// reactions to messages are events rather than messages, with an empty emoji meaning reaction was removed
+				if msg.Type == "reaction" && msg.Reaction != nil {
+					event := h.Backend().NewChannelEvent(channel, courier.EventTypeReaction, urn, clog).
+						WithOccurredOn(date).
+						WithContactName(contactNames[contactID]).
+						WithExtra(map[string]string{msgIDKey: msg.Reaction.MessageID, emojiKey: msg.Reaction.Emoji})
+
+					if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+						return nil, nil, err
+					}
+
+					events = append(events, event)
+					data = append(data, courier.NewEventReceiveData(event))
+					seenMsgIDs[msg.ID] = true
+					continue
+				}
+
+				// messages from click-to-WhatsApp ads carry the referral which we record as a separate event
+				if msg.Referral != nil {
+					event := h.Backend().NewChannelEvent(channel, courier.EventTypeReferral, urn, clog).
+						WithOccurredOn(date).
+						WithContactName(contactNames[contactID]).
+						WithExtra(msg.Referral.Normalize().Extra())
+
+					if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+						return nil, nil, err
+					}
+
+					events = append(events, event)
+					data = append(data, courier.NewEventReceiveData(event))
+				}
+
 				text := ""
 				mediaURL := ""
+				metadata := make(map[string]any)
 
 				if msg.Type == "text" {
 					text = msg.Text.Body
 				} else if msg.Type == "audio" && msg.Audio != nil {
 					text = msg.Audio.Caption
-					mediaURL, err = h.resolveMediaURL(msg.Audio.ID, token, clog)
+					mediaURL, err = h.resolveMediaURL(channel, msg.Audio.ID, token, clog)
 				} else if msg.Type == "voice" && msg.Voice != nil {
 					text = msg.Voice.Caption
-					mediaURL, err = h.resolveMediaURL(msg.Voice.ID, token, clog)
+					mediaURL, err = h.resolveMediaURL(channel, msg.Voice.ID, token, clog)
 				} else if msg.Type == "button" && msg.Button != nil {
 					text = msg.Button.Text
 				} else if msg.Type == "document" && msg.Document != nil {
 					text = msg.Document.Caption
-					mediaURL, err = h.resolveMediaURL(msg.Document.ID, token, clog)
+					mediaURL, err = h.resolveMediaURL(channel, msg.Document.ID, token, clog)
 				} else if msg.Type == "image" && msg.Image != nil {
 					text = msg.Image.Caption
-					mediaURL, err = h.resolveMediaURL(msg.Image.ID, token, clog)
+					mediaURL, err = h.resolveMediaURL(channel, msg.Image.ID, token, clog)
 				} else if msg.Type == "video" && msg.Video != nil {
 					text = msg.Video.Caption
-					mediaURL, err = h.resolveMediaURL(msg.Video.ID, token, clog)
+					mediaURL, err = h.resolveMediaURL(channel, msg.Video.ID, token, clog)
 				} else if msg.Type == "location" && msg.Location != nil {
 					mediaURL = fmt.Sprintf("geo:%f,%f", msg.Location.Latitude, msg.Location.Longitude)
+					metadata["location"] = msg.Location
+				} else if msg.Type == "contacts" && len(msg.Contacts) > 0 {
+					text = whatsapp.GetContactsText(msg.Contacts)
+					metadata["contacts"] = msg.Contacts
 				} else if msg.Type == "interactive" && msg.Interactive.Type == "button_reply" {
 					text = msg.Interactive.ButtonReply.Title
 				} else if msg.Type == "interactive" && msg.Interactive.Type == "list_reply" {
 					text = msg.Interactive.ListReply.Title
+				} else if msg.Type == "interactive" && msg.Interactive.Type == "nfm_reply" {
+					text = msg.Interactive.NFMReply.Body
+
+					// responses from WhatsApp Flows include the submitted form data as a JSON string
+					response := json.RawMessage(msg.Interactive.NFMReply.ResponseJSON)
+					if !json.Valid(response) {
+						response = jsonx.MustMarshal(msg.Interactive.NFMReply.ResponseJSON)
+					}
+					metadata["flow_response"] = map[string]any{"name": msg.Interactive.NFMReply.Name, "response": response}
 				} else {
 					// we received a message type we do not support.
 					courier.LogRequestError(r, channel, fmt.Errorf("unsupported message type %s", msg.Type))
 					continue
 				}
 
+				if forwarded := msg.Forwarded(); forwarded != nil {
+					metadata["forwarded"] = forwarded
+				}
+
 				// create our message
-				event := h.Backend().NewIncomingMsg(channel, urn, text, msg.ID, clog).WithReceivedOn(date).WithContactName(contactNames[msg.From])
+				event := h.Backend().NewIncomingMsg(channel, urn, text, msg.ID, clog).WithReceivedOn(date).WithContactName(contactNames[contactID])
+				if isEcho {
+					event.WithEcho()
+				}
 
 				// we had an error downloading media
 				if err != nil {
@@ -339,6 +448,9 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 				if mediaURL != "" {
 					event.WithAttachment(mediaURL)
 				}
+				if len(metadata) > 0 {
+					event.WithMetadata(jsonx.MustMarshal(metadata))
+				}
 
 				err = h.Backend().WriteMsg(ctx, event, clog)
 				if err != nil {
@@ -387,6 +499,17 @@ func (h *handler) processWhatsAppPayload(ctx context.Context, channel courier.Ch
 	return events, data, nil
 }
 
+// checks whether the given WhatsApp ID is the channel's own number, which is displayed formatted, e.g. +250 788 123 200
+func isOwnNumber(waID, displayNumber string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, displayNumber)
+	return digits != "" && waID == digits
+}
+
 func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel courier.Channel, payload *Notifications, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, []any, error) {
 	var err error
 
@@ -400,20 +523,55 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 
 	// for each entry
 	for _, entry := range payload.Entry {
+		if payload.Object == "instagram" && len(entry.Changes) > 0 {
+			evts, dat, err := h.processInstagramComments(ctx, channel, entry.Changes, entry.Time, clog)
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, evts...)
+			data = append(data, dat...)
+		}
+
+		messaging := entry.Messaging
+		isRequest := false
+		if payload.Object == "instagram" && len(messaging) == 0 && len(entry.Standby) > 0 {
+			messaging = entry.Standby
+			isRequest = true
+		}
+
 		// no entry, ignore
-		if len(entry.Messaging) == 0 {
+		if len(messaging) == 0 {
 			continue
 		}
 
 		// grab our message, there is always a single one
-		msg := entry.Messaging[0]
+		msg := messaging[0]
 
 		// ignore this entry if it is to another page
 		if channel.Address() != msg.Recipient.ID {
 			continue
 		}
 
-		date := parseTimestamp(msg.Timestamp)
+		if isRequest {
+			// only new messages from the user can be accepted, anything else is someone else's conversation
+			if msg.Message == nil || msg.Message.IsEcho {
+				data = append(data, courier.NewInfoData("ignoring standby event"))
+				continue
+			}
+
+			if !channel.BoolConfigForKey(configAcceptMessageRequests, false) {
+				clog.Error(errorMessageRequest())
+				data = append(data, courier.NewInfoData("ignoring message request"))
+				continue
+			}
+
+			// if accepting fails we still want the message, the user will just not get replies until it is accepted
+			if err := h.takeThreadControl(ctx, channel, msg.Sender.ID, clog); err != nil {
+				clog.RawError(err)
+			}
+		}
+
+		date := handlers.ParseUnixTimestamp(msg.Timestamp, handlers.TimestampSecondsOrMillis)
 
 		sender := msg.Sender.UserRef
 		if sender == "" {
@@ -487,19 +645,13 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			}
 			event := h.Backend().NewChannelEvent(channel, eventType, urn, clog).WithOccurredOn(date)
 
-			// build our extra
-			extra := map[string]string{titleKey: msg.Postback.Title, payloadKey: msg.Postback.Payload}
-
-			// add in referral information if we have it
+			// build our extra, adding in referral information if we have it
+			extra := map[string]string{}
 			if eventType == courier.EventTypeReferral {
-				extra[referrerIDKey] = msg.Postback.Referral.Ref
-				extra[sourceKey] = msg.Postback.Referral.Source
-				extra[typeKey] = msg.Postback.Referral.Type
-
-				if msg.Postback.Referral.AdID != "" {
-					extra[adIDKey] = msg.Postback.Referral.AdID
-				}
+				extra = msg.Postback.Referral.Normalize().Extra()
 			}
+			extra[titleKey] = msg.Postback.Title
+			extra[payloadKey] = msg.Postback.Payload
 
 			event = event.WithExtra(extra)
 
@@ -515,19 +667,7 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			// this is an incoming referral
 			event := h.Backend().NewChannelEvent(channel, courier.EventTypeReferral, urn, clog).WithOccurredOn(date)
 
-			// build our extra
-			extra := map[string]string{sourceKey: msg.Referral.Source, typeKey: msg.Referral.Type}
-
-			// add referrer id if present
-			if msg.Referral.Ref != "" {
-				extra[referrerIDKey] = msg.Referral.Ref
-			}
-
-			// add ad id if present
-			if msg.Referral.AdID != "" {
-				extra[adIDKey] = msg.Referral.AdID
-			}
-			event = event.WithExtra(extra)
+			event = event.WithExtra(msg.Referral.Normalize().Extra())
 
 			err := h.Backend().WriteChannelEvent(ctx, event, clog)
 			if err != nil {
@@ -624,6 +764,55 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 	return events, data, nil
 }
 
+// creates comment events for comments on the Instagram account's media, which can then be replied to privately
+func (h *handler) processInstagramComments(ctx context.Context, channel courier.Channel, changes []whatsapp.Change, timestamp int64, clog *courier.ChannelLog) ([]courier.Event, []any, error) {
+	events := make([]courier.Event, 0, 1)
+	data := make([]any, 0, 1)
+
+	date := handlers.ParseUnixTimestamp(timestamp, handlers.TimestampSecondsOrMillis)
+
+	for _, change := range changes {
+		if change.Field != "comments" || change.Value.ID == "" || change.Value.From == nil {
+			data = append(data, courier.NewInfoData("ignoring unknown change type"))
+			continue
+		}
+
+		// ignore comments made by the account itself, e.g. replies to other comments
+		if change.Value.From.ID == channel.Address() {
+			data = append(data, courier.NewInfoData("ignoring own comment"))
+			continue
+		}
+
+		urn, err := urns.New(urns.Instagram, change.Value.From.ID)
+		if err != nil {
+			clog.Error(courier.ErrorExternal("invalid_id", fmt.Sprintf("invalid instagram id: %s", change.Value.From.ID)))
+			continue
+		}
+
+		extra := map[string]string{commentIDKey: change.Value.ID, textKey: change.Value.Text}
+		if change.Value.ParentID != "" {
+			extra[parentIDKey] = change.Value.ParentID
+		}
+		if change.Value.Media != nil {
+			extra[mediaIDKey] = change.Value.Media.ID
+		}
+		if change.Value.From.Username != "" {
+			extra[usernameKey] = change.Value.From.Username
+		}
+
+		event := h.Backend().NewChannelEvent(channel, courier.EventTypeComment, urn, clog).WithOccurredOn(date).WithExtra(extra)
+
+		if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+			return nil, nil, err
+		}
+
+		events = append(events, event)
+		data = append(data, courier.NewEventReceiveData(event))
+	}
+
+	return events, data, nil
+}
+
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 	if msg.Channel().ChannelType() == "FBA" || msg.Channel().ChannelType() == "IG" {
 		return h.sendFacebookInstagramMsg(ctx, msg, res, clog)
@@ -636,7 +825,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 	// can't do anything without an access token
-	accessToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	accessToken := h.pageAccessToken(ctx, msg.Channel())
 	if accessToken == "" {
 		return courier.ErrChannelConfig
 	}
@@ -653,6 +842,13 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 		payload.Recipient.ID = msg.URN().Path()
 	}
 
+	// Instagram messages can be sent as a private reply to a comment, but only the first part of the message
+	commentID, _ := jsonparser.GetString(msg.Metadata(), "comment_id")
+	if msg.Channel().ChannelType() == "IG" && commentID != "" {
+		payload.Recipient.ID = ""
+		payload.Recipient.CommentID = commentID
+	}
+
 	if msg.Topic() != "" || isHuman {
 		payload.MessagingType = "MESSAGE_TAG"
 
@@ -663,14 +859,14 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 			payload.Tag = "HUMAN_AGENT"
 		}
 	} else {
-		if msg.ResponseToExternalID() != "" {
+		if msg.ResponseToExternalID() != "" || payload.Recipient.CommentID != "" {
 			payload.MessagingType = "RESPONSE"
 		} else {
 			payload.MessagingType = "UPDATE"
 		}
 	}
 
-	msgURL, _ := url.Parse(sendURL)
+	msgURL := h.graphURL(msg.Channel(), "me/messages")
 	query := url.Values{}
 	query.Set("access_token", accessToken)
 	msgURL.RawQuery = query.Encode()
@@ -724,13 +920,18 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 		resp, respBody, err := h.RequestHTTP(req, clog)
 		if err != nil || resp.StatusCode/100 == 5 {
 			return courier.ErrConnectionFailed
-		} else if resp.StatusCode/100 != 2 {
-			return courier.ErrResponseStatus
 		}
 
 		respPayload := &messenger.SendResponse{}
 		err = json.Unmarshal(respBody, respPayload)
-		if err != nil {
+
+		if contactErr, found := messengerContactErrorSubcodes[respPayload.Error.ErrorSubcode]; found {
+			return contactErr
+		} else if contactErr, found := messengerContactErrorCodes[respPayload.Error.Code]; found {
+			return contactErr
+		} else if resp.StatusCode/100 != 2 {
+			return courier.ErrResponseStatus
+		} else if err != nil {
 			return courier.ErrResponseUnparseable
 		}
 
@@ -743,6 +944,13 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 		}
 
 		res.AddExternalID(respPayload.ExternalID)
+
+		// remaining parts are sent directly to the commenter
+		if payload.Recipient.CommentID != "" {
+			payload.Recipient.CommentID = ""
+			payload.Recipient.ID = msg.URN().Path()
+		}
+
 		if IsFacebookRef(msg.URN()) {
 			recipientID := respPayload.RecipientID
 			if recipientID == "" {
@@ -786,11 +994,9 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 
 func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 	// can't do anything without an access token
-	accessToken := h.Server().Config().WhatsappAdminSystemUserToken
+	accessToken := h.wacAccessToken(msg.Channel())
 
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s/messages", msg.Channel().Address()))
-	wacPhoneURL := base.ResolveReference(path)
+	wacPhoneURL := h.graphURL(msg.Channel(), fmt.Sprintf("%s/messages", msg.Channel().Address()))
 
 	hasCaption := false
 
@@ -801,12 +1007,33 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 	qrs := msg.QuickReplies()
 	menuButton := handlers.GetText("Menu", msg.Locale())
 
+	// contact cards can be sent via the message metadata
+	contacts, err := whatsapp.GetContactsPayload(msg.Metadata())
+	if err != nil {
+		return courier.ErrMessageInvalid
+	}
+
+	// as can WhatsApp Flows, which use the message text as their body
+	flow, err := whatsapp.GetFlowPayload(msg.Metadata())
+	if err != nil || (flow != nil && msg.Text() == "") {
+		return courier.ErrMessageInvalid
+	}
+
 	var payloadAudio whatsapp.SendRequest
 	// do we have a template?
 	if msg.Templating() != nil {
 		payload := whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path()}
 		payload.Type = "template"
-		payload.Template = whatsapp.GetTemplatePayload(msg.Templating())
+		payload.Template = whatsapp.GetTemplatePayload(msg.Templating(), msg.Locale())
+		err := h.requestWAC(payload, accessToken, res, wacPhoneURL, clog)
+		if err != nil {
+			return err
+		}
+
+	} else if flow != nil {
+		payload := whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path()}
+		payload.Type = "interactive"
+		payload.Interactive = whatsapp.GetFlowInteractive(flow, msg.Text())
 		err := h.requestWAC(payload, accessToken, res, wacPhoneURL, clog)
 		if err != nil {
 			return err
@@ -821,16 +1048,13 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 				// do we have a template?
 				if msg.Templating() != nil {
 					payload.Type = "template"
-					payload.Template = whatsapp.GetTemplatePayload(msg.Templating())
+					payload.Template = whatsapp.GetTemplatePayload(msg.Templating(), msg.Locale())
 
 				} else {
 					if i < (len(msgParts) + len(msg.Attachments()) - 1) {
 						// this is still a msg part
-						text := &whatsapp.Text{PreviewURL: false}
+						text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 						payload.Type = "text"
-						if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-							text.PreviewURL = true
-						}
 						text.Body = msgParts[i-len(msg.Attachments())]
 						payload.Text = text
 					} else {
@@ -857,11 +1081,7 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 									btns[i].Reply.ID = fmt.Sprint(i)
 									btns[i].Reply.Title = qr
 								}
-								interactive.Action = &struct {
-									Button   string             "json:\"button,omitempty\""
-									Sections []whatsapp.Section "json:\"sections,omitempty\""
-									Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-								}{Buttons: btns}
+								interactive.Action = &whatsapp.Action{Buttons: btns}
 								payload.Interactive = &interactive
 							} else {
 								interactive := whatsapp.Interactive{Type: "list", Body: struct {
@@ -878,11 +1098,7 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 									}
 								}
 
-								interactive.Action = &struct {
-									Button   string             "json:\"button,omitempty\""
-									Sections []whatsapp.Section "json:\"sections,omitempty\""
-									Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-								}{Button: menuButton, Sections: []whatsapp.Section{
+								interactive.Action = &whatsapp.Action{Button: menuButton, Sections: []whatsapp.Section{
 									section,
 								}}
 
@@ -890,11 +1106,8 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 							}
 						} else {
 							// this is still a msg part
-							text := &whatsapp.Text{PreviewURL: false}
+							text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 							payload.Type = "text"
-							if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-								text.PreviewURL = true
-							}
 							text.Body = msgParts[i-len(msg.Attachments())]
 							payload.Text = text
 						}
@@ -902,15 +1115,15 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 				}
 
 			} else if i < len(msg.Attachments()) && (len(qrs) == 0 || len(qrs) > 3) {
-				attType, attURL := handlers.SplitAttachment(msg.Attachments()[i])
+				attType, attURL := handlers.ResolveAttachmentURL(ctx, h.Backend(), msg.Attachments()[i], wacMediaSupport, clog)
 				attType = strings.Split(attType, "/")[0]
 				if attType == "application" {
 					attType = "document"
 				}
 				payload.Type = attType
-				media := whatsapp.Media{Link: attURL}
+				media := h.wacMedia(msg, attURL, accessToken, clog)
 
-				if len(msgParts) == 1 && attType != "audio" && len(msg.Attachments()) == 1 && len(msg.QuickReplies()) == 0 {
+				if len(msgParts) == 1 && utf8.RuneCountInString(msgParts[0]) <= maxCaptionLength && attType != "audio" && len(msg.Attachments()) == 1 && len(msg.QuickReplies()) == 0 {
 					media.Caption = msgParts[i]
 					hasCaption = true
 				}
@@ -949,15 +1162,13 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 
 						if len(msg.Attachments()) > 0 {
 							hasCaption = true
-							attType, attURL := handlers.SplitAttachment(msg.Attachments()[i])
+							attType, attURL := handlers.ResolveAttachmentURL(ctx, h.Backend(), msg.Attachments()[i], wacMediaSupport, clog)
 							attType = strings.Split(attType, "/")[0]
 							if attType == "application" {
 								attType = "document"
 							}
 							if attType == "image" {
-								image := whatsapp.Media{
-									Link: attURL,
-								}
+								image := h.wacMedia(msg, attURL, accessToken, clog)
 								interactive.Header = &struct {
 									Type     string          "json:\"type\""
 									Text     string          "json:\"text,omitempty\""
@@ -966,9 +1177,7 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 									Document *whatsapp.Media "json:\"document,omitempty\""
 								}{Type: "image", Image: &image}
 							} else if attType == "video" {
-								video := whatsapp.Media{
-									Link: attURL,
-								}
+								video := h.wacMedia(msg, attURL, accessToken, clog)
 								interactive.Header = &struct {
 									Type     string          "json:\"type\""
 									Text     string          "json:\"text,omitempty\""
@@ -981,10 +1190,8 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 								if err != nil {
 									return err
 								}
-								document := whatsapp.Media{
-									Link:     attURL,
-									Filename: filename,
-								}
+								document := h.wacMedia(msg, attURL, accessToken, clog)
+								document.Filename = filename
 								interactive.Header = &struct {
 									Type     string          "json:\"type\""
 									Text     string          "json:\"text,omitempty\""
@@ -994,7 +1201,8 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 								}{Type: "document", Document: &document}
 							} else if attType == "audio" {
 
-								payloadAudio = whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path(), Type: "audio", Audio: &whatsapp.Media{Link: attURL}}
+								audio := h.wacMedia(msg, attURL, accessToken, clog)
+								payloadAudio = whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path(), Type: "audio", Audio: &audio}
 								err := h.requestWAC(payloadAudio, accessToken, res, wacPhoneURL, clog)
 								if err != nil {
 									return err
@@ -1013,11 +1221,7 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 							btns[i].Reply.ID = fmt.Sprint(i)
 							btns[i].Reply.Title = qr
 						}
-						interactive.Action = &struct {
-							Button   string             "json:\"button,omitempty\""
-							Sections []whatsapp.Section "json:\"sections,omitempty\""
-							Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-						}{Buttons: btns}
+						interactive.Action = &whatsapp.Action{Buttons: btns}
 						payload.Interactive = &interactive
 
 					} else {
@@ -1035,11 +1239,7 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 							}
 						}
 
-						interactive.Action = &struct {
-							Button   string             "json:\"button,omitempty\""
-							Sections []whatsapp.Section "json:\"sections,omitempty\""
-							Buttons  []whatsapp.Button  "json:\"buttons,omitempty\""
-						}{Button: menuButton, Sections: []whatsapp.Section{
+						interactive.Action = &whatsapp.Action{Button: menuButton, Sections: []whatsapp.Section{
 							section,
 						}}
 
@@ -1047,11 +1247,8 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 					}
 				} else {
 					// this is still a msg part
-					text := &whatsapp.Text{PreviewURL: false}
+					text := &whatsapp.Text{PreviewURL: handlers.PreviewURLs(msg, msgParts[i-len(msg.Attachments())])}
 					payload.Type = "text"
-					if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-						text.PreviewURL = true
-					}
 					text.Body = msgParts[i-len(msg.Attachments())]
 					payload.Text = text
 				}
@@ -1067,6 +1264,14 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 			}
 		}
 	}
+
+	// contact cards are sent after any text and attachments
+	if len(contacts) > 0 {
+		payload := whatsapp.SendRequest{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path(), Type: "contacts", Contacts: contacts}
+		if err := h.requestWAC(payload, accessToken, res, wacPhoneURL, clog); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1101,6 +1306,10 @@ func (h *handler) requestWAC(payload whatsapp.SendRequest, accessToken string, r
 		return courier.ErrFailedWithReason(strconv.Itoa(respPayload.Error.Code), respPayload.Error.Message)
 	}
 
+	if len(respPayload.Messages) == 0 {
+		return courier.ErrResponseUnexpected
+	}
+
 	externalID := respPayload.Messages[0].ID
 	if externalID != "" {
 		res.AddExternalID(externalID)
@@ -1119,15 +1328,13 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 		return map[string]string{}, nil
 	}
 
-	accessToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	accessToken := h.pageAccessToken(ctx, channel)
 	if accessToken == "" {
 		return nil, fmt.Errorf("missing access token")
 	}
 
 	// build a request to lookup the stats for this contact
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s", urn.Path()))
-	u := base.ResolveReference(path)
+	u := h.graphURL(channel, urn.Path())
 	query := url.Values{}
 	var name string
 
@@ -1157,6 +1364,58 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 
 }
 
+// CheckQuality fetches the quality rating and messaging limit tier of a WhatsApp phone number
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/reference/phone-numbers
+func (h *handler) CheckQuality(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (*courier.ChannelQuality, error) {
+	if channel.ChannelType() != "WAC" {
+		return nil, nil
+	}
+
+	u := h.graphURL(channel, channel.Address())
+	u.RawQuery = url.Values{"fields": []string{"quality_rating,messaging_limit_tier"}}.Encode()
+
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.wacAccessToken(channel)))
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return nil, errors.New("unable to fetch phone number quality")
+	}
+
+	rating, err := jsonparser.GetString(respBody, "quality_rating")
+	if err != nil {
+		clog.Error(courier.ErrorResponseValueMissing("quality_rating"))
+		return nil, errors.New("unable to fetch phone number quality")
+	}
+	tier, _ := jsonparser.GetString(respBody, "messaging_limit_tier")
+
+	return &courier.ChannelQuality{Rating: rating, Tier: tier, CheckedOn: time.Now().UTC()}, nil
+}
+
+// MarkRead marks the incoming WhatsApp message with the given external ID as read
+func (h *handler) MarkRead(ctx context.Context, channel courier.Channel, externalID string, clog *courier.ChannelLog) error {
+	if channel.ChannelType() != "WAC" {
+		return nil
+	}
+
+	u := h.graphURL(channel, fmt.Sprintf("%s/messages", channel.Address()))
+
+	payload := &whatsapp.MarkReadRequest{MessagingProduct: "whatsapp", Status: "read", MessageID: externalID}
+
+	req, _ := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(jsonx.MustMarshal(payload)))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.wacAccessToken(channel)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, _, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return errors.New("unable to mark message as read")
+	}
+	return nil
+}
+
+var _ courier.ReadMarker = (*handler)(nil)
+
 // see https://developers.facebook.com/docs/messenger-platform/webhook#security
 func (h *handler) validateSignature(r *http.Request) error {
 	headerSignature := r.Header.Get(signatureHeader)
@@ -1201,7 +1460,7 @@ func fbCalculateSignature(appSecret string, body []byte) (string, error) {
 
 // BuildAttachmentRequest to download media for message attachment with Bearer token set
 func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend, channel courier.Channel, attachmentURL string, clog *courier.ChannelLog) (*http.Request, error) {
-	token := h.Server().Config().WhatsappAdminSystemUserToken
+	token := h.wacAccessToken(channel)
 	if token == "" {
 		return nil, fmt.Errorf("missing token for WAC channel")
 	}
@@ -1216,11 +1475,3 @@ func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend,
 }
 
 var _ courier.AttachmentRequestBuilder = (*handler)(nil)
-
-func parseTimestamp(ts int64) time.Time {
-	// sometimes Facebook sends timestamps in seconds rather than milliseconds
-	if ts >= 1_000_000_000_000 {
-		return time.Unix(0, ts*1000000).UTC()
-	}
-	return time.Unix(ts, 0).UTC()
-}
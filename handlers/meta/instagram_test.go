@@ -2,6 +2,7 @@ package meta
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
@@ -92,6 +94,25 @@ var instagramIncomingTests = []IncomingTestCase{
 		},
 		PrepRequest: addValidSignature,
 	},
+	{
+		Label:                "Receive Comment",
+		URL:                  "/c/ig/receive",
+		Data:                 string(test.ReadFile("./testdata/ig/comment.json")),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Handled",
+		ExpectedEvents: []ExpectedEvent{
+			{Type: courier.EventTypeComment, URN: "instagram:5678", Time: time.Date(2016, 4, 7, 1, 11, 27, 0, time.UTC), Extra: map[string]string{"comment_id": "17865799348089039", "text": "How much is this?", "media_id": "17887498072083520", "username": "bob"}},
+		},
+		PrepRequest: addValidSignature,
+	},
+	{
+		Label:                "Own Comment",
+		URL:                  "/c/ig/receive",
+		Data:                 string(test.ReadFile("./testdata/ig/own_comment.json")),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring own comment",
+		PrepRequest:          addValidSignature,
+	},
 	{
 		Label:                "Different Page",
 		URL:                  "/c/ig/receive",
@@ -178,11 +199,86 @@ var instagramIncomingTests = []IncomingTestCase{
 }
 
 func TestInstagramIncoming(t *testing.T) {
-	graphURL = createMockGraphAPI().URL
+	mockGraphURL(t, createMockGraphAPI().URL)
 
 	RunIncomingTestCases(t, instgramTestChannels, newHandler("IG", "Instagram"), instagramIncomingTests)
 }
 
+func TestInstagramMessageRequests(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	// by default message requests are ignored
+	RunIncomingTestCases(t, instgramTestChannels, newHandler("IG", "Instagram"), []IncomingTestCase{
+		{
+			Label:                 "Ignore Message Request",
+			NoQueueErrorCheck:     true,
+			NoInvalidChannelCheck: true,
+			URL:                   "/c/ig/receive",
+			Data:                  string(test.ReadFile("./testdata/ig/message_request.json")),
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "ignoring message request",
+			ExpectedErrors:        []*clogs.LogError{errorMessageRequest()},
+			PrepRequest:           addValidSignature,
+		},
+	})
+
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://graph.facebook.com/v23.0/me/take_thread_control?access_token=a123": {
+			httpx.NewMockResponse(200, nil, []byte(`{"success": true}`)),
+			httpx.NewMockResponse(400, nil, []byte(`{"error": {"message": "Invalid recipient"}}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	acceptingChannels := []courier.Channel{
+		test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "IG", "12345", "", []string{urns.Instagram.Prefix}, map[string]any{courier.ConfigAuthToken: "a123", configAcceptMessageRequests: true}),
+	}
+
+	RunIncomingTestCases(t, acceptingChannels, newHandler("IG", "Instagram"), []IncomingTestCase{
+		{
+			Label:                 "Accept Message Request",
+			URL:                   "/c/ig/receive",
+			Data:                  string(test.ReadFile("./testdata/ig/message_request.json")),
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "Handled",
+			NoQueueErrorCheck:     true,
+			NoInvalidChannelCheck: true,
+			ExpectedMsgText:       Sp("Hi there"),
+			ExpectedURN:           "instagram:5678",
+			ExpectedExternalID:    "external_id",
+			ExpectedDate:          time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC),
+			PrepRequest:           addValidSignature,
+		},
+		{
+			Label:                 "Accept Message Request Fails",
+			NoQueueErrorCheck:     true,
+			NoInvalidChannelCheck: true,
+			URL:                   "/c/ig/receive",
+			Data:                  string(test.ReadFile("./testdata/ig/message_request.json")),
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "Handled",
+			ExpectedMsgText:       Sp("Hi there"),
+			ExpectedURN:           "instagram:5678",
+			ExpectedExternalID:    "external_id",
+			ExpectedDate:          time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC),
+			ExpectedErrors:        []*clogs.LogError{clogs.NewLogError("", "", "unable to take thread control")},
+			PrepRequest:           addValidSignature,
+		},
+		{
+			Label:                 "Ignore Standby Echo",
+			NoQueueErrorCheck:     true,
+			NoInvalidChannelCheck: true,
+			URL:                   "/c/ig/receive",
+			Data:                  string(test.ReadFile("./testdata/ig/standby_echo.json")),
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "ignoring standby event",
+			PrepRequest:           addValidSignature,
+		},
+	})
+
+	assert.False(t, mocks.HasUnused())
+}
+
 var instagramOutgoingTests = []OutgoingTestCase{
 	{
 		Label:     "Text only chat message",
@@ -190,7 +286,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgURN:    "instagram:12345",
 		MsgOrigin: courier.MsgOriginChat,
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -206,7 +302,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgURN:    "instagram:12345",
 		MsgOrigin: courier.MsgOriginBroadcast,
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -223,7 +319,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:               courier.MsgOriginFlow,
 		MsgResponseToExternalID: "23526",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -240,7 +336,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgOrigin:       courier.MsgOriginBroadcast,
 		MsgQuickReplies: []string{"Yes", "No"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -257,7 +353,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgQuickReplies: []string{"Yes", "No"},
 		MsgTopic:        "account",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
@@ -279,7 +375,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgURN:         "instagram:12345",
 		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -297,7 +393,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgQuickReplies: []string{"Yes", "No"},
 		MsgTopic:        "event",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
@@ -320,7 +416,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgURN:   "instagram:12345",
 		MsgTopic: "agent",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -335,7 +431,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgURN:         "instagram:12345",
 		MsgAttachments: []string{"application/pdf:https://foo.bar/document.pdf"},
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
 			},
 		},
@@ -345,12 +441,36 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		}},
 		ExpectedExtIDs: []string{"mid.133"},
 	},
+	{
+		Label:          "Private reply to comment",
+		MsgText:        "Thanks for your comment, it costs $10",
+		MsgURN:         "instagram:12345",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MsgMetadata:    `{"comment_id": "17865799348089039"}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://graph.facebook.com/v23.0/me/messages*": {
+				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.133"}`)),
+				httpx.NewMockResponse(200, nil, []byte(`{"message_id": "mid.134"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Params: url.Values{"access_token": {"a123"}},
+				Body:   `{"messaging_type":"RESPONSE","recipient":{"comment_id":"17865799348089039"},"message":{"attachment":{"type":"image","payload":{"url":"https://foo.bar/image.jpg","is_reusable":true}}}}`,
+			},
+			{
+				Params: url.Values{"access_token": {"a123"}},
+				Body:   `{"messaging_type":"RESPONSE","recipient":{"id":"12345"},"message":{"text":"Thanks for your comment, it costs $10"}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"mid.133", "mid.134"},
+	},
 	{
 		Label:   "Response doesn't contain message id",
 		MsgText: "ID Error",
 		MsgURN:  "instagram:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{ "is_error": true }`)),
 			},
 		},
@@ -361,7 +481,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgText: "Error",
 		MsgURN:  "instagram:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(403, nil, []byte(`{ "is_error": true }`)),
 			},
 		},
@@ -372,7 +492,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgText: "Error",
 		MsgURN:  "instagram:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`bad json`)),
 			},
 		},
@@ -383,7 +503,7 @@ var instagramOutgoingTests = []OutgoingTestCase{
 		MsgText: "Error",
 		MsgURN:  "instagram:12345",
 		MockResponses: map[string][]*httpx.MockResponse{
-			"https://graph.facebook.com/v18.0/me/messages*": {
+			"https://graph.facebook.com/v23.0/me/messages*": {
 				httpx.NewMockResponse(200, nil, []byte(`{ "error": {"message": "The image size is too large.","code": 36000 }}`)),
 			},
 		},
@@ -402,6 +522,34 @@ func TestInstagramOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, channel, newHandler("IG", "Instagram"), instagramOutgoingTests, checkRedacted, nil)
 }
 
+func TestInstagramConfigureProfile(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	handler := newHandler("IG", "Instagram")
+	handler.Initialize(courier.NewServer(courier.NewDefaultConfig(), test.NewMockBackend()))
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"*/me/messenger_profile?access_token=a123&platform=instagram": {
+			httpx.NewMockResponse(200, nil, []byte(`{"result": "success"}`)),
+		},
+	})
+	httpx.SetRequestor(mockHTTP)
+
+	channel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "IG", "12345", "", []string{urns.Instagram.Prefix}, map[string]any{
+		courier.ConfigAuthToken: "a123",
+		configGreeting:          "Welcome to Acme!",
+		configIceBreakers:       []any{map[string]any{"question": "Where are you?", "payload": "location"}},
+	})
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+	err := handler.(courier.ProfileConfigurer).ConfigureProfile(context.Background(), channel, clog)
+	assert.NoError(t, err)
+
+	// greetings aren't supported by Instagram
+	body, _ := io.ReadAll(mockHTTP.Requests()[0].Body)
+	assert.JSONEq(t, `{"ice_breakers": [{"locale": "default", "call_to_actions": [{"question": "Where are you?", "payload": "location"}]}]}`, string(body))
+}
+
 func TestInstgramVerify(t *testing.T) {
 	RunIncomingTestCases(t, instgramTestChannels, newHandler("IG", "Instagram"), []IncomingTestCase{
 		{
@@ -447,6 +595,7 @@ func TestInstgramVerify(t *testing.T) {
 func TestInstagramDescribeURN(t *testing.T) {
 	fbGraph := buildMockFBGraphIG(instagramIncomingTests)
 	defer fbGraph.Close()
+	mockGraphURL(t, fbGraph.URL)
 
 	channel := instgramTestChannels[0]
 	handler := newHandler("IG", "Instagram")
@@ -473,7 +622,7 @@ func TestInstagramBuildAttachmentRequest(t *testing.T) {
 	mb := test.NewMockBackend()
 	s := courier.NewServer(courier.NewDefaultConfig(), mb)
 
-	handler := &handler{NewBaseHandler(courier.ChannelType("IG"), "Instagram", DisableUUIDRouting())}
+	handler := &handler{BaseHandler: NewBaseHandler(courier.ChannelType("IG"), "Instagram", DisableUUIDRouting())}
 	handler.Initialize(s)
 	req, _ := handler.BuildAttachmentRequest(context.Background(), mb, facebookTestChannels[0], "https://example.org/v1/media/41", nil)
 	assert.Equal(t, "https://example.org/v1/media/41", req.URL.String())
@@ -500,7 +649,6 @@ func buildMockFBGraphIG(testCases []IncomingTestCase) *httptest.Server {
 		// no name
 		w.Write([]byte(`{ "name": ""}`))
 	}))
-	graphURL = server.URL
 
 	return server
 }
@@ -0,0 +1,135 @@
+package meta
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/handlers/meta/whatsapp"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/redisx"
+	"github.com/patrickmn/go-cache"
+)
+
+// media uploaded to WhatsApp Cloud is kept for 30 days so we can safely reuse IDs for 6-7 days
+func wacMediaCache() *redisx.IntervalHash {
+	return redisx.NewIntervalHash(valkey.Tag("wac-media"), time.Hour*24, 7)
+}
+
+// attachments which we failed to upload aren't retried for a while, and are sent by link instead
+var failedMediaCache = cache.New(15*time.Minute, 15*time.Minute)
+
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/reference/media#supported-media-types, where OGG must be
+// OPUS which is what we transcode to and is listed first because it's sent as a voice note
+var wacMediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
+	handlers.MediaTypeImage: {Types: []string{"image/jpeg", "image/png"}, MaxBytes: 5 * 1024 * 1024},
+	handlers.MediaTypeAudio: {Types: []string{"audio/ogg", "audio/aac", "audio/amr", "audio/mpeg", "audio/mp4"}, MaxBytes: 16 * 1024 * 1024},
+}
+
+// returns the media object to send for the given attachment URL, which uses the ID of uploaded media if possible and
+// otherwise falls back to the link
+func (h *handler) wacMedia(msg courier.MsgOut, attURL string, accessToken string, clog *courier.ChannelLog) whatsapp.Media {
+	mediaID, err := h.fetchWACMediaID(msg.Channel(), attURL, accessToken, clog)
+	if err != nil {
+		slog.Error("error uploading media to whatsapp", "error", err, "channel_uuid", msg.Channel().UUID())
+	}
+	if mediaID != "" {
+		return whatsapp.Media{ID: mediaID}
+	}
+	return whatsapp.Media{Link: attURL}
+}
+
+// fetchWACMediaID returns the ID of the given attachment URL, uploading it to WhatsApp if we haven't done so already
+// for this channel, see https://developers.facebook.com/docs/whatsapp/cloud-api/reference/media#upload-media
+func (h *handler) fetchWACMediaID(channel courier.Channel, mediaURL string, accessToken string, clog *courier.ChannelLog) (string, error) {
+	cacheField := fmt.Sprintf("%s:%s", channel.UUID(), mediaURL)
+
+	var mediaID string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		mediaID, err = wacMediaCache().Get(rc, cacheField)
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error reading media id from redis: %s: %w", mediaURL, err)
+	} else if mediaID != "" {
+		return mediaID, nil
+	}
+
+	// previews can use already uploaded media but shouldn't upload anything
+	if clog.Type == courier.ChannelLogTypeMsgPreview {
+		return "", nil
+	}
+
+	// any non nil value means we cached a failure, don't try again until our cache expires
+	if found, _ := failedMediaCache.Get(cacheField); found != nil {
+		return "", nil
+	}
+
+	// download the media
+	req, _ := http.NewRequest(http.MethodGet, mediaURL, nil)
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		failedMediaCache.Set(cacheField, true, cache.DefaultExpiration)
+		return "", fmt.Errorf("error downloading media: %s", mediaURL)
+	}
+
+	filename, _ := utils.BasePathForURL(mediaURL)
+
+	// use the content type of the download if it's there, otherwise try to detect it
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/octet-stream" {
+		mediaType, _ = httpx.DetectContentType(respBody)
+	}
+
+	// and upload it to WhatsApp
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("messaging_product", "whatsapp")
+	writer.WriteField("type", mediaType)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	header.Set("Content-Type", mediaType)
+	part, _ := writer.CreatePart(header)
+	part.Write(respBody)
+	writer.Close()
+
+	uploadURL := h.graphURL(channel, fmt.Sprintf("%s/media", channel.Address()))
+
+	req, _ = http.NewRequest(http.MethodPost, uploadURL.String(), body)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, respBody, err = h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		failedMediaCache.Set(cacheField, true, cache.DefaultExpiration)
+		return "", fmt.Errorf("error uploading media to whatsapp: %s", mediaURL)
+	}
+
+	mediaID, err = jsonparser.GetString(respBody, "id")
+	if err != nil {
+		return "", fmt.Errorf("error reading media id from response: %w", err)
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		err = wacMediaCache().Set(rc, cacheField, mediaID)
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error setting media id in cache: %w", err)
+	}
+
+	return mediaID, nil
+}
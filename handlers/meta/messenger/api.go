@@ -1,5 +1,36 @@
 package messenger
 
+import "github.com/nyaruka/courier/handlers"
+
+// see https://developers.facebook.com/docs/messenger-platform/reference/webhook-events/messaging_referrals
+type Referral struct {
+	Ref            string `json:"ref"`
+	Source         string `json:"source"`
+	Type           string `json:"type"`
+	AdID           string `json:"ad_id"`
+	AdsContextData *struct {
+		AdTitle  string `json:"ad_title"`
+		PhotoURL string `json:"photo_url"`
+		VideoURL string `json:"video_url"`
+		PostID   string `json:"post_id"`
+	} `json:"ads_context_data"`
+}
+
+// Normalize converts this to our normalized referral
+func (r *Referral) Normalize() *handlers.Referral {
+	n := &handlers.Referral{Source: r.Source, Type: r.Type, Ref: r.Ref, AdID: r.AdID}
+
+	if c := r.AdsContextData; c != nil {
+		n.Headline = c.AdTitle
+		if c.VideoURL != "" {
+			n.MediaType, n.MediaURL = "video", c.VideoURL
+		} else if c.PhotoURL != "" {
+			n.MediaType, n.MediaURL = "image", c.PhotoURL
+		}
+	}
+	return n
+}
+
 //	{
 //	  "messaging_type": "<MESSAGING_TYPE>"
 //	  "recipient": {
@@ -23,6 +54,7 @@ type SendRequest struct {
 		UserRef                   string `json:"user_ref,omitempty"`
 		ID                        string `json:"id,omitempty"`
 		NotificationMessagesToken string `json:"notification_messages_token,omitempty"`
+		CommentID                 string `json:"comment_id,omitempty"`
 	} `json:"recipient"`
 	Message struct {
 		Text         string       `json:"text,omitempty"`
@@ -53,11 +85,20 @@ type SendResponse struct {
 	ExternalID  string `json:"message_id"`
 	RecipientID string `json:"recipient_id"`
 	Error       struct {
-		Message string `json:"message"`
-		Code    int    `json:"code"`
+		Message      string `json:"message"`
+		Code         int    `json:"code"`
+		ErrorSubcode int    `json:"error_subcode"`
 	} `json:"error"`
 }
 
+// see https://developers.facebook.com/docs/messenger-platform/handover-protocol/conversation-control
+type ThreadControlRequest struct {
+	Recipient struct {
+		ID string `json:"id"`
+	} `json:"recipient"`
+	Metadata string `json:"metadata,omitempty"`
+}
+
 // see https://developers.facebook.com/docs/messenger-platform/webhooks/#event-notifications
 type Messaging struct {
 	Sender *struct {
@@ -84,23 +125,13 @@ type Messaging struct {
 		UserRef string `json:"user_ref"`
 	} `json:"optin"`
 
-	Referral *struct {
-		Ref    string `json:"ref"`
-		Source string `json:"source"`
-		Type   string `json:"type"`
-		AdID   string `json:"ad_id"`
-	} `json:"referral"`
+	Referral *Referral `json:"referral"`
 
 	Postback *struct {
-		MID      string `json:"mid"`
-		Title    string `json:"title"`
-		Payload  string `json:"payload"`
-		Referral struct {
-			Ref    string `json:"ref"`
-			Source string `json:"source"`
-			Type   string `json:"type"`
-			AdID   string `json:"ad_id"`
-		} `json:"referral"`
+		MID      string   `json:"mid"`
+		Title    string   `json:"title"`
+		Payload  string   `json:"payload"`
+		Referral Referral `json:"referral"`
 	} `json:"postback"`
 
 	Message *struct {
@@ -126,3 +157,38 @@ type Messaging struct {
 		Watermark int64    `json:"watermark"`
 	} `json:"delivery"`
 }
+
+// see https://developers.facebook.com/docs/messenger-platform/reference/messenger-profile-api
+type ProfileRequest struct {
+	Greeting       []Greeting       `json:"greeting,omitempty"`
+	PersistentMenu []PersistentMenu `json:"persistent_menu,omitempty" validate:"dive"`
+	IceBreakers    []IceBreakers    `json:"ice_breakers,omitempty"    validate:"dive"`
+}
+
+type Greeting struct {
+	Locale string `json:"locale"`
+	Text   string `json:"text"`
+}
+
+type PersistentMenu struct {
+	Locale                string     `json:"locale"`
+	ComposerInputDisabled bool       `json:"composer_input_disabled"`
+	CallToActions         []MenuItem `json:"call_to_actions" validate:"dive"`
+}
+
+type MenuItem struct {
+	Type    string `json:"type"              validate:"required,oneof=postback web_url"`
+	Title   string `json:"title"             validate:"required"`
+	Payload string `json:"payload,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+type IceBreakers struct {
+	Locale        string       `json:"locale"`
+	CallToActions []IceBreaker `json:"call_to_actions" validate:"dive"`
+}
+
+type IceBreaker struct {
+	Question string `json:"question" validate:"required"`
+	Payload  string `json:"payload"  validate:"required"`
+}
@@ -0,0 +1,124 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers/meta/messenger"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// channel config keys which make up the profile of a Facebook or Instagram channel, e.g.
+//
+//	"greeting": "Welcome to Acme!",
+//	"persistent_menu": [{"type": "postback", "title": "Talk to us", "payload": "talk"}, {"type": "web_url", "title": "Shop", "url": "https://acme.com"}],
+//	"ice_breakers": [{"question": "Where are you?", "payload": "location"}]
+const (
+	configGreeting       = "greeting"
+	configPersistentMenu = "persistent_menu"
+	configIceBreakers    = "ice_breakers"
+)
+
+// ConfigureProfile sets the greeting, persistent menu and ice breakers of the page or Instagram account from the
+// channel's config. Instagram accounts don't support greetings so that is only set for Facebook pages.
+func (h *handler) ConfigureProfile(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) error {
+	if channel.ChannelType() == "WAC" {
+		return errors.New("profile configuration not supported for WhatsApp channels")
+	}
+
+	accessToken := h.pageAccessToken(ctx, channel)
+	if accessToken == "" {
+		return errors.New("missing access token")
+	}
+
+	profile, err := profileFromConfig(channel)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"access_token": []string{accessToken}}
+	if channel.ChannelType() == "IG" {
+		query.Set("platform", "instagram")
+	}
+
+	u := h.graphURL(channel, "me/messenger_profile")
+	u.RawQuery = query.Encode()
+
+	req, _ := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(jsonx.MustMarshal(profile)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		if code, err := jsonparser.GetInt(respBody, "error", "code"); err == nil {
+			message, _ := jsonparser.GetString(respBody, "error", "message")
+			clog.Error(courier.ErrorExternal(strconv.Itoa(int(code)), message))
+		}
+		return errors.New("unable to update messenger profile")
+	}
+
+	if result, _ := jsonparser.GetString(respBody, "result"); result != "success" {
+		clog.Error(courier.ErrorResponseUnexpected("success"))
+		return errors.New("unable to update messenger profile")
+	}
+
+	return nil
+}
+
+// builds the messenger profile request from the channel's config
+func profileFromConfig(channel courier.Channel) (*messenger.ProfileRequest, error) {
+	profile := &messenger.ProfileRequest{}
+
+	if greeting := channel.StringConfigForKey(configGreeting, ""); greeting != "" && channel.ChannelType() != "IG" {
+		profile.Greeting = []messenger.Greeting{{Locale: "default", Text: greeting}}
+	}
+
+	var menuItems []messenger.MenuItem
+	if err := configAsJSON(channel, configPersistentMenu, &menuItems); err != nil {
+		return nil, err
+	}
+	if len(menuItems) > 0 {
+		profile.PersistentMenu = []messenger.PersistentMenu{{Locale: "default", CallToActions: menuItems}}
+	}
+
+	var iceBreakers []messenger.IceBreaker
+	if err := configAsJSON(channel, configIceBreakers, &iceBreakers); err != nil {
+		return nil, err
+	}
+	if len(iceBreakers) > 0 {
+		profile.IceBreakers = []messenger.IceBreakers{{Locale: "default", CallToActions: iceBreakers}}
+	}
+
+	if profile.Greeting == nil && profile.PersistentMenu == nil && profile.IceBreakers == nil {
+		return nil, errors.New("channel has no profile config")
+	}
+
+	if err := utils.Validate(profile); err != nil {
+		return nil, fmt.Errorf("invalid profile config: %w", err)
+	}
+
+	return profile, nil
+}
+
+// reads the config value with the given key into v, which is left unchanged if the key isn't set
+func configAsJSON(channel courier.Channel, key string, v any) error {
+	value := channel.ConfigForKey(key, nil)
+	if value == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(jsonx.MustMarshal(value), v); err != nil {
+		return fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	return nil
+}
+
+var _ courier.ProfileConfigurer = (*handler)(nil)
@@ -0,0 +1,48 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers/meta/messenger"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// Instagram messages from users who don't follow the account land in its message requests folder and are delivered to
+// the standby channel rather than as regular messages. If enabled, we accept these requests by taking control of the
+// conversation so that the message can be handled and replied to like any other.
+const configAcceptMessageRequests = "accept_message_requests"
+
+func errorMessageRequest() *clogs.LogError {
+	return clogs.NewLogError("message_request", "", "Message request ignored. Enable accepting message requests to receive messages from new users.")
+}
+
+// takeThreadControl makes us the owner of the conversation with the given user which accepts any message request
+func (h *handler) takeThreadControl(ctx context.Context, channel courier.Channel, userID string, clog *courier.ChannelLog) error {
+	accessToken := h.pageAccessToken(ctx, channel)
+	if accessToken == "" {
+		return errors.New("missing access token")
+	}
+
+	payload := &messenger.ThreadControlRequest{}
+	payload.Recipient.ID = userID
+	payload.Metadata = "accepting message request"
+
+	reqURL := h.graphURL(channel, "me/take_thread_control")
+	reqURL.RawQuery = url.Values{"access_token": []string{accessToken}}.Encode()
+
+	req, _ := http.NewRequest(http.MethodPost, reqURL.String(), bytes.NewReader(jsonx.MustMarshal(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, _, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return errors.New("unable to take thread control")
+	}
+	return nil
+}
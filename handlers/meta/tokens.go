@@ -0,0 +1,131 @@
+package meta
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	// when the channel's page access token expires, tokens without an expiry are never refreshed
+	configTokenExpiresOn = "token_expires_on"
+
+	// how long before expiry we try to exchange a token for a new one
+	tokenRefreshWindow = time.Hour * 24 * 7
+
+	// how long a refreshed token is cached whilst stale copies of the channel may still be in use
+	tokenRefreshCacheTTL = time.Hour
+)
+
+// returns the page access token for the given Facebook or Instagram channel, exchanging it for a new long-lived token
+// first if it's due to expire soon and our backend can save it. If the refresh fails, the existing token is returned as
+// it may still be valid.
+func (h *handler) pageAccessToken(ctx context.Context, channel courier.Channel) string {
+	token := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if token == "" || h.Server().Config().FacebookApplicationID == "" {
+		return token
+	}
+
+	updater, canUpdate := courier.BackendAs[courier.ChannelConfigUpdater](h.Backend())
+	if !canUpdate {
+		return token
+	}
+
+	expiresOn, err := time.Parse(time.RFC3339, channel.StringConfigForKey(configTokenExpiresOn, ""))
+	if err != nil || time.Until(expiresOn) > tokenRefreshWindow {
+		return token
+	}
+
+	h.refreshTokenMutex.Lock()
+	defer h.refreshTokenMutex.Unlock()
+
+	log := slog.With("comp", "meta", "channel_uuid", channel.UUID())
+	cacheKey := fmt.Sprintf("channel-token:%s", channel.UUID())
+
+	// channel may be a stale copy and the token already refreshed
+	var cached string
+	h.WithRedisConn(func(rc redis.Conn) {
+		cached, err = redis.String(rc.Do("GET", cacheKey))
+	})
+	if err != nil && err != redis.ErrNil {
+		log.Error("error reading cached access token", "error", err)
+	}
+	if cached != "" {
+		return cached
+	}
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeTokenRefresh, channel, h.RedactValues(channel))
+
+	newToken, expires, err := h.exchangePageToken(token, clog)
+	if err == nil {
+		err = updater.UpdateChannelConfig(ctx, channel, map[string]any{
+			courier.ConfigAuthToken: newToken,
+			configTokenExpiresOn:    time.Now().Add(expires).UTC().Format(time.RFC3339),
+		})
+
+		// another instance got there first so their token is now the one saved on the channel
+		if err == courier.ErrChannelConfigConflict {
+			err = nil
+		}
+	}
+
+	clog.End()
+
+	if err := h.Backend().WriteChannelLog(ctx, clog); err != nil {
+		log.Error("error writing channel log", "error", err)
+	}
+
+	if err != nil {
+		log.Error("error refreshing page access token", "error", err)
+		return token
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("SET", cacheKey, newToken, "EX", int(tokenRefreshCacheTTL/time.Second))
+	})
+	if err != nil {
+		log.Error("error caching access token", "error", err)
+	}
+
+	return newToken
+}
+
+// exchanges the given long-lived token for a new one using the app credentials
+// see https://developers.facebook.com/docs/facebook-login/guides/access-tokens/get-long-lived
+func (h *handler) exchangePageToken(token string, clog *courier.ChannelLog) (string, time.Duration, error) {
+	u := h.graphURL(nil, "oauth/access_token")
+	u.RawQuery = url.Values{
+		"grant_type":        []string{"fb_exchange_token"},
+		"client_id":         []string{h.Server().Config().FacebookApplicationID},
+		"client_secret":     []string{h.Server().Config().FacebookApplicationSecret},
+		"fb_exchange_token": []string{token},
+	}.Encode()
+
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return "", 0, fmt.Errorf("unable to exchange access token")
+	}
+
+	newToken, err := jsonparser.GetString(respBody, "access_token")
+	if err != nil {
+		clog.Error(courier.ErrorResponseValueMissing("access_token"))
+		return "", 0, fmt.Errorf("unable to exchange access token")
+	}
+
+	// Meta long-lived tokens last around 60 days
+	expiresIn, err := jsonparser.GetInt(respBody, "expires_in")
+	if err != nil || expiresIn == 0 {
+		expiresIn = 60 * 24 * 60 * 60
+	}
+
+	return newToken, time.Second * time.Duration(expiresIn), nil
+}
@@ -1,6 +1,9 @@
 package whatsapp
 
-import "github.com/nyaruka/courier"
+import (
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+)
 
 // see https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/payload-examples#message-status-updates
 var StatusMapping = map[string]courier.MsgStatus{
@@ -23,6 +26,110 @@ type MOMedia struct {
 	SHA256   string `json:"sha256"`
 }
 
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/payload-examples#received-messages
+type Message struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	To        string `json:"to"` // only set on echoes
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Context   *struct {
+		Forwarded           bool   `json:"forwarded"`
+		FrequentlyForwarded bool   `json:"frequently_forwarded"`
+		From                string `json:"from"`
+		ID                  string `json:"id"`
+	} `json:"context"`
+	Text struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image    *MOMedia `json:"image"`
+	Audio    *MOMedia `json:"audio"`
+	Video    *MOMedia `json:"video"`
+	Document *MOMedia `json:"document"`
+	Voice    *MOMedia `json:"voice"`
+	Location *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Name      string  `json:"name,omitempty"`
+		Address   string  `json:"address,omitempty"`
+		URL       string  `json:"url,omitempty"`
+	} `json:"location"`
+	Contacts []*Contact `json:"contacts"`
+	Reaction *struct {
+		MessageID string `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	} `json:"reaction"`
+	Button *struct {
+		Text    string `json:"text"`
+		Payload string `json:"payload"`
+	} `json:"button"`
+	Interactive struct {
+		Type        string `json:"type"`
+		ButtonReply struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"button_reply,omitempty"`
+		ListReply struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"list_reply,omitempty"`
+		NFMReply struct {
+			Name         string `json:"name"`
+			Body         string `json:"body"`
+			ResponseJSON string `json:"response_json"`
+		} `json:"nfm_reply,omitempty"`
+	} `json:"interactive,omitempty"`
+	Referral *Referral `json:"referral"`
+	Errors   []struct {
+		Code  int    `json:"code"`
+		Title string `json:"title"`
+	} `json:"errors"`
+}
+
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/components#referral-object
+type Referral struct {
+	SourceURL    string `json:"source_url"`
+	SourceID     string `json:"source_id"`
+	SourceType   string `json:"source_type"`
+	Headline     string `json:"headline"`
+	Body         string `json:"body"`
+	MediaType    string `json:"media_type"`
+	ImageURL     string `json:"image_url"`
+	VideoURL     string `json:"video_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	CtwaClid     string `json:"ctwa_clid"`
+}
+
+// Normalize converts this to our normalized referral
+func (r *Referral) Normalize() *handlers.Referral {
+	n := &handlers.Referral{
+		Source:    r.SourceType,
+		ClickID:   r.CtwaClid,
+		Headline:  r.Headline,
+		Body:      r.Body,
+		MediaType: r.MediaType,
+		SourceURL: r.SourceURL,
+	}
+	if r.SourceType == "ad" {
+		n.AdID = r.SourceID
+	}
+	if r.MediaType == "video" {
+		n.MediaURL = r.VideoURL
+	} else {
+		n.MediaURL = r.ImageURL
+	}
+	return n
+}
+
+// Forwarded returns the forwarding context of this message, or nil if it wasn't forwarded. WhatsApp doesn't reveal who
+// originally sent a forwarded message, only whether it has been forwarded many times.
+func (m *Message) Forwarded() *handlers.Forwarded {
+	if m.Context == nil || (!m.Context.Forwarded && !m.Context.FrequentlyForwarded) {
+		return nil
+	}
+	return &handlers.Forwarded{Frequently: m.Context.FrequentlyForwarded}
+}
+
 type Change struct {
 	Field string `json:"field"`
 	Value struct {
@@ -37,52 +144,12 @@ type Change struct {
 			} `json:"profile"`
 			WaID string `json:"wa_id"`
 		} `json:"contacts"`
-		Messages []struct {
-			ID        string `json:"id"`
-			From      string `json:"from"`
-			Timestamp string `json:"timestamp"`
-			Type      string `json:"type"`
-			Context   *struct {
-				Forwarded           bool   `json:"forwarded"`
-				FrequentlyForwarded bool   `json:"frequently_forwarded"`
-				From                string `json:"from"`
-				ID                  string `json:"id"`
-			} `json:"context"`
-			Text struct {
-				Body string `json:"body"`
-			} `json:"text"`
-			Image    *MOMedia `json:"image"`
-			Audio    *MOMedia `json:"audio"`
-			Video    *MOMedia `json:"video"`
-			Document *MOMedia `json:"document"`
-			Voice    *MOMedia `json:"voice"`
-			Location *struct {
-				Latitude  float64 `json:"latitude"`
-				Longitude float64 `json:"longitude"`
-				Name      string  `json:"name"`
-				Address   string  `json:"address"`
-			} `json:"location"`
-			Button *struct {
-				Text    string `json:"text"`
-				Payload string `json:"payload"`
-			} `json:"button"`
-			Interactive struct {
-				Type        string `json:"type"`
-				ButtonReply struct {
-					ID    string `json:"id"`
-					Title string `json:"title"`
-				} `json:"button_reply,omitempty"`
-				ListReply struct {
-					ID    string `json:"id"`
-					Title string `json:"title"`
-				} `json:"list_reply,omitempty"`
-			} `json:"interactive,omitempty"`
-			Errors []struct {
-				Code  int    `json:"code"`
-				Title string `json:"title"`
-			} `json:"errors"`
-		} `json:"messages"`
-		Statuses []struct {
+		Messages []*Message `json:"messages"`
+
+		// in coexistence mode, messages sent by the business from the WhatsApp Business app are echoed back to us
+		// see https://developers.facebook.com/docs/whatsapp/embedded-signup/custom-flows/onboarding-business-app-users
+		MessageEchoes []*Message `json:"message_echoes"`
+		Statuses      []struct {
 			ID           string `json:"id"`
 			RecipientID  string `json:"recipient_id"`
 			Status       string `json:"status"`
@@ -108,9 +175,30 @@ type Change struct {
 			Code  int    `json:"code"`
 			Title string `json:"title"`
 		} `json:"errors"`
+
+		// Instagram comments are also delivered as changes
+		// see https://developers.facebook.com/docs/instagram-platform/webhooks/examples#comments
+		ID       string `json:"id"`
+		ParentID string `json:"parent_id"`
+		Text     string `json:"text"`
+		From     *struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"from"`
+		Media *struct {
+			ID               string `json:"id"`
+			MediaProductType string `json:"media_product_type"`
+		} `json:"media"`
 	} `json:"value"`
 }
 
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/guides/mark-message-as-read
+type MarkReadRequest struct {
+	MessagingProduct string `json:"messaging_product"`
+	Status           string `json:"status"`
+	MessageID        string `json:"message_id"`
+}
+
 // see https://developers.facebook.com/docs/whatsapp/cloud-api/guides/send-messages#media-messages
 type Media struct {
 	ID       string `json:"id,omitempty"`
@@ -196,11 +284,37 @@ type Interactive struct {
 	Footer *struct {
 		Text string `json:"text"`
 	} `json:"footer,omitempty"`
-	Action *struct {
-		Button   string    `json:"button,omitempty"`
-		Sections []Section `json:"sections,omitempty"`
-		Buttons  []Button  `json:"buttons,omitempty"`
-	} `json:"action,omitempty"`
+	Action *Action `json:"action,omitempty"`
+}
+
+type Action struct {
+	Button     string      `json:"button,omitempty"`
+	Sections   []Section   `json:"sections,omitempty"`
+	Buttons    []Button    `json:"buttons,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Parameters *FlowParams `json:"parameters,omitempty"`
+}
+
+// see https://developers.facebook.com/docs/whatsapp/cloud-api/reference/messages#contacts-object
+type Contact struct {
+	Name struct {
+		FormattedName string `json:"formatted_name" validate:"required"`
+		FirstName     string `json:"first_name,omitempty"`
+		LastName      string `json:"last_name,omitempty"`
+	} `json:"name" validate:"required"`
+	Org *struct {
+		Company string `json:"company,omitempty"`
+		Title   string `json:"title,omitempty"`
+	} `json:"org,omitempty"`
+	Phones []struct {
+		Phone string `json:"phone"`
+		Type  string `json:"type,omitempty"`
+		WaID  string `json:"wa_id,omitempty"`
+	} `json:"phones,omitempty"`
+	Emails []struct {
+		Email string `json:"email"`
+		Type  string `json:"type,omitempty"`
+	} `json:"emails,omitempty"`
 }
 
 // see https://developers.facebook.com/docs/whatsapp/cloud-api/guides/send-messages#request-syntax
@@ -221,6 +335,8 @@ type SendRequest struct {
 	Interactive *Interactive `json:"interactive,omitempty"`
 
 	Template *Template `json:"template,omitempty"`
+
+	Contacts []*Contact `json:"contacts,omitempty"`
 }
 
 // see https://developers.facebook.com/docs/whatsapp/cloud-api/guides/send-messages#response-syntax
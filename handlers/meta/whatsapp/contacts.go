@@ -0,0 +1,69 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier/utils"
+)
+
+// GetContactsPayload returns the contact cards to send from the contacts key of the given message metadata, e.g.
+//
+//	{"contacts": [{"name": {"formatted_name": "Bob"}, "phones": [{"phone": "+12065551234", "type": "CELL"}]}]}
+func GetContactsPayload(metadata json.RawMessage) ([]*Contact, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+
+	raw, _, _, err := jsonparser.Get(metadata, "contacts")
+	if err != nil {
+		return nil, nil
+	}
+
+	contacts := make([]*Contact, 0, 1)
+	if err := json.Unmarshal(raw, &contacts); err != nil {
+		return nil, fmt.Errorf("unable to read contacts from metadata: %w", err)
+	}
+	for _, c := range contacts {
+		if err := utils.Validate(c); err != nil {
+			return nil, fmt.Errorf("invalid contact in metadata: %w", err)
+		}
+	}
+	return contacts, nil
+}
+
+// GetContactsText returns a text representation of the given received contact cards, e.g.
+//
+//	Bob Smith
+//	Phone: +12065551234 (CELL)
+//	Email: bob@example.com (WORK)
+func GetContactsText(contacts []*Contact) string {
+	cards := make([]string, len(contacts))
+
+	for i, c := range contacts {
+		lines := []string{c.Name.FormattedName}
+
+		if c.Org != nil && c.Org.Company != "" {
+			lines = append(lines, fmt.Sprintf("Organization: %s", c.Org.Company))
+		}
+		for _, p := range c.Phones {
+			lines = append(lines, withType("Phone: "+p.Phone, p.Type))
+		}
+		for _, e := range c.Emails {
+			lines = append(lines, withType("Email: "+e.Email, e.Type))
+		}
+
+		cards[i] = strings.Join(lines, "\n")
+	}
+
+	return strings.Join(cards, "\n\n")
+}
+
+func withType(value, typ string) string {
+	if typ != "" {
+		return fmt.Sprintf("%s (%s)", value, typ)
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+package whatsapp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/courier/handlers/meta/whatsapp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContactsPayload(t *testing.T) {
+	contacts, err := whatsapp.GetContactsPayload(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, contacts)
+
+	contacts, err = whatsapp.GetContactsPayload(json.RawMessage(`{"topic": "agent"}`))
+	assert.NoError(t, err)
+	assert.Nil(t, contacts)
+
+	contacts, err = whatsapp.GetContactsPayload(json.RawMessage(`{"contacts": [{"name": {"formatted_name": "Bob"}, "phones": [{"phone": "+12065551234"}]}]}`))
+	assert.NoError(t, err)
+	if assert.Len(t, contacts, 1) {
+		assert.Equal(t, "Bob", contacts[0].Name.FormattedName)
+		assert.Equal(t, "+12065551234", contacts[0].Phones[0].Phone)
+	}
+
+	_, err = whatsapp.GetContactsPayload(json.RawMessage(`{"contacts": "Bob"}`))
+	assert.ErrorContains(t, err, "unable to read contacts from metadata")
+
+	_, err = whatsapp.GetContactsPayload(json.RawMessage(`{"contacts": [{"name": {"first_name": "Bob"}}]}`))
+	assert.ErrorContains(t, err, "invalid contact in metadata")
+}
+
+func TestGetContactsText(t *testing.T) {
+	contacts, _ := whatsapp.GetContactsPayload(json.RawMessage(`{"contacts": [{"name": {"formatted_name": "Bob"}, "phones": [{"phone": "+12065551234", "type": "HOME"}]}, {"name": {"formatted_name": "Jim"}}]}`))
+
+	assert.Equal(t, "Bob\nPhone: +12065551234 (HOME)\n\nJim", whatsapp.GetContactsText(contacts))
+	assert.Equal(t, "", whatsapp.GetContactsText(nil))
+}
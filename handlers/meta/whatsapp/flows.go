@@ -0,0 +1,81 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier/utils"
+)
+
+// Flow is how a WhatsApp Flow to send is described in message metadata, e.g.
+//
+//	{"flow": {"id": "1234", "token": "abc", "cta": "Book now", "screen": "WELCOME", "data": {"name": "Bob"}}}
+type Flow struct {
+	ID     string         `json:"id"               validate:"required"`
+	Token  string         `json:"token"            validate:"required"`
+	CTA    string         `json:"cta"              validate:"required"`
+	Screen string         `json:"screen,omitempty"`
+	Data   map[string]any `json:"data,omitempty"`
+	Mode   string         `json:"mode,omitempty"   validate:"omitempty,oneof=draft published"`
+}
+
+// see https://developers.facebook.com/docs/whatsapp/flows/guides/sendingaflow#interactive-message-parameters
+type FlowParams struct {
+	MessageVersion string             `json:"flow_message_version"`
+	Token          string             `json:"flow_token"`
+	ID             string             `json:"flow_id"`
+	CTA            string             `json:"flow_cta"`
+	Action         string             `json:"flow_action"`
+	ActionPayload  *FlowActionPayload `json:"flow_action_payload,omitempty"`
+	Mode           string             `json:"mode,omitempty"`
+}
+
+type FlowActionPayload struct {
+	Screen string         `json:"screen"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+// GetFlowPayload returns the flow to send from the flow key of the given message metadata
+func GetFlowPayload(metadata json.RawMessage) (*Flow, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+
+	raw, _, _, err := jsonparser.Get(metadata, "flow")
+	if err != nil {
+		return nil, nil
+	}
+
+	flow := &Flow{}
+	if err := json.Unmarshal(raw, flow); err != nil {
+		return nil, fmt.Errorf("unable to read flow from metadata: %w", err)
+	}
+	if err := utils.Validate(flow); err != nil {
+		return nil, fmt.Errorf("invalid flow in metadata: %w", err)
+	}
+	return flow, nil
+}
+
+// GetFlowInteractive returns the interactive object to send the given flow with the given body text
+func GetFlowInteractive(flow *Flow, body string) *Interactive {
+	params := &FlowParams{
+		MessageVersion: "3",
+		Token:          flow.Token,
+		ID:             flow.ID,
+		CTA:            flow.CTA,
+		Action:         "navigate",
+		Mode:           flow.Mode,
+	}
+
+	// without a screen to navigate to, the flow must use a data endpoint to determine its first screen
+	if flow.Screen != "" {
+		params.ActionPayload = &FlowActionPayload{Screen: flow.Screen, Data: flow.Data}
+	} else {
+		params.Action = "data_exchange"
+	}
+
+	interactive := &Interactive{Type: "flow", Action: &Action{Name: "flow", Parameters: params}}
+	interactive.Body.Text = body
+	return interactive
+}
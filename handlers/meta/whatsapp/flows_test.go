@@ -0,0 +1,41 @@
+package whatsapp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/courier/handlers/meta/whatsapp"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFlowPayload(t *testing.T) {
+	flow, err := whatsapp.GetFlowPayload(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, flow)
+
+	flow, err = whatsapp.GetFlowPayload(json.RawMessage(`{"topic": "agent"}`))
+	assert.NoError(t, err)
+	assert.Nil(t, flow)
+
+	flow, err = whatsapp.GetFlowPayload(json.RawMessage(`{"flow": {"id": "1234", "token": "abc", "cta": "Book", "screen": "WELCOME"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &whatsapp.Flow{ID: "1234", Token: "abc", CTA: "Book", Screen: "WELCOME"}, flow)
+
+	_, err = whatsapp.GetFlowPayload(json.RawMessage(`{"flow": "1234"}`))
+	assert.ErrorContains(t, err, "unable to read flow from metadata")
+
+	_, err = whatsapp.GetFlowPayload(json.RawMessage(`{"flow": {"id": "1234", "token": "abc"}}`))
+	assert.ErrorContains(t, err, "invalid flow in metadata")
+
+	_, err = whatsapp.GetFlowPayload(json.RawMessage(`{"flow": {"id": "1234", "token": "abc", "cta": "Book", "mode": "testing"}}`))
+	assert.ErrorContains(t, err, "invalid flow in metadata")
+}
+
+func TestGetFlowInteractive(t *testing.T) {
+	flow := &whatsapp.Flow{ID: "1234", Token: "abc", CTA: "Book", Screen: "WELCOME", Data: map[string]any{"name": "Bob"}}
+	assert.JSONEq(t, `{"type": "flow", "body": {"text": "Hi"}, "action": {"name": "flow", "parameters": {"flow_message_version": "3", "flow_token": "abc", "flow_id": "1234", "flow_cta": "Book", "flow_action": "navigate", "flow_action_payload": {"screen": "WELCOME", "data": {"name": "Bob"}}}}}`, string(jsonx.MustMarshal(whatsapp.GetFlowInteractive(flow, "Hi"))))
+
+	flow = &whatsapp.Flow{ID: "1234", Token: "abc", CTA: "Book", Mode: "draft"}
+	assert.JSONEq(t, `{"type": "flow", "body": {"text": "Hi"}, "action": {"name": "flow", "parameters": {"flow_message_version": "3", "flow_token": "abc", "flow_id": "1234", "flow_cta": "Book", "flow_action": "data_exchange", "mode": "draft"}}}`, string(jsonx.MustMarshal(whatsapp.GetFlowInteractive(flow, "Hi"))))
+}
@@ -8,9 +8,12 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/i18n"
 )
 
-func GetTemplatePayload(templating *courier.Templating) *Template {
+func GetTemplatePayload(templating *courier.Templating, locale i18n.Locale) *Template {
+	variables := handlers.FormatTemplatingVariables(templating, locale)
+
 	template := &Template{
 		Name:       templating.Template.Name,
 		Language:   &Language{Policy: "deterministic", Code: templating.Language},
@@ -22,7 +25,7 @@ func GetTemplatePayload(templating *courier.Templating) *Template {
 		compParams := make([]courier.TemplatingVariable, 0, len(comp.Variables))
 
 		for _, varName := range slices.Sorted(maps.Keys(comp.Variables)) {
-			compParams = append(compParams, templating.Variables[comp.Variables[varName]])
+			compParams = append(compParams, variables[comp.Variables[varName]])
 		}
 
 		var component *Component
@@ -63,6 +66,9 @@ func GetTemplatePayload(templating *courier.Templating) *Template {
 			for _, p := range compParams {
 				component.Params = append(component.Params, &Param{Type: p.Type, Text: p.Value})
 			}
+		} else if comp.Type == "footer" {
+			// footers can't contain variables so are never sent
+			continue
 		} else if strings.HasPrefix(comp.Type, "button/") {
 			component = &Component{Type: "button", Index: strings.TrimPrefix(comp.Name, "button."), SubType: strings.TrimPrefix(comp.Type, "button/"), Params: []*Param{}}
 
@@ -75,7 +81,8 @@ func GetTemplatePayload(templating *courier.Templating) *Template {
 			}
 		}
 
-		if component != nil {
+		// components without any parameters, e.g. a static header or a URL button without a suffix, must be omitted
+		if component != nil && len(component.Params) > 0 {
 			template.Components = append(template.Components, component)
 		}
 	}
@@ -192,6 +192,88 @@ func TestGetTemplatePayload(t *testing.T) {
 				},
 			},
 		},
+		{
+			templating: `{
+				"template": {"uuid": "4ed5000f-5c94-4143-9697-b7cbd230a381", "name": "Offer"},
+				"language": "en",
+				"components": [
+					{
+						"type": "header",
+						"name": "header",
+						"variables": {}
+					},
+					{
+						"type": "body",
+						"name": "body",
+						"variables": {"1": 0}
+					},
+					{
+						"type": "footer",
+						"name": "footer",
+						"variables": {}
+					},
+					{
+						"type": "button/quick_reply",
+						"name": "button.0",
+						"variables": {"1": 1}
+					},
+					{
+						"type": "button/quick_reply",
+						"name": "button.1",
+						"variables": {}
+					},
+					{
+						"type": "button/url",
+						"name": "button.2",
+						"variables": {"1": 2}
+					},
+					{
+						"type": "button/url",
+						"name": "button.3",
+						"variables": {}
+					}
+				],
+				"variables": [
+					{"type": "text", "value": "Bob"},
+					{"type": "text", "value": "Yes please"},
+					{"type": "text", "value": "offers/123"}
+				]
+			}`,
+			expected: &whatsapp.Template{
+				Name:     "Offer",
+				Language: &whatsapp.Language{Policy: "deterministic", Code: "en"},
+				Components: []*whatsapp.Component{
+					{Type: "body", Params: []*whatsapp.Param{{Type: "text", Text: "Bob"}}},
+					{Type: "button", SubType: "quick_reply", Index: "0", Params: []*whatsapp.Param{{Type: "payload", Payload: "Yes please"}}},
+					{Type: "button", SubType: "url", Index: "2", Params: []*whatsapp.Param{{Type: "text", Text: "offers/123"}}},
+				},
+			},
+		},
+		{
+			templating: `{
+				"template": {"uuid": "4ed5000f-5c94-4143-9697-b7cbd230a381", "name": "Invoice"},
+				"language": "es_EC",
+				"components": [
+					{
+						"type": "body",
+						"name": "body",
+						"variables": {"1": 0, "2": 1, "3": 2}
+					}
+				],
+				"variables": [
+					{"type": "text", "value": "1234.5", "format": "currency", "currency": "USD"},
+					{"type": "text", "value": "2024-03-15", "format": "date"},
+					{"type": "text", "value": "1234.5"}
+				]
+			}`,
+			expected: &whatsapp.Template{
+				Name:     "Invoice",
+				Language: &whatsapp.Language{Policy: "deterministic", Code: "es_EC"},
+				Components: []*whatsapp.Component{
+					{Type: "body", Params: []*whatsapp.Param{{Type: "text", Text: "$1.234,50"}, {Type: "text", Text: "15/03/2024"}, {Type: "text", Text: "1234.5"}}},
+				},
+			},
+		},
 	}
 
 	for i, tc := range tcs {
@@ -199,7 +281,7 @@ func TestGetTemplatePayload(t *testing.T) {
 		jsonx.MustUnmarshal([]byte(tc.templating), templating)
 
 		msg := test.NewMockMsg(1, "87995844-2017-4ba0-bc73-f3da75b32f9b", nil, "tel:+1234567890", "hi", nil).WithTemplating(templating)
-		actual := whatsapp.GetTemplatePayload(msg.Templating())
+		actual := whatsapp.GetTemplatePayload(msg.Templating(), msg.Locale())
 
 		assert.Equal(t, tc.expected, actual, "%d: template payload mismatch", i)
 	}
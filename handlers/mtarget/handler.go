@@ -155,7 +155,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		// build our request
 		params := url.Values{
 			"username":     []string{username},
@@ -198,6 +198,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			reason, _ := jsonparser.GetString(respBody, "results", "[0]", "reason")
 			return courier.ErrFailedWithReason(code, reason)
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
@@ -9,11 +9,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/buger/jsonparser"
-	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/gocommon/urns"
@@ -32,14 +30,13 @@ func init() {
 type handler struct {
 	handlers.BaseHandler
 
-	fetchTokenMutex sync.Mutex
+	tokens *handlers.TokenManager
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{
-		BaseHandler:     handlers.NewBaseHandler(courier.ChannelType("MTN"), "MTN Developer Portal"),
-		fetchTokenMutex: sync.Mutex{},
-	}
+	h := &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("MTN"), "MTN Developer Portal")}
+	h.tokens = handlers.NewTokenManager(h)
+	return h
 }
 
 // Initialize implements courier.ChannelHandler
@@ -79,7 +76,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 	if payload.Message != "" {
 		clog.Type = courier.ChannelLogTypeMsgReceive
 
-		date := time.Unix(payload.Created/1000, payload.Created%1000*1000000).UTC()
+		date := handlers.ParseUnixTimestamp(payload.Created, handlers.TimestampMillis)
 		urn, err := urns.ParsePhone(payload.From, channel.Country(), true, false)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
@@ -121,7 +118,7 @@ type mtPayload struct {
 }
 
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
-	accessToken, err := h.getAccessToken(msg.Channel(), clog)
+	accessToken, err := h.tokens.Token(ctx, msg.Channel(), clog)
 	if err != nil {
 		return courier.ErrChannelConfig
 	}
@@ -133,7 +130,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	mtMsg := &mtPayload{}
 	mtMsg.From = strings.TrimPrefix(msg.Channel().Address(), "+")
 	mtMsg.To = []string{strings.TrimPrefix(msg.URN().Path(), "+")}
-	mtMsg.Message = handlers.GetTextAndAttachments(msg)
+	mtMsg.Message = h.TextAndAttachments(msg)
 	mtMsg.ClientCorrelator = msg.ID().String()
 	if cpAddress != "" {
 		mtMsg.CPAddress = cpAddress
@@ -165,6 +162,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		res.AddExternalID(externalID)
 	}
 
+	handlers.AddSMSSegments(res, mtMsg.Message)
+
 	return nil
 }
 
@@ -175,44 +174,8 @@ func (h *handler) RedactValues(ch courier.Channel) []string {
 	}
 }
 
-func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
-	tokenKey := fmt.Sprintf("channel-token:%s", channel.UUID())
-
-	h.fetchTokenMutex.Lock()
-	defer h.fetchTokenMutex.Unlock()
-
-	var token string
-	var err error
-	h.WithRedisConn(func(rc redis.Conn) {
-		token, err = redis.String(rc.Do("GET", tokenKey))
-	})
-
-	if err != nil && err != redis.ErrNil {
-		return "", fmt.Errorf("error reading cached access token: %w", err)
-	}
-
-	if token != "" {
-		return token, nil
-	}
-
-	token, expires, err := h.fetchAccessToken(channel, clog)
-	if err != nil {
-		return "", fmt.Errorf("error fetching new access token: %w", err)
-	}
-
-	h.WithRedisConn(func(rc redis.Conn) {
-		_, err = rc.Do("SET", tokenKey, token, "EX", int(expires/time.Second))
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("error updating cached access token: %w", err)
-	}
-
-	return token, nil
-}
-
-// fetchAccessToken tries to fetch a new token for our channel, setting the result in redis
-func (h *handler) fetchAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+// FetchAccessToken fetches a new access token for the given channel
+func (h *handler) FetchAccessToken(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
 	form := url.Values{
 		"client_id":     []string{channel.StringConfigForKey(courier.ConfigAPIKey, "")},
 		"client_secret": []string{channel.StringConfigForKey(courier.ConfigAuthToken, "")},
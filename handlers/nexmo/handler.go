@@ -22,6 +22,9 @@ const (
 	configNexmoAPISecret     = "nexmo_api_secret"
 	configNexmoAppID         = "nexmo_app_id"
 	configNexmoAppPrivateKey = "nexmo_app_private_key"
+
+	// send status returned when the account doesn't have enough credit to send
+	statusPartnerQuotaViolation = "9"
 )
 
 var (
@@ -98,6 +101,7 @@ func (h *handler) Initialize(s courier.Server) error {
 	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
 	s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, h.receiveStatus)
 	s.AddHandlerRoute(h, http.MethodGet, "status", courier.ChannelLogTypeMsgStatus, h.receiveStatus)
+	s.AddHandlerRoute(h, http.MethodPost, "dtmf", courier.ChannelLogTypeMsgReceive, h.receiveDTMF)
 	return nil
 }
 
@@ -169,6 +173,42 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
 
+// https://developer.vonage.com/en/voice/voice-api/webhook-reference#input
+type dtmfPayload struct {
+	UUID             string `json:"uuid"              validate:"required"`
+	ConversationUUID string `json:"conversation_uuid"`
+	From             string `json:"from"              validate:"required"`
+	To               string `json:"to"`
+	DTMF             struct {
+		Digits   string `json:"digits"`
+		TimedOut bool   `json:"timed_out"`
+	} `json:"dtmf"`
+}
+
+// receiveDTMF is our HTTP handler function for the digits entered by a contact during a call, which are used as the
+// text of an incoming message so that simple IVR flows can be driven without a separate IVR service
+func (h *handler) receiveDTMF(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	payload := &dtmfPayload{}
+	if err := handlers.DecodeAndValidateJSON(payload, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	if payload.DTMF.Digits == "" {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "no digits entered, ignored")
+	}
+
+	urn, err := urns.ParsePhone(payload.From, channel.Country(), true, false)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// a call can have several inputs so the call UUID isn't used as the external ID
+	session := &handlers.VoiceSession{CallID: payload.UUID, ConversationID: payload.ConversationUUID, TimedOut: payload.DTMF.TimedOut}
+	msg := h.Backend().NewIncomingMsg(channel, urn, payload.DTMF.Digits, "", clog).WithMetadata(session.Metadata())
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 
 	nexmoAPIKey := msg.Channel().StringConfigForKey(configNexmoAPIKey, "")
@@ -181,7 +221,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
 	callbackURL := fmt.Sprintf("https://%s/c/nx/%s/status", callbackDomain, msg.Channel().UUID())
 
-	text := handlers.GetTextAndAttachments(msg)
+	text := h.TextAndAttachments(msg)
 
 	textType := "text"
 	if !gsm7.IsValid(text) {
@@ -229,7 +269,9 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 		nexmoStatus, err := jsonparser.GetString(respBody, "messages", "[0]", "status")
 		errCode, _ := strconv.Atoi(nexmoStatus)
-		if err != nil || nexmoStatus != "0" {
+		if nexmoStatus == statusPartnerQuotaViolation {
+			return courier.ErrInsufficientBalance
+		} else if err != nil || nexmoStatus != "0" {
 			return courier.ErrFailedWithReason("send:"+nexmoStatus, sendErrorCodes[errCode])
 		}
 
@@ -238,6 +280,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			res.AddExternalID(externalID)
 		}
 
+		handlers.AddSMSSegments(res, part)
 	}
 
 	return nil
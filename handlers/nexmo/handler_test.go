@@ -19,6 +19,7 @@ var testChannels = []courier.Channel{
 const (
 	statusURL  = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"
 	receiveURL = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"
+	dtmfURL    = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/dtmf"
 )
 
 var testCases = []IncomingTestCase{
@@ -47,6 +48,30 @@ var testCases = []IncomingTestCase{
 		ExpectedURN:          "tel:+2349067554729",
 		ExpectedExternalID:   "external1",
 	},
+	{
+		Label:                "Receive DTMF",
+		URL:                  dtmfURL,
+		Data:                 `{"uuid": "aaaaaaaa-bbbb-cccc-dddd-0123456789ab", "conversation_uuid": "CON-aaaaaaaa-bbbb-cccc-dddd-0123456789ab", "from": "2349067554729", "to": "2020", "dtmf": {"digits": "42", "timed_out": true}, "timestamp": "2024-01-01T14:00:00.000Z"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("42"),
+		ExpectedURN:          "tel:+2349067554729",
+		ExpectedMsgMetadata:  `{"voice_session": {"call_id": "aaaaaaaa-bbbb-cccc-dddd-0123456789ab", "conversation_id": "CON-aaaaaaaa-bbbb-cccc-dddd-0123456789ab", "timed_out": true}}`,
+	},
+	{
+		Label:                "Receive DTMF No Digits",
+		URL:                  dtmfURL,
+		Data:                 `{"uuid": "aaaaaaaa-bbbb-cccc-dddd-0123456789ab", "from": "2349067554729", "to": "2020", "dtmf": {"digits": "", "timed_out": true}}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "no digits entered, ignored",
+	},
+	{
+		Label:                "Receive DTMF Missing Call",
+		URL:                  dtmfURL,
+		Data:                 `{"from": "2349067554729", "dtmf": {"digits": "42"}}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "Field validation for 'UUID' failed",
+	},
 	{
 		Label:                "Receive URL check",
 		URL:                  receiveURL,
@@ -168,7 +193,8 @@ var defaultSendTestCases = []OutgoingTestCase{
 				Form: url.Values{"text": {"I need to keep adding more things to make it work"}, "to": {"250788383383"}, "from": {"2020"}, "api_key": {"nexmo-api-key"}, "api_secret": {"nexmo-api-secret"}, "status-report-req": {"1"}, "type": {"text"}, "callback": {"https://localhost/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"}},
 			},
 		},
-		ExpectedExtIDs: []string{"1002", "1002"},
+		ExpectedExtIDs:   []string{"1002", "1002"},
+		ExpectedSegments: 2,
 	},
 	{
 		Label:          "Send Attachment",
@@ -200,6 +226,20 @@ var defaultSendTestCases = []OutgoingTestCase{
 
 		ExpectedError: courier.ErrFailedWithReason("send:10", "Too Many Existing Binds"),
 	},
+	{
+		Label:   "Insufficient Balance",
+		MsgText: "No credit",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://rest.nexmo.com/sms/json": {
+				httpx.NewMockResponse(200, nil, []byte(`{"messages":[{"status":"9","error-text":"Quota Exceeded - rejected"}]}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Form: url.Values{"text": {"No credit"}, "to": {"250788383383"}, "from": {"2020"}, "api_key": {"nexmo-api-key"}, "api_secret": {"nexmo-api-secret"}, "status-report-req": {"1"}, "type": {"text"}, "callback": {"https://localhost/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"}},
+		}},
+		ExpectedError: courier.ErrInsufficientBalance,
+	},
 	{
 		Label:   "Error Sending",
 		MsgText: "Error Message",
@@ -155,7 +155,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for i, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for i, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		payload := mtPayload{}
 		message := mtMessage{}
 
@@ -185,6 +185,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		} else if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
+
+		handlers.AddSMSSegments(res, part)
 	}
 	return nil
 }
@@ -41,7 +41,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	text := handlers.GetTextAndAttachments(msg)
+	text := h.TextAndAttachments(msg)
 	form := url.Values{
 		"LoginName":         []string{username},
 		"Password":          []string{password},
@@ -76,5 +76,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
+	handlers.AddSMSSegments(res, text)
+
 	return nil
 }
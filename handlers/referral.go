@@ -0,0 +1,38 @@
+package handlers
+
+// Referral is campaign attribution, e.g. from a click-to-chat ad or a deep link, normalized across channel types and
+// written as the extra of referral events
+type Referral struct {
+	Source    string // where the referral came from, e.g. ADS, ad, post or start
+	Type      string // the type of referral if the channel distinguishes them, e.g. OPEN_THREAD
+	Ref       string // the ref param of the link or ad, or the start parameter of a deep link
+	AdID      string
+	ClickID   string
+	Headline  string
+	Body      string
+	MediaType string // image or video
+	MediaURL  string
+	SourceURL string
+}
+
+// Extra returns the extra of a referral event for this referral, omitting values which weren't provided
+func (r *Referral) Extra() map[string]string {
+	extra := make(map[string]string, 4)
+	for k, v := range map[string]string{
+		"source":      r.Source,
+		"type":        r.Type,
+		"referrer_id": r.Ref,
+		"ad_id":       r.AdID,
+		"click_id":    r.ClickID,
+		"headline":    r.Headline,
+		"body":        r.Body,
+		"media_type":  r.MediaType,
+		"media_url":   r.MediaURL,
+		"source_url":  r.SourceURL,
+	} {
+		if v != "" {
+			extra[k] = v
+		}
+	}
+	return extra
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/nyaruka/courier"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// checks a sample of the webhook payloads of channel types configured for it for fields which weren't decoded into the
+// given payload, recording them so that we find out about providers changing their payloads before it breaks parsing
+func checkSchemaDrift(h courier.ChannelHandler, ch courier.Channel, r *http.Request, payload any) {
+	config := h.Server().Config()
+
+	typ := h.ChannelType()
+	if ch != nil {
+		typ = ch.ChannelType()
+	}
+
+	if !slices.Contains(config.ParseSchemaDriftTypes(), typ) || rand.Float64() >= config.SchemaDriftSampleRate {
+		return
+	}
+
+	body, err := ReadBody(r, maxBodyReadBytes)
+	if err != nil {
+		return
+	}
+
+	fields := unknownJSONFields(body, payload)
+	if len(fields) == 0 {
+		return
+	}
+
+	rc := h.Server().Backend().RedisPool().Get()
+	defer rc.Close()
+
+	added, err := courier.RecordSchemaDrift(rc, typ, fields)
+	if err != nil {
+		slog.Error("error recording schema drift", "error", err, "channel_type", typ)
+		return
+	}
+
+	// only alert the first time a field is seen
+	for _, f := range added {
+		slog.Error("webhook payload has unknown field", "channel_type", typ, "field", f, "url", r.URL.Path)
+	}
+}
+
+// returns the paths, e.g. entry[].changes[].value.foo, of fields in the given JSON which wouldn't be decoded into the
+// given value, sorted and without duplicates
+func unknownJSONFields(body []byte, v any) []string {
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil
+	}
+
+	unknown := make(map[string]bool)
+	findUnknownJSONFields("", decoded, reflect.TypeOf(v), unknown)
+
+	fields := make([]string, 0, len(unknown))
+	for f := range unknown {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+func findUnknownJSONFields(path string, v any, t reflect.Type, unknown map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// types which do their own decoding can accept anything
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, isObj := v.(map[string]any)
+		if !isObj {
+			return
+		}
+
+		fields := jsonFields(t)
+		for key, value := range obj {
+			ft, found := fields[key]
+			if !found {
+				// like encoding/json, fall back to a case-insensitive match
+				for name, nt := range fields {
+					if strings.EqualFold(name, key) {
+						ft, found = nt, true
+						break
+					}
+				}
+			}
+
+			if found {
+				findUnknownJSONFields(joinJSONPath(path, key), value, ft, unknown)
+			} else {
+				unknown[joinJSONPath(path, key)] = true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if arr, isArr := v.([]any); isArr {
+			for _, item := range arr {
+				findUnknownJSONFields(path+"[]", item, t.Elem(), unknown)
+			}
+		}
+	case reflect.Map:
+		if obj, isObj := v.(map[string]any); isObj {
+			for _, value := range obj {
+				findUnknownJSONFields(joinJSONPath(path, "*"), value, t.Elem(), unknown)
+			}
+		}
+	}
+}
+
+// returns the types of the fields of the given struct type by the names they're decoded from
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		// fields of embedded structs without names are decoded as if they were fields of the outer struct
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, nt := range jsonFields(ft) {
+					if _, exists := fields[n]; !exists {
+						fields[n] = nt
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func joinJSONPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
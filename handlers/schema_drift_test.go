@@ -0,0 +1,110 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type driftHandler struct {
+	handlers.BaseHandler
+}
+
+func (h *driftHandler) Initialize(s courier.Server) error { return nil }
+func (h *driftHandler) Send(context.Context, courier.MsgOut, *courier.SendResult, *courier.ChannelLog) error {
+	return nil
+}
+
+type driftBase struct {
+	ID string `json:"id"`
+}
+
+type driftPayload struct {
+	driftBase
+	Object  string `json:"object"`
+	Entries []struct {
+		Text    string            `json:"text"`
+		Extra   map[string]string `json:"extra"`
+		Contact *struct {
+			Name string
+		} `json:"contact"`
+	} `json:"entries"`
+	Ignored string `json:"-"`
+}
+
+func TestSchemaDrift(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, mc, nil)
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	config := courier.NewDefaultConfig()
+	config.SchemaDriftTypes = "NX"
+	config.SchemaDriftSampleRate = 1
+
+	h := &driftHandler{handlers.NewBaseHandler("NX", "Test")}
+	h.SetServer(test.NewMockServer(config, mb))
+
+	var received *driftPayload
+	handle := handlers.JSONPayload(h, func(ctx context.Context, c courier.Channel, w http.ResponseWriter, r *http.Request, p *driftPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+		received = p
+		return nil, nil
+	})
+
+	receive := func(body string) {
+		r := httptest.NewRequest(http.MethodPost, "/c/nx/7a8ff1d4-f211-4492-9d05-e1905f6da8c8/receive", strings.NewReader(body))
+		_, err := handle(ctx, mc, httptest.NewRecorder(), r, clog)
+		require.NoError(t, err)
+	}
+
+	// a payload which only has fields we know about
+	receive(`{"id": "123", "object": "msg", "entries": [{"text": "hi", "extra": {"foo": "bar"}, "contact": {"name": "Bob"}}]}`)
+	assert.Equal(t, "hi", received.Entries[0].Text)
+
+	drifts, err := courier.ReadSchemaDrift(rc)
+	assert.NoError(t, err)
+	assert.Len(t, drifts, 0)
+
+	// a payload which has new fields at different levels, including one we deliberately don't decode
+	receive(`{"id": "123", "object": "msg", "version": 2, "Ignored": "x", "entries": [{"text": "hi", "language": "en", "contact": {"name": "Bob", "age": 32}}]}`)
+	receive(`{"id": "123", "object": "msg", "version": 3}`)
+
+	drifts, err = courier.ReadSchemaDrift(rc)
+	assert.NoError(t, err)
+	if assert.Len(t, drifts, 4) {
+		assert.Equal(t, courier.ChannelType("NX"), drifts[0].ChannelType)
+		assert.Equal(t, "Ignored", drifts[0].Field)
+		assert.Equal(t, "entries[].contact.age", drifts[1].Field)
+		assert.Equal(t, "entries[].language", drifts[2].Field)
+		assert.Equal(t, "version", drifts[3].Field)
+		assert.Equal(t, 1, drifts[2].Count)
+		assert.Equal(t, 2, drifts[3].Count)
+		assert.False(t, drifts[3].FirstSeen.After(drifts[3].LastSeen))
+	}
+
+	// channel types which aren't configured aren't checked
+	config.SchemaDriftTypes = "TG"
+	receive(`{"id": "123", "object": "msg", "status": "new"}`)
+
+	drifts, err = courier.ReadSchemaDrift(rc)
+	assert.NoError(t, err)
+	assert.Len(t, drifts, 4)
+
+	assert.NoError(t, courier.ClearSchemaDrift(rc, "NX"))
+
+	drifts, err = courier.ReadSchemaDrift(rc)
+	assert.NoError(t, err)
+	assert.Len(t, drifts, 0)
+}
@@ -19,6 +19,8 @@ POST /api/v1/shaqodoon/received/uuid/
 from=252634101111&text=Msg
 */
 
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{time.RFC3339Nano}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -61,7 +63,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	date := time.Now()
 	if dateString != "" {
-		date, err = time.Parse(time.RFC3339Nano, dateString)
+		date, err = timestampFormat.Parse(dateString)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid date format, must be RFC 3339"))
 		}
@@ -95,7 +97,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	// build our request
 	form := url.Values{
 		"from":     []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
-		"msg":      []string{handlers.GetTextAndAttachments(msg)},
+		"msg":      []string{h.TextAndAttachments(msg)},
 		"to":       []string{strings.TrimPrefix(msg.URN().Path(), "+")},
 		"username": []string{username},
 		"password": []string{password},
@@ -117,5 +119,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
+	handlers.AddSMSSegments(res, form.Get("msg"))
+
 	return nil
 }
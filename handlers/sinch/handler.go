@@ -0,0 +1,294 @@
+package sinch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/gsm7"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+var (
+	sendURL = "https://%s.sms.api.sinch.com/xms/v1/%s/batches"
+
+	// Sinch concatenates long messages itself, up to this many parts per batch
+	maxMsgParts         = 10
+	maxGSM7MsgLength    = maxMsgParts * 153
+	maxUnicodeMsgLength = maxMsgParts * 67
+)
+
+const (
+	configServicePlanID = "service_plan_id"
+	configRegion        = "region"
+	configMMS           = "mms"
+
+	defaultRegion = "us"
+)
+
+// timestamps are in UTC, e.g. 2016-10-02T09:34:28.542Z
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000Z", "2006-01-02T15:04:05Z"}}
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("SNC"), "Sinch")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, handlers.JSONPayload(h, h.receiveEvent))
+	return nil
+}
+
+// Sinch posts both incoming messages and delivery reports to the same callback URL, e.g.
+//
+//	{"type": "mo_text", "id": "01FC66621VHDBN119Z8PMV1QPQ", "from": "12067799294", "to": "18444651185", "body": "Hi", "received_at": "2016-10-02T09:34:28.542Z"}
+//	{"type": "recipient_delivery_report_sms", "batch_id": "01FC66621XXXXX119Z8PMV1QPQ", "recipient": "12067799294", "code": 0, "status": "Delivered"}
+type eventPayload struct {
+	Type       string          `json:"type"       validate:"required"`
+	ID         string          `json:"id"`
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Body       json.RawMessage `json:"body"`
+	ReceivedAt string          `json:"received_at"`
+	BatchID    string          `json:"batch_id"`
+	Recipient  string          `json:"recipient"`
+	Code       int             `json:"code"`
+	Status     string          `json:"status"`
+}
+
+// the body of an incoming MMS
+type moMediaBody struct {
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	Media   []struct {
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+		Status      string `json:"status"`
+	} `json:"media"`
+}
+
+var statusMapping = map[string]courier.MsgStatus{
+	"Queued":     courier.MsgStatusWired,
+	"Dispatched": courier.MsgStatusSent,
+	"Delivered":  courier.MsgStatusDelivered,
+	"Aborted":    courier.MsgStatusFailed,
+	"Cancelled":  courier.MsgStatusFailed,
+	"Rejected":   courier.MsgStatusFailed,
+	"Failed":     courier.MsgStatusFailed,
+	"Expired":    courier.MsgStatusFailed,
+}
+
+// receiveEvent is our HTTP handler function for incoming messages and delivery reports
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *eventPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	switch payload.Type {
+	case "recipient_delivery_report_sms", "recipient_delivery_report_mms":
+		clog.Type = courier.ChannelLogTypeMsgStatus
+		return h.receiveStatus(ctx, channel, w, r, payload, clog)
+	case "mo_text", "mo_media":
+		clog.Type = courier.ChannelLogTypeMsgReceive
+		return h.receiveMessage(ctx, channel, w, r, payload, clog)
+	}
+
+	return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring event of type %s", payload.Type))
+}
+
+func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *eventPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	if payload.BatchID == "" || payload.Status == "" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("missing one of 'batch_id' or 'status' in request body"))
+	}
+
+	msgStatus, found := statusMapping[payload.Status]
+	if !found {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring status: %s", payload.Status))
+	}
+
+	if msgStatus == courier.MsgStatusFailed && payload.Code != 0 {
+		clog.Error(courier.ErrorExternal(strconv.Itoa(payload.Code), payload.Status))
+	}
+
+	// we send each batch to a single recipient so batch IDs are our external IDs
+	status := h.Backend().NewStatusUpdateByExternalID(channel, payload.BatchID, msgStatus, clog)
+	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
+}
+
+func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *eventPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	if payload.ID == "" || payload.From == "" || payload.ReceivedAt == "" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("missing one of 'id', 'from' or 'received_at' in request body"))
+	}
+
+	date, err := timestampFormat.Parse(payload.ReceivedAt)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	urn, err := urns.ParsePhone(payload.From, channel.Country(), true, false)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	var text string
+	var attachments []string
+
+	if payload.Type == "mo_media" {
+		body := &moMediaBody{}
+		if err := json.Unmarshal(payload.Body, body); err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse media body: %w", err))
+		}
+
+		text = body.Message
+		for _, m := range body.Media {
+			if m.URL != "" && m.Status != "Failed" {
+				attachments = append(attachments, m.URL)
+			}
+		}
+	} else if err := json.Unmarshal(payload.Body, &text); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse text body: %w", err))
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.ID, clog).WithReceivedOn(date)
+	for _, a := range attachments {
+		msg.WithAttachment(a)
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+type mtPayload struct {
+	From                    string   `json:"from"`
+	To                      []string `json:"to"`
+	Type                    string   `json:"type,omitempty"`
+	Body                    any      `json:"body"`
+	DeliveryReport          string   `json:"delivery_report"`
+	CallbackURL             string   `json:"callback_url"`
+	MaxNumberOfMessageParts int      `json:"max_number_of_message_parts,omitempty"`
+}
+
+type mtMediaBody struct {
+	URL     string `json:"url"`
+	Message string `json:"message,omitempty"`
+}
+
+type mtResponse struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	servicePlanID := msg.Channel().StringConfigForKey(configServicePlanID, "")
+	authToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	if servicePlanID == "" || authToken == "" {
+		return courier.ErrChannelConfig
+	}
+
+	region := msg.Channel().StringConfigForKey(configRegion, defaultRegion)
+	batchesURL := fmt.Sprintf(sendURL, region, servicePlanID)
+
+	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
+	callbackURL := fmt.Sprintf("https://%s/c/snc/%s/receive", callbackDomain, msg.Channel().UUID())
+
+	newPayload := func() *mtPayload {
+		return &mtPayload{
+			From:           strings.TrimPrefix(msg.Channel().Address(), "+"),
+			To:             []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+			DeliveryReport: "per_recipient",
+			CallbackURL:    callbackURL,
+		}
+	}
+
+	// MMS is only supported in some countries so attachments are sent as links unless the channel has it enabled
+	if msg.Channel().BoolConfigForKey(configMMS, false) && len(msg.Attachments()) > 0 {
+		for i, attachment := range msg.Attachments() {
+			_, attURL := handlers.SplitAttachment(attachment)
+
+			payload := newPayload()
+			payload.Type = "mt_media"
+			body := &mtMediaBody{URL: attURL}
+
+			// text goes along with the first attachment
+			if i == 0 {
+				body.Message = msg.Text()
+			}
+			payload.Body = body
+
+			if err := h.sendBatch(batchesURL, authToken, payload, res, clog); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	text := h.TextAndAttachments(msg)
+
+	// split into batches which Sinch can send as a single concatenated message
+	maxLength := maxUnicodeMsgLength
+	if gsm7.IsValid(text) {
+		maxLength = maxGSM7MsgLength
+	}
+
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), text, maxLength) {
+		payload := newPayload()
+		payload.Body = part
+		payload.MaxNumberOfMessageParts = maxMsgParts
+
+		if err := h.sendBatch(batchesURL, authToken, payload, res, clog); err != nil {
+			return err
+		}
+
+		handlers.AddSMSSegments(res, part)
+	}
+
+	return nil
+}
+
+func (h *handler) sendBatch(batchesURL, authToken string, payload *mtPayload, res *courier.SendResult, clog *courier.ChannelLog) error {
+	req, err := http.NewRequest(http.MethodPost, batchesURL, bytes.NewReader(jsonx.MustMarshal(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+authToken)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return courier.ErrConnectionThrottled
+	}
+
+	response := &mtResponse{}
+	if err := json.Unmarshal(respBody, response); err != nil {
+		return courier.ErrResponseUnparseable
+	}
+
+	if resp.StatusCode/100 != 2 {
+		if response.Code != "" {
+			return courier.ErrFailedWithReason(response.Code, response.Text)
+		}
+		return courier.ErrResponseStatus
+	}
+
+	if response.ID == "" {
+		return courier.ErrResponseUnexpected
+	}
+
+	res.AddExternalID(response.ID)
+	return nil
+}
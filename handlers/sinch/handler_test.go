@@ -0,0 +1,281 @@
+package sinch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "SNC", "18444651185", "US", []string{urns.Phone.Prefix}, map[string]any{"service_plan_id": "plan123", "auth_token": "sesame"}),
+}
+
+const receiveURL = "/c/snc/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+var incomingCases = []IncomingTestCase{
+	{
+		Label:                "Receive valid text",
+		URL:                  receiveURL,
+		Data:                 `{"type": "mo_text", "id": "01FC66621VHDBN119Z8PMV1QPQ", "from": "12067799294", "to": "18444651185", "body": "Hello World", "received_at": "2016-10-02T09:34:28.542Z"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Message Accepted",
+		ExpectedMsgText:      Sp("Hello World"),
+		ExpectedURN:          "tel:+12067799294",
+		ExpectedDate:         time.Date(2016, 10, 2, 9, 34, 28, 542000000, time.UTC),
+		ExpectedExternalID:   "01FC66621VHDBN119Z8PMV1QPQ",
+	},
+	{
+		Label:                "Receive valid media",
+		URL:                  receiveURL,
+		Data:                 `{"type": "mo_media", "id": "01FC66621VHDBN119Z8PMV1QPR", "from": "12067799294", "to": "18444651185", "body": {"subject": "", "message": "My pic", "media": [{"url": "https://sinch.com/media/1.jpg", "content_type": "image/jpeg", "status": "Uploaded"}, {"url": "https://sinch.com/media/2.jpg", "content_type": "image/jpeg", "status": "Failed"}]}, "received_at": "2016-10-02T09:34:28Z"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Message Accepted",
+		ExpectedMsgText:      Sp("My pic"),
+		ExpectedAttachments:  []string{"https://sinch.com/media/1.jpg"},
+		ExpectedURN:          "tel:+12067799294",
+		ExpectedDate:         time.Date(2016, 10, 2, 9, 34, 28, 0, time.UTC),
+		ExpectedExternalID:   "01FC66621VHDBN119Z8PMV1QPR",
+	},
+	{
+		Label:                "Receive missing params",
+		URL:                  receiveURL,
+		Data:                 `{"type": "mo_text", "id": "01FC66621VHDBN119Z8PMV1QPQ", "to": "18444651185", "body": "Hello World", "received_at": "2016-10-02T09:34:28.542Z"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing one of 'id', 'from' or 'received_at' in request body",
+	},
+	{
+		Label:                "Receive invalid URN",
+		URL:                  receiveURL,
+		Data:                 `{"type": "mo_text", "id": "01FC66621VHDBN119Z8PMV1QPQ", "from": "MTN", "to": "18444651185", "body": "Hello World", "received_at": "2016-10-02T09:34:28.542Z"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "not a possible number",
+	},
+	{
+		Label:                "Receive invalid date",
+		URL:                  receiveURL,
+		Data:                 `{"type": "mo_text", "id": "01FC66621VHDBN119Z8PMV1QPQ", "from": "12067799294", "to": "18444651185", "body": "Hello World", "received_at": "yesterday"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: `parsing time \"yesterday\"`,
+	},
+	{
+		Label:                "Status delivered",
+		URL:                  receiveURL,
+		Data:                 `{"type": "recipient_delivery_report_sms", "batch_id": "01FC66621XXXXX119Z8PMV1QPQ", "recipient": "12067799294", "code": 0, "status": "Delivered"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"D"`,
+		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "01FC66621XXXXX119Z8PMV1QPQ", Status: courier.MsgStatusDelivered}},
+	},
+	{
+		Label:                "Status failed",
+		URL:                  receiveURL,
+		Data:                 `{"type": "recipient_delivery_report_mms", "batch_id": "01FC66621XXXXX119Z8PMV1QPQ", "recipient": "12067799294", "code": 402, "status": "Failed"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: `"status":"F"`,
+		ExpectedStatuses:     []ExpectedStatus{{ExternalID: "01FC66621XXXXX119Z8PMV1QPQ", Status: courier.MsgStatusFailed}},
+		ExpectedErrors:       []*clogs.LogError{courier.ErrorExternal("402", "Failed")},
+	},
+	{
+		Label:                "Status unknown",
+		URL:                  receiveURL,
+		Data:                 `{"type": "recipient_delivery_report_sms", "batch_id": "01FC66621XXXXX119Z8PMV1QPQ", "recipient": "12067799294", "code": 0, "status": "Unknown"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring status: Unknown",
+	},
+	{
+		Label:                "Status missing batch ID",
+		URL:                  receiveURL,
+		Data:                 `{"type": "recipient_delivery_report_sms", "status": "Delivered"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing one of 'batch_id' or 'status' in request body",
+	},
+	{
+		Label:                "Other event type",
+		URL:                  receiveURL,
+		Data:                 `{"type": "delivery_report_sms", "batch_id": "01FC66621XXXXX119Z8PMV1QPQ", "status": "Delivered"}`,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring event of type delivery_report_sms",
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), incomingCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), incomingCases)
+}
+
+var outgoingCases = []OutgoingTestCase{
+	{
+		Label:   "Plain send",
+		MsgText: "Simple Message ☺",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QPQ", "type": "mt_text"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{
+				"Content-Type":  "application/json",
+				"Accept":        "application/json",
+				"Authorization": "Bearer sesame",
+			},
+			Body: `{"from":"18444651185","to":["250788383383"],"body":"Simple Message ☺","delivery_report":"per_recipient","callback_url":"https://localhost/c/snc/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive","max_number_of_message_parts":10}`,
+		}},
+		ExpectedExtIDs: []string{"01FC66621XXXXX119Z8PMV1QPQ"},
+	},
+	{
+		Label:   "Long GSM7 send",
+		MsgText: strings.Repeat("1234567890", 154),
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QP1"}`)),
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QP2"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{BodyContains: `"body":"` + strings.Repeat("1234567890", 153) + `"`},
+			{BodyContains: `"body":"1234567890"`},
+		},
+		ExpectedExtIDs: []string{"01FC66621XXXXX119Z8PMV1QP1", "01FC66621XXXXX119Z8PMV1QP2"},
+	},
+	{
+		Label:          "Attachment sent as link",
+		MsgText:        "My pic!",
+		MsgURN:         "tel:+250788383383",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QPQ"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			BodyContains: `"body":"My pic!\nhttps://foo.bar/image.jpg"`,
+		}},
+		ExpectedExtIDs: []string{"01FC66621XXXXX119Z8PMV1QPQ"},
+	},
+	{
+		Label:   "Rejected",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(400, nil, []byte(`{"code": "syntax_invalid_parameter_format", "text": "Invalid from address"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrFailedWithReason("syntax_invalid_parameter_format", "Invalid from address"),
+	},
+	{
+		Label:   "Rate limited",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(429, nil, []byte(`{"code": "too_many_requests", "text": "Slow down"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionThrottled,
+	},
+	{
+		Label:   "Error status without code",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(401, nil, []byte(`{}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrResponseStatus,
+	},
+	{
+		Label:   "Missing batch ID",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"type": "mt_text"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrResponseUnexpected,
+	},
+	{
+		Label:   "Connection error",
+		MsgText: "Error Message",
+		MsgURN:  "tel:+250788383383",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://us.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(503, nil, []byte(`Service Unavailable`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+}
+
+var mmsOutgoingCases = []OutgoingTestCase{
+	{
+		Label:          "Attachments sent as MMS",
+		MsgText:        "My pics!",
+		MsgURN:         "tel:+12067799294",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image1.jpg", "image/jpeg:https://foo.bar/image2.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://eu.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QP1", "type": "mt_media"}`)),
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QP2", "type": "mt_media"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"from":"18444651185","to":["12067799294"],"type":"mt_media","body":{"url":"https://foo.bar/image1.jpg","message":"My pics!"},"delivery_report":"per_recipient","callback_url":"https://localhost/c/snc/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"}`},
+			{Body: `{"from":"18444651185","to":["12067799294"],"type":"mt_media","body":{"url":"https://foo.bar/image2.jpg"},"delivery_report":"per_recipient","callback_url":"https://localhost/c/snc/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"}`},
+		},
+		ExpectedExtIDs: []string{"01FC66621XXXXX119Z8PMV1QP1", "01FC66621XXXXX119Z8PMV1QP2"},
+	},
+	{
+		Label:   "Text only sent as SMS",
+		MsgText: "Hi there",
+		MsgURN:  "tel:+12067799294",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://eu.sms.api.sinch.com/xms/v1/plan123/batches": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id": "01FC66621XXXXX119Z8PMV1QPQ"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{BodyContains: `"body":"Hi there"`}},
+		ExpectedExtIDs:   []string{"01FC66621XXXXX119Z8PMV1QPQ"},
+	},
+}
+
+func TestOutgoing(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "SNC", "+18444651185", "US", []string{urns.Phone.Prefix}, map[string]any{"service_plan_id": "plan123", "auth_token": "sesame"})
+
+	RunOutgoingTestCases(t, ch, newHandler(), outgoingCases, []string{"sesame"}, nil)
+
+	ch = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "SNC", "+18444651185", "US", []string{urns.Phone.Prefix}, map[string]any{"service_plan_id": "plan123", "auth_token": "sesame", "region": "eu", "mms": true})
+
+	RunOutgoingTestCases(t, ch, newHandler(), mmsOutgoingCases, []string{"sesame"}, nil)
+}
+
+func TestOutgoingMissingConfig(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "SNC", "+18444651185", "US", []string{urns.Phone.Prefix}, map[string]any{"auth_token": "sesame"})
+
+	RunOutgoingTestCases(t, ch, newHandler(), []OutgoingTestCase{
+		{
+			Label:         "Missing service plan",
+			MsgText:       "Hi",
+			MsgURN:        "tel:+250788383383",
+			ExpectedError: courier.ErrChannelConfig,
+		},
+	}, []string{"sesame"}, nil)
+}
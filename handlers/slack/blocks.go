@@ -0,0 +1,55 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// maxBlocks is the maximum number of blocks Slack accepts in a single message
+const maxBlocks = 50
+
+// Block Kit layouts are requested by the message metadata, as built in the Block Kit Builder, e.g.
+//
+//	"blocks": [
+//	  {"type": "section", "text": {"type": "mrkdwn", "text": "Pick a color"}},
+//	  {"type": "actions", "elements": [{"type": "button", "text": {"type": "plain_text", "text": "Red"}, "value": "red"}]}
+//	]
+//
+// The message text is still sent alongside as Slack uses it for notifications.
+func getBlocks(metadata json.RawMessage) (json.RawMessage, error) {
+	raw, dataType, _, err := jsonparser.Get(metadata, "blocks")
+	if err != nil {
+		return nil, nil
+	}
+	if dataType != jsonparser.Array {
+		return nil, errors.New("blocks must be an array")
+	}
+
+	var blocks []json.RawMessage
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("unable to parse blocks: %w", err)
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("blocks must not be empty")
+	}
+	if len(blocks) > maxBlocks {
+		return nil, fmt.Errorf("too many blocks, max is %d", maxBlocks)
+	}
+
+	return raw, nil
+}
+
+// the thread an incoming message was sent in is recorded in its metadata, and replies are sent to the thread in their
+// metadata, e.g. "thread_ts": "1355517523.000005"
+func threadMetadata(threadTS string) json.RawMessage {
+	return jsonx.MustMarshal(map[string]string{"thread_ts": threadTS})
+}
+
+func getThreadTS(metadata json.RawMessage) string {
+	threadTS, _ := jsonparser.GetString(metadata, "thread_ts")
+	return threadTS
+}
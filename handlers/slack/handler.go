@@ -42,12 +42,14 @@ type handler struct {
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("SL"), "Slack", handlers.WithRedactConfigKeys(configBotToken, configUserToken, configValidationToken))}
+	// attachments are sent as file uploads which can be slow for larger media
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("SL"), "Slack", handlers.WithRedactConfigKeys(configBotToken, configUserToken, configValidationToken), handlers.WithRequestTimeout(time.Minute))}
 }
 
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, handlers.JSONPayload(h, h.receiveEvent))
+	s.AddHandlerRoute(h, http.MethodPost, "interaction", courier.ChannelLogTypeMsgReceive, h.receiveInteraction)
 	return nil
 }
 
@@ -74,7 +76,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 	if payload.Event.Type == "message" && payload.Event.BotID == "" && payload.Event.ChannelType == "im" {
 		clog.Type = courier.ChannelLogTypeMsgReceive
 
-		date := time.Unix(int64(payload.EventTime), 0)
+		date := handlers.ParseUnixTimestamp(int64(payload.EventTime), handlers.TimestampSeconds)
 
 		urn, err := urns.New(urns.Slack, payload.Event.User)
 		if err != nil {
@@ -94,6 +96,11 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		text := payload.Event.Text
 		msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.EventID, clog).WithReceivedOn(date)
 
+		// replies to messages in a thread should go to the same thread
+		if payload.Event.ThreadTS != "" {
+			msg.WithMetadata(threadMetadata(payload.Event.ThreadTS))
+		}
+
 		for _, attURL := range attachmentURLs {
 			msg.WithAttachment(attURL)
 		}
@@ -152,6 +159,12 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
+	blocks, err := getBlocks(msg.Metadata())
+	if err != nil {
+		clog.RawError(err)
+		return courier.ErrMessageInvalid
+	}
+
 	for _, attachment := range msg.Attachments() {
 		fileAttachment, err := h.parseAttachmentToFileParams(msg, attachment, clog)
 		if err != nil {
@@ -166,8 +179,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		}
 	}
 
-	if msg.Text() != "" {
-		err := h.sendTextMsgPart(msg, botToken, clog)
+	if msg.Text() != "" || blocks != nil {
+		err := h.sendTextMsgPart(msg, botToken, blocks, res, clog)
 		if err != nil {
 			return err
 		}
@@ -176,12 +189,15 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	return nil
 }
 
-func (h *handler) sendTextMsgPart(msg courier.MsgOut, token string, clog *courier.ChannelLog) error {
+func (h *handler) sendTextMsgPart(msg courier.MsgOut, token string, blocks json.RawMessage, res *courier.SendResult, clog *courier.ChannelLog) error {
 	sendURL := apiURL + "/chat.postMessage"
 
+	// text is used as the fallback for notifications when sending blocks
 	msgPayload := &mtPayload{
-		Channel: msg.URN().Path(),
-		Text:    msg.Text(),
+		Channel:  msg.URN().Path(),
+		Text:     msg.Text(),
+		Blocks:   blocks,
+		ThreadTS: getThreadTS(msg.Metadata()),
 	}
 
 	body, err := json.Marshal(msgPayload)
@@ -216,6 +232,10 @@ func (h *handler) sendTextMsgPart(msg courier.MsgOut, token string, clog *courie
 		clog.Error(clogs.NewLogError("", "", errDescription))
 		return courier.ErrFailedWithReason("", errDescription)
 	}
+
+	if ts, err := jsonparser.GetString(respBody, "ts"); err == nil {
+		res.AddExternalID(ts)
+	}
 	return nil
 }
 
@@ -320,8 +340,10 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 // mtPayload is a struct that represents the body of a SendMmsg text part.
 // https://api.slack.com/methods/chat.postMessage
 type mtPayload struct {
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
+	Channel  string          `json:"channel"`
+	Text     string          `json:"text"`
+	Blocks   json.RawMessage `json:"blocks,omitempty"`
+	ThreadTS string          `json:"thread_ts,omitempty"`
 }
 
 // moPayload is a struct that represents message payload from message type event.
@@ -333,6 +355,8 @@ type moPayload struct {
 		Channel     string `json:"channel,omitempty"`
 		User        string `json:"user,omitempty"`
 		Text        string `json:"text,omitempty"`
+		TS          string `json:"ts,omitempty"`
+		ThreadTS    string `json:"thread_ts,omitempty"`
 		ChannelType string `json:"channel_type,omitempty"`
 		Files       []File `json:"files"`
 		BotID       string `json:"bot_id,omitempty"`
@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -20,8 +22,9 @@ import (
 )
 
 const (
-	channelUUID = "8eb23e93-5ecb-45ba-b726-3b064e0c568c"
-	receiveURL  = "/c/sl/" + channelUUID + "/receive/"
+	channelUUID    = "8eb23e93-5ecb-45ba-b726-3b064e0c568c"
+	receiveURL     = "/c/sl/" + channelUUID + "/receive/"
+	interactionURL = "/c/sl/" + channelUUID + "/interaction/"
 )
 
 var testChannels = []courier.Channel{
@@ -49,6 +52,44 @@ const helloMsg = `{
 	"event_time": 1355517523
 }`
 
+const threadReplyMsg = `{
+	"token": "one-long-verification-token",
+	"team_id": "T061EG9R6",
+	"api_app_id": "A0PNCHHK2",
+	"event": {
+			"type": "message",
+			"channel": "U0123ABCDEF",
+			"user": "U0123ABCDEF",
+			"text": "Thanks!",
+			"ts": "1355517600.000008",
+			"thread_ts": "1355517523.000005",
+			"event_ts": "1355517600.000008",
+			"channel_type": "im"
+	},
+	"type": "event_callback",
+	"event_id": "Ev0PV52K22",
+	"event_time": 1355517600
+}`
+
+const secondThreadReplyMsg = `{
+	"token": "one-long-verification-token",
+	"team_id": "T061EG9R6",
+	"api_app_id": "A0PNCHHK2",
+	"event": {
+			"type": "message",
+			"channel": "U0123ABCDEF",
+			"user": "U0123ABCDEF",
+			"text": "One more thing",
+			"ts": "1355517660.000009",
+			"thread_ts": "1355517523.000005",
+			"event_ts": "1355517660.000009",
+			"channel_type": "im"
+	},
+	"type": "event_callback",
+	"event_id": "Ev0PV52K23",
+	"event_time": 1355517660
+}`
+
 const imageFileMsg = `{
 	"token": "Bwf82iq5kCEkHOzRQ7p4FqkQ",
 	"team_id": "T03CN5KTA6S",
@@ -136,6 +177,18 @@ var handleTestCases = []IncomingTestCase{
 		ExpectedBodyContains: "Accepted",
 		ExpectedExternalID:   "Ev0PV52K21",
 	},
+	{
+		Label:                "Receive thread reply",
+		URL:                  receiveURL,
+		Headers:              map[string]string{},
+		Data:                 threadReplyMsg,
+		ExpectedURN:          "slack:U0123ABCDEF",
+		ExpectedMsgText:      Sp("Thanks!"),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedExternalID:   "Ev0PV52K22",
+		ExpectedMsgMetadata:  `{"thread_ts": "1355517523.000005"}`,
+	},
 	{
 		Label:                "Receive image file",
 		URL:                  receiveURL,
@@ -200,6 +253,44 @@ var defaultSendTestCases = []OutgoingTestCase{
 			Body: `{"channel":"U0123ABCDEF","text":"☺"}`,
 		}},
 	},
+	{
+		Label:       "Send In Thread",
+		MsgText:     "Simple Reply",
+		MsgURN:      "slack:U0123ABCDEF",
+		MsgMetadata: `{"thread_ts":"1355517523.000005"}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/chat.postMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{"ok":true,"channel":"U0123ABCDEF","ts":"1355517600.000010"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `{"channel":"U0123ABCDEF","text":"Simple Reply","thread_ts":"1355517523.000005"}`,
+		}},
+		ExpectedExtIDs: []string{"1355517600.000010"},
+	},
+	{
+		Label:       "Send Blocks",
+		MsgText:     "Pick a color",
+		MsgURN:      "slack:U0123ABCDEF",
+		MsgMetadata: `{"blocks":[{"type":"section","text":{"type":"mrkdwn","text":"Pick a color"}},{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Red"},"value":"red"}]}]}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/chat.postMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{"ok":true,"channel":"U0123ABCDEF","ts":"1355517600.000010"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `{"channel":"U0123ABCDEF","text":"Pick a color","blocks":[{"type":"section","text":{"type":"mrkdwn","text":"Pick a color"}},{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Red"},"value":"red"}]}]}`,
+		}},
+		ExpectedExtIDs: []string{"1355517600.000010"},
+	},
+	{
+		Label:             "Send Invalid Blocks",
+		MsgText:           "Pick a color",
+		MsgURN:            "slack:U0123ABCDEF",
+		MsgMetadata:       `{"blocks":{"type":"section"}}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "blocks must be an array")},
+	},
 	{
 		Label:   "Send Text Auth Error",
 		MsgText: "Hello",
@@ -308,6 +399,93 @@ func TestVerification(t *testing.T) {
 	})
 }
 
+func TestThreadReplies(t *testing.T) {
+	// replies in the same thread are different messages, so mustn't be seen as duplicates, but both go to the thread
+	RunIncomingTestCases(t, testChannels, newHandler(), []IncomingTestCase{
+		{
+			Label:                "First reply in thread",
+			URL:                  receiveURL,
+			Data:                 threadReplyMsg,
+			ExpectedURN:          "slack:U0123ABCDEF",
+			ExpectedMsgText:      Sp("Thanks!"),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+			ExpectedExternalID:   "Ev0PV52K22",
+			ExpectedMsgMetadata:  `{"thread_ts": "1355517523.000005"}`,
+		},
+		{
+			Label:                "Second reply in thread",
+			URL:                  receiveURL,
+			Data:                 secondThreadReplyMsg,
+			ExpectedURN:          "slack:U0123ABCDEF",
+			ExpectedMsgText:      Sp("One more thing"),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+			ExpectedExternalID:   "Ev0PV52K23",
+			ExpectedMsgMetadata:  `{"thread_ts": "1355517523.000005"}`,
+		},
+	})
+}
+
+func TestInteraction(t *testing.T) {
+	interaction := func(payload string) string {
+		return url.Values{"payload": []string{payload}}.Encode()
+	}
+	formHeaders := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+
+	RunIncomingTestCases(t, testChannels, newHandler(), []IncomingTestCase{
+		{
+			Label:                "Button clicked",
+			URL:                  interactionURL,
+			Headers:              formHeaders,
+			Data:                 interaction(`{"type":"block_actions","token":"one-long-verification-token","user":{"id":"U0123ABCDEF"},"container":{"type":"message","message_ts":"1355517600.000010"},"actions":[{"type":"button","action_id":"color","value":"red","text":{"type":"plain_text","text":"Red"},"action_ts":"1355517700.123456"}]}`),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+			ExpectedURN:          "slack:U0123ABCDEF",
+			ExpectedMsgText:      Sp("red"),
+			ExpectedExternalID:   "1355517700.123456",
+			ExpectedMsgMetadata:  `{"thread_ts": "1355517600.000010"}`,
+			ExpectedDate:         time.Date(2012, 12, 14, 20, 41, 40, 123456000, time.UTC),
+		},
+		{
+			Label:                "Option selected in thread",
+			URL:                  interactionURL,
+			Headers:              formHeaders,
+			Data:                 interaction(`{"type":"block_actions","token":"one-long-verification-token","user":{"id":"U0123ABCDEF"},"container":{"type":"message","message_ts":"1355517600.000010","thread_ts":"1355517523.000005"},"actions":[{"type":"static_select","action_id":"color","selected_option":{"text":{"type":"plain_text","text":"Blue"},"value":"blue"},"action_ts":"1355517700.123456"}]}`),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+			ExpectedURN:          "slack:U0123ABCDEF",
+			ExpectedMsgText:      Sp("blue"),
+			ExpectedExternalID:   "1355517700.123456",
+			ExpectedMsgMetadata:  `{"thread_ts": "1355517523.000005"}`,
+			ExpectedDate:         time.Date(2012, 12, 14, 20, 41, 40, 123456000, time.UTC),
+		},
+		{
+			Label:                "Not a block action",
+			URL:                  interactionURL,
+			Headers:              formHeaders,
+			Data:                 interaction(`{"type":"view_submission","token":"one-long-verification-token","user":{"id":"U0123ABCDEF"}}`),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Ignoring request, not a block action",
+		},
+		{
+			Label:              "Invalid token",
+			URL:                interactionURL,
+			Headers:            formHeaders,
+			Data:               interaction(`{"type":"block_actions","token":"abc321","user":{"id":"U0123ABCDEF"},"actions":[{"value":"red"}]}`),
+			ExpectedRespStatus: 403,
+		},
+		{
+			Label:                "Invalid payload",
+			URL:                  interactionURL,
+			Headers:              formHeaders,
+			Data:                 interaction(`{`),
+			ExpectedRespStatus:   400,
+			ExpectedBodyContains: "unable to parse interaction payload",
+		},
+	})
+}
+
 func buildMockSlackService(testCases []IncomingTestCase) *httptest.Server {
 
 	files := make(map[string]File)
@@ -0,0 +1,108 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// moInteraction is the payload Slack posts when a user interacts with a Block Kit element, sent as a form encoded
+// payload field.
+// https://api.slack.com/reference/interaction-payloads/block-actions
+type moInteraction struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+	User  struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Container struct {
+		MessageTS string `json:"message_ts"`
+		ThreadTS  string `json:"thread_ts"`
+	} `json:"container"`
+	Actions []struct {
+		ActionID       string `json:"action_id"`
+		Value          string `json:"value"`
+		SelectedOption *struct {
+			Value string `json:"value"`
+		} `json:"selected_option"`
+		Text *struct {
+			Text string `json:"text"`
+		} `json:"text"`
+		ActionTS string `json:"action_ts"`
+	} `json:"actions"`
+}
+
+// receiveInteraction is our HTTP handler function for block actions, each of which becomes an incoming message whose
+// text is the value of the action
+func (h *handler) receiveInteraction(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	payload := &moInteraction{}
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), payload); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse interaction payload: %w", err))
+	}
+
+	if payload.Token != channel.StringConfigForKey(configValidationToken, "") {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, fmt.Errorf("wrong validation token for channel: %s", channel.UUID())
+	}
+
+	if payload.Type != "block_actions" {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, not a block action")
+	}
+
+	urn, err := urns.New(urns.Slack, payload.User.ID)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// replies should go to the thread of the message that was interacted with
+	threadTS := payload.Container.ThreadTS
+	if threadTS == "" {
+		threadTS = payload.Container.MessageTS
+	}
+
+	msgs := make([]courier.MsgIn, 0, len(payload.Actions))
+	for _, action := range payload.Actions {
+		text := action.Value
+		if text == "" && action.SelectedOption != nil {
+			text = action.SelectedOption.Value
+		}
+		if text == "" && action.Text != nil {
+			text = action.Text.Text
+		}
+		if text == "" {
+			continue
+		}
+
+		// each action is its own message so is identified by its own timestamp
+		msg := h.Backend().NewIncomingMsg(channel, urn, text, action.ActionTS, clog)
+		if threadTS != "" {
+			msg.WithMetadata(threadMetadata(threadTS))
+		}
+		if date, err := parseTS(action.ActionTS); err == nil {
+			msg.WithReceivedOn(date)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, no action values")
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, msgs, w, r, clog)
+}
+
+// parses a Slack timestamp like 1548426417.840180 which is seconds since the epoch with microseconds
+func parseTS(ts string) (time.Time, error) {
+	f, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(int64(f * 1e6)).UTC(), nil
+}
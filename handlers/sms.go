@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/gsm7"
+)
+
+// SMSEncoding is the encoding an SMS is sent with, which depends on the characters in it
+type SMSEncoding string
+
+// possible SMS encodings
+const (
+	SMSEncodingGSM7 SMSEncoding = "gsm7"
+	SMSEncodingUCS2 SMSEncoding = "ucs2"
+)
+
+// GSM-7 characters which are sent as an escape followed by the character so take up two septets
+const gsm7Extended = "\f^{}\\[~]|€"
+
+// returns the number of units, i.e. septets for GSM-7 and 16-bit code units for UCS-2, which the given rune takes up
+func (e SMSEncoding) units(r rune) int {
+	if e == SMSEncodingGSM7 {
+		if strings.ContainsRune(gsm7Extended, r) {
+			return 2
+		}
+		return 1
+	}
+	if r > 0xFFFF {
+		return 2 // surrogate pair, e.g. most emoji
+	}
+	return 1
+}
+
+// returns the number of units in a single SMS, and in each segment of a multipart SMS which loses some to the header
+// used to reassemble it
+func (e SMSEncoding) limits() (int, int) {
+	if e == SMSEncodingGSM7 {
+		return 160, 153
+	}
+	return 70, 67
+}
+
+// smsCounter counts the segments needed to send text as it's added a rune at a time. Characters which take up two units
+// can't be split across segments.
+type smsCounter struct {
+	encoding     SMSEncoding
+	units        int
+	segments     int
+	segmentUnits int
+}
+
+func newSMSCounter(encoding SMSEncoding) *smsCounter {
+	return &smsCounter{encoding: encoding, segments: 1}
+}
+
+func (c *smsCounter) add(r rune) {
+	_, multi := c.encoding.limits()
+	n := c.encoding.units(r)
+
+	c.units += n
+	if c.segmentUnits+n > multi {
+		c.segments++
+		c.segmentUnits = n
+	} else {
+		c.segmentUnits += n
+	}
+}
+
+func (c *smsCounter) count() int {
+	single, _ := c.encoding.limits()
+	if c.units == 0 {
+		return 0
+	} else if c.units <= single {
+		return 1
+	}
+	return c.segments
+}
+
+// SMSSegments returns the encoding the given text will be sent with as an SMS and how many segments that will take
+func SMSSegments(text string) (SMSEncoding, int) {
+	encoding := smsEncoding(text)
+	counter := newSMSCounter(encoding)
+	for _, r := range text {
+		counter.add(r)
+	}
+	return encoding, counter.count()
+}
+
+// AddSMSSegments adds the number of segments the given text takes up as an SMS to the result of sending a message, for
+// SMS handlers to call for each part they send
+func AddSMSSegments(res *courier.SendResult, text string) {
+	_, segments := SMSSegments(text)
+	res.AddSegments(segments)
+}
+
+// SplitSMS splits the given text into parts which can each be sent as an SMS of at most the given number of segments,
+// splitting on whitespace where possible
+func SplitSMS(text string, maxSegments int) []string {
+	maxSegments = max(maxSegments, 1)
+	encoding := smsEncoding(text)
+
+	if _, segments := SMSSegments(text); segments <= maxSegments {
+		return []string{text}
+	}
+
+	runes := []rune(text)
+	parts := make([]string, 0, 2)
+
+	for start := 0; start < len(runes); {
+		counter := newSMSCounter(encoding)
+		end, lastSpace := start, -1
+
+		for ; end < len(runes); end++ {
+			counter.add(runes[end])
+			if counter.count() > maxSegments {
+				break
+			}
+			if unicode.IsSpace(runes[end]) {
+				lastSpace = end
+			}
+		}
+
+		// if this isn't the last part, end it on whitespace unless that means losing more than half of it
+		if end < len(runes) && lastSpace > start+(end-start)/2 {
+			end = lastSpace + 1
+		}
+
+		if part := strings.TrimSpace(string(runes[start:end])); part != "" {
+			parts = append(parts, part)
+		}
+		start = end
+	}
+
+	return parts
+}
+
+// characters which aren't in GSM-7 but have lookalikes which are, in addition to those replaced by
+// gsm7.ReplaceSubstitutions
+var smsTransliterations = map[rune]string{
+	'«': `"`, '»': `"`, '„': `"`, '″': `"`, '‚': ",", '‹': "'", '›': "'", '′': "'", '`': "'",
+	'—': "-", '―': "-", '‒': "-", '‐': "-", '‑': "-", '−': "-", '•': "-",
+	'…': "...", '×': "x", '÷': "/",
+	'\u2002': " ", '\u2003': " ", '\u2009': " ", '\u200a': " ", '\u202f': " ", '\u3000': " ", // unusual spaces
+	'\u200b': "", '\u200c': "", '\u200d': "", '\ufeff': "", // zero width characters
+	'❗': "!", '❕': "!", '❓': "?", '❔': "?", '➖': "-", '➕': "+",
+}
+
+// TransliterateSMS replaces characters which aren't in GSM-7 with lookalikes which are, e.g. curly quotes with straight
+// ones, if that means the text can be sent as GSM-7 rather than UCS-2 which only fits 70 characters in an SMS.
+// Otherwise the text is returned unchanged.
+func TransliterateSMS(text string) string {
+	if gsm7.IsValid(text) {
+		return text
+	}
+
+	var b strings.Builder
+	for _, r := range gsm7.ReplaceSubstitutions(text) {
+		if s, ok := smsTransliterations[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	if replaced := b.String(); gsm7.IsValid(replaced) {
+		return replaced
+	}
+	return text
+}
+
+func smsEncoding(text string) SMSEncoding {
+	if gsm7.IsValid(text) {
+		return SMSEncodingGSM7
+	}
+	return SMSEncodingUCS2
+}
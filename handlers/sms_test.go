@@ -0,0 +1,77 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMSSegments(t *testing.T) {
+	tcs := []struct {
+		text             string
+		expectedEncoding handlers.SMSEncoding
+		expectedSegments int
+	}{
+		{"", handlers.SMSEncodingGSM7, 0},
+		{"Hello", handlers.SMSEncodingGSM7, 1},
+		{strings.Repeat("a", 160), handlers.SMSEncodingGSM7, 1},
+		{strings.Repeat("a", 161), handlers.SMSEncodingGSM7, 2},
+		{strings.Repeat("a", 306), handlers.SMSEncodingGSM7, 2},
+		{strings.Repeat("a", 307), handlers.SMSEncodingGSM7, 3},
+		{strings.Repeat("€", 80), handlers.SMSEncodingGSM7, 1}, // extended chars take two septets
+		{strings.Repeat("€", 81), handlers.SMSEncodingGSM7, 2}, // ...
+		{strings.Repeat("a", 151) + "€" + strings.Repeat("a", 153), handlers.SMSEncodingGSM7, 2},
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), handlers.SMSEncodingGSM7, 3}, // ...and can't be split across segments
+		{"Hello “world”", handlers.SMSEncodingUCS2, 1},
+		{strings.Repeat("ф", 70), handlers.SMSEncodingUCS2, 1},
+		{strings.Repeat("ф", 71), handlers.SMSEncodingUCS2, 2},
+		{strings.Repeat("😀", 35), handlers.SMSEncodingUCS2, 1}, // emoji are surrogate pairs
+		{strings.Repeat("😀", 36), handlers.SMSEncodingUCS2, 2},
+	}
+
+	for _, tc := range tcs {
+		encoding, segments := handlers.SMSSegments(tc.text)
+		assert.Equal(t, tc.expectedEncoding, encoding, "encoding mismatch for %q", tc.text)
+		assert.Equal(t, tc.expectedSegments, segments, "segments mismatch for %q", tc.text)
+	}
+}
+
+func TestSplitSMS(t *testing.T) {
+	assert.Equal(t, []string{""}, handlers.SplitSMS("", 1))
+	assert.Equal(t, []string{"Hello world"}, handlers.SplitSMS("Hello world", 1))
+	assert.Equal(t, []string{strings.Repeat("a", 161)}, handlers.SplitSMS(strings.Repeat("a", 161), 2))
+	assert.Equal(t, []string{strings.Repeat("a", 160), "a"}, handlers.SplitSMS(strings.Repeat("a", 161), 1))
+	assert.Equal(t, []string{strings.Repeat("€", 80), "€"}, handlers.SplitSMS(strings.Repeat("€", 81), 1))
+	assert.Equal(t, []string{strings.Repeat("ф", 70), "ф"}, handlers.SplitSMS(strings.Repeat("ф", 71), 1))
+	assert.Equal(t, []string{strings.Repeat("😀", 35), "😀"}, handlers.SplitSMS(strings.Repeat("😀", 36), 1))
+
+	// splits on whitespace where possible
+	words := strings.Repeat("hello ", 30)
+	parts := handlers.SplitSMS(words, 1)
+	assert.Equal(t, []string{strings.TrimSpace(strings.Repeat("hello ", 26)), strings.TrimSpace(strings.Repeat("hello ", 4))}, parts)
+	for _, part := range parts {
+		_, segments := handlers.SMSSegments(part)
+		assert.Equal(t, 1, segments)
+	}
+}
+
+func TestTransliterateSMS(t *testing.T) {
+	tcs := []struct {
+		text     string
+		expected string
+	}{
+		{"Hello world", "Hello world"},
+		{"“Hello” ‘world’", `"Hello" 'world'`},
+		{"Wait… it’s 5—10 minutes", "Wait... it's 5-10 minutes"},
+		{"Yes❗ Really❓", "Yes! Really?"},
+		{"zero​width", "zerowidth"},
+		{"“Hello” 😀", "“Hello” 😀"}, // unchanged because it can't become GSM-7 anyway
+		{"Привет “мир”", "Привет “мир”"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, handlers.TransliterateSMS(tc.text), "transliteration mismatch for %q", tc.text)
+	}
+}
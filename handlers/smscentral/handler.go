@@ -74,7 +74,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		"user":    []string{username},
 		"pass":    []string{password},
 		"mobile":  []string{strings.TrimPrefix(msg.URN().Path(), "+")},
-		"content": []string{handlers.GetTextAndAttachments(msg)},
+		"content": []string{h.TextAndAttachments(msg)},
 	}
 
 	req, err := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
@@ -90,5 +90,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseStatus
 	}
 
+	handlers.AddSMSSegments(res, form.Get("content"))
+
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"slices"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/nyaruka/courier"
 )
@@ -42,8 +43,9 @@ func SplitMsg(m courier.MsgOut, opts SplitOptions) []MsgPart {
 		return []MsgPart{{Type: MsgPartTypeOptIn, Text: text, OptIn: m.OptIn(), IsFirst: true, IsLast: true}}
 	}
 
-	// if we have a single attachment and text we may be able to combine them into a captioned attachment
-	if len(attachments) == 1 && len(text) > 0 && (len(text) <= opts.MaxCaptionLen || opts.MaxCaptionLen == 0) {
+	// if we have a single attachment and text we may be able to combine them into a captioned attachment, otherwise
+	// the text overflows into separate text parts after the attachment
+	if len(attachments) == 1 && len(text) > 0 && (opts.MaxCaptionLen == 0 || utf8.RuneCountInString(text) <= opts.MaxCaptionLen) {
 		att := attachments[0]
 		mediaType, _ := SplitAttachment(att)
 		mediaType = strings.Split(mediaType, "/")[0]
@@ -73,6 +75,13 @@ func SplitMsg(m courier.MsgOut, opts SplitOptions) []MsgPart {
 
 // deprecated use SplitMsg instead
 func SplitMsgByChannel(channel courier.Channel, text string, maxLength int) []string {
+	if channel.BoolConfigForKey(courier.ConfigSmartEncoding, false) {
+		text = TransliterateSMS(text)
+	}
+	if maxSegments := channel.IntConfigForKey(courier.ConfigMaxSegments, 0); maxSegments > 0 {
+		return SplitSMS(text, maxSegments)
+	}
+
 	max := channel.IntConfigForKey(courier.ConfigMaxLength, maxLength)
 
 	return SplitText(text, max)
@@ -1,6 +1,7 @@
 package handlers_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/nyaruka/courier"
@@ -41,6 +42,28 @@ func TestSplitMsg(t *testing.T) {
 				{Type: handlers.MsgPartTypeCaptionedAttachment, Text: "Lovely image", Attachment: "image/jpeg:http://test.jpg", IsFirst: true, IsLast: true},
 			},
 		},
+		{
+			msg:  test.NewMockMsg(1001, "b6454f25-e5b9-4795-a180-b9e35ca3a523", channel, "tel+1234567890", "Lovely image", []string{"image/jpeg:http://test.jpg"}),
+			opts: handlers.SplitOptions{MaxTextLen: 20, MaxCaptionLen: 12, Captionable: []handlers.MediaType{handlers.MediaTypeImage}},
+			expectedParts: []handlers.MsgPart{
+				{Type: handlers.MsgPartTypeCaptionedAttachment, Text: "Lovely image", Attachment: "image/jpeg:http://test.jpg", IsFirst: true, IsLast: true},
+			},
+		},
+		{
+			msg:  test.NewMockMsg(1001, "b6454f25-e5b9-4795-a180-b9e35ca3a523", channel, "tel+1234567890", "Lovely imagé", []string{"image/jpeg:http://test.jpg"}),
+			opts: handlers.SplitOptions{MaxTextLen: 20, MaxCaptionLen: 12, Captionable: []handlers.MediaType{handlers.MediaTypeImage}},
+			expectedParts: []handlers.MsgPart{
+				{Type: handlers.MsgPartTypeCaptionedAttachment, Text: "Lovely imagé", Attachment: "image/jpeg:http://test.jpg", IsFirst: true, IsLast: true},
+			},
+		},
+		{
+			msg:  test.NewMockMsg(1001, "b6454f25-e5b9-4795-a180-b9e35ca3a523", channel, "tel+1234567890", "Lovely images", []string{"image/jpeg:http://test.jpg"}),
+			opts: handlers.SplitOptions{MaxTextLen: 20, MaxCaptionLen: 12, Captionable: []handlers.MediaType{handlers.MediaTypeImage}},
+			expectedParts: []handlers.MsgPart{
+				{Type: handlers.MsgPartTypeAttachment, Attachment: "image/jpeg:http://test.jpg", IsFirst: true},
+				{Type: handlers.MsgPartTypeText, Text: "Lovely images", IsLast: true},
+			},
+		},
 	}
 
 	for _, tc := range tcs {
@@ -75,6 +98,15 @@ func TestSplitMsgByChannel(t *testing.T) {
 	assert.Equal(t, []string{"This is a message longer", "than 10"}, handlers.SplitMsgByChannel(channelWithMaxLength, "This is a message longer than 10", 20))
 	assert.Equal(t, []string{" "}, handlers.SplitMsgByChannel(channelWithMaxLength, " ", 20))
 	assert.Equal(t, []string{"This is a message", "longer than 10"}, handlers.SplitMsgByChannel(channelWithMaxLength, "This is a message   longer than 10", 20))
+
+	// channels can be configured to split by SMS segments and to transliterate to GSM-7
+	var channelWithMaxSegments = test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AC", "2020", "US", []string{urns.Phone.Prefix},
+		map[string]any{courier.ConfigMaxSegments: 1, courier.ConfigSmartEncoding: true})
+
+	assert.Equal(t, []string{"Simple message"}, handlers.SplitMsgByChannel(channelWithMaxSegments, "Simple message", 20))
+	assert.Equal(t, []string{`"Quoted" message`}, handlers.SplitMsgByChannel(channelWithMaxSegments, "“Quoted” message", 20))
+	assert.Equal(t, []string{strings.Repeat("a", 160)}, handlers.SplitMsgByChannel(channelWithMaxSegments, strings.Repeat("a", 160), 20))
+	assert.Equal(t, []string{strings.Repeat("a", 160), "a"}, handlers.SplitMsgByChannel(channelWithMaxSegments, strings.Repeat("a", 161), 20))
 }
 
 func TestSplitText(t *testing.T) {
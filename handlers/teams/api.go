@@ -0,0 +1,90 @@
+package teams
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+// Activity is the Bot Framework representation of messages and other events in a conversation
+// https://learn.microsoft.com/en-us/azure/bot-service/rest-api/bot-framework-rest-connector-api-reference#activity-object
+type Activity struct {
+	Type             string               `json:"type"`
+	ID               string               `json:"id,omitempty"`
+	Timestamp        *time.Time           `json:"timestamp,omitempty"`
+	ServiceURL       string               `json:"serviceUrl,omitempty"`
+	ChannelID        string               `json:"channelId,omitempty"`
+	From             *ChannelAccount      `json:"from,omitempty"         validate:"required"`
+	Conversation     *ConversationAccount `json:"conversation,omitempty" validate:"required"`
+	Recipient        *ChannelAccount      `json:"recipient,omitempty"`
+	Text             string               `json:"text,omitempty"`
+	Attachments      []ActivityAttachment `json:"attachments,omitempty"`
+	SuggestedActions *SuggestedActions    `json:"suggestedActions,omitempty"`
+	MembersAdded     []ChannelAccount     `json:"membersAdded,omitempty"`
+	ReplyToID        string               `json:"replyToId,omitempty"`
+}
+
+// returns whether this activity adds the given account to the conversation
+func (a *Activity) addsMember(id string) bool {
+	for _, m := range a.MembersAdded {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelAccount is a user or bot in a conversation
+type ChannelAccount struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	AADObjectID string `json:"aadObjectId,omitempty"`
+}
+
+// ConversationAccount is a conversation
+type ConversationAccount struct {
+	ID               string `json:"id"`
+	ConversationType string `json:"conversationType,omitempty"`
+	TenantID         string `json:"tenantId,omitempty"`
+}
+
+// ActivityAttachment is a media attachment or card
+type ActivityAttachment struct {
+	ContentType string          `json:"contentType"`
+	ContentURL  string          `json:"contentUrl,omitempty"`
+	Content     json.RawMessage `json:"content,omitempty"`
+	Name        string          `json:"name,omitempty"`
+}
+
+// returns the URL of the media of this attachment or empty string if it doesn't have any
+func (a *ActivityAttachment) mediaURL() string {
+	switch a.ContentType {
+	case attachmentTypeHTML:
+		return ""
+	case attachmentTypeFileDownload:
+		downloadURL, _ := jsonparser.GetString(a.Content, "downloadUrl")
+		return downloadURL
+	}
+	return a.ContentURL
+}
+
+// SuggestedActions are shown as buttons which disappear once one is tapped
+type SuggestedActions struct {
+	Actions []CardAction `json:"actions"`
+}
+
+// CardAction is a clickable action
+type CardAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// ConversationParams is the payload to create a new conversation
+type ConversationParams struct {
+	Bot         ChannelAccount   `json:"bot"`
+	Members     []ChannelAccount `json:"members"`
+	TenantID    string           `json:"tenantId,omitempty"`
+	ChannelData map[string]any   `json:"channelData,omitempty"`
+}
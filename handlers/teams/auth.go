@@ -0,0 +1,220 @@
+package teams
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+var (
+	keysURL     = "https://login.botframework.com/v1/.well-known/keys"
+	keysTTL     = time.Hour * 24
+	tokenIssuer = "https://api.botframework.com"
+)
+
+// signingKey is a public key used by the Bot Framework to sign the tokens it sends us
+type signingKey struct {
+	key          *rsa.PublicKey
+	endorsements []string // the channels, e.g. msteams, that the key may be used for
+}
+
+// keyCache holds the signing keys which the Bot Framework rotates regularly
+type keyCache struct {
+	mutex     sync.Mutex
+	keys      map[string]*signingKey
+	fetchedOn time.Time
+}
+
+// validateToken checks the JWT in the given Authorization header was issued by the Bot Framework for our bot
+// see https://learn.microsoft.com/en-us/azure/bot-service/rest-api/bot-framework-rest-connector-authentication
+func (h *handler) validateToken(channel courier.Channel, header, serviceURL, channelID string, clog *courier.ChannelLog) error {
+	tokenString, found := strings.CutPrefix(header, "Bearer ")
+	if !found || tokenString == "" {
+		return errors.New("missing or invalid Authorization header")
+	}
+
+	var endorsements []string
+
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := h.signingKey(kid, clog)
+		if err != nil {
+			return nil, err
+		}
+		endorsements = key.endorsements
+		return key.key, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(tokenIssuer), jwt.WithAudience(channel.Address()), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute*5))
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+
+	if len(endorsements) > 0 && !slices.Contains(endorsements, channelID) {
+		return fmt.Errorf("signing key not endorsed for channel %s", channelID)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claimedURL, _ := claims["serviceurl"].(string); claimedURL != "" && claimedURL != serviceURL {
+		return errors.New("service URL doesn't match token")
+	}
+
+	return nil
+}
+
+// signingKey gets the signing key with the given ID, fetching keys if we don't have it or ours are stale
+func (h *handler) signingKey(kid string, clog *courier.ChannelLog) (*signingKey, error) {
+	h.keys.mutex.Lock()
+	defer h.keys.mutex.Unlock()
+
+	key := h.keys.keys[kid]
+	if key == nil || time.Since(h.keys.fetchedOn) > keysTTL {
+		keys, err := h.fetchSigningKeys(clog)
+		if err != nil {
+			return nil, err
+		}
+		h.keys.keys = keys
+		h.keys.fetchedOn = time.Now()
+
+		key = keys[kid]
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty          string   `json:"kty"`
+	Kid          string   `json:"kid"`
+	N            string   `json:"n"`
+	E            string   `json:"e"`
+	Endorsements []string `json:"endorsements"`
+}
+
+func (h *handler) fetchSigningKeys(clog *courier.ChannelLog) (map[string]*signingKey, error) {
+	req, _ := http.NewRequest(http.MethodGet, keysURL, nil)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return nil, errors.New("unable to fetch signing keys")
+	}
+
+	set := &struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(respBody, set); err != nil {
+		return nil, fmt.Errorf("unable to parse signing keys: %w", err)
+	}
+
+	keys := make(map[string]*signingKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &signingKey{
+			key:          &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())},
+			endorsements: k.Endorsements,
+		}
+	}
+	return keys, nil
+}
+
+// getAccessToken gets the token used to authenticate our requests to the Bot Connector service, fetching a new one if
+// we don't have one cached
+func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
+	tokenKey := fmt.Sprintf("channel-token:%s", channel.UUID())
+
+	h.fetchTokenMutex.Lock()
+	defer h.fetchTokenMutex.Unlock()
+
+	var token string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		token, err = redis.String(rc.Do("GET", tokenKey))
+	})
+
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading cached access token: %w", err)
+	}
+
+	if token != "" {
+		return token, nil
+	}
+
+	token, expires, err := h.fetchAccessToken(channel, clog)
+	if err != nil {
+		return "", err
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("SET", tokenKey, token, "EX", int(expires/time.Second))
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error updating cached access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// fetchAccessToken fetches a new token using the app ID and password of the bot
+func (h *handler) fetchAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type":    []string{"client_credentials"},
+		"client_id":     []string{channel.Address()},
+		"client_secret": []string{channel.StringConfigForKey(configAppPassword, "")},
+		"scope":         []string{tokenScope},
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return "", 0, courier.ErrConnectionFailed
+	} else if resp.StatusCode/100 != 2 {
+		code, _ := jsonparser.GetString(respBody, "error")
+		message, _ := jsonparser.GetString(respBody, "error_description")
+		clog.Error(courier.ErrorExternal(code, message))
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	token, err := jsonparser.GetString(respBody, "access_token")
+	if err != nil {
+		clog.Error(courier.ErrorResponseValueMissing("access_token"))
+		return "", 0, courier.ErrResponseUnexpected
+	}
+
+	// expire our cached token a little early so that it's never used after it expires
+	expiration, err := jsonparser.GetInt(respBody, "expires_in")
+	if err != nil || expiration <= 300 {
+		expiration = 3600
+	}
+
+	return token, time.Second * time.Duration(expiration-300), nil
+}
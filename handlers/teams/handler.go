@@ -0,0 +1,281 @@
+package teams
+
+/*
+Handles Microsoft Teams and other channels of the Azure Bot Framework. The channel address is the bot's app ID and it
+is configured with the app password and, for starting conversations, the tenant ID of the organization.
+
+Activities posted to our webhook are authenticated with a JWT signed by the Bot Framework. Replies are posted back to
+the service URL of the conversation, which along with the conversation ID is stored as the contact's URN auth token.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	configAppPassword = "app_password"
+	configTenantID    = "tenant_id"
+	configServiceURL  = "service_url"
+
+	// Teams includes an HTML version of the message text as an attachment which we don't want
+	attachmentTypeHTML = "text/html"
+	// files shared in personal chats come as download info attachments
+	attachmentTypeFileDownload = "application/vnd.microsoft.teams.file.download.info"
+)
+
+var (
+	tokenURL          = "https://login.microsoftonline.com/botframework.com/oauth2/v2.0/token"
+	tokenScope        = "https://api.botframework.com/.default"
+	defaultServiceURL = "https://smba.trafficmanager.net/teams/"
+	maxMsgLength      = 28000
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	fetchTokenMutex sync.Mutex
+	keys            *keyCache
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{
+		BaseHandler: handlers.NewBaseHandler(courier.ChannelType("TM"), "Teams", handlers.WithRedactConfigKeys(configAppPassword)),
+		keys:        &keyCache{},
+	}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeUnknown, h.receiveActivity)
+	return nil
+}
+
+// conversation is what we need to post activities to a conversation and is stored as the URN auth token
+type conversation struct {
+	ServiceURL     string `json:"service_url"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// receiveActivity is our HTTP handler function for activities posted by the Bot Framework
+func (h *handler) receiveActivity(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	payload := &Activity{}
+	if err := handlers.DecodeAndValidateJSON(payload, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	if err := h.validateToken(channel, r.Header.Get("Authorization"), payload.ServiceURL, payload.ChannelID, clog); err != nil {
+		return nil, courier.WriteAndLogUnauthorized(w, r, channel, err)
+	}
+
+	urn, err := urns.New(urns.External, payload.From.ID)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	authTokens := map[string]string{"default": string(jsonx.MustMarshal(&conversation{ServiceURL: payload.ServiceURL, ConversationID: payload.Conversation.ID}))}
+	date := time.Now().UTC()
+	if payload.Timestamp != nil {
+		date = *payload.Timestamp
+	}
+
+	switch payload.Type {
+	case "message":
+		clog.Type = courier.ChannelLogTypeMsgReceive
+
+		msg := h.Backend().NewIncomingMsg(channel, urn, payload.Text, payload.ID, clog).WithReceivedOn(date).WithContactName(payload.From.Name).WithURNAuthTokens(authTokens)
+
+		for _, att := range payload.Attachments {
+			if attURL := att.mediaURL(); attURL != "" {
+				msg.WithAttachment(attURL)
+			}
+		}
+
+		return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+
+	case "conversationUpdate":
+		// we only care about our bot being added to a conversation, e.g. when a user installs the app
+		if payload.Recipient == nil || !payload.addsMember(payload.Recipient.ID) {
+			return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring conversation update")
+		}
+
+		clog.Type = courier.ChannelLogTypeEventReceive
+
+		event := h.Backend().NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithOccurredOn(date).WithContactName(payload.From.Name).WithURNAuthTokens(authTokens)
+		if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+			return nil, err
+		}
+
+		return []courier.Event{event}, courier.WriteChannelEventSuccess(w, event)
+	}
+
+	return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring activity of type %s", payload.Type))
+}
+
+// Send sends the given message, logging any HTTP calls or errors
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	if msg.Channel().StringConfigForKey(configAppPassword, "") == "" {
+		return courier.ErrChannelConfig
+	}
+
+	token, err := h.getAccessToken(msg.Channel(), clog)
+	if err != nil {
+		return err
+	}
+
+	// if we've not heard from this contact before, we need to start a conversation with them
+	conv := &conversation{}
+	if msg.URNAuth() != "" {
+		if err := json.Unmarshal([]byte(msg.URNAuth()), conv); err != nil {
+			return courier.ErrMessageInvalid
+		}
+	} else {
+		conv, err = h.createConversation(msg, token, clog)
+		if err != nil {
+			return err
+		}
+	}
+
+	activities := make([]*Activity, 0, 2)
+
+	parts := handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLength)
+	for _, part := range parts {
+		if part != "" {
+			activities = append(activities, &Activity{Type: "message", Text: part})
+		}
+	}
+
+	if len(msg.Attachments()) > 0 {
+		act := &Activity{Type: "message"}
+		for _, a := range msg.Attachments() {
+			mediaType, mediaURL := handlers.SplitAttachment(a)
+			act.Attachments = append(act.Attachments, ActivityAttachment{ContentType: mediaType, ContentURL: mediaURL, Name: filenameFromURL(mediaURL)})
+		}
+		activities = append(activities, act)
+	}
+
+	if len(activities) == 0 {
+		return courier.ErrMessageInvalid
+	}
+
+	// quick replies are shown as suggested actions on the last activity
+	if qrs := msg.QuickReplies(); len(qrs) > 0 {
+		last := activities[len(activities)-1]
+		last.SuggestedActions = &SuggestedActions{}
+		for _, qr := range qrs {
+			last.SuggestedActions.Actions = append(last.SuggestedActions.Actions, CardAction{Type: "imBack", Title: qr, Value: qr})
+		}
+	}
+
+	for _, act := range activities {
+		act.ReplyToID = msg.ResponseToExternalID()
+
+		id, err := h.postActivity(conv, act, token, clog)
+		if err != nil {
+			return err
+		}
+		res.AddExternalID(id)
+	}
+
+	return nil
+}
+
+// posts the given activity to the given conversation, returning the ID of the new activity
+func (h *handler) postActivity(conv *conversation, act *Activity, token string, clog *courier.ChannelLog) (string, error) {
+	sendURL := fmt.Sprintf("%s/v3/conversations/%s/activities", strings.TrimSuffix(conv.ServiceURL, "/"), url.PathEscape(conv.ConversationID))
+	if act.ReplyToID != "" {
+		sendURL += "/" + url.PathEscape(act.ReplyToID)
+	}
+
+	respBody, err := h.requestConnector(sendURL, act, token, clog)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := jsonparser.GetString(respBody, "id")
+	if err != nil {
+		return "", courier.ErrResponseUnexpected
+	}
+	return id, nil
+}
+
+// creates a new personal conversation with the contact so that we can message them proactively
+func (h *handler) createConversation(msg courier.MsgOut, token string, clog *courier.ChannelLog) (*conversation, error) {
+	ch := msg.Channel()
+	serviceURL := ch.StringConfigForKey(configServiceURL, defaultServiceURL)
+	tenantID := ch.StringConfigForKey(configTenantID, "")
+	if tenantID == "" {
+		return nil, courier.ErrChannelConfig
+	}
+
+	payload := &ConversationParams{
+		Bot:         ChannelAccount{ID: ch.Address()},
+		Members:     []ChannelAccount{{ID: msg.URN().Path()}},
+		TenantID:    tenantID,
+		ChannelData: map[string]any{"tenant": map[string]string{"id": tenantID}},
+	}
+
+	respBody, err := h.requestConnector(strings.TrimSuffix(serviceURL, "/")+"/v3/conversations", payload, token, clog)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := jsonparser.GetString(respBody, "id")
+	if err != nil {
+		return nil, courier.ErrResponseUnexpected
+	}
+	return &conversation{ServiceURL: serviceURL, ConversationID: id}, nil
+}
+
+func (h *handler) requestConnector(reqURL string, payload any, token string, clog *courier.ChannelLog) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(jsonx.MustMarshal(payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return nil, courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, courier.ErrConnectionThrottled
+	} else if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+		// the user has blocked or uninstalled the bot or the conversation no longer exists
+		code, _ := jsonparser.GetString(respBody, "error", "code")
+		message, _ := jsonparser.GetString(respBody, "error", "message")
+		clog.Error(courier.ErrorExternal(code, message))
+		return nil, courier.ErrFailedWithReason(code, message)
+	} else if resp.StatusCode/100 != 2 {
+		return nil, courier.ErrResponseStatus
+	}
+
+	return respBody, nil
+}
+
+func filenameFromURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Path[strings.LastIndex(parsed.Path, "/")+1:]
+}
@@ -0,0 +1,394 @@
+package teams
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	channelUUID = "8eb23e93-5ecb-45ba-b726-3b064e0c56ab"
+	receiveURL  = "/c/tm/" + channelUUID + "/receive/"
+	appID       = "5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"
+	serviceURL  = "https://smba.trafficmanager.net/amer/"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel(channelUUID, "TM", appID, "", []string{urns.External.Prefix}, map[string]any{configAppPassword: "sesame", configTenantID: "tenant123"}),
+}
+
+const helloMsg = `{
+	"type": "message",
+	"id": "1728990000000",
+	"timestamp": "2024-10-15T10:20:00.123Z",
+	"serviceUrl": "https://smba.trafficmanager.net/amer/",
+	"channelId": "msteams",
+	"from": {"id": "29:1Xq8xPmgpZ", "name": "Bob Smith", "aadObjectId": "3f1c0b7e-aa22"},
+	"conversation": {"conversationType": "personal", "tenantId": "tenant123", "id": "a:1RtJ7kfXnWw"},
+	"recipient": {"id": "28:5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84", "name": "Courier Bot"},
+	"text": "Hello World",
+	"attachments": [{"contentType": "text/html", "content": "<div>Hello World</div>"}]
+}`
+
+const attachmentsMsg = `{
+	"type": "message",
+	"id": "1728990000001",
+	"timestamp": "2024-10-15T10:20:00.123Z",
+	"serviceUrl": "https://smba.trafficmanager.net/amer/",
+	"channelId": "msteams",
+	"from": {"id": "29:1Xq8xPmgpZ", "name": "Bob Smith"},
+	"conversation": {"conversationType": "personal", "id": "a:1RtJ7kfXnWw"},
+	"recipient": {"id": "28:5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"},
+	"text": "",
+	"attachments": [
+		{"contentType": "image/png", "contentUrl": "https://smba.trafficmanager.net/amer/v3/attachments/abc/views/original", "name": "cat.png"},
+		{"contentType": "application/vnd.microsoft.teams.file.download.info", "content": {"downloadUrl": "https://contoso.sharepoint.com/report.pdf", "fileType": "pdf"}, "name": "report.pdf"}
+	]
+}`
+
+const installMsg = `{
+	"type": "conversationUpdate",
+	"id": "f:123",
+	"timestamp": "2024-10-15T10:20:00.123Z",
+	"serviceUrl": "https://smba.trafficmanager.net/amer/",
+	"channelId": "msteams",
+	"from": {"id": "29:1Xq8xPmgpZ", "name": "Bob Smith"},
+	"conversation": {"conversationType": "personal", "id": "a:1RtJ7kfXnWw"},
+	"recipient": {"id": "28:5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"},
+	"membersAdded": [{"id": "29:1Xq8xPmgpZ"}, {"id": "28:5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"}]
+}`
+
+const memberAddedMsg = `{
+	"type": "conversationUpdate",
+	"id": "f:124",
+	"serviceUrl": "https://smba.trafficmanager.net/amer/",
+	"channelId": "msteams",
+	"from": {"id": "29:1Xq8xPmgpZ"},
+	"conversation": {"id": "19:channel@thread.skype"},
+	"recipient": {"id": "28:5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"},
+	"membersAdded": [{"id": "29:2Yr9yQnhqA"}]
+}`
+
+const typingMsg = `{
+	"type": "typing",
+	"serviceUrl": "https://smba.trafficmanager.net/amer/",
+	"channelId": "msteams",
+	"from": {"id": "29:1Xq8xPmgpZ"},
+	"conversation": {"id": "a:1RtJ7kfXnWw"}
+}`
+
+const conversationAuth = `{"service_url":"https://smba.trafficmanager.net/amer/","conversation_id":"a:1RtJ7kfXnWw"}`
+
+// starts a server serving the public part of a new signing key and returns a function to sign tokens with it
+func newSigningKey(t *testing.T) (*httptest.Server, func(jwt.MapClaims, string) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonx.MustMarshal(map[string]any{"keys": []map[string]any{
+			{
+				"kty":          "RSA",
+				"kid":          "key1",
+				"n":            base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":            base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				"endorsements": []string{"msteams", "skype"},
+			},
+		}}))
+	}))
+
+	sign := func(claims jwt.MapClaims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return "Bearer " + signed
+	}
+
+	return server, sign
+}
+
+func TestIncoming(t *testing.T) {
+	keysServer, sign := newSigningKey(t)
+	defer keysServer.Close()
+
+	keysURL = keysServer.URL
+
+	claims := func(aud, serviceURL string, exp time.Time) jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://api.botframework.com", "aud": aud, "serviceurl": serviceURL, "exp": exp.Unix(), "nbf": time.Now().Add(-time.Minute).Unix()}
+	}
+	validAuth := map[string]string{"Authorization": sign(claims(appID, serviceURL, time.Now().Add(time.Hour)), "key1")}
+
+	RunIncomingTestCases(t, testChannels, newHandler(), []IncomingTestCase{
+		{
+			Label:                 "Receive Message",
+			URL:                   receiveURL,
+			Data:                  helloMsg,
+			Headers:               validAuth,
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "Message Accepted",
+			ExpectedMsgText:       Sp("Hello World"),
+			ExpectedURN:           "ext:29:1Xq8xPmgpZ",
+			ExpectedURNAuthTokens: map[urns.URN]map[string]string{"ext:29:1Xq8xPmgpZ": {"default": conversationAuth}},
+			ExpectedContactName:   Sp("Bob Smith"),
+			ExpectedExternalID:    "1728990000000",
+			ExpectedDate:          time.Date(2024, 10, 15, 10, 20, 0, 123000000, time.UTC),
+		},
+		{
+			Label:                 "Receive Attachments",
+			URL:                   receiveURL,
+			Data:                  attachmentsMsg,
+			Headers:               validAuth,
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "Message Accepted",
+			ExpectedMsgText:       Sp(""),
+			ExpectedURN:           "ext:29:1Xq8xPmgpZ",
+			ExpectedURNAuthTokens: map[urns.URN]map[string]string{"ext:29:1Xq8xPmgpZ": {"default": conversationAuth}},
+			ExpectedAttachments:   []string{"https://smba.trafficmanager.net/amer/v3/attachments/abc/views/original", "https://contoso.sharepoint.com/report.pdf"},
+			ExpectedExternalID:    "1728990000001",
+		},
+		{
+			Label:                 "Bot Installed",
+			URL:                   receiveURL,
+			Data:                  installMsg,
+			Headers:               validAuth,
+			ExpectedRespStatus:    200,
+			ExpectedBodyContains:  "Event Accepted",
+			ExpectedURNAuthTokens: map[urns.URN]map[string]string{"ext:29:1Xq8xPmgpZ": {"default": conversationAuth}},
+			ExpectedEvents: []ExpectedEvent{
+				{Type: courier.EventTypeNewConversation, URN: "ext:29:1Xq8xPmgpZ", Time: time.Date(2024, 10, 15, 10, 20, 0, 123000000, time.UTC)},
+			},
+		},
+		{
+			Label:                "Other Member Added",
+			URL:                  receiveURL,
+			Data:                 memberAddedMsg,
+			Headers:              validAuth,
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "ignoring conversation update",
+		},
+		{
+			Label:                "Typing Ignored",
+			URL:                  receiveURL,
+			Data:                 typingMsg,
+			Headers:              validAuth,
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "ignoring activity of type typing",
+		},
+		{
+			Label:                "Missing Authorization",
+			URL:                  receiveURL,
+			Data:                 helloMsg,
+			ExpectedRespStatus:   401,
+			ExpectedBodyContains: "missing or invalid Authorization header",
+		},
+		{
+			Label:                "Wrong Audience",
+			URL:                  receiveURL,
+			Data:                 helloMsg,
+			Headers:              map[string]string{"Authorization": sign(claims("another-bot", serviceURL, time.Now().Add(time.Hour)), "key1")},
+			ExpectedRespStatus:   401,
+			ExpectedBodyContains: "invalid token",
+		},
+		{
+			Label:                "Expired Token",
+			URL:                  receiveURL,
+			Data:                 helloMsg,
+			Headers:              map[string]string{"Authorization": sign(claims(appID, serviceURL, time.Now().Add(-time.Hour)), "key1")},
+			ExpectedRespStatus:   401,
+			ExpectedBodyContains: "token is expired",
+		},
+		{
+			Label:                "Unknown Signing Key",
+			URL:                  receiveURL,
+			Data:                 helloMsg,
+			Headers:              map[string]string{"Authorization": sign(claims(appID, serviceURL, time.Now().Add(time.Hour)), "key2")},
+			ExpectedRespStatus:   401,
+			ExpectedBodyContains: "unknown signing key: key2",
+		},
+		{
+			Label:                "Service URL Mismatch",
+			URL:                  receiveURL,
+			Data:                 helloMsg,
+			Headers:              map[string]string{"Authorization": sign(claims(appID, "https://evil.com/", time.Now().Add(time.Hour)), "key1")},
+			ExpectedRespStatus:   401,
+			ExpectedBodyContains: "service URL doesn't match token",
+		},
+		{
+			Label:                "Invalid JSON",
+			URL:                  receiveURL,
+			Data:                 `{"type": "message"`,
+			Headers:              validAuth,
+			ExpectedRespStatus:   400,
+			ExpectedBodyContains: "unable to parse request JSON",
+		},
+	})
+}
+
+var sendURL = fmt.Sprintf("%sv3/conversations/a:1RtJ7kfXnWw/activities", serviceURL)
+
+var outgoingCases = []OutgoingTestCase{
+	{
+		Label:      "Plain Send",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:29:1Xq8xPmgpZ",
+		MsgURNAuth: conversationAuth,
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000010"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Headers: map[string]string{"Authorization": "Bearer ACCESS_TOKEN"},
+				Body:    `{"type":"message","text":"Simple Message"}`,
+			},
+		},
+		ExpectedExtIDs: []string{"1728990000010"},
+	},
+	{
+		Label:                   "Reply With Quick Replies",
+		MsgText:                 "Pick one",
+		MsgURN:                  "ext:29:1Xq8xPmgpZ",
+		MsgURNAuth:              conversationAuth,
+		MsgQuickReplies:         []string{"Yes", "No"},
+		MsgResponseToExternalID: "1728990000000",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL + "/1728990000000": {httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000011"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"type":"message","text":"Pick one","suggestedActions":{"actions":[{"type":"imBack","title":"Yes","value":"Yes"},{"type":"imBack","title":"No","value":"No"}]},"replyToId":"1728990000000"}`,
+			},
+		},
+		ExpectedExtIDs: []string{"1728990000011"},
+	},
+	{
+		Label:          "Send With Attachments",
+		MsgText:        "Look",
+		MsgURN:         "ext:29:1Xq8xPmgpZ",
+		MsgURNAuth:     conversationAuth,
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/images/cat.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {
+				httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000012"}`)),
+				httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000013"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"type":"message","text":"Look"}`},
+			{Body: `{"type":"message","attachments":[{"contentType":"image/jpeg","contentUrl":"https://foo.bar/images/cat.jpg","name":"cat.jpg"}]}`},
+		},
+		ExpectedExtIDs: []string{"1728990000012", "1728990000013"},
+	},
+	{
+		Label:   "Proactive Send",
+		MsgText: "Hi there",
+		MsgURN:  "ext:29:1Xq8xPmgpZ",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://smba.trafficmanager.net/teams/v3/conversations": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id":"a:1NewConv"}`)),
+			},
+			"https://smba.trafficmanager.net/teams/v3/conversations/a:1NewConv/activities": {
+				httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000014"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"bot":{"id":"5d2f4e1a-8c3b-4a9d-b1e7-2f6c9a0d3e84"},"members":[{"id":"29:1Xq8xPmgpZ"}],"tenantId":"tenant123","channelData":{"tenant":{"id":"tenant123"}}}`},
+			{Body: `{"type":"message","text":"Hi there"}`},
+		},
+		ExpectedExtIDs: []string{"1728990000014"},
+	},
+	{
+		Label:      "Bot Blocked",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:29:1Xq8xPmgpZ",
+		MsgURNAuth: conversationAuth,
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(403, nil, []byte(`{"error":{"code":"BotDisabledByAdmin","message":"The bot is disabled"}}`))},
+		},
+		ExpectedRequests:  []ExpectedRequest{{Body: `{"type":"message","text":"Simple Message"}`}},
+		ExpectedError:     courier.ErrFailedWithReason("BotDisabledByAdmin", "The bot is disabled"),
+		ExpectedLogErrors: []*clogs.LogError{courier.ErrorExternal("BotDisabledByAdmin", "The bot is disabled")},
+	},
+	{
+		Label:      "Error Sending",
+		MsgText:    "Simple Message",
+		MsgURN:     "ext:29:1Xq8xPmgpZ",
+		MsgURNAuth: conversationAuth,
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(502, nil, []byte(`Bad Gateway`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{Body: `{"type":"message","text":"Simple Message"}`}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), outgoingCases, []string{"sesame"}, func(mb *test.MockBackend) {
+		rc := mb.RedisPool().Get()
+		defer rc.Close()
+		rc.Do("SET", "channel-token:"+channelUUID, "ACCESS_TOKEN")
+	})
+}
+
+func TestOutgoingTokenRefresh(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), []OutgoingTestCase{
+		{
+			Label:      "Fetches Token",
+			MsgText:    "Simple Message",
+			MsgURN:     "ext:29:1Xq8xPmgpZ",
+			MsgURNAuth: conversationAuth,
+			MockResponses: map[string][]*httpx.MockResponse{
+				"https://login.microsoftonline.com/botframework.com/oauth2/v2.0/token": {
+					httpx.NewMockResponse(200, nil, []byte(`{"token_type":"Bearer","expires_in":3599,"access_token":"NEW_TOKEN"}`)),
+				},
+				sendURL: {httpx.NewMockResponse(201, nil, []byte(`{"id":"1728990000010"}`))},
+			},
+			ExpectedRequests: []ExpectedRequest{
+				{Form: map[string][]string{"grant_type": {"client_credentials"}, "client_id": {appID}, "client_secret": {"sesame"}, "scope": {"https://api.botframework.com/.default"}}},
+				{Headers: map[string]string{"Authorization": "Bearer NEW_TOKEN"}, Body: `{"type":"message","text":"Simple Message"}`},
+			},
+			ExpectedExtIDs: []string{"1728990000010"},
+		},
+	}, []string{"sesame"}, func(mb *test.MockBackend) {
+		rc := mb.RedisPool().Get()
+		defer rc.Close()
+		rc.Do("DEL", "channel-token:"+channelUUID)
+	})
+
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), []OutgoingTestCase{
+		{
+			Label:      "Invalid Credentials",
+			MsgText:    "Simple Message",
+			MsgURN:     "ext:29:1Xq8xPmgpZ",
+			MsgURNAuth: conversationAuth,
+			MockResponses: map[string][]*httpx.MockResponse{
+				"https://login.microsoftonline.com/botframework.com/oauth2/v2.0/token": {
+					httpx.NewMockResponse(401, nil, []byte(`{"error":"invalid_client","error_description":"Invalid client secret provided."}`)),
+				},
+			},
+			ExpectedRequests:  []ExpectedRequest{{}},
+			ExpectedError:     courier.ErrChannelConfig,
+			ExpectedLogErrors: []*clogs.LogError{courier.ErrorExternal("invalid_client", "Invalid client secret provided.")},
+		},
+	}, []string{"sesame"}, func(mb *test.MockBackend) {
+		rc := mb.RedisPool().Get()
+		defer rc.Close()
+		rc.Do("DEL", "channel-token:"+channelUUID)
+	})
+}
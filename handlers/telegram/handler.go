@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
+	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/utils"
@@ -20,10 +22,30 @@ import (
 
 var apiURL = "https://api.telegram.org"
 
+// max for media captions, longer text is sent as a separate message
+const maxCaptionLength = 1024
+
+// whether messages in groups are ignored unless they mention the bot or reply to one of its messages
+const configGroupRequireMention = "group_require_mention"
+
+// whether quick replies are sent as inline keyboards rather than reply keyboards, can be overridden per message by
+// setting inline_keyboard in the message metadata
+const configInlineKeyboard = "inline_keyboard"
+
+// send error descriptions which tell us the chat can't be messaged, either because the user has blocked the bot or
+// because the chat no longer exists
+var contactSendErrors = map[string]error{
+	"Forbidden: bot was blocked by the user":             courier.ErrContactStopped,
+	"Forbidden: bot was kicked from the group chat":      courier.ErrContactStopped,
+	"Forbidden: bot was kicked from the supergroup chat": courier.ErrContactStopped,
+	"Forbidden: user is deactivated":                     courier.ErrContactUnknown,
+	"Bad Request: chat not found":                        courier.ErrContactUnknown,
+}
+
 // see https://core.telegram.org/bots/api#sending-files
 var mediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
 	handlers.MediaTypeImage:       {MaxBytes: 10 * 1024 * 1024},
-	handlers.MediaTypeAudio:       {MaxBytes: 50 * 1024 * 1024},
+	handlers.MediaTypeAudio:       {Types: []string{"audio/ogg", "audio/mpeg", "audio/mp3", "audio/mp4"}, MaxBytes: 50 * 1024 * 1024}, // OGG first to be sent as voice
 	handlers.MediaTypeVideo:       {MaxBytes: 50 * 1024 * 1024},
 	handlers.MediaTypeApplication: {Types: []string{"application/pdf"}, MaxBytes: 50 * 1024 * 1024},
 }
@@ -49,25 +71,68 @@ func (h *handler) Initialize(s courier.Server) error {
 
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	// a button on an inline keyboard was pressed
+	if payload.CallbackQuery != nil {
+		return h.receiveCallbackQuery(ctx, channel, w, r, payload, clog)
+	}
+
+	message := &payload.Message
+	if payload.ChannelPost != nil {
+		message = payload.ChannelPost
+	}
+
 	// no message? ignore this
-	if payload.Message.MessageID == 0 {
+	if message.MessageID == 0 {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, no message")
 	}
 
 	// create our date from the timestamp
-	date := time.Unix(payload.Message.Date, 0).UTC()
+	date := handlers.ParseUnixTimestamp(message.Date, handlers.TimestampSeconds)
 
-	// create our URN
-	urn, err := urns.NewFromParts(urns.Telegram.Prefix, strconv.FormatInt(payload.Message.From.ContactID, 10), nil, strings.ToLower(payload.Message.From.Username))
-	if err != nil {
-		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	// our text is either "text" or "caption" (or empty)
+	text := message.Text
+	if text == "" && message.Caption != "" {
+		text = message.Caption
 	}
 
-	// build our name from first and last
-	name := handlers.NameFromFirstLastUsername(payload.Message.From.FirstName, payload.Message.From.LastName, payload.Message.From.Username)
+	var urn urns.URN
+	var name string
+	var err error
+	metadata := make(map[string]any)
 
-	// our text is either "text" or "caption" (or empty)
-	text := payload.Message.Text
+	if message.Chat.IsGroup() {
+		// in groups we may only want messages addressed to us
+		if message.Chat.Type != chatTypeChannel && channel.BoolConfigForKey(configGroupRequireMention, false) && !mentionsBot(channel, message) {
+			return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, bot not mentioned")
+		}
+
+		// group chat ids are negative so can't be telegram URNs, the whole group is one contact
+		urn, err = urns.New(urns.External, strconv.FormatInt(message.Chat.ID, 10))
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		}
+
+		name = message.Chat.Title
+		text = stripBotMention(channel, text)
+
+		// keep track of who in the group actually sent the message
+		if message.From.ContactID != 0 {
+			metadata["sender"] = map[string]any{
+				"id":       message.From.ContactID,
+				"username": message.From.Username,
+				"name":     handlers.NameFromFirstLastUsername(message.From.FirstName, message.From.LastName, message.From.Username),
+			}
+		}
+	} else {
+		// create our URN
+		urn, err = urns.NewFromParts(urns.Telegram.Prefix, strconv.FormatInt(message.From.ContactID, 10), nil, strings.ToLower(message.From.Username))
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		}
+
+		// build our name from first and last
+		name = handlers.NameFromFirstLastUsername(message.From.FirstName, message.From.LastName, message.From.Username)
+	}
 
 	// this is a start command, trigger a new conversation
 	if text == "/start" {
@@ -79,43 +144,76 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return []courier.Event{event}, courier.WriteChannelEventSuccess(w, event)
 	}
 
-	// normal message of some kind
-	if text == "" && payload.Message.Caption != "" {
-		text = payload.Message.Caption
+	// deep links to the bot, e.g. from an ad, start a conversation with a parameter which we treat as a referral
+	if param, isStart := strings.CutPrefix(text, "/start "); isStart && param != "" {
+		referral := &handlers.Referral{Source: "start", Ref: param}
+		event := h.Backend().NewChannelEvent(channel, courier.EventTypeReferral, urn, clog).WithContactName(name).WithOccurredOn(date).WithExtra(referral.Extra())
+		err = h.Backend().WriteChannelEvent(ctx, event, clog)
+		if err != nil {
+			return nil, err
+		}
+		return []courier.Event{event}, courier.WriteChannelEventSuccess(w, event)
 	}
 
 	// deal with attachments
 	mediaURL := ""
-	if len(payload.Message.Photo) > 0 {
+	if len(message.Photo) > 0 {
 		// grab the largest photo less than 100k
-		photo := payload.Message.Photo[0]
-		for i := 1; i < len(payload.Message.Photo); i++ {
-			if payload.Message.Photo[i].FileSize > 100000 {
+		photo := message.Photo[0]
+		for i := 1; i < len(message.Photo); i++ {
+			if message.Photo[i].FileSize > 100000 {
 				break
 			}
-			photo = payload.Message.Photo[i]
+			photo = message.Photo[i]
 		}
 		mediaURL, err = h.resolveFileID(ctx, channel, photo.FileID, clog)
-	} else if payload.Message.Video != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Video.FileID, clog)
-	} else if payload.Message.Voice != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Voice.FileID, clog)
-	} else if payload.Message.Sticker != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Sticker.Thumb.FileID, clog)
-	} else if payload.Message.Document != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Document.FileID, clog)
-	} else if payload.Message.Venue != nil {
-		text = utils.JoinNonEmpty(", ", payload.Message.Venue.Title, payload.Message.Venue.Address)
-		mediaURL = fmt.Sprintf("geo:%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-	} else if payload.Message.Location != nil {
-		text = fmt.Sprintf("%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-		mediaURL = fmt.Sprintf("geo:%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-	} else if payload.Message.Contact != nil {
+	} else if message.Video != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Video.FileID, clog)
+	} else if message.Voice != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Voice.FileID, clog)
+	} else if message.Sticker != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Sticker.Thumb.FileID, clog)
+	} else if message.Document != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Document.FileID, clog)
+	} else if message.Venue != nil {
+		location := message.Venue.Location
+		if location == nil {
+			location = message.Location
+		}
+		text = utils.JoinNonEmpty(", ", message.Venue.Title, message.Venue.Address)
+		venue := map[string]any{"title": message.Venue.Title, "address": message.Venue.Address}
+		if location != nil {
+			mediaURL = fmt.Sprintf("geo:%f,%f", location.Latitude, location.Longitude)
+			venue["latitude"] = location.Latitude
+			venue["longitude"] = location.Longitude
+		}
+		metadata["venue"] = venue
+	} else if message.Location != nil {
+		text = fmt.Sprintf("%f,%f", message.Location.Latitude, message.Location.Longitude)
+		mediaURL = fmt.Sprintf("geo:%f,%f", message.Location.Latitude, message.Location.Longitude)
+	} else if message.Contact != nil {
 		phone := ""
-		if payload.Message.Contact.PhoneNumber != "" {
-			phone = fmt.Sprintf("(%s)", payload.Message.Contact.PhoneNumber)
+		if message.Contact.PhoneNumber != "" {
+			phone = fmt.Sprintf("(%s)", message.Contact.PhoneNumber)
+		}
+		text = utils.JoinNonEmpty(" ", message.Contact.FirstName, message.Contact.LastName, phone)
+	} else if message.Poll != nil {
+		options := make([]string, len(message.Poll.Options))
+		for i, o := range message.Poll.Options {
+			options[i] = o.Text
+		}
+		text = message.Poll.Question
+		metadata["poll"] = map[string]any{
+			"id":                      message.Poll.ID,
+			"question":                message.Poll.Question,
+			"options":                 options,
+			"is_anonymous":            message.Poll.IsAnonymous,
+			"allows_multiple_answers": message.Poll.AllowsMultipleAnswers,
 		}
-		text = utils.JoinNonEmpty(" ", payload.Message.Contact.FirstName, payload.Message.Contact.LastName, phone)
+	}
+
+	if forwarded := message.Forwarded(); forwarded != nil {
+		metadata["forwarded"] = forwarded
 	}
 
 	// we had an error downloading media
@@ -124,15 +222,79 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, text, fmt.Sprintf("%d", payload.Message.MessageID), clog).WithReceivedOn(date).WithContactName(name)
+	msg := h.Backend().NewIncomingMsg(channel, urn, text, fmt.Sprintf("%d", message.MessageID), clog).WithReceivedOn(date).WithContactName(name)
 
 	if mediaURL != "" {
 		msg.WithAttachment(mediaURL)
 	}
+	if len(metadata) > 0 {
+		msg.WithMetadata(jsonx.MustMarshal(metadata))
+	}
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
 
+// receiveCallbackQuery handles a button press on an inline keyboard by creating a message with the button's data
+func (h *handler) receiveCallbackQuery(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	query := payload.CallbackQuery
+
+	urn, err := urns.NewFromParts(urns.Telegram.Prefix, strconv.FormatInt(query.From.ContactID, 10), nil, strings.ToLower(query.From.Username))
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	name := handlers.NameFromFirstLastUsername(query.From.FirstName, query.From.LastName, query.From.Username)
+
+	// the client shows a progress indicator until the query is answered
+	h.answerCallbackQuery(channel, query.ID, clog)
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, query.Data, query.ID, clog).WithContactName(name)
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+// answerCallbackQuery acknowledges a callback query, see https://core.telegram.org/bots/api#answercallbackquery
+func (h *handler) answerCallbackQuery(channel courier.Channel, queryID string, clog *courier.ChannelLog) {
+	authToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+
+	form := url.Values{"callback_query_id": []string{queryID}}
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bot%s/answerCallbackQuery", apiURL, authToken), strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		errorCode, _ := jsonparser.GetInt(respBody, "error_code")
+		description, _ := jsonparser.GetString(respBody, "description")
+		clog.Error(courier.ErrorExternal(strconv.FormatInt(errorCode, 10), description))
+	}
+}
+
+// mentionsBot returns whether the given group message is addressed to our bot by mentioning it or replying to it
+func mentionsBot(channel courier.Channel, message *moMessage) bool {
+	botUsername := strings.ToLower(strings.TrimPrefix(channel.Address(), "@"))
+
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From.IsBot && strings.ToLower(message.ReplyToMessage.From.Username) == botUsername {
+		return true
+	}
+
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	return strings.Contains(strings.ToLower(text), "@"+botUsername)
+}
+
+// stripBotMention removes any mentions of our bot from the given text
+func stripBotMention(channel courier.Channel, text string) string {
+	botUsername := strings.TrimPrefix(channel.Address(), "@")
+	if botUsername == "" {
+		return text
+	}
+
+	mention := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(botUsername) + `\b`)
+	return strings.TrimSpace(mention.ReplaceAllString(text, ""))
+}
+
 type mtResponse struct {
 	Ok          bool   `json:"ok" validate:"required"`
 	ErrorCode   int    `json:"error_code"`
@@ -142,7 +304,14 @@ type mtResponse struct {
 	} `json:"result"`
 }
 
-func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.Values, keyboard *ReplyKeyboardMarkup, clog *courier.ChannelLog) (string, error) {
+func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.Values, keyboard any, clog *courier.ChannelLog) (string, error) {
+	// in groups reply to the message we're responding to, so that it's clear who we're talking to
+	if msg.URN().Scheme() == urns.External.Prefix && msg.ResponseToExternalID() != "" {
+		if replyToID, err := strconv.ParseInt(msg.ResponseToExternalID(), 10, 64); err == nil {
+			form.Set("reply_parameters", string(jsonx.MustMarshal(map[string]any{"message_id": replyToID, "allow_sending_without_reply": true})))
+		}
+	}
+
 	// either include or remove our keyboard
 	form.Add("parse_mode", "Markdown")
 	if keyboard == nil {
@@ -158,7 +327,7 @@ func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.V
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, respBody, _ := h.RequestHTTP(req, clog)
+	resp, respBody, err := h.RequestHTTP(req, clog)
 	if err != nil || resp.StatusCode/100 == 5 {
 		return "", courier.ErrConnectionFailed
 	}
@@ -167,8 +336,8 @@ func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.V
 	err = json.Unmarshal(respBody, response)
 
 	if err != nil || resp.StatusCode/100 != 2 || !response.Ok {
-		if response.ErrorCode == 403 && response.Description == "Forbidden: bot was blocked by the user" {
-			return "", courier.ErrContactStopped
+		if contactErr, found := contactSendErrors[response.Description]; found {
+			return "", contactErr
 		} else if response.ErrorCode > 0 {
 			return "", courier.ErrFailedWithReason(strconv.Itoa(response.ErrorCode), response.Description)
 		}
@@ -193,27 +362,59 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return fmt.Errorf("error resolving attachments: %w", err)
 	}
 
-	// we only caption if there is only a single attachment
+	// metadata can ask for the message to be sent as a native location, venue, contact or poll
+	native := &mtNative{}
+	if len(msg.Metadata()) > 0 {
+		json.Unmarshal(msg.Metadata(), native)
+	}
+
+	text := msg.Text()
+	qrs := msg.QuickReplies()
+
+	// polls default to using the text as the question and the quick replies as the options
+	if native.Poll != nil {
+		if native.Poll.Question == "" {
+			native.Poll.Question = text
+			text = ""
+		}
+		if len(native.Poll.Options) == 0 {
+			native.Poll.Options = qrs
+			qrs = nil
+		}
+	}
+
+	// we only caption if there is only a single attachment and the text will fit
 	caption := ""
-	if len(attachments) == 1 {
-		caption = msg.Text()
+	if len(attachments) == 1 && utf8.RuneCountInString(text) <= maxCaptionLength {
+		caption = text
 	}
 
 	// figure out whether we have a keyboard to send as well
-	qrs := msg.QuickReplies()
-	var keyboard *ReplyKeyboardMarkup
+	var keyboard any
 	if len(qrs) > 0 {
-		keyboard = NewKeyboardFromReplies(qrs)
+		inline := msg.Channel().BoolConfigForKey(configInlineKeyboard, false)
+		if metaInline, err := jsonparser.GetBoolean(msg.Metadata(), "inline_keyboard"); err == nil {
+			inline = metaInline
+		}
+
+		if inline {
+			keyboard = NewInlineKeyboardFromReplies(qrs)
+		} else {
+			keyboard = NewKeyboardFromReplies(qrs)
+		}
 	}
 
 	// if we have text, send that if we aren't sending it as a caption
-	if msg.Text() != "" && caption == "" {
-		var msgKeyBoard *ReplyKeyboardMarkup
-		if len(attachments) == 0 {
+	if text != "" && caption == "" {
+		var msgKeyBoard any
+		if len(attachments) == 0 && !native.isSet() {
 			msgKeyBoard = keyboard
 		}
 
-		form := url.Values{"chat_id": []string{msg.URN().Path()}, "text": []string{msg.Text()}}
+		form := url.Values{"chat_id": []string{msg.URN().Path()}, "text": []string{text}}
+		if preview := msg.URLPreview(); preview != nil && !*preview {
+			form.Set("disable_web_page_preview", "true")
+		}
 
 		externalID, err := h.sendMsgPart(msg, authToken, "sendMessage", form, msgKeyBoard, clog)
 		if err != nil {
@@ -225,8 +426,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 	// send each attachment
 	for i, attachment := range attachments {
-		var attachmentKeyBoard *ReplyKeyboardMarkup
-		if i == len(msg.Attachments())-1 {
+		var attachmentKeyBoard any
+		if i == len(msg.Attachments())-1 && !native.isSet() {
 			attachmentKeyBoard = keyboard
 		}
 
@@ -256,12 +457,18 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			res.AddExternalID(externalID)
 
 		case handlers.MediaTypeAudio:
+			// OGG audio is sent as a voice note, anything else as a music file
+			method, field := "sendAudio", "audio"
+			if attachment.ContentType == "audio/ogg" {
+				method, field = "sendVoice", "voice"
+			}
+
 			form := url.Values{
 				"chat_id": []string{msg.URN().Path()},
-				"audio":   []string{attachment.URL},
+				field:     []string{attachment.URL},
 				"caption": []string{caption},
 			}
-			externalID, err := h.sendMsgPart(msg, authToken, "sendAudio", form, attachmentKeyBoard, clog)
+			externalID, err := h.sendMsgPart(msg, authToken, method, form, attachmentKeyBoard, clog)
 			if err != nil {
 				return err
 			}
@@ -284,9 +491,87 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		}
 	}
 
+	// and finally our native message which gets the keyboard since it's sent last
+	if native.isSet() {
+		path, form := native.form(msg.URN().Path())
+		externalID, err := h.sendMsgPart(msg, authToken, path, form, keyboard, clog)
+		if err != nil {
+			return err
+		}
+		res.AddExternalID(externalID)
+	}
+
 	return nil
 }
 
+// mtNative is a native Telegram message which can be requested via the message metadata, e.g.
+// {"location": {"latitude": 1.5, "longitude": -2.5}} or {"poll": {"question": "Favorite color?", "options": ["Red", "Blue"]}}
+type mtNative struct {
+	Location *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Title     string  `json:"title"`
+		Address   string  `json:"address"`
+	} `json:"location"`
+	Contact *struct {
+		PhoneNumber string `json:"phone_number"`
+		FirstName   string `json:"first_name"`
+		LastName    string `json:"last_name"`
+	} `json:"contact"`
+	Poll *struct {
+		Question              string   `json:"question"`
+		Options               []string `json:"options"`
+		IsAnonymous           *bool    `json:"is_anonymous"`
+		AllowsMultipleAnswers bool     `json:"allows_multiple_answers"`
+	} `json:"poll"`
+}
+
+func (n *mtNative) isSet() bool {
+	return n.Location != nil || n.Contact != nil || n.Poll != nil
+}
+
+// form returns the API method and form for sending this native message to the given chat
+func (n *mtNative) form(chatID string) (string, url.Values) {
+	form := url.Values{"chat_id": []string{chatID}}
+
+	switch {
+	case n.Location != nil:
+		form.Set("latitude", strconv.FormatFloat(n.Location.Latitude, 'f', -1, 64))
+		form.Set("longitude", strconv.FormatFloat(n.Location.Longitude, 'f', -1, 64))
+
+		// a location with a title or address is a venue
+		if n.Location.Title == "" && n.Location.Address == "" {
+			return "sendLocation", form
+		}
+		form.Set("title", n.Location.Title)
+		form.Set("address", n.Location.Address)
+		return "sendVenue", form
+
+	case n.Contact != nil:
+		form.Set("phone_number", n.Contact.PhoneNumber)
+		form.Set("first_name", n.Contact.FirstName)
+		if n.Contact.LastName != "" {
+			form.Set("last_name", n.Contact.LastName)
+		}
+		return "sendContact", form
+
+	default:
+		options := make([]map[string]string, len(n.Poll.Options))
+		for i, o := range n.Poll.Options {
+			options[i] = map[string]string{"text": o}
+		}
+		form.Set("question", n.Poll.Question)
+		form.Set("options", string(jsonx.MustMarshal(options)))
+		if n.Poll.IsAnonymous != nil {
+			form.Set("is_anonymous", strconv.FormatBool(*n.Poll.IsAnonymous))
+		}
+		if n.Poll.AllowsMultipleAnswers {
+			form.Set("allows_multiple_answers", "true")
+		}
+		return "sendPoll", form
+	}
+}
+
 type fileResponse struct {
 	Ok          bool   `json:"ok"`
 	ErrorCode   int    `json:"error_code"`
@@ -346,6 +631,48 @@ type moFile struct {
 	FileSize int    `json:"file_size"`
 }
 
+type moUser struct {
+	ContactID int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+func (u *moUser) forwardedFrom() *handlers.ForwardedFrom {
+	return &handlers.ForwardedFrom{
+		Type:     "user",
+		ID:       strconv.FormatInt(u.ContactID, 10),
+		Name:     handlers.NameFromFirstLastUsername(u.FirstName, u.LastName, u.Username),
+		Username: u.Username,
+	}
+}
+
+const (
+	chatTypeGroup      = "group"
+	chatTypeSupergroup = "supergroup"
+	chatTypeChannel    = "channel"
+)
+
+type moChat struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+}
+
+func (c *moChat) forwardedFrom() *handlers.ForwardedFrom {
+	fromType := "chat"
+	if c.Type == chatTypeChannel {
+		fromType = "channel"
+	}
+	return &handlers.ForwardedFrom{Type: fromType, ID: strconv.FormatInt(c.ID, 10), Name: c.Title}
+}
+
+// IsGroup returns whether this is a chat with more than one user
+func (c *moChat) IsGroup() bool {
+	return c.Type == chatTypeGroup || c.Type == chatTypeSupergroup || c.Type == chatTypeChannel
+}
+
 type moLocation struct {
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
@@ -371,36 +698,112 @@ type moLocation struct {
 //	    "text": "Hello World"
 //	   }
 //	}
-type moPayload struct {
-	UpdateID int64 `json:"update_id" validate:"required"`
-	Message  struct {
-		MessageID int64 `json:"message_id"`
-		From      struct {
-			ContactID int64  `json:"id"`
-			FirstName string `json:"first_name"`
-			LastName  string `json:"last_name"`
-			Username  string `json:"username"`
-		} `json:"from"`
-		Date    int64  `json:"date"`
-		Text    string `json:"text"`
-		Caption string `json:"caption"`
-		Sticker *struct {
-			Thumb moFile `json:"thumb"`
-		} `json:"sticker"`
-		Photo    []moFile    `json:"photo"`
-		Video    *moFile     `json:"video"`
-		Voice    *moFile     `json:"voice"`
-		Document *moFile     `json:"document"`
+type moMessage struct {
+	MessageID int64  `json:"message_id"`
+	From      moUser `json:"from"`
+	Chat      moChat `json:"chat"`
+	Date      int64  `json:"date"`
+	Text      string `json:"text"`
+	Caption   string `json:"caption"`
+	Sticker   *struct {
+		Thumb moFile `json:"thumb"`
+	} `json:"sticker"`
+	Photo    []moFile    `json:"photo"`
+	Video    *moFile     `json:"video"`
+	Voice    *moFile     `json:"voice"`
+	Document *moFile     `json:"document"`
+	Location *moLocation `json:"location"`
+	Venue    *struct {
 		Location *moLocation `json:"location"`
-		Venue    *struct {
-			Location *moLocation `json:"location"`
-			Title    string      `json:"title"`
-			Address  string      `json:"address"`
+		Title    string      `json:"title"`
+		Address  string      `json:"address"`
+	}
+	Contact *struct {
+		PhoneNumber string `json:"phone_number"`
+		FirstName   string `json:"first_name"`
+		LastName    string `json:"last_name"`
+	}
+	Poll *struct {
+		ID       string `json:"id"`
+		Question string `json:"question"`
+		Options  []struct {
+			Text string `json:"text"`
+		} `json:"options"`
+		IsAnonymous           bool `json:"is_anonymous"`
+		AllowsMultipleAnswers bool `json:"allows_multiple_answers"`
+	} `json:"poll"`
+	ReplyToMessage *struct {
+		From moUser `json:"from"`
+	} `json:"reply_to_message"`
+
+	// forwarded messages have an origin, or the older forward_* fields
+	ForwardOrigin *struct {
+		Type           string  `json:"type"`
+		Date           int64   `json:"date"`
+		SenderUser     *moUser `json:"sender_user"`
+		SenderUserName string  `json:"sender_user_name"`
+		SenderChat     *moChat `json:"sender_chat"`
+		Chat           *moChat `json:"chat"`
+	} `json:"forward_origin"`
+	ForwardFrom       *moUser `json:"forward_from"`
+	ForwardFromChat   *moChat `json:"forward_from_chat"`
+	ForwardSenderName string  `json:"forward_sender_name"`
+	ForwardDate       int64   `json:"forward_date"`
+}
+
+// Forwarded returns the forwarding context of this message, or nil if it wasn't forwarded
+func (m *moMessage) Forwarded() *handlers.Forwarded {
+	var from *handlers.ForwardedFrom
+	var date int64
+
+	if o := m.ForwardOrigin; o != nil {
+		date = o.Date
+
+		switch o.Type {
+		case "user":
+			if o.SenderUser != nil {
+				from = o.SenderUser.forwardedFrom()
+			}
+		case "hidden_user":
+			from = &handlers.ForwardedFrom{Type: "user", Name: o.SenderUserName}
+		case "chat":
+			if o.SenderChat != nil {
+				from = o.SenderChat.forwardedFrom()
+			}
+		case "channel":
+			if o.Chat != nil {
+				from = o.Chat.forwardedFrom()
+			}
 		}
-		Contact *struct {
-			PhoneNumber string `json:"phone_number"`
-			FirstName   string `json:"first_name"`
-			LastName    string `json:"last_name"`
+	} else if m.ForwardDate != 0 {
+		date = m.ForwardDate
+
+		if m.ForwardFrom != nil {
+			from = m.ForwardFrom.forwardedFrom()
+		} else if m.ForwardFromChat != nil {
+			from = m.ForwardFromChat.forwardedFrom()
+		} else if m.ForwardSenderName != "" {
+			from = &handlers.ForwardedFrom{Type: "user", Name: m.ForwardSenderName}
 		}
-	} `json:"message"`
+	} else {
+		return nil
+	}
+
+	forwarded := &handlers.Forwarded{From: from}
+	if date != 0 {
+		t := handlers.ParseUnixTimestamp(date, handlers.TimestampSeconds)
+		forwarded.Date = &t
+	}
+	return forwarded
+}
+
+type moPayload struct {
+	UpdateID      int64      `json:"update_id" validate:"required"`
+	Message       moMessage  `json:"message"`
+	ChannelPost   *moMessage `json:"channel_post"`
+	CallbackQuery *struct {
+		ID   string `json:"id"`
+		From moUser `json:"from"`
+		Data string `json:"data"`
+	} `json:"callback_query"`
 }
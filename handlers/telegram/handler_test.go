@@ -59,6 +59,27 @@ var startMsg = `{
     }
   }`
 
+var startReferralMsg = `{
+    "update_id": 174114370,
+    "message": {
+      "message_id": 42,
+      "from": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "username": "nicpottier"
+      },
+      "chat": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "type": "private"
+      },
+      "date": 1454119029,
+      "text": "/start summer_campaign"
+    }
+  }`
+
 var emptyMsg = `{
  	"update_id": 174114370
 }`
@@ -454,6 +475,86 @@ var locationMsg = `
     }
 }`
 
+var forwardedMsg = `
+{
+    "update_id": 900946538,
+    "message": {
+        "message_id": 98,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "type": "private"
+        },
+        "date": 1493845520,
+        "forward_origin": {
+            "type": "user",
+            "date": 1493845000,
+            "sender_user": {
+                "id": 1234567,
+                "first_name": "Bob",
+                "username": "bobby"
+            }
+        },
+        "text": "The moon landing was faked"
+    }
+}`
+
+var forwardedLegacyMsg = `
+{
+    "update_id": 900946539,
+    "message": {
+        "message_id": 99,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "type": "private"
+        },
+        "date": 1493845520,
+        "forward_from_chat": {
+            "id": -1001234567890,
+            "title": "Daily News",
+            "type": "channel"
+        },
+        "forward_date": 1493845000,
+        "text": "The moon landing was faked"
+    }
+}`
+
+var forwardedHiddenMsg = `
+{
+    "update_id": 900946540,
+    "message": {
+        "message_id": 100,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "type": "private"
+        },
+        "date": 1493845520,
+        "forward_origin": {
+            "type": "hidden_user",
+            "date": 1493845000,
+            "sender_user_name": "Someone Private"
+        },
+        "text": "The moon landing was faked"
+    }
+}`
+
 var venueMsg = `
 {
     "update_id": 900946535,
@@ -489,6 +590,41 @@ var venueMsg = `
     }
 }`
 
+var pollMsg = `
+{
+    "update_id": 900946537,
+    "message": {
+        "message_id": 97,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier",
+            "type": "private"
+        },
+        "date": 1493845520,
+        "poll": {
+            "id": "5436145263485927425",
+            "question": "Favorite color?",
+            "options": [
+                {"text": "Red", "voter_count": 0},
+                {"text": "Blue", "voter_count": 0}
+            ],
+            "total_voter_count": 0,
+            "is_closed": false,
+            "is_anonymous": true,
+            "type": "regular",
+            "allows_multiple_answers": false
+        }
+    }
+}`
+
 var contactMsg = `
 {
     "update_id": 900946536,
@@ -515,6 +651,30 @@ var contactMsg = `
     }
 }`
 
+var callbackQueryMsg = `{
+	"update_id": 174114375,
+	"callback_query": {
+		"id": "4382bfdwdsb323b2d9",
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"message": {
+			"message_id": 42,
+			"chat": {
+				"id": 3527065,
+				"type": "private"
+			},
+			"date": 1454119029,
+			"text": "Are you happy?"
+		},
+		"chat_instance": "-4627315729837513045",
+		"data": "Yes"
+	}
+}`
+
 var testCases = []IncomingTestCase{
 	{
 
@@ -541,6 +701,28 @@ var testCases = []IncomingTestCase{
 			{Type: courier.EventTypeNewConversation, URN: "telegram:3527065#nicpottier", Time: time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)},
 		},
 	},
+	{
+		Label:                "Receive Start Message With Parameter",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 startReferralMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Nic Pottier"),
+		ExpectedEvents: []ExpectedEvent{
+			{Type: courier.EventTypeReferral, URN: "telegram:3527065#nicpottier", Time: time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC), Extra: map[string]string{"source": "start", "referrer_id": "summer_campaign"}},
+		},
+	},
+	{
+		Label:                "Receive Callback Query",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 callbackQueryMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Nic Pottier"),
+		ExpectedMsgText:      Sp("Yes"),
+		ExpectedURN:          "telegram:3527065#nicpottier",
+		ExpectedExternalID:   "4382bfdwdsb323b2d9",
+	},
 	{
 		Label:                "Receive No Params",
 		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
@@ -642,10 +824,60 @@ var testCases = []IncomingTestCase{
 		ExpectedContactName:  Sp("Nic Pottier"),
 		ExpectedMsgText:      Sp("Cuenca, Provincia del Azuay"),
 		ExpectedAttachments:  []string{"geo:-2.898944,-79.006835"},
+		ExpectedMsgMetadata:  `{"venue":{"title":"Cuenca","address":"Provincia del Azuay","latitude":-2.898944,"longitude":-79.006835}}`,
 		ExpectedURN:          "telegram:3527065#nicpottier",
 		ExpectedExternalID:   "95",
 		ExpectedDate:         time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC),
 	},
+	{
+		Label:                "Receive Forwarded",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 forwardedMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("The moon landing was faked"),
+		ExpectedMsgMetadata:  `{"forwarded":{"frequently":false,"from":{"type":"user","id":"1234567","name":"Bob","username":"bobby"},"date":"2017-05-03T20:56:40Z"}}`,
+		ExpectedURN:          "telegram:3527065#nicpottier",
+		ExpectedExternalID:   "98",
+		ExpectedDate:         time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Forwarded From Channel",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 forwardedLegacyMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("The moon landing was faked"),
+		ExpectedMsgMetadata:  `{"forwarded":{"frequently":false,"from":{"type":"channel","id":"-1001234567890","name":"Daily News"},"date":"2017-05-03T20:56:40Z"}}`,
+		ExpectedURN:          "telegram:3527065#nicpottier",
+		ExpectedExternalID:   "99",
+		ExpectedDate:         time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Forwarded From Hidden User",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 forwardedHiddenMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedMsgText:      Sp("The moon landing was faked"),
+		ExpectedMsgMetadata:  `{"forwarded":{"frequently":false,"from":{"type":"user","name":"Someone Private"},"date":"2017-05-03T20:56:40Z"}}`,
+		ExpectedURN:          "telegram:3527065#nicpottier",
+		ExpectedExternalID:   "100",
+		ExpectedDate:         time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Poll",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 pollMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Nic Pottier"),
+		ExpectedMsgText:      Sp("Favorite color?"),
+		ExpectedMsgMetadata:  `{"poll":{"id":"5436145263485927425","question":"Favorite color?","options":["Red","Blue"],"is_anonymous":true,"allows_multiple_answers":false}}`,
+		ExpectedURN:          "telegram:3527065#nicpottier",
+		ExpectedExternalID:   "97",
+		ExpectedDate:         time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC),
+	},
 	{
 		Label:                "Receive Contact",
 		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
@@ -717,6 +949,15 @@ func buildMockTelegramService(testCases []IncomingTestCase) *httptest.Server {
 		fileID := r.FormValue("file_id")
 		defer r.Body.Close()
 
+		if strings.HasSuffix(r.URL.Path, "/answerCallbackQuery") {
+			if r.FormValue("callback_query_id") == "4382bfdwdsb323b2d9" {
+				w.Write([]byte(`{ "ok": true, "result": true }`))
+			} else {
+				http.Error(w, `{ "ok": false, "error_code": 400, "description": "Bad Request: query is too old" }`, 400)
+			}
+			return
+		}
+
 		filePath := ""
 
 		switch fileID {
@@ -768,6 +1009,146 @@ func buildMockTelegramService(testCases []IncomingTestCase) *httptest.Server {
 	return server
 }
 
+var groupMsg = `{
+	"update_id": 174114380,
+	"message": {
+		"message_id": 51,
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"chat": {
+			"id": -1001234567890,
+			"title": "Nyaruka Team",
+			"type": "supergroup"
+		},
+		"date": 1454119029,
+		"text": "@RapidPro_Bot Hello World"
+	}
+}`
+
+var groupMsgNoMention = `{
+	"update_id": 174114381,
+	"message": {
+		"message_id": 52,
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"chat": {
+			"id": -1001234567890,
+			"title": "Nyaruka Team",
+			"type": "supergroup"
+		},
+		"date": 1454119029,
+		"text": "Hello everyone"
+	}
+}`
+
+var groupReplyMsg = `{
+	"update_id": 174114382,
+	"message": {
+		"message_id": 53,
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"chat": {
+			"id": -4012345678,
+			"title": "Book Club",
+			"type": "group"
+		},
+		"date": 1454119029,
+		"text": "Yes",
+		"reply_to_message": {
+			"message_id": 50,
+			"from": {
+				"id": 7012345678,
+				"is_bot": true,
+				"first_name": "RapidPro",
+				"username": "rapidpro_bot"
+			},
+			"chat": {
+				"id": -4012345678,
+				"title": "Book Club",
+				"type": "group"
+			},
+			"date": 1454119000,
+			"text": "Are you coming?"
+		}
+	}
+}`
+
+var channelPostMsg = `{
+	"update_id": 174114383,
+	"channel_post": {
+		"message_id": 12,
+		"sender_chat": {
+			"id": -1009876543210,
+			"title": "Nyaruka News",
+			"type": "channel"
+		},
+		"chat": {
+			"id": -1009876543210,
+			"title": "Nyaruka News",
+			"type": "channel"
+		},
+		"date": 1454119029,
+		"text": "New release out today"
+	}
+}`
+
+var groupTestCases = []IncomingTestCase{
+	{
+		Label:                "Receive Group Message",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 groupMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Nyaruka Team"),
+		ExpectedMsgText:      Sp("Hello World"),
+		ExpectedURN:          "ext:-1001234567890",
+		ExpectedExternalID:   "51",
+		ExpectedMsgMetadata:  `{"sender":{"id":3527065,"name":"Nic Pottier","username":"nicpottier"}}`,
+		ExpectedDate:         time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Group Message Without Mention",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 groupMsgNoMention,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Ignoring request, bot not mentioned",
+	},
+	{
+		Label:                "Receive Group Reply To Bot",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 groupReplyMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Book Club"),
+		ExpectedMsgText:      Sp("Yes"),
+		ExpectedURN:          "ext:-4012345678",
+		ExpectedExternalID:   "53",
+	},
+	{
+		Label:                "Receive Channel Post",
+		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                 channelPostMsg,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Accepted",
+		ExpectedContactName:  Sp("Nyaruka News"),
+		ExpectedMsgText:      Sp("New release out today"),
+		ExpectedURN:          "ext:-1009876543210",
+		ExpectedExternalID:   "12",
+	},
+}
+
 func TestIncoming(t *testing.T) {
 	telegramService := buildMockTelegramService(testCases)
 	defer telegramService.Close()
@@ -777,6 +1158,28 @@ func TestIncoming(t *testing.T) {
 	}
 
 	RunIncomingTestCases(t, chs, newHandler(), testCases)
+
+	// group messages are accepted without mentions unless the channel requires them
+	RunIncomingTestCases(t, chs, newHandler(), []IncomingTestCase{
+		{
+			Label:                "Receive Group Message Without Mention",
+			URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+			Data:                 groupMsgNoMention,
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "Accepted",
+			ExpectedContactName:  Sp("Nyaruka Team"),
+			ExpectedMsgText:      Sp("Hello everyone"),
+			ExpectedURN:          "ext:-1001234567890",
+			ExpectedExternalID:   "52",
+			ExpectedMsgMetadata:  `{"sender":{"id":3527065,"name":"Nic Pottier","username":"nicpottier"}}`,
+		},
+	})
+
+	groupChs := []courier.Channel{
+		test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "TG", "rapidpro_bot", "US", []string{urns.Telegram.Prefix, urns.External.Prefix}, map[string]any{"auth_token": "a123", configGroupRequireMention: true}),
+	}
+
+	RunIncomingTestCases(t, groupChs, newHandler(), groupTestCases)
 }
 
 var outgoingCases = []OutgoingTestCase{
@@ -794,6 +1197,36 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedExtIDs: []string{"133"},
 	},
+	{
+		Label:                   "Send To Group",
+		MsgText:                 "Simple Message",
+		MsgURN:                  "ext:-1001234567890",
+		MsgResponseToExternalID: "51",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 134 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Simple Message"}, "chat_id": {"-1001234567890"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}, "reply_parameters": {`{"allow_sending_without_reply":true,"message_id":51}`}}},
+		},
+		ExpectedExtIDs: []string{"134"},
+	},
+	{
+		Label:         "Send Without Link Preview",
+		MsgText:       "Check out https://nyaruka.com",
+		MsgURN:        "telegram:12345",
+		MsgURLPreview: Bp(false),
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Check out https://nyaruka.com"}, "chat_id": {"12345"}, "disable_web_page_preview": {"true"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
 	{
 		Label:           "Quick Reply",
 		MsgText:         "Are you happy?",
@@ -809,6 +1242,111 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedExtIDs: []string{"133"},
 	},
+	{
+		Label:           "Quick Reply As Inline Keyboard",
+		MsgText:         "Are you happy?",
+		MsgURN:          "telegram:12345",
+		MsgQuickReplies: []string{"Yes", "No"},
+		MsgMetadata:     `{"inline_keyboard": true}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Are you happy?"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"inline_keyboard":[[{"text":"Yes","callback_data":"Yes"},{"text":"No","callback_data":"No"}]]}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
+	{
+		Label:       "Send Location",
+		MsgText:     "Our office",
+		MsgURN:      "telegram:12345",
+		MsgMetadata: `{"location": {"latitude": -2.898944, "longitude": -79.006835}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+			"*/botauth_token/sendLocation": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 134 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Our office"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+			{Form: url.Values{"latitude": {"-2.898944"}, "longitude": {"-79.006835"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133", "134"},
+	},
+	{
+		Label:           "Send Venue With Quick Replies",
+		MsgText:         "Our office",
+		MsgURN:          "telegram:12345",
+		MsgQuickReplies: []string{"Thanks"},
+		MsgMetadata:     `{"location": {"latitude": -2.898944, "longitude": -79.006835, "title": "Cuenca", "address": "Provincia del Azuay"}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+			"*/botauth_token/sendVenue": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 134 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Our office"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+			{Form: url.Values{"latitude": {"-2.898944"}, "longitude": {"-79.006835"}, "title": {"Cuenca"}, "address": {"Provincia del Azuay"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"keyboard":[[{"text":"Thanks"}]],"resize_keyboard":true,"one_time_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133", "134"},
+	},
+	{
+		Label:       "Send Contact",
+		MsgURN:      "telegram:12345",
+		MsgMetadata: `{"contact": {"phone_number": "+250788123123", "first_name": "Bob"}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendContact": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"phone_number": {"+250788123123"}, "first_name": {"Bob"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
+	{
+		Label:           "Send Poll From Text And Quick Replies",
+		MsgText:         "Favorite color?",
+		MsgURN:          "telegram:12345",
+		MsgQuickReplies: []string{"Red", "Blue"},
+		MsgMetadata:     `{"poll": {"is_anonymous": false}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendPoll": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"question": {"Favorite color?"}, "options": {`[{"text":"Red"},{"text":"Blue"}]`}, "is_anonymous": {"false"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
+	{
+		Label:       "Send Poll Error",
+		MsgText:     "Vote now",
+		MsgURN:      "telegram:12345",
+		MsgMetadata: `{"poll": {"question": "Favorite color?", "options": ["Red"], "allows_multiple_answers": true}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+			"*/botauth_token/sendPoll": {
+				httpx.NewMockResponse(400, nil, []byte(`{ "ok": false, "error_code": 400, "description": "Bad Request: poll must have at least 2 option" }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Vote now"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+			{Form: url.Values{"question": {"Favorite color?"}, "options": {`[{"text":"Red"}]`}, "allows_multiple_answers": {"true"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError:  courier.ErrFailedWithReason("400", "Bad Request: poll must have at least 2 option"),
+		ExpectedExtIDs: []string{"133"},
+	},
 	{
 		Label:           "Quick Reply with multiple attachments",
 		MsgText:         "Are you happy?",
@@ -859,6 +1397,48 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrContactStopped,
 	},
+	{
+		Label:   "Kicked From Group",
+		MsgText: "Stopped Contact",
+		MsgURN:  "telegram:-12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(403, nil, []byte(`{ "ok": false, "error_code":403, "description":"Forbidden: bot was kicked from the group chat"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Stopped Contact"}, "chat_id": {"-12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError: courier.ErrContactStopped,
+	},
+	{
+		Label:   "Deactivated User",
+		MsgText: "Unknown Contact",
+		MsgURN:  "telegram:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(403, nil, []byte(`{ "ok": false, "error_code":403, "description":"Forbidden: user is deactivated"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Unknown Contact"}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError: courier.ErrContactUnknown,
+	},
+	{
+		Label:   "Chat Not Found",
+		MsgText: "Unknown Contact",
+		MsgURN:  "telegram:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(400, nil, []byte(`{ "ok": false, "error_code":400, "description":"Bad Request: chat not found"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Unknown Contact"}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError: courier.ErrContactUnknown,
+	},
 	{
 		Label:          "Send Photo",
 		MsgText:        "My pic!",
@@ -874,6 +1454,40 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedExtIDs: []string{"133"},
 	},
+	{
+		Label:          "Send Photo With Max Length Caption",
+		MsgText:        strings.Repeat("é", 1024),
+		MsgURN:         "telegram:12345",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendPhoto": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"caption": {strings.Repeat("é", 1024)}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "photo": {"https://foo.bar/image.jpg"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
+	{
+		Label:          "Send Photo With Caption Too Long",
+		MsgText:        strings.Repeat("a", 1025),
+		MsgURN:         "telegram:12345",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+			"*/botauth_token/sendPhoto": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 134 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {strings.Repeat("a", 1025)}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+			{Form: url.Values{"caption": {""}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "photo": {"https://foo.bar/image.jpg"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133", "134"},
+	},
 	{
 		Label:          "Send Video",
 		MsgText:        "My vid!",
@@ -904,6 +1518,21 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedExtIDs: []string{"133"},
 	},
+	{
+		Label:          "Send Audio Transcoded To Voice",
+		MsgText:        "My voice!",
+		MsgURN:         "telegram:12345",
+		MsgAttachments: []string{"audio/wav:http://mock.com/3456/voice.wav"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendVoice": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 134 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"caption": {"My voice!"}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "voice": {"http://mock.com/4567/voice.ogg"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"134"},
+	},
 	{
 		Label:          "Send Document",
 		MsgText:        "My document!",
@@ -942,11 +1571,60 @@ var outgoingCases = []OutgoingTestCase{
 	},
 }
 
+func setupMedia(mb *test.MockBackend) {
+	voiceWAV := test.NewMockMedia("voice.wav", "audio/wav", "http://mock.com/3456/voice.wav", 1024*1024, 0, 0, 5, nil)
+	voiceOGG := test.NewMockMedia("voice.ogg", "audio/ogg", "http://mock.com/4567/voice.ogg", 12*1024, 0, 0, 5, nil)
+
+	mb.MockMedia(voiceWAV)
+	mb.MockTranscodedMedia(voiceWAV.URL(), voiceOGG)
+}
+
 func TestOutgoing(t *testing.T) {
 	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "2020", "US",
 		[]string{urns.Telegram.Prefix},
 		map[string]any{courier.ConfigAuthToken: "auth_token"},
 	)
 
-	RunOutgoingTestCases(t, ch, newHandler(), outgoingCases, []string{"auth_token"}, nil)
+	RunOutgoingTestCases(t, ch, newHandler(), outgoingCases, []string{"auth_token"}, setupMedia)
+
+	inlineCh := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "2020", "US",
+		[]string{urns.Telegram.Prefix},
+		map[string]any{courier.ConfigAuthToken: "auth_token", configInlineKeyboard: true},
+	)
+
+	RunOutgoingTestCases(t, inlineCh, newHandler(), inlineOutgoingCases, []string{"auth_token"}, nil)
+}
+
+var inlineOutgoingCases = []OutgoingTestCase{
+	{
+		Label:           "Quick Reply",
+		MsgText:         "Are you happy?",
+		MsgURN:          "telegram:12345",
+		MsgQuickReplies: []string{"Yes", "No"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Are you happy?"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"inline_keyboard":[[{"text":"Yes","callback_data":"Yes"},{"text":"No","callback_data":"No"}]]}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
+	{
+		Label:           "Quick Reply With Inline Keyboard Disabled For Message",
+		MsgText:         "Are you happy?",
+		MsgURN:          "telegram:12345",
+		MsgQuickReplies: []string{"Yes", "No"},
+		MsgMetadata:     `{"inline_keyboard": false}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "ok": true, "result": { "message_id": 133 } }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Are you happy?"}, "chat_id": {"12345"}, "parse_mode": {"Markdown"}, "reply_markup": {`{"keyboard":[[{"text":"Yes"},{"text":"No"}]],"resize_keyboard":true,"one_time_keyboard":true}`}}},
+		},
+		ExpectedExtIDs: []string{"133"},
+	},
 }
@@ -1,6 +1,10 @@
 package telegram
 
-import "github.com/nyaruka/courier/utils"
+import (
+	"unicode/utf8"
+
+	"github.com/nyaruka/courier/utils"
+)
 
 // KeyboardButton is button on a keyboard, see https://core.telegram.org/bots/api/#keyboardbutton
 type KeyboardButton struct {
@@ -30,3 +34,42 @@ func NewKeyboardFromReplies(replies []string) *ReplyKeyboardMarkup {
 
 	return &ReplyKeyboardMarkup{Keyboard: keyboard, ResizeKeyboard: true, OneTimeKeyboard: true}
 }
+
+// InlineKeyboardButton is a button on an inline keyboard, see https://core.telegram.org/bots/api#inlinekeyboardbutton
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// InlineKeyboardMarkup models a keyboard attached to a message, see https://core.telegram.org/bots/api#inlinekeyboardmarkup
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// NewInlineKeyboardFromReplies creates an inline keyboard from the given quick replies
+func NewInlineKeyboardFromReplies(replies []string) *InlineKeyboardMarkup {
+	rows := utils.StringsToRows(replies, 5, 30, 2)
+	keyboard := make([][]InlineKeyboardButton, len(rows))
+
+	for i := range rows {
+		keyboard[i] = make([]InlineKeyboardButton, len(rows[i]))
+		for j := range rows[i] {
+			keyboard[i][j].Text = rows[i][j]
+			keyboard[i][j].CallbackData = truncateBytes(rows[i][j], maxCallbackDataBytes)
+		}
+	}
+
+	return &InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// callback data is what we get back when a button is pressed and is limited to 64 bytes
+const maxCallbackDataBytes = 64
+
+// truncates the given string to at most the given number of bytes without splitting any characters
+func truncateBytes(s string, limit int) string {
+	for len(s) > limit {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
@@ -1,6 +1,7 @@
 package telegram_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/nyaruka/courier/handlers/telegram"
@@ -60,3 +61,18 @@ func TestKeyboardFromReplies(t *testing.T) {
 		assert.Equal(t, tc.expected, kb, "keyboard mismatch for replies %v", tc.replies)
 	}
 }
+
+func TestInlineKeyboardFromReplies(t *testing.T) {
+	kb := telegram.NewInlineKeyboardFromReplies([]string{"Yes", "No", "Maybe"})
+	assert.Equal(t, &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{{Text: "Yes", CallbackData: "Yes"}, {Text: "No", CallbackData: "No"}, {Text: "Maybe", CallbackData: "Maybe"}},
+		},
+	}, kb)
+
+	// callback data is limited to 64 bytes
+	long := strings.Repeat("é", 40)
+	kb = telegram.NewInlineKeyboardFromReplies([]string{long})
+	assert.Equal(t, long, kb.InlineKeyboard[0][0].Text)
+	assert.Equal(t, strings.Repeat("é", 32), kb.InlineKeyboard[0][0].CallbackData)
+}
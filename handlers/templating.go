@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/i18n"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// countries where dates are written month first or year first, everywhere else writes them day first
+var (
+	monthFirstCountries = map[string]bool{"US": true, "PH": true, "FM": true, "MH": true, "PW": true}
+	yearFirstCountries  = map[string]bool{"CN": true, "JP": true, "KR": true, "KP": true, "TW": true, "HU": true, "LT": true, "MN": true, "IR": true}
+)
+
+// FormatTemplatingVariables returns the variables of the given templating with any values that have a format hint,
+// e.g. numbers, currencies and dates, formatted for the given locale, or if that's empty, the template's language.
+// Values which can't be parsed are left as they are.
+func FormatTemplatingVariables(templating *courier.Templating, locale i18n.Locale) []courier.TemplatingVariable {
+	if locale == i18n.NilLocale {
+		locale = i18n.Locale(templating.Language)
+	}
+
+	tag, _ := language.Parse(string(locale))
+	p := message.NewPrinter(tag)
+
+	vars := make([]courier.TemplatingVariable, len(templating.Variables))
+	for i, v := range templating.Variables {
+		if v.Type == "text" && v.Format != "" {
+			v.Value = formatTemplatingValue(p, tag, v)
+		}
+		vars[i] = v
+	}
+	return vars
+}
+
+func formatTemplatingValue(p *message.Printer, tag language.Tag, v courier.TemplatingVariable) string {
+	switch v.Format {
+	case courier.TemplatingFormatNumber:
+		if n, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return p.Sprint(number.Decimal(n))
+		}
+	case courier.TemplatingFormatCurrency:
+		n, err := strconv.ParseFloat(v.Value, 64)
+		unit, uErr := currency.ParseISO(v.Currency)
+		if err == nil && uErr == nil {
+			scale, _ := currency.Standard.Rounding(unit)
+			amount := p.Sprint(number.Decimal(n, number.Scale(scale)))
+			symbol := p.Sprint(currency.Symbol(unit))
+
+			// symbols like $ are written against the amount but codes like KES need a space
+			if r := []rune(symbol); unicode.IsLetter(r[len(r)-1]) {
+				return symbol + " " + amount
+			}
+			return symbol + amount
+		}
+	case courier.TemplatingFormatDate:
+		if d, err := parseTemplatingDate(v.Value); err == nil {
+			region, _ := tag.Region()
+			if monthFirstCountries[region.String()] {
+				return d.Format("01/02/2006")
+			} else if yearFirstCountries[region.String()] {
+				return d.Format("2006/01/02")
+			}
+			return d.Format("02/01/2006")
+		}
+	}
+	return v.Value
+}
+
+// parses a date value which can be a date like 2024-03-15 or a datetime like 2024-03-15T10:30:00Z
+func parseTemplatingDate(s string) (time.Time, error) {
+	if d, err := time.Parse(time.DateOnly, s); err == nil {
+		return d, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,46 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatTemplatingVariables(t *testing.T) {
+	tcs := []struct {
+		variable courier.TemplatingVariable
+		locale   i18n.Locale
+		expected string
+	}{
+		{courier.TemplatingVariable{Type: "text", Value: "1234.5"}, "eng-US", "1234.5"}, // no hint so left as is
+		{courier.TemplatingVariable{Type: "text", Value: "1234567.5", Format: "number"}, "eng-US", "1,234,567.5"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234567.5", Format: "number"}, "spa-EC", "1.234.567,5"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234567.5", Format: "number"}, "fra-FR", "1\u00a0234\u00a0567,5"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234567.5", Format: "number"}, "hin-IN", "12,34,567.5"},
+		{courier.TemplatingVariable{Type: "text", Value: "lots", Format: "number"}, "eng-US", "lots"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234.5", Format: "currency", Currency: "USD"}, "eng-US", "$1,234.50"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234.5", Format: "currency", Currency: "EUR"}, "deu-DE", "€1.234,50"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234.5", Format: "currency", Currency: "KES"}, "eng-KE", "Ksh 1,234.50"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234.6", Format: "currency", Currency: "RWF"}, "kin-RW", "RF 1.235"},
+		{courier.TemplatingVariable{Type: "text", Value: "1234.5", Format: "currency", Currency: "XYZ"}, "eng-US", "1234.5"},
+		{courier.TemplatingVariable{Type: "text", Value: "2024-03-15", Format: "date"}, "eng-US", "03/15/2024"},
+		{courier.TemplatingVariable{Type: "text", Value: "2024-03-15", Format: "date"}, "spa-EC", "15/03/2024"},
+		{courier.TemplatingVariable{Type: "text", Value: "2024-03-15T10:30:00Z", Format: "date"}, "jpn-JP", "2024/03/15"},
+		{courier.TemplatingVariable{Type: "text", Value: "tomorrow", Format: "date"}, "eng-US", "tomorrow"},
+		{courier.TemplatingVariable{Type: "image/jpeg", Value: "image/jpeg:https://example.com/1234.jpg", Format: "number"}, "eng-US", "image/jpeg:https://example.com/1234.jpg"},
+	}
+
+	for _, tc := range tcs {
+		templating := &courier.Templating{Variables: []courier.TemplatingVariable{tc.variable}}
+		actual := handlers.FormatTemplatingVariables(templating, tc.locale)
+
+		assert.Equal(t, tc.expected, actual[0].Value, "format mismatch for %s in %s", tc.variable.Value, tc.locale)
+	}
+
+	// if message has no locale, template language is used
+	templating := &courier.Templating{Language: "spa", Variables: []courier.TemplatingVariable{{Type: "text", Value: "1234.5", Format: "number"}}}
+	assert.Equal(t, "1.234,5", handlers.FormatTemplatingVariables(templating, i18n.NilLocale)[0].Value)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -64,6 +65,8 @@ type IncomingTestCase struct {
 	ExpectedURN           urns.URN
 	ExpectedURNAuthTokens map[urns.URN]map[string]string
 	ExpectedAttachments   []string
+	ExpectedMsgMetadata   string
+	ExpectedMsgEcho       bool
 	ExpectedDate          time.Time
 	ExpectedExternalID    string
 	ExpectedMsgID         int64
@@ -176,6 +179,10 @@ func RunIncomingTestCases(t *testing.T, channels []courier.Channel, handler cour
 				if len(tc.ExpectedAttachments) > 0 {
 					assert.Equal(t, tc.ExpectedAttachments, msg.Attachments())
 				}
+				if tc.ExpectedMsgMetadata != "" {
+					assert.JSONEq(t, tc.ExpectedMsgMetadata, string(msg.Metadata()))
+				}
+				assert.Equal(t, tc.ExpectedMsgEcho, msg.IsEcho(), "msg echo mismatch")
 				if !tc.ExpectedDate.IsZero() {
 					assert.Equal(t, tc.ExpectedDate.Local(), msg.ReceivedOn().Local())
 				}
@@ -295,6 +302,7 @@ func (e *ExpectedRequest) AssertMatches(t *testing.T, actual *http.Request, requ
 type OutgoingTestCase struct {
 	Label string
 
+	MsgUUID                 courier.MsgUUID
 	MsgText                 string
 	MsgURN                  string
 	MsgURNAuth              string
@@ -302,6 +310,8 @@ type OutgoingTestCase struct {
 	MsgQuickReplies         []string
 	MsgLocale               i18n.Locale
 	MsgTopic                string
+	MsgURLPreview           *bool
+	MsgMetadata             string
 	MsgTemplating           string
 	MsgHighPriority         bool
 	MsgResponseToExternalID string
@@ -315,6 +325,7 @@ type OutgoingTestCase struct {
 
 	ExpectedRequests    []ExpectedRequest
 	ExpectedExtIDs      []string
+	ExpectedSegments    int
 	ExpectedError       error
 	ExpectedLogErrors   []*clogs.LogError
 	ExpectedContactURNs map[string]bool
@@ -332,6 +343,10 @@ func (tc *OutgoingTestCase) Msg(mb *test.MockBackend, ch courier.Channel) courie
 	m.WithLocale(tc.MsgLocale)
 	m.WithUserID(tc.MsgUserID)
 
+	if tc.MsgUUID != "" {
+		m.WithUUID(tc.MsgUUID)
+	}
+
 	for _, a := range tc.MsgAttachments {
 		m.WithAttachment(a)
 	}
@@ -349,6 +364,12 @@ func (tc *OutgoingTestCase) Msg(mb *test.MockBackend, ch courier.Channel) courie
 	if tc.MsgOptIn != nil {
 		m.WithOptIn(tc.MsgOptIn)
 	}
+	if tc.MsgURLPreview != nil {
+		m.WithURLPreview(*tc.MsgURLPreview)
+	}
+	if tc.MsgMetadata != "" {
+		m.WithMetadata(json.RawMessage(tc.MsgMetadata))
+	}
 	return m
 }
 
@@ -408,6 +429,9 @@ func RunOutgoingTestCases(t *testing.T, channel courier.Channel, handler courier
 			}
 
 			assert.Equal(t, tc.ExpectedExtIDs, externalIDs, "external IDs mismatch")
+			if tc.ExpectedSegments != 0 {
+				assert.Equal(t, tc.ExpectedSegments, res.Segments(), "segments mismatch")
+			}
 			assert.Equal(t, tc.ExpectedError, serr, "send method error mismatch")
 			assert.Equal(t, append([]*clogs.LogError{}, tc.ExpectedLogErrors...), clog.Errors, "channel log errors mismatch")
 
@@ -477,3 +501,6 @@ func AssertChannelLogRedaction(t *testing.T, clog *courier.ChannelLog, vals []st
 
 // Sp is a utility method to get the pointer to the passed in string
 func Sp(s string) *string { return &s }
+
+// Bp is a utility method to get the pointer to the passed in bool
+func Bp(b bool) *bool { return &b }
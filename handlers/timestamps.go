@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+)
+
+// TimestampUnit is the unit of the numeric timestamps a channel type sends us
+type TimestampUnit int
+
+const (
+	TimestampSeconds         TimestampUnit = iota // e.g. 1523040421
+	TimestampMillis                               // e.g. 1523040421123
+	TimestampSecondsOrMillis                      // either, detected by magnitude
+)
+
+// numeric timestamps at least this large are assumed to be milliseconds, as in seconds they'd be thousands of years away
+const minMillisTimestamp = 1_000_000_000_000
+
+// TimestampFormat declares how a channel type formats the string timestamps it sends us
+type TimestampFormat struct {
+	Layouts  []string       // layouts to try in order
+	Location *time.Location // location of timestamps without an explicit offset, defaults to UTC
+}
+
+// Parse parses the given timestamp using the first layout which matches, returning it in UTC and clamped to now. If no
+// layout matches, the error from the first layout is returned.
+func (f *TimestampFormat) Parse(s string) (time.Time, error) {
+	loc := f.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var firstErr error
+	for _, layout := range f.Layouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err == nil {
+			return ClampTimestamp(t), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no layouts to parse timestamp: %s", s)
+	}
+	return time.Time{}, firstErr
+}
+
+// ParseUnixTimestamp converts the given numeric timestamp to a time in UTC, clamped to now
+func ParseUnixTimestamp(ts int64, unit TimestampUnit) time.Time {
+	if unit == TimestampMillis || (unit == TimestampSecondsOrMillis && ts >= minMillisTimestamp) {
+		return ClampTimestamp(time.UnixMilli(ts))
+	}
+	return ClampTimestamp(time.Unix(ts, 0))
+}
+
+// ClampTimestamp returns the given time in UTC, or the current time if the given time is in the future, as can happen
+// when a channel's clock is ahead of ours, and which would put the message out of order
+func ClampTimestamp(t time.Time) time.Time {
+	now := dates.Now()
+	if t.After(now) {
+		return now.UTC()
+	}
+	return t.UTC()
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampFormat(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	kl, _ := time.LoadLocation("Asia/Kuala_Lumpur")
+
+	tcs := []struct {
+		format   *handlers.TimestampFormat
+		value    string
+		expected time.Time
+		err      string
+	}{
+		{&handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z"}}, "2017-05-03T06:04:45Z", time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{&handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z", "2006-01-02 15:04:05"}}, "2017-05-03 06:04:45", time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{&handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05.000"}}, "2017-05-03T06:04:45.123", time.Date(2017, 5, 3, 6, 4, 45, 123000000, time.UTC), ""},
+		{&handlers.TimestampFormat{Layouts: []string{time.RFC3339Nano}}, "2017-05-03T08:04:45.123+02:00", time.Date(2017, 5, 3, 6, 4, 45, 123000000, time.UTC), ""},
+		{&handlers.TimestampFormat{Layouts: []string{"2006-01-0215:04:05"}, Location: kl}, "2016-03-3019:20:09", time.Date(2016, 3, 30, 11, 20, 9, 0, time.UTC), ""},
+
+		// future dated timestamps are clamped to now
+		{&handlers.TimestampFormat{Layouts: []string{time.RFC3339Nano}}, "2024-06-12T10:35:00Z", time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC), ""},
+
+		{&handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z", "2006-01-02 15:04:05"}}, "2017-05-03", time.Time{}, `parsing time "2017-05-03" as "2006-01-02T15:04:05Z"`},
+	}
+
+	for _, tc := range tcs {
+		actual, err := tc.format.Parse(tc.value)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, "error mismatch for %s", tc.value)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual, "timestamp mismatch for %s", tc.value)
+			assert.Equal(t, time.UTC, actual.Location())
+		}
+	}
+}
+
+func TestParseUnixTimestamp(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	tcs := []struct {
+		ts       int64
+		unit     handlers.TimestampUnit
+		expected time.Time
+	}{
+		{1523040421, handlers.TimestampSeconds, time.Date(2018, 4, 6, 18, 47, 1, 0, time.UTC)},
+		{1523040421123, handlers.TimestampMillis, time.Date(2018, 4, 6, 18, 47, 1, 123000000, time.UTC)},
+		{1523040421, handlers.TimestampSecondsOrMillis, time.Date(2018, 4, 6, 18, 47, 1, 0, time.UTC)},
+		{1523040421123, handlers.TimestampSecondsOrMillis, time.Date(2018, 4, 6, 18, 47, 1, 123000000, time.UTC)},
+
+		// future dated timestamps are clamped to now
+		{1718188500, handlers.TimestampSeconds, time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC)},
+		{1718188500000, handlers.TimestampMillis, time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tcs {
+		actual := handlers.ParseUnixTimestamp(tc.ts, tc.unit)
+		assert.Equal(t, tc.expected, actual, "timestamp mismatch for %d", tc.ts)
+		assert.Equal(t, time.UTC, actual.Location())
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// cached tokens expire this long before the provider says they do so that we never send one that's about to expire
+	tokenExpiryMargin = time.Minute
+
+	// how long tokens are cached for when the provider doesn't tell us when they expire
+	defaultTokenExpiry = time.Hour
+
+	// how long a fetch of a new token can take, regardless of whether the callers waiting on it give up
+	tokenFetchTimeout = 30 * time.Second
+)
+
+// TokenFetcher is the interface handlers which authenticate with short-lived access tokens, e.g. OAuth2 client
+// credentials, should satisfy so that a TokenManager can cache and refresh their tokens.
+type TokenFetcher interface {
+	Backend() courier.Backend
+	RedactValues(courier.Channel) []string
+
+	// FetchAccessToken requests a new access token for the channel from the provider, returning it with its lifetime
+	FetchAccessToken(ctx context.Context, ch courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error)
+}
+
+// TokenManager caches the access tokens of channels in Valkey so that they're shared across instances, and makes sure
+// that within an instance only one fetch of a new token for a channel is in progress at a time.
+type TokenManager struct {
+	fetcher TokenFetcher
+	fetches singleflight.Group
+}
+
+// NewTokenManager creates a new token manager which uses the given fetcher to get new tokens
+func NewTokenManager(fetcher TokenFetcher) *TokenManager {
+	return &TokenManager{fetcher: fetcher}
+}
+
+// the result of a fetch shared by concurrent callers, with the log of any requests it made
+type tokenFetch struct {
+	token string
+	log   *courier.ChannelLog
+}
+
+// Token returns the access token for the given channel, fetching a new one if there isn't one cached. Any request made
+// to fetch a new token is logged to the given channel log.
+func (m *TokenManager) Token(ctx context.Context, ch courier.Channel, clog *courier.ChannelLog) (string, error) {
+	key := tokenKey(ch)
+
+	token, err := m.cached(key)
+	if err != nil || token != "" {
+		return token, err
+	}
+
+	// concurrent callers share the same fetch, which is independent of any one caller's context so that it isn't
+	// cancelled for all of them if the first caller gives up, and is logged separately so every caller gets its requests
+	results := m.fetches.DoChan(key, func() (any, error) {
+		// the first caller could have just missed a token being cached
+		if token, err := m.cached(key); err != nil || token != "" {
+			return &tokenFetch{token: token}, err
+		}
+
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+		defer cancel()
+
+		fetchLog := courier.NewChannelLog(clog.Type, ch, m.fetcher.RedactValues(ch))
+		token, err := m.fetch(fetchCtx, key, ch, fetchLog)
+		return &tokenFetch{token: token, log: fetchLog}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-results:
+		f := r.Val.(*tokenFetch)
+		if f.log != nil {
+			clog.HttpLogs = append(clog.HttpLogs, f.log.HttpLogs...)
+			clog.Errors = append(clog.Errors, f.log.Errors...)
+		}
+		return f.token, r.Err
+	}
+}
+
+// Clear removes the cached access token of the given channel, e.g. because the provider has rejected it as expired
+func (m *TokenManager) Clear(ch courier.Channel) error {
+	rc := m.fetcher.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	_, err := rc.Do("DEL", tokenKey(ch))
+	return err
+}
+
+func tokenKey(ch courier.Channel) string {
+	return fmt.Sprintf("channel-token:%s", ch.UUID())
+}
+
+func (m *TokenManager) cached(key string) (string, error) {
+	rc := m.fetcher.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	token, err := redis.String(rc.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading cached access token: %w", err)
+	}
+	return token, nil
+}
+
+func (m *TokenManager) fetch(ctx context.Context, key string, ch courier.Channel, clog *courier.ChannelLog) (string, error) {
+	token, expires, err := m.fetcher.FetchAccessToken(ctx, ch, clog)
+	if err == nil && token == "" {
+		err = errors.New("no token returned")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching new access token: %w", err)
+	}
+
+	if expires <= 0 {
+		expires = defaultTokenExpiry
+	}
+	ttl := max(expires-tokenExpiryMargin, expires/2)
+
+	rc := m.fetcher.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	if _, err := rc.Do("SET", key, token, "PX", ttl.Milliseconds()); err != nil {
+		return "", fmt.Errorf("error updating cached access token: %w", err)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,172 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTokenFetcher struct {
+	handlers.BaseHandler
+
+	fetches atomic.Int32
+	delay   time.Duration
+	token   string
+	expires time.Duration
+	err     error
+}
+
+func (f *testTokenFetcher) FetchAccessToken(ctx context.Context, ch courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+	f.fetches.Add(1)
+	clog.Error(courier.ErrorExternal("fetch", "fetching token"))
+
+	select {
+	case <-time.After(10*time.Millisecond + f.delay):
+	case <-ctx.Done():
+		return "", 0, ctx.Err()
+	}
+	return f.token, f.expires, f.err
+}
+
+func TestTokenManager(t *testing.T) {
+	ctx := context.Background()
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mc, nil)
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8")
+
+	fetcher := &testTokenFetcher{BaseHandler: handlers.NewBaseHandler("NX", "Test"), token: "sesame", expires: time.Hour}
+	fetcher.SetServer(test.NewMockServer(courier.NewDefaultConfig(), mb))
+	tokens := handlers.NewTokenManager(fetcher)
+
+	// concurrent requests for a token share a single fetch
+	wg := &sync.WaitGroup{}
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tokens.Token(ctx, mc, clog)
+			assert.NoError(t, err)
+			assert.Equal(t, "sesame", token)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), fetcher.fetches.Load())
+
+	// token is cached until just before it expires
+	ttl, err := redis.Int(rc.Do("TTL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8"))
+	require.NoError(t, err)
+	assert.Equal(t, 59*60, ttl)
+
+	token, err := tokens.Token(ctx, mc, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "sesame", token)
+	assert.Equal(t, int32(1), fetcher.fetches.Load())
+
+	// once it's gone, a new token is fetched
+	rc.Do("DEL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8")
+	fetcher.token = "sesame2"
+	fetcher.expires = 0
+
+	token, err = tokens.Token(ctx, mc, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "sesame2", token)
+	assert.Equal(t, int32(2), fetcher.fetches.Load())
+
+	// providers that don't say when tokens expire get the default
+	ttl, err = redis.Int(rc.Do("TTL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8"))
+	require.NoError(t, err)
+	assert.Equal(t, 59*60, ttl)
+
+	// and short-lived tokens are cached for half their lifetime
+	rc.Do("DEL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8")
+	fetcher.expires = time.Minute
+
+	_, err = tokens.Token(ctx, mc, clog)
+	assert.NoError(t, err)
+	ttl, err = redis.Int(rc.Do("TTL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8"))
+	require.NoError(t, err)
+	assert.Equal(t, 30, ttl)
+
+	// errors and empty tokens aren't cached
+	rc.Do("DEL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8")
+	fetcher.err = errors.New("boom")
+
+	_, err = tokens.Token(ctx, mc, clog)
+	assert.EqualError(t, err, "error fetching new access token: boom")
+
+	fetcher.token = ""
+	fetcher.err = nil
+
+	_, err = tokens.Token(ctx, mc, clog)
+	assert.EqualError(t, err, "error fetching new access token: no token returned")
+
+	exists, err := redis.Bool(rc.Do("EXISTS", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// cached tokens can be cleared
+	rc.Do("SET", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "sesame")
+	assert.NoError(t, tokens.Clear(mc))
+
+	exists, err = redis.Bool(rc.Do("EXISTS", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTokenManagerSharedFetch(t *testing.T) {
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "channel-token:7a8ff1d4-f211-4492-9d05-e1905f6da8c8")
+
+	fetcher := &testTokenFetcher{BaseHandler: handlers.NewBaseHandler("NX", "Test"), token: "sesame", expires: time.Hour, delay: 100 * time.Millisecond}
+	fetcher.SetServer(test.NewMockServer(courier.NewDefaultConfig(), mb))
+	tokens := handlers.NewTokenManager(fetcher)
+
+	// first caller gives up before the fetch completes
+	ctx1, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	clog1 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mc, nil)
+	clog2 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mc, nil)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := tokens.Token(ctx1, mc, clog1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}()
+	go func() {
+		defer wg.Done()
+		time.Sleep(5 * time.Millisecond)
+
+		// but the second caller still gets the token from the same fetch
+		token, err := tokens.Token(context.Background(), mc, clog2)
+		assert.NoError(t, err)
+		assert.Equal(t, "sesame", token)
+	}()
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetcher.fetches.Load())
+
+	// and its channel log gets what the fetch logged
+	assert.Len(t, clog2.Errors, 1)
+	assert.Len(t, clog1.Errors, 0)
+}
@@ -0,0 +1,124 @@
+package twiml
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/redisx"
+)
+
+var twilioContentURL = "https://content.twilio.com/v1/Content"
+
+// content templates are cached briefly so that sends don't each need a request, but new and edited ones are picked up
+func contentCache() *redisx.IntervalHash {
+	return redisx.NewIntervalHash(valkey.Tag("twilio-content"), time.Minute*15, 2)
+}
+
+// see https://www.twilio.com/docs/content/content-api-resources#fetch-all-content-resources
+type contentPage struct {
+	Contents []struct {
+		SID       string            `json:"sid"`
+		Variables map[string]string `json:"variables"`
+	} `json:"contents"`
+	Meta struct {
+		NextPageURL string `json:"next_page_url"`
+	} `json:"meta"`
+}
+
+// validateContentVariables checks that the given variables are those of the given content template, returning
+// ErrMessageInvalid with an error in the log explaining why if not. If templates can't be fetched, the send goes ahead.
+func (h *handler) validateContentVariables(channel courier.Channel, accountSID, accountToken, contentSID string, variables map[string]string, clog *courier.ChannelLog) error {
+	templates, cached, err := h.contentTemplates(channel, accountSID, accountToken, false, clog)
+
+	// templates created since we cached them won't be there so check again before failing the message
+	if _, exists := templates[contentSID]; err == nil && cached && !exists {
+		templates, _, err = h.contentTemplates(channel, accountSID, accountToken, true, clog)
+	}
+	if err != nil {
+		slog.Error("error fetching twilio content templates", "error", err, "channel_uuid", channel.UUID())
+		return nil
+	}
+
+	expected, exists := templates[contentSID]
+	if !exists {
+		clog.Error(clogs.NewLogError("template_missing", "", "Content template %s doesn't exist.", contentSID))
+		return courier.ErrMessageInvalid
+	}
+
+	actual := slices.Sorted(maps.Keys(variables))
+	if !slices.Equal(expected, actual) {
+		clog.Error(clogs.NewLogError("template_variables", "", "Content template %s has variables [%s] but message has [%s].", contentSID, strings.Join(expected, ", "), strings.Join(actual, ", ")))
+		return courier.ErrMessageInvalid
+	}
+
+	return nil
+}
+
+// returns the sorted variable names of the content templates of the given channel by their SID, from the cache
+// unless refresh is set, and whether they came from the cache
+func (h *handler) contentTemplates(channel courier.Channel, accountSID, accountToken string, refresh bool, clog *courier.ChannelLog) (map[string][]string, bool, error) {
+	field := string(channel.UUID())
+
+	if !refresh {
+		var cached string
+		var err error
+		h.WithRedisConn(func(rc redis.Conn) {
+			cached, err = contentCache().Get(rc, field)
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading content templates from redis: %w", err)
+		}
+		if cached != "" {
+			templates := make(map[string][]string)
+			if err := json.Unmarshal([]byte(cached), &templates); err != nil {
+				return nil, false, fmt.Errorf("error unmarshalling cached content templates: %w", err)
+			}
+			return templates, true, nil
+		}
+	}
+
+	templates := make(map[string][]string)
+
+	for pageURL := twilioContentURL + "?PageSize=500"; pageURL != ""; {
+		req, _ := http.NewRequest(http.MethodGet, pageURL, nil)
+		req.SetBasicAuth(accountSID, accountToken)
+		req.Header.Set("Accept", "application/json")
+
+		resp, respBody, err := h.RequestHTTP(req, clog)
+		if err != nil || resp.StatusCode/100 != 2 {
+			return nil, false, fmt.Errorf("error fetching content templates")
+		}
+
+		page := &contentPage{}
+		if err := json.Unmarshal(respBody, page); err != nil {
+			clog.Error(courier.ErrorResponseUnparseable("JSON"))
+			return nil, false, fmt.Errorf("error unmarshalling content templates: %w", err)
+		}
+
+		for _, c := range page.Contents {
+			templates[c.SID] = slices.Sorted(maps.Keys(c.Variables))
+		}
+		pageURL = page.Meta.NextPageURL
+	}
+
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		err = contentCache().Set(rc, field, string(jsonx.MustMarshal(templates)))
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("error caching content templates: %w", err)
+	}
+
+	return templates, false, nil
+}
@@ -11,6 +11,7 @@ import (
 	"crypto/sha1"
 	_ "embed"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -18,6 +19,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -42,8 +44,9 @@ const (
 )
 
 var (
-	maxMsgLength  = 1600
-	twilioBaseURL = "https://api.twilio.com"
+	maxMsgLength           = 1600
+	twilioBaseURL          = "https://api.twilio.com"
+	twilioDeactivationsURL = "https://messaging.twilio.com/v1/Deactivations"
 
 	//go:embed errors.json
 	errorCodes []byte
@@ -83,6 +86,7 @@ func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
 	s.AddHandlerRoute(h, http.MethodPost, "status", courier.ChannelLogTypeMsgStatus, h.receiveStatus)
+	s.AddHandlerRoute(h, http.MethodPost, "gather", courier.ChannelLogTypeMsgReceive, h.receiveGather)
 	return nil
 }
 
@@ -98,6 +102,17 @@ type moForm struct {
 	NumMedia    int
 }
 
+// see https://www.twilio.com/docs/voice/twiml/gather#action
+type gatherForm struct {
+	CallSID       string `validate:"required"`
+	AccountSID    string `validate:"required"`
+	From          string `validate:"required"`
+	FromCountry   string
+	To            string
+	Digits        string
+	FinishedOnKey string
+}
+
 type statusForm struct {
 	MessageSID    string `validate:"required"`
 	MessageStatus string `validate:"required"`
@@ -105,6 +120,28 @@ type statusForm struct {
 	To            string
 }
 
+// see https://www.twilio.com/docs/voice/api/call-resource#statuscallback and
+// https://www.twilio.com/docs/voice/answering-machine-detection#asyncamdstatuscallback-parameters
+type callStatusForm struct {
+	CallSID      string `validate:"required"`
+	CallStatus   string
+	CallDuration string
+	AnsweredBy   string
+	Direction    string
+	From         string
+	To           string
+}
+
+// call statuses which are outcomes worth recording, queued, ringing etc are ignored
+var callStatusMapping = map[string]string{
+	"in-progress": "answered",
+	"completed":   "completed",
+	"busy":        "busy",
+	"no-answer":   "no_answer",
+	"failed":      "failed",
+	"canceled":    "canceled",
+}
+
 var statusMapping = map[string]courier.MsgStatus{
 	"queued":      courier.MsgStatusSent,
 	"failed":      courier.MsgStatusFailed,
@@ -154,6 +191,36 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
 }
 
+// receiveGather is our HTTP handler function for the digits entered by a contact during a call, which are used as the
+// text of an incoming message so that simple IVR flows can be driven without a separate IVR service
+func (h *handler) receiveGather(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	form := &gatherForm{}
+	err = handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	if form.Digits == "" {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "no digits entered, ignoring")
+	}
+
+	urn, err := h.parseURN(channel, form.From, i18n.Country(form.FromCountry))
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// a call can have several gathers so the call SID isn't used as the external ID
+	session := &handlers.VoiceSession{CallID: form.CallSID, FinishedOnKey: form.FinishedOnKey}
+	msg := h.Backend().NewIncomingMsg(channel, urn, form.Digits, "", clog).WithMetadata(session.Metadata())
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
 // receiveStatus is our HTTP handler function for status updates
 func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
 	err := h.validateSignature(channel, r)
@@ -161,6 +228,11 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 		return nil, err
 	}
 
+	// calls post their statuses and answering machine detection results here too
+	if r.PostFormValue("CallSid") != "" && r.PostFormValue("MessageSid") == "" {
+		return h.receiveCallStatus(ctx, channel, w, r, clog)
+	}
+
 	// get our params
 	form := &statusForm{}
 	err = handlers.DecodeAndValidateForm(form, r)
@@ -219,6 +291,55 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
+// receiveCallStatus writes the outcome of a call, i.e. whether it was answered and by a person or a machine, as a
+// channel event so that deployments making calls can track them
+func (h *handler) receiveCallStatus(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &callStatusForm{}
+	err := handlers.DecodeAndValidateForm(form, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	// answering machine detection results come from calls which have been answered
+	status, found := callStatusMapping[form.CallStatus]
+	if !found && form.AnsweredBy != "" {
+		status, found = "answered", true
+	}
+	if !found {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring call status '%s'", form.CallStatus))
+	}
+
+	// the contact is who was called for calls we made, and who called for calls we received
+	contact := form.From
+	if strings.HasPrefix(form.Direction, "outbound") {
+		contact = form.To
+	}
+	if contact == "" {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "no contact number for call, ignoring")
+	}
+
+	urn, err := h.parseURN(channel, contact, "")
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	extra := map[string]string{"call_id": form.CallSID, "status": status}
+	if form.CallDuration != "" {
+		extra["duration"] = form.CallDuration
+	}
+	if form.AnsweredBy != "" {
+		extra["answered_by"] = form.AnsweredBy
+	}
+
+	event := h.Backend().NewChannelEvent(channel, courier.EventTypeCallStatus, urn, clog).WithExtra(extra)
+
+	if err := h.Backend().WriteChannelEvent(ctx, event, clog); err != nil {
+		return nil, err
+	}
+
+	return []courier.Event{event}, courier.WriteChannelEventSuccess(w, event)
+}
+
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 	// build our callback URL
 	callbackDomain := msg.Channel().CallbackDomain(h.Server().Config().Domain)
@@ -232,6 +353,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 	channel := msg.Channel()
 
+	// Twilio associates 10DLC campaigns with messaging services, so sends from 10DLC numbers must go through one
+	if channel.BoolConfigForKey(courier.ConfigTenDLC, false) && channel.StringConfigForKey(configMessagingServiceSID, "") == "" {
+		return courier.ErrCampaignMissing
+	}
+
 	attachments, err := handlers.ResolveAttachments(ctx, h.Backend(), msg.Attachments(), mediaSupport, true, clog)
 	if err != nil {
 		return err
@@ -259,13 +385,14 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			form["From"] = []string{fmt.Sprintf("%s:%s", urns.WhatsApp.Prefix, channel.Address())}
 		}
 
-		contentVariables := make(map[string]string, len(msg.Templating().Variables))
+		variables := handlers.FormatTemplatingVariables(msg.Templating(), msg.Locale())
+		contentVariables := make(map[string]string, len(variables))
 
 		for _, comp := range msg.Templating().Components {
 			for varKey, varIndex := range comp.Variables {
-				value := msg.Templating().Variables[varIndex].Value
+				value := variables[varIndex].Value
 
-				if msg.Templating().Variables[varIndex].Type != "text" {
+				if variables[varIndex].Type != "text" {
 					_, value = handlers.SplitAttachment(value)
 				}
 
@@ -273,6 +400,13 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			}
 		}
 
+		// Twilio lets us check that the variables match those of the content template before sending
+		if h.baseURL(channel) == twilioBaseURL {
+			if err := h.validateContentVariables(channel, accountSID, accountToken, msg.Templating().ExternalID, contentVariables, clog); err != nil {
+				return err
+			}
+		}
+
 		contentVariablesJson := jsonx.MustMarshal(contentVariables)
 		if len(contentVariables) > 0 {
 			form["ContentVariables"] = []string{string(contentVariablesJson)}
@@ -438,6 +572,50 @@ func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend,
 	return req, nil
 }
 
+// FetchDeactivations fetches the US numbers deactivated by carriers on the given day, which Twilio publishes for an
+// account as a text file with a number on each line
+func (h *handler) FetchDeactivations(ctx context.Context, channel courier.Channel, day time.Time, clog *courier.ChannelLog) ([]string, error) {
+	if channel.ChannelType() != "T" && channel.ChannelType() != "TMS" {
+		return nil, nil
+	}
+
+	accountSID := channel.StringConfigForKey(configAccountSID, "")
+	accountToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if accountSID == "" || accountToken == "" {
+		return nil, fmt.Errorf("missing account sid or auth token for %s channel", h.ChannelName())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, twilioDeactivationsURL+"?"+url.Values{"Date": []string{day.Format(time.DateOnly)}}.Encode(), nil)
+	req.SetBasicAuth(accountSID, accountToken)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return nil, errors.New("unable to fetch deactivations")
+	}
+
+	fileURL, err := jsonparser.GetString(respBody, "redirect_to")
+	if err != nil {
+		clog.Error(courier.ErrorResponseValueMissing("redirect_to"))
+		return nil, errors.New("unable to fetch deactivations")
+	}
+
+	// the file itself is at a pre-signed URL so doesn't need auth
+	req, _ = http.NewRequest(http.MethodGet, fileURL, nil)
+
+	resp, respBody, err = h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return nil, errors.New("unable to fetch deactivations file")
+	}
+
+	numbers := make([]string, 0, 10)
+	for _, line := range strings.Split(string(respBody), "\n") {
+		if n := strings.TrimSpace(line); n != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil
+}
+
 func (h *handler) RedactValues(ch courier.Channel) []string {
 	return []string{
 		httpx.BasicAuth(ch.StringConfigForKey(configAccountSID, ""), ch.StringConfigForKey(courier.ConfigAuthToken, "")),
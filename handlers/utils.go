@@ -90,6 +90,33 @@ func DecodePossibleBase64(original string) string {
 	return decoded
 }
 
+// GroupMsgs groups the indexes of the given messages by the given key, e.g. for providers which send the same content
+// from the same sender to many recipients in one request. Groups are in the order their first message appears.
+func GroupMsgs(msgs []courier.MsgOut, key func(courier.MsgOut) string) [][]int {
+	groups := make([][]int, 0, 1)
+	byKey := make(map[string]int)
+
+	for i, m := range msgs {
+		k := key(m)
+		if g, ok := byKey[k]; ok {
+			groups[g] = append(groups[g], i)
+		} else {
+			byKey[k] = len(groups)
+			groups = append(groups, []int{i})
+		}
+	}
+	return groups
+}
+
 func IsURL(s string) bool {
 	return urlRegex.MatchString(s)
 }
+
+// PreviewURLs returns whether links in the given text of an outgoing message should be previewed, using the message's
+// explicit setting if it has one, and otherwise only if the text contains a link
+func PreviewURLs(msg courier.MsgOut, text string) bool {
+	if preview := msg.URLPreview(); preview != nil {
+		return *preview
+	}
+	return strings.Contains(text, "https://") || strings.Contains(text, "http://")
+}
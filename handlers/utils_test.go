@@ -3,7 +3,10 @@ package handlers_test
 import (
 	"testing"
 
+	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -96,6 +99,33 @@ func TestIsURL(t *testing.T) {
 	}
 }
 
+func TestPreviewURLs(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", []string{urns.WhatsApp.Prefix}, map[string]any{})
+	msg := test.NewMockMsg(1, "", ch, "whatsapp:250788123123", "", nil)
+
+	assert.True(t, handlers.PreviewURLs(msg, "Check out https://nyaruka.com"))
+	assert.False(t, handlers.PreviewURLs(msg, "No links here"))
+
+	msg.WithURLPreview(false)
+	assert.False(t, handlers.PreviewURLs(msg, "Check out https://nyaruka.com"))
+
+	msg.WithURLPreview(true)
+	assert.True(t, handlers.PreviewURLs(msg, "No links here"))
+}
+
+func TestGroupMsgs(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "MCK", "12345", "", []string{urns.Phone.Prefix}, map[string]any{})
+	msgs := []courier.MsgOut{
+		test.NewMockMsg(1, "", ch, "tel:+250788000001", "Hi", nil),
+		test.NewMockMsg(2, "", ch, "tel:+250788000002", "Bye", nil),
+		test.NewMockMsg(3, "", ch, "tel:+250788000003", "Hi", nil),
+	}
+
+	assert.Equal(t, [][]int{{0, 2}, {1}}, handlers.GroupMsgs(msgs, func(m courier.MsgOut) string { return m.Text() }))
+	assert.Equal(t, [][]int{{0}, {1}, {2}}, handlers.GroupMsgs(msgs, func(m courier.MsgOut) string { return m.URN().Path() }))
+	assert.Equal(t, [][]int{}, handlers.GroupMsgs(nil, func(m courier.MsgOut) string { return "" }))
+}
+
 var test6 = `
 SSByZWNlaXZlZCB5b3VyIGxldHRlciB0b2RheSwgaW4gd2hpY2ggeW91IHNheSB5b3Ugd2FudCB0
 byByZXNjdWUgTm9ydGggQ2Fyb2xpbmlhbnMgZnJvbSB0aGUgQUNBLCBvciBPYmFtYWNhcmUgYXMg
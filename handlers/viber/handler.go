@@ -21,6 +21,10 @@ import (
 
 const (
 	configViberWelcomeMessage = "welcome_message"
+
+	// send error codes which tell us the receiver can't be messaged, as opposed to problems with the message or account
+	sendErrorReceiverNotRegistered = 5
+	sendErrorReceiverNotSubscribed = 6
 )
 
 var (
@@ -29,6 +33,11 @@ var (
 	maxMsgLength         = 7000
 	descriptionMaxLength = 512
 
+	// https://developers.viber.com/docs/api/rest-bot-api/#picture-message
+	mediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
+		handlers.MediaTypeImage: {Types: []string{"image/jpeg", "image/png", "image/gif"}, MaxBytes: 1024 * 1024},
+	}
+
 	// https://developers.viber.com/docs/api/rest-bot-api/#error-codes
 	sendErrorCodes = map[int]string{
 		1:  "The webhook URL is not valid",
@@ -341,6 +350,10 @@ type mtPayload struct {
 	Size         int               `json:"size,omitempty"`
 	FileName     string            `json:"file_name,omitempty"`
 	Keyboard     *Keyboard         `json:"keyboard,omitempty"`
+
+	MinAPIVersion int        `json:"min_api_version,omitempty"`
+	RichMedia     *RichMedia `json:"rich_media,omitempty"`
+	AltText       string     `json:"alt_text,omitempty"`
 }
 
 type mtResponse struct {
@@ -354,6 +367,13 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
+	// rich media can be sent via the message metadata
+	richMedia, altText, err := GetRichMedia(msg.Metadata())
+	if err != nil {
+		clog.RawError(err)
+		return courier.ErrMessageInvalid
+	}
+
 	// figure out whether we have a keyboard to send as well
 	qrs := msg.QuickReplies()
 	var keyboard *Keyboard
@@ -372,7 +392,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		var err error
 
 		if part.Type == handlers.MsgPartTypeAttachment || part.Type == handlers.MsgPartTypeCaptionedAttachment {
-			mediaType, mediaURL := handlers.SplitAttachment(part.Attachment)
+			mediaType, mediaURL := handlers.ResolveAttachmentURL(ctx, h.Backend(), part.Attachment, mediaSupport, clog)
 			switch strings.Split(mediaType, "/")[0] {
 			case "image":
 				msgType = "picture"
@@ -406,7 +426,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			msgText = part.Text
 		}
 
-		payload := mtPayload{
+		payload := &mtPayload{
 			AuthToken:    authToken,
 			Receiver:     msg.URN().Path(),
 			Text:         msgText,
@@ -420,43 +440,76 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			payload.Size = attSize
 		}
 
-		requestBody := &bytes.Buffer{}
-		err = json.NewEncoder(requestBody).Encode(payload)
-		if err != nil {
+		if err := h.sendPayload(payload, clog); err != nil {
 			return err
 		}
 
-		// build our request
-		req, err := http.NewRequest(http.MethodPost, sendURL, requestBody)
-		if err != nil {
-			return err
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "application/json")
-
-		resp, respBody, err := h.RequestHTTP(req, clog)
-		if err != nil || resp.StatusCode/100 == 5 {
-			return courier.ErrConnectionFailed
-		} else if resp.StatusCode/100 != 2 {
-			return courier.ErrResponseStatus
-		}
+		keyboard = nil
+	}
 
-		respPayload := &mtResponse{}
-		err = json.Unmarshal(respBody, respPayload)
-		if err != nil {
-			return courier.ErrResponseUnparseable
+	if richMedia != nil {
+		payload := &mtPayload{
+			AuthToken:     authToken,
+			Receiver:      msg.URN().Path(),
+			Type:          "rich_media",
+			TrackingData:  msg.ID().String(),
+			Keyboard:      keyboard,
+			MinAPIVersion: 2,
+			RichMedia:     richMedia,
+			AltText:       altText,
 		}
 
-		if respPayload.Status != 0 {
-			errorMessage, found := sendErrorCodes[respPayload.Status]
-			if !found {
-				errorMessage = "General error"
-			}
-			return courier.ErrFailedWithReason(strconv.Itoa(respPayload.Status), errorMessage)
+		if err := h.sendPayload(payload, clog); err != nil {
+			return err
 		}
+	}
 
-		keyboard = nil
+	return nil
+}
+
+func (h *handler) sendPayload(payload *mtPayload, clog *courier.ChannelLog) error {
+	requestBody := &bytes.Buffer{}
+	err := json.NewEncoder(requestBody).Encode(payload)
+	if err != nil {
+		return err
+	}
+
+	// build our request
+	req, err := http.NewRequest(http.MethodPost, sendURL, requestBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	} else if resp.StatusCode/100 != 2 {
+		return courier.ErrResponseStatus
 	}
+
+	respPayload := &mtResponse{}
+	err = json.Unmarshal(respBody, respPayload)
+	if err != nil {
+		return courier.ErrResponseUnparseable
+	}
+
+	switch respPayload.Status {
+	case sendErrorReceiverNotRegistered:
+		return courier.ErrContactUnknown
+	case sendErrorReceiverNotSubscribed:
+		return courier.ErrContactStopped
+	}
+
+	if respPayload.Status != 0 {
+		errorMessage, found := sendErrorCodes[respPayload.Status]
+		if !found {
+			errorMessage = "General error"
+		}
+		return courier.ErrFailedWithReason(strconv.Itoa(respPayload.Status), errorMessage)
+	}
+
 	return nil
 }
 
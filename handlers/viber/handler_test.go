@@ -13,6 +13,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -219,6 +220,34 @@ var defaultSendTestCases = []OutgoingTestCase{
 		}},
 		ExpectedError: courier.ErrFailedWithReason("3", "There is an error in the request itself (missing comma, brackets, etc.)"),
 	},
+	{
+		Label:   "Receiver not registered",
+		MsgText: "Simple Message",
+		MsgURN:  "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://chatapi.viber.com/pa/send_message": {
+				httpx.NewMockResponse(200, nil, []byte(`{"status":5,"status_message":"receiverNotRegistered"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","text":"Simple Message","type":"text","tracking_data":"10"}`,
+		}},
+		ExpectedError: courier.ErrContactUnknown,
+	},
+	{
+		Label:   "Receiver not subscribed",
+		MsgText: "Simple Message",
+		MsgURN:  "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://chatapi.viber.com/pa/send_message": {
+				httpx.NewMockResponse(200, nil, []byte(`{"status":6,"status_message":"notSubscribed"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Body: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","text":"Simple Message","type":"text","tracking_data":"10"}`,
+		}},
+		ExpectedError: courier.ErrContactStopped,
+	},
 	{
 		Label:   "Got general error response",
 		MsgText: "Simple Message",
@@ -265,6 +294,50 @@ var defaultSendTestCases = []OutgoingTestCase{
 		}},
 		ExpectedError: courier.ErrResponseStatus,
 	},
+	{
+		Label:           "Send Rich Media",
+		MsgText:         "Our menu",
+		MsgURN:          "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MsgQuickReplies: []string{"Done"},
+		MsgMetadata:     `{"rich_media": {"columns": 6, "rows": 3, "alt_text": "Pizza menu", "buttons": [{"rows": 2, "image": "https://example.com/pizza.jpg", "action": "open-url", "body": "https://example.com/pizza"}, {"columns": 3, "text": "Order"}, {"columns": 3, "text": "More"}]}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://chatapi.viber.com/pa/send_message": {
+				httpx.NewMockResponse(200, nil, []byte(`{"status":0,"status_message":"ok","message_token":4987381194038857789}`)),
+				httpx.NewMockResponse(200, nil, []byte(`{"status":0,"status_message":"ok","message_token":4987381194038857790}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","text":"Our menu","type":"text","tracking_data":"10","keyboard":{"Type":"keyboard","DefaultHeight":false,"Buttons":[{"ActionType":"reply","ActionBody":"Done","Text":"Done","TextSize":"regular","Columns":"6"}]}}`,
+			},
+			{
+				Body: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","type":"rich_media","tracking_data":"10","min_api_version":2,"rich_media":{"Type":"rich_media","ButtonsGroupColumns":6,"ButtonsGroupRows":3,"Buttons":[{"Columns":6,"Rows":2,"ActionType":"open-url","ActionBody":"https://example.com/pizza","Image":"https://example.com/pizza.jpg"},{"Columns":3,"Rows":1,"ActionType":"reply","ActionBody":"Order","Text":"Order"},{"Columns":3,"Rows":1,"ActionType":"reply","ActionBody":"More","Text":"More"}]},"alt_text":"Pizza menu"}`,
+			},
+		},
+	},
+	{
+		Label:       "Send Rich Media Only",
+		MsgURN:      "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MsgMetadata: `{"rich_media": {"buttons": [{"rows": 7, "text": "Hi"}]}}`,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://chatapi.viber.com/pa/send_message": {
+				httpx.NewMockResponse(200, nil, []byte(`{"status":0,"status_message":"ok","message_token":4987381194038857789}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Body: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","type":"rich_media","tracking_data":"10","min_api_version":2,"rich_media":{"Type":"rich_media","ButtonsGroupColumns":6,"ButtonsGroupRows":7,"Buttons":[{"Columns":6,"Rows":7,"ActionType":"reply","ActionBody":"Hi","Text":"Hi"}]}}`,
+			},
+		},
+	},
+	{
+		Label:             "Send Invalid Rich Media",
+		MsgText:           "Our menu",
+		MsgURN:            "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MsgMetadata:       `{"rich_media": {"columns": 6, "rows": 3, "buttons": [{"columns": 7, "text": "Order"}]}}`,
+		ExpectedError:     courier.ErrMessageInvalid,
+		ExpectedLogErrors: []*clogs.LogError{clogs.NewLogError("", "", "button 0 must be between 1 and 6 columns and between 1 and 3 rows")},
+	},
 }
 
 var invalidTokenSendTestCases = []OutgoingTestCase{
@@ -291,6 +364,14 @@ var buttonLayoutSendTestCases = []OutgoingTestCase{
 	},
 }
 
+func setupMedia(mb *test.MockBackend) {
+	bigPNG := test.NewMockMedia("big.png", "image/png", "http://mock.com/1234/big.png", 3*1024*1024, 4000, 3000, 0, nil)
+	bigJPG := test.NewMockMedia("big.jpg", "image/jpeg", "http://mock.com/2345/big.jpg", 800*1024, 4000, 3000, 0, nil)
+
+	mb.MockMedia(bigPNG)
+	mb.MockResizedMedia(bigPNG.URL(), bigJPG)
+}
+
 func TestOutgoing(t *testing.T) {
 	attachmentService := buildMockAttachmentService(defaultSendTestCases)
 	defer attachmentService.Close()
@@ -315,6 +396,26 @@ func TestOutgoing(t *testing.T) {
 	RunOutgoingTestCases(t, defaultChannel, newHandler(), defaultSendTestCases, []string{"Token"}, nil)
 	RunOutgoingTestCases(t, invalidTokenChannel, newHandler(), invalidTokenSendTestCases, []string{"Token"}, nil)
 	RunOutgoingTestCases(t, buttonLayoutChannel, newHandler(), buttonLayoutSendTestCases, []string{"Token"}, nil)
+	RunOutgoingTestCases(t, defaultChannel, newHandler(), resizedMediaSendTestCases, []string{"Token"}, setupMedia)
+}
+
+// uploaded images which are too big are sent as resized versions of themselves
+var resizedMediaSendTestCases = []OutgoingTestCase{
+	{
+		Label:          "Send Attachment Resized",
+		MsgText:        "Big pic!",
+		MsgURN:         "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		MsgAttachments: []string{"image/png:http://mock.com/1234/big.png"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://chatapi.viber.com/pa/send_message": {
+				httpx.NewMockResponse(200, nil, []byte(`{"status":0,"status_message":"ok","message_token":4987381194038857789}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Content-Type": "application/json", "Accept": "application/json"},
+			Body:    `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","text":"Big pic!","type":"picture","tracking_data":"10","media":"http://mock.com/2345/big.jpg"}`,
+		}},
+	},
 }
 
 var testChannels = []courier.Channel{
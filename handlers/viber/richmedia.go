@@ -0,0 +1,202 @@
+package viber
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+
+	"github.com/buger/jsonparser"
+)
+
+const (
+	// maxRichMediaRows is the maximum number of rows in a group of buttons
+	maxRichMediaRows = 7
+	// maxRichMediaGroups is the maximum number of groups of buttons, i.e. items in the carousel
+	maxRichMediaGroups = 6
+)
+
+var richMediaActions = map[string]bool{"reply": true, "open-url": true, "none": true}
+
+// RichMediaButton is a button in a rich media message, see https://developers.viber.com/docs/tools/keyboards/#buttons-parameters
+type RichMediaButton struct {
+	Columns    int    `json:"Columns"`
+	Rows       int    `json:"Rows"`
+	ActionType string `json:"ActionType"`
+	ActionBody string `json:"ActionBody"`
+	Image      string `json:"Image,omitempty"`
+	Text       string `json:"Text,omitempty"`
+	TextSize   string `json:"TextSize,omitempty"`
+	BgColor    string `json:"BgColor,omitempty"`
+}
+
+// RichMedia models a rich media message, which displays its buttons as a carousel of groups, see
+// https://developers.viber.com/docs/api/rest-bot-api/#rich-media-message--carousel-content-message
+type RichMedia struct {
+	Type                string            `json:"Type"`
+	ButtonsGroupColumns int               `json:"ButtonsGroupColumns"`
+	ButtonsGroupRows    int               `json:"ButtonsGroupRows"`
+	BgColor             string            `json:"BgColor,omitempty"`
+	Buttons             []RichMediaButton `json:"Buttons"`
+}
+
+// rich media is requested by the message metadata, e.g.
+//
+//	"rich_media": {
+//	  "columns": 6, "rows": 7, "alt_text": "Our menu",
+//	  "buttons": [
+//	    {"rows": 4, "image": "https://example.com/pizza.jpg", "action": "open-url", "body": "https://example.com/pizza"},
+//	    {"rows": 2, "text": "Pizza"},
+//	    {"columns": 3, "text": "Order", "body": "order pizza"},
+//	    {"columns": 3, "text": "More", "action": "open-url", "body": "https://example.com/pizza"}
+//	  ]
+//	}
+type richMediaMetadata struct {
+	Columns int    `json:"columns"`
+	Rows    int    `json:"rows"`
+	BgColor string `json:"bg_color"`
+	AltText string `json:"alt_text"`
+	Buttons []struct {
+		Columns  int    `json:"columns"`
+		Rows     int    `json:"rows"`
+		Image    string `json:"image"`
+		Text     string `json:"text"`
+		TextSize string `json:"text_size"`
+		Action   string `json:"action"`
+		Body     string `json:"body"`
+		BgColor  string `json:"bg_color"`
+	} `json:"buttons"`
+}
+
+// GetRichMedia builds rich media and its alt text from the given message metadata, returning nil if it doesn't have any
+func GetRichMedia(metadata json.RawMessage) (*RichMedia, string, error) {
+	raw, _, _, err := jsonparser.Get(metadata, "rich_media")
+	if err != nil {
+		return nil, "", nil
+	}
+
+	m := &richMediaMetadata{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, "", fmt.Errorf("unable to parse rich media: %w", err)
+	}
+
+	rm := &RichMedia{Type: "rich_media", ButtonsGroupColumns: maxColumns, ButtonsGroupRows: maxRichMediaRows, BgColor: m.BgColor}
+	if m.Columns != 0 {
+		rm.ButtonsGroupColumns = m.Columns
+	}
+	if m.Rows != 0 {
+		rm.ButtonsGroupRows = m.Rows
+	}
+	if rm.ButtonsGroupColumns < 1 || rm.ButtonsGroupColumns > maxColumns || rm.ButtonsGroupRows < 1 || rm.ButtonsGroupRows > maxRichMediaRows {
+		return nil, "", fmt.Errorf("rich media must have between 1 and %d columns and between 1 and %d rows", maxColumns, maxRichMediaRows)
+	}
+	if len(m.Buttons) == 0 {
+		return nil, "", fmt.Errorf("rich media must have at least one button")
+	}
+
+	for i, b := range m.Buttons {
+		button := RichMediaButton{
+			Columns:    rm.ButtonsGroupColumns,
+			Rows:       1,
+			ActionType: "reply",
+			ActionBody: b.Body,
+			Image:      b.Image,
+			Text:       html.EscapeString(b.Text),
+			TextSize:   b.TextSize,
+			BgColor:    b.BgColor,
+		}
+		if b.Columns != 0 {
+			button.Columns = b.Columns
+		}
+		if b.Rows != 0 {
+			button.Rows = b.Rows
+		}
+		if b.Action != "" {
+			button.ActionType = b.Action
+		}
+
+		if !richMediaActions[button.ActionType] {
+			return nil, "", fmt.Errorf("button %d has invalid action '%s'", i, button.ActionType)
+		}
+
+		// replies without a body use their text, and if they have no text they're just for display
+		if button.ActionBody == "" {
+			if button.ActionType == "open-url" {
+				return nil, "", fmt.Errorf("button %d has no URL to open", i)
+			} else if button.ActionType == "reply" && b.Text != "" {
+				button.ActionBody = b.Text
+			} else {
+				button.ActionType = "none"
+			}
+		}
+		if button.TextSize != "" && !textSizes[button.TextSize] {
+			return nil, "", fmt.Errorf("button %d has invalid text size '%s'", i, button.TextSize)
+		}
+
+		rm.Buttons = append(rm.Buttons, button)
+	}
+
+	if err := validateRichMediaLayout(rm); err != nil {
+		return nil, "", err
+	}
+
+	return rm, m.AltText, nil
+}
+
+// Viber fills each group's grid with buttons left to right and top to bottom, moving onto a new group when the next
+// button doesn't fit in the first free space of the current one
+func validateRichMediaLayout(rm *RichMedia) error {
+	cols, rows := rm.ButtonsGroupColumns, rm.ButtonsGroupRows
+	groups := 0
+
+	var grid [][]bool
+
+	for i, b := range rm.Buttons {
+		if b.Columns < 1 || b.Columns > cols || b.Rows < 1 || b.Rows > rows {
+			return fmt.Errorf("button %d must be between 1 and %d columns and between 1 and %d rows", i, cols, rows)
+		}
+
+		if grid == nil || !placeButton(grid, b.Columns, b.Rows) {
+			grid = make([][]bool, rows)
+			for r := range grid {
+				grid[r] = make([]bool, cols)
+			}
+			groups++
+
+			placeButton(grid, b.Columns, b.Rows)
+		}
+	}
+
+	if groups > maxRichMediaGroups {
+		return fmt.Errorf("rich media buttons need %d groups but the maximum is %d", groups, maxRichMediaGroups)
+	}
+	return nil
+}
+
+// tries to place a button of the given size at the first free cell of the given grid
+func placeButton(grid [][]bool, cols, rows int) bool {
+	for r := range grid {
+		for c := range grid[r] {
+			if grid[r][c] {
+				continue
+			}
+
+			if r+rows > len(grid) || c+cols > len(grid[r]) {
+				return false
+			}
+			for y := r; y < r+rows; y++ {
+				for x := c; x < c+cols; x++ {
+					if grid[y][x] {
+						return false
+					}
+				}
+			}
+			for y := r; y < r+rows; y++ {
+				for x := c; x < c+cols; x++ {
+					grid[y][x] = true
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package viber_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier/handlers/viber"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRichMedia(t *testing.T) {
+	// no rich media in metadata
+	rm, altText, err := viber.GetRichMedia(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, rm)
+	assert.Equal(t, "", altText)
+
+	rm, _, err = viber.GetRichMedia([]byte(`{"inline_keyboard": true}`))
+	assert.NoError(t, err)
+	assert.Nil(t, rm)
+
+	// defaults to full width buttons in a 6x7 grid
+	rm, altText, err = viber.GetRichMedia([]byte(`{"rich_media": {"alt_text": "Menu", "bg_color": "#FFFFFF", "buttons": [
+		{"rows": 5, "image": "https://example.com/a.jpg", "action": "open-url", "body": "https://example.com/a"},
+		{"text": "<b>A</b>"},
+		{"text_size": "small"}
+	]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "Menu", altText)
+	assert.Equal(t, &viber.RichMedia{
+		Type:                "rich_media",
+		ButtonsGroupColumns: 6,
+		ButtonsGroupRows:    7,
+		BgColor:             "#FFFFFF",
+		Buttons: []viber.RichMediaButton{
+			{Columns: 6, Rows: 5, ActionType: "open-url", ActionBody: "https://example.com/a", Image: "https://example.com/a.jpg"},
+			{Columns: 6, Rows: 1, ActionType: "reply", ActionBody: "<b>A</b>", Text: "&lt;b&gt;A&lt;/b&gt;"},
+			{Columns: 6, Rows: 1, ActionType: "none", TextSize: "small"},
+		},
+	}, rm)
+
+	tcs := []struct {
+		metadata string
+		err      string
+	}{
+		{`{"rich_media": {"buttons": [{"columns": 3}, {"columns": 3}, {"columns": 2, "rows": 2}, {"columns": 4, "rows": 2}]}}`, ""},
+		{`{"rich_media": [1, 2]}`, "unable to parse rich media: json: cannot unmarshal array into Go value of type viber.richMediaMetadata"},
+		{`{"rich_media": {"columns": 7, "buttons": [{"text": "A"}]}}`, "rich media must have between 1 and 6 columns and between 1 and 7 rows"},
+		{`{"rich_media": {"rows": 8, "buttons": [{"text": "A"}]}}`, "rich media must have between 1 and 6 columns and between 1 and 7 rows"},
+		{`{"rich_media": {"buttons": []}}`, "rich media must have at least one button"},
+		{`{"rich_media": {"buttons": [{"text": "A", "action": "share-phone"}]}}`, "button 0 has invalid action 'share-phone'"},
+		{`{"rich_media": {"buttons": [{"text": "A", "action": "open-url"}]}}`, "button 0 has no URL to open"},
+		{`{"rich_media": {"buttons": [{"text": "A", "text_size": "huge"}]}}`, "button 0 has invalid text size 'huge'"},
+		{`{"rich_media": {"columns": 3, "buttons": [{"text": "A"}, {"columns": 4, "text": "B"}]}}`, "button 1 must be between 1 and 3 columns and between 1 and 7 rows"},
+
+		// each of these buttons fills a whole group so 7 need 7 groups
+		{`{"rich_media": {"buttons": [{"rows": 7}, {"rows": 7}, {"rows": 7}, {"rows": 7}, {"rows": 7}, {"rows": 7}, {"rows": 7}]}}`, "rich media buttons need 7 groups but the maximum is 6"},
+
+		// a 4x4 button can't fit after a 3x1 button and moves onto the next group, as do the following 4x4 buttons
+		{`{"rich_media": {"rows": 4, "buttons": [{"columns": 3}, {"columns": 4, "rows": 4}, {"columns": 4, "rows": 4}, {"columns": 4, "rows": 4}, {"columns": 4, "rows": 4}, {"columns": 4, "rows": 4}, {"columns": 4, "rows": 4}]}}`, "rich media buttons need 7 groups but the maximum is 6"},
+	}
+
+	for _, tc := range tcs {
+		_, _, err := viber.GetRichMedia([]byte(tc.metadata))
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s", tc.metadata)
+		} else {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s", tc.metadata)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// VoiceSession describes the voice call during which a contact entered keypresses (DTMF), which voice-capable channel
+// types turn into incoming messages with the entered digits as their text
+type VoiceSession struct {
+	CallID         string `json:"call_id"`
+	ConversationID string `json:"conversation_id,omitempty"` // the provider's ID for the conversation if it has one
+	FinishedOnKey  string `json:"finished_on_key,omitempty"` // the key the contact pressed to finish their input, e.g. #
+	TimedOut       bool   `json:"timed_out,omitempty"`       // whether input finished because the contact stopped pressing keys
+}
+
+// Metadata returns the metadata of an incoming message created from keypresses during this voice session
+func (s *VoiceSession) Metadata() json.RawMessage {
+	return jsonx.MustMarshal(map[string]any{"voice_session": s})
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -100,7 +99,7 @@ type moPayload struct {
 
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
-	date := time.Unix(0, int64(payload.Timestamp*1000000)).UTC()
+	date := handlers.ParseUnixTimestamp(int64(payload.Timestamp), handlers.TimestampMillis)
 
 	// create our URN
 	urn, err := urns.ParsePhone(payload.From, channel.Country(), true, false)
@@ -108,7 +107,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, payload.Message, payload.ID, clog).WithReceivedOn(date.UTC())
+	msg := h.Backend().NewIncomingMsg(channel, urn, payload.Message, payload.ID, clog).WithReceivedOn(date)
 
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
@@ -129,7 +128,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 	payload := mtPayload{}
 	payload.Destination = strings.TrimPrefix(msg.URN().Path(), "+")
-	payload.Message = handlers.GetTextAndAttachments(msg)
+	payload.Message = h.TextAndAttachments(msg)
 
 	jsonPayload := jsonx.MustMarshal(payload)
 
@@ -155,5 +154,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		res.AddExternalID(externalID)
 	}
 
+	handlers.AddSMSSegments(res, payload.Message)
+
 	return nil
 }
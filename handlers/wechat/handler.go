@@ -12,11 +12,9 @@ import (
 	"net/url"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/buger/jsonparser"
-	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/gocommon/urns"
@@ -39,14 +37,13 @@ func init() {
 type handler struct {
 	handlers.BaseHandler
 
-	fetchTokenMutex sync.Mutex
+	tokens *handlers.TokenManager
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{
-		BaseHandler:     handlers.NewBaseHandler(courier.ChannelType("WC"), "WeChat"),
-		fetchTokenMutex: sync.Mutex{},
-	}
+	h := &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("WC"), "WeChat")}
+	h.tokens = handlers.NewTokenManager(h)
+	return h
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -117,7 +114,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("missing parameters, must have either 'MsgId' or 'Event'"))
 	}
 
-	date := time.Unix(payload.CreateTime/1000, payload.CreateTime%1000*1000000).UTC()
+	date := handlers.ParseUnixTimestamp(payload.CreateTime, handlers.TimestampMillis)
 	urn, err := urns.New(urns.WeChat, payload.FromUsername)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
@@ -177,7 +174,7 @@ type mtPayload struct {
 }
 
 func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
-	accessToken, err := h.getAccessToken(msg.Channel(), clog)
+	accessToken, err := h.tokens.Token(ctx, msg.Channel(), clog)
 	if err != nil {
 		return err
 	}
@@ -216,7 +213,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 
 // DescribeURN handles WeChat contact details
 func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn urns.URN, clog *courier.ChannelLog) (map[string]string, error) {
-	accessToken, err := h.getAccessToken(channel, clog)
+	accessToken, err := h.tokens.Token(ctx, channel, clog)
 	if err != nil {
 		return nil, err
 	}
@@ -255,7 +252,7 @@ func (h *handler) RedactValues(ch courier.Channel) []string {
 
 // BuildAttachmentRequest download media for message attachment
 func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend, channel courier.Channel, attachmentURL string, clog *courier.ChannelLog) (*http.Request, error) {
-	accessToken, err := h.getAccessToken(channel, clog)
+	accessToken, err := h.tokens.Token(ctx, channel, clog)
 	if err != nil {
 		return nil, err
 	}
@@ -275,44 +272,8 @@ func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend,
 
 var _ courier.AttachmentRequestBuilder = (*handler)(nil)
 
-func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
-	tokenKey := fmt.Sprintf("channel-token:%s", channel.UUID())
-
-	h.fetchTokenMutex.Lock()
-	defer h.fetchTokenMutex.Unlock()
-
-	var token string
-	var err error
-	h.WithRedisConn(func(rc redis.Conn) {
-		token, err = redis.String(rc.Do("GET", tokenKey))
-	})
-
-	if err != nil && err != redis.ErrNil {
-		return "", fmt.Errorf("error reading cached access token: %w", err)
-	}
-
-	if token != "" {
-		return token, nil
-	}
-
-	token, expires, err := h.fetchAccessToken(channel, clog)
-	if err != nil {
-		return "", fmt.Errorf("error fetching new access token: %w", err)
-	}
-
-	h.WithRedisConn(func(rc redis.Conn) {
-		_, err = rc.Do("SET", tokenKey, token, "EX", int(expires/time.Second))
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("error updating cached access token: %w", err)
-	}
-
-	return token, nil
-}
-
-// fetchAccessToken tries to fetch a new token for our channel, setting the result in redis
-func (h *handler) fetchAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+// FetchAccessToken fetches a new access token for the given channel
+func (h *handler) FetchAccessToken(ctx context.Context, channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
 	form := url.Values{
 		"grant_type": []string{"client_credential"},
 		"appid":      []string{channel.StringConfigForKey(configAppID, "")},
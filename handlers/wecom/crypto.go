@@ -0,0 +1,70 @@
+package wecom
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// WeCom pads plaintext to multiples of 32 bytes rather than the AES block size
+const paddingBlockSize = 32
+
+// callbackSignature calculates the msg_signature of a callback which is the SHA1 of its sorted token, timestamp, nonce
+// and encrypted message
+func callbackSignature(token, timestamp, nonce, encrypted string) string {
+	parts := []string{token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+
+	hash := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(hash[:])
+}
+
+// decryptMessage decrypts a callback message using the base64 encoded AES key configured for the callback, checking
+// that it was intended for the given receiver, i.e. our corp ID
+func decryptMessage(encodingAESKey, encrypted, receiverID string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid encoding AES key")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, errors.New("unable to decode encrypted message")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted message is not a multiple of the block size")
+	}
+
+	block, _ := aes.NewCipher(key)
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext)
+
+	pad := int(plaintext[len(plaintext)-1])
+	if pad < 1 || pad > paddingBlockSize || pad > len(plaintext) {
+		return nil, errors.New("invalid padding in decrypted message")
+	}
+	plaintext = plaintext[:len(plaintext)-pad]
+
+	// plaintext is 16 random bytes, 4 bytes of message length, the message and the receiver ID
+	if len(plaintext) < 20 {
+		return nil, errors.New("decrypted message is too short")
+	}
+	msgLen := int(binary.BigEndian.Uint32(plaintext[16:20]))
+	if 20+msgLen > len(plaintext) {
+		return nil, errors.New("invalid length in decrypted message")
+	}
+
+	msg := plaintext[20 : 20+msgLen]
+	if !bytes.Equal(plaintext[20+msgLen:], []byte(receiverID)) {
+		return nil, errors.New("decrypted message is for a different receiver")
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,517 @@
+package wecom
+
+/*
+WeChat Work (WeCom) apps receive encrypted callbacks for messages sent to them by members of the corp. These are
+received from ext URNs of the member's user ID and replied to using the app agent. If the channel is configured with a
+customer service account (open_kfid) then messages from external WeChat users to that account are also received, from
+ext URNs of their external user ID, which always starts with wm or wo. Callbacks for those only tell us that there are
+new messages which we then have to fetch.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	configCorpID         = "corp_id"
+	configCorpSecret     = "corp_secret"
+	configAgentID        = "agent_id"
+	configEncodingAESKey = "encoding_aes_key"
+	configOpenKfID       = "open_kfid"
+
+	kfEvent      = "kf_msg_or_event"
+	kfOriginUser = 3 // kf messages which were sent by the external user
+	kfMaxSyncs   = 5 // max number of pages of kf messages we'll fetch for a single callback
+	kfCursorTTL  = 60 * 60 * 24 * 7
+	maxMsgLength = 2048
+)
+
+var (
+	apiURL = "https://qyapi.weixin.qq.com/cgi-bin"
+
+	// error codes which mean our access token is no longer valid
+	invalidTokenCodes = map[int64]bool{40001: true, 40014: true, 42001: true}
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	fetchTokenMutex sync.Mutex
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("WCM"), "WeCom", handlers.WithRedactConfigKeys(configCorpSecret, configEncodingAESKey, courier.ConfigSecret))}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodGet, "", courier.ChannelLogTypeWebhookVerify, h.verifyURL)
+	s.AddHandlerRoute(h, http.MethodPost, "", courier.ChannelLogTypeMsgReceive, h.receiveMessage)
+	return nil
+}
+
+type callbackForm struct {
+	MsgSignature string `name:"msg_signature" validate:"required"`
+	Timestamp    string `name:"timestamp"     validate:"required"`
+	Nonce        string `name:"nonce"         validate:"required"`
+	EchoStr      string `name:"echostr"`
+}
+
+// verifyURL is our HTTP handler function for verifying the callback URL, which requires us to decrypt the echo string
+func (h *handler) verifyURL(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &callbackForm{}
+	if err := handlers.DecodeAndValidateForm(form, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	echo, err := h.decryptCallback(channel, form, form.EchoStr)
+	if err != nil {
+		return nil, courier.WriteAndLogUnauthorized(w, r, channel, err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(echo)
+	return nil, err
+}
+
+// envelope is the XML body of callbacks which contains the actual message encrypted
+type envelope struct {
+	ToUserName string `xml:"ToUserName"`
+	AgentID    string `xml:"AgentID"`
+	Encrypt    string `xml:"Encrypt" validate:"required"`
+}
+
+type moPayload struct {
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	PicURL       string `xml:"PicUrl"`
+	MediaID      string `xml:"MediaId"`
+	Event        string `xml:"Event"`
+	Token        string `xml:"Token"`
+	OpenKfID     string `xml:"OpenKfId"`
+}
+
+// receiveMessage is our HTTP handler function for incoming messages and events
+func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, clog *courier.ChannelLog) ([]courier.Event, error) {
+	form := &callbackForm{}
+	if err := handlers.DecodeAndValidateForm(form, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	env := &envelope{}
+	if err := handlers.DecodeAndValidateXML(env, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	decrypted, err := h.decryptCallback(channel, form, env.Encrypt)
+	if err != nil {
+		return nil, courier.WriteAndLogUnauthorized(w, r, channel, err)
+	}
+
+	payload := &moPayload{}
+	if err := xml.Unmarshal(decrypted, payload); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to parse decrypted XML: %w", err))
+	}
+
+	// messages to our customer service account have to be fetched
+	if payload.MsgType == "event" && payload.Event == kfEvent {
+		msgs, err := h.syncKfMsgs(ctx, channel, payload.Token, payload.OpenKfID, clog)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "no new customer messages")
+		}
+		return handlers.WriteMsgsAndResponse(ctx, h, msgs, w, r, clog)
+	}
+
+	if payload.MsgType == "event" {
+		clog.Type = courier.ChannelLogTypeEventReceive
+
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring event: %s", payload.Event))
+	}
+
+	if payload.FromUserName == "" || payload.MsgID == "" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("missing required fields FromUserName or MsgId"))
+	}
+
+	urn, err := urns.New(urns.External, payload.FromUserName)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	date := handlers.ParseUnixTimestamp(payload.CreateTime, handlers.TimestampSeconds)
+	msg := h.Backend().NewIncomingMsg(channel, urn, payload.Content, payload.MsgID, clog).WithReceivedOn(date)
+
+	if payload.PicURL != "" {
+		msg.WithAttachment(payload.PicURL)
+	} else if payload.MediaID != "" {
+		msg.WithAttachment(buildMediaURL(payload.MediaID))
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+// WriteMsgSuccessResponse writes an empty response as anything else is treated as a passive reply
+func (h *handler) WriteMsgSuccessResponse(ctx context.Context, w http.ResponseWriter, msgs []courier.MsgIn) error {
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// decryptCallback checks the signature of a callback and decrypts the given value from it
+func (h *handler) decryptCallback(channel courier.Channel, form *callbackForm, encrypted string) ([]byte, error) {
+	token := channel.StringConfigForKey(courier.ConfigSecret, "")
+	aesKey := channel.StringConfigForKey(configEncodingAESKey, "")
+	corpID := channel.StringConfigForKey(configCorpID, "")
+
+	if callbackSignature(token, form.Timestamp, form.Nonce, encrypted) != form.MsgSignature {
+		return nil, errors.New("invalid msg_signature")
+	}
+
+	return decryptMessage(aesKey, encrypted, corpID)
+}
+
+//	{
+//	  "msgid": "from_msgid_4622416642169452483",
+//	  "open_kfid": "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q",
+//	  "external_userid": "wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw",
+//	  "send_time": 1615478585,
+//	  "origin": 3,
+//	  "msgtype": "text",
+//	  "text": {"content": "hello"}
+//	}
+type kfMsg struct {
+	MsgID          string `json:"msgid"`
+	ExternalUserID string `json:"external_userid"`
+	SendTime       int64  `json:"send_time"`
+	Origin         int    `json:"origin"`
+	MsgType        string `json:"msgtype"`
+	Text           struct {
+		Content string `json:"content"`
+	} `json:"text"`
+	Image struct {
+		MediaID string `json:"media_id"`
+	} `json:"image"`
+	Voice struct {
+		MediaID string `json:"media_id"`
+	} `json:"voice"`
+	Video struct {
+		MediaID string `json:"media_id"`
+	} `json:"video"`
+	File struct {
+		MediaID string `json:"media_id"`
+	} `json:"file"`
+}
+
+type kfSyncResponse struct {
+	ErrCode    int64    `json:"errcode"`
+	ErrMsg     string   `json:"errmsg"`
+	NextCursor string   `json:"next_cursor"`
+	HasMore    int      `json:"has_more"`
+	MsgList    []*kfMsg `json:"msg_list"`
+}
+
+// syncKfMsgs fetches new messages sent to our customer service account since the last time we fetched them
+func (h *handler) syncKfMsgs(ctx context.Context, channel courier.Channel, syncToken, openKfID string, clog *courier.ChannelLog) ([]courier.MsgIn, error) {
+	if openKfID == "" || openKfID != channel.StringConfigForKey(configOpenKfID, "") {
+		return nil, nil
+	}
+
+	cursorKey := fmt.Sprintf("wecom-kf-cursor:%s", channel.UUID())
+
+	var cursor string
+	h.WithRedisConn(func(rc redis.Conn) {
+		cursor, _ = redis.String(rc.Do("GET", cursorKey))
+	})
+
+	msgs := make([]courier.MsgIn, 0, 1)
+
+	for i := 0; i < kfMaxSyncs; i++ {
+		body := map[string]any{"cursor": cursor, "token": syncToken, "open_kfid": openKfID, "limit": 1000}
+
+		respBody, err := h.callAPI(channel, "kf/sync_msg", body, clog)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &kfSyncResponse{}
+		if err := jsonx.Unmarshal(respBody, resp); err != nil {
+			clog.Error(courier.ErrorResponseUnparseable("JSON"))
+			return nil, courier.ErrResponseUnparseable
+		}
+		if resp.ErrCode != 0 {
+			clog.Error(courier.ErrorExternal(strconv.Itoa(int(resp.ErrCode)), resp.ErrMsg))
+			return nil, courier.ErrResponseUnexpected
+		}
+
+		for _, m := range resp.MsgList {
+			if m.Origin != kfOriginUser || m.ExternalUserID == "" {
+				continue
+			}
+
+			urn, err := urns.New(urns.External, m.ExternalUserID)
+			if err != nil {
+				continue
+			}
+
+			date := handlers.ParseUnixTimestamp(m.SendTime, handlers.TimestampSeconds)
+			msg := h.Backend().NewIncomingMsg(channel, urn, m.Text.Content, m.MsgID, clog).WithReceivedOn(date)
+
+			for _, mediaID := range []string{m.Image.MediaID, m.Voice.MediaID, m.Video.MediaID, m.File.MediaID} {
+				if mediaID != "" {
+					msg.WithAttachment(buildMediaURL(mediaID))
+				}
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		if resp.NextCursor != "" {
+			cursor = resp.NextCursor
+			h.WithRedisConn(func(rc redis.Conn) {
+				rc.Do("SET", cursorKey, cursor, "EX", kfCursorTTL)
+			})
+		}
+
+		if resp.HasMore == 0 {
+			break
+		}
+	}
+
+	return msgs, nil
+}
+
+func buildMediaURL(mediaID string) string {
+	mediaURL, _ := url.Parse(fmt.Sprintf("%s/%s", apiURL, "media/get"))
+	mediaURL.RawQuery = url.Values{"media_id": []string{mediaID}}.Encode()
+	return mediaURL.String()
+}
+
+// BuildAttachmentRequest adds our access token to requests to download media
+func (h *handler) BuildAttachmentRequest(ctx context.Context, b courier.Backend, channel courier.Channel, attachmentURL string, clog *courier.ChannelLog) (*http.Request, error) {
+	parsedURL, err := url.Parse(attachmentURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// images are given to us as public URLs
+	if !strings.HasPrefix(attachmentURL, apiURL) {
+		return http.NewRequest(http.MethodGet, attachmentURL, nil)
+	}
+
+	accessToken, err := h.getAccessToken(channel, clog)
+	if err != nil {
+		return nil, err
+	}
+
+	form := parsedURL.Query()
+	form.Set("access_token", accessToken)
+	parsedURL.RawQuery = form.Encode()
+
+	return http.NewRequest(http.MethodGet, parsedURL.String(), nil)
+}
+
+var _ courier.AttachmentRequestBuilder = (*handler)(nil)
+
+// external contacts have IDs like wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw
+func isExternalUser(id string) bool {
+	return strings.HasPrefix(id, "wm") || strings.HasPrefix(id, "wo")
+}
+
+// Send sends the given message, logging any HTTP calls or errors
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	userID := msg.URN().Path()
+
+	var path string
+	base := map[string]any{"msgtype": "text"}
+
+	// external users can only be messaged from our customer service account, members from our app agent
+	if isExternalUser(userID) {
+		openKfID := msg.Channel().StringConfigForKey(configOpenKfID, "")
+		if openKfID == "" {
+			return courier.ErrChannelConfig
+		}
+		path, base["touser"], base["open_kfid"] = "kf/send_msg", userID, openKfID
+	} else {
+		agentID, err := strconv.Atoi(msg.Channel().StringConfigForKey(configAgentID, ""))
+		if err != nil {
+			return courier.ErrChannelConfig
+		}
+		path, base["touser"], base["agentid"] = "message/send", userID, agentID
+	}
+
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+		body := make(map[string]any, len(base)+1)
+		for k, v := range base {
+			body[k] = v
+		}
+		body["text"] = map[string]string{"content": part}
+
+		respBody, err := h.callAPI(msg.Channel(), path, body, clog)
+		if err != nil {
+			return err
+		}
+
+		code, _ := jsonparser.GetInt(respBody, "errcode")
+		if code != 0 {
+			message, _ := jsonparser.GetString(respBody, "errmsg")
+			return courier.ErrFailedWithReason(strconv.Itoa(int(code)), message)
+		}
+
+		msgID, _ := jsonparser.GetString(respBody, "msgid")
+		if msgID == "" {
+			return courier.ErrResponseUnexpected
+		}
+		res.AddExternalID(msgID)
+	}
+
+	return nil
+}
+
+// callAPI makes a POST request to the given API path with our access token, getting a new token and trying again if
+// it has been invalidated
+func (h *handler) callAPI(channel courier.Channel, path string, body any, clog *courier.ChannelLog) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		accessToken, err := h.getAccessToken(channel, clog)
+		if err != nil {
+			return nil, err
+		}
+
+		reqURL := fmt.Sprintf("%s/%s?access_token=%s", apiURL, path, url.QueryEscape(accessToken))
+		req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(jsonx.MustMarshal(body)))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		resp, respBody, err := h.RequestHTTP(req, clog)
+		if err != nil || resp.StatusCode/100 == 5 {
+			return nil, courier.ErrConnectionFailed
+		} else if resp.StatusCode/100 != 2 {
+			return nil, courier.ErrResponseStatus
+		}
+
+		code, _ := jsonparser.GetInt(respBody, "errcode")
+		if invalidTokenCodes[code] && attempt == 0 {
+			if err := h.clearAccessToken(channel); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return respBody, nil
+	}
+}
+
+func accessTokenKey(channel courier.Channel) string {
+	return fmt.Sprintf("channel-token:%s", channel.UUID())
+}
+
+// getAccessToken returns our cached access token or fetches a new one
+func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
+	h.fetchTokenMutex.Lock()
+	defer h.fetchTokenMutex.Unlock()
+
+	var token string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		token, err = redis.String(rc.Do("GET", accessTokenKey(channel)))
+	})
+
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading cached access token: %w", err)
+	}
+
+	if token != "" {
+		return token, nil
+	}
+
+	token, expires, err := h.fetchAccessToken(channel, clog)
+	if err != nil {
+		return "", err
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("SET", accessTokenKey(channel), token, "EX", int(expires/time.Second))
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error updating cached access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// clearAccessToken removes our cached access token so that a new one will be fetched
+func (h *handler) clearAccessToken(channel courier.Channel) error {
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("DEL", accessTokenKey(channel))
+	})
+	if err != nil {
+		return fmt.Errorf("error clearing cached access token: %w", err)
+	}
+	return nil
+}
+
+// fetchAccessToken fetches a new access token using our corp ID and the secret of our app
+func (h *handler) fetchAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, time.Duration, error) {
+	corpID := channel.StringConfigForKey(configCorpID, "")
+	corpSecret := channel.StringConfigForKey(configCorpSecret, "")
+	if corpID == "" || corpSecret == "" {
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	tokenURL, _ := url.Parse(fmt.Sprintf("%s/%s", apiURL, "gettoken"))
+	tokenURL.RawQuery = url.Values{"corpid": []string{corpID}, "corpsecret": []string{corpSecret}}.Encode()
+
+	req, _ := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	req.Header.Set("Accept", "application/json")
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return "", 0, courier.ErrConnectionFailed
+	}
+
+	token, err := jsonparser.GetString(respBody, "access_token")
+	if err != nil || token == "" {
+		code, _ := jsonparser.GetInt(respBody, "errcode")
+		message, _ := jsonparser.GetString(respBody, "errmsg")
+		clog.Error(courier.ErrorExternal(strconv.Itoa(int(code)), message))
+		return "", 0, courier.ErrChannelConfig
+	}
+
+	expiration, err := jsonparser.GetInt(respBody, "expires_in")
+	if err != nil || expiration == 0 {
+		expiration = 7200
+	}
+
+	return token, time.Second * time.Duration(expiration), nil
+}
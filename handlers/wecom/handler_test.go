@@ -0,0 +1,411 @@
+package wecom
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testCorpID = "ww1234567890abcdef"
+	testAESKey = "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXowMTIzNDU"
+	receiveURL = "/c/wcm/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WCM", "1000002", "", []string{urns.External.Prefix}, map[string]any{
+		configCorpID:         testCorpID,
+		configCorpSecret:     "corp-secret",
+		configAgentID:        "1000002",
+		configEncodingAESKey: testAESKey,
+		configOpenKfID:       "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q",
+		courier.ConfigSecret: "sesame",
+	}),
+}
+
+// encrypts a message the way WeCom does, but with predictable random bytes
+func encrypt(msg, receiverID string) string {
+	key, _ := base64.StdEncoding.DecodeString(testAESKey + "=")
+
+	plaintext := []byte("0123456789abcdef")
+	plaintext = binary.BigEndian.AppendUint32(plaintext, uint32(len(msg)))
+	plaintext = append(plaintext, msg...)
+	plaintext = append(plaintext, receiverID...)
+
+	pad := paddingBlockSize - len(plaintext)%paddingBlockSize
+	for i := 0; i < pad; i++ {
+		plaintext = append(plaintext, byte(pad))
+	}
+
+	block, _ := aes.NewCipher(key)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(ciphertext, plaintext)
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+func callbackURL(encrypted string) string {
+	return fmt.Sprintf("%s?msg_signature=%s&timestamp=1409659813&nonce=1372623149", receiveURL, callbackSignature("sesame", "1409659813", "1372623149", encrypted))
+}
+
+func callbackBody(encrypted string) string {
+	return fmt.Sprintf(`<xml><ToUserName><![CDATA[%s]]></ToUserName><AgentID><![CDATA[1000002]]></AgentID><Encrypt><![CDATA[%s]]></Encrypt></xml>`, testCorpID, encrypted)
+}
+
+var (
+	textMsg = encrypt(`<xml>
+		<ToUserName><![CDATA[ww1234567890abcdef]]></ToUserName>
+		<FromUserName><![CDATA[zhangsan]]></FromUserName>
+		<CreateTime>1728988245</CreateTime>
+		<MsgType><![CDATA[text]]></MsgType>
+		<Content><![CDATA[你好]]></Content>
+		<MsgId>1234567890123456</MsgId>
+		<AgentID>1000002</AgentID>
+	</xml>`, testCorpID)
+
+	imageMsg = encrypt(`<xml>
+		<ToUserName><![CDATA[ww1234567890abcdef]]></ToUserName>
+		<FromUserName><![CDATA[zhangsan]]></FromUserName>
+		<CreateTime>1728988245</CreateTime>
+		<MsgType><![CDATA[image]]></MsgType>
+		<PicUrl><![CDATA[https://wework.qpic.cn/image.jpg]]></PicUrl>
+		<MediaId><![CDATA[media_id_1]]></MediaId>
+		<MsgId>1234567890123457</MsgId>
+		<AgentID>1000002</AgentID>
+	</xml>`, testCorpID)
+
+	voiceMsg = encrypt(`<xml>
+		<ToUserName><![CDATA[ww1234567890abcdef]]></ToUserName>
+		<FromUserName><![CDATA[zhangsan]]></FromUserName>
+		<CreateTime>1728988245</CreateTime>
+		<MsgType><![CDATA[voice]]></MsgType>
+		<MediaId><![CDATA[media_id_2]]></MediaId>
+		<Format><![CDATA[amr]]></Format>
+		<MsgId>1234567890123458</MsgId>
+		<AgentID>1000002</AgentID>
+	</xml>`, testCorpID)
+
+	enterEvent = encrypt(`<xml>
+		<ToUserName><![CDATA[ww1234567890abcdef]]></ToUserName>
+		<FromUserName><![CDATA[zhangsan]]></FromUserName>
+		<CreateTime>1728988245</CreateTime>
+		<MsgType><![CDATA[event]]></MsgType>
+		<Event><![CDATA[enter_agent]]></Event>
+		<AgentID>1000002</AgentID>
+	</xml>`, testCorpID)
+
+	missingMsgID = encrypt(`<xml>
+		<FromUserName><![CDATA[zhangsan]]></FromUserName>
+		<MsgType><![CDATA[text]]></MsgType>
+		<Content><![CDATA[你好]]></Content>
+	</xml>`, testCorpID)
+
+	otherCorpMsg = encrypt(`<xml><FromUserName><![CDATA[zhangsan]]></FromUserName></xml>`, "ww0000000000000000")
+
+	kfEventMsg = encrypt(`<xml>
+		<ToUserName><![CDATA[ww1234567890abcdef]]></ToUserName>
+		<CreateTime>1728988245</CreateTime>
+		<MsgType><![CDATA[event]]></MsgType>
+		<Event><![CDATA[kf_msg_or_event]]></Event>
+		<Token><![CDATA[ENCApHxnGDNAVNY4AaSJKj4Tb5mwsEMzxhFmHVGcra996NR]]></Token>
+		<OpenKfId><![CDATA[wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q]]></OpenKfId>
+	</xml>`, testCorpID)
+)
+
+var testCases = []IncomingTestCase{
+	{
+		Label:              "Receive Text Message",
+		URL:                callbackURL(textMsg),
+		Data:               callbackBody(textMsg),
+		ExpectedRespStatus: 200,
+		ExpectedMsgText:    Sp("你好"),
+		ExpectedURN:        "ext:zhangsan",
+		ExpectedExternalID: "1234567890123456",
+		ExpectedDate:       time.Date(2024, 10, 15, 10, 30, 45, 0, time.UTC),
+	},
+	{
+		Label:               "Receive Image Message",
+		URL:                 callbackURL(imageMsg),
+		Data:                callbackBody(imageMsg),
+		ExpectedRespStatus:  200,
+		ExpectedMsgText:     Sp(""),
+		ExpectedURN:         "ext:zhangsan",
+		ExpectedExternalID:  "1234567890123457",
+		ExpectedAttachments: []string{"https://wework.qpic.cn/image.jpg"},
+	},
+	{
+		Label:               "Receive Voice Message",
+		URL:                 callbackURL(voiceMsg),
+		Data:                callbackBody(voiceMsg),
+		ExpectedRespStatus:  200,
+		ExpectedMsgText:     Sp(""),
+		ExpectedURN:         "ext:zhangsan",
+		ExpectedExternalID:  "1234567890123458",
+		ExpectedAttachments: []string{"https://qyapi.weixin.qq.com/cgi-bin/media/get?media_id=media_id_2"},
+	},
+	{
+		Label:                "Receive Enter Agent Event",
+		URL:                  callbackURL(enterEvent),
+		Data:                 callbackBody(enterEvent),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring event: enter_agent",
+	},
+	{
+		Label:                "Missing Msg ID",
+		URL:                  callbackURL(missingMsgID),
+		Data:                 callbackBody(missingMsgID),
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing required fields FromUserName or MsgId",
+	},
+	{
+		Label:                "Different Receiver",
+		URL:                  callbackURL(otherCorpMsg),
+		Data:                 callbackBody(otherCorpMsg),
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "decrypted message is for a different receiver",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Invalid Signature",
+		URL:                  receiveURL + "?msg_signature=123&timestamp=1409659813&nonce=1372623149",
+		Data:                 callbackBody(textMsg),
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid msg_signature",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Missing Signature",
+		URL:                  receiveURL,
+		Data:                 callbackBody(textMsg),
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "Field validation for 'MsgSignature' failed on the 'required' tag",
+	},
+	{
+		Label:                "Verify URL",
+		URL:                  callbackURL(encrypt("1616140317555161061", testCorpID)) + "&echostr=" + url.QueryEscape(encrypt("1616140317555161061", testCorpID)),
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "1616140317555161061",
+	},
+	{
+		Label:                "Verify URL Invalid Signature",
+		URL:                  receiveURL + "?msg_signature=123&timestamp=1409659813&nonce=1372623149&echostr=" + url.QueryEscape(encrypt("1616140317555161061", testCorpID)),
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid msg_signature",
+		NoLogsExpected:       true,
+	},
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
+}
+
+var syncURL = "https://qyapi.weixin.qq.com/cgi-bin/kf/sync_msg?access_token=ACCESS_TOKEN"
+
+func TestIncomingKf(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=ww1234567890abcdef&corpsecret=corp-secret": {
+			httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "access_token": "ACCESS_TOKEN", "expires_in": 7200}`)),
+		},
+		syncURL: {
+			httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "next_cursor": "4gw7MepFLfgF2VC5npN", "has_more": 0, "msg_list": [
+				{"msgid": "from_msgid_1", "open_kfid": "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q", "external_userid": "wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw", "send_time": 1728988245, "origin": 3, "msgtype": "text", "text": {"content": "你好"}},
+				{"msgid": "from_msgid_2", "open_kfid": "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q", "servicer_userid": "zhangsan", "send_time": 1728988246, "origin": 5, "msgtype": "text", "text": {"content": "reply"}}
+			]}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "next_cursor": "", "has_more": 0, "msg_list": []}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	RunIncomingTestCases(t, testChannels, newHandler(), []IncomingTestCase{
+		{
+			Label:              "Receive Kf Message",
+			NoQueueErrorCheck:  true,
+			URL:                callbackURL(kfEventMsg),
+			Data:               callbackBody(kfEventMsg),
+			ExpectedRespStatus: 200,
+			ExpectedMsgText:    Sp("你好"),
+			ExpectedURN:        "ext:wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw",
+			ExpectedExternalID: "from_msgid_1",
+			ExpectedDate:       time.Date(2024, 10, 15, 10, 30, 45, 0, time.UTC),
+		},
+		{
+			Label:                "Receive Kf Event With No New Messages",
+			URL:                  callbackURL(kfEventMsg),
+			Data:                 callbackBody(kfEventMsg),
+			ExpectedRespStatus:   200,
+			ExpectedBodyContains: "no new customer messages",
+		},
+	})
+
+	assert.False(t, mocks.HasUnused())
+	assert.JSONEq(t, `{"cursor": "", "token": "ENCApHxnGDNAVNY4AaSJKj4Tb5mwsEMzxhFmHVGcra996NR", "open_kfid": "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q", "limit": 1000}`, readBody(mocks.Requests()[1].Body))
+	assert.JSONEq(t, `{"cursor": "4gw7MepFLfgF2VC5npN", "token": "ENCApHxnGDNAVNY4AaSJKj4Tb5mwsEMzxhFmHVGcra996NR", "open_kfid": "wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q", "limit": 1000}`, readBody(mocks.Requests()[2].Body))
+}
+
+func readBody(r io.Reader) string {
+	b, _ := io.ReadAll(r)
+	return string(b)
+}
+
+func TestDecryptMessage(t *testing.T) {
+	// encrypted using openssl
+	encrypted := "n+2yCHTHPE9sik87zYQO2zJ6C5I/zW3J6emI+8vVQj72fBuyD/hqPi+CdS/9YfQ6yniAhyPFIyWj2wtkjIKtpKsp9eygIurQFRerxuCKWTpVM/Ou2silab0uIQgacaOn"
+
+	msg, err := decryptMessage(testAESKey, encrypted, testCorpID)
+	assert.NoError(t, err)
+	assert.Equal(t, "<xml><FromUserName>zhangsan</FromUserName></xml>", string(msg))
+
+	_, err = decryptMessage(testAESKey, encrypted, "ww0000000000000000")
+	assert.EqualError(t, err, "decrypted message is for a different receiver")
+
+	_, err = decryptMessage("tooshort", encrypted, testCorpID)
+	assert.EqualError(t, err, "invalid encoding AES key")
+
+	_, err = decryptMessage(testAESKey, "not base64!", testCorpID)
+	assert.EqualError(t, err, "unable to decode encrypted message")
+
+	_, err = decryptMessage(testAESKey, "YWJj", testCorpID)
+	assert.EqualError(t, err, "encrypted message is not a multiple of the block size")
+}
+
+var defaultSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "Send To Member",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:zhangsan",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=ACCESS_TOKEN": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "invaliduser": "", "msgid": "xx1234"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"agentid":1000002,"msgtype":"text","text":{"content":"Simple Message"},"touser":"zhangsan"}`},
+		},
+		ExpectedExtIDs: []string{"xx1234"},
+	},
+	{
+		Label:          "Send To External Contact With Attachment",
+		MsgText:        "Simple Message",
+		MsgURN:         "ext:wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://qyapi.weixin.qq.com/cgi-bin/kf/send_msg?access_token=ACCESS_TOKEN": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "msgid": "MSG_ID"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"msgtype":"text","open_kfid":"wkAJ2GCAAASSm4_FhToWMFea0xAFfd3Q","text":{"content":"Simple Message\nhttps://foo.bar/image.jpg"},"touser":"wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw"}`},
+		},
+		ExpectedExtIDs: []string{"MSG_ID"},
+	},
+	{
+		Label:   "Error Response",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:zhangsan",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=ACCESS_TOKEN": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 81013, "errmsg": "user & party & tag all invalid"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrFailedWithReason("81013", "user & party & tag all invalid"),
+	},
+	{
+		Label:   "Connection Error",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:zhangsan",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=ACCESS_TOKEN": {
+				httpx.NewMockResponse(503, nil, []byte(`Service Unavailable`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+	{
+		Label:   "Expired Access Token",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:zhangsan",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=ACCESS_TOKEN": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 42001, "errmsg": "access_token expired"}`)),
+			},
+			"https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=ww1234567890abcdef&corpsecret=corp-secret": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "access_token": "NEW_ACCESS_TOKEN", "expires_in": 7200}`)),
+			},
+			"https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=NEW_ACCESS_TOKEN": {
+				httpx.NewMockResponse(200, nil, []byte(`{"errcode": 0, "errmsg": "ok", "msgid": "xx1234"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{}, {}, {}},
+		ExpectedExtIDs:   []string{"xx1234"},
+	},
+}
+
+func setupBackend(mb *test.MockBackend) {
+	// ensure there's a cached access token
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("SET", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "ACCESS_TOKEN")
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, []string{"corp-secret", testAESKey, "sesame"}, setupBackend)
+}
+
+func TestOutgoingNoKfAccount(t *testing.T) {
+	ch := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WCM", "1000002", "", []string{urns.External.Prefix}, map[string]any{
+		configCorpID:     testCorpID,
+		configCorpSecret: "corp-secret",
+	})
+
+	RunOutgoingTestCases(t, ch, newHandler(), []OutgoingTestCase{
+		{
+			Label:         "External Contact",
+			MsgText:       "Simple Message",
+			MsgURN:        "ext:wmAJ2GCAAAme1XQRC-NI-q0_ZM9ukoAw",
+			ExpectedError: courier.ErrChannelConfig,
+		},
+		{
+			Label:         "Member Without Agent",
+			MsgText:       "Simple Message",
+			MsgURN:        "ext:zhangsan",
+			ExpectedError: courier.ErrChannelConfig,
+		},
+	}, nil, nil)
+}
+
+func TestFetchAccessToken(t *testing.T) {
+	h := newHandler().(*handler)
+	h.Initialize(courier.NewServer(courier.NewDefaultConfig(), test.NewMockBackend()))
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=ww1234567890abcdef&corpsecret=corp-secret": {
+			httpx.NewMockResponse(200, nil, []byte(`{"errcode": 40013, "errmsg": "invalid corpid"}`)),
+		},
+	}))
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, testChannels[0], nil)
+	_, _, err := h.fetchAccessToken(testChannels[0], clog)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorExternal("40013", "invalid corpid")}, clog.Errors)
+}
@@ -19,7 +19,9 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/queue"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/jsonx"
@@ -63,7 +65,8 @@ type handler struct {
 }
 
 func newWAHandler(channelType courier.ChannelType, name string) courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(channelType, name)}
+	// attachments are uploaded to the API before they can be sent which can be slow for larger media
+	return &handler{handlers.NewBaseHandler(channelType, name, handlers.WithRequestTimeout(time.Minute))}
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -199,7 +202,7 @@ func (h *handler) receiveEvents(ctx context.Context, channel courier.Channel, w
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid timestamp: %s", msg.Timestamp))
 		}
-		date := time.Unix(ts, 0).UTC()
+		date := handlers.ParseUnixTimestamp(ts, handlers.TimestampSeconds)
 
 		// create our URN
 		urn, err := urns.New(urns.WhatsApp, msg.From)
@@ -623,19 +626,11 @@ func buildPayloads(msg courier.MsgOut, h *handler, clog *courier.ChannelLog) ([]
 		if !textAsCaption && !isInteractiveMsg {
 			for _, part := range parts {
 
-				//check if you have a link
-				var payload mtTextPayload
-				if strings.Contains(part, "https://") || strings.Contains(part, "http://") {
-					payload = mtTextPayload{
-						To:         msg.URN().Path(),
-						Type:       "text",
-						PreviewURL: true,
-					}
-				} else {
-					payload = mtTextPayload{
-						To:   msg.URN().Path(),
-						Type: "text",
-					}
+				// preview any links unless message says otherwise
+				payload := mtTextPayload{
+					To:         msg.URN().Path(),
+					Type:       "text",
+					PreviewURL: handlers.PreviewURLs(msg, part),
 				}
 				payload.Text.Body = part
 				payloads = append(payloads, payload)
@@ -790,19 +785,11 @@ func buildPayloads(msg courier.MsgOut, h *handler, clog *courier.ChannelLog) ([]
 			} else {
 				for _, part := range parts {
 
-					//check if you have a link
-					var payload mtTextPayload
-					if strings.Contains(part, "https://") || strings.Contains(part, "http://") {
-						payload = mtTextPayload{
-							To:         msg.URN().Path(),
-							Type:       "text",
-							PreviewURL: true,
-						}
-					} else {
-						payload = mtTextPayload{
-							To:   msg.URN().Path(),
-							Type: "text",
-						}
+					// preview any links unless message says otherwise
+					payload := mtTextPayload{
+						To:         msg.URN().Path(),
+						Type:       "text",
+						PreviewURL: handlers.PreviewURLs(msg, part),
 					}
 					payload.Text.Body = part
 					payloads = append(payloads, payload)
@@ -817,7 +804,7 @@ func buildPayloads(msg courier.MsgOut, h *handler, clog *courier.ChannelLog) ([]
 func (h *handler) fetchMediaID(msg courier.MsgOut, mediaURL string, clog *courier.ChannelLog) (string, error) {
 	// check in cache first
 	cacheKey := fmt.Sprintf(mediaCacheKeyPattern, msg.Channel().UUID())
-	mediaCache := redisx.NewIntervalHash(cacheKey, time.Hour*24, 2)
+	mediaCache := redisx.NewIntervalHash(valkey.Tag(cacheKey), time.Hour*24, 2)
 
 	var mediaID string
 	var err error
@@ -908,7 +895,7 @@ func (h *handler) sendWhatsAppMsg(msg courier.MsgOut, sendPath *url.URL, payload
 	}
 
 	if resp != nil && (resp.StatusCode == 429 || resp.StatusCode == 503) {
-		rateLimitKey := fmt.Sprintf("rate_limit:%s", msg.Channel().UUID())
+		rateLimitKey := queue.RateLimitKey("msgs", string(msg.Channel().UUID()))
 
 		h.WithRedisConn(func(rc redis.Conn) {
 			rc.Do("SET", rateLimitKey, "engaged")
@@ -928,7 +915,7 @@ func (h *handler) sendWhatsAppMsg(msg courier.MsgOut, sendPath *url.URL, payload
 	// handle send msg errors
 	if err == nil && len(errPayload.Errors) > 0 {
 		if hasTiersError(*errPayload) {
-			rateLimitBulkKey := fmt.Sprintf("rate_limit_bulk:%s", msg.Channel().UUID())
+			rateLimitBulkKey := queue.BulkRateLimitKey("msgs", string(msg.Channel().UUID()))
 
 			h.WithRedisConn(func(rc redis.Conn) {
 				rc.Do("SET", rateLimitBulkKey, "engaged")
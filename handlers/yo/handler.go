@@ -26,6 +26,8 @@ var (
 	maxMsgLength = 1600
 )
 
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{time.RFC3339Nano}}
+
 func init() {
 	courier.RegisterHandler(newHandler())
 }
@@ -77,7 +79,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	date := time.Now()
 	if dateString != "" {
-		date, err = time.Parse(time.RFC3339Nano, dateString)
+		date, err = timestampFormat.Parse(dateString)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid date format, must be RFC 3339"))
 		}
@@ -104,7 +106,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrChannelConfig
 	}
 
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength) {
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), h.TextAndAttachments(msg), maxMsgLength) {
 		form := url.Values{
 			"origin":       []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
 			"sms_content":  []string{part},
@@ -141,6 +143,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			// finally check that we were sent
 			createStatus := responseQS["ybs_autocreate_status"]
 			if len(createStatus) > 0 && createStatus[0] == "OK" {
+				handlers.AddSMSSegments(res, part)
 				return nil
 			} else {
 				return courier.ErrResponseContent
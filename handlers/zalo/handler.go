@@ -0,0 +1,378 @@
+package zalo
+
+/*
+Zalo Official Accounts send us events for messages from users which we receive as messages from ext URNs of the user's
+ID. Replies are sent via the OA customer service message API which requires an access token obtained using a refresh
+token. Refresh tokens can only be used once, so the new refresh token we get back is stored in redis and used instead
+of the one in the channel config from then on.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	configAppID        = "app_id"
+	configAppSecret    = "app_secret"
+	configRefreshToken = "refresh_token"
+
+	signatureHeader = "X-ZEvent-Signature"
+
+	errorInvalidToken  = -216
+	refreshTokenExpiry = time.Hour * 24 * 90
+)
+
+var (
+	sendURL      = "https://openapi.zalo.me/v3.0/oa/message/cs"
+	tokenURL     = "https://oauth.zaloapp.com/v4/oa/access_token"
+	maxMsgLength = 2000
+
+	// events for messages from users, which can have attachments
+	messageEvents = map[string]bool{
+		"user_send_text":    true,
+		"user_send_image":   true,
+		"user_send_sticker": true,
+		"user_send_gif":     true,
+		"user_send_audio":   true,
+		"user_send_video":   true,
+		"user_send_file":    true,
+	}
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	fetchTokenMutex sync.Mutex
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{BaseHandler: handlers.NewBaseHandler(courier.ChannelType("ZLO"), "Zalo", handlers.WithRedactConfigKeys(configAppSecret, configRefreshToken, courier.ConfigSecret))}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", courier.ChannelLogTypeMsgReceive, handlers.JSONPayload(h, h.receiveEvent))
+	return nil
+}
+
+//	{
+//	  "app_id": "360846524940903967",
+//	  "user_id_by_app": "552177279717587730",
+//	  "event_name": "user_send_image",
+//	  "timestamp": "1728988245000",
+//	  "sender": {"id": "246845883529197922"},
+//	  "recipient": {"id": "388613280878808645"},
+//	  "message": {
+//	    "msg_id": "96d3cdf3af150460909",
+//	    "text": "Look at this",
+//	    "attachments": [{"type": "image", "payload": {"thumbnail": "https://...", "url": "https://..."}}]
+//	  }
+//	}
+type moPayload struct {
+	AppID     string `json:"app_id"`
+	EventName string `json:"event_name" validate:"required"`
+	Timestamp string `json:"timestamp"`
+	Sender    struct {
+		ID string `json:"id"`
+	} `json:"sender"`
+	Message *struct {
+		MsgID       string `json:"msg_id"`
+		Text        string `json:"text"`
+		Attachments []struct {
+			Type    string `json:"type"`
+			Payload struct {
+				URL string `json:"url"`
+			} `json:"payload"`
+		} `json:"attachments"`
+	} `json:"message"`
+}
+
+// receiveEvent is our HTTP handler function for events from Zalo
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *moPayload, clog *courier.ChannelLog) ([]courier.Event, error) {
+	if secret := channel.StringConfigForKey(courier.ConfigSecret, ""); secret != "" {
+		body, err := handlers.ReadBody(r, 100000)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		}
+
+		if !validSignature(secret, payload.AppID, body, payload.Timestamp, r.Header.Get(signatureHeader)) {
+			return nil, courier.WriteAndLogUnauthorized(w, r, channel, errors.New("invalid request signature"))
+		}
+	}
+
+	if !messageEvents[payload.EventName] || payload.Message == nil {
+		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, fmt.Sprintf("ignoring event: %s", payload.EventName))
+	}
+
+	if payload.Sender.ID == "" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("missing sender id"))
+	}
+
+	urn, err := urns.New(urns.External, payload.Sender.ID)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	date := time.Now().UTC()
+	if ts, err := strconv.ParseInt(payload.Timestamp, 10, 64); err == nil {
+		date = handlers.ParseUnixTimestamp(ts, handlers.TimestampMillis)
+	}
+
+	msg := h.Backend().NewIncomingMsg(channel, urn, payload.Message.Text, payload.Message.MsgID, clog).WithReceivedOn(date)
+	for _, a := range payload.Message.Attachments {
+		if a.Payload.URL != "" {
+			msg.WithAttachment(a.Payload.URL)
+		}
+	}
+
+	return handlers.WriteMsgsAndResponse(ctx, h, []courier.MsgIn{msg}, w, r, clog)
+}
+
+// validSignature checks a signature which is the SHA256 of the app ID, body, timestamp and OA secret key
+func validSignature(secret, appID string, body []byte, timestamp, signature string) bool {
+	hash := sha256.Sum256([]byte(appID + string(body) + timestamp + secret))
+	expected := "mac=" + hex.EncodeToString(hash[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+type mtElement struct {
+	MediaType string `json:"media_type"`
+	URL       string `json:"url"`
+}
+
+type mtAttachment struct {
+	Type    string `json:"type"`
+	Payload struct {
+		TemplateType string       `json:"template_type"`
+		Elements     []*mtElement `json:"elements"`
+	} `json:"payload"`
+}
+
+type mtPayload struct {
+	Recipient struct {
+		UserID string `json:"user_id"`
+	} `json:"recipient"`
+	Message struct {
+		Text       string        `json:"text,omitempty"`
+		Attachment *mtAttachment `json:"attachment,omitempty"`
+	} `json:"message"`
+}
+
+// Send sends the given message, logging any HTTP calls or errors
+func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
+	payloads := make([]*mtPayload, 0, 2)
+
+	// images can be sent by URL, other attachments are sent as links
+	text := msg.Text()
+	for _, a := range msg.Attachments() {
+		mediaType, mediaURL := handlers.SplitAttachment(a)
+		if strings.HasPrefix(mediaType, "image/") {
+			attachment := &mtAttachment{Type: "template"}
+			attachment.Payload.TemplateType = "media"
+			attachment.Payload.Elements = []*mtElement{{MediaType: "image", URL: mediaURL}}
+
+			payload := &mtPayload{}
+			payload.Message.Attachment = attachment
+			payloads = append(payloads, payload)
+		} else {
+			text += "\n" + mediaURL
+		}
+	}
+
+	text = strings.TrimSpace(text)
+	if text != "" {
+		for _, part := range handlers.SplitMsgByChannel(msg.Channel(), text, maxMsgLength) {
+			payload := &mtPayload{}
+			payload.Message.Text = part
+			payloads = append(payloads, payload)
+		}
+	}
+
+	for _, payload := range payloads {
+		payload.Recipient.UserID = msg.URN().Path()
+
+		if err := h.sendPayload(msg.Channel(), payload, res, clog); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *handler) sendPayload(channel courier.Channel, payload *mtPayload, res *courier.SendResult, clog *courier.ChannelLog) error {
+	// if our access token has been invalidated we get a new one and try again
+	for attempt := 0; ; attempt++ {
+		accessToken, err := h.getAccessToken(channel, clog)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(jsonx.MustMarshal(payload)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("access_token", accessToken)
+
+		resp, respBody, err := h.RequestHTTP(req, clog)
+		if err != nil || resp.StatusCode/100 == 5 {
+			return courier.ErrConnectionFailed
+		} else if resp.StatusCode/100 != 2 {
+			return courier.ErrResponseStatus
+		}
+
+		code, _ := jsonparser.GetInt(respBody, "error")
+		if code == errorInvalidToken && attempt == 0 {
+			if err := h.clearAccessToken(channel); err != nil {
+				return err
+			}
+			continue
+		} else if code != 0 {
+			message, _ := jsonparser.GetString(respBody, "message")
+			return courier.ErrFailedWithReason(strconv.Itoa(int(code)), message)
+		}
+
+		messageID, _ := jsonparser.GetString(respBody, "data", "message_id")
+		if messageID == "" {
+			return courier.ErrResponseUnexpected
+		}
+
+		res.AddExternalID(messageID)
+		return nil
+	}
+}
+
+func accessTokenKey(channel courier.Channel) string {
+	return fmt.Sprintf("channel-token:%s", channel.UUID())
+}
+
+func refreshTokenKey(channel courier.Channel) string {
+	return fmt.Sprintf("channel-refresh-token:%s", channel.UUID())
+}
+
+// getAccessToken returns our cached access token or gets a new one using our refresh token
+func (h *handler) getAccessToken(channel courier.Channel, clog *courier.ChannelLog) (string, error) {
+	h.fetchTokenMutex.Lock()
+	defer h.fetchTokenMutex.Unlock()
+
+	var token, refreshToken string
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		token, err = redis.String(rc.Do("GET", accessTokenKey(channel)))
+		if err == redis.ErrNil {
+			refreshToken, err = redis.String(rc.Do("GET", refreshTokenKey(channel)))
+		}
+	})
+
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading cached access token: %w", err)
+	}
+
+	if token != "" {
+		return token, nil
+	}
+
+	// if we haven't yet refreshed then we use the refresh token we were configured with
+	if refreshToken == "" {
+		refreshToken = channel.StringConfigForKey(configRefreshToken, "")
+	}
+
+	token, refreshToken, expires, err := h.refreshAccessToken(channel, refreshToken, clog)
+	if err != nil {
+		return "", err
+	}
+
+	h.WithRedisConn(func(rc redis.Conn) {
+		rc.Send("MULTI")
+		rc.Send("SET", accessTokenKey(channel), token, "EX", int(expires/time.Second))
+		rc.Send("SET", refreshTokenKey(channel), refreshToken, "EX", int(refreshTokenExpiry/time.Second))
+		_, err = rc.Do("EXEC")
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error updating cached access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// clearAccessToken removes our cached access token so that a new one will be fetched
+func (h *handler) clearAccessToken(channel courier.Channel) error {
+	var err error
+	h.WithRedisConn(func(rc redis.Conn) {
+		_, err = rc.Do("DEL", accessTokenKey(channel))
+	})
+	if err != nil {
+		return fmt.Errorf("error clearing cached access token: %w", err)
+	}
+	return nil
+}
+
+// refreshAccessToken uses the given refresh token to get a new access token and refresh token
+func (h *handler) refreshAccessToken(channel courier.Channel, refreshToken string, clog *courier.ChannelLog) (string, string, time.Duration, error) {
+	appID := channel.StringConfigForKey(configAppID, "")
+	appSecret := channel.StringConfigForKey(configAppSecret, "")
+	if appID == "" || appSecret == "" || refreshToken == "" {
+		return "", "", 0, courier.ErrChannelConfig
+	}
+
+	form := url.Values{
+		"app_id":        []string{appID},
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{refreshToken},
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("secret_key", appSecret)
+
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	if err != nil || resp.StatusCode/100 != 2 {
+		return "", "", 0, courier.ErrConnectionFailed
+	}
+
+	token, _ := jsonparser.GetString(respBody, "access_token")
+	newRefreshToken, _ := jsonparser.GetString(respBody, "refresh_token")
+	if token == "" || newRefreshToken == "" {
+		code, _ := jsonparser.GetInt(respBody, "error")
+		name, _ := jsonparser.GetString(respBody, "error_name")
+		clog.Error(courier.ErrorExternal(strconv.Itoa(int(code)), name))
+		return "", "", 0, courier.ErrChannelConfig
+	}
+
+	// expiry is given in seconds as a string
+	expiresIn, _ := jsonparser.GetUnsafeString(respBody, "expires_in")
+	expiration, _ := strconv.Atoi(expiresIn)
+	if expiration <= 0 {
+		expiration = 3600
+	}
+
+	return token, newRefreshToken, time.Second * time.Duration(expiration), nil
+}
@@ -0,0 +1,336 @@
+package zalo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "ZLO", "388613280878808645", "VN", []string{urns.External.Prefix}, map[string]any{
+		configAppID:          "360846524940903967",
+		configAppSecret:      "app-secret",
+		configRefreshToken:   "REFRESH_TOKEN",
+		courier.ConfigSecret: "sesame",
+	}),
+}
+
+const receiveURL = "/c/zlo/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"
+
+var textMsg = `{
+	"app_id": "360846524940903967",
+	"user_id_by_app": "552177279717587730",
+	"event_name": "user_send_text",
+	"timestamp": "1728988245000",
+	"sender": {"id": "246845883529197922"},
+	"recipient": {"id": "388613280878808645"},
+	"message": {"msg_id": "96d3cdf3af150460909", "text": "Xin chào"}
+}`
+
+var imageMsg = `{
+	"app_id": "360846524940903967",
+	"event_name": "user_send_image",
+	"timestamp": "1728988245000",
+	"sender": {"id": "246845883529197922"},
+	"recipient": {"id": "388613280878808645"},
+	"message": {
+		"msg_id": "96d3cdf3af150460910",
+		"text": "Look",
+		"attachments": [{"type": "image", "payload": {"thumbnail": "https://zalo.me/thumb.jpg", "url": "https://zalo.me/image.jpg"}}]
+	}
+}`
+
+var stickerMsg = `{
+	"app_id": "360846524940903967",
+	"event_name": "user_send_sticker",
+	"timestamp": "1728988245000",
+	"sender": {"id": "246845883529197922"},
+	"recipient": {"id": "388613280878808645"},
+	"message": {
+		"msg_id": "96d3cdf3af150460911",
+		"attachments": [{"type": "sticker", "payload": {"id": "bfe458bf64fa8da4d4eb", "url": "https://zalo.me/sticker.png"}}]
+	}
+}`
+
+var followEvent = `{
+	"app_id": "360846524940903967",
+	"event_name": "follow",
+	"timestamp": "1728988245000",
+	"follower": {"id": "246845883529197922"}
+}`
+
+var missingSender = `{
+	"app_id": "360846524940903967",
+	"event_name": "user_send_text",
+	"timestamp": "1728988245000",
+	"message": {"msg_id": "96d3cdf3af150460909", "text": "Xin chào"}
+}`
+
+var testCases = []IncomingTestCase{
+	{
+		Label:                "Receive Text Message",
+		URL:                  receiveURL,
+		Data:                 textMsg,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Message Accepted",
+		ExpectedMsgText:      Sp("Xin chào"),
+		ExpectedURN:          "ext:246845883529197922",
+		ExpectedExternalID:   "96d3cdf3af150460909",
+		ExpectedDate:         time.Date(2024, 10, 15, 10, 30, 45, 0, time.UTC),
+	},
+	{
+		Label:                "Receive Image Message",
+		URL:                  receiveURL,
+		Data:                 imageMsg,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Message Accepted",
+		ExpectedMsgText:      Sp("Look"),
+		ExpectedURN:          "ext:246845883529197922",
+		ExpectedExternalID:   "96d3cdf3af150460910",
+		ExpectedAttachments:  []string{"https://zalo.me/image.jpg"},
+	},
+	{
+		Label:                "Receive Sticker Message",
+		URL:                  receiveURL,
+		Data:                 stickerMsg,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "Message Accepted",
+		ExpectedMsgText:      Sp(""),
+		ExpectedURN:          "ext:246845883529197922",
+		ExpectedExternalID:   "96d3cdf3af150460911",
+		ExpectedAttachments:  []string{"https://zalo.me/sticker.png"},
+	},
+	{
+		Label:                "Receive Follow Event",
+		URL:                  receiveURL,
+		Data:                 followEvent,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   200,
+		ExpectedBodyContains: "ignoring event: follow",
+	},
+	{
+		Label:                "Missing Sender",
+		URL:                  receiveURL,
+		Data:                 missingSender,
+		PrepRequest:          addValidSignature,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "missing sender id",
+	},
+	{
+		Label:                "Invalid Signature",
+		URL:                  receiveURL,
+		Data:                 textMsg,
+		Headers:              map[string]string{signatureHeader: "mac=bad"},
+		ExpectedRespStatus:   401,
+		ExpectedBodyContains: "invalid request signature",
+		NoLogsExpected:       true,
+	},
+	{
+		Label:                "Missing Event Name",
+		URL:                  receiveURL,
+		Data:                 `{"app_id": "360846524940903967"}`,
+		ExpectedRespStatus:   400,
+		ExpectedBodyContains: "Field validation for 'EventName' failed on the 'required' tag",
+	},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	appID, _ := jsonparser.GetString(body, "app_id")
+	timestamp, _ := jsonparser.GetString(body, "timestamp")
+	hash := sha256.Sum256([]byte(appID + string(body) + timestamp + "sesame"))
+	r.Header.Set(signatureHeader, "mac="+hex.EncodeToString(hash[:]))
+}
+
+func TestIncoming(t *testing.T) {
+	RunIncomingTestCases(t, testChannels, newHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"event_name":"user_send_text"}`)
+
+	assert.True(t, validSignature("sesame", "123", body, "1728988245000", "mac=b2983c356f263c079ab6f7ee90e11f950f9fd72c85691bc374895bcf0e7e4f6e"))
+	assert.False(t, validSignature("sesame", "123", body, "1728988245001", "mac=b2983c356f263c079ab6f7ee90e11f950f9fd72c85691bc374895bcf0e7e4f6e"))
+	assert.False(t, validSignature("sesame", "123", body, "1728988245000", ""))
+}
+
+var defaultSendTestCases = []OutgoingTestCase{
+	{
+		Label:   "Plain Send",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:246845883529197922",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(200, nil, []byte(`{"error": 0, "message": "Success", "data": {"message_id": "a1b2c3", "user_id": "246845883529197922"}}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{
+				Headers: map[string]string{"access_token": "ACCESS_TOKEN", "Content-Type": "application/json"},
+				Body:    `{"recipient":{"user_id":"246845883529197922"},"message":{"text":"Simple Message"}}`,
+			},
+		},
+		ExpectedExtIDs: []string{"a1b2c3"},
+	},
+	{
+		Label:          "Send Image And Document",
+		MsgText:        "Look",
+		MsgURN:         "ext:246845883529197922",
+		MsgAttachments: []string{"image/jpeg:https://foo.bar/image.jpg", "application/pdf:https://foo.bar/doc.pdf"},
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {
+				httpx.NewMockResponse(200, nil, []byte(`{"error": 0, "message": "Success", "data": {"message_id": "a1b2c3"}}`)),
+				httpx.NewMockResponse(200, nil, []byte(`{"error": 0, "message": "Success", "data": {"message_id": "d4e5f6"}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Body: `{"recipient":{"user_id":"246845883529197922"},"message":{"attachment":{"type":"template","payload":{"template_type":"media","elements":[{"media_type":"image","url":"https://foo.bar/image.jpg"}]}}}}`},
+			{Body: `{"recipient":{"user_id":"246845883529197922"},"message":{"text":"Look\nhttps://foo.bar/doc.pdf"}}`},
+		},
+		ExpectedExtIDs: []string{"a1b2c3", "d4e5f6"},
+	},
+	{
+		Label:   "Error Response",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:246845883529197922",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(200, nil, []byte(`{"error": -230, "message": "User has not interacted with the OA in the past 7 days"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrFailedWithReason("-230", "User has not interacted with the OA in the past 7 days"),
+	},
+	{
+		Label:   "Unexpected Response",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:246845883529197922",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(200, nil, []byte(`{"error": 0}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrResponseUnexpected,
+	},
+	{
+		Label:   "Connection Error",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:246845883529197922",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {httpx.NewMockResponse(503, nil, []byte(`Service Unavailable`))},
+		},
+		ExpectedRequests: []ExpectedRequest{{}},
+		ExpectedError:    courier.ErrConnectionFailed,
+	},
+	{
+		Label:   "Invalid Access Token",
+		MsgText: "Simple Message",
+		MsgURN:  "ext:246845883529197922",
+		MockResponses: map[string][]*httpx.MockResponse{
+			sendURL: {
+				httpx.NewMockResponse(200, nil, []byte(`{"error": -216, "message": "Access token is invalid"}`)),
+				httpx.NewMockResponse(200, nil, []byte(`{"error": 0, "message": "Success", "data": {"message_id": "a1b2c3"}}`)),
+			},
+			tokenURL: {httpx.NewMockResponse(200, nil, []byte(`{"access_token": "NEW_ACCESS_TOKEN", "refresh_token": "NEW_REFRESH_TOKEN", "expires_in": "90000"}`))},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Headers: map[string]string{"access_token": "ACCESS_TOKEN"}},
+			{
+				Headers: map[string]string{"secret_key": "app-secret"},
+				Form:    url.Values{"app_id": {"360846524940903967"}, "grant_type": {"refresh_token"}, "refresh_token": {"REFRESH_TOKEN"}},
+			},
+			{Headers: map[string]string{"access_token": "NEW_ACCESS_TOKEN"}},
+		},
+		ExpectedExtIDs: []string{"a1b2c3"},
+	},
+}
+
+func setupBackend(mb *test.MockBackend) {
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	// ensure there's a cached access token and no refresh token from previous tests
+	rc.Do("SET", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "ACCESS_TOKEN")
+	rc.Do("DEL", "channel-refresh-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab")
+}
+
+func TestOutgoing(t *testing.T) {
+	RunOutgoingTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, []string{"app-secret", "REFRESH_TOKEN", "sesame"}, setupBackend)
+}
+
+func TestRefreshAccessToken(t *testing.T) {
+	mb := test.NewMockBackend()
+	h := newHandler().(*handler)
+	h.SetServer(courier.NewServer(courier.NewDefaultConfig(), mb))
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "channel-refresh-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab")
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		tokenURL: {
+			httpx.NewMockResponse(200, nil, []byte(`{"access_token": "ACCESS_TOKEN_1", "refresh_token": "REFRESH_TOKEN_1", "expires_in": "90000"}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"access_token": "ACCESS_TOKEN_2", "refresh_token": "REFRESH_TOKEN_2", "expires_in": "90000"}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"error": -14014, "error_name": "Invalid refresh token"}`)),
+		},
+	}))
+
+	ch := testChannels[0]
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, ch, nil)
+
+	// first token is fetched using the configured refresh token
+	token, err := h.getAccessToken(ch, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACCESS_TOKEN_1", token)
+
+	ttl, _ := redis.Int(rc.Do("TTL", "channel-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab"))
+	assert.Equal(t, 90000, ttl)
+
+	// and is then cached
+	token, err = h.getAccessToken(ch, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACCESS_TOKEN_1", token)
+
+	// once cleared, a new one is fetched using the refresh token we were given
+	require.NoError(t, h.clearAccessToken(ch))
+
+	token, err = h.getAccessToken(ch, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACCESS_TOKEN_2", token)
+
+	refreshToken, _ := redis.String(rc.Do("GET", "channel-refresh-token:8eb23e93-5ecb-45ba-b726-3b064e0c56ab"))
+	assert.Equal(t, "REFRESH_TOKEN_2", refreshToken)
+
+	// if the refresh token is rejected, the channel needs to be reauthorized
+	require.NoError(t, h.clearAccessToken(ch))
+
+	_, err = h.getAccessToken(ch, clog)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+	assert.Equal(t, []*clogs.LogError{courier.ErrorExternal("-14014", "Invalid refresh token")}, clog.Errors)
+
+	// and without credentials we can't even try
+	noCreds := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "ZLO", "388613280878808645", "VN", []string{urns.External.Prefix}, map[string]any{})
+	_, err = h.getAccessToken(noCreds, clog)
+	assert.Equal(t, courier.ErrChannelConfig, err)
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
@@ -22,6 +21,9 @@ var (
 	smsSendURL      = "https://api.zenvia.com/v2/channels/sms/messages"
 )
 
+// timestamps are in UTC, e.g. 2017-05-03T06:04:45Z
+var timestampFormat = &handlers.TimestampFormat{Layouts: []string{"2006-01-02T15:04:05Z"}}
+
 func init() {
 	courier.RegisterHandler(newHandler("ZVW", "Zenvia WhatsApp"))
 	courier.RegisterHandler(newHandler("ZVS", "Zenvia SMS"))
@@ -82,8 +84,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our date from the timestamp
-	// 2017-05-03T06:04:45Z
-	date, err := time.Parse("2006-01-02T15:04:05Z", payload.Timestamp)
+	date, err := timestampFormat.Parse(payload.Timestamp)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", payload.Timestamp))
 	}
@@ -119,7 +120,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		}
 
 		// build our msg
-		msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.Message.ID, clog).WithReceivedOn(date.UTC()).WithContactName(contactName)
+		msg := h.Backend().NewIncomingMsg(channel, urn, text, payload.Message.ID, clog).WithReceivedOn(date).WithContactName(contactName)
 		if mediaURL != "" {
 			msg.WithAttachment(mediaURL)
 		}
@@ -204,7 +205,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		text = msg.Text()
 
 	} else if channel.ChannelType() == "ZVS" {
-		text = handlers.GetTextAndAttachments(msg)
+		text = h.TextAndAttachments(msg)
 	}
 
 	msgParts := make([]string, 0)
@@ -245,5 +246,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 		return courier.ErrResponseContent
 	}
 	res.AddExternalID(externalID)
+
+	if channel.ChannelType() == "ZVS" {
+		for _, part := range msgParts {
+			handlers.AddSMSSegments(res, part)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,130 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+)
+
+// HealthCheck is the result of checking one of the dependencies of a backend, e.g. its database
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	Elapsed int    `json:"elapsed_ms"`
+}
+
+// CheckDependency times the given check of the named dependency
+func CheckDependency(name string, check func() error) *HealthCheck {
+	start := time.Now()
+	err := check()
+
+	hc := &HealthCheck{Name: name, Healthy: err == nil, Elapsed: int(time.Since(start) / time.Millisecond)}
+	if err != nil {
+		hc.Error = err.Error()
+	}
+	return hc
+}
+
+// HandlerHealth is the recent sending history of a handler
+type HandlerHealth struct {
+	ChannelType  ChannelType `json:"channel_type"`
+	Name         string      `json:"name"`
+	Healthy      bool        `json:"healthy"`
+	LastSentOn   *time.Time  `json:"last_sent_on"`
+	LastFailedOn *time.Time  `json:"last_failed_on"`
+}
+
+// sendTracker keeps track of the last successful and failed sends of each channel type by this instance
+type sendTracker struct {
+	lastSent   map[ChannelType]time.Time
+	lastFailed map[ChannelType]time.Time
+	mutex      sync.RWMutex
+}
+
+func newSendTracker() *sendTracker {
+	return &sendTracker{lastSent: make(map[ChannelType]time.Time), lastFailed: make(map[ChannelType]time.Time)}
+}
+
+// records the outcome of a send by the given channel type, ignoring statuses which aren't a final outcome
+func (t *sendTracker) record(channelType ChannelType, status MsgStatus) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch status {
+	case MsgStatusWired, MsgStatusSent, MsgStatusDelivered, MsgStatusRead:
+		t.lastSent[channelType] = dates.Now()
+	case MsgStatusErrored, MsgStatusFailed:
+		t.lastFailed[channelType] = dates.Now()
+	}
+}
+
+// a handler is healthy unless its most recent send failed
+func (t *sendTracker) health(h ChannelHandler) *HandlerHealth {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	hh := &HandlerHealth{ChannelType: h.ChannelType(), Name: h.ChannelName(), Healthy: true}
+
+	if sent, ok := t.lastSent[h.ChannelType()]; ok {
+		hh.LastSentOn = &sent
+	}
+	if failed, ok := t.lastFailed[h.ChannelType()]; ok {
+		hh.LastFailedOn = &failed
+		hh.Healthy = hh.LastSentOn != nil && !hh.LastSentOn.Before(failed)
+	}
+	return hh
+}
+
+type healthResponse struct {
+	Status   string           `json:"status"`
+	Version  string           `json:"version"`
+	Checks   []*HealthCheck   `json:"checks"`
+	Handlers []*HandlerHealth `json:"handlers"`
+}
+
+// handleHealth reports the health of the backend's dependencies and the handlers, returning a 503 if any dependency is
+// unhealthy. Handlers don't affect the status code since a provider outage affects every instance equally. Errors can
+// contain hostnames and the like so are only included when detailed, which requires auth, and are otherwise logged.
+func (s *server) handleHealth(detailed bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+		defer cancel()
+
+		resp := &healthResponse{Status: "ok", Version: s.config.Version, Checks: []*HealthCheck{}, Handlers: []*HandlerHealth{}}
+		if checker, ok := BackendAs[HealthChecker](s.backend); ok {
+			resp.Checks = checker.CheckHealth(ctx)
+		}
+		for _, c := range resp.Checks {
+			if !c.Healthy {
+				resp.Status = "unhealthy"
+
+				if !detailed {
+					slog.Error("health check failed", "dependency", c.Name, "error", c.Error)
+					c.Error = ""
+				}
+			}
+		}
+
+		for _, h := range s.activeHandlers {
+			resp.Handlers = append(resp.Handlers, s.foreman.sends.health(h))
+		}
+		slices.SortFunc(resp.Handlers, func(a, b *HandlerHealth) int { return strings.Compare(string(a.ChannelType), string(b.ChannelType)) })
+
+		status := http.StatusOK
+		if resp.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,246 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/redisx"
+)
+
+// NumberType is the type of a phone number as reported by a carrier lookup
+type NumberType string
+
+// possible values for NumberType
+const (
+	NumberTypeMobile   NumberType = "mobile"
+	NumberTypeLandline NumberType = "landline"
+	NumberTypeVoIP     NumberType = "voip"
+	NumberTypeUnknown  NumberType = "unknown"
+)
+
+// NumberLookup is the result of looking up a phone number with a carrier lookup provider
+type NumberLookup struct {
+	Valid   bool       `json:"valid"`
+	Type    NumberType `json:"type"`
+	Carrier string     `json:"carrier,omitempty"`
+}
+
+var (
+	twilioLookupURL = "https://lookups.twilio.com/v2/PhoneNumbers"
+	vonageLookupURL = "https://api.nexmo.com/ni/standard/json"
+)
+
+// lookups rarely change so are cached for 7-8 days
+func numberLookupCache() *redisx.IntervalHash {
+	return redisx.NewIntervalHash(valkey.Tag("number-lookups"), time.Hour*24, 7)
+}
+
+// ErrNumberInvalid is returned when a number lookup reports that the destination number isn't valid
+var ErrNumberInvalid error = &SendError{
+	msg:       "number invalid",
+	retryable: false,
+	loggable:  false,
+	clogCode:  "number_invalid",
+	clogMsg:   "Number lookup reported that the destination number is not valid.",
+}
+
+// ErrNumberLandline is returned when a number lookup reports that the destination number is a landline
+var ErrNumberLandline error = &SendError{
+	msg:       "number is landline",
+	retryable: false,
+	loggable:  false,
+	clogCode:  "number_landline",
+	clogMsg:   "Number lookup reported that the destination number is a landline which can't receive messages.",
+}
+
+// numberLookupConfig is the org config which enables number lookups, e.g.
+//
+//	{"provider": "twilio", "account_sid": "...", "auth_token": "..."}
+//	{"provider": "vonage", "api_key": "...", "api_secret": "..."}
+type numberLookupConfig struct {
+	Provider   string `json:"provider"`
+	AccountSID string `json:"account_sid"`
+	AuthToken  string `json:"auth_token"`
+	APIKey     string `json:"api_key"`
+	APISecret  string `json:"api_secret"`
+}
+
+// returns the secret values which shouldn't appear in logs
+func (c *numberLookupConfig) redactValues() []string {
+	if c.Provider == "twilio" {
+		return []string{httpx.BasicAuth(c.AccountSID, c.AuthToken), c.AuthToken}
+	}
+	return []string{c.APISecret}
+}
+
+// returns the number lookup config for the org of the given channel, or nil if lookups aren't enabled
+func numberLookupConfigForChannel(ch Channel) *numberLookupConfig {
+	raw, isMap := ch.OrgConfigForKey(ConfigNumberLookup, nil).(map[string]any)
+	if !isMap {
+		return nil
+	}
+
+	config := &numberLookupConfig{}
+	if err := json.Unmarshal(jsonx.MustMarshal(raw), config); err != nil {
+		return nil
+	}
+	if config.Provider != "twilio" && config.Provider != "vonage" {
+		return nil
+	}
+	return config
+}
+
+// checks the destination number of the given message if its org has number lookups enabled, returning an error if
+// the message can't be delivered. Any lookup made is logged to the returned channel log, and lookups which fail
+// don't prevent sending.
+func checkNumber(ctx context.Context, b Backend, msg MsgOut) (*ChannelLog, error) {
+	if msg.URN().Scheme() != urns.Phone.Prefix {
+		return nil, nil
+	}
+
+	config := numberLookupConfigForChannel(msg.Channel())
+	if config == nil {
+		return nil, nil
+	}
+
+	number := msg.URN().Path()
+
+	rc := b.RedisPool().Get()
+	defer rc.Close()
+
+	lookup, err := cachedNumberLookup(rc, number)
+	if err != nil {
+		return nil, nil // cache errors aren't fatal, just means we'll do the lookup again
+	}
+
+	var clog *ChannelLog
+	if lookup == nil {
+		clog = NewChannelLog(ChannelLogTypeNumberLookup, msg.Channel(), config.redactValues())
+
+		lookup, err = lookupNumber(ctx, config, number, clog)
+		clog.End()
+
+		if err != nil {
+			clog.Error(clogs.NewLogError("lookup_failed", "", err.Error()))
+			return clog, nil
+		}
+
+		numberLookupCache().Set(rc, number, string(jsonx.MustMarshal(lookup)))
+	}
+
+	if !lookup.Valid {
+		return clog, ErrNumberInvalid
+	} else if lookup.Type == NumberTypeLandline {
+		return clog, ErrNumberLandline
+	}
+	return clog, nil
+}
+
+func cachedNumberLookup(rc redis.Conn, number string) (*NumberLookup, error) {
+	cached, err := numberLookupCache().Get(rc, number)
+	if err != nil || cached == "" {
+		return nil, err
+	}
+
+	lookup := &NumberLookup{}
+	if err := json.Unmarshal([]byte(cached), lookup); err != nil {
+		return nil, nil
+	}
+	return lookup, nil
+}
+
+// looks up the given number using the provider in the given config
+func lookupNumber(ctx context.Context, config *numberLookupConfig, number string, clog *ChannelLog) (*NumberLookup, error) {
+	if config.Provider == "twilio" {
+		return lookupNumberTwilio(ctx, config, number, clog)
+	}
+	return lookupNumberVonage(ctx, config, number, clog)
+}
+
+// see https://www.twilio.com/docs/lookup/v2-api/line-type-intelligence
+func lookupNumberTwilio(ctx context.Context, config *numberLookupConfig, number string, clog *ChannelLog) (*NumberLookup, error) {
+	lookupURL := fmt.Sprintf("%s/%s?Fields=line_type_intelligence", twilioLookupURL, url.PathEscape(number))
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, lookupURL, nil)
+	req.SetBasicAuth(config.AccountSID, config.AuthToken)
+
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024*1024)
+	if trace != nil {
+		clog.HTTP(trace)
+	}
+	if err != nil || trace.Response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("error looking up number with twilio")
+	}
+
+	valid, _ := jsonparser.GetBoolean(trace.ResponseBody, "valid")
+	lineType, _ := jsonparser.GetString(trace.ResponseBody, "line_type_intelligence", "type")
+	carrier, _ := jsonparser.GetString(trace.ResponseBody, "line_type_intelligence", "carrier_name")
+
+	numType := NumberTypeUnknown
+	switch lineType {
+	case "mobile":
+		numType = NumberTypeMobile
+	case "landline":
+		numType = NumberTypeLandline
+	case "fixedVoip", "nonFixedVoip":
+		numType = NumberTypeVoIP
+	}
+
+	return &NumberLookup{Valid: valid, Type: numType, Carrier: carrier}, nil
+}
+
+// see https://developer.vonage.com/en/number-insight/technical-details
+func lookupNumberVonage(ctx context.Context, config *numberLookupConfig, number string, clog *ChannelLog) (*NumberLookup, error) {
+	form := url.Values{
+		"api_key":    []string{config.APIKey},
+		"api_secret": []string{config.APISecret},
+		"number":     []string{strings.TrimPrefix(number, "+")},
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, vonageLookupURL+"?"+form.Encode(), nil)
+
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024*1024)
+	if trace != nil {
+		clog.HTTP(trace)
+	}
+	if err != nil || trace.Response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("error looking up number with vonage")
+	}
+
+	// a status of 3 means the number is invalid, anything else non-zero is an error
+	status, err := jsonparser.GetInt(trace.ResponseBody, "status")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read status from vonage response")
+	} else if status == 3 {
+		return &NumberLookup{Valid: false, Type: NumberTypeUnknown}, nil
+	} else if status != 0 {
+		return nil, fmt.Errorf("vonage lookup returned status %d", status)
+	}
+
+	networkType, _ := jsonparser.GetString(trace.ResponseBody, "current_carrier", "network_type")
+	carrier, _ := jsonparser.GetString(trace.ResponseBody, "current_carrier", "name")
+
+	numType := NumberTypeUnknown
+	switch networkType {
+	case "mobile":
+		numType = NumberTypeMobile
+	case "landline", "landline_premium", "landline_tollfree":
+		numType = NumberTypeLandline
+	case "virtual":
+		numType = NumberTypeVoIP
+	}
+
+	return &NumberLookup{Valid: true, Type: numType, Carrier: carrier}, nil
+}
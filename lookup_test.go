@@ -0,0 +1,108 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberLookup(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://lookups.twilio.com/v2/PhoneNumbers/+12065550001?Fields=line_type_intelligence": {
+			httpx.NewMockResponse(200, nil, []byte(`{"valid": true, "line_type_intelligence": {"type": "landline", "carrier_name": "Acme"}}`)),
+		},
+		"https://lookups.twilio.com/v2/PhoneNumbers/+12065550002?Fields=line_type_intelligence": {
+			httpx.NewMockResponse(200, nil, []byte(`{"valid": true, "line_type_intelligence": {"type": "mobile", "carrier_name": "Acme"}}`)),
+		},
+		"https://lookups.twilio.com/v2/PhoneNumbers/+12065550003?Fields=line_type_intelligence": {
+			httpx.NewMockResponse(500, nil, []byte(`Error`)),
+		},
+		"https://api.nexmo.com/ni/standard/json?api_key=key123&api_secret=sesame&number=250788000001": {
+			httpx.NewMockResponse(200, nil, []byte(`{"status": 3, "status_message": "Invalid number"}`)),
+		},
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	mb := test.NewMockBackend()
+	s := courier.NewServer(testConfig(), mb)
+
+	s.Start()
+	defer s.Stop()
+
+	twilioChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	twilioChannel.SetOrgConfig(courier.ConfigNumberLookup, map[string]any{"provider": "twilio", "account_sid": "AC123", "auth_token": "sesame"})
+	mb.AddChannel(twilioChannel)
+
+	// message to a landline fails without being sent
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, twilioChannel, "tel:+12065550001", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 2)
+	assert.Equal(t, courier.ChannelLogTypeNumberLookup, mb.WrittenChannelLogs()[0].Type)
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 1)
+	assert.NotContains(t, mb.WrittenChannelLogs()[0].HttpLogs[0].Request, "sesame")
+	assert.Equal(t, courier.ChannelLogTypeMsgSend, mb.WrittenChannelLogs()[1].Type)
+	assert.Equal(t, "number_landline", mb.WrittenChannelLogs()[1].Errors[0].Code)
+	assert.Len(t, mb.WrittenChannelLogs()[1].HttpLogs, 0)
+	mb.Reset()
+
+	// second message to same landline uses cached lookup
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, twilioChannel, "tel:+12065550001", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 1)
+	mb.Reset()
+
+	// message to a mobile number is sent
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(103), courier.NilMsgUUID, twilioChannel, "tel:+12065550002", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 2)
+	mb.Reset()
+
+	// failed lookups don't prevent sending
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(104), courier.NilMsgUUID, twilioChannel, "tel:+12065550003", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 2)
+	assert.Equal(t, "lookup_failed", mb.WrittenChannelLogs()[0].Errors[0].Code)
+	mb.Reset()
+
+	// as do messages to non-phone URNs
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(105), courier.NilMsgUUID, twilioChannel, "telegram:12345", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 1)
+	mb.Reset()
+
+	vonageChannel := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MCK", "2020", "RW", []string{urns.Phone.Prefix}, map[string]any{})
+	vonageChannel.SetOrgConfig(courier.ConfigNumberLookup, map[string]any{"provider": "vonage", "api_key": "key123", "api_secret": "sesame"})
+	mb.AddChannel(vonageChannel)
+
+	// message to an invalid number fails without being sent
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(106), courier.NilMsgUUID, vonageChannel, "tel:+250788000001", "hello", nil))
+
+	require.Len(t, mb.WrittenMsgStatuses(), 1)
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+	require.Len(t, mb.WrittenChannelLogs(), 2)
+	assert.NotContains(t, mb.WrittenChannelLogs()[0].HttpLogs[0].URL, "sesame")
+	assert.Equal(t, "number_invalid", mb.WrittenChannelLogs()[1].Errors[0].Code)
+
+	assert.False(t, mocks.HasUnused())
+}
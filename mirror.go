@@ -0,0 +1,91 @@
+package courier
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nyaruka/gocommon/httpx"
+)
+
+// headers we don't copy from the original request to the mirrored request
+var mirrorSkipHeaders = map[string]bool{"Connection": true, "Content-Length": true, "Transfer-Encoding": true}
+
+// mirrors the given incoming request to the webhook mirror URL of the channel, if it has one, without blocking
+func (s *server) mirrorRequest(channel Channel, requestTrace []byte) {
+	mirrorURL := channel.StringConfigForKey(ConfigWebhookMirrorURL, "")
+	if mirrorURL == "" {
+		return
+	}
+
+	log := slog.With("comp", "mirror", "channel_uuid", channel.UUID(), "url", mirrorURL)
+
+	req, err := newMirrorRequest(channel, mirrorURL, requestTrace)
+	if err != nil {
+		log.Error("error creating mirror request", "error", err)
+		return
+	}
+
+	s.waitGroup.Add(1)
+
+	go func() {
+		defer s.waitGroup.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		trace, err := httpx.DoTrace(s.backend.HttpClient(true), req.WithContext(ctx), nil, s.backend.HttpAccess(), 1024)
+		if err != nil {
+			log.Error("error mirroring request", "error", err)
+		} else if trace.Response.StatusCode/100 != 2 {
+			log.Error("error mirroring request", "status", trace.Response.StatusCode)
+		}
+	}()
+}
+
+// creates a copy of the request in the given trace which is sent to the given URL instead
+func newMirrorRequest(channel Channel, mirrorURL string, requestTrace []byte) (*http.Request, error) {
+	original, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(requestTrace)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request trace: %w", err)
+	}
+
+	body, err := io.ReadAll(original.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	u, err := url.Parse(mirrorURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror URL: %w", err)
+	}
+
+	// providers often send data as query parameters so those are preserved
+	if original.URL.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&" + original.URL.RawQuery
+		} else {
+			u.RawQuery = original.URL.RawQuery
+		}
+	}
+
+	req, err := http.NewRequest(original.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	for k, vs := range original.Header {
+		if !mirrorSkipHeaders[k] {
+			req.Header[k] = vs
+		}
+	}
+	req.Header.Set("X-Courier-Channel", string(channel.UUID()))
+
+	return req, nil
+}
@@ -3,7 +3,11 @@ package courier
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nyaruka/gocommon/i18n"
@@ -54,10 +58,18 @@ const (
 )
 
 type TemplatingVariable struct {
-	Type  string `json:"type"`
-	Value string `json:"value"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Format   string `json:"format,omitempty"`   // optional hint for formatting text values, one of number, currency or date
+	Currency string `json:"currency,omitempty"` // ISO 4217 code of currency values, e.g. USD
 }
 
+const (
+	TemplatingFormatNumber   = "number"
+	TemplatingFormatCurrency = "currency"
+	TemplatingFormatDate     = "date"
+)
+
 type Templating struct {
 	Template struct {
 		Name string `json:"name" validate:"required"`
@@ -81,6 +93,39 @@ type Session struct {
 	Timeout    int    `json:"timeout"`
 }
 
+// OutgoingMsg is an outgoing message from a producer other than mailroom, e.g. the gRPC API, which backends queue to
+// be sent in the same way as messages from mailroom
+type OutgoingMsg struct {
+	UUID         MsgUUID  `json:"uuid"`
+	URN          urns.URN `json:"urn"`
+	Text         string   `json:"text"`
+	Attachments  []string `json:"attachments,omitempty"`
+	QuickReplies []string `json:"quick_replies,omitempty"`
+	HighPriority bool     `json:"high_priority"`
+}
+
+// Validate checks that this message can be sent by the given channel
+func (m *OutgoingMsg) Validate(ch Channel) error {
+	if m.Text == "" && len(m.Attachments) == 0 {
+		return errors.New("message must have text or attachments")
+	}
+	if err := ValidateURN(m.URN); err != nil {
+		return fmt.Errorf("invalid URN: %w", err)
+	}
+	if !slices.Contains(ch.Schemes(), m.URN.Scheme()) {
+		return fmt.Errorf("URN scheme %s not supported by channel", m.URN.Scheme())
+	}
+
+	// attachments are in the same format as those from mailroom, i.e. content type and URL
+	for _, a := range m.Attachments {
+		contentType, url, _ := strings.Cut(a, ":")
+		if contentType == "" || !(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) {
+			return fmt.Errorf("invalid attachment: %s", a)
+		}
+	}
+	return nil
+}
+
 //-----------------------------------------------------------------------------
 // Msg interface
 //-----------------------------------------------------------------------------
@@ -110,6 +155,7 @@ type MsgOut interface {
 	Origin() MsgOrigin
 	ContactLastSeenOn() *time.Time
 	Topic() string
+	URLPreview() *bool
 	Metadata() json.RawMessage
 	ResponseToExternalID() string
 	SentOn() *time.Time
@@ -131,4 +177,9 @@ type MsgIn interface {
 	WithContactName(name string) MsgIn
 	WithURNAuthTokens(tokens map[string]string) MsgIn
 	WithReceivedOn(date time.Time) MsgIn
+	WithMetadata(metadata json.RawMessage) MsgIn
+
+	// WithEcho marks this as a message sent by the channel owner from another app, e.g. the WhatsApp Business app, which
+	// should be recorded as an outgoing message that has already been sent
+	WithEcho() MsgIn
 }
@@ -0,0 +1,52 @@
+package courier
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// number of times sending a message can crash its handler before we fail it as poison rather than let it be retried
+const maxSendPanics = 3
+
+// how long we count the crashes of a message for, which comfortably covers all of its retries
+const sendPanicsTTL = 24 * time.Hour
+
+// handlerPanic is the error we get in place of the result of a send when the handler panicked
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p *handlerPanic) Error() string {
+	return fmt.Sprintf("handler panicked: %v", p.value)
+}
+
+// calls the given send function, recovering from any panic in it so that one malformed message can't take down the
+// sender that's sending it
+func recoverSend(send func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &handlerPanic{value: r, stack: debug.Stack()}
+		}
+	}()
+
+	return send()
+}
+
+// records a handler crash when sending the given message, returning how many crashes it has now caused
+func recordSendPanic(rc redis.Conn, id MsgID) (int, error) {
+	key := fmt.Sprintf("send-panics:%d", id)
+
+	rc.Send("MULTI")
+	rc.Send("INCR", key)
+	rc.Send("EXPIRE", key, int(sendPanicsTTL/time.Second))
+	values, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return 0, fmt.Errorf("error recording send panic: %w", err)
+	}
+
+	return redis.Int(values[0], nil)
+}
@@ -0,0 +1,130 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// previewRequest is the message to preview the send of, e.g.
+//
+//	{"urn": "tel:+250788123123", "text": "Hi there", "quick_replies": ["Yes", "No"]}
+type previewRequest struct {
+	URN          urns.URN        `json:"urn"           validate:"required"`
+	Text         string          `json:"text"`
+	Attachments  []string        `json:"attachments"`
+	QuickReplies []string        `json:"quick_replies"`
+	Locale       i18n.Locale     `json:"locale"`
+	Templating   *Templating     `json:"templating"`
+	Metadata     json.RawMessage `json:"metadata"`
+}
+
+type previewResponse struct {
+	Requests []*httpx.Log      `json:"requests"`
+	Errors   []*clogs.LogError `json:"errors"`
+}
+
+func (s *server) handlePreviewMsg(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	request := &previewRequest{}
+	if err := json.Unmarshal(body, request); err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error unmarshalling request: %w", err))
+		return
+	}
+	if err := utils.Validate(request); err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, errors.New("no such channel"))
+		return
+	}
+
+	handler := s.GetHandler(channel)
+	if handler == nil {
+		WriteError(w, http.StatusBadRequest, errors.New("no handler for channel type"))
+		return
+	}
+
+	clog := previewSend(ctx, handler, &previewMsg{previewRequest: request, channel: channel})
+
+	writeAdminResponse(w, &previewResponse{Requests: clog.HttpLogs, Errors: clog.Errors})
+}
+
+// previews sending the given message with the given handler, returning a log of the requests that would have been made
+func previewSend(ctx context.Context, h ChannelHandler, msg MsgOut) *ChannelLog {
+	clog := NewChannelLog(ChannelLogTypeMsgPreview, msg.Channel(), h.RedactValues(msg.Channel()))
+
+	err := h.Send(ctx, msg, &SendResult{newURN: urns.NilURN}, clog)
+	clog.End()
+
+	// requests aren't actually made so connection errors are expected, but other errors mean the message can't be sent
+	var serr *SendError
+	if errors.As(err, &serr) && !(serr == ErrConnectionFailed && len(clog.HttpLogs) > 0) {
+		clog.Error(clogs.NewLogError(serr.clogCode, serr.clogExtCode, serr.clogMsg))
+	} else if err != nil && serr == nil {
+		clog.RawError(err)
+	}
+
+	return clog
+}
+
+// previewMsg is an outgoing message which only exists to be previewed
+type previewMsg struct {
+	*previewRequest
+
+	channel Channel
+}
+
+func (m *previewMsg) EventID() int64        { return 0 }
+func (m *previewMsg) ID() MsgID             { return NilMsgID }
+func (m *previewMsg) UUID() MsgUUID         { return NilMsgUUID }
+func (m *previewMsg) ExternalID() string    { return "" }
+func (m *previewMsg) Text() string          { return m.previewRequest.Text }
+func (m *previewMsg) Attachments() []string { return m.previewRequest.Attachments }
+func (m *previewMsg) URN() urns.URN         { return m.previewRequest.URN }
+func (m *previewMsg) Channel() Channel      { return m.channel }
+
+func (m *previewMsg) QuickReplies() []string        { return m.previewRequest.QuickReplies }
+func (m *previewMsg) Locale() i18n.Locale           { return m.previewRequest.Locale }
+func (m *previewMsg) Templating() *Templating       { return m.previewRequest.Templating }
+func (m *previewMsg) URNAuth() string               { return "" }
+func (m *previewMsg) Origin() MsgOrigin             { return MsgOriginFlow }
+func (m *previewMsg) ContactLastSeenOn() *time.Time { return nil }
+func (m *previewMsg) Topic() string                 { return "" }
+func (m *previewMsg) URLPreview() *bool             { return nil }
+func (m *previewMsg) Metadata() json.RawMessage     { return m.previewRequest.Metadata }
+func (m *previewMsg) ResponseToExternalID() string  { return "" }
+func (m *previewMsg) SentOn() *time.Time            { return nil }
+func (m *previewMsg) IsResend() bool                { return false }
+func (m *previewMsg) Flow() *FlowReference          { return nil }
+func (m *previewMsg) OptIn() *OptInReference        { return nil }
+func (m *previewMsg) UserID() UserID                { return 0 }
+func (m *previewMsg) HighPriority() bool            { return false }
+func (m *previewMsg) Session() *Session             { return nil }
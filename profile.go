@@ -0,0 +1,56 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+)
+
+type profileResponse struct {
+	Requests []*httpx.Log      `json:"requests"`
+	Errors   []*clogs.LogError `json:"errors"`
+}
+
+// handleConfigureProfile pushes the profile in a channel's config to its provider, e.g. after a channel is claimed
+func (s *server) handleConfigureProfile(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, errors.New("no such channel"))
+		return
+	}
+
+	handler := s.GetHandler(channel)
+	configurer, isConfigurer := handler.(ProfileConfigurer)
+	if !isConfigurer {
+		WriteError(w, http.StatusBadRequest, errors.New("channel type doesn't support profile configuration"))
+		return
+	}
+
+	clog := NewChannelLog(ChannelLogTypeProfileUpdate, channel, handler.RedactValues(channel))
+
+	if err := configurer.ConfigureProfile(ctx, channel, clog); err != nil {
+		clog.Error(clogs.NewLogError("profile_update", "", err.Error()))
+	}
+
+	clog.End()
+
+	if err := s.backend.WriteChannelLog(ctx, clog); err != nil {
+		slog.Error("error writing channel log", "error", err, "channel_uuid", channelUUID)
+	}
+
+	writeAdminResponse(w, &profileResponse{Requests: clog.HttpLogs, Errors: clog.Errors})
+}
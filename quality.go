@@ -0,0 +1,85 @@
+package courier
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ChannelQuality is the quality state of a channel as reported by its provider
+type ChannelQuality struct {
+	Rating    string    `json:"rating"`         // e.g. GREEN, YELLOW, RED
+	Tier      string    `json:"tier,omitempty"` // messaging limit tier, e.g. TIER_1K
+	CheckedOn time.Time `json:"checked_on"`
+}
+
+// qualityPoller periodically checks the quality of all active channels whose handlers implement QualityChecker
+type qualityPoller struct {
+	server   Server
+	lister   ChannelLister
+	writer   QualityWriter
+	types    []ChannelType
+	interval time.Duration
+}
+
+func newQualityPoller(s Server, lister ChannelLister, writer QualityWriter, types []ChannelType, interval time.Duration) *qualityPoller {
+	return &qualityPoller{server: s, lister: lister, writer: writer, types: types, interval: interval}
+}
+
+// Start starts a goroutine which checks channels every interval until the server is stopped
+func (p *qualityPoller) Start() {
+	p.server.WaitGroup().Add(1)
+
+	go func() {
+		defer p.server.WaitGroup().Done()
+
+		for {
+			select {
+			case <-p.server.StopChan():
+				return
+			case <-time.After(p.interval):
+				p.check(context.Background())
+			}
+		}
+	}()
+}
+
+func (p *qualityPoller) check(ctx context.Context) {
+	for _, typ := range p.types {
+		channels, err := p.lister.GetChannelsByType(ctx, typ)
+		if err != nil {
+			slog.Error("error loading channels to check quality of", "comp", "quality poller", "channel_type", typ, "error", err)
+			continue
+		}
+
+		for _, ch := range channels {
+			p.checkChannel(ctx, ch)
+		}
+	}
+}
+
+func (p *qualityPoller) checkChannel(ctx context.Context, ch Channel) {
+	handler := p.server.GetHandler(ch)
+	checker := handler.(QualityChecker)
+	log := slog.With("comp", "quality poller", "channel_uuid", ch.UUID())
+
+	clog := NewChannelLog(ChannelLogTypeQualityCheck, ch, handler.RedactValues(ch))
+
+	quality, err := checker.CheckQuality(ctx, ch, clog)
+	if err != nil {
+		log.Error("error checking channel quality", "error", err)
+	} else if quality != nil {
+		if err := p.writer.WriteChannelQuality(ctx, ch, quality); err != nil {
+			log.Error("error writing channel quality", "error", err)
+		}
+	}
+
+	clog.End()
+
+	// only bother writing logs for checks which failed
+	if clog.IsError() {
+		if err := p.server.Backend().WriteChannelLog(ctx, clog); err != nil {
+			log.Error("error writing channel log", "error", err)
+		}
+	}
+}
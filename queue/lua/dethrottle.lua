@@ -1,25 +1,13 @@
--- KEYS: [QueueType]
+-- KEYS: [QueueType:throttled, QueueType:future, QueueType:active]
 
--- get all the keys from our throttle list
-local throttled = redis.call("zrange", KEYS[1] .. ":throttled", 0, -1, "WITHSCORES")
+-- move all the queues from our throttled and future sets to our active set
+for i=1,2 do
+    local queues = redis.call("zrange", KEYS[i], 0, -1, "WITHSCORES")
 
--- add them to our active list
-if next(throttled) then
-    local activeKey = KEYS[1] .. ":active"
-    for i=1,#throttled,2 do
-        redis.call("zincrby", activeKey, throttled[i+1], throttled[i])
+    if next(queues) then
+        for j=1,#queues,2 do
+            redis.call("zincrby", KEYS[3], queues[j+1], queues[j])
+        end
+        redis.call("del", KEYS[i])
     end
-    redis.call("del", KEYS[1] .. ":throttled")
 end
-
--- get all the keys in the future
-local future = redis.call("zrange", KEYS[1] .. ":future", 0, -1, "WITHSCORES")
-
--- add them to our active list
-if next(future) then
-    local activeKey = KEYS[1] .. ":active"
-    for i=1,#future,2 do
-        redis.call("zincrby", activeKey, future[i+1], future[i])
-    end
-    redis.call("del", KEYS[1] .. ":future")
-end
\ No newline at end of file
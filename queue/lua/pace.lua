@@ -0,0 +1,10 @@
+-- KEYS: [Key]
+-- ARGV: [EpochMS, Interval, TTL]
+
+-- the next free slot is the later of now and the slot after the last one we gave out
+local next = tonumber(redis.call("get", KEYS[1])) or 0
+local slot = math.max(next, tonumber(ARGV[1]))
+
+redis.call("set", KEYS[1], string.format("%.6f", slot + tonumber(ARGV[2])), "EX", ARGV[3])
+
+return string.format("%.6f", slot)
@@ -0,0 +1,56 @@
+-- KEYS: [PriorityQueue, BulkQueue, TPSKey, RateLimitKey, BulkRateLimitKey, QueueType:paused]
+-- ARGV: [EpochMS, TPS, Max, QueueName]
+
+local tps = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+local popped = {}
+
+-- if our queue is paused or rate limited, we can't pop anything more
+if redis.call("sismember", KEYS[6], ARGV[4]) == 1 or redis.call("get", KEYS[4]) then
+    return popped
+end
+
+-- if we have a tps, then limit how many more we can pop this second
+if tps > 0 then
+    local curr = tonumber(redis.call("get", KEYS[3])) or 0
+    max = math.min(max, tps - curr)
+end
+
+-- pops values from the given priority queue until we have our max or run out of eligible items
+local function popFrom(priorityQueue)
+    while #popped < max do
+        local result = redis.call("zrangebyscore", priorityQueue, 0, ARGV[1], "WITHSCORES", "LIMIT", 0, 1)
+        if not result[1] then
+            return
+        end
+
+        redis.call("zrem", priorityQueue, result[1])
+
+        -- each item is a JSON list of values so take as many of those as we can
+        local valueList = cjson.decode(result[1])
+        while #valueList > 0 and #popped < max do
+            table.insert(popped, cjson.encode(valueList[1]))
+            table.remove(valueList, 1)
+        end
+
+        -- put back anything left over with its original score
+        if #valueList > 0 then
+            redis.call("zadd", priorityQueue, result[2], cjson.encode(valueList))
+        end
+    end
+end
+
+popFrom(KEYS[1])
+
+-- only try our bulk queue if it isn't rate limited
+if not redis.call("get", KEYS[5]) then
+    popFrom(KEYS[2])
+end
+
+-- increment our tps for this second if we have a limit
+if tps > 0 and #popped > 0 then
+    redis.call("incrby", KEYS[3], #popped)
+    redis.call("expire", KEYS[3], 10)
+end
+
+return popped
@@ -1,26 +1,19 @@
--- KEYS: [EpochMS, QueueType, QueueName, TPS, Priority, Value]
+-- KEYS: [PriorityQueue, TPSKey, QueueType:active]
+-- ARGV: [EpochMS, TPS, Value, Queue]
 
--- first push onto our specific queue
--- our queue name is built from the type, name and tps, usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps"
-local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. KEYS[4]
+-- push onto our priority queue, the key of which is usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps/priority"
+redis.call("zadd", KEYS[1], ARGV[1], ARGV[3])
 
--- our priority queue name also includes the priority of the message (we have one queue for default and one for bulk)
-local priorityQueueKey = queueKey .. "/" .. KEYS[5]
-redis.call("zadd", priorityQueueKey, KEYS[1], KEYS[6])
-
-local tps = tonumber(KEYS[4])
+local tps = tonumber(ARGV[2])
 
 -- if we have a TPS, check whether we are currently throttled
-local curr = -1
 if tps > 0 then
-    local tpsKey = queueKey .. ":tps:" .. math.floor(KEYS[1])
-    curr = tonumber(redis.call("get", tpsKey))
+    local curr = tonumber(redis.call("get", KEYS[2]))
+    if curr and curr >= tps then
+        return 0
+    end
 end
 
--- if we aren't then add to our active
-if not curr or curr < tps then
-    redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
-    return 1
-else 
-    return 0
-end
\ No newline at end of file
+-- we aren't, so add to our active set
+redis.call("zincrby", KEYS[3], 0, ARGV[4])
+return 1
@@ -0,0 +1,8 @@
+-- KEYS: [TPSKey]
+
+-- give back what popping a value cost our tps for this second, never going below zero
+local curr = tonumber(redis.call("get", KEYS[1]))
+
+if curr and curr > 0 then
+    redis.call("decr", KEYS[1])
+end
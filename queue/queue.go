@@ -2,12 +2,17 @@ package queue
 
 import (
 	_ "embed"
+	"fmt"
+	"hash/crc32"
 	"log/slog"
+	"math/rand/v2"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
 )
 
 // Priority represents the priority of an item in a queue
@@ -33,35 +38,143 @@ const (
 	Retry = WorkerToken("retry")
 )
 
+// In a cluster, the queues of each type are spread across shards which each have their own sets of active, throttled,
+// future and paused queues. All the keys of a shard are tagged, e.g. {msgs:3}:active, so that they're in the same slot
+// and popping can still be a single script. Otherwise there's a single shard with untagged keys, e.g. msgs:active.
+const clusterShards = 16
+
+// returns the prefixes of the keys of all shards of queues of the given type, e.g. msgs or {msgs:0}...{msgs:15}
+func shardPrefixes(qType string) []string {
+	if !valkey.IsCluster() {
+		return []string{qType}
+	}
+
+	prefixes := make([]string, clusterShards)
+	for i := range prefixes {
+		prefixes[i] = valkey.Tag(fmt.Sprintf("%s:%d", qType, i))
+	}
+	return prefixes
+}
+
+// returns the prefix of the keys of the shard which the queue with the given name, e.g. a channel UUID, belongs to
+func shardPrefix(qType string, name string) string {
+	if !valkey.IsCluster() {
+		return qType
+	}
+	return valkey.Tag(fmt.Sprintf("%s:%d", qType, crc32.ChecksumIEEE([]byte(name))%clusterShards))
+}
+
+// returns the key of the given queue, e.g. msgs:chan1|10 or {msgs:3}:chan1|10 in a cluster
+func queueKey(qType string, queue string) string {
+	name, _, _ := strings.Cut(queue, "|")
+	return shardPrefix(qType, name) + ":" + queue
+}
+
+// parses the given queue key, e.g. msgs:chan1|10 or {msgs:3}:chan1|10, into the queue name and TPS
+func parseQueueKey(qType string, key string) (string, int) {
+	if strings.HasPrefix(key, "{") {
+		_, key, _ = strings.Cut(key, "}:")
+	} else {
+		key = strings.TrimPrefix(key, qType+":")
+	}
+	name, tps, _ := strings.Cut(key, "|")
+	t, _ := strconv.Atoi(tps)
+	return name, t
+}
+
+// returns the prefix of the rate limit keys of queues in the shard with the given prefix, which is empty outside of a
+// cluster so that those keys are named as they always have been, e.g. rate_limit:chan1
+func rateLimitPrefix(prefix string) string {
+	if !valkey.IsCluster() {
+		return ""
+	}
+	return prefix + ":"
+}
+
+// returns the key which counts the values popped from the queue with the given key in the second of the given time
+func tpsKey(key string, t time.Time) string {
+	return fmt.Sprintf("%s:tps:%d", key, t.Unix())
+}
+
+// RateLimitKey returns the key which, while it's set, stops any values being popped from the queue with the given
+// name, e.g. a channel UUID. In a cluster this is in the same slot as the queue.
+func RateLimitKey(qType string, name string) string {
+	return rateLimitPrefix(shardPrefix(qType, name)) + "rate_limit:" + name
+}
+
+// BulkRateLimitKey returns the key which, while it's set, stops any low priority values being popped from the queue
+// with the given name, e.g. a channel UUID. In a cluster this is in the same slot as the queue.
+func BulkRateLimitKey(qType string, name string) string {
+	return rateLimitPrefix(shardPrefix(qType, name)) + "rate_limit_bulk:" + name
+}
+
 //go:embed lua/push.lua
 var luaPush string
-var scriptPush = redis.NewScript(6, luaPush)
+var scriptPush = redis.NewScript(3, luaPush)
 
 // PushOntoQueue pushes the passed in value to the passed in queue, making sure that no more than the
 // specified transactions per second are popped off at a time. A tps value of 0 means there is no
 // limit to the rate that messages can be consumed
 func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	_, err := redis.Int(scriptPush.Do(conn, epochMS, qType, queue, tps, priority, value))
+	return PushOntoQueueAt(conn, qType, queue, tps, value, priority, time.Now())
+}
+
+// PushOntoQueueAt pushes the passed in value to the passed in queue like PushOntoQueue, but the value won't be
+// popped off until the passed in time
+func PushOntoQueueAt(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, at time.Time) error {
+	key := queueKey(qType, fmt.Sprintf("%s|%d", queue, tps))
+
+	_, err := redis.Int(scriptPush.Do(conn, fmt.Sprintf("%s/%d", key, priority), tpsKey(key, at), shardPrefix(qType, queue)+":active", epochMS(at), tps, value, key))
 	return err
 }
 
 //go:embed lua/pop.lua
 var luaPop string
-var scriptPop = redis.NewScript(2, luaPop)
+var scriptPop = redis.NewScript(4, luaPop)
 
 // PopFromQueue pops the next available message from the passed in queue. If QueueRetry
 // is returned the caller should immediately make another call to get the next value. A
 // worker token of EmptyQueue will be returned if there are no more items to retrive.
 // Otherwise the WorkerToken should be saved in order to mark the task as complete later.
 func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	values, err := redis.Strings(scriptPop.Do(conn, epochMS, qType))
+	// in a cluster each shard is popped from by its own script, starting from a random one so that no shard is favored
+	prefixes := shardPrefixes(qType)
+	first := rand.IntN(len(prefixes))
+
+	for i := range prefixes {
+		prefix := prefixes[(first+i)%len(prefixes)]
+
+		now := time.Now()
+		values, err := redis.Strings(scriptPop.Do(conn, prefix+":active", prefix+":throttled", prefix+":future", prefix+":paused", epochMS(now), prefix+":", rateLimitPrefix(prefix)))
+		if err != nil {
+			slog.Error("error popping from queue", "error", err)
+			return "", "", err
+		}
+		if values[0] != string(EmptyQueue) {
+			return WorkerToken(values[0]), values[1], nil
+		}
+	}
+	return EmptyQueue, "", nil
+}
+
+//go:embed lua/pop_more.lua
+var luaPopMore string
+var scriptPopMore = redis.NewScript(6, luaPopMore)
+
+// PopMoreFromQueue pops up to max more available values from the queue identified by the passed in worker
+// token, which should be one returned by PopFromQueue. These values share that worker's slot so there's no
+// need to mark them as complete separately. Fewer values may be returned if the queue is throttled.
+func PopMoreFromQueue(conn redis.Conn, qType string, token WorkerToken, max int) ([]string, error) {
+	key := string(token)
+	name, tps := parseQueueKey(qType, key)
+
+	now := time.Now()
+	values, err := redis.Strings(scriptPopMore.Do(conn, key+"/1", key+"/0", tpsKey(key, now), RateLimitKey(qType, name), BulkRateLimitKey(qType, name), shardPrefix(qType, name)+":paused", epochMS(now), tps, max, name))
 	if err != nil {
-		slog.Error("error popping from queue", "error", err)
-		return "", "", err
+		slog.Error("error popping more from queue", "error", err, "token", token)
+		return nil, err
 	}
-	return WorkerToken(values[0]), values[1], nil
+	return values, nil
 }
 
 //go:embed lua/complete.lua
@@ -72,20 +185,181 @@ var scriptComplete = redis.NewScript(2, luaComplete)
 // important for callers to call this so that workers are evenly spread across all
 // queues with jobs in them
 func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
-	_, err := scriptComplete.Do(conn, qType, token)
+	name, _ := parseQueueKey(qType, string(token))
+	prefix := shardPrefix(qType, name)
+
+	_, err := scriptComplete.Do(conn, prefix+":throttled", prefix+":active", token)
 	if err != nil {
 		slog.Error("error marking job complete from queue", "error", err, "token", token)
 	}
 	return err
 }
 
+//go:embed lua/refund.lua
+var luaRefund string
+var scriptRefund = redis.NewScript(1, luaRefund)
+
+// Refund gives back the tps used by popping a value from the queue identified by the passed in worker token, e.g.
+// because the value was pushed back onto the queue to be processed later, so it doesn't count against the rate limit
+func Refund(conn redis.Conn, qType string, token WorkerToken) error {
+	_, err := scriptRefund.Do(conn, tpsKey(string(token), time.Now()))
+	return err
+}
+
+// Queues returns the names of all queues of the passed in type which are active, throttled or only contain future
+// items, e.g. uuid|tps, along with their current number of workers
+func Queues(conn redis.Conn, qType string) (map[string]int, error) {
+	queues := make(map[string]int)
+
+	for _, prefix := range shardPrefixes(qType) {
+		for _, set := range []string{"active", "throttled", "future"} {
+			values, err := redis.IntMap(conn.Do("ZRANGE", prefix+":"+set, 0, -1, "WITHSCORES"))
+			if err != nil {
+				return nil, err
+			}
+			for key, workers := range values {
+				name, tps := parseQueueKey(qType, key)
+				queues[fmt.Sprintf("%s|%d", name, tps)] += workers
+			}
+		}
+	}
+	return queues, nil
+}
+
+// Size returns the number of values in the high and low priority parts of the passed in queue
+func Size(conn redis.Conn, qType string, queue string) (int, int, error) {
+	key := queueKey(qType, queue)
+
+	conn.Send("ZCARD", fmt.Sprintf("%s/%d", key, HighPriority))
+	conn.Send("ZCARD", fmt.Sprintf("%s/%d", key, LowPriority))
+	conn.Flush()
+
+	high, err := redis.Int(conn.Receive())
+	if err != nil {
+		return 0, 0, err
+	}
+	low, err := redis.Int(conn.Receive())
+	if err != nil {
+		return 0, 0, err
+	}
+	return high, low, nil
+}
+
+// Peek returns up to max values from the passed in queue without popping them, high priority values first
+func Peek(conn redis.Conn, qType string, queue string, max int) ([]string, error) {
+	values := make([]string, 0, max)
+
+	for _, priority := range []Priority{HighPriority, LowPriority} {
+		if len(values) >= max {
+			break
+		}
+
+		vs, err := redis.Strings(conn.Do("ZRANGE", fmt.Sprintf("%s/%d", queueKey(qType, queue), priority), 0, max-len(values)-1))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vs...)
+	}
+	return values, nil
+}
+
+// Purge removes all values from the passed in queue, returning how many were removed
+func Purge(conn redis.Conn, qType string, queue string) (int, error) {
+	high, low, err := Size(conn, qType, queue)
+	if err != nil {
+		return 0, err
+	}
+
+	key := queueKey(qType, queue)
+	_, err = conn.Do("DEL", fmt.Sprintf("%s/%d", key, HighPriority), fmt.Sprintf("%s/%d", key, LowPriority))
+	if err != nil {
+		return 0, err
+	}
+	return high + low, nil
+}
+
+// Item is a value in a queue along with the time from which it can be popped
+type Item struct {
+	Value string
+	At    time.Time
+}
+
+// Take removes and returns all values from the passed in queue with the given priority, in the order they'd be popped
+func Take(conn redis.Conn, qType string, queue string, priority Priority) ([]Item, error) {
+	key := fmt.Sprintf("%s/%d", queueKey(qType, queue), priority)
+
+	conn.Send("MULTI")
+	conn.Send("ZRANGE", key, 0, -1, "WITHSCORES")
+	conn.Send("DEL", key)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := redis.Strings(replies[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score for queue value: %w", err)
+		}
+		items = append(items, Item{Value: values[i], At: time.UnixMicro(int64(score * 1000000))})
+	}
+	return items, nil
+}
+
+// Pause stops any values being popped from queues with the passed in name, e.g. a channel UUID, regardless of their TPS
+func Pause(conn redis.Conn, qType string, name string) error {
+	_, err := conn.Do("SADD", shardPrefix(qType, name)+":paused", name)
+	return err
+}
+
+// Resume allows values to be popped again from queues with the passed in name
+func Resume(conn redis.Conn, qType string, name string) error {
+	_, err := conn.Do("SREM", shardPrefix(qType, name)+":paused", name)
+	return err
+}
+
+// Paused returns the names of all paused queues
+func Paused(conn redis.Conn, qType string) ([]string, error) {
+	paused := make([]string, 0)
+
+	for _, prefix := range shardPrefixes(qType) {
+		names, err := redis.Strings(conn.Do("SMEMBERS", prefix+":paused"))
+		if err != nil {
+			return nil, err
+		}
+		paused = append(paused, names...)
+	}
+	return paused, nil
+}
+
+//go:embed lua/pace.lua
+var luaPace string
+var scriptPace = redis.NewScript(1, luaPace)
+
+// Pace reserves the next slot for an item which is one of a group of items, identified by the passed in key, that
+// should be spread out by the passed in interval. The returned time will be now if the group isn't currently ahead
+// of its pace, otherwise it's the time at which the item can be processed.
+func Pace(conn redis.Conn, key string, interval time.Duration, ttl time.Duration) (time.Time, error) {
+	slot, err := redis.Float64(scriptPace.Do(conn, key, epochMS(time.Now()), interval.Seconds(), int(ttl/time.Second)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(int64(slot * 1000000)), nil
+}
+
 //go:embed lua/dethrottle.lua
 var luaDethrottle string
-var scriptDethrottle = redis.NewScript(1, luaDethrottle)
+var scriptDethrottle = redis.NewScript(3, luaDethrottle)
 
 // StartDethrottler starts a goroutine responsible for dethrottling any queues that were
 // throttled every second. The passed in quitter chan can be used to shut down the goroutine
-func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
+func StartDethrottler(rp valkey.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
 	wg.Add(1)
 
 	go func() {
@@ -99,10 +373,12 @@ func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup,
 				return
 
 			case <-time.After(delay):
-				rc := redis.Get()
-				_, err := scriptDethrottle.Do(rc, qType)
-				if err != nil {
-					slog.Error("error dethrottling", "error", err)
+				rc := rp.Get()
+				for _, prefix := range shardPrefixes(qType) {
+					_, err := scriptDethrottle.Do(rc, prefix+":throttled", prefix+":future", prefix+":active")
+					if err != nil {
+						slog.Error("error dethrottling", "error", err)
+					}
 				}
 				rc.Close()
 
@@ -111,3 +387,8 @@ func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup,
 		}
 	}()
 }
+
+// formats the passed in time as the decimal seconds since epoch used for scores by our scripts
+func epochMS(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+}
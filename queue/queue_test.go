@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/redisx/assertredis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -288,6 +289,246 @@ func TestThrottle(t *testing.T) {
 	wg.Wait()
 }
 
+func TestPopMore(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	// add 3 bulk messages as a batch, and 2 high priority messages individually
+	err := PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":1},{"id":2},{"id":3}]`, LowPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":4}]`, HighPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":5}]`, HighPriority)
+	require.NoError(t, err)
+
+	// and a message for another channel
+	err = PushOntoQueue(rc, "msgs", "chan2", 0, `[{"id":6}]`, HighPriority)
+	require.NoError(t, err)
+
+	token, value, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|5"), token)
+	assert.Equal(t, `{"id":4}`, value)
+
+	// pop 2 more from the same queue, should get remaining high priority first
+	values, err := PopMoreFromQueue(rc, "msgs", token, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":5}`, `{"id":1}`}, values)
+
+	// our tps limit of 5 means we can only pop 2 more this second
+	values, err = PopMoreFromQueue(rc, "msgs", token, 10)
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+
+	// only one active worker on our queue
+	assertredis.ZScore(t, rc, "msgs:active", "msgs:chan1|5", 1)
+
+	assert.NoError(t, MarkComplete(rc, "msgs", token))
+
+	// if queue is rate limited, we can't pop any more
+	rc.Do("SET", "rate_limit:chan2", "engaged")
+
+	values, err = PopMoreFromQueue(rc, "msgs", WorkerToken("msgs:chan2|0"), 5)
+	assert.NoError(t, err)
+	assert.Len(t, values, 0)
+}
+
+func TestPushAt(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	// push one message to be popped in the future and one to be popped now
+	err := PushOntoQueueAt(rc, "msgs", "chan1", 0, `[{"id":1}]`, LowPriority, time.Now().Add(2*time.Second))
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":2}]`, LowPriority)
+	require.NoError(t, err)
+
+	token, value, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":2}`, value)
+
+	// our remaining message isn't ready yet so queue is moved to future set
+	token, _, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, Retry, token)
+	assertredis.ZScore(t, rc, "msgs:future", "msgs:chan1|0", 0)
+
+	// but will be popped once it is ready and the queue is dethrottled
+	time.Sleep(2 * time.Second)
+	_, err = scriptDethrottle.Do(rc, "msgs:throttled", "msgs:future", "msgs:active")
+	require.NoError(t, err)
+
+	token, value, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":1}`, value)
+}
+
+func TestRefund(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	for i := 1; i <= 3; i++ {
+		err := PushOntoQueue(rc, "msgs", "chan1", 2, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority)
+		require.NoError(t, err)
+	}
+
+	// pop our 2 per second
+	token, _, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|2"), token)
+
+	values, err := PopMoreFromQueue(rc, "msgs", token, 5)
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+
+	// refunding one lets us pop another this second
+	assert.NoError(t, Refund(rc, "msgs", token))
+
+	values, err = PopMoreFromQueue(rc, "msgs", token, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":3}`}, values)
+
+	// refunds never take the count below zero
+	for range 3 {
+		assert.NoError(t, Refund(rc, "msgs", token))
+	}
+	assertredis.Get(t, rc, fmt.Sprintf("msgs:chan1|2:tps:%d", time.Now().Unix()), "0")
+}
+
+func TestPace(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	start := time.Now()
+
+	// first slot is now
+	slot1, err := Pace(rc, "pacing:b1", 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, start, slot1, time.Second)
+
+	// subsequent slots are spaced by the interval
+	slot2, err := Pace(rc, "pacing:b1", 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, slot1.Add(10*time.Second), slot2, time.Millisecond)
+
+	slot3, err := Pace(rc, "pacing:b1", 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, slot1.Add(20*time.Second), slot3, time.Millisecond)
+
+	// other keys are paced independently
+	slot4, err := Pace(rc, "pacing:b2", 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, start, slot4, time.Second)
+
+	assertredis.Exists(t, rc, "pacing:b1")
+}
+
+func TestQueueAdmin(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	err := PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":1},{"id":2}]`, LowPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":3}]`, HighPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan2", 0, `[{"id":4}]`, HighPriority)
+	require.NoError(t, err)
+
+	queues, err := Queues(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"chan1|5": 0, "chan2|0": 0}, queues)
+
+	high, low, err := Size(rc, "msgs", "chan1|5")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, high)
+	assert.Equal(t, 1, low)
+
+	values, err := Peek(rc, "msgs", "chan1|5", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`[{"id":3}]`, `[{"id":1},{"id":2}]`}, values)
+
+	values, err = Peek(rc, "msgs", "chan1|5", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`[{"id":3}]`}, values)
+
+	// pause chan1 so we can only pop from chan2
+	assert.NoError(t, Pause(rc, "msgs", "chan1"))
+
+	paused, err := Paused(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chan1"}, paused)
+
+	// pops until we get something other than a retry
+	pop := func() (WorkerToken, string) {
+		for {
+			token, value, err := PopFromQueue(rc, "msgs")
+			require.NoError(t, err)
+			if token != Retry {
+				return token, value
+			}
+		}
+	}
+
+	token, value := pop()
+	assert.Equal(t, WorkerToken("msgs:chan2|0"), token)
+	assert.Equal(t, `{"id":4}`, value)
+	assert.NoError(t, MarkComplete(rc, "msgs", token))
+
+	token, _ = pop()
+	assert.Equal(t, EmptyQueue, token)
+
+	// nothing more can be popped from a paused queue either
+	values, err = PopMoreFromQueue(rc, "msgs", WorkerToken("msgs:chan1|5"), 5)
+	assert.NoError(t, err)
+	assert.Len(t, values, 0)
+
+	// resume chan1 and dethrottle it so it can be popped from again
+	assert.NoError(t, Resume(rc, "msgs", "chan1"))
+	_, err = scriptDethrottle.Do(rc, "msgs:throttled", "msgs:future", "msgs:active")
+	require.NoError(t, err)
+
+	token, value = pop()
+	assert.Equal(t, WorkerToken("msgs:chan1|5"), token)
+	assert.Equal(t, `{"id":3}`, value)
+	assert.NoError(t, MarkComplete(rc, "msgs", token))
+
+	// push a value for later so we can check that taking it preserves when it can be popped
+	later := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = PushOntoQueueAt(rc, "msgs", "chan1", 5, `[{"id":5}]`, LowPriority, later)
+	require.NoError(t, err)
+
+	items, err := Take(rc, "msgs", "chan1|5", LowPriority)
+	assert.NoError(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, `[{"id":1},{"id":2}]`, items[0].Value)
+		assert.Equal(t, `[{"id":5}]`, items[1].Value)
+		assert.True(t, later.Equal(items[1].At), "expected %s, got %s", later, items[1].At)
+	}
+
+	items, err = Take(rc, "msgs", "chan1|5", LowPriority)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+
+	err = PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":6}]`, LowPriority)
+	require.NoError(t, err)
+
+	purged, err := Purge(rc, "msgs", "chan1|5")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	high, low, err = Size(rc, "msgs", "chan1|5")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, high)
+	assert.Equal(t, 0, low)
+}
+
 func BenchmarkQueue(b *testing.B) {
 	assert := assert.New(b)
 	pool := getPool()
@@ -308,3 +549,74 @@ func BenchmarkQueue(b *testing.B) {
 		assert.NoError(err)
 	}
 }
+
+func TestClusterKeys(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	valkey.SetCluster(true)
+	defer valkey.SetCluster(false)
+
+	assert.Len(t, shardPrefixes("msgs"), 16)
+	assert.Equal(t, "{msgs:8}", shardPrefix("msgs", "chan1"))
+	assert.Equal(t, "{msgs:2}", shardPrefix("msgs", "chan2"))
+	assert.Equal(t, "{msgs:8}:chan1|5", queueKey("msgs", "chan1|5"))
+	assert.Equal(t, "{msgs:8}:rate_limit:chan1", RateLimitKey("msgs", "chan1"))
+	assert.Equal(t, "{msgs:8}:rate_limit_bulk:chan1", BulkRateLimitKey("msgs", "chan1"))
+
+	name, tps := parseQueueKey("msgs", "{msgs:8}:chan1|5")
+	assert.Equal(t, "chan1", name)
+	assert.Equal(t, 5, tps)
+
+	err := PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":1}]`, HighPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":2}]`, HighPriority)
+	require.NoError(t, err)
+	err = PushOntoQueue(rc, "msgs", "chan2", 0, `[{"id":3}]`, LowPriority)
+	require.NoError(t, err)
+
+	// each queue is in the active set of its own shard
+	assertredis.ZCard(t, rc, "{msgs:8}:chan1|5/1", 2)
+	assertredis.ZCard(t, rc, "{msgs:2}:chan2|0/0", 1)
+	assertredis.ZGetAll(t, rc, "{msgs:8}:active", map[string]float64{"{msgs:8}:chan1|5": 0})
+	assertredis.ZGetAll(t, rc, "{msgs:2}:active", map[string]float64{"{msgs:2}:chan2|0": 0})
+
+	queues, err := Queues(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"chan1|5": 0, "chan2|0": 0}, queues)
+
+	// pausing uses the paused set of the queue's shard
+	assert.NoError(t, Pause(rc, "msgs", "chan2"))
+	assertredis.SMembers(t, rc, "{msgs:2}:paused", []string{"chan2"})
+
+	paused, err := Paused(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chan2"}, paused)
+
+	// popping moves chan2 to the throttled set of its shard and eventually finds chan1 in its shard
+	var token WorkerToken
+	var value string
+	for token == "" || token == Retry {
+		token, value, err = PopFromQueue(rc, "msgs")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, WorkerToken("{msgs:8}:chan1|5"), token)
+	assert.Equal(t, `{"id":1}`, value)
+	assertredis.Get(t, rc, fmt.Sprintf("{msgs:8}:chan1|5:tps:%d", time.Now().Unix()), "1")
+	assertredis.ZScore(t, rc, "{msgs:8}:active", "{msgs:8}:chan1|5", 1)
+
+	values, err := PopMoreFromQueue(rc, "msgs", token, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":2}`}, values)
+
+	assert.NoError(t, MarkComplete(rc, "msgs", token))
+	assertredis.ZScore(t, rc, "{msgs:8}:active", "{msgs:8}:chan1|5", 0)
+
+	for token != EmptyQueue {
+		token, _, err = PopFromQueue(rc, "msgs")
+		require.NoError(t, err)
+	}
+	assertredis.ZGetAll(t, rc, "{msgs:2}:throttled", map[string]float64{"{msgs:2}:chan2|0": 0})
+	assertredis.ZGetAll(t, rc, "{msgs:8}:active", map[string]float64{})
+}
@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// SchemaVersion is the version of the schema of queued messages that we understand. Payloads without a version are
+// from before versioning was added and are treated as version 1.
+const SchemaVersion = 1
+
+// SchemaVersionKey is the key of the schema version in queued payloads
+const SchemaVersionKey = "schema_version"
+
+// Payload is what we know about a queued payload besides the fields that were decoded into a struct
+type Payload struct {
+	// Version is the schema version of the payload
+	Version int
+
+	// Extra holds the fields that we don't understand, so that they aren't lost if the payload is queued again
+	Extra map[string]json.RawMessage
+}
+
+// Decode decodes the given queued payload into the struct pointed to by v. Fields which the struct doesn't have are
+// kept in the returned payload's extra fields rather than being dropped. If the payload is from a newer version of the
+// schema, fields whose type has changed so that they can't be decoded are treated the same way, unless they're one
+// of the given required fields.
+func Decode(data []byte, v any, required ...string) (*Payload, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	p := &Payload{Version: 1, Extra: make(map[string]json.RawMessage)}
+
+	if raw, found := fields[SchemaVersionKey]; found {
+		if err := json.Unmarshal(raw, &p.Version); err != nil {
+			return nil, fmt.Errorf("invalid schema version: %s", raw)
+		}
+		delete(fields, SchemaVersionKey)
+	}
+
+	t := reflect.TypeOf(v).Elem()
+	known := knownFields(t)
+
+	for name, raw := range fields {
+		if !known[name] {
+			p.Extra[name] = raw
+			delete(fields, name)
+		}
+	}
+
+	err := json.Unmarshal(jsonx.MustMarshal(fields), v)
+	if err == nil || p.Version <= SchemaVersion {
+		return p, err
+	}
+
+	// payload is from a newer version so try decoding each field on its own to find those which have changed
+	for name, raw := range fields {
+		probe := reflect.New(t).Interface()
+		if fErr := json.Unmarshal(jsonx.MustMarshal(map[string]json.RawMessage{name: raw}), probe); fErr != nil {
+			if slices.Contains(required, name) {
+				return nil, fmt.Errorf("unable to decode required field '%s' of schema version %d: %w", name, p.Version, fErr)
+			}
+			p.Extra[name] = raw
+			delete(fields, name)
+		}
+	}
+
+	reflect.ValueOf(v).Elem().SetZero()
+
+	return p, json.Unmarshal(jsonx.MustMarshal(fields), v)
+}
+
+// Encode encodes the given struct as a queued payload, adding back any extra fields from when it was decoded. The
+// payload is given our schema version, or the version it was decoded from if that is newer.
+func Encode(v any, p *Payload) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	version := SchemaVersion
+	if p != nil {
+		version = max(p.Version, SchemaVersion)
+
+		// extra fields include those we couldn't decode, so their original values replace our zero values
+		maps.Copy(fields, p.Extra)
+	}
+	fields[SchemaVersionKey] = jsonx.MustMarshal(version)
+
+	return json.Marshal(fields)
+}
+
+var knownFieldsCache sync.Map
+
+// gets the JSON names of the fields of the given struct type, following the same rules as encoding/json
+func knownFields(t reflect.Type) map[string]bool {
+	if cached, ok := knownFieldsCache.Load(t); ok {
+		return cached.(map[string]bool)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		known[name] = true
+	}
+
+	knownFieldsCache.Store(t, known)
+	return known
+}
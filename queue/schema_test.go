@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the message schema as we understand it, i.e. version N
+type testMsgV1 struct {
+	ID       int    `json:"id"`
+	URN      string `json:"urn"`
+	Text     string `json:"text"`
+	Attempts int    `json:"attempts"`
+}
+
+// the message schema of the next version, N+1, which adds quick replies and changes attempts to an object
+type testMsgV2 struct {
+	ID           int      `json:"id"`
+	URN          string   `json:"urn"`
+	Text         string   `json:"text"`
+	QuickReplies []string `json:"quick_replies"`
+	Attempts     struct {
+		Count int `json:"count"`
+	} `json:"attempts"`
+}
+
+func TestSchemaCompatibility(t *testing.T) {
+	tcs := []struct {
+		payload string
+		decoded testMsgV1
+		version int
+		extra   map[string]json.RawMessage
+		err     string
+		encoded string
+	}{
+		{ // N payload from before versioning
+			payload: `{"id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2}`,
+			decoded: testMsgV1{ID: 1, URN: "tel:+250788383383", Text: "hi", Attempts: 2},
+			version: 1,
+			extra:   map[string]json.RawMessage{},
+			encoded: `{"id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2, "schema_version": 1}`,
+		},
+		{ // N payload
+			payload: `{"schema_version": 1, "id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2}`,
+			decoded: testMsgV1{ID: 1, URN: "tel:+250788383383", Text: "hi", Attempts: 2},
+			version: 1,
+			extra:   map[string]json.RawMessage{},
+			encoded: `{"id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2, "schema_version": 1}`,
+		},
+		{ // N payload with a field we don't know about
+			payload: `{"schema_version": 1, "id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2, "topic": "event"}`,
+			decoded: testMsgV1{ID: 1, URN: "tel:+250788383383", Text: "hi", Attempts: 2},
+			version: 1,
+			extra:   map[string]json.RawMessage{"topic": json.RawMessage(`"event"`)},
+			encoded: `{"id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": 2, "topic": "event", "schema_version": 1}`,
+		},
+		{ // N payload with a field of the wrong type is still an error
+			payload: `{"schema_version": 1, "id": 1, "urn": "tel:+250788383383", "text": "hi", "attempts": {"count": 2}}`,
+			version: 1,
+			err:     "json: cannot unmarshal object into Go struct field testMsgV1.attempts of type int",
+		},
+		{ // N+1 payload has new fields and a field whose type has changed, which are kept but not decoded
+			payload: `{"schema_version": 2, "id": 1, "urn": "tel:+250788383383", "text": "hi", "quick_replies": ["yes"], "attempts": {"count": 2}}`,
+			decoded: testMsgV1{ID: 1, URN: "tel:+250788383383", Text: "hi"},
+			version: 2,
+			extra:   map[string]json.RawMessage{"quick_replies": json.RawMessage(`["yes"]`), "attempts": json.RawMessage(`{"count": 2}`)},
+			encoded: `{"id": 1, "urn": "tel:+250788383383", "text": "hi", "quick_replies": ["yes"], "attempts": {"count": 2}, "schema_version": 2}`,
+		},
+		{ // unless that field is required
+			payload: `{"schema_version": 2, "id": "1", "urn": "tel:+250788383383", "text": "hi", "attempts": 2}`,
+			err:     "unable to decode required field 'id' of schema version 2: json: cannot unmarshal string into Go struct field testMsgV1.id of type int",
+		},
+		{
+			payload: `{"schema_version": "2", "id": 1}`,
+			err:     `invalid schema version: "2"`,
+		},
+	}
+
+	for _, tc := range tcs {
+		msg := &testMsgV1{}
+		p, err := Decode([]byte(tc.payload), msg, "id", "urn")
+
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "error mismatch for payload %s", tc.payload)
+			continue
+		}
+
+		if assert.NoError(t, err, "unexpected error for payload %s", tc.payload) {
+			assert.Equal(t, tc.decoded, *msg, "decoded mismatch for payload %s", tc.payload)
+			assert.Equal(t, tc.version, p.Version, "version mismatch for payload %s", tc.payload)
+			assert.Equal(t, tc.extra, p.Extra, "extra mismatch for payload %s", tc.payload)
+
+			encoded, err := Encode(msg, p)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.encoded, string(encoded), "encoded mismatch for payload %s", tc.payload)
+		}
+	}
+
+	// N+1 can decode N payloads which don't have its new fields
+	msg := &testMsgV2{}
+	p, err := Decode([]byte(`{"schema_version": 1, "id": 1, "urn": "tel:+250788383383", "text": "hi"}`), msg, "id", "urn")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.Version)
+	assert.Equal(t, "hi", msg.Text)
+	assert.Nil(t, msg.QuickReplies)
+
+	// and payloads we create have our version
+	encoded, err := Encode(&testMsgV1{ID: 2, URN: "tel:+250788383383", Text: "bye"}, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": 2, "urn": "tel:+250788383383", "text": "bye", "attempts": 0, "schema_version": 1}`, string(encoded))
+}
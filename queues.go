@@ -0,0 +1,203 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+const (
+	defaultPeekLimit = 10
+	maxPeekLimit     = 100
+)
+
+// QueueInfo is the state of the outgoing message queue of a channel
+type QueueInfo struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	TPS         int         `json:"tps"`
+	Size        int         `json:"size"`      // number of queued high priority items
+	BulkSize    int         `json:"bulk_size"` // number of queued bulk items
+	Workers     int         `json:"workers"`
+	Paused      bool        `json:"paused"`
+}
+
+type queuesResponse struct {
+	Queues []*QueueInfo `json:"queues"`
+}
+
+type queueResponse struct {
+	Queue   *QueueInfo        `json:"queue"`
+	Pending []json.RawMessage `json:"pending"`
+}
+
+type purgeQueueResponse struct {
+	Purged int `json:"purged"`
+}
+
+type pauseQueueResponse struct {
+	Paused bool `json:"paused"`
+}
+
+type drainQueueResponse struct {
+	Drained int `json:"drained"`
+}
+
+// gets the channel UUID from the path of an admin request
+func adminChannelUUID(r *http.Request) (ChannelUUID, error) {
+	uuid := chi.URLParam(r, "uuid")
+	if !uuids.Is(uuid) {
+		return NilChannelUUID, errors.New("invalid channel UUID")
+	}
+	return ChannelUUID(uuid), nil
+}
+
+func (s *server) handleListQueues(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	queues, err := s.queues.Queues(ctx)
+	if err != nil {
+		slog.Error("error listing queues", "error", err)
+		WriteError(w, http.StatusInternalServerError, errors.New("error listing queues"))
+		return
+	}
+
+	writeAdminResponse(w, &queuesResponse{Queues: queues})
+}
+
+func (s *server) handleGetQueue(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := defaultPeekLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, maxPeekLimit)
+	}
+
+	queues, err := s.queues.Queues(ctx)
+	if err != nil {
+		slog.Error("error listing queues", "error", err)
+		WriteError(w, http.StatusInternalServerError, errors.New("error reading queue"))
+		return
+	}
+
+	// channels without pending messages which haven't been paused won't have a queue
+	queue := &QueueInfo{ChannelUUID: channelUUID}
+	for _, q := range queues {
+		if q.ChannelUUID == channelUUID {
+			queue = q
+			break
+		}
+	}
+
+	pending, err := s.queues.PeekQueue(ctx, channelUUID, limit)
+	if err != nil {
+		slog.Error("error peeking queue", "error", err, "channel_uuid", channelUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error reading queue"))
+		return
+	}
+
+	writeAdminResponse(w, &queueResponse{Queue: queue, Pending: pending})
+}
+
+func (s *server) handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	purged, err := s.queues.PurgeQueue(ctx, channelUUID)
+	if err != nil {
+		slog.Error("error purging queue", "error", err, "channel_uuid", channelUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error purging queue"))
+		return
+	}
+
+	slog.Info("purged queue", "channel_uuid", channelUUID, "count", purged)
+
+	writeAdminResponse(w, &purgeQueueResponse{Purged: purged})
+}
+
+// returns a handler which pauses or resumes the queue of a channel
+func (s *server) handlePauseQueue(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+		defer cancel()
+
+		channelUUID, err := adminChannelUUID(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.queues.PauseQueue(ctx, channelUUID, pause); err != nil {
+			slog.Error("error pausing queue", "error", err, "channel_uuid", channelUUID, "pause", pause)
+			WriteError(w, http.StatusInternalServerError, errors.New("error pausing queue"))
+			return
+		}
+
+		slog.Info("paused queue", "channel_uuid", channelUUID, "pause", pause)
+
+		writeAdminResponse(w, &pauseQueueResponse{Paused: pause})
+	}
+}
+
+// drains the queue of a channel onto the queue of the channel given by the to param, e.g. when migrating providers
+func (s *server) handleDrainQueue(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	to := r.URL.Query().Get("to")
+	if !uuids.Is(to) {
+		WriteError(w, http.StatusBadRequest, errors.New("invalid target channel UUID"))
+		return
+	}
+	if ChannelUUID(to) == channelUUID {
+		WriteError(w, http.StatusBadRequest, errors.New("can't drain a queue into itself"))
+		return
+	}
+
+	drained, err := s.queues.DrainQueue(ctx, channelUUID, ChannelUUID(to))
+	if errors.Is(err, ErrChannelNotFound) {
+		WriteError(w, http.StatusBadRequest, errors.New("target channel not found"))
+		return
+	} else if err != nil {
+		slog.Error("error draining queue", "error", err, "channel_uuid", channelUUID, "to", to)
+		WriteError(w, http.StatusInternalServerError, errors.New("error draining queue"))
+		return
+	}
+
+	slog.Info("drained queue", "channel_uuid", channelUUID, "to", to, "count", drained)
+
+	writeAdminResponse(w, &drainQueueResponse{Drained: drained})
+}
+
+func writeAdminResponse(w http.ResponseWriter, resp any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonx.MustMarshal(resp))
+}
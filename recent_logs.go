@@ -0,0 +1,131 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/httpx"
+)
+
+const (
+	defaultRecentLogsLimit = 50
+	maxRecentLogsLimit     = 500
+)
+
+// RecentChannelLog is a channel log as kept in the buffer of recent logs of its channel. Like all channel logs, values
+// the handler considers secret have already been redacted.
+type RecentChannelLog struct {
+	UUID      clogs.LogUUID     `json:"uuid"`
+	Type      clogs.LogType     `json:"type"`
+	HttpLogs  []*httpx.Log      `json:"http_logs"`
+	Errors    []*clogs.LogError `json:"errors"`
+	ElapsedMS int               `json:"elapsed_ms"`
+	CreatedOn time.Time         `json:"created_on"`
+}
+
+// NewRecentChannelLog creates a new recent channel log from the given channel log
+func NewRecentChannelLog(clog *ChannelLog) *RecentChannelLog {
+	return &RecentChannelLog{
+		UUID:      clog.UUID,
+		Type:      clog.Type,
+		HttpLogs:  clog.HttpLogs,
+		Errors:    clog.Errors,
+		ElapsedMS: int(clog.Elapsed / time.Millisecond),
+		CreatedOn: clog.CreatedOn,
+	}
+}
+
+// ChannelLogQuery filters the recent logs of a channel
+type ChannelLogQuery struct {
+	Type       clogs.LogType
+	ErrorsOnly bool
+	After      time.Time
+	Before     time.Time
+	Limit      int
+}
+
+// Matches returns whether the given log matches this query
+func (q *ChannelLogQuery) Matches(l *RecentChannelLog) bool {
+	if q.Type != "" && l.Type != q.Type {
+		return false
+	}
+	if q.ErrorsOnly && len(l.Errors) == 0 {
+		return false
+	}
+	if !q.After.IsZero() && !l.CreatedOn.After(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !l.CreatedOn.Before(q.Before) {
+		return false
+	}
+	return true
+}
+
+type recentLogsResponse struct {
+	Logs []*RecentChannelLog `json:"logs"`
+}
+
+// parses a channel log query from the query string of the given request
+func readChannelLogQuery(r *http.Request) (*ChannelLogQuery, error) {
+	params := r.URL.Query()
+	query := &ChannelLogQuery{Type: clogs.LogType(params.Get("type")), Limit: defaultRecentLogsLimit}
+
+	if v := params.Get("errors"); v != "" {
+		errorsOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid errors value: %s", v)
+		}
+		query.ErrorsOnly = errorsOnly
+	}
+
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"after", &query.After}, {"before", &query.Before}} {
+		if v := params.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value, must be RFC3339: %s", p.name, v)
+			}
+			*p.dest = t
+		}
+	}
+
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		query.Limit = min(l, maxRecentLogsLimit)
+	}
+
+	return query, nil
+}
+
+func (s *server) handleListRecentLogs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	channelUUID, err := adminChannelUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query, err := readChannelLogQuery(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logs, err := s.logSearcher.RecentChannelLogs(ctx, channelUUID, query)
+	if err != nil {
+		slog.Error("error listing recent channel logs", "error", err, "channel_uuid", channelUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error listing channel logs"))
+		return
+	}
+
+	writeAdminResponse(w, &recentLogsResponse{Logs: logs})
+}
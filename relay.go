@@ -0,0 +1,159 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// RelaySignatureHeader is the header containing the hex encoded HMAC-SHA256 of the body of relayed events
+const RelaySignatureHeader = "X-Courier-Signature"
+
+// how long we wait before each retry of a failed relay request
+var relayBackoffs = []time.Duration{time.Second, time.Second * 5, time.Second * 30}
+
+// the types of events we relay
+const (
+	relayTypeMsgIn  = "msg_in"
+	relayTypeStatus = "status"
+)
+
+// relayEvent is what we POST to the relay URL, which has one of msg or status depending on its type
+type relayEvent struct {
+	Type        string       `json:"type"`
+	ChannelUUID ChannelUUID  `json:"channel_uuid"`
+	ChannelType ChannelType  `json:"channel_type"`
+	Msg         *relayMsg    `json:"msg,omitempty"`
+	Status      *relayStatus `json:"status,omitempty"`
+	CreatedOn   time.Time    `json:"created_on"`
+}
+
+type relayMsg struct {
+	UUID        MsgUUID    `json:"uuid"`
+	URN         urns.URN   `json:"urn"`
+	Text        string     `json:"text"`
+	Attachments []string   `json:"attachments,omitempty"`
+	ExternalID  string     `json:"external_id,omitempty"`
+	ReceivedOn  *time.Time `json:"received_on,omitempty"`
+}
+
+type relayStatus struct {
+	MsgID        MsgID           `json:"msg_id,omitempty"`
+	ExternalID   string          `json:"external_id,omitempty"`
+	Status       MsgStatus       `json:"status"`
+	FailedReason MsgFailedReason `json:"failed_reason,omitempty"`
+}
+
+// relays the incoming messages and status updates in the given events to the relay URL of the channel, or the global
+// relay URL if the channel doesn't have one, without blocking
+func (s *server) relayEvents(channel Channel, events []Event) {
+	relayURL, secret := s.config.RelayURL, s.config.RelaySecret
+	if channelURL := channel.StringConfigForKey(ConfigRelayURL, ""); channelURL != "" {
+		relayURL, secret = channelURL, channel.StringConfigForKey(ConfigRelaySecret, "")
+	}
+	if relayURL == "" {
+		return
+	}
+
+	for _, event := range events {
+		relayed := &relayEvent{ChannelUUID: channel.UUID(), ChannelType: channel.ChannelType(), CreatedOn: dates.Now()}
+
+		switch e := event.(type) {
+		case MsgIn:
+			relayed.Type = relayTypeMsgIn
+			relayed.Msg = &relayMsg{
+				UUID:        e.UUID(),
+				URN:         e.URN(),
+				Text:        e.Text(),
+				Attachments: e.Attachments(),
+				ExternalID:  e.ExternalID(),
+				ReceivedOn:  e.ReceivedOn(),
+			}
+		case StatusUpdate:
+			relayed.Type = relayTypeStatus
+			relayed.Status = &relayStatus{
+				MsgID:        e.MsgID(),
+				ExternalID:   e.ExternalID(),
+				Status:       e.Status(),
+				FailedReason: e.FailedReason(),
+			}
+		default:
+			continue
+		}
+
+		s.relayEvent(channel, relayURL, secret, jsonx.MustMarshal(relayed))
+	}
+}
+
+// POSTs the given body to the given relay URL, retrying in the background until it succeeds or we run out of retries
+func (s *server) relayEvent(channel Channel, relayURL, secret string, body []byte) {
+	log := slog.With("comp", "relay", "channel_uuid", channel.UUID(), "url", relayURL)
+
+	s.waitGroup.Add(1)
+
+	go func() {
+		defer s.waitGroup.Done()
+
+		for retry := 0; ; retry++ {
+			retryable, err := s.postRelayEvent(relayURL, secret, body)
+			if err == nil {
+				return
+			}
+			if !retryable || retry >= len(relayBackoffs) {
+				log.Error("error relaying event", "error", err, "retries", retry)
+				return
+			}
+
+			select {
+			case <-s.stopChan:
+				log.Error("error relaying event, stopped before retrying", "error", err, "retries", retry)
+				return
+			case <-time.After(relayBackoffs[retry]):
+			}
+		}
+	}()
+}
+
+// makes a single attempt at POSTing the given body to the relay URL, returning whether a failure can be retried
+func (s *server) postRelayEvent(relayURL, secret string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, relayURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Courier/"+s.config.Version)
+	if secret != "" {
+		req.Header.Set(RelaySignatureHeader, RelaySignature(secret, body))
+	}
+
+	trace, err := httpx.DoTrace(s.backend.HttpClient(true), req, nil, s.backend.HttpAccess(), 1024)
+	if err != nil {
+		return err != httpx.ErrAccessConfig, err
+	}
+	if trace.Response.StatusCode/100 != 2 {
+		retryable := trace.Response.StatusCode == http.StatusTooManyRequests || trace.Response.StatusCode/100 == 5
+		return retryable, fmt.Errorf("relay returned non-2XX response: %d", trace.Response.StatusCode)
+	}
+	return false, nil
+}
+
+// RelaySignature returns the signature of the given relayed body, which receivers can use to verify requests
+func RelaySignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
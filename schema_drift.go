@@ -0,0 +1,156 @@
+package courier
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
+)
+
+// unknown fields are forgotten once they haven't been seen in any webhook payload of their channel type for this long
+const schemaDriftTTL = 30 * 24 * time.Hour
+
+// SchemaDrift is a field seen in webhook payloads of a channel type which its handler doesn't parse, which usually
+// means that the provider has added or renamed a field
+type SchemaDrift struct {
+	ChannelType ChannelType `json:"channel_type"`
+	Field       string      `json:"field"` // path of the field, e.g. entry[].changes[].value.foo
+	Count       int         `json:"count"` // number of sampled payloads it's been seen in
+	FirstSeen   time.Time   `json:"first_seen"`
+	LastSeen    time.Time   `json:"last_seen"`
+}
+
+// each channel type has hashes of the first and last times, and counts, of its unknown fields by path
+func schemaDriftKey(typ ChannelType, part string) string {
+	return fmt.Sprintf("%s:%s:%s", valkey.Tag("schema-drift"), typ, part)
+}
+
+func schemaDriftTypesKey() string {
+	return valkey.Tag("schema-drift") + ":types"
+}
+
+// RecordSchemaDrift records that the given unknown fields were seen in a webhook payload of the given channel type,
+// returning those which haven't been seen before
+func RecordSchemaDrift(rc redis.Conn, typ ChannelType, fields []string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	ttl := int(schemaDriftTTL / time.Second)
+
+	rc.Send("MULTI")
+	rc.Send("SADD", schemaDriftTypesKey(), typ)
+	for _, f := range fields {
+		rc.Send("HSETNX", schemaDriftKey(typ, "first"), f, now)
+		rc.Send("HSET", schemaDriftKey(typ, "last"), f, now)
+		rc.Send("HINCRBY", schemaDriftKey(typ, "count"), f, 1)
+	}
+	for _, part := range []string{"first", "last", "count"} {
+		rc.Send("EXPIRE", schemaDriftKey(typ, part), ttl)
+	}
+	replies, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return nil, fmt.Errorf("error recording schema drift: %w", err)
+	}
+
+	added := make([]string, 0, len(fields))
+	for i, f := range fields {
+		if isNew, _ := redis.Bool(replies[1+i*3], nil); isNew {
+			added = append(added, f)
+		}
+	}
+	return added, nil
+}
+
+// ReadSchemaDrift reads the unknown fields which have been seen in webhook payloads, ordered by channel type and path
+func ReadSchemaDrift(rc redis.Conn) ([]*SchemaDrift, error) {
+	types, err := redis.Strings(rc.Do("SMEMBERS", schemaDriftTypesKey()))
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema drift types: %w", err)
+	}
+	slices.Sort(types)
+
+	drifts := make([]*SchemaDrift, 0, 10)
+
+	for _, t := range types {
+		typ := ChannelType(t)
+
+		first, err := redis.Int64Map(rc.Do("HGETALL", schemaDriftKey(typ, "first")))
+		if err != nil {
+			return nil, fmt.Errorf("error reading schema drift: %w", err)
+		}
+		last, err := redis.Int64Map(rc.Do("HGETALL", schemaDriftKey(typ, "last")))
+		if err != nil {
+			return nil, fmt.Errorf("error reading schema drift: %w", err)
+		}
+		counts, err := redis.IntMap(rc.Do("HGETALL", schemaDriftKey(typ, "count")))
+		if err != nil {
+			return nil, fmt.Errorf("error reading schema drift: %w", err)
+		}
+
+		fields := make([]string, 0, len(first))
+		for f := range first {
+			fields = append(fields, f)
+		}
+		slices.Sort(fields)
+
+		for _, f := range fields {
+			drifts = append(drifts, &SchemaDrift{
+				ChannelType: typ,
+				Field:       f,
+				Count:       counts[f],
+				FirstSeen:   time.UnixMilli(first[f]).UTC(),
+				LastSeen:    time.UnixMilli(last[f]).UTC(),
+			})
+		}
+	}
+	return drifts, nil
+}
+
+// ClearSchemaDrift forgets the unknown fields seen for the given channel type, e.g. once its handler has been updated
+func ClearSchemaDrift(rc redis.Conn, typ ChannelType) error {
+	rc.Send("MULTI")
+	rc.Send("SREM", schemaDriftTypesKey(), typ)
+	rc.Send("DEL", schemaDriftKey(typ, "first"), schemaDriftKey(typ, "last"), schemaDriftKey(typ, "count"))
+	if _, err := rc.Do("EXEC"); err != nil {
+		return fmt.Errorf("error clearing schema drift: %w", err)
+	}
+	return nil
+}
+
+type schemaDriftResponse struct {
+	Fields []*SchemaDrift `json:"fields"`
+}
+
+func (s *server) handleListSchemaDrift(w http.ResponseWriter, r *http.Request) {
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	drifts, err := ReadSchemaDrift(rc)
+	if err != nil {
+		slog.Error("error reading schema drift", "error", err)
+		WriteError(w, http.StatusInternalServerError, errors.New("error reading schema drift"))
+		return
+	}
+
+	writeAdminResponse(w, &schemaDriftResponse{Fields: drifts})
+}
+
+func (s *server) handleClearSchemaDrift(w http.ResponseWriter, r *http.Request) {
+	typ := ChannelType(chi.URLParam(r, "type"))
+
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	if err := ClearSchemaDrift(rc, typ); err != nil {
+		slog.Error("error clearing schema drift", "error", err, "channel_type", typ)
+		WriteError(w, http.StatusInternalServerError, errors.New("error clearing schema drift"))
+		return
+	}
+
+	writeAdminResponse(w, &schemaDriftResponse{Fields: []*SchemaDrift{}})
+}
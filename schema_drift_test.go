@@ -0,0 +1,65 @@
+package courier_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	added, err := courier.RecordSchemaDrift(rc, "WAC", []string{"entry[].foo", "object2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"entry[].foo", "object2"}, added)
+
+	// only fields which haven't been seen before are returned as added
+	added, err = courier.RecordSchemaDrift(rc, "WAC", []string{"entry[].foo", "entry[].bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"entry[].bar"}, added)
+
+	_, err = courier.RecordSchemaDrift(rc, "TG", []string{"message.story"})
+	assert.NoError(t, err)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(method, url, authToken string) (int, []byte) {
+		req, _ := http.NewRequest(method, url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	statusCode, _ := request("GET", "http://localhost:8081/admin/drift", "")
+	assert.Equal(t, 401, statusCode)
+
+	statusCode, respBody := request("GET", "http://localhost:8081/admin/drift", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, string(respBody), `{"channel_type":"TG","field":"message.story","count":1,`)
+	assert.Contains(t, string(respBody), `{"channel_type":"WAC","field":"entry[].bar","count":1,`)
+	assert.Contains(t, string(respBody), `{"channel_type":"WAC","field":"entry[].foo","count":2,`)
+	assert.Contains(t, string(respBody), `{"channel_type":"WAC","field":"object2","count":1,`)
+
+	statusCode, _ = request("DELETE", "http://localhost:8081/admin/drift/WAC", "sesame")
+	assert.Equal(t, 200, statusCode)
+
+	drifts, err := courier.ReadSchemaDrift(rc)
+	assert.NoError(t, err)
+	if assert.Len(t, drifts, 1) {
+		assert.Equal(t, courier.ChannelType("TG"), drifts[0].ChannelType)
+	}
+}
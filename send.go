@@ -0,0 +1,82 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// sendRequest is a message to queue for sending, e.g.
+//
+//	{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788123123", "text": "Hi there", "quick_replies": ["Yes", "No"]}
+type sendRequest struct {
+	ChannelUUID  ChannelUUID `json:"channel_uuid"  validate:"required,uuid"`
+	URN          urns.URN    `json:"urn"           validate:"required"`
+	Text         string      `json:"text"`
+	Attachments  []string    `json:"attachments"`
+	QuickReplies []string    `json:"quick_replies"`
+}
+
+type sendResponse struct {
+	ID   MsgID   `json:"id"`
+	UUID MsgUUID `json:"uuid"`
+}
+
+func (s *server) handleSend(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	request := &sendRequest{}
+	if err := json.Unmarshal(body, request); err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error unmarshalling request: %w", err))
+		return
+	}
+	if err := utils.Validate(request); err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, request.ChannelUUID)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, errors.New("no such channel"))
+		return
+	}
+
+	msg := &OutgoingMsg{
+		UUID:         MsgUUID(uuids.NewV4()),
+		URN:          request.URN,
+		Text:         request.Text,
+		Attachments:  request.Attachments,
+		QuickReplies: request.QuickReplies,
+	}
+	if err := msg.Validate(channel); err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := s.msgQueuer.QueueMsg(ctx, channel, msg)
+	if err != nil {
+		slog.Error("error queueing msg from send API", "error", err, "channel_uuid", channel.UUID())
+		WriteError(w, http.StatusInternalServerError, errors.New("error queueing message"))
+		return
+	}
+
+	slog.Info("queued msg from send API", "msg_id", id, "msg_uuid", msg.UUID, "channel_uuid", channel.UUID())
+
+	writeAdminResponse(w, &sendResponse{ID: id, UUID: msg.UUID})
+}
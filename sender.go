@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
 	"time"
 
 	"github.com/nyaruka/courier/utils/clogs"
@@ -14,6 +15,8 @@ import (
 type SendResult struct {
 	externalIDs []string
 	newURN      urns.URN
+	segments    int
+	err         error
 }
 
 func (r *SendResult) AddExternalID(id string) {
@@ -33,10 +36,30 @@ func (r *SendResult) GetNewURN() urns.URN {
 
 }
 
+// AddSegments adds to the number of SMS segments sent for this message, used by SMS handlers to report how many
+// segments each part they send takes up
+func (r *SendResult) AddSegments(n int) {
+	r.segments += n
+}
+
+func (r *SendResult) Segments() int {
+	return r.segments
+}
+
+// SetError sets an error for this message only, used by bulk senders when a single message in a batch fails
+func (r *SendResult) SetError(err error) {
+	r.err = err
+}
+
+func (r *SendResult) GetError() error {
+	return r.err
+}
+
 type SendError struct {
-	msg       string
-	retryable bool
-	loggable  bool
+	msg        string
+	retryable  bool
+	loggable   bool
+	retryAfter time.Duration
 
 	clogCode    string
 	clogMsg     string
@@ -128,6 +151,62 @@ var ErrContactStopped error = &SendError{
 	clogMsg:   "Contact has opted-out of messages from this channel.",
 }
 
+// ErrContactUnknown should be returned when channel tells us explicitly that the contact doesn't exist or can't be
+// reached at their URN, e.g. an invalid user ID. Unlike ErrContactStopped, the contact hasn't chosen to stop receiving
+// messages, so rather than stopping them, their URN is flagged as deactivated.
+var ErrContactUnknown error = &SendError{
+	msg:       "contact unknown",
+	retryable: false,
+	loggable:  false,
+	clogCode:  "contact_unknown",
+	clogMsg:   "Contact doesn't exist or can't be reached on this channel.",
+}
+
+// ErrInsufficientBalance should be returned when channel tells us the account has run out of balance or credit. The
+// channel's queue is paused so that queued messages are held until it is resumed after credit is restored.
+var ErrInsufficientBalance error = &SendError{
+	msg:       "insufficient balance",
+	retryable: true,
+	loggable:  false,
+	clogCode:  "insufficient_balance",
+	clogMsg:   "Account has insufficient balance or credit to send messages.",
+}
+
+// ErrChannelUnverified should be returned when the provider requires the channel's number to be verified before it can
+// send, e.g. toll-free verification. Like ErrInsufficientBalance, the channel's queue is paused so that queued messages
+// are held until it is resumed after verification.
+var ErrChannelUnverified error = &SendError{
+	msg:       "channel unverified",
+	retryable: true,
+	loggable:  false,
+	clogCode:  "channel_unverified",
+	clogMsg:   "Channel number must be verified by the provider before it can send messages.",
+}
+
+// ErrCampaignMissing should be returned when the channel's number is a 10DLC number but it doesn't have the campaign
+// that carriers require on its messages configured, so sending would only be filtered by the carrier
+var ErrCampaignMissing error = &SendError{
+	msg:       "channel campaign missing",
+	retryable: false,
+	loggable:  true,
+	clogCode:  "campaign_missing",
+	clogMsg:   "Channel number is a 10DLC number which can only send messages as part of a registered campaign.",
+}
+
+// ErrRetryAfter should be returned when the channel can't send right now but has told us when to try again, e.g. a
+// resource is still being provisioned. The message is put back on the queue to be sent after the given delay and,
+// unlike other retryable errors, this doesn't count as a failed attempt.
+func ErrRetryAfter(delay time.Duration) *SendError {
+	return &SendError{
+		msg:        "channel asked for retry",
+		retryable:  true,
+		loggable:   false,
+		retryAfter: delay,
+		clogCode:   "retry_after",
+		clogMsg:    fmt.Sprintf("Channel asked for send to be retried after %s.", delay),
+	}
+}
+
 func ErrFailedWithReason(code, desc string) *SendError {
 	return &SendError{
 		msg:         "channel rejected send with reason",
@@ -144,6 +223,8 @@ type Foreman struct {
 	server           Server
 	senders          []*Sender
 	availableSenders chan *Sender
+	sends            *sendTracker
+	circuits         *circuitBreaker
 	quit             chan bool
 }
 
@@ -153,6 +234,8 @@ func NewForeman(server Server, maxSenders int) *Foreman {
 		server:           server,
 		senders:          make([]*Sender, maxSenders),
 		availableSenders: make(chan *Sender, maxSenders),
+		sends:            newSendTracker(),
+		circuits:         newCircuitBreaker(server.Config()),
 		quit:             make(chan bool),
 	}
 
@@ -281,6 +364,19 @@ func (w *Sender) sendMessage(msg MsgOut) {
 
 	log := slog.With("comp", "sender", "sender_id", w.id, "channel_uuid", msg.Channel().UUID())
 
+	// sends are recovered from panics in handlers, but if anything else panics, we still want to keep this sender alive
+	// and to complete the send so that the message isn't left claimed and is retried
+	completed := false
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic sending message", "error", r, "msg_id", msg.ID(), "stack", string(debug.Stack()))
+
+			if !completed {
+				w.abandonSend(msg, log)
+			}
+		}
+	}()
+
 	server := w.foreman.server
 	backend := server.Backend()
 
@@ -288,6 +384,25 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
 	defer cancel()
 
+	handler := server.GetHandler(msg.Channel())
+
+	// if handler can send in bulk, see if there are more messages for this channel we can send with this one
+	bulkSender, isBulk := handler.(BulkSender)
+	popper, canPop := BackendAs[BulkMsgPopper](backend)
+	if isBulk && canPop {
+		if max := bulkSender.MaxBulkSize(msg.Channel()); max > 1 {
+			more, err := popper.PopMoreOutgoingMsgs(sendCTX, msg, max-1)
+			if err != nil {
+				log.Error("error popping more outgoing msgs", "error", err)
+			}
+			if len(more) > 0 {
+				completed = true // bulk sends complete their own messages
+				w.sendBulk(sendCTX, handler, bulkSender, append([]MsgOut{msg}, more...), log)
+				return
+			}
+		}
+	}
+
 	log = log.With("msg_id", msg.ID(), "msg_text", msg.Text(), "msg_urn", msg.URN().Identity())
 	if len(msg.Attachments()) > 0 {
 		log = log.With("attachments", msg.Attachments())
@@ -296,25 +411,10 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		log = log.With("quick_replies", msg.QuickReplies())
 	}
 
-	// if this is a resend, clear our sent status
-	if msg.IsResend() {
-		err := backend.ClearMsgSent(sendCTX, msg.ID())
-		if err != nil {
-			log.Error("error clearing sent status for msg", "error", err)
-		}
-	}
-
-	// was this msg already sent? (from a double queue?)
-	sent, err := backend.WasMsgSent(sendCTX, msg.ID())
-
-	// failing on a lookup isn't a halting problem but we should log it
-	if err != nil {
-		log.Error("error looking up msg was sent", "error", err)
-	}
+	sent := w.checkSent(sendCTX, msg, log)
 
 	var status StatusUpdate
 	var redactValues []string
-	handler := server.GetHandler(msg.Channel())
 	if handler != nil {
 		redactValues = handler.RedactValues(msg.Channel())
 	}
@@ -331,6 +431,14 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusWired, clog)
 		log.Warn("duplicate send, marking as wired")
 
+	} else if delay := w.checkCircuit(msg.Channel(), log); delay > 0 {
+		// if the channel's provider is failing, put the message back on the queue until its circuit might have closed
+		status = w.statusFromResult(sendCTX, msg, &SendResult{newURN: urns.NilURN}, errCircuitOpen(delay), clog, log)
+
+	} else if err := w.checkNumber(sendCTX, msg, clog, log); err != nil {
+		// if the number is deactivated or a lookup tells us this message can't be delivered, fail it without sending
+		status = w.statusFromResult(sendCTX, msg, &SendResult{newURN: urns.NilURN}, err, clog, log)
+
 	} else {
 		status = w.sendByHandler(sendCTX, handler, msg, clog, log)
 	}
@@ -339,7 +447,7 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	writeCTX, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	err = backend.WriteStatusUpdate(writeCTX, status)
+	err := backend.WriteStatusUpdate(writeCTX, status)
 	if err != nil {
 		log.Info("error writing msg status", "error", err)
 	}
@@ -353,13 +461,289 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	}
 
 	// mark our send task as complete
+	completed = true
 	backend.OnSendComplete(writeCTX, msg, status, clog)
 }
 
-func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) StatusUpdate {
+// completes the send of the passed in message after a panic outside of its handler, writing it as errored so that it's
+// retried like any other errored send
+func (w *Sender) abandonSend(m MsgOut, log *slog.Logger) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic completing abandoned send", "error", r, "msg_id", m.ID())
+		}
+	}()
+
 	backend := w.foreman.server.Backend()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	clog := NewChannelLogForSend(m, nil)
+	clog.Error(ErrorHandlerPanic())
+
+	status := backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusErrored, clog)
+	if err := backend.WriteStatusUpdate(ctx, status); err != nil {
+		log.Info("error writing msg status", "error", err, "msg_id", m.ID())
+	}
+
+	clog.End()
+
+	if err := backend.WriteChannelLog(ctx, clog); err != nil {
+		log.Info("error writing msg logs", "error", err, "msg_id", m.ID())
+	}
+
+	backend.OnSendComplete(ctx, m, status, clog)
+}
+
+// sends the passed in messages for a single channel in a single call to the handler, they share a single channel log
+func (w *Sender) sendBulk(ctx context.Context, h ChannelHandler, bs BulkSender, msgs []MsgOut, log *slog.Logger) {
+	backend := w.foreman.server.Backend()
+
+	log = log.With("msg_count", len(msgs))
+
+	// like single sends, if anything outside of the handler panics, we complete the messages which haven't been
+	// completed yet, as errored so that they're retried without any crashes being counted against them
+	completed := 0
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic sending messages", "error", r, "stack", string(debug.Stack()))
+
+			for _, m := range msgs[completed:] {
+				w.abandonSend(m, log)
+			}
+		}
+	}()
+
+	clog := NewChannelLogForSend(msgs[0], h.RedactValues(msgs[0].Channel()))
+	statuses := make([]StatusUpdate, len(msgs))
+
+	// figure out which messages actually need sending
+	toSend := make([]MsgOut, 0, len(msgs))
+	toSendIdx := make([]int, 0, len(msgs))
+	for i, m := range msgs {
+		if w.checkSent(ctx, m, log) {
+			statuses[i] = backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusWired, clog)
+			log.Warn("duplicate send, marking as wired", "msg_id", m.ID())
+		} else if err := w.checkNumber(ctx, m, clog, log); err != nil {
+			statuses[i] = w.statusFromResult(ctx, m, &SendResult{newURN: urns.NilURN}, err, clog, log.With("msg_id", m.ID()))
+		} else {
+			toSend = append(toSend, m)
+			toSendIdx = append(toSendIdx, i)
+		}
+	}
+
+	// if the channel's provider is failing, put the messages back on the queue until its circuit might have closed
+	if len(toSend) > 0 {
+		if delay := w.checkCircuit(msgs[0].Channel(), log); delay > 0 {
+			for i, m := range toSend {
+				statuses[toSendIdx[i]] = w.statusFromResult(ctx, m, &SendResult{newURN: urns.NilURN}, errCircuitOpen(delay), clog, log.With("msg_id", m.ID()))
+			}
+			toSend = nil
+		}
+	}
+
+	if len(toSend) > 0 {
+		results := make([]*SendResult, len(toSend))
+		pooled := make([]MsgOut, len(toSend))
+		for i, m := range toSend {
+			w.markRead(ctx, h, m, clog, log.With("msg_id", m.ID()))
+
+			results[i] = &SendResult{newURN: urns.NilURN}
+			pooled[i] = w.poolSender(m, log.With("msg_id", m.ID()))
+		}
+
+		err := recoverSend(func() error { return bs.SendBulk(ctx, pooled, results, clog) })
+
+		// if the handler panicked we can't tell which message crashed it, so we send each message on its own instead,
+		// so that only a message which crashes the handler has that counted against it
+		var hp *handlerPanic
+		if errors.As(err, &hp) {
+			log.Error("handler panicked sending messages in bulk, sending individually", "error", err, "stack", string(hp.stack))
+
+			for i, m := range toSend {
+				statuses[toSendIdx[i]] = w.sendSingle(ctx, h, m, pooled[i], clog, log.With("msg_id", m.ID()))
+			}
+		} else {
+			for i, m := range toSend {
+				msgErr := err
+				if results[i].err != nil {
+					msgErr = results[i].err
+				}
+				statuses[toSendIdx[i]] = w.statusFromResult(ctx, m, results[i], msgErr, clog, log.With("msg_id", m.ID()))
+				w.foreman.sends.record(h.ChannelType(), statuses[toSendIdx[i]].Status())
+			}
+
+			w.recordCircuit(msgs[0].Channel(), clog, log)
+		}
+	}
+
+	// we allot 10 seconds to write our statuses to the db
+	writeCTX, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	for _, status := range statuses {
+		if err := backend.WriteStatusUpdate(writeCTX, status); err != nil {
+			log.Info("error writing msg status", "error", err, "msg_id", status.MsgID())
+		}
+	}
+
+	clog.End()
+
+	if err := backend.WriteChannelLog(writeCTX, clog); err != nil {
+		log.Info("error writing msg logs", "error", err)
+	}
+
+	for i, m := range msgs {
+		completed = i + 1
+		backend.OnSendComplete(writeCTX, m, statuses[i], clog)
+	}
+}
+
+// clears the sent status of resends and then checks whether the passed in message was already sent
+func (w *Sender) checkSent(ctx context.Context, msg MsgOut, log *slog.Logger) bool {
+	backend := w.foreman.server.Backend()
+
+	// if this is a resend, clear our sent status
+	if msg.IsResend() {
+		err := backend.ClearMsgSent(ctx, msg.ID())
+		if err != nil {
+			log.Error("error clearing sent status for msg", "error", err)
+		}
+	}
+
+	// was this msg already sent? (from a double queue?)
+	sent, err := backend.WasMsgSent(ctx, msg.ID())
+
+	// failing on a lookup isn't a halting problem but we should log it
+	if err != nil {
+		log.Error("error looking up msg was sent", "error", err)
+	}
+
+	return sent
+}
+
+// checks the destination number of the passed in message if number lookups are enabled, writing the log of any lookup
+func (w *Sender) checkNumber(ctx context.Context, msg MsgOut, clog *ChannelLog, log *slog.Logger) error {
+	backend := w.foreman.server.Backend()
+
+	if w.foreman.server.Config().DeactivationsEnabled() {
+		if err := checkDeactivated(ctx, backend, msg, clog); err != nil {
+			return err
+		}
+	}
+
+	lookupLog, err := checkNumber(ctx, backend, msg)
+	if lookupLog != nil {
+		if err := backend.WriteChannelLog(ctx, lookupLog); err != nil {
+			log.Error("error writing number lookup log", "error", err)
+		}
+	}
+	return err
+}
+
+func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) StatusUpdate {
+	w.markRead(ctx, h, m, clog, log)
+
+	return w.sendSingle(ctx, h, m, w.poolSender(m, log), clog, log)
+}
+
+// sends the passed in message on its own, as the passed in pooled version of it which may be from a different number
+func (w *Sender) sendSingle(ctx context.Context, h ChannelHandler, m, pooled MsgOut, clog *ChannelLog, log *slog.Logger) StatusUpdate {
 	res := &SendResult{newURN: urns.NilURN}
-	err := h.Send(ctx, m, res, clog)
+	err := recoverSend(func() error { return h.Send(ctx, pooled, res, clog) })
+
+	status := w.statusFromResult(ctx, m, res, err, clog, log)
+	w.foreman.sends.record(h.ChannelType(), status.Status())
+	w.recordCircuit(m.Channel(), clog, log)
+	return status
+}
+
+// if the passed in message's channel has a pool of sender numbers, returns it with the number it should be sent from
+func (w *Sender) poolSender(m MsgOut, log *slog.Logger) MsgOut {
+	// most channels don't have a pool so avoid getting a connection for them
+	if len(senderPoolForChannel(m.Channel())) == 0 {
+		return m
+	}
+
+	rc := w.foreman.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	pooled, err := withPoolSender(rc, m)
+	if err != nil {
+		log.Error("error picking sender from pool, sending from channel address", "error", err)
+	}
+	return pooled
+}
+
+// if the passed in message is a reply and its channel has mark_read enabled, marks the message being replied to as read
+func (w *Sender) markRead(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) {
+	marker, isMarker := h.(ReadMarker)
+	if !isMarker || m.ResponseToExternalID() == "" || !m.Channel().BoolConfigForKey(ConfigMarkRead, false) {
+		return
+	}
+
+	// failing to mark a message as read shouldn't stop us sending the reply
+	if err := marker.MarkRead(ctx, m.Channel(), m.ResponseToExternalID(), clog); err != nil {
+		log.Warn("error marking msg as read", "error", err, "external_id", m.ResponseToExternalID())
+	}
+}
+
+// returns how long sends by the given channel should be deferred for because its circuit is open
+func (w *Sender) checkCircuit(ch Channel, log *slog.Logger) time.Duration {
+	if !w.foreman.circuits.enabled() {
+		return 0
+	}
+
+	rc := w.foreman.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	delay, err := w.foreman.circuits.check(rc, ch.UUID(), time.Now())
+	if err != nil {
+		log.Error("error checking circuit", "error", err)
+	}
+	return delay
+}
+
+// records whether the provider failed a send for the circuit of its channel, logging if that opens or closes it
+func (w *Sender) recordCircuit(ch Channel, clog *ChannelLog, log *slog.Logger) {
+	failed, requested := isProviderFailure(clog)
+	if !w.foreman.circuits.enabled() || !requested {
+		return
+	}
+
+	rc := w.foreman.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	change, err := w.foreman.circuits.record(rc, ch.UUID(), failed, time.Now())
+	if err != nil {
+		log.Error("error recording circuit send", "error", err)
+	}
+
+	switch change {
+	case circuitOpened:
+		log.Warn("channel circuit opened, pausing sends", "channel_type", ch.ChannelType(), "cooldown", w.foreman.circuits.cooldown)
+		clog.Error(ErrorCircuitOpened(w.foreman.circuits.cooldown))
+	case circuitClosed:
+		log.Warn("channel circuit closed, resuming sends", "channel_type", ch.ChannelType())
+	}
+}
+
+// records that sending the passed in message crashed its handler, returning how many times it has now
+func (w *Sender) recordPanic(m MsgOut, log *slog.Logger) int {
+	rc := w.foreman.server.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	crashes, err := recordSendPanic(rc, m.ID())
+	if err != nil {
+		log.Error("error recording send panic", "error", err)
+	}
+	return crashes
+}
+
+// creates a status update for the passed in message from the result and error of trying to send it
+func (w *Sender) statusFromResult(ctx context.Context, m MsgOut, res *SendResult, err error, clog *ChannelLog, log *slog.Logger) StatusUpdate {
+	backend := w.foreman.server.Backend()
 
 	status := backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusWired, clog)
 
@@ -375,8 +759,27 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 		}
 	}
 
+	if res.segments > 0 {
+		status.SetSegments(res.segments)
+	}
+
+	var hp *handlerPanic
 	var serr *SendError
-	if errors.As(err, &serr) {
+	if errors.As(err, &hp) {
+		log.Error("handler panicked sending message", "error", err, "stack", string(hp.stack))
+
+		// a message which keeps crashing its handler is failed so that it isn't retried forever, and which means the
+		// backend keeps its payload for inspection
+		if crashes := w.recordPanic(m, log); crashes >= maxSendPanics {
+			status.SetStatus(MsgStatusFailed)
+			status.SetFailedReason(MsgFailedReasonPoison)
+			clog.Error(ErrorPoisonMsg(crashes))
+		} else {
+			status.SetStatus(MsgStatusErrored)
+			clog.Error(ErrorHandlerPanic())
+		}
+
+	} else if errors.As(err, &serr) {
 		if serr.loggable {
 			log.Error("error sending message", "error", err)
 		}
@@ -388,6 +791,16 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 
 		clog.Error(clogs.NewLogError(serr.clogCode, serr.clogExtCode, serr.clogMsg))
 
+		// if handler asked for a retry later, put the message back on the queue so it stays queued rather than errored,
+		// unless that fails in which case it's retried like any other errored message
+		if serr.retryAfter > 0 {
+			if err := requeueMsg(ctx, backend, m, serr.retryAfter); err != nil {
+				log.Error("error requeuing msg for retry", "error", err)
+			} else {
+				status.SetStatus(MsgStatusQueued)
+			}
+		}
+
 		// if handler returned ErrContactStopped need to write a stop event
 		if serr == ErrContactStopped {
 			channelEvent := backend.NewChannelEvent(m.Channel(), EventTypeStopContact, m.URN(), clog)
@@ -396,6 +809,46 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 			}
 		}
 
+		// if handler returned ErrContactUnknown need to flag the URN as no longer reachable
+		if serr == ErrContactUnknown {
+			channelEvent := backend.NewChannelEvent(m.Channel(), EventTypeURNDeactivated, m.URN(), clog)
+			if err = backend.WriteChannelEvent(ctx, channelEvent, clog); err != nil {
+				log.Error("error writing urn deactivated event", "error", err)
+			}
+		}
+
+		// if handler returned ErrInsufficientBalance need to hold the channel's messages until credit is restored
+		if serr == ErrInsufficientBalance {
+			log.Warn("channel has insufficient balance, pausing queue")
+
+			if err := pauseQueue(ctx, backend, m.Channel().UUID()); err != nil {
+				log.Error("error pausing queue", "error", err)
+			}
+
+			// and this message is held with them rather than counted as a failed attempt
+			if err := requeueMsg(ctx, backend, m, 0); err != nil {
+				log.Error("error requeuing msg until credit is restored", "error", err)
+			} else {
+				status.SetStatus(MsgStatusQueued)
+			}
+		}
+
+		// if handler returned ErrChannelUnverified need to hold the channel's messages until it's verified
+		if serr == ErrChannelUnverified {
+			log.Warn("channel is unverified, pausing queue")
+
+			if err := pauseQueue(ctx, backend, m.Channel().UUID()); err != nil {
+				log.Error("error pausing queue", "error", err)
+			}
+
+			// and this message is held with them rather than counted as a failed attempt
+			if err := requeueMsg(ctx, backend, m, 0); err != nil {
+				log.Error("error requeuing msg until channel is verified", "error", err)
+			} else {
+				status.SetStatus(MsgStatusQueued)
+			}
+		}
+
 	} else if err != nil {
 		log.Error("error sending message", "error", err)
 
@@ -404,5 +857,73 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 		clog.Error(clogs.NewLogError("internal_error", "", "An internal error occured."))
 	}
 
+	if status.Status() == MsgStatusErrored {
+		w.failover(ctx, m, status, clog, log)
+	}
+
 	return status
 }
+
+// if the passed in errored message has had enough errored attempts and its channel has a fallback channel, moves it to
+// that channel and updates its status to record that
+func (w *Sender) failover(ctx context.Context, m MsgOut, status StatusUpdate, clog *ChannelLog, log *slog.Logger) {
+	fallbackUUID := ChannelUUID(m.Channel().StringConfigForKey(ConfigFallbackChannel, ""))
+	if fallbackUUID == "" {
+		return
+	}
+
+	backend := w.foreman.server.Backend()
+	requeuer, canRequeue := BackendAs[MsgRequeuer](backend)
+	if !canRequeue {
+		return
+	}
+
+	rc := backend.RedisPool().Get()
+	defer rc.Close()
+
+	attempts, err := recordSendError(rc, m.ID())
+	if err != nil {
+		log.Error("error recording send error", "error", err)
+		return
+	}
+	if attempts < m.Channel().IntConfigForKey(ConfigFallbackAttempts, defaultFallbackAttempts) {
+		return
+	}
+
+	fallback, err := backend.GetChannel(ctx, AnyChannelType, fallbackUUID)
+	if err != nil {
+		log.Error("error loading fallback channel", "error", err, "fallback_channel_uuid", fallbackUUID)
+		return
+	}
+	if err := requeuer.FailoverMsg(ctx, m, fallback); err != nil {
+		log.Error("error moving msg to fallback channel", "error", err, "fallback_channel_uuid", fallbackUUID)
+		return
+	}
+	if err := clearSendErrors(rc, m.ID()); err != nil {
+		log.Error("error clearing send errors", "error", err)
+	}
+
+	status.SetStatus(MsgStatusQueued)
+	status.SetFallbackChannel(fallback)
+	clog.Error(ErrorFailover(fallback.UUID(), attempts))
+
+	log.Warn("msg moved to fallback channel", "fallback_channel_uuid", fallback.UUID(), "attempts", attempts)
+}
+
+// puts the passed in message back on its queue to be sent again after the given delay, if our backend can do that
+func requeueMsg(ctx context.Context, b Backend, m MsgOut, delay time.Duration) error {
+	requeuer, ok := BackendAs[MsgRequeuer](b)
+	if !ok {
+		return errors.New("backend can't requeue messages")
+	}
+	return requeuer.RequeueMsg(ctx, m, delay)
+}
+
+// pauses the queue of the given channel, if our backend can do that
+func pauseQueue(ctx context.Context, b Backend, uuid ChannelUUID) error {
+	pauser, ok := BackendAs[QueuePauser](b)
+	if !ok {
+		return errors.New("backend can't pause queues")
+	}
+	return pauser.PauseQueue(ctx, uuid, true)
+}
@@ -0,0 +1,123 @@
+package courier
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	// SenderPoolSticky sends all messages to a contact from the same number in the pool
+	SenderPoolSticky = "sticky"
+
+	// SenderPoolRoundRobin sends each message from the next number in the pool
+	SenderPoolRoundRobin = "round_robin"
+)
+
+// how long a contact stays mapped to a number of the pool after they were last sent to
+const senderPoolStickyTTL = 30 * 24 * time.Hour
+
+// keys of a channel's pool are tagged with its UUID so that they're in the same slot in a cluster
+func senderPoolKey(ch Channel) string {
+	return valkey.WithTag(string(ch.UUID()), fmt.Sprintf("sender_pool:%s", ch.UUID()))
+}
+
+func senderPoolNextKey(ch Channel) string {
+	return valkey.WithTag(string(ch.UUID()), fmt.Sprintf("sender_pool_next:%s", ch.UUID()))
+}
+
+// gets the pool of sender numbers configured on the given channel
+func senderPoolForChannel(ch Channel) []string {
+	var pool []string
+
+	switch v := ch.ConfigForKey(ConfigSenderPool, nil).(type) {
+	case []string:
+		pool = v
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s != "" {
+				pool = append(pool, s)
+			}
+		}
+	}
+	return pool
+}
+
+// PoolSenderAddress returns the address that a message to the given URN should be sent from, or empty if the channel
+// doesn't have a pool of sender numbers. In sticky mode (the default) each contact is mapped to a number of the pool
+// which they keep being sent from, otherwise each message is sent from the next number of the pool.
+func PoolSenderAddress(rc redis.Conn, ch Channel, urn urns.URN) (string, error) {
+	pool := senderPoolForChannel(ch)
+	if len(pool) == 0 {
+		return "", nil
+	}
+
+	if ch.StringConfigForKey(ConfigSenderPoolMode, SenderPoolSticky) == SenderPoolRoundRobin {
+		return nextPoolSender(rc, ch, pool)
+	}
+
+	key, identity := senderPoolKey(ch), urn.Identity().String()
+
+	address, err := redis.String(rc.Do("HGET", key, identity))
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error reading sender pool mapping: %w", err)
+	}
+
+	// contacts without a number, or whose number has since been removed from the pool, are given the next one
+	if !slices.Contains(pool, address) {
+		if address, err = nextPoolSender(rc, ch, pool); err != nil {
+			return "", err
+		}
+	}
+
+	rc.Send("MULTI")
+	rc.Send("HSET", key, identity, address)
+	rc.Send("EXPIRE", key, int(senderPoolStickyTTL/time.Second))
+	if _, err := rc.Do("EXEC"); err != nil {
+		return "", fmt.Errorf("error writing sender pool mapping: %w", err)
+	}
+
+	return address, nil
+}
+
+// gets the next number of the given pool, cycling through them in order
+func nextPoolSender(rc redis.Conn, ch Channel, pool []string) (string, error) {
+	next, err := redis.Int(rc.Do("INCR", senderPoolNextKey(ch)))
+	if err != nil {
+		return "", fmt.Errorf("error incrementing sender pool counter: %w", err)
+	}
+	return pool[(next-1)%len(pool)], nil
+}
+
+// wraps a channel so that it sends from a number of its pool rather than its own address
+type pooledChannel struct {
+	Channel
+
+	address string
+}
+
+func (c *pooledChannel) Address() string                { return c.address }
+func (c *pooledChannel) ChannelAddress() ChannelAddress { return ChannelAddress(c.address) }
+
+// wraps an outgoing message so that its handler sees its channel as having the given address
+type pooledMsg struct {
+	MsgOut
+
+	channel Channel
+}
+
+func (m *pooledMsg) Channel() Channel { return m.channel }
+
+// if the channel of the given message has a pool of sender numbers, returns a message whose channel has the address it
+// should be sent from, otherwise returns the message unchanged
+func withPoolSender(rc redis.Conn, msg MsgOut) (MsgOut, error) {
+	address, err := PoolSenderAddress(rc, msg.Channel(), msg.URN())
+	if err != nil || address == "" {
+		return msg, err
+	}
+	return &pooledMsg{MsgOut: msg, channel: &pooledChannel{Channel: msg.Channel(), address: address}}, nil
+}
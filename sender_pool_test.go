@@ -0,0 +1,58 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolSenderAddress(t *testing.T) {
+	mb := test.NewMockBackend()
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	noPool := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	sticky := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigSenderPool: []any{"+12065550101", "+12065550102"},
+	})
+	roundRobin := test.NewMockChannel("8e3a1a5b-8e8e-4d2c-9b0c-1c5b5a2d2b61", "MCK", "2022", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigSenderPool:     []any{"+12065550201", "+12065550202", "+12065550203"},
+		courier.ConfigSenderPoolMode: courier.SenderPoolRoundRobin,
+	})
+
+	pick := func(ch courier.Channel, urn urns.URN) string {
+		address, err := courier.PoolSenderAddress(rc, ch, urn)
+		require.NoError(t, err)
+		return address
+	}
+
+	bob, jim, ann := urns.URN("tel:+250788000001"), urns.URN("tel:+250788000002"), urns.URN("tel:+250788000003")
+
+	// channels without a pool send from their own address
+	assert.Equal(t, "", pick(noPool, bob))
+
+	// contacts are given the next number of the pool and then stick to it
+	assert.Equal(t, "+12065550101", pick(sticky, bob))
+	assert.Equal(t, "+12065550102", pick(sticky, jim))
+	assert.Equal(t, "+12065550101", pick(sticky, ann))
+	assert.Equal(t, "+12065550101", pick(sticky, bob))
+	assert.Equal(t, "+12065550102", pick(sticky, jim))
+
+	// in round robin mode every message is sent from the next number
+	assert.Equal(t, "+12065550201", pick(roundRobin, bob))
+	assert.Equal(t, "+12065550202", pick(roundRobin, bob))
+	assert.Equal(t, "+12065550203", pick(roundRobin, jim))
+	assert.Equal(t, "+12065550201", pick(roundRobin, bob))
+
+	// if a contact's number is removed from the pool, they're moved to another
+	shrunk := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigSenderPool: []any{"+12065550102", "+12065550103"},
+	})
+	assert.Equal(t, "+12065550102", pick(shrunk, jim))
+	assert.Equal(t, "+12065550103", pick(shrunk, bob))
+	assert.Equal(t, "+12065550103", pick(shrunk, bob))
+}
@@ -6,8 +6,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"log/slog"
+	"maps"
 	"net/http"
 	"runtime/debug"
 	"slices"
@@ -51,17 +51,26 @@ type Server interface {
 	Stop() error
 }
 
+// ServerOption is an option which can be passed to NewServer
+type ServerOption func(*server)
+
+// WithHandlers sets the handlers used by the server, instead of all the handlers registered by imported handler
+// packages. Handlers registered by packages can still be included by fetching them with GetHandler.
+func WithHandlers(handlers ...ChannelHandler) ServerOption {
+	return func(s *server) { s.handlers = handlers }
+}
+
 // NewServer creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
-func NewServer(config *Config, backend Backend) Server {
+func NewServer(config *Config, backend Backend, opts ...ServerOption) Server {
 	// create our top level router
 	logger := slog.Default()
-	return NewServerWithLogger(config, backend, logger)
+	return NewServerWithLogger(config, backend, logger, opts...)
 }
 
 // NewServerWithLogger creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
-func NewServerWithLogger(config *Config, backend Backend, logger *slog.Logger) Server {
+func NewServerWithLogger(config *Config, backend Backend, logger *slog.Logger, opts ...ServerOption) Server {
 	router := chi.NewRouter()
 	router.Use(middleware.Compress(flate.DefaultCompression))
 	router.Use(middleware.StripSlashes)
@@ -73,17 +82,25 @@ func NewServerWithLogger(config *Config, backend Backend, logger *slog.Logger) S
 	publicRouter := chi.NewRouter()
 	router.Mount("/c/", publicRouter)
 
-	return &server{
+	s := &server{
 		config:  config,
 		backend: backend,
 
 		router:       router,
 		publicRouter: publicRouter,
 
+		activeHandlers: make(map[ChannelType]ChannelHandler),
+
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
 		stopped:   false,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 // Start starts the Server listening for incoming requests and sending messages. It will return an error
@@ -104,10 +121,54 @@ func (s *server) Start() error {
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.basicAuthRequired(s.handleStatus))
+	s.router.Get("/health", s.handleHealth(false))
+	s.router.Get("/m/{token}", s.handleShortLink)
+	s.router.Head("/m/{token}", s.handleShortLink)
 	s.publicRouter.Post("/_fetch-attachment", s.tokenAuthRequired(s.handleFetchAttachment)) // becomes /c/_fetch-attachment
+	s.router.Get("/admin/drift", s.tokenAuthRequired(s.handleListSchemaDrift))
+	s.router.Delete("/admin/drift/{type}", s.tokenAuthRequired(s.handleClearSchemaDrift))
+	s.router.Get("/admin/health", s.tokenAuthRequired(s.handleHealth(true)))
+	s.router.Post("/admin/preview/{uuid}", s.tokenAuthRequired(s.handlePreviewMsg))
+	s.router.Post("/admin/profile/{uuid}", s.tokenAuthRequired(s.handleConfigureProfile))
+
+	// the rest of the admin API depends on what our backend supports
+	var ok bool
+	if s.queues, ok = BackendAs[QueueManager](s.backend); ok {
+		s.router.Get("/admin/queues", s.tokenAuthRequired(s.handleListQueues))
+		s.router.Get("/admin/queues/{uuid}", s.tokenAuthRequired(s.handleGetQueue))
+		s.router.Delete("/admin/queues/{uuid}", s.tokenAuthRequired(s.handlePurgeQueue))
+		s.router.Post("/admin/queues/{uuid}/pause", s.tokenAuthRequired(s.handlePauseQueue(true)))
+		s.router.Post("/admin/queues/{uuid}/resume", s.tokenAuthRequired(s.handlePauseQueue(false)))
+		s.router.Post("/admin/queues/{uuid}/drain", s.tokenAuthRequired(s.handleDrainQueue))
+	}
+	if s.deadLetters, ok = BackendAs[DeadLetterStore](s.backend); ok {
+		s.router.Get("/admin/dlq/{uuid}", s.tokenAuthRequired(s.handleListDeadLetters))
+		s.router.Post("/admin/dlq/{uuid}/requeue", s.tokenAuthRequired(s.handleRequeueDeadLetters))
+	}
+	if s.logSearcher, ok = BackendAs[ChannelLogSearcher](s.backend); ok {
+		s.router.Get("/admin/logs/{uuid}", s.tokenAuthRequired(s.handleListRecentLogs))
+	}
+	if s.archive, ok = BackendAs[MsgArchive](s.backend); ok {
+		s.router.Get("/admin/msgs/{uuid}", s.tokenAuthRequired(s.handleGetArchivedMsg))
+		s.router.Post("/admin/msgs/{uuid}/resend", s.tokenAuthRequired(s.handleResendArchivedMsg))
+		s.router.Get("/admin/msgs/{uuid}/timeline", s.tokenAuthRequired(s.handleGetMsgTimeline))
+	}
+	if s.msgQueuer, ok = BackendAs[MsgQueuer](s.backend); ok {
+		s.router.Post("/api/v1/send", s.tokenAuthRequired(s.handleSend))
+	}
 
 	// initialize our handlers
-	s.initializeChannelHandlers()
+	if err := s.initializeChannelHandlers(); err != nil {
+		return err
+	}
+
+	if s.config.QualityInterval > 0 {
+		s.qualityPoller = s.newQualityPoller()
+	}
+
+	if s.config.DeactivationsInterval > 0 {
+		s.deactivationPoller = s.newDeactivationPoller()
+	}
 
 	// configure timeouts on our server
 	s.httpServer = &http.Server{
@@ -140,6 +201,16 @@ func (s *server) Start() error {
 	s.foreman = NewForeman(s, s.config.MaxWorkers)
 	s.foreman.Start()
 
+	// and our poller for channel quality
+	if s.qualityPoller != nil {
+		s.qualityPoller.Start()
+	}
+
+	// and our poller for deactivated numbers
+	if s.deactivationPoller != nil {
+		s.deactivationPoller.Start()
+	}
+
 	return nil
 }
 
@@ -156,6 +227,13 @@ func (s *server) Stop() error {
 		log.Error("error shutting down server", "error", err, "state", "stopping")
 	}
 
+	// write anything the backend is still buffering before we stop it, but don't wait forever
+	flushCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(s.config.ShutdownTimeout))
+	if err := s.backend.Flush(flushCtx); err != nil {
+		log.Error("error flushing backend", "error", err, "state", "stopping")
+	}
+	cancel()
+
 	// stop everything
 	s.stopped = true
 	close(s.stopChan)
@@ -175,7 +253,43 @@ func (s *server) Stop() error {
 	return nil
 }
 
-func (s *server) GetHandler(ch Channel) ChannelHandler { return activeHandlers[ch.ChannelType()] }
+func (s *server) GetHandler(ch Channel) ChannelHandler { return s.activeHandlers[ch.ChannelType()] }
+
+// creates a poller for the quality of channels whose handlers can check it, or nil if our backend can't list them or
+// record their quality
+func (s *server) newQualityPoller() *qualityPoller {
+	lister, canList := BackendAs[ChannelLister](s.backend)
+	writer, canWrite := BackendAs[QualityWriter](s.backend)
+	if !canList || !canWrite {
+		slog.Warn("backend can't list channels or record their quality, quality polling disabled", "comp", "server")
+		return nil
+	}
+
+	types := make([]ChannelType, 0, len(s.activeHandlers))
+	for typ, handler := range s.activeHandlers {
+		if _, isChecker := handler.(QualityChecker); isChecker {
+			types = append(types, typ)
+		}
+	}
+	slices.Sort(types)
+
+	return newQualityPoller(s, lister, writer, types, time.Duration(s.config.QualityInterval)*time.Second)
+}
+
+// creates a poller for the deactivated numbers of channels whose handlers can fetch them
+func (s *server) newDeactivationPoller() *deactivationPoller {
+	lister, _ := BackendAs[ChannelLister](s.backend)
+
+	types := make([]ChannelType, 0, len(s.activeHandlers))
+	for typ, handler := range s.activeHandlers {
+		if _, isFetcher := handler.(DeactivationFetcher); isFetcher {
+			types = append(types, typ)
+		}
+	}
+	slices.Sort(types)
+
+	return newDeactivationPoller(s, lister, types, time.Duration(s.config.DeactivationsInterval)*time.Second)
+}
 
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
@@ -188,11 +302,23 @@ func (s *server) Router() chi.Router { return s.router }
 type server struct {
 	backend Backend
 
+	// optional features of our backend, nil if not supported
+	queues      QueueManager
+	deadLetters DeadLetterStore
+	logSearcher ChannelLogSearcher
+	archive     MsgArchive
+	msgQueuer   MsgQueuer
+
 	httpServer   *http.Server
 	router       *chi.Mux
 	publicRouter *chi.Mux
 
-	foreman *Foreman
+	handlers       []ChannelHandler // nil means all registered handlers
+	activeHandlers map[ChannelType]ChannelHandler
+
+	foreman            *Foreman
+	qualityPoller      *qualityPoller
+	deactivationPoller *deactivationPoller
 
 	config *Config
 
@@ -203,19 +329,24 @@ type server struct {
 	chanRoutes []string // used for index page
 }
 
-func (s *server) initializeChannelHandlers() {
+func (s *server) initializeChannelHandlers() error {
 	includes := s.config.IncludeChannels
 	excludes := s.config.ExcludeChannels
 
+	handlers := s.handlers
+	if handlers == nil {
+		handlers = slices.Collect(maps.Values(registeredHandlers))
+	}
+
 	// initialize handlers which are included/not-excluded in the config
-	for _, handler := range registeredHandlers {
+	for _, handler := range handlers {
 		channelType := string(handler.ChannelType())
 		if (includes == nil || slices.Contains(includes, channelType)) && (excludes == nil || !slices.Contains(excludes, channelType)) {
 			err := handler.Initialize(s)
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("error initializing %s handler: %w", channelType, err)
 			}
-			activeHandlers[handler.ChannelType()] = handler
+			s.activeHandlers[handler.ChannelType()] = handler
 
 			slog.Info("handler initialized", "comp", "server", "handler", handler.ChannelName(), "handler_type", channelType)
 		}
@@ -223,6 +354,7 @@ func (s *server) initializeChannelHandlers() {
 
 	// sort our route help
 	sort.Strings(s.chanRoutes)
+	return nil
 }
 
 func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc ChannelHandleFunc, logType clogs.LogType) http.HandlerFunc {
@@ -252,6 +384,10 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		var channelUUID ChannelUUID
 		if channel != nil {
 			channelUUID = channel.UUID()
+
+			if s.deactivationPoller != nil {
+				s.deactivationPoller.Track(channel)
+			}
 		}
 
 		defer func() {
@@ -281,6 +417,13 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		}
 
 		if channel != nil {
+			// only mirror requests which the handler accepted as coming from the channel, noting that handlers reject
+			// requests, e.g. with invalid signatures, by writing an error response without returning an error
+			if hErr == nil && recorder.Trace.Response != nil && recorder.Trace.Response.StatusCode/100 == 2 {
+				s.mirrorRequest(channel, recorder.Trace.RequestTrace)
+				s.relayEvents(channel, events)
+			}
+
 			for _, event := range events {
 				switch e := event.(type) {
 				case MsgIn:
@@ -357,7 +500,7 @@ func (s *server) handleFetchAttachment(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
 	defer cancel()
 
-	resp, err := fetchAttachment(ctx, s.backend, r)
+	resp, err := fetchAttachment(ctx, s.backend, NewAttachmentLimits(s.config), s.config.AttachmentFetchWorkers, r)
 	if err != nil {
 		slog.Error("error fetching attachment", "error", err)
 		WriteError(w, http.StatusBadRequest, err)
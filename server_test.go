@@ -2,20 +2,26 @@ package courier_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/nyaruka/courier"
+	_ "github.com/nyaruka/courier/handlers/twiml"
 	"github.com/nyaruka/courier/test"
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/redisx/assertredis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,8 +34,25 @@ func testConfig() *courier.Config {
 	return config
 }
 
+// starts a server for the given config and backend, waiting until it's accepting requests, and returns a function to
+// stop it
+func startTestServer(t *testing.T, config *courier.Config, backend courier.Backend) func() {
+	server := courier.NewServer(config, backend)
+	require.NoError(t, server.Start())
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/", config.Port))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, time.Second, 10*time.Millisecond, "server didn't come up")
+
+	return func() { server.Stop() }
+}
+
 func TestServerURLs(t *testing.T) {
-	logger := slog.Default()
 	config := testConfig()
 	config.StatusUsername = "admin"
 	config.StatusPassword = "password123"
@@ -37,12 +60,8 @@ func TestServerURLs(t *testing.T) {
 	mb := test.NewMockBackend()
 	mb.AddChannel(test.NewMockChannel("95710b36-855d-4832-a723-5f71f73688a0", "MCK", "12345", "RW", []string{urns.Phone.Prefix}, nil))
 
-	server := courier.NewServerWithLogger(config, mb, logger)
-	server.Start()
-	defer server.Stop()
-
-	// wait for server to come up
-	time.Sleep(100 * time.Millisecond)
+	stop := startTestServer(t, config, mb)
+	defer stop()
 
 	request := func(method, url, user, pass string) (int, string) {
 		req, _ := http.NewRequest(method, url, nil)
@@ -116,6 +135,235 @@ func TestIncoming(t *testing.T) {
 	assert.Len(t, clog.HttpLogs, 1)
 }
 
+func TestStopFlushesBackend(t *testing.T) {
+	mb := test.NewMockBackend()
+	s := courier.NewServer(testConfig(), mb)
+
+	require.NoError(t, s.Start())
+	assert.Equal(t, 0, mb.Flushes())
+
+	s.Stop()
+	assert.Equal(t, 1, mb.Flushes())
+}
+
+func TestIncomingMirroring(t *testing.T) {
+	type mirrored struct {
+		method  string
+		query   string
+		body    string
+		cookie  string
+		channel string
+	}
+	requests := make(chan mirrored, 5)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- mirrored{r.Method, r.URL.RawQuery, string(body), r.Header.Get("Cookie"), r.Header.Get("X-Courier-Channel")}
+		w.WriteHeader(200)
+	}))
+	defer mirror.Close()
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigWebhookMirrorURL: mirror.URL + "/hook?source=courier",
+	}))
+	mb.AddChannel(test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{}))
+
+	s, err := courier.Embed(testConfig(), mb, courier.WithHandlers(courier.GetHandler("MCK")))
+	require.NoError(t, err)
+
+	// a valid request is handled and mirrored
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil)
+	req.Header.Set("Cookie", "secret")
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+
+	select {
+	case m := <-requests:
+		assert.Equal(t, mirrored{"GET", "source=courier&from=2065551212&text=hello", "", "secret", "e4bb1578-29da-4fa5-a214-9da19dd24230"}, m)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "request not mirrored")
+	}
+
+	// a request which the handler rejects isn't mirrored
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 400, recorder.Code)
+
+	// and neither are requests to channels without a mirror URL
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/c/mck/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?from=2065551212&text=hello", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+
+	select {
+	case m := <-requests:
+		assert.Fail(t, "unexpected mirrored request", "got %v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIncomingMirroringWithSignatures(t *testing.T) {
+	requests := make(chan string, 5)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- string(body)
+		w.WriteHeader(200)
+	}))
+	defer mirror.Close()
+
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- string(body)
+		w.WriteHeader(200)
+	}))
+	defer relay.Close()
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "T", "+12065551212", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigAuthToken:        "6789",
+		courier.ConfigWebhookMirrorURL: mirror.URL + "/hook",
+		courier.ConfigRelayURL:         relay.URL + "/relay",
+	}))
+
+	s, err := courier.Embed(testConfig(), mb, courier.WithHandlers(courier.GetHandler("T")))
+	require.NoError(t, err)
+
+	// a request which fails signature validation gets an error response without the handler returning an error, but
+	// still isn't mirrored or relayed
+	form := "ToCountry=US&To=%2B12065551212&MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&AccountSid=AC1234&From=%2B14133881111&Body=Hello"
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/c/t/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", "invalid")
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 400, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "invalid request signature")
+	assert.Len(t, mb.WrittenMsgs(), 0)
+
+	select {
+	case r := <-requests:
+		assert.Fail(t, "unexpected mirrored or relayed request", "got %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIncomingRelaying(t *testing.T) {
+	type relayed struct {
+		path      string
+		body      string
+		signature string
+	}
+	requests := make(chan relayed, 5)
+	failures := 1
+
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		// global relay fails the first time
+		if r.URL.Path == "/global" && failures > 0 {
+			failures--
+			w.WriteHeader(503)
+			return
+		}
+
+		requests <- relayed{r.URL.Path, string(body), r.Header.Get(courier.RelaySignatureHeader)}
+		w.WriteHeader(200)
+	}))
+	defer relay.Close()
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigRelayURL:    relay.URL + "/channel",
+		courier.ConfigRelaySecret: "sesame",
+	}))
+	mb.AddChannel(test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{}))
+
+	config := testConfig()
+	config.RelayURL = relay.URL + "/global"
+
+	s, err := courier.Embed(config, mb, courier.WithHandlers(courier.GetHandler("MCK")))
+	require.NoError(t, err)
+
+	// a received message is relayed to the channel's own relay URL and signed with its secret
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+
+	select {
+	case r := <-requests:
+		body := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(r.body), &body))
+		assert.NotEmpty(t, body["created_on"])
+		delete(body, "created_on")
+
+		assert.Equal(t, "/channel", r.path)
+		assert.Equal(t, map[string]any{
+			"type":         "msg_in",
+			"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230",
+			"channel_type": "MCK",
+			"msg":          map[string]any{"uuid": string(mb.WrittenMsgs()[0].UUID()), "urn": "tel:2065551212", "text": "hello"},
+		}, body)
+		assert.Equal(t, courier.RelaySignature("sesame", []byte(r.body)), r.signature)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not relayed")
+	}
+
+	// channels without their own relay URL use the global one, which is retried if it fails
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/c/mck/53e5aafa-8155-449d-9009-fcb30d54bd26/receive?from=2065551212&text=hola", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+
+	select {
+	case r := <-requests:
+		assert.Equal(t, "/global", r.path)
+		assert.Contains(t, r.body, `"text":"hola"`)
+		assert.Equal(t, "", r.signature)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not relayed")
+	}
+	assert.Equal(t, 0, failures)
+
+	// a request which the handler rejects isn't relayed
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil)
+	s.Router().ServeHTTP(recorder, req)
+	assert.Equal(t, 400, recorder.Code)
+
+	select {
+	case r := <-requests:
+		assert.Fail(t, "unexpected relayed request", "got %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestQualityPolling(t *testing.T) {
+	config := testConfig()
+	config.QualityInterval = 1
+
+	mb := test.NewMockBackend()
+	mb.AddChannel(test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"quality_rating": "YELLOW"}))
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	// nothing checked until the first interval has passed
+	assert.Nil(t, mb.ChannelQuality("e4bb1578-29da-4fa5-a214-9da19dd24230"))
+
+	time.Sleep(1500 * time.Millisecond)
+
+	// channels are checked without having received anything
+	quality := mb.ChannelQuality("e4bb1578-29da-4fa5-a214-9da19dd24230")
+	if assert.NotNil(t, quality) {
+		assert.Equal(t, "YELLOW", quality.Rating)
+	}
+}
+
 func TestOutgoing(t *testing.T) {
 	defer httpx.SetRequestor(httpx.DefaultRequestor)
 	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
@@ -125,6 +373,14 @@ func TestOutgoing(t *testing.T) {
 			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
 			httpx.NewMockResponse(429, nil, []byte(`too much!`)),
 			httpx.NewMockResponse(403, nil, []byte(`stop!`)),
+			httpx.NewMockResponse(404, nil, []byte(`who?`)),
+			httpx.NewMockResponse(402, nil, []byte(`no credit!`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
 		},
 	}))
 
@@ -221,6 +477,277 @@ func TestOutgoing(t *testing.T) {
 	assert.Equal(t, 1, len(mb.WrittenChannelEvents()))
 	assert.Equal(t, courier.EventTypeStopContact, mb.WrittenChannelEvents()[0].EventType())
 	mb.Reset()
+
+	// send message which will have mocked contact-unknown error
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(120), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "6a", nil))
+
+	// message should be marked as failed (non-retryable)
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[0].Status())
+
+	// and rather than stopping the contact, we should have flagged their URN
+	assert.Equal(t, 1, len(mb.WrittenChannelEvents()))
+	assert.Equal(t, courier.EventTypeURNDeactivated, mb.WrittenChannelEvents()[0].EventType())
+	mb.Reset()
+
+	// send message which will have mocked insufficient balance error
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(107), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "7", nil))
+
+	// message should stay queued rather than errored and have been put back on the queue to be held with the others
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusQueued, mb.WrittenMsgStatuses()[0].Status())
+
+	if assert.Len(t, mb.RequeuedMsgs(), 1) {
+		assert.Equal(t, courier.MsgID(107), mb.RequeuedMsgs()[0].Msg.ID())
+		assert.Equal(t, time.Duration(0), mb.RequeuedMsgs()[0].Delay)
+	}
+
+	// and we should have paused the channel's queue without writing any contact events
+	assert.Equal(t, 0, len(mb.WrittenChannelEvents()))
+
+	queues, err := mb.Queues(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.QueueInfo{{ChannelUUID: "e4bb1578-29da-4fa5-a214-9da19dd24230", Paused: true}}, queues)
+
+	// so further messages are held
+	mb.PushOutgoingMsg(test.NewMockMsg(courier.MsgID(108), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "8", nil))
+	time.Sleep(time.Millisecond * 100)
+
+	sent, err := mb.WasMsgSent(context.Background(), courier.MsgID(108))
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	// purge held message so it isn't sent when reset unpauses the queue
+	_, err = mb.PurgeQueue(context.Background(), mockChannel.UUID())
+	assert.NoError(t, err)
+	mb.Reset()
+
+	// send message which will have mocked unverified channel error
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(109), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:unverified", nil))
+
+	// message should stay queued rather than errored and have been put back on the queue to be held until the channel
+	// is verified
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusQueued, mb.WrittenMsgStatuses()[0].Status())
+
+	if assert.Len(t, mb.RequeuedMsgs(), 1) {
+		assert.Equal(t, courier.MsgID(109), mb.RequeuedMsgs()[0].Msg.ID())
+		assert.Equal(t, time.Duration(0), mb.RequeuedMsgs()[0].Delay)
+	}
+
+	// and the channel's queue paused
+	queues, err = mb.Queues(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.QueueInfo{{ChannelUUID: "e4bb1578-29da-4fa5-a214-9da19dd24230", Paused: true}}, queues)
+	mb.Reset()
+
+	// send message which the channel asks us to retry later
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(110), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:retry", nil))
+
+	// message should remain queued rather than errored and have been put back on the queue with the requested delay
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusQueued, mb.WrittenMsgStatuses()[0].Status())
+
+	if assert.Len(t, mb.RequeuedMsgs(), 1) {
+		assert.Equal(t, courier.MsgID(110), mb.RequeuedMsgs()[0].Msg.ID())
+		assert.Equal(t, 30*time.Second, mb.RequeuedMsgs()[0].Delay)
+	}
+
+	clog = mb.WrittenChannelLogs()[0]
+	assert.Equal(t, clogs.NewLogError("retry_after", "", "Channel asked for send to be retried after 30s."), clog.Errors[len(clog.Errors)-1])
+	mb.Reset()
+
+	// send message which crashes the handler, which should be errored so that it's retried
+	poisonMsg := test.NewMockMsg(courier.MsgID(111), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "err:panic", nil)
+	sendAndWait(mb, poisonMsg)
+
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, courier.NilMsgFailedReason, mb.WrittenMsgStatuses()[0].FailedReason())
+
+	clog = mb.WrittenChannelLogs()[0]
+	assert.Equal(t, courier.ErrorHandlerPanic(), clog.Errors[len(clog.Errors)-1])
+	mb.Reset()
+
+	// until it has crashed the handler enough times to be failed as poison (errored statuses clear the sent flag in a
+	// real backend so that the message can be retried)
+	mb.ClearMsgSent(context.Background(), poisonMsg.ID())
+	sendAndWait(mb, poisonMsg)
+	mb.ClearMsgSent(context.Background(), poisonMsg.ID())
+	sendAndWait(mb, poisonMsg)
+
+	assert.Equal(t, 2, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[1].Status())
+	assert.Equal(t, courier.MsgFailedReasonPoison, mb.WrittenMsgStatuses()[1].FailedReason())
+
+	clog = mb.WrittenChannelLogs()[1]
+	assert.Equal(t, courier.ErrorPoisonMsg(3), clog.Errors[len(clog.Errors)-1])
+
+	deadLetters, err := mb.DeadLetters(context.Background(), mockChannel.UUID())
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, courier.MsgID(111), deadLetters[0].MsgID)
+	}
+
+	// and the sender is still sending messages
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(112), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil))
+
+	assert.Equal(t, 3, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[2].Status())
+	mb.Reset()
+
+	// a panic outside of the handler still completes the send, as errored so that it's retried
+	panicChannel := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{"redact_panic": true})
+	sendAndWait(mb, test.NewMockMsg(courier.MsgID(113), courier.NilMsgUUID, panicChannel, "tel:+250788383383", "test message", nil))
+
+	assert.Equal(t, 1, len(mb.WrittenMsgStatuses()))
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+
+	clog = mb.WrittenChannelLogs()[0]
+	assert.Equal(t, []*clogs.LogError{courier.ErrorHandlerPanic()}, clog.Errors)
+	mb.Reset()
+}
+
+func TestOutgoingBulk(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/read/ext1": {
+			httpx.NewMockResponse(200, nil, []byte(`READ`)),
+		},
+		"http://mock.com/send_bulk": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	}))
+
+	mb := test.NewMockBackend()
+	bulkChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigMaxBulkSize:    5,
+		courier.ConfigMarkRead:       true,
+		courier.ConfigSenderPool:     []any{"2021", "2022"},
+		courier.ConfigSenderPoolMode: courier.SenderPoolRoundRobin,
+	})
+	mb.AddChannel(bulkChannel)
+
+	// queue up 3 messages before starting the server so they're all available to be sent together
+	msg1 := mb.NewOutgoingMsg(bulkChannel, 101, "tel:+250788383383", "one", false, nil, "", "ext1", courier.MsgOriginFlow, nil)
+	msg2 := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, bulkChannel, "tel:+250788383384", "err:invalid", nil)
+	msg3 := test.NewMockMsg(courier.MsgID(103), courier.NilMsgUUID, bulkChannel, "tel:+250788383385", "three", nil)
+	mb.PushOutgoingMsg(msg1)
+	mb.PushOutgoingMsg(msg2)
+	mb.PushOutgoingMsg(msg3)
+
+	// use a single worker so that messages aren't popped by other workers before they can be batched
+	config := testConfig()
+	config.MaxWorkers = 1
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for {
+		time.Sleep(time.Millisecond * 25)
+
+		if sent, _ := mb.WasMsgSent(ctx, msg3.ID()); sent {
+			break
+		}
+	}
+
+	// should have 3 statuses but they share a single channel log
+	require.Len(t, mb.WrittenMsgStatuses(), 3)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, "ext-101", mb.WrittenMsgStatuses()[0].ExternalID())
+	assert.Equal(t, courier.MsgStatusFailed, mb.WrittenMsgStatuses()[1].Status())
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[2].Status())
+	assert.Equal(t, "ext-103", mb.WrittenMsgStatuses()[2].ExternalID())
+
+	// the reply is marked as read and each message is sent from the next number of the channel's pool
+	require.Len(t, mb.WrittenChannelLogs(), 1)
+	clog := mb.WrittenChannelLogs()[0]
+	require.Len(t, clog.HttpLogs, 2)
+	assert.Equal(t, "http://mock.com/read/ext1", clog.HttpLogs[0].URL)
+	assert.Equal(t, "http://mock.com/send_bulk", clog.HttpLogs[1].URL)
+	assert.True(t, strings.HasSuffix(clog.HttpLogs[1].Request, "2021,2022,2021"))
+	assert.Equal(t, []*clogs.LogError{clogs.NewLogError("message_invalid", "", "Message is missing required values.")}, clog.Errors)
+}
+
+func TestOutgoingBulkPanic(t *testing.T) {
+	mb := test.NewMockBackend()
+	bulkChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxBulkSize: 5, "redact_panic": true})
+	mb.AddChannel(bulkChannel)
+
+	msg1 := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, bulkChannel, "tel:+250788383383", "one", nil)
+	msg2 := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, bulkChannel, "tel:+250788383384", "two", nil)
+	mb.PushOutgoingMsg(msg1)
+	mb.PushOutgoingMsg(msg2)
+
+	config := testConfig()
+	config.MaxWorkers = 1
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	// a panic outside of the handler still completes every message of the batch, as errored so that they're retried
+	require.Eventually(t, func() bool {
+		sent1, _ := mb.WasMsgSent(context.Background(), msg1.ID())
+		sent2, _ := mb.WasMsgSent(context.Background(), msg2.ID())
+		return sent1 && sent2
+	}, time.Second, 25*time.Millisecond)
+
+	require.Len(t, mb.WrittenMsgStatuses(), 2)
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[1].Status())
+}
+
+func TestOutgoingBulkHandlerPanic(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send_bulk": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	}))
+
+	mb := test.NewMockBackend()
+	bulkChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMaxBulkSize: 5})
+	mb.AddChannel(bulkChannel)
+
+	goodMsg := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, bulkChannel, "tel:+250788383383", "one", nil)
+	poisonMsg := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, bulkChannel, "tel:+250788383384", "err:panic", nil)
+	mb.PushOutgoingMsg(goodMsg)
+	mb.PushOutgoingMsg(poisonMsg)
+
+	config := testConfig()
+	config.MaxWorkers = 1
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	require.Eventually(t, func() bool {
+		sent1, _ := mb.WasMsgSent(context.Background(), goodMsg.ID())
+		sent2, _ := mb.WasMsgSent(context.Background(), poisonMsg.ID())
+		return sent1 && sent2
+	}, time.Second, 25*time.Millisecond)
+
+	// when the handler panics, the messages are sent individually so the good message is still sent
+	require.Len(t, mb.WrittenMsgStatuses(), 2)
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	assert.Equal(t, courier.MsgStatusErrored, mb.WrittenMsgStatuses()[1].Status())
+
+	// and only the message which crashed the handler has that counted against it
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	assertredis.NotExists(t, rc, "send-panics:101")
+	assertredis.Get(t, rc, "send-panics:102", "1")
 }
 
 func TestFetchAttachment(t *testing.T) {
@@ -229,9 +756,11 @@ func TestFetchAttachment(t *testing.T) {
 	httpMocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
 		"http://mock.com/media/hello.jpg": {
 			httpx.NewMockResponse(200, nil, testJPG),
+			httpx.NewMockResponse(200, nil, testJPG),
 		},
 		"http://mock.com/media/hello.mp3": {
 			httpx.NewMockResponse(404, nil, []byte(`No such file`)),
+			httpx.NewMockResponse(404, nil, []byte(`No such file`)),
 		},
 		"http://mock.com/media/hello.pdf": {
 			httpx.MockConnectionError,
@@ -245,21 +774,17 @@ func TestFetchAttachment(t *testing.T) {
 	defer uuids.SetGenerator(uuids.DefaultGenerator)
 	uuids.SetGenerator(uuids.NewSeededGenerator(1234, dates.NewSequentialNow(time.Date(2024, 9, 11, 14, 33, 0, 0, time.UTC), time.Second)))
 
-	logger := slog.Default()
 	config := courier.NewDefaultConfig()
 	config.AuthToken = "sesame"
 	config.Port = 8081
+	config.AttachmentFetchWorkers = 1 // so that UUIDs are generated in a predictable order
 
 	mb := test.NewMockBackend()
 	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
 	mb.AddChannel(mockChannel)
 
-	server := courier.NewServerWithLogger(config, mb, logger)
-	server.Start()
-	defer server.Stop()
-
-	// wait for server to come up
-	time.Sleep(100 * time.Millisecond)
+	stop := startTestServer(t, config, mb)
+	defer stop()
 
 	submit := func(body, authToken string) (int, []byte) {
 		req, _ := http.NewRequest("POST", "http://localhost:8081/c/_fetch-attachment", strings.NewReader(body))
@@ -310,20 +835,1090 @@ func TestFetchAttachment(t *testing.T) {
 	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK", "url": "http://mock.com/media/hello.pdf"}`, "sesame")
 	assert.Equal(t, 200, statusCode)
 	assert.JSONEq(t, `{"attachment": {"content_type": "unavailable", "url": "http://mock.com/media/hello.pdf", "size": 0}, "log_uuid": "0191e180-8530-7000-8ef6-384876655d1b"}`, string(respBody))
+
+	// multiple attachments can be fetched at once, with any that can't be fetched returned as unavailable
+	statusCode, respBody = submit(`{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "channel_type": "MCK", "urls": ["http://mock.com/media/hello.jpg", "http://mock.com/media/hello.mp3"]}`, "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"attachments": [
+		{"attachment": {"content_type": "image/jpeg", "url": "https://backend.com/attachments/37c5fddb-8512-4a80-8c21-38b6e22ef940.jpg", "size": 17301}, "log_uuid": "0191e180-8918-7000-8ab6-9b9af5cd042a"},
+		{"attachment": {"content_type": "unavailable", "url": "http://mock.com/media/hello.mp3", "size": 0}, "log_uuid": "0191e180-8d00-7000-994d-0359ae4cd48e"}
+	]}`, string(respBody))
+	assert.Len(t, mb.WrittenChannelLogs(), 5)
 }
 
-// utility to send a message on a mocked backend and block until it's marked as sent
-func sendAndWait(mb *test.MockBackend, m courier.MsgOut) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+func TestQueueAdmin(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
 
-	mb.PushOutgoingMsg(m)
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+	newChannel := test.NewMockChannel("b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(newChannel)
 
-	for {
-		time.Sleep(time.Millisecond * 25)
+	stop := startTestServer(t, config, mb)
+	defer stop()
 
-		if sent, _ := mb.WasMsgSent(ctx, m.ID()); sent {
-			return
+	request := func(method, url, authToken string) (int, string) {
+		req, _ := http.NewRequest(method, url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
 		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
 	}
+
+	// can't access without auth
+	statusCode, respBody := request("GET", "http://localhost:8081/admin/queues", "")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queues": []}`, respBody)
+
+	// pause our channel so that messages stay queued
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/pause", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"paused": true}`, respBody)
+
+	mb.PushOutgoingMsg(test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "hello", nil))
+	mb.PushOutgoingMsg(test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "world", nil))
+
+	// give the sender a chance to try to send them
+	time.Sleep(100 * time.Millisecond)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queues": [{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "tps": 0, "size": 2, "bulk_size": 0, "workers": 0, "paused": true}]}`, respBody)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230?limit=1", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queue": {"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "tps": 0, "size": 2, "bulk_size": 0, "workers": 0, "paused": true}, "pending": [{"id": 101, "text": "hello"}]}`, respBody)
+
+	// channels without queues are reported as empty
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues/7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queue": {"channel_uuid": "7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3", "tps": 0, "size": 0, "bulk_size": 0, "workers": 0, "paused": false}, "pending": []}`, respBody)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues/xyz", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid channel UUID")
+
+	// pause the channel we're migrating to so that drained messages also stay queued
+	statusCode, _ = request("POST", "http://localhost:8081/admin/queues/b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77/pause", "sesame")
+	assert.Equal(t, 200, statusCode)
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/drain", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid target channel UUID")
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/drain?to=e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "can't drain a queue into itself")
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/drain?to=7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "target channel not found")
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/drain?to=b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"drained": 2}`, respBody)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queues": [
+		{"channel_uuid": "b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77", "tps": 0, "size": 2, "bulk_size": 0, "workers": 0, "paused": true},
+		{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "tps": 0, "size": 0, "bulk_size": 0, "workers": 0, "paused": true}
+	]}`, respBody)
+
+	statusCode, respBody = request("DELETE", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"purged": 0}`, respBody)
+
+	statusCode, respBody = request("DELETE", "http://localhost:8081/admin/queues/b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"purged": 2}`, respBody)
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/queues/e4bb1578-29da-4fa5-a214-9da19dd24230/resume", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"paused": false}`, respBody)
+
+	statusCode, _ = request("POST", "http://localhost:8081/admin/queues/b0f3c5a2-8e0e-4b7a-9d0a-3c2f5e1d6a77/resume", "sesame")
+	assert.Equal(t, 200, statusCode)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/queues", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"queues": []}`, respBody)
+
+	// nothing was sent
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(method, url, authToken string) (int, []byte) {
+		req, _ := http.NewRequest(method, url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	// fail a message so that it becomes a dead letter
+	msg := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil)
+	clog := courier.NewChannelLogForSend(msg, nil)
+	mb.OnSendComplete(ctx, msg, mb.NewStatusUpdate(mockChannel, msg.ID(), courier.MsgStatusFailed, clog), clog)
+
+	// can't list without auth
+	statusCode, respBody := request("GET", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230", "")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", string(respBody))
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, string(respBody), `"msg_id":101`)
+	assert.Contains(t, string(respBody), `"log_uuid":"`+string(clog.UUID)+`"`)
+
+	// can't requeue without auth
+	statusCode, _ = request("POST", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230/requeue", "")
+	assert.Equal(t, 401, statusCode)
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230/requeue", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"requeued": 1}`, string(respBody))
+
+	// message is no longer considered sent so it can be sent again
+	sent, err := mb.WasMsgSent(ctx, msg.ID())
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"dead_letters": []}`, string(respBody))
+}
+
+func TestRecentLogs(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(url, authToken string) (int, []byte) {
+		req, _ := http.NewRequest("GET", url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, trace.ResponseBody
+	}
+
+	clog1 := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, mockChannel, []string{"sesame"})
+	clog1.CreatedOn = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	clog2 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mockChannel, []string{"sesame"})
+	clog2.CreatedOn = time.Date(2026, 10, 1, 13, 0, 0, 0, time.UTC)
+	clog2.Error(clogs.NewLogError("", "", "bad token sesame"))
+	clog3 := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, mockChannel, nil)
+	clog3.CreatedOn = time.Date(2026, 10, 1, 14, 0, 0, 0, time.UTC)
+
+	for _, clog := range []*courier.ChannelLog{clog1, clog2, clog3} {
+		require.NoError(t, mb.WriteChannelLog(ctx, clog))
+	}
+
+	logUUIDs := func(body []byte) []string {
+		resp := &struct {
+			Logs []struct {
+				UUID string `json:"uuid"`
+			} `json:"logs"`
+		}{}
+		require.NoError(t, json.Unmarshal(body, resp))
+		uuids := make([]string, len(resp.Logs))
+		for i, l := range resp.Logs {
+			uuids[i] = l.UUID
+		}
+		return uuids
+	}
+
+	// can't list without auth
+	statusCode, _ := request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230", "")
+	assert.Equal(t, 401, statusCode)
+
+	statusCode, respBody := request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []string{string(clog3.UUID), string(clog2.UUID), string(clog1.UUID)}, logUUIDs(respBody))
+	assert.Contains(t, string(respBody), `"message":"bad token **********"`)
+	assert.NotContains(t, string(respBody), "sesame")
+
+	statusCode, respBody = request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230?type=msg_send&limit=1", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []string{string(clog3.UUID)}, logUUIDs(respBody))
+
+	statusCode, respBody = request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230?errors=true", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []string{string(clog2.UUID)}, logUUIDs(respBody))
+
+	statusCode, respBody = request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230?after=2026-10-01T12:30:00Z&before=2026-10-01T14:00:00Z", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []string{string(clog2.UUID)}, logUUIDs(respBody))
+
+	// other channels have no logs
+	statusCode, respBody = request("http://localhost:8081/admin/logs/53e5aafa-8155-449d-9009-fcb30d54bd26", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"logs": []}`, string(respBody))
+
+	statusCode, _ = request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230?after=yesterday", "sesame")
+	assert.Equal(t, 400, statusCode)
+
+	statusCode, _ = request("http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230?errors=maybe", "sesame")
+	assert.Equal(t, 400, statusCode)
+
+	statusCode, _ = request("http://localhost:8081/admin/logs/xyz", "sesame")
+	assert.Equal(t, 400, statusCode)
+}
+
+func TestArchivedMsgs(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mb.AddArchivedMsg(&courier.ArchivedMsg{
+		UUID:        "0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b",
+		ChannelUUID: "e4bb1578-29da-4fa5-a214-9da19dd24230",
+		URN:         "tel:+250788383383",
+		Text:        "Your order has shipped",
+		Attachments: []string{"image/jpeg:https://example.com/receipt.jpg"},
+		Status:      courier.MsgStatusDelivered,
+		CreatedOn:   time.Date(2025, 10, 1, 12, 30, 0, 0, time.UTC),
+	})
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(method, url, authToken, body string) (int, string) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	// can't read without auth
+	statusCode, respBody := request("GET", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b", "", "")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/msgs/xyz", "sesame", "")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid message UUID")
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/msgs/7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3", "sesame", "")
+	assert.Equal(t, 404, statusCode)
+	assert.Contains(t, respBody, "message not found")
+
+	statusCode, respBody = request("GET", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b", "sesame", "")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"msg": {
+		"uuid": "0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b",
+		"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230",
+		"urn": "tel:+250788383383",
+		"text": "Your order has shipped",
+		"attachments": ["image/jpeg:https://example.com/receipt.jpg"],
+		"quick_replies": null,
+		"status": "D",
+		"created_on": "2025-10-01T12:30:00Z",
+		"sent_on": null
+	}}`, respBody)
+
+	// can't resend without auth
+	statusCode, _ = request("POST", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/resend", "", "")
+	assert.Equal(t, 401, statusCode)
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/msgs/7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3/resend", "sesame", "")
+	assert.Equal(t, 404, statusCode)
+	assert.Contains(t, respBody, "message not found")
+
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/resend", "sesame", `{"urn": "xyz"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid URN")
+
+	// resend to the original URN
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/resend", "sesame", "")
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"urn":"tel:+250788383383"`)
+
+	// and to a different URN
+	statusCode, respBody = request("POST", "http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/resend", "sesame", `{"urn": "tel:+250788383384"}`)
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"urn":"tel:+250788383384"`)
+
+	if assert.Len(t, mb.ResentMsgs(), 2) {
+		assert.Equal(t, urns.URN("tel:+250788383383"), mb.ResentMsgs()[0].URN)
+		assert.Equal(t, urns.URN("tel:+250788383384"), mb.ResentMsgs()[1].URN)
+		assert.Equal(t, "Your order has shipped", mb.ResentMsgs()[1].Text)
+	}
+}
+
+func TestMsgTimeline(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mb.AddMsgTimeline(&courier.MsgTimeline{
+		MsgUUID:     "0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b",
+		ChannelUUID: "e4bb1578-29da-4fa5-a214-9da19dd24230",
+		Status:      courier.MsgStatusDelivered,
+		Events: []*courier.MsgTimelineEvent{
+			{Type: courier.MsgTimelineQueued, CreatedOn: time.Date(2025, 10, 1, 12, 30, 0, 0, time.UTC)},
+			{Type: courier.MsgTimelinePopped, CreatedOn: time.Date(2025, 10, 1, 12, 30, 1, 0, time.UTC)},
+			{Type: courier.MsgTimelineSendAttempt, CreatedOn: time.Date(2025, 10, 1, 12, 30, 2, 0, time.UTC), Status: courier.MsgStatusWired, LogUUID: "0199a7b6-0000-7000-8000-000000000001", ElapsedMS: 345},
+			{Type: courier.MsgTimelineStatus, CreatedOn: time.Date(2025, 10, 1, 12, 30, 5, 0, time.UTC), Status: courier.MsgStatusDelivered, LogUUID: "0199a7b6-0000-7000-8000-000000000002", Request: "POST /c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/status HTTP/1.1\r\n\r\n"},
+		},
+	})
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(url, authToken string) (int, string) {
+		req, _ := http.NewRequest("GET", url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	statusCode, respBody := request("http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/timeline", "")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	statusCode, respBody = request("http://localhost:8081/admin/msgs/xyz/timeline", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid message UUID")
+
+	statusCode, respBody = request("http://localhost:8081/admin/msgs/7c9c9a1b-1a5e-4bbb-9a14-5c1bbf7e4ca3/timeline", "sesame")
+	assert.Equal(t, 404, statusCode)
+	assert.Contains(t, respBody, "message not found")
+
+	statusCode, respBody = request("http://localhost:8081/admin/msgs/0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b/timeline", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.JSONEq(t, `{"timeline": {
+		"msg_uuid": "0199a7b5-8d1c-7a4e-9f3b-2c6d4e8f1a2b",
+		"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230",
+		"status": "D",
+		"events": [
+			{"type": "queued", "created_on": "2025-10-01T12:30:00Z"},
+			{"type": "popped", "created_on": "2025-10-01T12:30:01Z"},
+			{"type": "send_attempt", "created_on": "2025-10-01T12:30:02Z", "status": "W", "log_uuid": "0199a7b6-0000-7000-8000-000000000001", "elapsed_ms": 345},
+			{"type": "status", "created_on": "2025-10-01T12:30:05Z", "status": "D", "log_uuid": "0199a7b6-0000-7000-8000-000000000002", "request": "POST /c/mck/e4bb1578-29da-4fa5-a214-9da19dd24230/status HTTP/1.1\r\n\r\n"}
+		]
+	}}`, respBody)
+}
+
+// utility to send a message on a mocked backend and block until it's marked as sent
+func TestOutgoingMarkRead(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/read/*": {
+			httpx.NewMockResponse(200, nil, []byte(`READ`)),
+			httpx.NewMockResponse(400, nil, []byte(`no such message`)),
+		},
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	}))
+
+	mb := test.NewMockBackend()
+	s := courier.NewServer(testConfig(), mb)
+
+	s.Start()
+	defer s.Stop()
+
+	readChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{courier.ConfigMarkRead: true})
+	otherChannel := test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(readChannel)
+	mb.AddChannel(otherChannel)
+
+	// reply on a channel with mark_read enabled marks the incoming message as read before sending
+	msg := mb.NewOutgoingMsg(readChannel, 101, "tel:+250788383383", "thanks", false, nil, "", "ext1", courier.MsgOriginFlow, nil)
+	sendAndWait(mb, msg)
+
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+	clog := mb.WrittenChannelLogs()[0]
+	assert.Len(t, clog.HttpLogs, 2)
+	assert.Equal(t, "http://mock.com/read/ext1", clog.HttpLogs[0].URL)
+	assert.Equal(t, "http://mock.com/send", clog.HttpLogs[1].URL)
+	mb.Reset()
+
+	// failing to mark as read doesn't stop the reply being sent
+	msg = mb.NewOutgoingMsg(readChannel, 102, "tel:+250788383383", "thanks", false, nil, "", "ext2", courier.MsgOriginFlow, nil)
+	sendAndWait(mb, msg)
+
+	assert.Equal(t, courier.MsgStatusWired, mb.WrittenMsgStatuses()[0].Status())
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 2)
+	mb.Reset()
+
+	// messages which aren't replies aren't marked as read
+	msg = mb.NewOutgoingMsg(readChannel, 103, "tel:+250788383383", "hello", false, nil, "", "", courier.MsgOriginFlow, nil)
+	sendAndWait(mb, msg)
+
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 1)
+	mb.Reset()
+
+	// and neither are replies on channels without mark_read
+	msg = mb.NewOutgoingMsg(otherChannel, 104, "tel:+250788383383", "thanks", false, nil, "", "ext3", courier.MsgOriginFlow, nil)
+	sendAndWait(mb, msg)
+
+	assert.Len(t, mb.WrittenChannelLogs()[0].HttpLogs, 1)
+	mb.Reset()
+}
+
+func sendAndWait(mb *test.MockBackend, m courier.MsgOut) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	mb.PushOutgoingMsg(m)
+
+	for {
+		time.Sleep(time.Millisecond * 25)
+
+		if sent, _ := mb.WasMsgSent(ctx, m.ID()); sent {
+			return
+		}
+	}
+}
+
+func TestPreviewMsg(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	})
+	mockHTTP.SetIgnoreLocal(true)
+	httpx.SetRequestor(mockHTTP)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	request := func(url, authToken, body string) (int, string) {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	// can't access without auth
+	statusCode, respBody := request("http://localhost:8081/admin/preview/e4bb1578-29da-4fa5-a214-9da19dd24230", "", `{"urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	// invalid channel UUID
+	statusCode, respBody = request("http://localhost:8081/admin/preview/xyz", "sesame", `{"urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid channel UUID")
+
+	// non-existent channel
+	statusCode, respBody = request("http://localhost:8081/admin/preview/a984069d-0008-4d8c-a772-b14a8a6acccc", "sesame", `{"urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "no such channel")
+
+	// invalid message
+	statusCode, respBody = request("http://localhost:8081/admin/preview/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame", `{"text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "field 'urn' required")
+
+	statusCode, respBody = request("http://localhost:8081/admin/preview/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame", `{"urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"url":"http://mock.com/send"`)
+	assert.Contains(t, respBody, `Authorization: Token **********`)
+	assert.Contains(t, respBody, `"errors":[{"code":"seeds","message":"contains ********** seeds"}]`)
+	assert.NotContains(t, respBody, "sesame")
+
+	// nothing should have been written
+	assert.Len(t, mb.WrittenMsgStatuses(), 0)
+	assert.Len(t, mb.WrittenChannelLogs(), 0)
+}
+
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.AuthToken = "sesame"
+	config.MaxWorkers = 0 // so that queued messages aren't sent
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	request := func(authToken, body string) (int, string) {
+		req, _ := http.NewRequest("POST", "http://localhost:8081/api/v1/send", strings.NewReader(body))
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	// can't access without auth
+	statusCode, respBody := request("", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	// invalid requests
+	statusCode, respBody = request("sesame", `{"channel_uuid": "xyz", "urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "field 'channeluuid' uuid")
+
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "field 'urn' required")
+
+	// non-existent channel
+	statusCode, respBody = request("sesame", `{"channel_uuid": "a984069d-0008-4d8c-a772-b14a8a6acccc", "urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "no such channel")
+
+	// messages are validated like those from the gRPC API
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788383383"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "message must have text or attachments")
+
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "telegram:12345", "text": "hi"}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "URN scheme telegram not supported by channel")
+
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788383383", "attachments": ["test.jpg"]}`)
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "invalid attachment: test.jpg")
+
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788383383", "text": "hi", "attachments": ["image/jpeg:https://example.com/test.jpg"], "quick_replies": ["Yes", "No"]}`)
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"id":1`)
+
+	msg, err := mb.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.MsgID(1), msg.ID())
+	assert.Contains(t, respBody, string(msg.UUID()))
+	assert.Equal(t, mockChannel, msg.Channel())
+	assert.Equal(t, urns.URN("tel:+250788383383"), msg.URN())
+	assert.Equal(t, "hi", msg.Text())
+	assert.Equal(t, []string{"image/jpeg:https://example.com/test.jpg"}, msg.Attachments())
+	assert.Equal(t, []string{"Yes", "No"}, msg.QuickReplies())
+
+	// backend errors are returned as server errors
+	mb.SetErrorOnQueue(true)
+	statusCode, respBody = request("sesame", `{"channel_uuid": "e4bb1578-29da-4fa5-a214-9da19dd24230", "urn": "tel:+250788383383", "text": "hi"}`)
+	assert.Equal(t, 500, statusCode)
+	assert.Contains(t, respBody, "error queueing message")
+}
+
+func TestHealth(t *testing.T) {
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	mockRequestor := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.MockConnectionError,
+		},
+	})
+	mockRequestor.SetIgnoreLocal(true)
+	httpx.SetRequestor(mockRequestor)
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	type healthResponse struct {
+		Status   string                   `json:"status"`
+		Checks   []*courier.HealthCheck   `json:"checks"`
+		Handlers []*courier.HandlerHealth `json:"handlers"`
+	}
+
+	// health doesn't need auth so that it can be used by load balancers, but admin health includes errors
+	requestURL := func(url string, token string) (int, *healthResponse, *courier.HandlerHealth) {
+		req, _ := http.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+
+		resp := &healthResponse{}
+		require.NoError(t, json.Unmarshal(trace.ResponseBody, resp))
+
+		i := slices.IndexFunc(resp.Handlers, func(h *courier.HandlerHealth) bool { return h.ChannelType == "MCK" })
+		require.NotEqual(t, -1, i)
+
+		return trace.Response.StatusCode, resp, resp.Handlers[i]
+	}
+	request := func() (int, *healthResponse, *courier.HandlerHealth) {
+		return requestURL("http://localhost:8081/health", "")
+	}
+
+	// sends the given message and waits for its status to be written
+	send := func(m courier.MsgOut) {
+		mb.PushOutgoingMsg(m)
+		require.Eventually(t, func() bool {
+			return slices.ContainsFunc(mb.WrittenMsgStatuses(), func(s courier.StatusUpdate) bool { return s.MsgID() == m.ID() })
+		}, time.Second, 25*time.Millisecond)
+	}
+
+	statusCode, resp, mck := request()
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "ok", resp.Status)
+	assert.Len(t, resp.Checks, 1)
+	assert.Equal(t, "valkey", resp.Checks[0].Name)
+	assert.True(t, resp.Checks[0].Healthy)
+
+	// handlers without any sends are healthy
+	assert.Equal(t, "Mock Handler", mck.Name)
+	assert.True(t, mck.Healthy)
+	assert.Nil(t, mck.LastSentOn)
+	assert.Nil(t, mck.LastFailedOn)
+
+	send(test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil))
+
+	statusCode, _, mck = request()
+	assert.Equal(t, 200, statusCode)
+	assert.True(t, mck.Healthy)
+	assert.NotNil(t, mck.LastSentOn)
+	assert.Nil(t, mck.LastFailedOn)
+
+	// a failing send makes the handler unhealthy but not courier itself
+	send(test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil))
+
+	statusCode, resp, mck = request()
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "ok", resp.Status)
+	assert.False(t, mck.Healthy)
+	assert.NotNil(t, mck.LastSentOn)
+	assert.NotNil(t, mck.LastFailedOn)
+
+	// but a failing dependency does
+	mb.SetUnhealthy("db", errors.New("connection refused"))
+
+	statusCode, resp, _ = request()
+	assert.Equal(t, 503, statusCode)
+	assert.Equal(t, "unhealthy", resp.Status)
+	assert.Len(t, resp.Checks, 2)
+	assert.Equal(t, "db", resp.Checks[1].Name)
+	assert.False(t, resp.Checks[1].Healthy)
+	assert.Equal(t, "", resp.Checks[1].Error)
+
+	statusCode, resp, _ = requestURL("http://localhost:8081/admin/health", "sesame")
+	assert.Equal(t, 503, statusCode)
+	assert.Equal(t, "connection refused", resp.Checks[1].Error)
+
+	req, _ := http.NewRequest("GET", "http://localhost:8081/admin/health", nil)
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 401, trace.Response.StatusCode)
+
+	mb.SetUnhealthy("db", nil)
+
+	statusCode, _, _ = request()
+	assert.Equal(t, 200, statusCode)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mockRequestor := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+			httpx.NewMockResponse(400, nil, []byte(`bad URN`)), // not a provider failure
+			httpx.NewMockResponse(500, nil, []byte(`oops`)),
+			httpx.MockConnectionError,                              // opens circuit
+			httpx.NewMockResponse(503, nil, []byte(`unavailable`)), // probe fails
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),        // probe succeeds
+			httpx.NewMockResponse(200, nil, []byte(`SENT`)),
+		},
+	})
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(mockRequestor)
+
+	config := testConfig()
+	config.CircuitErrorRate = 50
+	config.CircuitMinSends = 4
+	config.CircuitCooldown = 1
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	s := courier.NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	nextID := courier.MsgID(100)
+
+	// sends a message and returns its status and the errors in its channel log
+	send := func() (courier.MsgStatus, []string) {
+		nextID++
+		m := test.NewMockMsg(nextID, courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil)
+		mb.PushOutgoingMsg(m)
+
+		var status courier.StatusUpdate
+		require.Eventually(t, func() bool {
+			i := slices.IndexFunc(mb.WrittenMsgStatuses(), func(s courier.StatusUpdate) bool { return s.MsgID() == m.ID() })
+			if i >= 0 {
+				status = mb.WrittenMsgStatuses()[i]
+			}
+			return i >= 0 && len(mb.WrittenChannelLogs()) == len(mb.WrittenMsgStatuses())
+		}, time.Second, 25*time.Millisecond)
+
+		clog := mb.WrittenChannelLogs()[len(mb.WrittenChannelLogs())-1]
+		codes := make([]string, len(clog.Errors))
+		for i, e := range clog.Errors {
+			codes[i] = e.Code
+		}
+		return status.Status(), codes
+	}
+
+	status, errs := send()
+	assert.Equal(t, courier.MsgStatusWired, status)
+	assert.NotContains(t, errs, "circuit_opened")
+
+	send()
+
+	status, errs = send()
+	assert.Equal(t, courier.MsgStatusErrored, status)
+	assert.NotContains(t, errs, "circuit_opened")
+
+	// 2 of the last 4 sends failed so circuit opens
+	status, errs = send()
+	assert.Equal(t, courier.MsgStatusErrored, status)
+	assert.Contains(t, errs, "circuit_opened")
+
+	// next send is deferred without making a request
+	status, errs = send()
+	assert.Equal(t, courier.MsgStatusQueued, status)
+	assert.Equal(t, []string{"circuit_open"}, errs)
+	assert.Len(t, mb.RequeuedMsgs(), 1)
+	assert.Equal(t, nextID, mb.RequeuedMsgs()[0].Msg.ID())
+	assert.LessOrEqual(t, mb.RequeuedMsgs()[0].Delay, time.Second)
+	assert.Len(t, mockRequestor.Requests(), 4)
+
+	// after the cooldown a send probes the provider, which is still failing so circuit reopens
+	time.Sleep(time.Second)
+
+	status, errs = send()
+	assert.Equal(t, courier.MsgStatusErrored, status)
+	assert.Contains(t, errs, "circuit_opened")
+
+	status, _ = send()
+	assert.Equal(t, courier.MsgStatusQueued, status)
+	assert.Len(t, mb.RequeuedMsgs(), 2)
+
+	// after another cooldown the probe succeeds and the circuit closes
+	time.Sleep(time.Second)
+
+	status, errs = send()
+	assert.Equal(t, courier.MsgStatusWired, status)
+	assert.NotContains(t, errs, "circuit_opened")
+
+	status, _ = send()
+	assert.Equal(t, courier.MsgStatusWired, status)
+	assert.False(t, mockRequestor.HasUnused())
+}
+
+func TestFailover(t *testing.T) {
+	mockRequestor := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/send": {
+			httpx.NewMockResponse(500, nil, []byte(`oops`)),
+			httpx.NewMockResponse(500, nil, []byte(`oops`)),
+			httpx.NewMockResponse(500, nil, []byte(`oops`)),
+		},
+	})
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(mockRequestor)
+
+	mb := test.NewMockBackend()
+	fallbackChannel := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{
+		courier.ConfigFallbackChannel: string(fallbackChannel.UUID()),
+	})
+	noFallbackChannel := test.NewMockChannel("8e3a1a5b-8e8e-4d2c-9b0c-1c5b5a2d2b61", "MCK", "2022", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+	mb.AddChannel(fallbackChannel)
+	mb.AddChannel(noFallbackChannel)
+
+	s := courier.NewServer(testConfig(), mb)
+	s.Start()
+	defer s.Stop()
+
+	// sends the given message and returns the status written for it and the errors in its channel log
+	send := func(m courier.MsgOut) (courier.StatusUpdate, []string) {
+		numStatuses := len(mb.WrittenMsgStatuses())
+		mb.ClearMsgSent(context.Background(), m.ID()) // mock backend treats all sends as final
+		mb.PushOutgoingMsg(m)
+
+		require.Eventually(t, func() bool {
+			return len(mb.WrittenMsgStatuses()) > numStatuses && len(mb.WrittenChannelLogs()) == len(mb.WrittenMsgStatuses())
+		}, time.Second, 25*time.Millisecond)
+
+		status := mb.WrittenMsgStatuses()[len(mb.WrittenMsgStatuses())-1]
+		clog := mb.WrittenChannelLogs()[len(mb.WrittenChannelLogs())-1]
+		codes := make([]string, len(clog.Errors))
+		for i, e := range clog.Errors {
+			codes[i] = e.Code
+		}
+		return status, codes
+	}
+
+	msg1 := test.NewMockMsg(101, courier.NilMsgUUID, mockChannel, "tel:+250788383383", "test message", nil)
+
+	// first errored attempt isn't enough to fail over
+	status, errs := send(msg1)
+	assert.Equal(t, courier.MsgStatusErrored, status.Status())
+	assert.Equal(t, courier.NilChannelUUID, status.FallbackChannel())
+	assert.NotContains(t, errs, "failover")
+
+	// second is
+	status, errs = send(msg1)
+	assert.Equal(t, courier.MsgStatusQueued, status.Status())
+	assert.Equal(t, fallbackChannel.UUID(), status.FallbackChannel())
+	assert.Contains(t, errs, "failover")
+
+	if assert.Len(t, mb.FailedOverMsgs(), 1) {
+		assert.Equal(t, msg1.ID(), mb.FailedOverMsgs()[0].Msg.ID())
+		assert.Equal(t, fallbackChannel, mb.FailedOverMsgs()[0].Fallback)
+	}
+
+	// channels without a fallback just keep erroring
+	msg2 := test.NewMockMsg(102, courier.NilMsgUUID, noFallbackChannel, "tel:+250788383383", "test message", nil)
+	status, errs = send(msg2)
+	assert.Equal(t, courier.MsgStatusErrored, status.Status())
+	assert.NotContains(t, errs, "failover")
+	assert.Len(t, mb.FailedOverMsgs(), 1)
+	assert.False(t, mockRequestor.HasUnused())
+}
+
+func TestShortLinks(t *testing.T) {
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+
+	stop := startTestServer(t, testConfig(), mb)
+	defer stop()
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	token, err := courier.ShortenURL(rc, "https://s3.amazonaws.com/attachments/photo.jpg", time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, token, 10)
+
+	// don't follow redirects so we can check them
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get("http://localhost:8081/m/" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://s3.amazonaws.com/attachments/photo.jpg", resp.Header.Get("Location"))
+
+	resp, err = client.Get("http://localhost:8081/m/xxxxxxxxxx")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// clicks on untracked links aren't recorded
+	assert.Len(t, mb.WrittenChannelEvents(), 0)
+
+	// but clicks on tracked links are
+	msg := test.NewMockMsg(123, courier.NilMsgUUID, mockChannel, "tel:+250788383383", "Visit https://nyaruka.com", nil)
+	token, err = courier.TrackURL(rc, "https://nyaruka.com", msg, time.Hour)
+	require.NoError(t, err)
+
+	resp, err = client.Get("http://localhost:8081/m/" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://nyaruka.com", resp.Header.Get("Location"))
+
+	if assert.Len(t, mb.WrittenChannelEvents(), 1) {
+		event := mb.WrittenChannelEvents()[0]
+		assert.Equal(t, courier.EventTypeLinkClicked, event.EventType())
+		assert.Equal(t, mockChannel.UUID(), event.ChannelUUID())
+		assert.Equal(t, urns.URN("tel:+250788383383"), event.URN())
+		assert.Equal(t, map[string]string{"msg_id": "123", "url": "https://nyaruka.com"}, event.Extra())
+	}
+	assert.Len(t, mb.WrittenChannelLogs(), 1)
+
+	// but only the first click within the window
+	resp, err = client.Get("http://localhost:8081/m/" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+
+	// and not requests from services previewing the link
+	token, err = courier.TrackURL(rc, "https://nyaruka.com/about", msg, time.Hour)
+	require.NoError(t, err)
+
+	resp, err = client.Head("http://localhost:8081/m/" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://nyaruka.com/about", resp.Header.Get("Location"))
+
+	req, _ := http.NewRequest("GET", "http://localhost:8081/m/"+token, nil)
+	req.Header.Set("User-Agent", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	req.Header.Set("User-Agent", "TelegramBot (like TwitterBot)")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Len(t, mb.WrittenChannelEvents(), 1)
+
+	// until the recipient clicks it
+	resp, err = client.Get("http://localhost:8081/m/" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Len(t, mb.WrittenChannelEvents(), 2)
+}
+
+func TestConfigureProfile(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+	mockChannel := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"greeting": "Hi there!"})
+	noProfileChannel := test.NewMockChannel("5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "MCK", "2021", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	mb.AddChannel(mockChannel)
+	mb.AddChannel(noProfileChannel)
+
+	stop := startTestServer(t, config, mb)
+	defer stop()
+
+	mockHTTP := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://mock.com/profile": {
+			httpx.NewMockResponse(200, nil, []byte(`{"result": "success"}`)),
+		},
+	})
+	mockHTTP.SetIgnoreLocal(true)
+	httpx.SetRequestor(mockHTTP)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	request := func(url, authToken string) (int, string) {
+		req, _ := http.NewRequest("POST", url, nil)
+		if authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+authToken)
+		}
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode, string(trace.ResponseBody)
+	}
+
+	// can't access without auth
+	statusCode, respBody := request("http://localhost:8081/admin/profile/e4bb1578-29da-4fa5-a214-9da19dd24230", "")
+	assert.Equal(t, 401, statusCode)
+	assert.Equal(t, "Unauthorized", respBody)
+
+	// non-existent channel
+	statusCode, respBody = request("http://localhost:8081/admin/profile/a984069d-0008-4d8c-a772-b14a8a6acccc", "sesame")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, respBody, "no such channel")
+
+	statusCode, respBody = request("http://localhost:8081/admin/profile/e4bb1578-29da-4fa5-a214-9da19dd24230", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"url":"http://mock.com/profile"`)
+	assert.Contains(t, respBody, `"errors":[]`)
+	assert.NotContains(t, respBody, "sesame")
+
+	// channel without any profile config
+	statusCode, respBody = request("http://localhost:8081/admin/profile/5fccf4b6-48d7-4f5a-bce8-b0d1fd5342ec", "sesame")
+	assert.Equal(t, 200, statusCode)
+	assert.Contains(t, respBody, `"requests":[]`)
+	assert.Contains(t, respBody, `channel has no profile config`)
+
+	// both attempts are logged
+	if assert.Len(t, mb.WrittenChannelLogs(), 2) {
+		assert.Equal(t, courier.ChannelLogTypeProfileUpdate, mb.WrittenChannelLogs()[0].Type)
+		assert.False(t, mb.WrittenChannelLogs()[0].IsError())
+		assert.True(t, mb.WrittenChannelLogs()[1].IsError())
+	}
+}
+
+// basicBackend hides the optional features of the wrapped backend
+type basicBackend struct {
+	courier.Backend
+}
+
+func TestOptionalBackendFeatures(t *testing.T) {
+	config := testConfig()
+	config.AuthToken = "sesame"
+
+	mb := test.NewMockBackend()
+
+	stop := startTestServer(t, config, &basicBackend{mb})
+	defer stop()
+
+	request := func(method, url string) int {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer sesame")
+		trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 0)
+		require.NoError(t, err)
+		return trace.Response.StatusCode
+	}
+
+	// admin endpoints which need backend support aren't available
+	assert.Equal(t, 404, request("GET", "http://localhost:8081/admin/queues"))
+	assert.Equal(t, 404, request("GET", "http://localhost:8081/admin/dlq/e4bb1578-29da-4fa5-a214-9da19dd24230"))
+	assert.Equal(t, 404, request("GET", "http://localhost:8081/admin/logs/e4bb1578-29da-4fa5-a214-9da19dd24230"))
+	assert.Equal(t, 404, request("GET", "http://localhost:8081/admin/msgs/0191e180-7d60-7000-aded-7d8b151cbd5b"))
+	assert.Equal(t, 404, request("POST", "http://localhost:8081/api/v1/send"))
+
+	// but the rest are, and health just doesn't include any dependency checks
+	assert.Equal(t, 200, request("GET", "http://localhost:8081/admin/drift"))
+	assert.Equal(t, 200, request("GET", "http://localhost:8081/admin/health"))
 }
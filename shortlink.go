@@ -0,0 +1,196 @@
+package courier
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/valkey"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/random"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// short link tokens are long enough that they can't be guessed but short enough to not use up much of an SMS
+const shortLinkLength = 10
+
+var shortLinkChars = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+// only the first click on a tracked link within this window is recorded
+const shortLinkClickWindow = time.Hour * 24
+
+// parts of the user agents of services which fetch links to preview them, which aren't clicks by the recipient
+var linkPreviewAgents = []string{"bot", "crawler", "spider", "preview", "facebookexternalhit", "whatsapp", "slack", "embedly", "skypeuripreview"}
+
+// ShortLink is a link on our domain which redirects to a URL. Links which track clicks also have the message they were
+// sent in, and clicks on them are written as channel events.
+type ShortLink struct {
+	URL         string
+	ChannelUUID ChannelUUID
+	MsgID       MsgID
+	URN         urns.URN
+}
+
+// IsTracked returns whether clicks on this link are tracked
+func (l *ShortLink) IsTracked() bool { return l.MsgID != NilMsgID }
+
+// ShortenURL returns the token of a short link to the given URL which works for the given TTL. URLs which have already
+// been shortened get the same token back, and their short link then works for the TTL from now.
+func ShortenURL(rc redis.Conn, url string, ttl time.Duration) (string, error) {
+	return saveShortLink(rc, &ShortLink{URL: url}, ttl)
+}
+
+// TrackURL returns the token of a short link to the given URL which tracks clicks by the recipient of the given message
+func TrackURL(rc redis.Conn, url string, msg MsgOut, ttl time.Duration) (string, error) {
+	return saveShortLink(rc, &ShortLink{URL: url, ChannelUUID: msg.Channel().UUID(), MsgID: msg.ID(), URN: msg.URN()}, ttl)
+}
+
+func saveShortLink(rc redis.Conn, link *ShortLink, ttl time.Duration) (string, error) {
+	hash := sha1.Sum([]byte(fmt.Sprintf("%d|%s", link.MsgID, link.URL)))
+	lookupKey := shortLinkKey("lookup:" + hex.EncodeToString(hash[:]))
+	seconds := int(ttl / time.Second)
+
+	token, err := redis.String(rc.Do("GET", lookupKey))
+	if err != nil && err != redis.ErrNil {
+		return "", fmt.Errorf("error looking up short link: %w", err)
+	}
+
+	// a link and its lookup can be in different slots in a cluster so they're written separately, the link first so
+	// that a lookup never finds a token without a link
+	if token != "" {
+		if _, err := rc.Do("EXPIRE", shortLinkKey(token), seconds); err != nil {
+			return "", fmt.Errorf("error saving short link: %w", err)
+		}
+	} else {
+		token = random.String(shortLinkLength, shortLinkChars)
+		fields := []any{shortLinkKey(token), "url", link.URL}
+		if link.IsTracked() {
+			fields = append(fields, "channel_uuid", string(link.ChannelUUID), "msg_id", int64(link.MsgID), "urn", string(link.URN))
+		}
+		rc.Send("MULTI")
+		rc.Send("HSET", fields...)
+		rc.Send("EXPIRE", shortLinkKey(token), seconds)
+		if _, err := rc.Do("EXEC"); err != nil {
+			return "", fmt.Errorf("error saving short link: %w", err)
+		}
+	}
+	if _, err := rc.Do("SET", lookupKey, token, "EX", seconds); err != nil {
+		return "", fmt.Errorf("error saving short link: %w", err)
+	}
+
+	return token, nil
+}
+
+// ResolveShortLink returns the short link with the given token, or nil if there's no such link
+func ResolveShortLink(rc redis.Conn, token string) (*ShortLink, error) {
+	fields, err := redis.StringMap(rc.Do("HGETALL", shortLinkKey(token)))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving short link: %w", err)
+	}
+	if fields["url"] == "" {
+		return nil, nil
+	}
+
+	msgID, _ := strconv.ParseInt(fields["msg_id"], 10, 64)
+
+	return &ShortLink{URL: fields["url"], ChannelUUID: ChannelUUID(fields["channel_uuid"]), MsgID: MsgID(msgID), URN: urns.URN(fields["urn"])}, nil
+}
+
+// ShortLinkURL returns the URL of the short link with the given token for the given channel
+func ShortLinkURL(cfg *Config, ch Channel, token string) string {
+	return fmt.Sprintf("https://%s/m/%s", ch.CallbackDomain(cfg.Domain), token)
+}
+
+// keys of short links are tagged individually so that links are spread across the slots of a cluster
+func shortLinkKey(part string) string {
+	return valkey.Tag("link:" + part)
+}
+
+// handleShortLink redirects a short link to the URL it was created for, recording the click if it's tracked
+func (s *server) handleShortLink(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer cancel()
+
+	rc := s.backend.RedisPool().Get()
+	link, err := ResolveShortLink(rc, chi.URLParam(r, "token"))
+	rc.Close()
+
+	if err != nil {
+		slog.Error("error resolving short link", "error", err)
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if link == nil {
+		s.handle404(w, r)
+		return
+	}
+
+	// a click which we fail to record shouldn't stop the recipient getting to the link
+	if link.IsTracked() && r.Method == http.MethodGet && !isLinkPreviewAgent(r.UserAgent()) {
+		if err := s.recordLinkClick(ctx, chi.URLParam(r, "token"), link); err != nil {
+			slog.Error("error writing link click", "error", err, "channel_uuid", link.ChannelUUID, "msg_id", link.MsgID)
+		}
+	}
+
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// returns whether the given user agent is that of a service which fetches links to preview them
+func isLinkPreviewAgent(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, agent := range linkPreviewAgents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// records a click on the given tracked link if it's the first within our window, as the same recipient opening a
+// link again or from another app isn't a new click
+func (s *server) recordLinkClick(ctx context.Context, token string, link *ShortLink) error {
+	rc := s.backend.RedisPool().Get()
+	_, err := redis.String(rc.Do("SET", shortLinkKey("clicked:"+token), "1", "NX", "EX", int(shortLinkClickWindow/time.Second)))
+	rc.Close()
+
+	if err == redis.ErrNil {
+		return nil // already clicked
+	} else if err != nil {
+		return fmt.Errorf("error checking for previous click: %w", err)
+	}
+
+	return s.writeLinkClick(ctx, link)
+}
+
+// writes a click on the given tracked link as a channel event
+func (s *server) writeLinkClick(ctx context.Context, link *ShortLink) error {
+	ch, err := s.backend.GetChannel(ctx, AnyChannelType, link.ChannelUUID)
+	if err != nil {
+		return fmt.Errorf("error loading channel: %w", err)
+	}
+
+	clog := NewChannelLog(ChannelLogTypeEventReceive, ch, nil)
+
+	event := s.backend.NewChannelEvent(ch, EventTypeLinkClicked, link.URN, clog).
+		WithExtra(map[string]string{"msg_id": link.MsgID.String(), "url": link.URL}).
+		WithOccurredOn(dates.Now())
+
+	if err := s.backend.WriteChannelEvent(ctx, event, clog); err != nil {
+		return err
+	}
+
+	clog.End()
+
+	if err := s.backend.WriteChannelLog(ctx, clog); err != nil {
+		slog.Error("error writing channel log", "error", err)
+	}
+	return nil
+}
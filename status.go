@@ -18,6 +18,15 @@ const (
 	NilMsgStatus       MsgStatus = ""
 )
 
+// MsgFailedReason is why a message failed when that isn't because of an error from the channel
+type MsgFailedReason string
+
+// Possible values for MsgFailedReason
+const (
+	MsgFailedReasonPoison MsgFailedReason = "P" // sending it repeatedly crashed the channel handler
+	NilMsgFailedReason    MsgFailedReason = ""
+)
+
 //-----------------------------------------------------------------------------
 // StatusUpdate Interface
 //-----------------------------------------------------------------------------
@@ -37,4 +46,13 @@ type StatusUpdate interface {
 
 	Status() MsgStatus
 	SetStatus(MsgStatus)
+
+	FailedReason() MsgFailedReason
+	SetFailedReason(MsgFailedReason)
+
+	FallbackChannel() ChannelUUID
+	SetFallbackChannel(Channel)
+
+	Segments() int
+	SetSegments(int)
 }
@@ -0,0 +1,138 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/jsonx"
+)
+
+// how many status updates we keep in the feed of each channel, and for how long after the last one was added
+const (
+	statusFeedMaxLength = 1000
+	statusFeedExpiry    = time.Hour
+)
+
+func statusFeedKey(uuid ChannelUUID) string { return fmt.Sprintf("status-feed:%s", uuid) }
+
+// StatusFeedItem is a status update in the feed of a channel
+type StatusFeedItem struct {
+	ChannelUUID  ChannelUUID     `json:"channel_uuid"`
+	MsgID        MsgID           `json:"msg_id,omitempty"`
+	ExternalID   string          `json:"external_id,omitempty"`
+	Status       MsgStatus       `json:"status"`
+	FailedReason MsgFailedReason `json:"failed_reason,omitempty"`
+	CreatedOn    time.Time       `json:"created_on"`
+}
+
+// statusFeedBackend is a backend which wraps another backend, also adding the status updates it writes to a feed for
+// their channel in Valkey, so that they can be followed by clients of any courier instance
+type statusFeedBackend struct {
+	Backend
+}
+
+// NewStatusFeedBackend creates a new backend which uses the given backend for everything, and also adds the status
+// updates it writes to the feeds of their channels
+func NewStatusFeedBackend(backend Backend) Backend {
+	return &statusFeedBackend{Backend: backend}
+}
+
+// Unwrap returns the wrapped backend
+func (b *statusFeedBackend) Unwrap() Backend { return b.Backend }
+
+// WriteStatusUpdate writes the given status update to the wrapped backend and if that succeeds, adds it to the feed
+// of its channel. Errors adding to the feed are logged rather than returned.
+func (b *statusFeedBackend) WriteStatusUpdate(ctx context.Context, status StatusUpdate) error {
+	if err := b.Backend.WriteStatusUpdate(ctx, status); err != nil {
+		return err
+	}
+
+	item := &StatusFeedItem{
+		ChannelUUID:  status.ChannelUUID(),
+		MsgID:        status.MsgID(),
+		ExternalID:   status.ExternalID(),
+		Status:       status.Status(),
+		FailedReason: status.FailedReason(),
+		CreatedOn:    dates.Now(),
+	}
+	key := statusFeedKey(item.ChannelUUID)
+
+	rc, err := b.RedisPool().GetContext(ctx)
+	if err != nil {
+		slog.Error("error getting connection to add to status feed", "comp", "status_feed", "error", err)
+		return nil
+	}
+	defer rc.Close()
+
+	rc.Send("XADD", key, "MAXLEN", "~", statusFeedMaxLength, "*", "status", jsonx.MustMarshal(item))
+	rc.Send("EXPIRE", key, int(statusFeedExpiry/time.Second))
+	if _, err := rc.Do(""); err != nil {
+		slog.Error("error adding to status feed", "comp", "status_feed", "channel_uuid", item.ChannelUUID, "error", err)
+	}
+
+	return nil
+}
+
+// ReadStatusFeed reads up to the given number of status updates from the feed of the given channel which were added
+// after the given position, returning them and the position to read from next. If the position is empty, nothing is
+// returned and the next position is the end of the feed.
+func ReadStatusFeed(rc redis.Conn, uuid ChannelUUID, after string, count int) ([]*StatusFeedItem, string, error) {
+	key := statusFeedKey(uuid)
+
+	if after == "" {
+		last, err := readStatusFeedEntries(rc, "XREVRANGE", key, "+", "-", "COUNT", 1)
+		if err != nil || len(last) == 0 {
+			return nil, "0-0", err
+		}
+		return nil, last[0].id, nil
+	}
+
+	entries, err := readStatusFeedEntries(rc, "XRANGE", key, "("+after, "+", "COUNT", count)
+	if err != nil {
+		return nil, after, err
+	}
+
+	items := make([]*StatusFeedItem, 0, len(entries))
+	for _, e := range entries {
+		item := &StatusFeedItem{}
+		if err := json.Unmarshal(e.status, item); err != nil {
+			slog.Error("error unmarshalling status feed item", "comp", "status_feed", "channel_uuid", uuid, "error", err)
+			continue
+		}
+		items = append(items, item)
+		after = e.id
+	}
+	return items, after, nil
+}
+
+type statusFeedEntry struct {
+	id     string
+	status []byte
+}
+
+// reads stream entries using the given range command
+func readStatusFeedEntries(rc redis.Conn, cmd string, args ...any) ([]statusFeedEntry, error) {
+	values, err := redis.Values(rc.Do(cmd, args...))
+	if err != nil {
+		return nil, fmt.Errorf("error reading status feed: %w", err)
+	}
+
+	entries := make([]statusFeedEntry, 0, len(values))
+	for _, v := range values {
+		// each entry is a pair of its ID and its list of field names and values
+		pair, err := redis.Values(v, nil)
+		if err != nil || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected status feed entry: %v", v)
+		}
+		id, _ := redis.String(pair[0], nil)
+		fields, _ := redis.StringMap(pair[1], nil)
+
+		entries = append(entries, statusFeedEntry{id: id, status: []byte(fields["status"])})
+	}
+	return entries, nil
+}
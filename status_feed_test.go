@@ -0,0 +1,78 @@
+package courier_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusFeedBackend(t *testing.T) {
+	ctx := context.Background()
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	wrapped := test.NewMockBackend()
+	ch1 := test.NewMockChannel("95710b36-855d-4832-a723-5f71f73688a0", "MCK", "12345", "RW", []string{urns.Phone.Prefix}, nil)
+	ch2 := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "23456", "RW", []string{urns.Phone.Prefix}, nil)
+	wrapped.AddChannel(ch1)
+	wrapped.AddChannel(ch2)
+
+	backend := courier.NewStatusFeedBackend(wrapped)
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, ch1, nil)
+
+	rc := backend.RedisPool().Get()
+	defer rc.Close()
+
+	// reading an empty feed without a position gives us a position from which to read new updates
+	items, pos, err := courier.ReadStatusFeed(rc, ch1.UUID(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+	assert.Equal(t, "0-0", pos)
+
+	require.NoError(t, backend.WriteStatusUpdate(ctx, wrapped.NewStatusUpdate(ch1, 1, courier.MsgStatusWired, clog)))
+	require.NoError(t, backend.WriteStatusUpdate(ctx, wrapped.NewStatusUpdateByExternalID(ch1, "ext1", courier.MsgStatusDelivered, clog)))
+	require.NoError(t, backend.WriteStatusUpdate(ctx, wrapped.NewStatusUpdate(ch2, 2, courier.MsgStatusSent, clog)))
+
+	// status updates are still written to the wrapped backend
+	assert.Len(t, wrapped.WrittenMsgStatuses(), 3)
+
+	items, pos, err = courier.ReadStatusFeed(rc, ch1.UUID(), pos, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.StatusFeedItem{
+		{ChannelUUID: ch1.UUID(), MsgID: 1, Status: courier.MsgStatusWired, CreatedOn: dates.Now()},
+	}, items)
+
+	items, pos, err = courier.ReadStatusFeed(rc, ch1.UUID(), pos, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*courier.StatusFeedItem{
+		{ChannelUUID: ch1.UUID(), ExternalID: "ext1", Status: courier.MsgStatusDelivered, CreatedOn: dates.Now()},
+	}, items)
+
+	// nothing more to read so position doesn't change
+	items, pos2, err := courier.ReadStatusFeed(rc, ch1.UUID(), pos, 10)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+	assert.Equal(t, pos, pos2)
+
+	// reading without a position skips everything already in the feed
+	items, pos, err = courier.ReadStatusFeed(rc, ch2.UUID(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+	assert.NotEqual(t, "0-0", pos)
+
+	items, _, err = courier.ReadStatusFeed(rc, ch2.UUID(), pos, 10)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+
+	// feeds expire
+	ttl, err := rc.Do("TTL", "status-feed:"+string(ch1.UUID()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3600), ttl)
+}
@@ -0,0 +1,207 @@
+package courier
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier/utils/batch"
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/nyaruka/courier/utils/publish"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// how many events can be waiting to be published before we start dropping them
+const streamBufferSize = 1000
+
+// an event which is waiting to be published to a topic
+type streamItem struct {
+	topic   string
+	payload []byte
+}
+
+type streamMsg struct {
+	UUID        MsgUUID     `json:"uuid"`
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	ChannelType ChannelType `json:"channel_type"`
+	URN         urns.URN    `json:"urn"`
+	Text        string      `json:"text"`
+	Attachments []string    `json:"attachments,omitempty"`
+	ExternalID  string      `json:"external_id,omitempty"`
+	ReceivedOn  *time.Time  `json:"received_on,omitempty"`
+	CreatedOn   time.Time   `json:"created_on"`
+}
+
+type streamStatus struct {
+	ChannelUUID  ChannelUUID     `json:"channel_uuid"`
+	MsgID        MsgID           `json:"msg_id,omitempty"`
+	ExternalID   string          `json:"external_id,omitempty"`
+	Status       MsgStatus       `json:"status"`
+	FailedReason MsgFailedReason `json:"failed_reason,omitempty"`
+	Fallback     ChannelUUID     `json:"fallback_channel_uuid,omitempty"`
+	Segments     int             `json:"segments,omitempty"`
+	CreatedOn    time.Time       `json:"created_on"`
+}
+
+type streamChannelEvent struct {
+	ChannelUUID ChannelUUID       `json:"channel_uuid"`
+	EventType   ChannelEventType  `json:"event_type"`
+	URN         urns.URN          `json:"urn"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	OccurredOn  time.Time         `json:"occurred_on"`
+	CreatedOn   time.Time         `json:"created_on"`
+}
+
+// streamBackend is a backend which wraps another backend, also publishing the incoming messages, status updates and
+// channel events it writes as JSON to topics of an event streaming service. Events are published in batches in the
+// background on a best-effort basis so a slow or failing service never affects the wrapped backend.
+type streamBackend struct {
+	Backend
+
+	publisher publish.Publisher
+	config    *Config
+	batcher   *batch.Batcher[*streamItem]
+	waitGroup sync.WaitGroup
+}
+
+// NewStreamBackend creates a new backend which uses the given backend for everything, and also publishes incoming
+// messages, status updates and channel events with the given publisher to the topics in the given config
+func NewStreamBackend(backend Backend, publisher publish.Publisher, config *Config, clk clock.Clock) Backend {
+	b := &streamBackend{Backend: backend, publisher: publisher, config: config}
+	b.batcher = batch.NewBatcher(b.publish, 100, time.Millisecond*500, streamBufferSize, clk, &b.waitGroup)
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *streamBackend) Unwrap() Backend { return b.Backend }
+
+// Start starts the wrapped backend and then our publishing of events
+func (b *streamBackend) Start() error {
+	if err := b.Backend.Start(); err != nil {
+		return err
+	}
+
+	b.batcher.Start()
+	return nil
+}
+
+// Stop stops the wrapped backend, then publishes any pending events
+func (b *streamBackend) Stop() error {
+	err := b.Backend.Stop()
+
+	b.batcher.Stop()
+	b.waitGroup.Wait()
+
+	if failed := b.batcher.Failed(); failed > 0 {
+		slog.Error("some events failed to be published", "comp", "stream", "publisher", b.publisher.Name(), "failed", failed)
+	}
+	if err := b.publisher.Close(); err != nil {
+		slog.Error("error closing publisher", "comp", "stream", "publisher", b.publisher.Name(), "error", err)
+	}
+
+	return err
+}
+
+// Flush publishes any pending events and then flushes the wrapped backend
+func (b *streamBackend) Flush(ctx context.Context) error {
+	if err := b.batcher.Flush(ctx); err != nil {
+		slog.Error("error flushing events to publisher", "comp", "stream", "publisher", b.publisher.Name(), "unflushed", b.batcher.Pending(), "error", err)
+	}
+
+	return b.Backend.Flush(ctx)
+}
+
+// WriteMsg writes the given message to the wrapped backend and if that succeeds, queues it to be published
+func (b *streamBackend) WriteMsg(ctx context.Context, msg MsgIn, clog *ChannelLog) error {
+	if err := b.Backend.WriteMsg(ctx, msg, clog); err != nil {
+		return err
+	}
+
+	b.queue(b.config.StreamMsgsTopic, &streamMsg{
+		UUID:        msg.UUID(),
+		ChannelUUID: msg.Channel().UUID(),
+		ChannelType: msg.Channel().ChannelType(),
+		URN:         msg.URN(),
+		Text:        msg.Text(),
+		Attachments: msg.Attachments(),
+		ExternalID:  msg.ExternalID(),
+		ReceivedOn:  msg.ReceivedOn(),
+		CreatedOn:   dates.Now(),
+	})
+	return nil
+}
+
+// WriteStatusUpdate writes the given status update to the wrapped backend and if that succeeds, queues it to be
+// published
+func (b *streamBackend) WriteStatusUpdate(ctx context.Context, status StatusUpdate) error {
+	if err := b.Backend.WriteStatusUpdate(ctx, status); err != nil {
+		return err
+	}
+
+	b.queue(b.config.StreamStatusesTopic, &streamStatus{
+		ChannelUUID:  status.ChannelUUID(),
+		MsgID:        status.MsgID(),
+		ExternalID:   status.ExternalID(),
+		Status:       status.Status(),
+		FailedReason: status.FailedReason(),
+		Fallback:     status.FallbackChannel(),
+		Segments:     status.Segments(),
+		CreatedOn:    dates.Now(),
+	})
+	return nil
+}
+
+// WriteChannelEvent writes the given channel event to the wrapped backend and if that succeeds, queues it to be
+// published
+func (b *streamBackend) WriteChannelEvent(ctx context.Context, event ChannelEvent, clog *ChannelLog) error {
+	if err := b.Backend.WriteChannelEvent(ctx, event, clog); err != nil {
+		return err
+	}
+
+	b.queue(b.config.StreamChannelEventsTopic, &streamChannelEvent{
+		ChannelUUID: event.ChannelUUID(),
+		EventType:   event.EventType(),
+		URN:         event.URN(),
+		Extra:       event.Extra(),
+		OccurredOn:  event.OccurredOn(),
+		CreatedOn:   dates.Now(),
+	})
+	return nil
+}
+
+// queues an event to be published, dropping it if the buffer is full rather than blocking the caller
+func (b *streamBackend) queue(topic string, event any) {
+	if b.batcher.Pending() >= streamBufferSize {
+		slog.Warn("event stream buffer full, dropping event", "comp", "stream", "publisher", b.publisher.Name(), "topic", topic)
+		return
+	}
+
+	b.batcher.Queue(&streamItem{topic: topic, payload: jsonx.MustMarshal(event)})
+}
+
+// publishes a batch of events, grouped by topic, returning how many couldn't be published
+func (b *streamBackend) publish(items []*streamItem) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	topics := make([]string, 0, 3)
+	byTopic := make(map[string][][]byte, 3)
+	for _, item := range items {
+		if _, seen := byTopic[item.topic]; !seen {
+			topics = append(topics, item.topic)
+		}
+		byTopic[item.topic] = append(byTopic[item.topic], item.payload)
+	}
+
+	failed := 0
+	for _, topic := range topics {
+		if err := b.publisher.Publish(ctx, topic, byTopic[topic]); err != nil {
+			slog.Error("error publishing events", "comp", "stream", "publisher", b.publisher.Name(), "topic", topic, "count", len(byTopic[topic]), "error", err)
+			failed += len(byTopic[topic])
+		}
+	}
+	return failed
+}
@@ -0,0 +1,140 @@
+package courier_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPublisher struct {
+	mutex     sync.Mutex
+	published map[string][]map[string]any
+	err       error
+	closed    bool
+}
+
+func (p *testPublisher) Name() string { return "test" }
+
+func (p *testPublisher) Publish(ctx context.Context, topic string, msgs [][]byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	for _, m := range msgs {
+		event := map[string]any{}
+		json.Unmarshal(m, &event)
+		delete(event, "created_on")
+		p.published[topic] = append(p.published[topic], event)
+	}
+	return nil
+}
+
+func (p *testPublisher) Close() error { p.closed = true; return nil }
+
+func TestStreamBackend(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	wrapped := test.NewMockBackend()
+	wrapped.AddChannel(test.NewMockChannel("95710b36-855d-4832-a723-5f71f73688a0", "MCK", "12345", "RW", []string{urns.Phone.Prefix}, nil))
+
+	publisher := &testPublisher{published: make(map[string][]map[string]any)}
+
+	backend := courier.NewStreamBackend(wrapped, publisher, courier.NewDefaultConfig(), clk)
+	require.NoError(t, backend.Start())
+
+	ch, _ := backend.GetChannel(ctx, "MCK", "95710b36-855d-4832-a723-5f71f73688a0")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, ch, nil)
+
+	msg := backend.NewIncomingMsg(ch, "tel:+250788383383", "hello", "ext1", clog).WithReceivedOn(time.Date(2024, 5, 1, 11, 30, 0, 0, time.UTC))
+	assert.NoError(t, backend.WriteMsg(ctx, msg, clog))
+	assert.NoError(t, backend.WriteStatusUpdate(ctx, backend.NewStatusUpdate(ch, 123, courier.MsgStatusDelivered, clog)))
+
+	event := backend.NewChannelEvent(ch, courier.EventTypeNewConversation, "tel:+250788383383", clog).WithExtra(map[string]string{"ref": "ad1"}).WithOccurredOn(time.Date(2024, 5, 1, 11, 45, 0, 0, time.UTC))
+	assert.NoError(t, backend.WriteChannelEvent(ctx, event, clog))
+
+	// if the wrapped backend fails, nothing is published
+	wrapped.SetErrorOnQueue(true)
+	assert.EqualError(t, backend.WriteMsg(ctx, backend.NewIncomingMsg(ch, "tel:+250788383383", "fail", "ext2", clog), clog), "unable to queue message")
+
+	// nothing is published until the batch is old enough
+	assert.Len(t, publisher.published, 0)
+	assert.Eventually(t, func() bool { return clk.Waiters() == 1 }, time.Second, time.Millisecond)
+	clk.Advance(time.Millisecond * 500)
+
+	assert.Eventually(t, func() bool {
+		publisher.mutex.Lock()
+		defer publisher.mutex.Unlock()
+		return len(publisher.published) == 3
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, []map[string]any{{
+		"uuid":         string(msg.UUID()),
+		"channel_uuid": "95710b36-855d-4832-a723-5f71f73688a0",
+		"channel_type": "MCK",
+		"urn":          "tel:+250788383383",
+		"text":         "hello",
+		"external_id":  "ext1",
+		"received_on":  "2024-05-01T11:30:00Z",
+	}}, publisher.published["courier.msgs"])
+	assert.Equal(t, []map[string]any{{
+		"channel_uuid": "95710b36-855d-4832-a723-5f71f73688a0",
+		"msg_id":       float64(123),
+		"status":       "D",
+	}}, publisher.published["courier.statuses"])
+	assert.Equal(t, []map[string]any{{
+		"channel_uuid": "95710b36-855d-4832-a723-5f71f73688a0",
+		"event_type":   "new_conversation",
+		"urn":          "tel:+250788383383",
+		"extra":        map[string]any{"ref": "ad1"},
+		"occurred_on":  "2024-05-01T11:45:00Z",
+	}}, publisher.published["courier.channel_events"])
+
+	// flushing publishes pending events without waiting, and failures don't affect the wrapped backend
+	wrapped.SetErrorOnQueue(false)
+	publisher.mutex.Lock()
+	publisher.err = errors.New("boom")
+	publisher.mutex.Unlock()
+
+	assert.NoError(t, backend.WriteStatusUpdate(ctx, backend.NewStatusUpdate(ch, 124, courier.MsgStatusFailed, clog)))
+	assert.NoError(t, backend.Flush(ctx))
+	assert.Len(t, publisher.published["courier.statuses"], 1)
+	assert.Len(t, wrapped.WrittenMsgStatuses(), 2)
+	assert.Equal(t, 1, wrapped.Flushes())
+
+	assert.NoError(t, backend.Stop())
+	assert.True(t, publisher.closed)
+}
+
+func TestNewBackendWithStream(t *testing.T) {
+	config := courier.NewDefaultConfig()
+	config.Backend = "mock"
+	config.StreamURL = "nats://localhost:4222"
+
+	backend, err := courier.NewBackend(config)
+	assert.NoError(t, err)
+	assert.NotNil(t, backend)
+
+	config.StreamURL = "kafka://localhost:9092"
+
+	backend, err = courier.NewBackend(config)
+	assert.NoError(t, err)
+	assert.NotNil(t, backend)
+
+	config.StreamURL = "https://kafka-rest.example.com"
+
+	_, err = courier.NewBackend(config)
+	assert.EqualError(t, err, "error creating event stream publisher: unsupported URL scheme: https")
+}
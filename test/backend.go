@@ -2,10 +2,14 @@ package test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +17,9 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/valkey"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 )
@@ -40,17 +46,31 @@ type MockBackend struct {
 	contacts          map[urns.URN]courier.Contact
 	outgoingMsgs      []courier.MsgOut
 	media             map[string]courier.Media // url -> Media
+	transcodedMedia   map[string]courier.Media // content type:url -> Media
+	resizedMedia      map[string]courier.Media // url -> Media
 	errorOnQueue      bool
+	configConflict    bool
 
 	mutex     sync.RWMutex
-	redisPool *redis.Pool
+	redisPool valkey.Pool
 
 	writtenMsgs          []courier.MsgIn
 	writtenMsgStatuses   []courier.StatusUpdate
 	writtenChannelEvents []courier.ChannelEvent
 	writtenChannelLogs   []*courier.ChannelLog
+	channelQualities     map[courier.ChannelUUID]*courier.ChannelQuality
+	deadLetters          map[courier.ChannelUUID][]*courier.DeadLetter
+	pausedQueues         map[courier.ChannelUUID]bool
+	archivedMsgs         map[courier.MsgUUID]*courier.ArchivedMsg
+	resentMsgs           []*courier.ArchivedMsg
+	msgTimelines         map[courier.MsgUUID]*courier.MsgTimeline
+	requeuedMsgs         []*RequeuedMsg
+	requeuedSentMsgs     []courier.MsgID
+	failedOverMsgs       []*FailedOverMsg
 	savedAttachments     []*SavedAttachment
 	storageError         error
+	unhealthy            map[string]error
+	flushes              int
 
 	lastMsgID       courier.MsgID
 	lastContactName string
@@ -88,8 +108,15 @@ func NewMockBackend() *MockBackend {
 		channelsByAddress: make(map[courier.ChannelAddress]courier.Channel),
 		contacts:          make(map[urns.URN]courier.Contact),
 		media:             make(map[string]courier.Media),
+		transcodedMedia:   make(map[string]courier.Media),
+		resizedMedia:      make(map[string]courier.Media),
 		sentMsgs:          make(map[courier.MsgID]bool),
 		seenExternalIDs:   make(map[string]courier.MsgUUID),
+		channelQualities:  make(map[courier.ChannelUUID]*courier.ChannelQuality),
+		deadLetters:       make(map[courier.ChannelUUID][]*courier.DeadLetter),
+		pausedQueues:      make(map[courier.ChannelUUID]bool),
+		archivedMsgs:      make(map[courier.MsgUUID]*courier.ArchivedMsg),
+		msgTimelines:      make(map[courier.MsgUUID]*courier.MsgTimeline),
 		redisPool:         redisPool,
 	}
 }
@@ -140,20 +167,60 @@ func (mb *MockBackend) PushOutgoingMsg(msg courier.MsgOut) {
 	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
 }
 
+// QueueMsg adds the given message to our queue of messages to send
+func (mb *MockBackend) QueueMsg(ctx context.Context, channel courier.Channel, m *courier.OutgoingMsg) (courier.MsgID, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.errorOnQueue {
+		return courier.NilMsgID, errors.New("unable to queue message")
+	}
+
+	mb.lastMsgID++
+
+	msg := NewMockMsg(mb.lastMsgID, m.UUID, channel, m.URN, m.Text, m.Attachments)
+	msg.quickReplies = m.QuickReplies
+	msg.highPriority = m.HighPriority
+
+	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
+	return mb.lastMsgID, nil
+}
+
 // PopNextOutgoingMsg returns the next message that should be sent, or nil if there are none to send
 func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
-	if len(mb.outgoingMsgs) > 0 {
-		msg, rest := mb.outgoingMsgs[0], mb.outgoingMsgs[1:]
-		mb.outgoingMsgs = rest
-		return msg, nil
+	for i, msg := range mb.outgoingMsgs {
+		if !mb.pausedQueues[msg.Channel().UUID()] {
+			mb.outgoingMsgs = slices.Delete(mb.outgoingMsgs, i, i+1)
+			return msg, nil
+		}
 	}
 
 	return nil, nil
 }
 
+// PopMoreOutgoingMsgs returns up to max more messages queued for the same channel as the passed in message
+func (mb *MockBackend) PopMoreOutgoingMsgs(ctx context.Context, msg courier.MsgOut, max int) ([]courier.MsgOut, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	popped := make([]courier.MsgOut, 0, max)
+	remaining := make([]courier.MsgOut, 0, len(mb.outgoingMsgs))
+
+	for _, m := range mb.outgoingMsgs {
+		if len(popped) < max && m.Channel().UUID() == msg.Channel().UUID() {
+			popped = append(popped, m)
+		} else {
+			remaining = append(remaining, m)
+		}
+	}
+	mb.outgoingMsgs = remaining
+
+	return popped, nil
+}
+
 // WasMsgSent returns whether the passed in msg was already sent
 func (mb *MockBackend) WasMsgSent(ctx context.Context, id courier.MsgID) (bool, error) {
 	mb.mutex.Lock()
@@ -170,12 +237,221 @@ func (mb *MockBackend) ClearMsgSent(ctx context.Context, id courier.MsgID) error
 	return nil
 }
 
+// RequeuedMsg is a message which was put back on the queue to be sent again after a delay
+type RequeuedMsg struct {
+	Msg   courier.MsgOut
+	Delay time.Duration
+}
+
+// RequeueMsg records that the given message was requeued
+func (mb *MockBackend) RequeueMsg(ctx context.Context, msg courier.MsgOut, delay time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.requeuedMsgs = append(mb.requeuedMsgs, &RequeuedMsg{Msg: msg, Delay: delay})
+	return nil
+}
+
+// RequeueSentMsg records that the given already sent message was requeued
+func (mb *MockBackend) RequeueSentMsg(ctx context.Context, ch courier.Channel, id courier.MsgID) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.requeuedSentMsgs = append(mb.requeuedSentMsgs, id)
+	return nil
+}
+
+// FailedOverMsg is a message which was moved to the fallback channel of its channel
+type FailedOverMsg struct {
+	Msg      courier.MsgOut
+	Fallback courier.Channel
+}
+
+// FailoverMsg records that the given message was moved to the given fallback channel
+func (mb *MockBackend) FailoverMsg(ctx context.Context, msg courier.MsgOut, fallback courier.Channel) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.failedOverMsgs = append(mb.failedOverMsgs, &FailedOverMsg{Msg: msg, Fallback: fallback})
+	return nil
+}
+
 // OnSendComplete marks the passed msg as having been dealt with
 func (mb *MockBackend) OnSendComplete(ctx context.Context, msg courier.MsgOut, s courier.StatusUpdate, clog *courier.ChannelLog) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
 	mb.sentMsgs[msg.ID()] = true
+
+	if s.Status() == courier.MsgStatusFailed {
+		uuid := msg.Channel().UUID()
+		mb.deadLetters[uuid] = append(mb.deadLetters[uuid], &courier.DeadLetter{MsgID: msg.ID(), ChannelUUID: uuid, LogUUID: clog.UUID, FailedOn: time.Now()})
+	}
+}
+
+// Queues returns the state of the outgoing queues
+func (mb *MockBackend) Queues(ctx context.Context) ([]*courier.QueueInfo, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	infos := make(map[courier.ChannelUUID]*courier.QueueInfo)
+	getInfo := func(uuid courier.ChannelUUID) *courier.QueueInfo {
+		if infos[uuid] == nil {
+			infos[uuid] = &courier.QueueInfo{ChannelUUID: uuid}
+		}
+		return infos[uuid]
+	}
+
+	for _, m := range mb.outgoingMsgs {
+		getInfo(m.Channel().UUID()).Size++
+	}
+	for uuid := range mb.pausedQueues {
+		getInfo(uuid).Paused = true
+	}
+
+	result := make([]*courier.QueueInfo, 0, len(infos))
+	for _, uuid := range slices.Sorted(maps.Keys(infos)) {
+		result = append(result, infos[uuid])
+	}
+	return result, nil
+}
+
+// PeekQueue returns up to limit pending messages for the given channel
+func (mb *MockBackend) PeekQueue(ctx context.Context, uuid courier.ChannelUUID, limit int) ([]json.RawMessage, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	msgs := make([]json.RawMessage, 0, limit)
+	for _, m := range mb.outgoingMsgs {
+		if m.Channel().UUID() == uuid && len(msgs) < limit {
+			msgs = append(msgs, jsonx.MustMarshal(map[string]any{"id": m.ID(), "text": m.Text()}))
+		}
+	}
+	return msgs, nil
+}
+
+// PurgeQueue removes all pending messages for the given channel
+func (mb *MockBackend) PurgeQueue(ctx context.Context, uuid courier.ChannelUUID) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	before := len(mb.outgoingMsgs)
+	mb.outgoingMsgs = slices.DeleteFunc(mb.outgoingMsgs, func(m courier.MsgOut) bool { return m.Channel().UUID() == uuid })
+	return before - len(mb.outgoingMsgs), nil
+}
+
+// PauseQueue pauses or resumes sending of messages for the given channel
+func (mb *MockBackend) PauseQueue(ctx context.Context, uuid courier.ChannelUUID, pause bool) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if pause {
+		mb.pausedQueues[uuid] = true
+	} else {
+		delete(mb.pausedQueues, uuid)
+	}
+	return nil
+}
+
+// DrainQueue moves all pending messages for the given channel onto the queue of another channel
+func (mb *MockBackend) DrainQueue(ctx context.Context, from, to courier.ChannelUUID) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	target, found := mb.channels[to]
+	if !found {
+		return 0, courier.ErrChannelNotFound
+	}
+
+	drained := 0
+	for _, m := range mb.outgoingMsgs {
+		if m.Channel().UUID() == from {
+			m.(*MockMsg).channel = target
+			drained++
+		}
+	}
+	return drained, nil
+}
+
+// DeadLetters returns the dead letters for the given channel
+func (mb *MockBackend) DeadLetters(ctx context.Context, uuid courier.ChannelUUID) ([]*courier.DeadLetter, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	return append([]*courier.DeadLetter{}, mb.deadLetters[uuid]...), nil
+}
+
+// RequeueDeadLetters clears the dead letters for the given channel
+func (mb *MockBackend) RequeueDeadLetters(ctx context.Context, uuid courier.ChannelUUID) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	requeued := len(mb.deadLetters[uuid])
+	for _, l := range mb.deadLetters[uuid] {
+		delete(mb.sentMsgs, l.MsgID)
+	}
+	delete(mb.deadLetters, uuid)
+	return requeued, nil
+}
+
+// RecentChannelLogs returns the written channel logs of the given channel which match the given query, newest first
+func (mb *MockBackend) RecentChannelLogs(ctx context.Context, uuid courier.ChannelUUID, query *courier.ChannelLogQuery) ([]*courier.RecentChannelLog, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	logs := make([]*courier.RecentChannelLog, 0, query.Limit)
+	for i := len(mb.writtenChannelLogs) - 1; i >= 0 && len(logs) < query.Limit; i-- {
+		clog := mb.writtenChannelLogs[i]
+		if clog.Channel() == nil || clog.Channel().UUID() != uuid {
+			continue
+		}
+		if l := courier.NewRecentChannelLog(clog); query.Matches(l) {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// GetArchivedMsg returns the archived message with the given UUID
+func (mb *MockBackend) GetArchivedMsg(ctx context.Context, uuid courier.MsgUUID) (*courier.ArchivedMsg, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	msg := mb.archivedMsgs[uuid]
+	if msg == nil {
+		return nil, courier.ErrMsgNotFound
+	}
+	return msg, nil
+}
+
+// ResendArchivedMsg records a resend of the archived message with the given UUID
+func (mb *MockBackend) ResendArchivedMsg(ctx context.Context, uuid courier.MsgUUID, urn urns.URN) (*courier.ArchivedMsg, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	msg := mb.archivedMsgs[uuid]
+	if msg == nil {
+		return nil, courier.ErrMsgNotFound
+	}
+
+	resent := *msg
+	if urn != urns.NilURN {
+		resent.URN = urn
+	}
+	mb.resentMsgs = append(mb.resentMsgs, &resent)
+	return &resent, nil
+}
+
+// MsgTimeline returns the timeline of the message with the given UUID
+func (mb *MockBackend) MsgTimeline(ctx context.Context, uuid courier.MsgUUID) (*courier.MsgTimeline, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	timeline := mb.msgTimelines[uuid]
+	if timeline == nil {
+		return nil, courier.ErrMsgNotFound
+	}
+	return timeline, nil
 }
 
 func (mb *MockBackend) OnReceiveComplete(ctx context.Context, ch courier.Channel, events []courier.Event, clog *courier.ChannelLog) {
@@ -190,6 +466,15 @@ func (mb *MockBackend) WriteChannelLog(ctx context.Context, clog *courier.Channe
 	return nil
 }
 
+// WriteChannelQuality records the passed in channel quality
+func (mb *MockBackend) WriteChannelQuality(ctx context.Context, ch courier.Channel, quality *courier.ChannelQuality) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.channelQualities[ch.UUID()] = quality
+	return nil
+}
+
 // SetErrorOnQueue is a mock method which makes the QueueMsg call throw the passed in error on next call
 func (mb *MockBackend) SetErrorOnQueue(shouldError bool) {
 	mb.errorOnQueue = shouldError
@@ -289,6 +574,21 @@ func (mb *MockBackend) GetChannel(ctx context.Context, cType courier.ChannelType
 	return channel, nil
 }
 
+// GetChannelsByType returns all channels with the passed in type
+func (mb *MockBackend) GetChannelsByType(ctx context.Context, cType courier.ChannelType) ([]courier.Channel, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	channels := make([]courier.Channel, 0)
+	for _, ch := range mb.channels {
+		if ch.ChannelType() == cType {
+			channels = append(channels, ch)
+		}
+	}
+	slices.SortFunc(channels, func(a, b courier.Channel) int { return strings.Compare(string(a.UUID()), string(b.UUID())) })
+	return channels, nil
+}
+
 // GetChannelByAddress returns the channel with the passed in type and channel address
 func (mb *MockBackend) GetChannelByAddress(ctx context.Context, cType courier.ChannelType, address courier.ChannelAddress) (courier.Channel, error) {
 	channel, found := mb.channelsByAddress[address]
@@ -298,6 +598,26 @@ func (mb *MockBackend) GetChannelByAddress(ctx context.Context, cType courier.Ch
 	return channel, nil
 }
 
+// UpdateChannelConfig updates the config of the passed in channel
+func (mb *MockBackend) UpdateChannelConfig(ctx context.Context, channel courier.Channel, updates map[string]any) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.configConflict {
+		return courier.ErrChannelConfigConflict
+	}
+
+	for key, value := range updates {
+		channel.(*MockChannel).SetConfig(key, value)
+	}
+	return nil
+}
+
+// SetConfigConflict sets whether updating channel config should fail because it has been modified
+func (mb *MockBackend) SetConfigConflict(conflict bool) {
+	mb.configConflict = conflict
+}
+
 // GetContact creates a new contact with the passed in channel and URN
 func (mb *MockBackend) GetContact(ctx context.Context, channel courier.Channel, urn urns.URN, authTokens map[string]string, name string, clog *courier.ChannelLog) (courier.Contact, error) {
 	contact, found := mb.contacts[urn]
@@ -329,6 +649,15 @@ func (mb *MockBackend) Start() error { return nil }
 // Stop stops our mock backend
 func (mb *MockBackend) Stop() error { return nil }
 
+// Flush records that we were flushed as we don't buffer anything
+func (mb *MockBackend) Flush(ctx context.Context) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.flushes++
+	return nil
+}
+
 // Cleanup cleans up any connections that are open
 func (mb *MockBackend) Cleanup() error { return nil }
 
@@ -357,10 +686,48 @@ func (mb *MockBackend) ResolveMedia(ctx context.Context, mediaUrl string) (couri
 	return media, nil
 }
 
+// TranscodeMedia returns the mocked transcoding of the passed in media to the given content type
+func (mb *MockBackend) TranscodeMedia(ctx context.Context, ch courier.Channel, media courier.Media, contentType string) (courier.Media, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	transcoded := mb.transcodedMedia[contentType+":"+media.URL()]
+	if transcoded == nil {
+		return nil, nil
+	}
+
+	return transcoded, nil
+}
+
+// ResizeImage returns the mocked resizing of the passed in media, if it fits within the given limits
+func (mb *MockBackend) ResizeImage(ctx context.Context, ch courier.Channel, media courier.Media, maxWidth, maxHeight, maxBytes int) (courier.Media, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	resized := mb.resizedMedia[media.URL()]
+	if resized == nil || (maxWidth > 0 && resized.Width() > maxWidth) || (maxHeight > 0 && resized.Height() > maxHeight) || (maxBytes > 0 && resized.Size() > maxBytes) {
+		return nil, nil
+	}
+
+	return resized, nil
+}
+
 func (mb *MockBackend) Health() string {
 	return ""
 }
 
+// CheckHealth checks our valkey, and fails a check for any dependency set as unhealthy
+func (mb *MockBackend) CheckHealth(ctx context.Context) []*courier.HealthCheck {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	checks := []*courier.HealthCheck{courier.CheckDependency("valkey", func() error { return valkey.Ping(ctx, mb.redisPool) })}
+	for _, name := range slices.Sorted(maps.Keys(mb.unhealthy)) {
+		checks = append(checks, courier.CheckDependency(name, func() error { return mb.unhealthy[name] }))
+	}
+	return checks
+}
+
 // Health gives a string representing our health, empty for our mock
 func (mb *MockBackend) HttpClient(bool) *http.Client {
 	return http.DefaultClient
@@ -376,7 +743,7 @@ func (mb *MockBackend) Status() string {
 }
 
 // RedisPool returns the redisPool for this backend
-func (mb *MockBackend) RedisPool() *redis.Pool {
+func (mb *MockBackend) RedisPool() valkey.Pool {
 	return mb.redisPool
 }
 
@@ -391,16 +758,84 @@ func (mb *MockBackend) WrittenChannelLogs() []*courier.ChannelLog     { return m
 func (mb *MockBackend) SavedAttachments() []*SavedAttachment          { return mb.savedAttachments }
 func (mb *MockBackend) URNAuthTokens() map[urns.URN]map[string]string { return mb.urnAuthTokens }
 
+// Flushes returns how many times we've been flushed
+func (mb *MockBackend) Flushes() int {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.flushes
+}
+
 // LastContactName returns the contact name set on the last msg or channel event written
 func (mb *MockBackend) LastContactName() string {
 	return mb.lastContactName
 }
 
+// ChannelQuality returns the last quality written for the given channel
+func (mb *MockBackend) ChannelQuality(uuid courier.ChannelUUID) *courier.ChannelQuality {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.channelQualities[uuid]
+}
+
+// AddArchivedMsg adds a previously sent message which can be read and resent
+func (mb *MockBackend) AddArchivedMsg(msg *courier.ArchivedMsg) {
+	mb.archivedMsgs[msg.UUID] = msg
+}
+
+// RequeuedMsgs returns the messages which have been requeued to be sent again later
+func (mb *MockBackend) RequeuedMsgs() []*RequeuedMsg {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.requeuedMsgs
+}
+
+// RequeuedSentMsgs returns the IDs of already sent messages which have been requeued
+func (mb *MockBackend) RequeuedSentMsgs() []courier.MsgID {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.requeuedSentMsgs
+}
+
+// FailedOverMsgs returns the messages which have been moved to fallback channels
+func (mb *MockBackend) FailedOverMsgs() []*FailedOverMsg {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.failedOverMsgs
+}
+
+// AddMsgTimeline adds the delivery timeline of a message
+func (mb *MockBackend) AddMsgTimeline(timeline *courier.MsgTimeline) {
+	mb.msgTimelines[timeline.MsgUUID] = timeline
+}
+
+// ResentMsgs returns the archived messages which have been resent
+func (mb *MockBackend) ResentMsgs() []*courier.ArchivedMsg {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.resentMsgs
+}
+
 // MockMedia adds the given media to the mocked backend
 func (mb *MockBackend) MockMedia(media courier.Media) {
 	mb.media[media.URL()] = media
 }
 
+// MockTranscodedMedia adds the given media as the transcoding of the media with the given URL
+func (mb *MockBackend) MockTranscodedMedia(url string, transcoded courier.Media) {
+	mb.transcodedMedia[transcoded.ContentType()+":"+url] = transcoded
+}
+
+// MockResizedMedia adds the given media as the resizing of the image with the given URL
+func (mb *MockBackend) MockResizedMedia(url string, resized courier.Media) {
+	mb.resizedMedia[url] = resized
+}
+
 // AddChannel adds a test channel to the test server
 func (mb *MockBackend) AddChannel(channel courier.Channel) {
 	mb.channels[channel.UUID()] = channel
@@ -422,6 +857,12 @@ func (mb *MockBackend) Reset() {
 	mb.writtenMsgStatuses = nil
 	mb.writtenChannelEvents = nil
 	mb.writtenChannelLogs = nil
+	mb.channelQualities = make(map[courier.ChannelUUID]*courier.ChannelQuality)
+	mb.deadLetters = make(map[courier.ChannelUUID][]*courier.DeadLetter)
+	mb.pausedQueues = make(map[courier.ChannelUUID]bool)
+	mb.resentMsgs = nil
+	mb.requeuedMsgs = nil
+	mb.requeuedSentMsgs = nil
 	mb.urnAuthTokens = nil
 }
 
@@ -430,6 +871,21 @@ func (mb *MockBackend) SetStorageError(err error) {
 	mb.storageError = err
 }
 
+// SetUnhealthy sets the named dependency as failing its health check with the given error, or healthy if nil
+func (mb *MockBackend) SetUnhealthy(name string, err error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.unhealthy == nil {
+		mb.unhealthy = make(map[string]error)
+	}
+	if err != nil {
+		mb.unhealthy[name] = err
+	} else {
+		delete(mb.unhealthy, name)
+	}
+}
+
 func (mb *MockBackend) recordURNAuthTokens(urn urns.URN, authTokens map[string]string) {
 	if mb.urnAuthTokens == nil {
 		mb.urnAuthTokens = make(map[urns.URN]map[string]string)
@@ -439,3 +895,17 @@ func (mb *MockBackend) recordURNAuthTokens(urn urns.URN, authTokens map[string]s
 	}
 	utils.MapUpdate(mb.urnAuthTokens[urn], authTokens)
 }
+
+var _ courier.ChannelLister = (*MockBackend)(nil)
+var _ courier.ChannelConfigUpdater = (*MockBackend)(nil)
+var _ courier.QualityWriter = (*MockBackend)(nil)
+var _ courier.QueueManager = (*MockBackend)(nil)
+var _ courier.MsgQueuer = (*MockBackend)(nil)
+var _ courier.BulkMsgPopper = (*MockBackend)(nil)
+var _ courier.MsgRequeuer = (*MockBackend)(nil)
+var _ courier.SentMsgRequeuer = (*MockBackend)(nil)
+var _ courier.DeadLetterStore = (*MockBackend)(nil)
+var _ courier.ChannelLogSearcher = (*MockBackend)(nil)
+var _ courier.MsgArchive = (*MockBackend)(nil)
+var _ courier.MediaProcessor = (*MockBackend)(nil)
+var _ courier.HealthChecker = (*MockBackend)(nil)
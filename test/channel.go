@@ -56,6 +56,11 @@ func (c *MockChannel) SetConfig(key string, value any) {
 	c.config[key] = value
 }
 
+// SetOrgConfig sets the passed in org config parameter
+func (c *MockChannel) SetOrgConfig(key string, value any) {
+	c.orgConfig[key] = value
+}
+
 // CallbackDomain returns the callback domain to use for this channel
 func (c *MockChannel) CallbackDomain(fallbackDomain string) string {
 	value, found := c.config[courier.ConfigCallbackDomain]
@@ -3,7 +3,10 @@ package test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils/clogs"
@@ -25,13 +28,24 @@ func NewMockHandler() courier.ChannelHandler {
 	return &mockHandler{}
 }
 
-func (h *mockHandler) Server() courier.Server                { return h.server }
-func (h *mockHandler) ChannelName() string                   { return "Mock Handler" }
-func (h *mockHandler) ChannelType() courier.ChannelType      { return courier.ChannelType("MCK") }
-func (h *mockHandler) UseChannelRouteUUID() bool             { return true }
-func (h *mockHandler) RedactValues(courier.Channel) []string { return []string{"sesame"} }
+func (h *mockHandler) Server() courier.Server           { return h.server }
+func (h *mockHandler) ChannelName() string              { return "Mock Handler" }
+func (h *mockHandler) ChannelType() courier.ChannelType { return courier.ChannelType("MCK") }
+func (h *mockHandler) UseChannelRouteUUID() bool        { return true }
+func (h *mockHandler) RedactValues(ch courier.Channel) []string {
+	// lets tests check how the sender copes with panics outside of sending itself
+	if ch.BoolConfigForKey("redact_panic", false) {
+		panic("bad redact values")
+	}
+	return []string{"sesame"}
+}
 
 func (h *mockHandler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	// use the channel from the backend if it's been added there, so that tests can control its config
+	if ch, err := h.backend.GetChannel(ctx, h.ChannelType(), courier.ChannelUUID(r.PathValue("uuid"))); err == nil {
+		return ch, nil
+	}
+
 	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
 	return dmChannel, nil
 }
@@ -55,8 +69,12 @@ func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier
 		return courier.ErrConnectionFailed
 	} else if trace.Response.StatusCode == 403 {
 		return courier.ErrContactStopped
+	} else if trace.Response.StatusCode == 404 {
+		return courier.ErrContactUnknown
 	} else if trace.Response.StatusCode == 429 {
 		return courier.ErrConnectionThrottled
+	} else if trace.Response.StatusCode == 402 {
+		return courier.ErrInsufficientBalance
 	}
 
 	// log an error than contains a value that should be redacted
@@ -64,8 +82,90 @@ func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier
 
 	if msg.Text() == "err:config" {
 		return courier.ErrChannelConfig
+	} else if msg.Text() == "err:unverified" {
+		return courier.ErrChannelUnverified
+	} else if msg.Text() == "err:retry" {
+		return courier.ErrRetryAfter(30 * time.Second)
+	} else if msg.Text() == "err:panic" {
+		panic("malformed message")
+	}
+
+	return nil
+}
+
+// MarkRead marks the given incoming message as read
+func (h *mockHandler) MarkRead(ctx context.Context, ch courier.Channel, externalID string, clog *courier.ChannelLog) error {
+	req, _ := httpx.NewRequest("POST", "http://mock.com/read/"+externalID, nil, nil)
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024)
+	clog.HTTP(trace)
+
+	if err != nil || trace.Response.StatusCode/100 != 2 {
+		return errors.New("unable to mark message as read")
+	}
+	return nil
+}
+
+// MaxBulkSize returns the maximum number of messages to send in a single request
+func (h *mockHandler) MaxBulkSize(ch courier.Channel) int {
+	return ch.IntConfigForKey(courier.ConfigMaxBulkSize, 1)
+}
+
+// SendBulk sends the given messages in a single request
+func (h *mockHandler) SendBulk(ctx context.Context, msgs []courier.MsgOut, results []*courier.SendResult, clog *courier.ChannelLog) error {
+	// request body is the address each message is sent from
+	froms := make([]string, len(msgs))
+	for i, msg := range msgs {
+		froms[i] = msg.Channel().Address()
+	}
+
+	req, _ := httpx.NewRequest("POST", "http://mock.com/send_bulk", strings.NewReader(strings.Join(froms, ",")), map[string]string{"Authorization": "Token sesame"})
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024)
+	clog.HTTP(trace)
+
+	if err != nil || trace.Response.StatusCode/100 == 5 {
+		return courier.ErrConnectionFailed
+	}
+
+	for i, msg := range msgs {
+		if msg.Text() == "err:panic" {
+			panic("malformed message")
+		} else if msg.Text() == "err:invalid" {
+			results[i].SetError(courier.ErrMessageInvalid)
+		} else {
+			results[i].AddExternalID(fmt.Sprintf("ext-%d", msg.ID()))
+		}
+	}
+	return nil
+}
+
+// CheckQuality returns the quality rating from the channel config
+func (h *mockHandler) CheckQuality(ctx context.Context, ch courier.Channel, clog *courier.ChannelLog) (*courier.ChannelQuality, error) {
+	return &courier.ChannelQuality{Rating: ch.StringConfigForKey("quality_rating", "GREEN"), CheckedOn: time.Now()}, nil
+}
+
+// FetchDeactivations returns the deactivated numbers from the channel config
+func (h *mockHandler) FetchDeactivations(ctx context.Context, ch courier.Channel, day time.Time, clog *courier.ChannelLog) ([]string, error) {
+	numbers := ch.StringConfigForKey("deactivated_numbers", "")
+	if numbers == "" {
+		return nil, nil
+	}
+	return strings.Split(numbers, ","), nil
+}
+
+// ConfigureProfile pushes the greeting from the channel config
+func (h *mockHandler) ConfigureProfile(ctx context.Context, ch courier.Channel, clog *courier.ChannelLog) error {
+	greeting := ch.StringConfigForKey("greeting", "")
+	if greeting == "" {
+		return errors.New("channel has no profile config")
 	}
 
+	req, _ := httpx.NewRequest("POST", "http://mock.com/profile", strings.NewReader(greeting), map[string]string{"Authorization": "Token sesame"})
+	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024)
+	clog.HTTP(trace)
+
+	if err != nil || trace.Response.StatusCode/100 != 2 {
+		return errors.New("unable to update profile")
+	}
 	return nil
 }
 
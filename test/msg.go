@@ -27,10 +27,12 @@ type MockMsg struct {
 	origin               courier.MsgOrigin
 	contactLastSeenOn    *time.Time
 	topic                string
+	urlPreview           *bool
 	responseToExternalID string
 	metadata             json.RawMessage
 	alreadyWritten       bool
 	isResend             bool
+	isEcho               bool
 	session              *courier.Session
 
 	flow   *courier.FlowReference
@@ -69,6 +71,7 @@ func (m *MockMsg) URNAuth() string                 { return m.urnAuth }
 func (m *MockMsg) Origin() courier.MsgOrigin       { return m.origin }
 func (m *MockMsg) ContactLastSeenOn() *time.Time   { return m.contactLastSeenOn }
 func (m *MockMsg) Topic() string                   { return m.topic }
+func (m *MockMsg) URLPreview() *bool               { return m.urlPreview }
 func (m *MockMsg) Metadata() json.RawMessage       { return m.metadata }
 func (m *MockMsg) ResponseToExternalID() string    { return m.responseToExternalID }
 func (m *MockMsg) SentOn() *time.Time              { return m.sentOn }
@@ -91,6 +94,12 @@ func (m *MockMsg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	return m
 }
 func (m *MockMsg) WithReceivedOn(date time.Time) courier.MsgIn { m.receivedOn = &date; return m }
+func (m *MockMsg) WithMetadata(metadata json.RawMessage) courier.MsgIn {
+	m.metadata = metadata
+	return m
+}
+func (m *MockMsg) WithEcho() courier.MsgIn { m.isEcho = true; return m }
+func (m *MockMsg) IsEcho() bool            { return m.isEcho }
 
 // used to create outgoing messages for testing
 func (m *MockMsg) WithID(id courier.MsgID) courier.MsgOut              { m.id = id; return m }
@@ -101,3 +110,4 @@ func (m *MockMsg) WithOptIn(o *courier.OptInReference) courier.MsgOut  { m.optIn
 func (m *MockMsg) WithUserID(uid courier.UserID) courier.MsgOut        { m.userID = uid; return m }
 func (m *MockMsg) WithLocale(lc i18n.Locale) courier.MsgOut            { m.locale = lc; return m }
 func (m *MockMsg) WithURNAuth(token string) courier.MsgOut             { m.urnAuth = token; return m }
+func (m *MockMsg) WithURLPreview(preview bool) courier.MsgOut          { m.urlPreview = &preview; return m }
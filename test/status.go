@@ -14,6 +14,9 @@ type MockStatusUpdate struct {
 	newURN     urns.URN
 	externalID string
 	status     courier.MsgStatus
+	reason     courier.MsgFailedReason
+	fallback   courier.ChannelUUID
+	segments   int
 	createdOn  time.Time
 }
 
@@ -35,3 +38,12 @@ func (m *MockStatusUpdate) SetExternalID(id string) { m.externalID = id }
 
 func (m *MockStatusUpdate) Status() courier.MsgStatus          { return m.status }
 func (m *MockStatusUpdate) SetStatus(status courier.MsgStatus) { m.status = status }
+
+func (m *MockStatusUpdate) FailedReason() courier.MsgFailedReason          { return m.reason }
+func (m *MockStatusUpdate) SetFailedReason(reason courier.MsgFailedReason) { m.reason = reason }
+
+func (m *MockStatusUpdate) FallbackChannel() courier.ChannelUUID       { return m.fallback }
+func (m *MockStatusUpdate) SetFallbackChannel(channel courier.Channel) { m.fallback = channel.UUID() }
+
+func (m *MockStatusUpdate) Segments() int     { return m.segments }
+func (m *MockStatusUpdate) SetSegments(n int) { m.segments = n }
@@ -0,0 +1,70 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+)
+
+// MsgTimelineEventType is the type of an event in the delivery timeline of a message
+type MsgTimelineEventType string
+
+// possible values for MsgTimelineEventType
+const (
+	MsgTimelineQueued      MsgTimelineEventType = "queued"
+	MsgTimelinePopped      MsgTimelineEventType = "popped"
+	MsgTimelineSendAttempt MsgTimelineEventType = "send_attempt"
+	MsgTimelineStatus      MsgTimelineEventType = "status"
+)
+
+// MsgTimelineEvent is a single step in the delivery of a message
+type MsgTimelineEvent struct {
+	Type      MsgTimelineEventType `json:"type"`
+	CreatedOn time.Time            `json:"created_on"`
+	Status    MsgStatus            `json:"status,omitempty"`
+	LogUUID   clogs.LogUUID        `json:"log_uuid,omitempty"`
+	ElapsedMS int                  `json:"elapsed_ms,omitempty"`
+	Errors    []*clogs.LogError    `json:"errors,omitempty"`
+
+	// for status events, the callback request made by the provider which will include their own timestamps
+	Request string `json:"request,omitempty"`
+}
+
+// MsgTimeline is everything we know about the delivery of an outgoing message, oldest event first
+type MsgTimeline struct {
+	MsgUUID     MsgUUID             `json:"msg_uuid"`
+	ChannelUUID ChannelUUID         `json:"channel_uuid"`
+	Status      MsgStatus           `json:"status"`
+	Events      []*MsgTimelineEvent `json:"events"`
+}
+
+type msgTimelineResponse struct {
+	Timeline *MsgTimeline `json:"timeline"`
+}
+
+func (s *server) handleGetMsgTimeline(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer cancel()
+
+	msgUUID, err := adminMsgUUID(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	timeline, err := s.archive.MsgTimeline(ctx, msgUUID)
+	if err == ErrMsgNotFound {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		slog.Error("error building msg timeline", "error", err, "msg_uuid", msgUUID)
+		WriteError(w, http.StatusInternalServerError, errors.New("error building timeline"))
+		return
+	}
+
+	writeAdminResponse(w, &msgTimelineResponse{Timeline: timeline})
+}
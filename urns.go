@@ -0,0 +1,46 @@
+package courier
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/nyaruka/gocommon/urns"
+)
+
+var appUserIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\.:@]{1,255}$`)
+
+// AppScheme is the scheme of URNs which identify users of customer-owned mobile apps by the id the app gives them, e.g.
+// app:user-1234. It isn't registered by gocommon so URNs with it must be validated with ValidateURN.
+var AppScheme = &urns.Scheme{
+	Prefix:   "app",
+	Name:     "App",
+	Validate: func(path string) bool { return appUserIDRegex.MatchString(path) },
+}
+
+// NewAppURN returns a validated app URN for the given app user id
+func NewAppURN(userID string) (urns.URN, error) {
+	urn := urns.URN(AppScheme.Prefix + ":" + userID).Normalize()
+	if err := ValidateURN(urn); err != nil {
+		return urns.NilURN, err
+	}
+	return urn, nil
+}
+
+// ValidateURN validates the given URN, including URNs with schemes that are defined by courier rather than gocommon
+func ValidateURN(u urns.URN) error {
+	scheme, path, _, display := u.ToParts()
+	if scheme != AppScheme.Prefix {
+		return u.Validate()
+	}
+
+	if path == "" {
+		return errors.New("scheme or path cannot be empty")
+	}
+	if !AppScheme.Validate(path) {
+		return errors.New("invalid path component")
+	}
+	if len(display) > 255 {
+		return errors.New("display component too long")
+	}
+	return nil
+}
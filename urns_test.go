@@ -0,0 +1,25 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppURNs(t *testing.T) {
+	urn, err := courier.NewAppURN("user-123")
+	assert.NoError(t, err)
+	assert.Equal(t, urns.URN("app:user-123"), urn)
+
+	_, err = courier.NewAppURN("user 123")
+	assert.EqualError(t, err, "invalid path component")
+
+	_, err = courier.NewAppURN("")
+	assert.EqualError(t, err, "scheme or path cannot be empty")
+
+	assert.NoError(t, courier.ValidateURN("app:bob@example.com#Bob"))
+	assert.NoError(t, courier.ValidateURN("tel:+250788383383"))
+	assert.EqualError(t, courier.ValidateURN("xyz:123"), "unknown URN scheme")
+}
@@ -0,0 +1,161 @@
+package batch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clock"
+)
+
+// Batcher allows values to be queued and processed in batches in a background thread, and unlike the batcher in
+// gocommon, can be flushed on demand and uses a clock which can be faked in tests.
+type Batcher[T any] struct {
+	process  func(batch []T) int
+	maxItems int
+	maxAge   time.Duration
+	clock    clock.Clock
+
+	wg      *sync.WaitGroup
+	buffer  chan T
+	flushes chan chan bool
+	stop    chan bool
+	stopped chan bool
+	started atomic.Bool
+	pending atomic.Int64
+	failed  atomic.Int64
+	batch   []T
+	timeout <-chan time.Time
+}
+
+// NewBatcher creates a new batcher. Queued items are passed to the `process` callback in batches of `maxItems` maximum
+// size, which returns how many of them it failed to process. Processing of a batch is triggered by reaching `maxItems`,
+// `maxAge` on the given clock since the oldest unprocessed item was queued, or by calling Flush.
+func NewBatcher[T any](process func(batch []T) int, maxItems int, maxAge time.Duration, bufferSize int, clk clock.Clock, wg *sync.WaitGroup) *Batcher[T] {
+	return &Batcher[T]{
+		process:  process,
+		maxItems: maxItems,
+		maxAge:   maxAge,
+		clock:    clk,
+		wg:       wg,
+		buffer:   make(chan T, bufferSize),
+		flushes:  make(chan chan bool),
+		stop:     make(chan bool),
+		stopped:  make(chan bool),
+		batch:    make([]T, 0, maxItems),
+	}
+}
+
+// Start starts this batcher's background processing, returning immediately.
+func (b *Batcher[T]) Start() {
+	b.started.Store(true)
+	b.wg.Add(1)
+
+	go func() {
+		defer b.wg.Done()
+		defer close(b.stopped)
+
+		for {
+			select {
+			case v := <-b.buffer:
+				b.batch = append(b.batch, v)
+
+				// if this is the first item in the batch we need to restart the age timeout
+				if b.timeout == nil {
+					b.timeout = b.clock.After(b.maxAge)
+				}
+
+				// if we have a full batch, flush it
+				if len(b.batch) == b.maxItems {
+					b.flush()
+				}
+
+			case <-b.timeout:
+				// flush whatever we have
+				b.flush()
+
+			case done := <-b.flushes:
+				b.drain()
+				close(done)
+
+			case <-b.stop:
+				b.drain()
+				return
+			}
+		}
+	}()
+}
+
+// Queue queues the given value, potentially blocking. Returns the new free capacity (batch + buffer).
+func (b *Batcher[T]) Queue(value T) int {
+	pending := b.pending.Add(1)
+	b.buffer <- value
+
+	return b.maxItems + cap(b.buffer) - int(pending)
+}
+
+// Flush processes everything which has been queued, blocking until it has been or the given context is done, in
+// which case its error is returned. Does nothing if this batcher isn't running.
+func (b *Batcher[T]) Flush(ctx context.Context) error {
+	if !b.started.Load() {
+		return nil
+	}
+
+	done := make(chan bool)
+
+	select {
+	case b.flushes <- done:
+	case <-b.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns how many queued items haven't been processed yet
+func (b *Batcher[T]) Pending() int {
+	return int(b.pending.Load())
+}
+
+// Failed returns how many items have failed to be processed since this batcher was created
+func (b *Batcher[T]) Failed() int {
+	return int(b.failed.Load())
+}
+
+// Stop stops this batcher, processing anything which is still queued.
+func (b *Batcher[T]) Stop() {
+	close(b.stop)
+}
+
+// flushes whatever has been batched
+func (b *Batcher[T]) flush() {
+	if len(b.batch) > 0 {
+		b.failed.Add(int64(b.process(b.batch)))
+		b.pending.Add(-int64(len(b.batch)))
+		b.batch = make([]T, 0, b.maxItems)
+	}
+	b.timeout = nil
+}
+
+// processes everything in the batch and buffer until they're both empty
+func (b *Batcher[T]) drain() {
+	for len(b.buffer) > 0 || len(b.batch) > 0 {
+		buffSize := len(b.buffer)
+		canRead := min(b.maxItems-len(b.batch), buffSize)
+
+		for i := 0; i < canRead; i++ {
+			v := <-b.buffer
+			b.batch = append(b.batch, v)
+		}
+
+		b.flush()
+	}
+}
@@ -0,0 +1,101 @@
+package batch_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/batch"
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	wg := &sync.WaitGroup{}
+	batches := make(chan []int, 10)
+
+	// odd numbers fail to be processed
+	b := batch.NewBatcher(func(batch []int) int {
+		batches <- batch
+		failed := 0
+		for _, v := range batch {
+			failed += v % 2
+		}
+		return failed
+	}, 3, time.Millisecond*500, 10, clk, wg)
+
+	// flushing before starting does nothing
+	assert.NoError(t, b.Flush(ctx))
+
+	b.Start()
+
+	// reaching the max batch size triggers processing
+	b.Queue(1)
+	b.Queue(2)
+	b.Queue(3)
+	assert.Equal(t, []int{1, 2, 3}, <-batches)
+
+	// as does reaching the max age
+	b.Queue(4)
+	assert.Eventually(t, func() bool { return clk.Waiters() == 2 }, time.Second, time.Millisecond)
+
+	clk.Advance(time.Millisecond * 400)
+	assert.Len(t, batches, 0)
+	assert.Equal(t, 1, b.Pending())
+
+	clk.Advance(time.Millisecond * 100)
+	assert.Equal(t, []int{4}, <-batches)
+
+	// flushing processes everything queued, without waiting for the clock
+	b.Queue(5)
+	b.Queue(6)
+	b.Queue(7)
+	b.Queue(8)
+	assert.NoError(t, b.Flush(ctx))
+
+	assert.Equal(t, []int{5, 6, 7}, <-batches)
+	assert.Equal(t, []int{8}, <-batches)
+	assert.Len(t, batches, 0)
+	assert.Equal(t, 0, b.Pending())
+	assert.Equal(t, 4, b.Failed())
+
+	// stopping processes anything still queued
+	b.Queue(9)
+	b.Stop()
+	wg.Wait()
+
+	assert.Equal(t, []int{9}, <-batches)
+	assert.Equal(t, 5, b.Failed())
+
+	// flushing after stopping does nothing
+	assert.NoError(t, b.Flush(ctx))
+}
+
+func TestBatcherFlushDeadline(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	unblock := make(chan bool)
+
+	b := batch.NewBatcher(func(batch []int) int { <-unblock; return 0 }, 2, time.Millisecond*500, 10, clock.Real, wg)
+	b.Start()
+
+	b.Queue(1)
+	b.Queue(2)
+	b.Queue(3)
+
+	// processing of the first batch is blocked so flushing can't complete before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, b.Flush(ctx))
+	assert.Equal(t, 3, b.Pending())
+
+	close(unblock)
+	assert.NoError(t, b.Flush(context.Background()))
+	assert.Equal(t, 0, b.Pending())
+
+	b.Stop()
+	wg.Wait()
+}
@@ -0,0 +1,81 @@
+package clamd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamd rejects streams with chunks larger than its StreamMaxLength so we send data in chunks well under the default
+const chunkSize = 64 * 1024
+
+// Client scans data for viruses using a clamd instance over its INSTREAM command.
+// See https://docs.clamav.net/manual/Usage/Scanning.html#clamd
+type Client struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClient creates a new client for the clamd instance at the given TCP address, e.g. localhost:3310
+func NewClient(address string, timeout time.Duration) *Client {
+	return &Client{address: address, timeout: timeout}
+}
+
+// Scan streams the given data to clamd, returning the name of the signature that it matched if it's infected, or empty
+// string if it's clean
+func (c *Client) Scan(ctx context.Context, data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return "", fmt.Errorf("error connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("error writing to clamd: %w", err)
+	}
+
+	// data is sent as chunks prefixed with their length, and terminated by a zero length chunk
+	for {
+		n := min(len(data), chunkSize)
+		chunk := binary.BigEndian.AppendUint32(make([]byte, 0, 4+n), uint32(n))
+		if _, err := conn.Write(append(chunk, data[:n]...)); err != nil {
+			return "", fmt.Errorf("error writing to clamd: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		data = data[n:]
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("error reading from clamd: %w", err)
+	}
+
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parses a reply like "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+
+	if result == "OK" {
+		return "", nil
+	}
+	if sig, found := strings.CutSuffix(result, " FOUND"); found {
+		return sig, nil
+	}
+	return "", fmt.Errorf("unexpected reply from clamd: %s", reply)
+}
@@ -0,0 +1,91 @@
+package clamd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clamd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// starts a fake clamd which reads streamed data and replies as if data containing "EICAR" is infected
+func startFakeClamd(t *testing.T, reply string) (string, *bytes.Buffer) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	received := &bytes.Buffer{}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			cmd := make([]byte, 10)
+			io.ReadFull(conn, cmd)
+			received.Reset()
+
+			for {
+				size := make([]byte, 4)
+				if _, err := io.ReadFull(conn, size); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				io.CopyN(received, conn, int64(n))
+			}
+
+			if reply != "" {
+				conn.Write([]byte(reply))
+			} else if bytes.Contains(received.Bytes(), []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	addr, received := startFakeClamd(t, "")
+	client := clamd.NewClient(addr, time.Second)
+
+	sig, err := client.Scan(ctx, []byte("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", sig)
+	assert.Equal(t, "hello world", received.String())
+
+	sig, err = client.Scan(ctx, []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Eicar-Signature", sig)
+
+	// data larger than a chunk is streamed in several chunks
+	big := bytes.Repeat([]byte("abcdefgh"), 20000)
+	sig, err = client.Scan(ctx, big)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sig)
+	assert.Equal(t, big, received.Bytes())
+
+	// an error reply is returned as an error
+	addr, _ = startFakeClamd(t, "INSTREAM size limit exceeded. ERROR\x00")
+	_, err = clamd.NewClient(addr, time.Second).Scan(ctx, []byte("hello world"))
+	assert.EqualError(t, err, "unexpected reply from clamd: INSTREAM size limit exceeded. ERROR")
+
+	// as is not being able to connect
+	_, err = clamd.NewClient("127.0.0.1:1", time.Second).Scan(ctx, []byte("hello world"))
+	assert.ErrorContains(t, err, "error connecting to clamd")
+}
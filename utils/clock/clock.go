@@ -0,0 +1,85 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers, and can be replaced in tests by a fake which is advanced manually
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+// Real is the clock which uses the actual time
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// Fake is a clock which only moves when advanced, firing any timers which become due
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// NewFake creates a new fake clock set to the given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the current time of this clock
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.now
+}
+
+// After returns a channel which receives the time once this clock has been advanced by at least the given duration
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	w := &waiter{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+	} else {
+		f.waiters = append(f.waiters, w)
+	}
+	return w.c
+}
+
+// Advance moves this clock forward by the given duration, firing any timers which are now due
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if !w.at.After(f.now) {
+			w.c <- f.now
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	f.waiters = pending
+}
+
+// Waiters returns the number of timers which haven't fired yet, which tests can use to know when something is waiting
+func (f *Fake) Waiters() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.waiters)
+}
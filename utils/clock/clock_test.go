@@ -0,0 +1,38 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+
+	assert.Equal(t, start, clk.Now())
+
+	c1 := clk.After(time.Second)
+	c2 := clk.After(3 * time.Second)
+	assert.Equal(t, 2, clk.Waiters())
+
+	clk.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), clk.Now())
+	assert.Len(t, c1, 0)
+
+	clk.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-c1)
+	assert.Len(t, c2, 0)
+	assert.Equal(t, 1, clk.Waiters())
+
+	clk.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute+time.Second), <-c2)
+	assert.Equal(t, 0, clk.Waiters())
+
+	// timers with no duration fire immediately
+	assert.Equal(t, start.Add(time.Minute+time.Second), <-clk.After(0))
+
+	assert.WithinDuration(t, time.Now(), clock.Real.Now(), time.Second)
+}
@@ -52,8 +52,9 @@ type Log struct {
 	CreatedOn time.Time
 	Elapsed   time.Duration
 
-	recorder *httpx.Recorder
-	redactor stringsx.Redactor
+	recorder  *httpx.Recorder
+	redactor  stringsx.Redactor
+	retention time.Duration
 }
 
 func NewLog(t LogType, r *httpx.Recorder, redactVals []string) *Log {
@@ -89,6 +90,11 @@ func (l *Log) End() {
 	l.Elapsed = time.Since(l.CreatedOn)
 }
 
+// SetRetention sets how long this log is kept for in place of the default of a week
+func (l *Log) SetRetention(d time.Duration) {
+	l.retention = d
+}
+
 func (l *Log) traceToLog(t *httpx.Trace) *httpx.Log {
 	return httpx.NewLog(t, 2048, 50000, l.redactor)
 }
@@ -114,13 +120,18 @@ func (l *Log) MarshalDynamo() (map[string]types.AttributeValue, error) {
 		return nil, fmt.Errorf("error marshaling log data: %w", err)
 	}
 
+	retention := dynamoTTL
+	if l.retention > 0 {
+		retention = l.retention
+	}
+
 	return attributevalue.MarshalMap(&dynamoLog{
 		UUID:      l.UUID,
 		Type:      l.Type,
 		DataGZ:    data,
 		ElapsedMS: int(l.Elapsed / time.Millisecond),
 		CreatedOn: l.CreatedOn,
-		ExpiresOn: l.CreatedOn.Add(dynamoTTL),
+		ExpiresOn: l.CreatedOn.Add(retention),
 	})
 }
 
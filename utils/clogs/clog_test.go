@@ -69,3 +69,18 @@ func TestLogs(t *testing.T) {
 	assert.Equal(t, l1.Elapsed, l3.Elapsed)
 	assert.Equal(t, l1.CreatedOn.Truncate(time.Second), l3.CreatedOn)
 }
+
+func TestLogRetention(t *testing.T) {
+	expiresOn := func(l *clogs.Log) string {
+		item, err := l.MarshalDynamo()
+		require.NoError(t, err)
+		return item["ExpiresOn"].(*types.AttributeValueMemberN).Value
+	}
+
+	l := clogs.NewLog("test_type", nil, nil)
+	l.CreatedOn = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "1791460800", expiresOn(l)) // 7 days later
+
+	l.SetRetention(24 * time.Hour)
+	assert.Equal(t, "1790942400", expiresOn(l)) // 1 day later
+}
@@ -0,0 +1,208 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DialFunc is the type of a function which dials a network address
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+type entry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// Resolver caches the results of host lookups for as long as the TTLs of their DNS records allow, up to a maximum
+type Resolver struct {
+	resolver *net.Resolver
+	maxTTL   time.Duration
+
+	mutex   sync.RWMutex
+	entries map[string]*entry
+
+	// TTLs seen in DNS responses by the queried name, recorded by our connections to the name server
+	ttls sync.Map
+}
+
+// NewResolver creates a new caching resolver which uses the system's name servers
+func NewResolver(maxTTL time.Duration) *Resolver {
+	d := &net.Dialer{}
+	return newResolver(maxTTL, d.DialContext)
+}
+
+func newResolver(maxTTL time.Duration, dial DialFunc) *Resolver {
+	r := &Resolver{maxTTL: maxTTL, entries: make(map[string]*entry)}
+
+	// the pure Go resolver lets us see the DNS messages so we can read their TTLs
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			if pc, isPacket := conn.(net.PacketConn); isPacket {
+				return &ttlConn{Conn: conn, pc: pc, record: r.recordTTL}, nil
+			}
+			return conn, nil // TCP responses are length prefixed and read in pieces so we don't try to inspect them
+		},
+	}
+	return r
+}
+
+// LookupIPAddr looks up the addresses of the given host, using cached results if they haven't expired
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	key := strings.ToLower(host)
+	now := time.Now()
+
+	r.mutex.RLock()
+	e := r.entries[key]
+	r.mutex.RUnlock()
+
+	if e != nil && now.Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	r.ttls.Delete(key)
+
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// if we didn't see a TTL, e.g. because the host is in /etc/hosts, then we use our maximum
+	ttl := r.maxTTL
+	if seen, ok := r.ttls.LoadAndDelete(key); ok {
+		ttl = min(seen.(time.Duration), r.maxTTL)
+	}
+
+	r.mutex.Lock()
+	if ttl > 0 {
+		r.entries[key] = &entry{addrs: addrs, expires: now.Add(ttl)}
+	} else {
+		delete(r.entries, key)
+	}
+	r.mutex.Unlock()
+
+	return addrs, nil
+}
+
+// DialContext returns a dial function for use by HTTP transports which looks up hosts using this resolver and tries
+// each of their addresses in turn
+func (r *Resolver) DialContext(dialer *net.Dialer) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		addrs, err := r.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var dialErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = errors.Join(dialErr, err)
+		}
+
+		if dialErr == nil {
+			dialErr = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+		}
+		return nil, dialErr
+	}
+}
+
+// Flush removes all cached lookups
+func (r *Resolver) Flush() {
+	r.mutex.Lock()
+	r.entries = make(map[string]*entry)
+	r.mutex.Unlock()
+}
+
+// records the smallest TTL seen for the given name, as A and AAAA lookups are separate queries
+func (r *Resolver) recordTTL(name string, ttl time.Duration) {
+	for {
+		existing, loaded := r.ttls.LoadOrStore(name, ttl)
+		if !loaded || existing.(time.Duration) <= ttl || r.ttls.CompareAndSwap(name, existing, ttl) {
+			return
+		}
+	}
+}
+
+// ttlConn wraps a packet connection to a name server to record the TTLs of answers in the responses read from it. It
+// has to remain a packet connection as that's how the resolver decides whether messages are length prefixed.
+type ttlConn struct {
+	net.Conn
+	pc     net.PacketConn
+	record func(string, time.Duration)
+}
+
+func (c *ttlConn) ReadFrom(b []byte) (int, net.Addr, error)     { return c.pc.ReadFrom(b) }
+func (c *ttlConn) WriteTo(b []byte, addr net.Addr) (int, error) { return c.pc.WriteTo(b, addr) }
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if name, ttl, ok := answerTTL(b[:n]); ok {
+			c.record(name, ttl)
+		}
+	}
+	return n, err
+}
+
+// answerTTL parses the given DNS response and returns the queried name and the smallest TTL of its answers
+func answerTTL(msg []byte) (string, time.Duration, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return "", 0, false
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return "", 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return "", 0, false
+	}
+
+	var minTTL uint32
+	found := false
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return "", 0, false
+		}
+		if !found || h.TTL < minTTL {
+			minTTL = h.TTL
+			found = true
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return "", 0, false
+		}
+	}
+
+	if !found {
+		return "", 0, false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	return name, time.Duration(minTTL) * time.Second, true
+}
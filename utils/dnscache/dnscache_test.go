@@ -0,0 +1,183 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// starts a name server which answers A queries for the given hosts with the given TTL
+func startNameServer(t *testing.T, hosts map[string]string, ttl uint32) (string, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	queries := &atomic.Int32{}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true})
+			b.EnableCompression()
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+
+			if q.Type == dnsmessage.TypeA {
+				queries.Add(1)
+
+				if ip, ok := hosts[q.Name.String()]; ok {
+					b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.AResource{A: [4]byte(net.ParseIP(ip).To4())})
+				}
+			}
+
+			resp, _ := b.Finish()
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), queries
+}
+
+func newTestResolver(maxTTL time.Duration, nameServer string) *Resolver {
+	d := &net.Dialer{}
+	return newResolver(maxTTL, func(ctx context.Context, network, address string) (net.Conn, error) {
+		return d.DialContext(ctx, "udp", nameServer)
+	})
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	nameServer, queries := startNameServer(t, map[string]string{"api.example.com.": "10.1.2.3"}, 2)
+
+	r := newTestResolver(time.Minute, nameServer)
+
+	addrs, err := r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IPAddr{{IP: net.ParseIP("10.1.2.3").To4()}}, addrs)
+	assert.Equal(t, int32(1), queries.Load())
+
+	// second lookup should come from the cache
+	addrs, err = r.LookupIPAddr(ctx, "API.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IPAddr{{IP: net.ParseIP("10.1.2.3").To4()}}, addrs)
+	assert.Equal(t, int32(1), queries.Load())
+
+	// entry should be cached for the record's TTL rather than our max
+	r.mutex.RLock()
+	e := r.entries["api.example.com"]
+	r.mutex.RUnlock()
+	assert.WithinDuration(t, time.Now().Add(2*time.Second), e.expires, time.Second)
+
+	// once the entry expires, we look it up again
+	r.mutex.Lock()
+	e.expires = time.Now().Add(-time.Second)
+	r.mutex.Unlock()
+
+	_, err = r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), queries.Load())
+
+	r.Flush()
+
+	_, err = r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), queries.Load())
+
+	// failed lookups aren't cached
+	_, err = r.LookupIPAddr(ctx, "missing.example.com")
+	assert.Error(t, err)
+	assert.Len(t, r.entries, 1)
+}
+
+func TestResolverMaxTTL(t *testing.T) {
+	ctx := context.Background()
+	nameServer, queries := startNameServer(t, map[string]string{"api.example.com.": "10.1.2.3"}, 3600)
+
+	// records are only cached for our max TTL
+	r := newTestResolver(time.Minute, nameServer)
+
+	_, err := r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), r.entries["api.example.com"].expires, time.Second)
+
+	// and a max TTL of zero disables caching
+	r = newTestResolver(0, nameServer)
+
+	_, err = r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	_, err = r.LookupIPAddr(ctx, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), queries.Load())
+	assert.Len(t, r.entries, 0)
+}
+
+func TestDialContext(t *testing.T) {
+	ctx := context.Background()
+
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	nameServer, _ := startNameServer(t, map[string]string{"api.example.com.": "127.0.0.1"}, 60)
+	dial := newTestResolver(time.Minute, nameServer).DialContext(&net.Dialer{Timeout: time.Second})
+
+	conn, err := dial(ctx, "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	assert.Equal(t, server.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+
+	// IP addresses are dialed directly
+	conn, err = dial(ctx, "tcp", server.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+
+	_, err = dial(ctx, "tcp", net.JoinHostPort("missing.example.com", port))
+	assert.Error(t, err)
+
+	_, err = dial(ctx, "tcp", "api.example.com")
+	assert.Error(t, err)
+}
+
+func TestAnswerTTL(t *testing.T) {
+	name := dnsmessage.MustNewName("Api.Example.com.")
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	b.StartAnswers()
+	b.CNAMEResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300}, dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("lb.example.com.")})
+	b.AResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("lb.example.com."), Class: dnsmessage.ClassINET, TTL: 30}, dnsmessage.AResource{A: [4]byte{10, 1, 2, 3}})
+	msg, err := b.Finish()
+	require.NoError(t, err)
+
+	host, ttl, ok := answerTTL(msg)
+	assert.True(t, ok)
+	assert.Equal(t, "api.example.com", host)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	_, _, ok = answerTTL([]byte("garbage"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,104 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	// decoders for the image formats we can resize
+	_ "image/gif"
+	_ "image/png"
+)
+
+// JPEG qualities we try in order until the encoded image is small enough
+var qualities = []int{85, 75, 65, 55, 45}
+
+// number of times we halve the dimensions of an image that's still too big at the lowest quality
+const maxHalvings = 3
+
+// Resize scales down the given JPEG, PNG or GIF image so that it fits within the given dimensions and re-encodes it as
+// a JPEG, lowering its quality and then its dimensions until it's no more than the given number of bytes. Zero limits
+// are ignored. Transparent areas become white as JPEGs don't support transparency.
+func Resize(data []byte, maxWidth, maxHeight, maxBytes int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	width, height := fitWithin(src.Bounds().Dx(), src.Bounds().Dy(), maxWidth, maxHeight)
+	flat := flatten(src)
+
+	for range maxHalvings + 1 {
+		scaled := scale(flat, width, height)
+
+		for _, quality := range qualities {
+			buf := &bytes.Buffer{}
+			if err := jpeg.Encode(buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, fmt.Errorf("error encoding image: %w", err)
+			}
+			if maxBytes <= 0 || buf.Len() <= maxBytes {
+				return buf.Bytes(), nil
+			}
+		}
+
+		width, height = max(width/2, 1), max(height/2, 1)
+	}
+
+	return nil, errors.New("unable to compress image to the maximum size")
+}
+
+// returns the largest dimensions with the same aspect ratio as the given dimensions which fit within the given limits
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && width > maxWidth {
+		height, width = max(height*maxWidth/width, 1), maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width, height = max(width*maxHeight/height, 1), maxHeight
+	}
+	return width, height
+}
+
+// draws the given image onto a white background
+func flatten(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	return dst
+}
+
+// scales the given image down to the given dimensions, with each pixel being the average of the pixels it replaces
+func scale(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if width == sw && height == sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := range height {
+		y0, y1 := dy*sh/height, max((dy+1)*sh/height, dy*sh/height+1)
+
+		for dx := range width {
+			x0, x1 := dx*sw/width, max((dx+1)*sw/width, dx*sw/width+1)
+
+			var r, g, b, n int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r, g, b = r+int(row[i]), g+int(row[i+1]), b+int(row[i+2])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), 255
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,105 @@
+package images_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/nyaruka/courier/utils/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// creates a PNG of random noise, which compresses badly, with the given dimensions
+func noisyPNG(t *testing.T, width, height int) []byte {
+	rnd := rand.New(rand.NewSource(1234))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.Intn(256))
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, data []byte) (image.Image, string) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	return img, format
+}
+
+func TestResize(t *testing.T) {
+	src := noisyPNG(t, 400, 200)
+
+	// no limits just re-encodes as a JPEG
+	out, err := images.Resize(src, 0, 0, 0)
+	assert.NoError(t, err)
+	img, format := decode(t, out)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, image.Rect(0, 0, 400, 200), img.Bounds())
+
+	// scaled down to fit within dimensions, keeping the aspect ratio
+	out, err = images.Resize(src, 100, 100, 0)
+	assert.NoError(t, err)
+	img, _ = decode(t, out)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+
+	out, err = images.Resize(src, 0, 50, 0)
+	assert.NoError(t, err)
+	img, _ = decode(t, out)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+
+	// compressed to fit within the max size, which requires reducing the dimensions
+	out, err = images.Resize(src, 0, 0, 20*1024)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(out), 20*1024)
+	img, _ = decode(t, out)
+	assert.Less(t, img.Bounds().Dx(), 400)
+	assert.Equal(t, img.Bounds().Dx(), img.Bounds().Dy()*2)
+
+	// impossible to compress to the max size
+	_, err = images.Resize(src, 0, 0, 100)
+	assert.EqualError(t, err, "unable to compress image to the maximum size")
+
+	// not an image
+	_, err = images.Resize([]byte("not an image"), 100, 100, 0)
+	assert.EqualError(t, err, "error decoding image: image: unknown format")
+
+	// transparent areas become white
+	transparent := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, transparent))
+
+	out, err = images.Resize(buf.Bytes(), 0, 0, 0)
+	assert.NoError(t, err)
+	img, _ = decode(t, out)
+	r, g, b, _ := img.At(5, 5).RGBA()
+	assert.Greater(t, r, uint32(0xf000))
+	assert.Greater(t, g, uint32(0xf000))
+	assert.Greater(t, b, uint32(0xf000))
+
+	// scaled pixels are the average of the pixels they replace
+	checkered := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			if (x+y)%2 == 0 {
+				checkered.Set(x, y, color.Black)
+			} else {
+				checkered.Set(x, y, color.White)
+			}
+		}
+	}
+	buf.Reset()
+	require.NoError(t, jpeg.Encode(buf, checkered, &jpeg.Options{Quality: 100}))
+
+	out, err = images.Resize(buf.Bytes(), 8, 8, 0)
+	assert.NoError(t, err)
+	img, _ = decode(t, out)
+	gray := color.GrayModel.Convert(img.At(4, 4)).(color.Gray)
+	assert.InDelta(t, 127, gray.Y, 10)
+}
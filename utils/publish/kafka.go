@@ -0,0 +1,66 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// Kafka publishes to topics of a Kafka cluster
+type Kafka struct {
+	client *kgo.Client
+}
+
+// NewKafka creates a new publisher for the Kafka cluster with the brokers at the given URL, e.g.
+// kafka://broker1:9092,broker2:9092, which can include a user and password for SASL/PLAIN authentication. Brokers are
+// connected to as needed.
+func NewKafka(u *url.URL) (*Kafka, error) {
+	opts := []kgo.Opt{kgo.SeedBrokers(strings.Split(u.Host, ",")...), kgo.ClientID("courier")}
+
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		opts = append(opts, kgo.SASL(plain.Auth{User: u.User.Username(), Pass: pass}.AsMechanism()))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kafka client: %w", err)
+	}
+	return &Kafka{client: client}, nil
+}
+
+func (k *Kafka) Name() string { return "kafka" }
+
+// Publish publishes the given messages to the given topic and waits for the brokers to acknowledge them
+func (k *Kafka) Publish(ctx context.Context, topic string, msgs [][]byte) error {
+	records := make([]*kgo.Record, len(msgs))
+	for i, m := range msgs {
+		records[i] = &kgo.Record{Topic: topic, Value: m}
+	}
+
+	// records can fail individually
+	var firstErr error
+	failed := 0
+	for _, r := range k.client.ProduceSync(ctx, records...) {
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records failed to be published: %w", failed, len(msgs), firstErr)
+	}
+	return nil
+}
+
+// Close closes our connections to the brokers
+func (k *Kafka) Close() error {
+	k.client.Close()
+	return nil
+}
@@ -0,0 +1,66 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes to subjects of a NATS server
+type NATS struct {
+	url string
+
+	mutex sync.Mutex
+	conn  *nats.Conn
+}
+
+// NewNATS creates a new publisher for the NATS server at the given URL, which can include a user and password, or a
+// token as the user. It connects on first publish, and the client reconnects if the connection is lost.
+func NewNATS(u *url.URL) *NATS {
+	return &NATS{url: u.String()}
+}
+
+func (n *NATS) Name() string { return "nats" }
+
+// Publish publishes the given messages to the given subject and waits for the server to acknowledge that it has
+// processed them
+func (n *NATS) Publish(ctx context.Context, subject string, msgs [][]byte) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.conn == nil || n.conn.IsClosed() {
+		conn, err := nats.Connect(n.url, nats.Name("courier"), nats.Timeout(10*time.Second))
+		if err != nil {
+			return fmt.Errorf("error connecting to NATS: %w", err)
+		}
+		n.conn = conn
+	}
+
+	for _, m := range msgs {
+		if err := n.conn.Publish(subject, m); err != nil {
+			return fmt.Errorf("error publishing to NATS: %w", err)
+		}
+	}
+
+	// flushing waits for the server to reply to a PING so we know it has processed our messages
+	if err := n.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("error publishing to NATS: %w", err)
+	}
+	return nil
+}
+
+// Close closes our connection to the server
+func (n *NATS) Close() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Publisher publishes messages to topics of an event streaming service
+type Publisher interface {
+	// Name returns the name of the service
+	Name() string
+
+	// Publish publishes the given messages to the given topic, returning an error if any couldn't be published
+	Publish(ctx context.Context, topic string, msgs [][]byte) error
+
+	// Close closes any connection to the service
+	Close() error
+}
+
+// New creates a new publisher for the service at the given URL, which can be a NATS server (nats://) or a Kafka cluster
+// (kafka://)
+func New(rawURL string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "nats":
+		return NewNATS(u), nil
+	case "kafka":
+		return NewKafka(u)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+}
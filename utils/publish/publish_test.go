@@ -0,0 +1,202 @@
+package publish_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+func TestNew(t *testing.T) {
+	p, err := publish.New("nats://localhost:4222")
+	assert.NoError(t, err)
+	assert.Equal(t, "nats", p.Name())
+
+	p, err = publish.New("kafka://localhost:9092,localhost:9093")
+	assert.NoError(t, err)
+	assert.Equal(t, "kafka", p.Name())
+
+	_, err = publish.New("https://kafka-rest.example.com")
+	assert.EqualError(t, err, "unsupported URL scheme: https")
+}
+
+func TestKafka(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "courier.msgs"), kfake.EnableSASL(), kfake.Superuser("PLAIN", "courier", "sesame"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	p, err := publish.New("kafka://courier:sesame@" + strings.Join(cluster.ListenAddrs(), ","))
+	require.NoError(t, err)
+
+	err = p.Publish(ctx, "courier.msgs", [][]byte{[]byte(`{"id":1}`), []byte(`{"id":2}`)})
+	assert.NoError(t, err)
+
+	// read back what was published
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(cluster.ListenAddrs()...),
+		kgo.SASL(plain.Auth{User: "courier", Pass: "sesame"}.AsMechanism()),
+		kgo.ConsumeTopics("courier.msgs"),
+	)
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	var values []string
+	for len(values) < 2 {
+		fetches := consumer.PollFetches(ctx)
+		require.NoError(t, fetches.Err())
+		fetches.EachRecord(func(r *kgo.Record) { values = append(values, string(r.Value)) })
+	}
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, values)
+
+	assert.NoError(t, p.Close())
+
+	// failing to authenticate is retried until our context is done
+	p, err = publish.New("kafka://courier:wrong@" + strings.Join(cluster.ListenAddrs(), ","))
+	require.NoError(t, err)
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer shortCancel()
+
+	err = p.Publish(shortCtx, "courier.msgs", [][]byte{[]byte(`{"id":3}`)})
+	assert.ErrorContains(t, err, "1 of 1 records failed to be published:")
+
+	assert.NoError(t, p.Close())
+}
+
+// testNATSServer is a minimal NATS server which records what is published to it
+type testNATSServer struct {
+	listener net.Listener
+	reject   string
+
+	mutex    sync.Mutex
+	connects []map[string]any
+	pubs     []string
+}
+
+func newTestNATSServer(t *testing.T) *testNATSServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testNATSServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch cmd {
+		case "CONNECT":
+			options := map[string]any{}
+			json.Unmarshal([]byte(args), &options)
+
+			s.mutex.Lock()
+			s.connects = append(s.connects, options)
+			s.mutex.Unlock()
+
+			if s.reject != "" {
+				conn.Write([]byte(fmt.Sprintf("-ERR '%s'\r\n", s.reject)))
+				return
+			}
+		case "PUB":
+			parts := strings.Fields(args)
+			size, _ := strconv.Atoi(parts[len(parts)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.pubs = append(s.pubs, parts[0]+" "+string(payload[:size]))
+			s.mutex.Unlock()
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+func (s *testNATSServer) Close() { s.listener.Close() }
+
+func TestNATS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := newTestNATSServer(t)
+	defer server.Close()
+
+	p, err := publish.New("nats://courier:sesame@" + server.listener.Addr().String())
+	require.NoError(t, err)
+
+	err = p.Publish(ctx, "courier.msgs", [][]byte{[]byte(`{"id":1}`), []byte(`{"id":2}`)})
+	assert.NoError(t, err)
+	err = p.Publish(ctx, "courier.statuses", [][]byte{[]byte(`{"id":3}`)})
+	assert.NoError(t, err)
+
+	assert.Len(t, server.connects, 1)
+	assert.Equal(t, "courier", server.connects[0]["user"])
+	assert.Equal(t, "sesame", server.connects[0]["pass"])
+	assert.Equal(t, false, server.connects[0]["verbose"])
+	assert.Equal(t, []string{`courier.msgs {"id":1}`, `courier.msgs {"id":2}`, `courier.statuses {"id":3}`}, server.pubs)
+
+	assert.NoError(t, p.Close())
+
+	// publishing after closing reconnects
+	err = p.Publish(ctx, "courier.msgs", [][]byte{[]byte(`{"id":4}`)})
+	assert.NoError(t, err)
+	assert.Len(t, server.connects, 2)
+	assert.Len(t, server.pubs, 4)
+
+	assert.NoError(t, p.Close())
+
+	// a user without a password is sent as a token, and errors from the server are returned
+	server.reject = "Authorization Violation"
+
+	p, err = publish.New("nats://s3cr3t@" + server.listener.Addr().String())
+	require.NoError(t, err)
+
+	err = p.Publish(ctx, "courier.msgs", [][]byte{[]byte(`{"id":5}`)})
+	assert.EqualError(t, err, "error connecting to NATS: nats: Authorization Violation")
+	assert.Equal(t, "s3cr3t", server.connects[2]["auth_token"])
+	assert.Len(t, server.pubs, 4)
+
+	// as are errors connecting
+	server.Close()
+
+	err = p.Publish(ctx, "courier.msgs", [][]byte{[]byte(`{"id":6}`)})
+	assert.ErrorContains(t, err, "error connecting to NATS:")
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureAPIVersion = "2021-08-06"
+
+// Azure is a container in Azure Blob Storage, accessed using the REST API with shared key authorization. Blobs are
+// written without any access level of their own so the container needs to allow public read access to blobs.
+type Azure struct {
+	client    *http.Client
+	account   string
+	key       []byte
+	endpoint  string
+	container string
+}
+
+// NewAzure creates a new Azure storage for the given container, using the base64 encoded access key of the account.
+// If endpoint is empty then the public endpoint of the account is used.
+func NewAzure(client *http.Client, account, key, endpoint, container string) (*Azure, error) {
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding azure storage key: %w", err)
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+
+	return &Azure{client: client, account: account, key: decodedKey, endpoint: strings.TrimSuffix(endpoint, "/"), container: container}, nil
+}
+
+func (s *Azure) Name() string { return fmt.Sprintf("azure:%s", s.container) }
+
+func (s *Azure) Test(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodGet, s.endpoint+"/"+s.container+"?restype=container", "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting azure container properties: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Azure) Get(ctx context.Context, path string) (string, []byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.blobURL(path), "", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil, ErrNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("error getting azure blob: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading azure blob: %w", err)
+	}
+
+	return resp.Header.Get("Content-Type"), data, nil
+}
+
+func (s *Azure) Put(ctx context.Context, path, contentType string, data []byte) (string, error) {
+	u := s.blobURL(path)
+
+	resp, err := s.do(ctx, http.MethodPut, u, contentType, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("error putting azure blob: %d", resp.StatusCode)
+	}
+	return u, nil
+}
+
+func (s *Azure) blobURL(path string) string {
+	return s.endpoint + "/" + s.container + "/" + (&url.URL{Path: strings.TrimPrefix(path, "/")}).EscapedPath()
+}
+
+func (s *Azure) do(ctx context.Context, method, u, contentType string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if method == http.MethodPut {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", s.account, s.sign(req, len(data))))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making azure storage request: %w", err)
+	}
+	return resp, nil
+}
+
+// signs the given request, see https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (s *Azure) sign(req *http.Request, contentLength int) string {
+	length := ""
+	if contentLength > 0 {
+		length = strconv.Itoa(contentLength)
+	}
+
+	// headers which are part of the signature, of which we only use content type, are blank if not set
+	parts := []string{req.Method, "", "", length, "", req.Header.Get("Content-Type"), "", "", "", "", "", ""}
+
+	msHeaders := make([]string, 0, 3)
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.Join(values, ","))
+		}
+	}
+	slices.Sort(msHeaders)
+	parts = append(parts, msHeaders...)
+
+	resource := "/" + s.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		params = append(params, strings.ToLower(name)+":"+strings.Join(values, ","))
+	}
+	slices.Sort(params)
+	parts = append(parts, resource)
+	parts = append(parts, params...)
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
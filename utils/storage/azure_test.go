@@ -0,0 +1,109 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nyaruka/courier/utils/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzure(t *testing.T) {
+	ctx := context.Background()
+	key := base64.StdEncoding.EncodeToString([]byte("sesame"))
+
+	// signs requests as described in https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+	expectedAuth := func(r *http.Request) string {
+		length := ""
+		if r.ContentLength > 0 {
+			length = strconv.Itoa(int(r.ContentLength))
+		}
+		headers := ""
+		if r.Header.Get("x-ms-blob-type") != "" {
+			headers += "x-ms-blob-type:" + r.Header.Get("x-ms-blob-type") + "\n"
+		}
+		headers += "x-ms-date:" + r.Header.Get("x-ms-date") + "\nx-ms-version:2021-08-06\n"
+		resource := "/devaccount" + r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			resource += "\nrestype:container"
+		}
+
+		mac := hmac.New(sha256.New, []byte("sesame"))
+		mac.Write([]byte(r.Method + "\n\n\n" + length + "\n\n" + r.Header.Get("Content-Type") + "\n\n\n\n\n\n\n" + headers + resource))
+		return "SharedKey devaccount:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	var mutex sync.Mutex
+	blobs := map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Header.Get("Authorization") != expectedAuth(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/attachments" && r.URL.Query().Get("restype") == "container":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/attachments/"):
+			body, _ := io.ReadAll(r.Body)
+			blobs[r.URL.Path] = r.Header.Get("Content-Type") + "|" + string(body)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet:
+			blob, found := blobs[r.URL.Path]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			contentType, body, _ := strings.Cut(blob, "|")
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	_, err := storage.NewAzure(http.DefaultClient, "devaccount", "not base64!", server.URL, "attachments")
+	assert.EqualError(t, err, "error decoding azure storage key: illegal base64 data at input byte 3")
+
+	s, err := storage.NewAzure(http.DefaultClient, "devaccount", key, server.URL, "attachments")
+	require.NoError(t, err)
+	assert.Equal(t, "azure:attachments", s.Name())
+	assert.NoError(t, s.Test(ctx))
+
+	url, err := s.Put(ctx, "media/1/hello world.txt", "text/plain", []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/attachments/media/1/hello%20world.txt", url)
+
+	contentType, data, err := s.Get(ctx, "media/1/hello world.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "hello", string(data))
+
+	_, _, err = s.Get(ctx, "media/1/missing.txt")
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	// with the wrong key our requests are rejected
+	s, err = storage.NewAzure(http.DefaultClient, "devaccount", base64.StdEncoding.EncodeToString([]byte("wrong")), server.URL, "attachments")
+	require.NoError(t, err)
+	assert.EqualError(t, s.Test(ctx), "error getting azure container properties: 403")
+
+	// without an endpoint we use the public one for the account
+	s, err = storage.NewAzure(http.DefaultClient, "devaccount", key, "", "attachments")
+	require.NoError(t, err)
+	assert.Equal(t, "azure:attachments", s.Name())
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCS is a bucket in Google Cloud Storage, accessed using the JSON API. Objects are written without any ACL of their own
+// so the bucket needs to allow public read access to objects.
+type GCS struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+}
+
+// NewGCSClient creates an HTTP client authorized to read and write to GCS using the given service account credentials
+// JSON, or if that's empty, the application default credentials of the environment.
+func NewGCSClient(ctx context.Context, credentialsJSON string) (*http.Client, error) {
+	if credentialsJSON == "" {
+		return google.DefaultClient(ctx, gcsScope)
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, []byte(credentialsJSON), gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("error reading gcs credentials: %w", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
+
+// NewGCS creates a new GCS storage for the given bucket, using the given authorized client. If endpoint is empty then
+// the public endpoint is used.
+func NewGCS(client *http.Client, endpoint, bucket string) *GCS {
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	return &GCS{client: client, endpoint: strings.TrimSuffix(endpoint, "/"), bucket: bucket}
+}
+
+func (s *GCS) Name() string { return fmt.Sprintf("gcs:%s", s.bucket) }
+
+func (s *GCS) Test(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s", s.endpoint, url.PathEscape(s.bucket)), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting gcs bucket: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *GCS) Get(ctx context.Context, path string) (string, []byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", s.endpoint, url.PathEscape(s.bucket), url.PathEscape(path)), "", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil, ErrNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("error getting gcs object: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading gcs object: %w", err)
+	}
+
+	return resp.Header.Get("Content-Type"), data, nil
+}
+
+func (s *GCS) Put(ctx context.Context, path, contentType string, data []byte) (string, error) {
+	path = strings.TrimPrefix(path, "/")
+	query := url.Values{"uploadType": []string{"media"}, "name": []string{path}}
+
+	resp, err := s.do(ctx, http.MethodPost, fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode()), contentType, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error putting gcs object: %d", resp.StatusCode)
+	}
+
+	return s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: path}).EscapedPath(), nil
+}
+
+func (s *GCS) do(ctx context.Context, method, u, contentType string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making gcs request: %w", err)
+	}
+	return resp, nil
+}
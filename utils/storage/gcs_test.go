@@ -0,0 +1,69 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nyaruka/courier/utils/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCS(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	objects := map[string][]byte{}
+	contentTypes := map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/attachments":
+			w.Write([]byte(`{"name": "attachments"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/attachments/o" && r.URL.Query().Get("uploadType") == "media":
+			name := r.URL.Query().Get("name")
+			objects[name], _ = io.ReadAll(r.Body)
+			contentTypes[name] = r.Header.Get("Content-Type")
+			w.Write([]byte(`{"name": "` + name + `"}`))
+		case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+			name := r.URL.Path[len("/storage/v1/b/attachments/o/"):]
+			data, found := objects[name]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", contentTypes[name])
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := storage.NewGCS(http.DefaultClient, server.URL, "attachments")
+	assert.Equal(t, "gcs:attachments", s.Name())
+	assert.NoError(t, s.Test(ctx))
+
+	url, err := s.Put(ctx, "media/1/hello world.txt", "text/plain", []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/attachments/media/1/hello%20world.txt", url)
+
+	contentType, data, err := s.Get(ctx, "media/1/hello world.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "hello", string(data))
+
+	_, _, err = s.Get(ctx, "media/1/missing.txt")
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	assert.EqualError(t, storage.NewGCS(http.DefaultClient, server.URL, "missing").Test(ctx), "error getting gcs bucket: 404")
+
+	_, err = storage.NewGCSClient(ctx, `{"type": "bogus"}`)
+	assert.Error(t, err)
+}
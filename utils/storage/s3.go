@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/nyaruka/gocommon/aws/s3x"
+)
+
+// S3 is a bucket in AWS S3 or a compatible service like minio
+type S3 struct {
+	svc    *s3x.Service
+	bucket string
+}
+
+// NewS3 creates a new S3 storage for the given bucket
+func NewS3(svc *s3x.Service, bucket string) *S3 {
+	return &S3{svc: svc, bucket: bucket}
+}
+
+func (s *S3) Name() string { return fmt.Sprintf("s3:%s", s.bucket) }
+
+func (s *S3) Test(ctx context.Context) error {
+	return s.svc.Test(ctx, s.bucket)
+}
+
+func (s *S3) Get(ctx context.Context, path string) (string, []byte, error) {
+	contentType, data, err := s.svc.GetObject(ctx, s.bucket, path)
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", nil, ErrNotFound
+		}
+		return "", nil, err
+	}
+	return contentType, data, nil
+}
+
+func (s *S3) Put(ctx context.Context, path, contentType string, data []byte) (string, error) {
+	return s.svc.PutObject(ctx, s.bucket, path, contentType, data, s3types.ObjectCannedACLPublicRead)
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when trying to get an object which doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a bucket or container of objects in a cloud storage service, which is where attachments are written to
+// and deactivation files are read from
+type Storage interface {
+	// Name is a description of the storage for use in logs
+	Name() string
+
+	// Test checks that the storage exists and is accessible
+	Test(ctx context.Context) error
+
+	// Get fetches the object at the given path, returning its content type and data
+	Get(ctx context.Context, path string) (string, []byte, error)
+
+	// Put writes the object at the given path, returning its public URL
+	Put(ctx context.Context, path, contentType string, data []byte) (string, error)
+}
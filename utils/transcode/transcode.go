@@ -0,0 +1,76 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// format describes how ffmpeg encodes audio of a content type that we can transcode to
+type format struct {
+	extension string
+	args      []string // codec and container args for ffmpeg
+}
+
+// content types we can transcode audio to, which have to be containers ffmpeg can write to a pipe
+var formats = map[string]format{
+	"audio/ogg":  {extension: "ogg", args: []string{"-c:a", "libopus", "-b:a", "32k", "-f", "ogg"}}, // voice notes on WhatsApp and Telegram
+	"audio/mpeg": {extension: "mp3", args: []string{"-c:a", "libmp3lame", "-q:a", "4", "-f", "mp3"}},
+	"audio/aac":  {extension: "aac", args: []string{"-c:a", "aac", "-b:a", "64k", "-f", "adts"}},
+	"audio/amr":  {extension: "amr", args: []string{"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-f", "amr"}},
+}
+
+// Transcoder converts audio to other codecs by shelling out to ffmpeg
+type Transcoder struct {
+	ffmpeg  string
+	timeout time.Duration
+}
+
+// NewTranscoder creates a new transcoder which uses the ffmpeg binary at the given path
+func NewTranscoder(ffmpeg string, timeout time.Duration) *Transcoder {
+	return &Transcoder{ffmpeg: ffmpeg, timeout: timeout}
+}
+
+// Supports returns whether we can transcode audio to the given content type
+func Supports(contentType string) bool {
+	_, found := formats[contentType]
+	return found
+}
+
+// Extension returns the file extension for audio of the given content type, or empty string if it's not supported
+func Extension(contentType string) string {
+	return formats[contentType].extension
+}
+
+// Transcode converts the given audio data, which can be any format ffmpeg can read, to the given content type
+func (t *Transcoder) Transcode(ctx context.Context, data []byte, contentType string) ([]byte, error) {
+	f, found := formats[contentType]
+	if !found {
+		return nil, fmt.Errorf("unsupported content type for transcoding: %s", contentType)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	// read from stdin and write to stdout, dropping any video streams like embedded cover art
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-vn"}, f.args...)
+	args = append(args, "pipe:1")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.ffmpeg, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error transcoding audio to %s: %w: %s", contentType, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("error transcoding audio to %s: no output", contentType)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package transcode_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils/transcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writes a fake ffmpeg which runs the given shell script
+func fakeFFmpeg(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestTranscode(t *testing.T) {
+	ctx := context.Background()
+
+	assert.True(t, transcode.Supports("audio/ogg"))
+	assert.False(t, transcode.Supports("audio/wav"))
+	assert.Equal(t, "ogg", transcode.Extension("audio/ogg"))
+	assert.Equal(t, "mp3", transcode.Extension("audio/mpeg"))
+	assert.Equal(t, "", transcode.Extension("audio/wav"))
+
+	// ffmpeg args are "... -f <format> pipe:1" so the format is the second last arg
+	tc := transcode.NewTranscoder(fakeFFmpeg(t, `eval "format=\${$(($#-1))}"; printf "%s:" "$format"; cat`), time.Second)
+
+	out, err := tc.Transcode(ctx, []byte("AUDIO"), "audio/ogg")
+	assert.NoError(t, err)
+	assert.Equal(t, "ogg:AUDIO", string(out))
+
+	out, err = tc.Transcode(ctx, []byte("AUDIO"), "audio/mpeg")
+	assert.NoError(t, err)
+	assert.Equal(t, "mp3:AUDIO", string(out))
+
+	_, err = tc.Transcode(ctx, []byte("AUDIO"), "audio/wav")
+	assert.EqualError(t, err, "unsupported content type for transcoding: audio/wav")
+
+	// ffmpeg failing to read the input
+	tc = transcode.NewTranscoder(fakeFFmpeg(t, `echo "pipe:0: Invalid data found when processing input" >&2; exit 1`), time.Second)
+
+	_, err = tc.Transcode(ctx, []byte("AUDIO"), "audio/ogg")
+	assert.EqualError(t, err, "error transcoding audio to audio/ogg: exit status 1: pipe:0: Invalid data found when processing input")
+
+	// ffmpeg producing no output
+	tc = transcode.NewTranscoder(fakeFFmpeg(t, `exit 0`), time.Second)
+
+	_, err = tc.Transcode(ctx, []byte("AUDIO"), "audio/ogg")
+	assert.EqualError(t, err, "error transcoding audio to audio/ogg: no output")
+
+	// ffmpeg not being installed
+	tc = transcode.NewTranscoder("/does/not/exist/ffmpeg", time.Second)
+
+	_, err = tc.Transcode(ctx, []byte("AUDIO"), "audio/ogg")
+	assert.ErrorContains(t, err, "error transcoding audio to audio/ogg: fork/exec /does/not/exist/ffmpeg")
+}
@@ -0,0 +1,335 @@
+package valkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+const (
+	maxRedirects  = 3
+	tryAgainDelay = 100 * time.Millisecond
+)
+
+// commands which don't have a key and can be sent to any node
+var keylessCommands = map[string]bool{
+	"ASKING": true, "AUTH": true, "CLUSTER": true, "DBSIZE": true, "DISCARD": true, "ECHO": true, "EXEC": true,
+	"FLUSHALL": true, "FLUSHDB": true, "INFO": true, "MULTI": true, "PING": true, "SCRIPT": true, "TIME": true,
+}
+
+// ClusterPool is a pool of connections to the nodes of a cluster. Connections from it route each command to the node
+// which serves the slot of the command's key, following redirects when slots have moved.
+type ClusterPool struct {
+	cluster *redisc.Cluster
+}
+
+// NewClusterPool creates a new cluster pool using the node at the given URL to discover the others
+func NewClusterPool(redisURL string, maxActive int) (*ClusterPool, error) {
+	parsedURL, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []redis.DialOption{redis.DialConnectTimeout(5 * time.Second)}
+
+	// send auth if required, note that clusters don't support selecting a database
+	if parsedURL.User != nil {
+		if pass, authRequired := parsedURL.User.Password(); authRequired {
+			opts = append(opts, redis.DialPassword(pass))
+		}
+	}
+
+	return newClusterPool([]string{parsedURL.Host}, maxActive, opts)
+}
+
+func newClusterPool(seeds []string, maxActive int, opts []redis.DialOption) (*ClusterPool, error) {
+	cluster := &redisc.Cluster{
+		StartupNodes: seeds,
+		DialOptions:  opts,
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			return &redis.Pool{
+				MaxActive:   maxActive,
+				MaxIdle:     4,
+				IdleTimeout: 180 * time.Second,
+				Wait:        true,
+				Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", addr, opts...) },
+			}, nil
+		},
+		BgError: func(src redisc.BgErrorSrc, err error) {
+			slog.Error("error in valkey cluster background task", "comp", "valkey", "source", src, "error", err)
+		},
+	}
+
+	if err := cluster.Refresh(); err != nil {
+		cluster.Close()
+		return nil, fmt.Errorf("error reading cluster slots: %w", err)
+	}
+	return &ClusterPool{cluster: cluster}, nil
+}
+
+// Get gets a connection which routes commands to the appropriate nodes
+func (p *ClusterPool) Get() redis.Conn {
+	return &clusterConn{cluster: p.cluster}
+}
+
+// GetContext gets a connection which routes commands to the appropriate nodes, provided the context isn't done
+func (p *ClusterPool) GetContext(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &clusterConn{cluster: p.cluster}, nil
+}
+
+// Stats returns the combined stats of the pools of all nodes
+func (p *ClusterPool) Stats() redis.PoolStats {
+	stats := redis.PoolStats{}
+	for _, s := range p.cluster.Stats() {
+		stats.ActiveCount += s.ActiveCount
+		stats.IdleCount += s.IdleCount
+		stats.WaitCount += s.WaitCount
+		stats.WaitDuration += s.WaitDuration
+	}
+	return stats
+}
+
+// Close closes the pools of all nodes
+func (p *ClusterPool) Close() error {
+	return p.cluster.Close()
+}
+
+type command struct {
+	name string
+	args []any
+}
+
+// clusterConn is a connection which routes each command to the node serving the slot of its key. Pipelined commands
+// and transactions are sent together to a single node so all their keys must be in the same slot.
+type clusterConn struct {
+	cluster *redisc.Cluster
+
+	pending    []command  // commands sent but not yet flushed
+	conn       redis.Conn // connection bound to the node of the current pipeline
+	unreceived int        // number of replies of the current pipeline yet to be received
+	closed     bool
+}
+
+func (c *clusterConn) Do(cmd string, args ...any) (any, error) {
+	if c.closed {
+		return nil, errors.New("valkey: connection closed")
+	}
+
+	if len(c.pending) > 0 || c.conn != nil {
+		return c.doPipelined(cmd, args)
+	}
+	if cmd == "" {
+		return nil, nil
+	}
+
+	// redisc would route scripts by their SHA rather than their first key so bind to the right node ourselves
+	conn := c.cluster.Get()
+	defer conn.Close()
+
+	if err := checkScriptKeys(cmd, args); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if key, hasKey := commandKey(cmd, args); hasKey {
+		keys = append(keys, key)
+	}
+	if err := redisc.BindConn(conn, keys...); err != nil {
+		return nil, err
+	}
+
+	retrying, err := redisc.RetryConn(conn, maxRedirects+1, tryAgainDelay)
+	if err != nil {
+		return nil, err
+	}
+	return retrying.Do(cmd, args...)
+}
+
+// sends all pending commands and the given command and returns the last reply and first error like redigo does
+func (c *clusterConn) doPipelined(cmd string, args []any) (any, error) {
+	if cmd != "" {
+		c.Send(cmd, args...)
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+
+	var reply any
+	var firstErr error
+	for c.unreceived > 0 {
+		r, err := c.Receive()
+		if err != nil {
+			if _, isReplyErr := err.(redis.Error); !isReplyErr {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		reply = r
+	}
+	return reply, firstErr
+}
+
+func (c *clusterConn) Send(cmd string, args ...any) error {
+	if c.closed {
+		return errors.New("valkey: connection closed")
+	}
+
+	c.pending = append(c.pending, command{name: cmd, args: args})
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	if len(c.pending) == 0 {
+		if c.conn != nil {
+			return c.conn.Flush()
+		}
+		return nil
+	}
+
+	if c.conn == nil {
+		var keys []string
+		for _, p := range c.pending {
+			if err := checkScriptKeys(p.name, p.args); err != nil {
+				c.pending = nil
+				return err
+			}
+			if key, hasKey := commandKey(p.name, p.args); hasKey {
+				if len(keys) > 0 && redisc.Slot(key) != redisc.Slot(keys[0]) {
+					c.pending = nil
+					return fmt.Errorf("valkey: pipelined keys %s and %s are in different slots", keys[0], key)
+				}
+				keys = append(keys, key)
+			}
+		}
+
+		conn := c.cluster.Get()
+		if err := redisc.BindConn(conn, keys...); err != nil {
+			conn.Close()
+			c.pending = nil
+			return err
+		}
+		c.conn = conn
+	}
+
+	for _, p := range c.pending {
+		if err := c.conn.Send(p.name, p.args...); err != nil {
+			c.pending = nil
+			return err
+		}
+		c.unreceived++
+	}
+	c.pending = nil
+
+	return c.conn.Flush()
+}
+
+func (c *clusterConn) Receive() (any, error) {
+	if c.conn == nil || c.unreceived == 0 {
+		return nil, errors.New("valkey: no pending replies")
+	}
+
+	// if a slot has moved, the commands will have to be retried but redisc records where so the retry goes to the right
+	// node
+	reply, err := c.conn.Receive()
+	c.unreceived--
+
+	// the pipeline is done so the node connection can go back to its pool
+	if c.unreceived == 0 {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *clusterConn) Err() error {
+	if c.closed {
+		return errors.New("valkey: connection closed")
+	}
+	return nil
+}
+
+func (c *clusterConn) Close() error {
+	c.closed = true
+	c.pending = nil
+
+	if c.conn != nil {
+		err := c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// commandKey returns the key of the given command which determines which node it's sent to
+func commandKey(cmd string, args []any) (string, bool) {
+	cmd = strings.ToUpper(cmd)
+
+	if keylessCommands[cmd] {
+		return "", false
+	}
+
+	// scripts are routed by their first key
+	if isScriptCommand(cmd) {
+		if len(args) < 3 {
+			return "", false
+		}
+		if numKeys, err := strconv.Atoi(argString(args[1])); err != nil || numKeys < 1 {
+			return "", false
+		}
+		return argString(args[2]), true
+	}
+
+	if len(args) == 0 {
+		return "", false
+	}
+	return argString(args[0]), true
+}
+
+// checkScriptKeys checks that all the keys of the given command, if it's a script, are in the same slot because scripts
+// are run on the node of their first key
+func checkScriptKeys(cmd string, args []any) error {
+	if !isScriptCommand(strings.ToUpper(cmd)) || len(args) < 2 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(argString(args[1]))
+	if err != nil || numKeys < 2 || len(args) < numKeys+2 {
+		return nil
+	}
+
+	first := argString(args[2])
+	for _, arg := range args[3 : numKeys+2] {
+		if key := argString(arg); redisc.Slot(key) != redisc.Slot(first) {
+			return fmt.Errorf("valkey: script keys %s and %s are in different slots", first, key)
+		}
+	}
+	return nil
+}
+
+func isScriptCommand(cmd string) bool {
+	return cmd == "EVAL" || cmd == "EVALSHA" || cmd == "EVAL_RO" || cmd == "EVALSHA_RO" || cmd == "FCALL" || cmd == "FCALL_RO"
+}
+
+func argString(arg any) string {
+	switch a := arg.(type) {
+	case string:
+		return a
+	case []byte:
+		return string(a)
+	default:
+		return fmt.Sprint(a)
+	}
+}
@@ -0,0 +1,351 @@
+package valkey
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type simpleString string
+type errorReply string
+
+// fakeCluster is a minimal cluster of nodes which speak enough RESP to test routing
+type fakeCluster struct {
+	mutex     sync.Mutex
+	nodes     []*fakeNode
+	owners    [redisc.HashSlots]int // index of the node which owns each slot
+	redirects int                   // number of MOVED replies sent
+}
+
+type fakeNode struct {
+	cluster *fakeCluster
+	addr    string
+	data    map[string]string
+}
+
+// starts a cluster of the given number of nodes with slots divided evenly between them
+func startFakeCluster(t *testing.T, numNodes int) *fakeCluster {
+	c := &fakeCluster{}
+
+	for i := 0; i < numNodes; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+
+		node := &fakeNode{cluster: c, addr: ln.Addr().String(), data: make(map[string]string)}
+		c.nodes = append(c.nodes, node)
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go node.serve(conn)
+			}
+		}()
+	}
+
+	for s := 0; s < redisc.HashSlots; s++ {
+		c.owners[s] = s * numNodes / redisc.HashSlots
+	}
+	return c
+}
+
+// moves the slot of the given key to the given node, taking its value with it
+func (c *fakeCluster) move(key string, to int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	from := c.nodes[c.owners[redisc.Slot(key)]]
+	if v, exists := from.data[key]; exists {
+		c.nodes[to].data[key] = v
+		delete(from.data, key)
+	}
+	c.owners[redisc.Slot(key)] = to
+}
+
+func (c *fakeCluster) numRedirects() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.redirects
+}
+
+func (c *fakeCluster) get(node int, key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v, exists := c.nodes[node].data[key]
+	return v, exists
+}
+
+func (n *fakeNode) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queued [][]string
+	inMulti := false
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		var reply any
+
+		switch {
+		case cmd == "MULTI":
+			inMulti, queued, reply = true, nil, simpleString("OK")
+		case cmd == "EXEC":
+			results := make([]any, len(queued))
+			for i, q := range queued {
+				results[i] = n.exec(q)
+			}
+			inMulti, queued, reply = false, nil, results
+		case inMulti:
+			queued, reply = append(queued, args), simpleString("QUEUED")
+		default:
+			reply = n.exec(args)
+		}
+
+		writeReply(w, reply)
+		w.Flush()
+	}
+}
+
+func (n *fakeNode) exec(args []string) any {
+	n.cluster.mutex.Lock()
+	defer n.cluster.mutex.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+
+	switch cmd {
+	case "PING":
+		return simpleString("PONG")
+	case "CLUSTER":
+		ranges := []any{}
+		for s := 0; s < redisc.HashSlots; {
+			e := s
+			for e+1 < redisc.HashSlots && n.cluster.owners[e+1] == n.cluster.owners[s] {
+				e++
+			}
+			host, port, _ := net.SplitHostPort(n.cluster.nodes[n.cluster.owners[s]].addr)
+			p, _ := strconv.Atoi(port)
+			ranges = append(ranges, []any{int64(s), int64(e), []any{[]byte(host), int64(p)}})
+			s = e + 1
+		}
+		return ranges
+	}
+
+	// all other commands are keyed so check we own the slot
+	slot := redisc.Slot(args[1])
+	if owner := n.cluster.nodes[n.cluster.owners[slot]]; owner != n {
+		n.cluster.redirects++
+		return errorReply(fmt.Sprintf("MOVED %d %s", slot, owner.addr))
+	}
+
+	switch cmd {
+	case "GET":
+		if v, exists := n.data[args[1]]; exists {
+			return []byte(v)
+		}
+		return nil
+	case "SET":
+		n.data[args[1]] = args[2]
+		return simpleString("OK")
+	case "DEL":
+		_, exists := n.data[args[1]]
+		delete(n.data, args[1])
+		if exists {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return errorReply("ERR unknown command")
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	num, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, num)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch r := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case simpleString:
+		w.WriteString("+" + string(r) + "\r\n")
+	case errorReply:
+		w.WriteString("-" + string(r) + "\r\n")
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", r)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r), r)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(r))
+		for _, v := range r {
+			writeReply(w, v)
+		}
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	tcs := []struct {
+		cmd    string
+		args   []any
+		key    string
+		hasKey bool
+	}{
+		{"GET", []any{"foo"}, "foo", true},
+		{"zadd", []any{[]byte("bar"), 1, "x"}, "bar", true},
+		{"PING", nil, "", false},
+		{"MULTI", nil, "", false},
+		{"EVALSHA", []any{"abc123", 1, "{msgs}:active", "a"}, "{msgs}:active", true},
+		{"EVAL", []any{"return 1", 0}, "", false},
+		{"INCR", nil, "", false},
+	}
+
+	for _, tc := range tcs {
+		key, hasKey := commandKey(tc.cmd, tc.args)
+		assert.Equal(t, tc.key, key, "key mismatch for %s", tc.cmd)
+		assert.Equal(t, tc.hasKey, hasKey, "has key mismatch for %s", tc.cmd)
+	}
+}
+
+func TestCheckScriptKeys(t *testing.T) {
+	assert.NoError(t, checkScriptKeys("GET", []any{"foo"}))
+	assert.NoError(t, checkScriptKeys("EVALSHA", []any{"abc123", 1, "foo", "a"}))
+	assert.NoError(t, checkScriptKeys("EVALSHA", []any{"abc123", 2, "{msgs:1}|10/1", "{msgs:1}|10/0", "a"}))
+	assert.EqualError(t, checkScriptKeys("evalsha", []any{"abc123", 2, "{msgs:1}|10/1", "{msgs}:active", "a"}), "valkey: script keys {msgs:1}|10/1 and {msgs}:active are in different slots")
+}
+
+func TestTag(t *testing.T) {
+	defer SetCluster(false)
+
+	assert.False(t, IsCluster())
+	assert.Equal(t, "msgs", Tag("msgs"))
+	assert.Equal(t, "rate_limit:1234", WithTag("msgs", "rate_limit:1234"))
+
+	SetCluster(true)
+
+	assert.True(t, IsCluster())
+	assert.Equal(t, "{msgs}", Tag("msgs"))
+	assert.Equal(t, "{msgs}:rate_limit:1234", WithTag("msgs", "rate_limit:1234"))
+}
+
+func TestClusterPool(t *testing.T) {
+	cluster := startFakeCluster(t, 2)
+
+	_, err := NewClusterPool("redis://127.0.0.1:1", 5)
+	assert.Error(t, err)
+
+	rp, err := NewPool("redis://"+cluster.nodes[1].addr, true, 5)
+	require.NoError(t, err)
+	defer rp.Close()
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	assert.Equal(t, "PONG", must(redis.String(rc.Do("PING"))))
+
+	// foo is in a slot on the second node, bar on the first
+	assert.Equal(t, "OK", must(redis.String(rc.Do("SET", "foo", "1"))))
+	assert.Equal(t, "OK", must(redis.String(rc.Do("SET", "bar", "2"))))
+	assert.Equal(t, "1", must(redis.String(rc.Do("GET", "foo"))))
+	assert.Equal(t, "2", must(redis.String(rc.Do("GET", "bar"))))
+
+	v, exists := cluster.get(1, "foo")
+	assert.True(t, exists)
+	assert.Equal(t, "1", v)
+	v, exists = cluster.get(0, "bar")
+	assert.True(t, exists)
+	assert.Equal(t, "2", v)
+
+	// move foo's slot to the first node, we should follow the redirect and remember it
+	cluster.move("foo", 0)
+
+	assert.Equal(t, "1", must(redis.String(rc.Do("GET", "foo"))))
+	assert.Equal(t, 1, cluster.numRedirects())
+	assert.Equal(t, "1", must(redis.String(rc.Do("GET", "foo"))))
+	assert.Equal(t, 1, cluster.numRedirects())
+
+	// pipelined transactions go to the node of their keys
+	rc.Send("MULTI")
+	rc.Send("SET", "{t}:a", "x")
+	rc.Send("SET", "{t}:b", "y")
+	replies, err := redis.Strings(rc.Do("EXEC"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"OK", "OK"}, replies)
+
+	tNode := redisc.Slot("t") * 2 / redisc.HashSlots
+	v, _ = cluster.get(tNode, "{t}:a")
+	assert.Equal(t, "x", v)
+	v, _ = cluster.get(tNode, "{t}:b")
+	assert.Equal(t, "y", v)
+
+	// and so do manually received pipelines
+	rc.Send("GET", "{t}:a")
+	rc.Send("GET", "{t}:b")
+	assert.NoError(t, rc.Flush())
+	assert.Equal(t, "x", must(redis.String(rc.Receive())))
+	assert.Equal(t, "y", must(redis.String(rc.Receive())))
+	_, err = rc.Receive()
+	assert.EqualError(t, err, "valkey: no pending replies")
+
+	// but pipelines can't span slots
+	rc.Send("GET", "{t}:a")
+	rc.Send("GET", "foo")
+	assert.EqualError(t, rc.Flush(), "valkey: pipelined keys {t}:a and foo are in different slots")
+	_, err = rc.Receive()
+	assert.EqualError(t, err, "valkey: no pending replies")
+
+	assert.Equal(t, 1, must(redis.Int(rc.Do("DEL", "bar"))))
+	assert.Equal(t, 0, must(redis.Int(rc.Do("DEL", "bar"))))
+
+	// all node connections should have been returned to their pools
+	stats := rp.Stats()
+	assert.Greater(t, stats.ActiveCount, 0)
+	assert.Equal(t, stats.ActiveCount, stats.IdleCount)
+
+	rc.Close()
+	_, err = rc.Do("GET", "foo")
+	assert.EqualError(t, err, "valkey: connection closed")
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package valkey
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/redisx"
+)
+
+// Pool is a pool of connections to a Valkey or Redis server, or to a cluster of them
+type Pool interface {
+	Get() redis.Conn
+	GetContext(context.Context) (redis.Conn, error)
+	Stats() redis.PoolStats
+	Close() error
+}
+
+var _ Pool = (*redis.Pool)(nil)
+var _ Pool = (*ClusterPool)(nil)
+
+// NewPool creates a new pool for the given URL with the given maximum number of active connections. If cluster is
+// true then the URL can be that of any node in the cluster and the others are discovered from it.
+func NewPool(url string, cluster bool, maxActive int) (Pool, error) {
+	if cluster {
+		return NewClusterPool(url, maxActive)
+	}
+
+	rp, err := redisx.NewPool(url, redisx.WithMaxActive(maxActive))
+	if err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Ping checks that a connection can be got from the given pool and the server responds
+func Ping(ctx context.Context, rp Pool) error {
+	rc, err := rp.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = rc.Do("PING")
+	return err
+}
+
+var clusterMode atomic.Bool
+
+// SetCluster sets whether we're using a cluster, which changes how keys are named so that keys which are used
+// together by scripts or transactions are stored in the same slot. This must be set before any keys are named.
+func SetCluster(enabled bool) {
+	clusterMode.Store(enabled)
+}
+
+// IsCluster returns whether we're using a cluster
+func IsCluster() bool {
+	return clusterMode.Load()
+}
+
+// Tag returns the given key base, e.g. msgs, as a hash tag if we're using a cluster, e.g. {msgs}, so that all keys
+// derived from it, e.g. {msgs}:active, are stored in the same slot
+func Tag(base string) string {
+	if clusterMode.Load() {
+		return "{" + base + "}"
+	}
+	return base
+}
+
+// WithTag returns the given key prefixed by the given hash tag if we're using a cluster, e.g. {msgs}:rate_limit:1234,
+// so that it's stored in the same slot as other keys with that tag
+func WithTag(tag, key string) string {
+	if clusterMode.Load() {
+		return "{" + tag + "}:" + key
+	}
+	return key
+}